@@ -49,7 +49,36 @@ function ConnectionStatus({ status }: ConnectionStatusProps) {
         />
       </Box>
 
-      {connected && (
+      {connected && status.tunnels && status.tunnels.length > 1 && (
+        <Grid container spacing={2}>
+          {status.tunnels.map((tunnel) => (
+            <Grid item xs={12} md={6} key={tunnel.tunnel_id || tunnel.name}>
+              <Card variant="outlined">
+                <CardContent>
+                  <Box sx={{ display: 'flex', alignItems: 'center', mb: 1 }}>
+                    <Typography variant="subtitle2" sx={{ flexGrow: 1 }}>
+                      {tunnel.name || tunnel.tunnel_id}
+                    </Typography>
+                    <Chip
+                      label={tunnel.connected ? 'Connected' : 'Disconnected'}
+                      color={tunnel.connected ? 'success' : 'error'}
+                      size="small"
+                    />
+                  </Box>
+                  <Typography variant="body2" sx={{ fontFamily: 'monospace' }}>
+                    {tunnel.public_url || 'N/A'}
+                  </Typography>
+                  <Typography variant="body2" color="text.secondary" sx={{ fontFamily: 'monospace' }}>
+                    {tunnel.protocol?.toUpperCase()} → {tunnel.local_addr || 'N/A'}
+                  </Typography>
+                </CardContent>
+              </Card>
+            </Grid>
+          ))}
+        </Grid>
+      )}
+
+      {connected && !(status.tunnels && status.tunnels.length > 1) && (
         <Grid container spacing={2}>
           {/* Public URL */}
           <Grid item xs={12} md={6}>
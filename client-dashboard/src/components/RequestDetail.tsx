@@ -266,6 +266,12 @@ function TimingInfo({ request }: { request: RequestLog }) {
           <TableCell sx={{ fontWeight: 'bold' }}>Remote Address</TableCell>
           <TableCell>{request.remote_addr}</TableCell>
         </TableRow>
+        {request.upstream && (
+          <TableRow>
+            <TableCell sx={{ fontWeight: 'bold' }}>Upstream</TableCell>
+            <TableCell sx={{ fontFamily: 'monospace' }}>{request.upstream}</TableCell>
+          </TableRow>
+        )}
         <TableRow>
           <TableCell sx={{ fontWeight: 'bold' }}>Request ID</TableCell>
           <TableCell sx={{ fontFamily: 'monospace', fontSize: '0.75rem' }}>{request.id}</TableCell>
@@ -192,9 +192,26 @@ function RequestLog() {
                     <Chip label={req.method} size="small" color="primary" variant="outlined" />
                   </TableCell>
                   <TableCell>
-                    <Typography variant="body2" sx={{ fontFamily: 'monospace', fontSize: '0.875rem' }}>
+                    {req.tunnel && (
+                      <Chip label={req.tunnel} size="small" variant="outlined" sx={{ mr: 1 }} />
+                    )}
+                    <Typography
+                      variant="body2"
+                      component="span"
+                      sx={{ fontFamily: 'monospace', fontSize: '0.875rem' }}
+                    >
                       {req.path.length > 50 ? req.path.substring(0, 50) + '...' : req.path}
                     </Typography>
+                    {req.upstream && (
+                      <Typography
+                        variant="caption"
+                        component="span"
+                        color="text.secondary"
+                        sx={{ ml: 1, fontFamily: 'monospace' }}
+                      >
+                        → {req.upstream}
+                      </Typography>
+                    )}
                   </TableCell>
                   <TableCell>
                     <Chip
@@ -1,12 +1,14 @@
 // TunnelStatus represents the current tunnel connection status
 export interface TunnelStatus {
   connected: boolean;
+  name?: string;
   tunnel_id?: string;
   public_url?: string;
   local_addr?: string;
   protocol?: string;
   uptime_seconds: number;
   connected_at?: string;
+  tunnels?: TunnelStatus[]; // Set when several tunnels share the dashboard
 }
 
 // RequestLog represents a single HTTP/TCP request
@@ -16,6 +18,8 @@ export interface RequestLog {
   method: string;
   path: string;
   protocol: string;
+  tunnel?: string;
+  upstream?: string; // Local address that served the request
   remote_addr: string;
   status_code: number;
   bytes_in: number;
@@ -60,6 +64,8 @@ export interface RequestStartedData {
   path: string;
   remote_addr: string;
   protocol: string;
+  tunnel?: string;
+  upstream?: string;
   headers?: Record<string, string>;
 }
 
@@ -2,11 +2,15 @@ package cli
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -20,17 +24,24 @@ import (
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
 	"github.com/pandeptwidyaop/grok/internal/server/config"
+	"github.com/pandeptwidyaop/grok/internal/server/dns"
+	"github.com/pandeptwidyaop/grok/internal/server/domains"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
 	grpcserver "github.com/pandeptwidyaop/grok/internal/server/grpc"
 	"github.com/pandeptwidyaop/grok/internal/server/grpc/interceptors"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
 	"github.com/pandeptwidyaop/grok/internal/server/proxy"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
 	tlsmanager "github.com/pandeptwidyaop/grok/internal/server/tls"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	"github.com/pandeptwidyaop/grok/internal/server/web"
 	"github.com/pandeptwidyaop/grok/internal/server/web/api"
 	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
 )
 
@@ -104,18 +115,195 @@ func initAdminUser(database *gorm.DB, cfg *config.Config) error {
 	return nil
 }
 
-// setupTLS initializes TLS manager if configured.
-func setupTLS(cfg *config.Config) (*tlsmanager.Manager, error) {
+// setupDNS starts the authoritative DNS server for the server domain if enabled.
+func setupDNS(cfg *config.Config, domainService *domains.Service) (*dns.Server, error) {
+	if !cfg.DNS.Enabled {
+		return nil, nil
+	}
+
+	dnsServer, err := dns.NewServer(dns.Config{
+		Addr:        cfg.DNS.Addr,
+		Domain:      cfg.Server.Domain,
+		Addresses:   cfg.DNS.Addresses,
+		Nameservers: cfg.DNS.Nameservers,
+		Hostmaster:  cfg.DNS.Hostmaster,
+		TTL:         cfg.DNS.TTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	dnsServer.SetCustomDomains(domainService)
+
+	if err := dnsServer.Start(); err != nil {
+		return nil, err
+	}
+	return dnsServer, nil
+}
+
+// setupIPFilter creates the filter enforcing IP rules, with the rules of
+// organizations loaded from the database.
+func setupIPFilter(cfg *config.Config, database *gorm.DB) (*ipfilter.Filter, error) {
+	var geo *ipfilter.GeoDB
+	if cfg.GeoIP.Database != "" {
+		var err error
+		if geo, err = ipfilter.OpenGeoDB(cfg.GeoIP.Database); err != nil {
+			return nil, err
+		}
+		logger.InfoEvent().Str("database", cfg.GeoIP.Database).Msg("GeoIP database loaded for country rules")
+	}
+
+	filter := ipfilter.NewFilter(geo)
+	if err := filter.Load(context.Background(), database); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// setupRateLimiter creates the limiter enforcing the configured rate limits
+// and monthly transfer quotas, or nil when none are configured.
+func setupRateLimiter(cfg *config.Config, database *gorm.DB) *ratelimit.Limiter {
+	if cfg.Limits == (config.LimitsConfig{}) {
+		return nil
+	}
+
+	limits := cfg.Limits
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		Tunnel:               rateOf(limits.Tunnel),
+		User:                 rateOf(limits.User),
+		Organization:         rateOf(limits.Organization),
+		UserTransfer:         limits.MonthlyTransfer.User,
+		OrganizationTransfer: limits.MonthlyTransfer.Organization,
+	}, database)
+	logger.InfoEvent().Msg("Edge rate limits enabled")
+	return limiter
+}
+
+func rateOf(cfg config.RateLimitConfig) ratelimit.Rate {
+	return ratelimit.Rate{
+		Requests:     cfg.RequestsPerSecond,
+		RequestBurst: cfg.RequestBurst,
+		Bytes:        cfg.BytesPerSecond,
+		ByteBurst:    cfg.ByteBurst,
+	}
+}
+
+// setupEdgeCache creates the cache for responses of HTTP tunnels, or nil
+// when no capacity is configured.
+func setupEdgeCache(cfg *config.Config) (*edgecache.Cache, error) {
+	if cfg.Cache.MaxMemory <= 0 && cfg.Cache.MaxDisk <= 0 {
+		return nil, nil
+	}
+	if cfg.Cache.MaxDisk > 0 && cfg.Cache.Dir == "" {
+		return nil, fmt.Errorf("cache.dir is required with cache.max_disk")
+	}
+
+	cache, err := edgecache.New(edgecache.Config{
+		MaxMemory:     cfg.Cache.MaxMemory,
+		MaxObjectSize: cfg.Cache.MaxObjectSize,
+		Dir:           cfg.Cache.Dir,
+		MaxDisk:       cfg.Cache.MaxDisk,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.InfoEvent().
+		Int64("max_memory", cfg.Cache.MaxMemory).
+		Int64("max_disk", cfg.Cache.MaxDisk).
+		Msg("Edge cache enabled")
+	return cache, nil
+}
+
+// setupProxyProtocol returns the networks of the load balancers whose
+// connections start with a PROXY header, or nil when it is disabled.
+func setupProxyProtocol(cfg *config.Config) ([]netip.Prefix, error) {
+	if !cfg.Server.ProxyProtocol.Enabled {
+		return nil, nil
+	}
+	if len(cfg.Server.ProxyProtocol.TrustedCIDRs) == 0 {
+		return nil, fmt.Errorf("server.proxy_protocol.trusted_cidrs is required with server.proxy_protocol.enabled")
+	}
+
+	trusted, err := proxyproto.ParseTrusted(cfg.Server.ProxyProtocol.TrustedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("server.proxy_protocol.trusted_cidrs: %w", err)
+	}
+	logger.InfoEvent().
+		Strs("trusted_cidrs", cfg.Server.ProxyProtocol.TrustedCIDRs).
+		Msg("PROXY protocol enabled on public listeners")
+	return trusted, nil
+}
+
+// setupAccess creates the guard enforcing the access policies of HTTP tunnels.
+func setupAccess(cfg *config.Config, tlsEnabled bool) (*access.Guard, error) {
+	// Share links and sessions get their own key, derived from the JWT secret
+	mac := hmac.New(sha256.New, []byte(cfg.Auth.JWTSecret))
+	mac.Write([]byte("grok tunnel access"))
+
+	guardCfg := access.Config{
+		Secret:          mac.Sum(nil),
+		SessionDuration: cfg.Access.SessionDuration,
+		MaxShareLinkTTL: cfg.Access.MaxShareLinkTTL,
+	}
+
+	if oidc := cfg.Access.OIDC; oidc.Issuer != "" {
+		redirectURL := oidc.RedirectURL
+		if redirectURL == "" {
+			scheme, port, defaultPort := "http", cfg.Server.HTTPPort, 80
+			if tlsEnabled {
+				scheme, port, defaultPort = "https", cfg.Server.HTTPSPort, 443
+			}
+			host := cfg.Server.Domain
+			if port != defaultPort {
+				host = net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			redirectURL = scheme + "://" + host + access.CallbackPath
+		}
+
+		guardCfg.OIDC = &access.OIDCConfig{
+			Issuer:       oidc.Issuer,
+			ClientID:     oidc.ClientID,
+			ClientSecret: oidc.ClientSecret,
+			Scopes:       oidc.Scopes,
+			RedirectURL:  redirectURL,
+
+			AllowMissingEmailVerified: oidc.AllowMissingEmailVerified,
+		}
+		logger.InfoEvent().
+			Str("issuer", oidc.Issuer).
+			Str("redirect_url", redirectURL).
+			Msg("OIDC login enabled for tunnel access policies")
+	}
+
+	return access.NewGuard(guardCfg)
+}
+
+// setupTLS initializes TLS manager if configured. Certificates are issued
+// for verified custom domains as well as the server domain.
+func setupTLS(cfg *config.Config, domainService *domains.Service, dnsServer *dns.Server) (*tlsmanager.Manager, error) {
 	if !cfg.TLS.AutoCert && (cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "") {
 		return nil, nil
 	}
 
+	dnsProvider, err := newDNSProvider(cfg, dnsServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+
 	tlsMgr, err := tlsmanager.NewManager(tlsmanager.Config{
 		AutoCert: cfg.TLS.AutoCert,
 		CertDir:  cfg.TLS.CertDir,
 		Domain:   cfg.Server.Domain,
 		CertFile: cfg.TLS.CertFile,
 		KeyFile:  cfg.TLS.KeyFile,
+		Email:    cfg.TLS.Email,
+
+		MaxCertsPerHour: cfg.TLS.MaxCertsPerHour,
+		ACMEDirectory:   cfg.TLS.ACMEDirectory,
+		ACMECAFile:      cfg.TLS.ACMECAFile,
+		CustomDomains:   domainService.HostPolicy,
+
+		DNSProvider:         dnsProvider,
+		DNSPropagationDelay: cfg.TLS.DNSPropagationDelay,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup TLS: %w", err)
@@ -125,12 +313,48 @@ func setupTLS(cfg *config.Config) (*tlsmanager.Manager, error) {
 		logger.InfoEvent().
 			Bool("auto_cert", cfg.TLS.AutoCert).
 			Str("domain", cfg.Server.Domain).
+			Str("dns_provider", cfg.TLS.DNSProvider).
 			Msg("TLS enabled")
 	}
 
 	return tlsMgr, nil
 }
 
+// newDNSProvider creates the DNS provider for wildcard certificates, or nil
+// when none is configured.
+func newDNSProvider(cfg *config.Config, dnsServer *dns.Server) (tlsmanager.DNSProvider, error) {
+	if cfg.TLS.DNSProvider == "" {
+		return nil, nil
+	}
+	if !cfg.TLS.AutoCert {
+		return nil, fmt.Errorf("tls.dns_provider requires tls.auto_cert")
+	}
+
+	switch cfg.TLS.DNSProvider {
+	case "rfc2136":
+		zone := cfg.TLS.RFC2136.Zone
+		if zone == "" {
+			zone = cfg.Server.Domain
+		}
+		return tlsmanager.NewRFC2136Provider(tlsmanager.RFC2136Config{
+			Nameserver:    cfg.TLS.RFC2136.Nameserver,
+			Zone:          zone,
+			TSIGKey:       cfg.TLS.RFC2136.TSIGKey,
+			TSIGSecret:    cfg.TLS.RFC2136.TSIGSecret,
+			TSIGAlgorithm: cfg.TLS.RFC2136.TSIGAlgorithm,
+		})
+	case "exec":
+		return tlsmanager.NewExecProvider(cfg.TLS.Exec.Command)
+	case "builtin":
+		if dnsServer == nil {
+			return nil, fmt.Errorf("tls.dns_provider builtin requires dns.enabled")
+		}
+		return dnsServer, nil
+	default:
+		return nil, fmt.Errorf("unknown tls.dns_provider %q (use rfc2136, exec or builtin)", cfg.TLS.DNSProvider)
+	}
+}
+
 // createGRPCServer creates and configures gRPC server.
 func createGRPCServer(tlsMgr *tlsmanager.Manager, tunnelManager *tunnel.Manager, tokenService *auth.TokenService) *grpc.Server {
 	grpcOpts := []grpc.ServerOption{
@@ -174,11 +398,12 @@ func createHTTPServers(
 	tokenService *auth.TokenService,
 	tunnelManager *tunnel.Manager,
 	webhookRouter *proxy.WebhookRouter,
+	domainService *domains.Service,
 ) (*http.Server, *http.Server, *http.Server) {
-	// Create HTTP handler
-	httpHandler := httpProxy
+	// Create HTTP handler; it also answers HTTP ownership checks of custom domains
+	httpHandler := domainService.HTTPHandler(httpProxy)
 	if tlsMgr != nil && tlsMgr.GetHTTPHandler() != nil {
-		httpHandler = tlsMgr.GetHTTPHandler().HTTPHandler(httpProxy)
+		httpHandler = tlsMgr.GetHTTPHandler().HTTPHandler(httpHandler)
 	}
 
 	httpServer := &http.Server{
@@ -187,7 +412,15 @@ func createHTTPServers(
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	if cfg.Server.H2C {
+		// HTTP/2 with prior knowledge, e.g. for gRPC behind a TLS-terminating proxy
+		httpServer.Protocols = new(http.Protocols)
+		httpServer.Protocols.SetHTTP1(true)
+		httpServer.Protocols.SetUnencryptedHTTP2(true)
+		logger.InfoEvent().Msg("Unencrypted HTTP/2 (h2c) enabled on public HTTP listener")
+	}
 
+	// HTTPS visitors negotiate HTTP/2 or HTTP/1.1 by ALPN
 	var httpsServer *http.Server
 	if tlsMgr != nil && tlsMgr.IsEnabled() {
 		httpsServer = &http.Server{
@@ -201,7 +434,7 @@ func createHTTPServers(
 
 	// Setup Dashboard API server
 	apiMux := http.NewServeMux()
-	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, cfg)
+	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, domainService, cfg)
 	apiHandler.RegisterRoutes(apiMux)
 
 	if dashboardFS, err := web.GetFileSystem(); err != nil {
@@ -226,8 +459,9 @@ func createHTTPServers(
 
 	// Enable TLS for API server if TLS is configured
 	if tlsMgr != nil && tlsMgr.IsEnabled() {
-		tlsConfig := tlsMgr.GetTLSConfig()
-		// Disable HTTP/2 to avoid SSE compatibility issues
+		// Disable HTTP/2 to avoid SSE compatibility issues. The configuration
+		// is shared with the public HTTPS server, which keeps HTTP/2.
+		tlsConfig := tlsMgr.GetTLSConfig().Clone()
 		tlsConfig.NextProtos = []string{"http/1.1"}
 		apiServer.TLSConfig = tlsConfig
 	}
@@ -236,18 +470,31 @@ func createHTTPServers(
 }
 
 // startServers starts all HTTP/HTTPS/API servers in background goroutines.
-func startServers(httpServer, httpsServer, apiServer *http.Server) {
+// Connections to the HTTPS port are routed by SNI first, so TLS tunnels are
+// relayed without being terminated. The public HTTP and HTTPS listeners read
+// the PROXY headers of connections from proxyTrusted, when set.
+func startServers(httpServer, httpsServer, apiServer *http.Server, router *proxy.Router, tcpProxy *proxy.TCPProxy, proxyTrusted []netip.Prefix) {
 	go func() {
+		listener, err := publicListener(httpServer.Addr, proxyTrusted)
+		if err != nil {
+			logger.Fatal(fmt.Sprintf("HTTP server error: %v", err))
+		}
+
 		logger.InfoEvent().Str("addr", httpServer.Addr).Msg("HTTP proxy server listening")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 			logger.Fatal(fmt.Sprintf("HTTP server error: %v", err))
 		}
 	}()
 
 	if httpsServer != nil {
 		go func() {
+			listener, err := publicListener(httpsServer.Addr, proxyTrusted)
+			if err != nil {
+				logger.Fatal(fmt.Sprintf("HTTPS server error: %v", err))
+			}
+
 			logger.InfoEvent().Str("addr", httpsServer.Addr).Msg("HTTPS proxy server listening")
-			if err := httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			if err := httpsServer.ServeTLS(proxy.NewSNIListener(listener, router, tcpProxy), "", ""); err != nil && err != http.ErrServerClosed {
 				logger.Fatal(fmt.Sprintf("HTTPS server error: %v", err))
 			}
 		}()
@@ -268,8 +515,21 @@ func startServers(httpServer, httpsServer, apiServer *http.Server) {
 	}()
 }
 
+// publicListener listens on addr, reading the PROXY headers of connections
+// from proxyTrusted when set.
+func publicListener(addr string, proxyTrusted []netip.Prefix) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyTrusted != nil {
+		return proxyproto.NewListener(listener, proxyTrusted), nil
+	}
+	return listener, nil
+}
+
 // setupGracefulShutdown configures graceful shutdown handler.
-func setupGracefulShutdown(httpServer, httpsServer, apiServer *http.Server, tcpProxy *proxy.TCPProxy, grpcServer *grpc.Server) {
+func setupGracefulShutdown(httpServer, httpsServer, apiServer *http.Server, tcpProxy *proxy.TCPProxy, udpProxy *proxy.UDPProxy, dnsServer *dns.Server, grpcServer *grpc.Server) {
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -297,6 +557,13 @@ func setupGracefulShutdown(httpServer, httpsServer, apiServer *http.Server, tcpP
 		tcpProxy.Shutdown()
 		logger.InfoEvent().Msg("TCP proxy shut down")
 
+		udpProxy.Shutdown()
+		logger.InfoEvent().Msg("UDP proxy shut down")
+
+		if dnsServer != nil {
+			dnsServer.Shutdown()
+		}
+
 		grpcServer.GracefulStop()
 	}()
 }
@@ -344,7 +611,17 @@ func runServer() error {
 		logger.Fatal(fmt.Sprintf("Failed to initialize admin user: %v", err))
 	}
 
-	tlsMgr, err := setupTLS(cfg)
+	domainService := domains.NewService(database, cfg.Server.Domain, cfg.Server.HTTPPort)
+	if err := domainService.Load(context.Background()); err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to load custom domains: %v", err))
+	}
+
+	dnsServer, err := setupDNS(cfg, domainService)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to start DNS server: %v", err))
+	}
+
+	tlsMgr, err := setupTLS(cfg, domainService, dnsServer)
 	if err != nil {
 		logger.Fatal(fmt.Sprintf("Failed to setup TLS: %v", err))
 	}
@@ -357,17 +634,55 @@ func runServer() error {
 		tlsEnabled, cfg.Server.HTTPPort, cfg.Server.HTTPSPort,
 		cfg.Server.TCPPortStart, cfg.Server.TCPPortEnd,
 	)
+	tunnelManager.SetReconnectGrace(cfg.Tunnels.ReconnectGracePeriod, cfg.Tunnels.MaxHeldRequests)
+
+	accessGuard, err := setupAccess(cfg, tlsEnabled)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to setup tunnel access policies: %v", err))
+	}
+	tunnelManager.SetAccessGuard(accessGuard)
+
+	ipFilter, err := setupIPFilter(cfg, database)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to setup IP rules: %v", err))
+	}
+	tunnelManager.SetIPFilter(ipFilter)
+	tunnelManager.SetRateLimiter(setupRateLimiter(cfg, database))
+
+	edgeCache, err := setupEdgeCache(cfg)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to setup edge cache: %v", err))
+	}
+	tunnelManager.SetEdgeCache(edgeCache)
+
+	proxyTrusted, err := setupProxyProtocol(cfg)
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to setup PROXY protocol: %v", err))
+	}
+
+	if tlsEnabled {
+		// Certificates for tunnel subdomains are obtained on their first handshake
+		tlsMgr.SetSubdomainChecker(tunnelManager)
+		go tlsMgr.RenewInBackground(context.Background())
+	}
 
 	tcpProxy := proxy.NewTCPProxy(tunnelManager)
+	tcpProxy.SetProxyProtocol(proxyTrusted)
 	tunnelManager.SetTCPProxy(tcpProxy)
 
+	udpProxy := proxy.NewUDPProxy(tunnelManager, cfg.Tunnels.UDPFlowTimeout, cfg.Tunnels.UDPMaxFlows)
+	tunnelManager.SetUDPProxy(udpProxy)
+
 	grpcServer := createGRPCServer(tlsMgr, tunnelManager, tokenService)
 
 	router := proxy.NewRouter(tunnelManager, cfg.Server.Domain)
+	router.SetCustomDomains(domainService)
 	webhookRouter := proxy.NewWebhookRouter(database, tunnelManager, cfg.Server.Domain)
 	httpProxy := proxy.NewHTTPProxy(router, webhookRouter, tunnelManager, database, cfg.Logging.HTTPLogLevel, cfg.Tunnels.MaxRequestLogs)
+	tcpProxy.SetBlockedLogger(httpProxy.LogBlockedConnection)
+	udpProxy.SetBlockedLogger(httpProxy.LogBlockedConnection)
 
-	httpServer, httpsServer, apiServer := createHTTPServers(cfg, tlsMgr, httpProxy, database, tokenService, tunnelManager, webhookRouter)
+	httpServer, httpsServer, apiServer := createHTTPServers(cfg, tlsMgr, httpProxy, database, tokenService, tunnelManager, webhookRouter, domainService)
 
 	grpcAddr := fmt.Sprintf(":%d", cfg.Server.GRPCPort)
 	grpcListener, err := net.Listen("tcp", grpcAddr)
@@ -377,8 +692,8 @@ func runServer() error {
 
 	logger.InfoEvent().Str("addr", grpcAddr).Msg("gRPC server listening")
 
-	startServers(httpServer, httpsServer, apiServer)
-	setupGracefulShutdown(httpServer, httpsServer, apiServer, tcpProxy, grpcServer)
+	startServers(httpServer, httpsServer, apiServer, router, tcpProxy, proxyTrusted)
+	setupGracefulShutdown(httpServer, httpsServer, apiServer, tcpProxy, udpProxy, dnsServer, grpcServer)
 
 	if err := grpcServer.Serve(grpcListener); err != nil {
 		logger.Fatal(fmt.Sprintf("Failed to serve gRPC: %v", err))
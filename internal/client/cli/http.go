@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/pandeptwidyaop/grok/internal/client"
+	"github.com/pandeptwidyaop/grok/internal/client/config"
 	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
 	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
@@ -20,6 +21,15 @@ import (
 var (
 	httpSubdomain string
 	httpSavedName string
+	httpGroup     string
+	httpRoutes    []string
+	httpUpstream  config.UpstreamTLSConfig
+	httpRewrite   config.RewriteConfig
+	httpAccess    config.AccessConfig
+	httpIPRules   config.IPRulesConfig
+	httpHeaders   config.HeaderRulesConfig
+	httpEdge      config.EdgeConfig
+	httpHTTP2     bool
 )
 
 // httpCmd represents the http command.
@@ -33,7 +43,23 @@ Examples:
   grok http 8080 --name api            # Named tunnel (recommended, min 3 chars)
   grok http 3000 --name my-service     # Persistent tunnel with custom name
   grok http 3000 --subdomain demo      # Custom subdomain (alternative to --name)
-  grok http localhost:3000             # Explicit host and port`,
+  grok http 3000 --name api --group round-robin  # Share "api" with other clients (load balanced)
+  grok http 3000 --route /api=8080 --route /ws,strip=9000  # Send /api and /ws to other local ports
+  grok http 3000 --route host:admin.localhost=4000         # Route by Host header
+  grok http 3000 --route header:X-Version:2=8081          # Route by header value
+  grok http localhost:3000             # Explicit host and port
+  grok http https://localhost:8443 --upstream-insecure  # Local HTTPS with a self-signed certificate
+  grok http https://localhost:5001 --upstream-ca ca.pem --upstream-sni app.local
+  grok http unix:///run/php/app.sock   # Local Unix domain socket
+  grok http 8000 --host-header preserve    # Send the public Host (e.g. Django ALLOWED_HOSTS)
+  grok http 8080 --host-header myapp.test  # Send a fixed Host (virtual hosts)
+  grok http 3000 --basic-auth me:s3cret    # Require a password
+  grok http 3000 --oidc-domain example.com # Require a login with an example.com account
+  grok http 3000 --name demo --basic-auth me:s3cret --share-links  # Then: grok share demo
+  grok http 3000 --response-header-set "X-Robots-Tag: noindex" --response-header-remove Server
+  grok http 3000 --request-header-set "X-Real-IP: {client_ip}" --request-header-remove Cookie
+  grok http 3000 --compress --cache    # Compress and cache responses on the server
+  grok http 50051 --http2              # Local gRPC server (h2c), for grpcurl on the public URL`,
 	Args: cobra.ExactArgs(1),
 	RunE: runHTTPTunnel,
 }
@@ -43,12 +69,60 @@ func init() {
 
 	httpCmd.Flags().StringVarP(&httpSavedName, "name", "n", "", "tunnel name for persistent tunnels (min 3 chars, recommended)")
 	httpCmd.Flags().StringVarP(&httpSubdomain, "subdomain", "s", "", "custom subdomain (alternative to --name)")
+	httpCmd.Flags().StringVar(&httpGroup, "group", "", "join a load-balanced tunnel group under --name: round-robin, least-inflight or sticky")
+	httpCmd.Flags().BoolVar(&httpUpstream.Insecure, "upstream-insecure", false, "skip certificate verification of https:// local upstreams (self-signed certs)")
+	httpCmd.Flags().StringVar(&httpUpstream.CAFile, "upstream-ca", "", "CA certificate (PEM) that signed the certificate of https:// local upstreams")
+	httpCmd.Flags().StringVar(&httpUpstream.ServerName, "upstream-sni", "", "server name sent to and verified for https:// local upstreams")
+	httpCmd.Flags().StringVar(&httpRewrite.HostHeader, "host-header", "", `Host header sent to the local service: "preserve" for the public host, or a fixed value (default: the local address)`)
+	httpCmd.Flags().BoolVar(&httpRewrite.DisableURLRewrite, "no-url-rewrite", false, "keep local URLs in Location, Content-Location and Set-Cookie domains instead of rewriting them to the public URL")
+	httpCmd.Flags().StringArrayVar(&httpAccess.BasicAuth, "basic-auth", nil, "require HTTP basic auth from visitors: user:password (repeatable)")
+	httpCmd.Flags().StringSliceVar(&httpAccess.OIDCEmails, "oidc-email", nil, "let visitors in after logging in with the server's OIDC provider as one of these emails")
+	httpCmd.Flags().StringSliceVar(&httpAccess.OIDCDomains, "oidc-domain", nil, "let visitors in after logging in with the server's OIDC provider with an email of these domains")
+	httpCmd.Flags().BoolVar(&httpAccess.ShareLinks, "share-links", false, "let visitors in with expiring links created by grok share")
+	addIPRuleFlags(httpCmd, &httpIPRules)
+	httpCmd.Flags().StringArrayVar(&httpHeaders.RequestAdd, "request-header-add", nil, `add a header to requests: "Name: value" (repeatable; {client_ip}, {tunnel}, {subdomain}, {host}, {request_id} are replaced)`)
+	httpCmd.Flags().StringArrayVar(&httpHeaders.RequestSet, "request-header-set", nil, `replace a header of requests: "Name: value" (repeatable)`)
+	httpCmd.Flags().StringSliceVar(&httpHeaders.RequestRemove, "request-header-remove", nil, "remove headers from requests")
+	httpCmd.Flags().StringArrayVar(&httpHeaders.ResponseAdd, "response-header-add", nil, `add a header to responses: "Name: value" (repeatable)`)
+	httpCmd.Flags().StringArrayVar(&httpHeaders.ResponseSet, "response-header-set", nil, `replace a header of responses: "Name: value" (repeatable)`)
+	httpCmd.Flags().StringSliceVar(&httpHeaders.ResponseRemove, "response-header-remove", nil, "remove headers from responses")
+	httpCmd.Flags().BoolVar(&httpEdge.Compress, "compress", false, "compress responses with gzip or brotli on the server for visitors that accept it")
+	httpCmd.Flags().BoolVar(&httpEdge.Cache, "cache", false, "cache GET responses on the server as allowed by their Cache-Control, ETag and Vary headers")
+	httpCmd.Flags().BoolVar(&httpHTTP2, "http2", false, "speak HTTP/2 to the local service, negotiated for https:// upstreams and h2c otherwise (gRPC servers)")
+	httpCmd.Flags().StringArrayVar(&httpRoutes, "route", nil, "send matching requests to another local upstream: MATCH[,MATCH...]=ADDR where MATCH is /path, host:NAME, header:NAME[:VALUE] or strip (repeatable, first match wins)")
 }
 
 func runHTTPTunnel(cmd *cobra.Command, args []string) error {
 	// Parse local address
 	localAddr := parseLocalAddr(args[0], "http")
 
+	loadBalancing, err := config.ParseLoadBalancing(httpGroup)
+	if err != nil {
+		return err
+	}
+	if httpGroup != "" && httpSavedName == "" {
+		return fmt.Errorf("--group requires --name, shared by all clients in the group")
+	}
+
+	if err := httpAccess.Validate(); err != nil {
+		return err
+	}
+	if err := httpIPRules.Validate(); err != nil {
+		return err
+	}
+	if err := httpHeaders.Validate(); err != nil {
+		return err
+	}
+
+	routes := make([]config.RouteConfig, 0, len(httpRoutes))
+	for _, spec := range httpRoutes {
+		route, err := config.ParseRoute(spec)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, route)
+	}
+
 	logger.InfoEvent().
 		Str("local_addr", localAddr).
 		Str("subdomain", httpSubdomain).
@@ -101,6 +175,15 @@ func runHTTPTunnel(cmd *cobra.Command, args []string) error {
 		LocalAddr:      localAddr,
 		Subdomain:      httpSubdomain,
 		SavedName:      httpSavedName,
+		LoadBalancing:  loadBalancing,
+		Routes:         routes,
+		UpstreamTLS:    httpUpstream,
+		Rewrite:        httpRewrite,
+		Access:         httpAccess,
+		IPRules:        httpIPRules,
+		Headers:        httpHeaders,
+		Edge:           httpEdge,
+		HTTP2:          httpHTTP2,
 		Protocol:       "http",
 		ReconnectCfg:   cfg.Reconnect,
 		DashboardCfg:   dashboardCfg,
@@ -30,6 +30,10 @@ Example usage:
   grok http 3000                    # Create HTTP tunnel to localhost:3000
   grok http 8080 --subdomain demo   # Create tunnel with custom subdomain
   grok tcp 22                       # Create TCP tunnel to localhost:22
+  grok tls 8443                     # Create TLS passthrough tunnel to localhost:8443
+  grok udp 53                       # Create UDP tunnel to localhost:53
+  grok start --all                  # Start all tunnels in ./grok.yml
+  grok share demo --expires 1h      # Share link for a protected tunnel
   grok config set-token <token>     # Configure auth token`,
 	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
 		// Skip config loading for config and version commands
@@ -44,11 +48,19 @@ Example usage:
 			return nil
 		}
 
-		// Load configuration
+		// Load configuration; the start command reads the project file on top of it
 		var err error
-		cfg, err = config.Load(cfgFile)
-		if err != nil {
-			return fmt.Errorf("failed to load config: %w", err)
+		if cmd == startCmd {
+			project, err = config.LoadProject(startProjectFile, cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load project file: %w", err)
+			}
+			cfg = &project.Config
+		} else {
+			cfg, err = config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
 		}
 
 		// Setup logger
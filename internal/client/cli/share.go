@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
+)
+
+var shareExpires time.Duration
+
+// shareCmd represents the share command.
+var shareCmd = &cobra.Command{
+	Use:   "share [name]",
+	Short: "Create a share link for a protected tunnel",
+	Long: `Create an expiring link that lets anyone who has it into a protected
+HTTP tunnel, without other credentials. The tunnel must be online and
+started with --share-links.
+
+Examples:
+  grok http 3000 --name demo --basic-auth me:s3cret --share-links
+  grok share demo                  # Link valid for 24 hours
+  grok share demo --expires 1h     # Link valid for one hour`,
+	Args: cobra.ExactArgs(1),
+	RunE: runShare,
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+
+	shareCmd.Flags().DurationVar(&shareExpires, "expires", 0, "validity of the link (default: server default, 24h)")
+}
+
+func runShare(cmd *cobra.Command, args []string) error {
+	cfg := GetConfig()
+	if serverFlag, _ := cmd.Flags().GetString("server"); serverFlag != "" {
+		cfg.Server.Addr = serverFlag
+	}
+	if tokenFlag, _ := cmd.Flags().GetString("token"); tokenFlag != "" {
+		cfg.Auth.Token = tokenFlag
+	}
+	if shareExpires < 0 {
+		return fmt.Errorf("--expires must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	link, expires, err := tunnel.CreateShareLink(ctx, tunnel.ClientConfig{
+		ServerAddr:    cfg.Server.Addr,
+		TLS:           cfg.Server.TLS,
+		TLSCertFile:   cfg.Server.TLSCertFile,
+		TLSInsecure:   cfg.Server.TLSInsecure,
+		TLSServerName: cfg.Server.TLSServerName,
+		AuthToken:     cfg.Auth.Token,
+	}, args[0], shareExpires)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(link)
+	fmt.Printf("Expires: %s\n", expires.Local().Format(time.RFC1123))
+	return nil
+}
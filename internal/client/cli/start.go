@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pandeptwidyaop/grok/internal/client/config"
+	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
+	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+var (
+	startAll         bool
+	startProjectFile string
+	project          *config.ProjectConfig
+)
+
+// startCmd represents the start command.
+var startCmd = &cobra.Command{
+	Use:   "start [names...]",
+	Short: "Start tunnels from a project file",
+	Long: `Start several tunnels defined in a project file (grok.yml) under one
+process, one server connection and one dashboard.
+
+The project file accepts the same settings as the user config, plus a
+tunnels section:
+
+  tunnels:
+    web:
+      proto: http        # http, https, tcp, tls or udp (default: http)
+      addr: 3000         # port or host:port
+      subdomain: myapp   # optional
+    db:
+      proto: tcp
+      addr: localhost:5432
+      name: my-db        # optional persistent tunnel name
+
+Examples:
+  grok start --all                  # Start every tunnel in ./grok.yml
+  grok start web api                # Start selected tunnels
+  grok start --all -f dev.grok.yml  # Use another project file`,
+	RunE: runStart,
+}
+
+func init() {
+	rootCmd.AddCommand(startCmd)
+
+	startCmd.Flags().BoolVar(&startAll, "all", false, "start all tunnels in the project file")
+	startCmd.Flags().StringVarP(&startProjectFile, "file", "f", config.ProjectFile, "project file")
+}
+
+func runStart(cmd *cobra.Command, args []string) error {
+	// Validate the whole file before connecting
+	if err := project.Validate(); err != nil {
+		return fmt.Errorf("invalid project file %s:\n%w", startProjectFile, err)
+	}
+
+	names, err := project.Select(args, startAll)
+	if err != nil {
+		return err
+	}
+
+	// Get config with overrides from flags
+	cfg := GetConfig()
+	if serverFlag, _ := cmd.Flags().GetString("server"); serverFlag != "" {
+		cfg.Server.Addr = serverFlag
+	}
+	if tokenFlag, _ := cmd.Flags().GetString("token"); tokenFlag != "" {
+		cfg.Auth.Token = tokenFlag
+	}
+
+	// Get dashboard flags
+	dashboardEnabled := cfg.Dashboard.Enabled
+	dashboardPort := cfg.Dashboard.Port
+
+	if noDashboard, _ := cmd.Flags().GetBool("no-dashboard"); noDashboard {
+		dashboardEnabled = false
+	} else if dashboardFlag, _ := cmd.Flags().GetBool("dashboard"); !dashboardFlag {
+		dashboardEnabled = false
+	}
+
+	if portFlag, _ := cmd.Flags().GetInt("dashboard-port"); portFlag != 4041 {
+		dashboardPort = portFlag
+	}
+
+	// Build dashboard config
+	dashboardCfg := dashboard.Config{}
+	if dashboardEnabled {
+		dashboardCfg.Port = dashboardPort
+		dashboardCfg.MaxRequests = cfg.Dashboard.MaxRequests
+		dashboardCfg.MaxBodySize = cfg.Dashboard.MaxBodySize
+		dashboardCfg.EnableSSE = true
+	}
+
+	// Check version compatibility with server (non-blocking, non-fatal)
+	checkServerVersion(cfg.Server.Addr)
+
+	// One session carries all tunnels
+	session := tunnel.NewSession(tunnel.ClientConfig{
+		ServerAddr:     cfg.Server.Addr,
+		TLS:            cfg.Server.TLS,
+		TLSCertFile:    cfg.Server.TLSCertFile,
+		TLSInsecure:    cfg.Server.TLSInsecure,
+		TLSServerName:  cfg.Server.TLSServerName,
+		AuthToken:      cfg.Auth.Token,
+		ReconnectCfg:   cfg.Reconnect,
+		DashboardCfg:   dashboardCfg,
+		PerformanceCfg: cfg.Performance,
+	})
+
+	for _, name := range names {
+		tun := project.Tunnels[name]
+
+		logger.InfoEvent().
+			Str("tunnel", name).
+			Str("proto", tun.Proto).
+			Str("local_addr", tun.LocalAddr()).
+			Msg("Adding tunnel")
+
+		// Validated with the project file
+		loadBalancing, _ := config.ParseLoadBalancing(tun.Group)
+
+		if _, err := session.AddTunnel(tunnel.ClientConfig{
+			Name:           name,
+			LocalAddr:      tun.LocalAddr(),
+			Subdomain:      tun.Subdomain,
+			SavedName:      tun.Name,
+			LoadBalancing:  loadBalancing,
+			Routes:         tun.Routes,
+			UpstreamTLS:    tun.UpstreamTLS,
+			Rewrite:        tun.Rewrite,
+			Access:         tun.Access,
+			IPRules:        tun.IPRules,
+			Headers:        tun.Headers,
+			Edge:           tun.Edge,
+			ProxyProtocol:  tun.ProxyProtocol,
+			HTTP2:          tun.HTTP2,
+			UDPMaxFlows:    tun.MaxFlows,
+			Protocol:       tun.Proto,
+			PerformanceCfg: cfg.Performance,
+		}); err != nil {
+			return fmt.Errorf("failed to add tunnel %q: %w", name, err)
+		}
+	}
+
+	// Setup context with cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logger.InfoEvent().Msg("Received shutdown signal, closing tunnels...")
+		cancel()
+	}()
+
+	// Start tunnels
+	if err := session.Start(ctx); err != nil {
+		return fmt.Errorf("tunnel error: %w", err)
+	}
+
+	return nil
+}
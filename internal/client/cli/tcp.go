@@ -9,12 +9,17 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/pandeptwidyaop/grok/internal/client/config"
 	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
 	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 )
 
-var tcpSavedName string
+var (
+	tcpSavedName     string
+	tcpIPRules       config.IPRulesConfig
+	tcpProxyProtocol string
+)
 
 // tcpCmd represents the tcp command.
 var tcpCmd = &cobra.Command{
@@ -26,7 +31,9 @@ Examples:
   grok tcp 22                       # Tunnel SSH on port 22 (auto-generated subdomain)
   grok tcp 3306 --name db           # Named tunnel (recommended, min 3 chars)
   grok tcp 5432 --name postgres     # Persistent tunnel with custom name
-  grok tcp localhost:27017          # Explicit host and port`,
+  grok tcp localhost:27017          # Explicit host and port
+  grok tcp 22 --allow-cidr 203.0.113.0/24  # Only accept connections from one network
+  grok tcp 25 --proxy-protocol v2   # Tell the local service who connected (PROXY header)`,
 	Args: cobra.ExactArgs(1),
 	RunE: runTCPTunnel,
 }
@@ -34,12 +41,26 @@ Examples:
 func init() {
 	rootCmd.AddCommand(tcpCmd)
 	tcpCmd.Flags().StringVarP(&tcpSavedName, "name", "n", "", "tunnel name for persistent tunnels (min 3 chars, recommended)")
+	addIPRuleFlags(tcpCmd, &tcpIPRules)
+	tcpCmd.Flags().StringVar(&tcpProxyProtocol, "proxy-protocol", "", "start connections to the local service with a PROXY header (v1 or v2) carrying the visitor's address")
+}
+
+// addIPRuleFlags adds the flags restricting which visitors may reach a tunnel.
+func addIPRuleFlags(cmd *cobra.Command, rules *config.IPRulesConfig) {
+	cmd.Flags().StringSliceVar(&rules.AllowCIDRs, "allow-cidr", nil, "only let in visitors from these networks or addresses")
+	cmd.Flags().StringSliceVar(&rules.DenyCIDRs, "deny-cidr", nil, "reject visitors from these networks or addresses")
+	cmd.Flags().StringSliceVar(&rules.AllowCountries, "allow-country", nil, "only let in visitors from these countries (ISO codes, needs GeoIP on the server)")
+	cmd.Flags().StringSliceVar(&rules.DenyCountries, "deny-country", nil, "reject visitors from these countries (ISO codes, needs GeoIP on the server)")
 }
 
 func runTCPTunnel(cmd *cobra.Command, args []string) error {
 	// Parse local address
 	localAddr := parseLocalAddr(args[0], "tcp")
 
+	if err := tcpIPRules.Validate(); err != nil {
+		return err
+	}
+
 	logger.InfoEvent().
 		Str("local_addr", localAddr).
 		Str("saved_name", tcpSavedName).
@@ -90,6 +111,8 @@ func runTCPTunnel(cmd *cobra.Command, args []string) error {
 		AuthToken:     cfg.Auth.Token,
 		LocalAddr:     localAddr,
 		SavedName:     tcpSavedName,
+		IPRules:       tcpIPRules,
+		ProxyProtocol: tcpProxyProtocol,
 		Protocol:      "tcp",
 		ReconnectCfg:  cfg.Reconnect,
 		DashboardCfg:  dashboardCfg,
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
+	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+var tlsSavedName string
+
+// tlsCmd represents the tls command.
+var tlsCmd = &cobra.Command{
+	Use:   "tls [port]",
+	Short: "Start TLS passthrough tunnel",
+	Long: `Create a TLS tunnel to expose a local TLS service to the internet.
+
+The server routes connections on its HTTPS port by SNI and relays them
+without decrypting: the local service terminates TLS with its own
+certificate for the tunnel's hostname, so traffic stays encrypted end to end.
+
+Examples:
+  grok tls 8443                     # Tunnel a local TLS server on port 8443
+  grok tls 8443 --name demo         # Persistent tunnel with custom name
+  grok tls localhost:9443           # Explicit host and port`,
+	Args: cobra.ExactArgs(1),
+	RunE: runTLSTunnel,
+}
+
+func init() {
+	rootCmd.AddCommand(tlsCmd)
+	tlsCmd.Flags().StringVarP(&tlsSavedName, "name", "n", "", "tunnel name for persistent tunnels (min 3 chars, recommended)")
+}
+
+func runTLSTunnel(cmd *cobra.Command, args []string) error {
+	// Parse local address
+	localAddr := parseLocalAddr(args[0], "tls")
+
+	logger.InfoEvent().
+		Str("local_addr", localAddr).
+		Str("saved_name", tlsSavedName).
+		Msg("Starting TLS tunnel")
+
+	// Get config with overrides from flags
+	cfg := GetConfig()
+	if serverFlag, _ := cmd.Flags().GetString("server"); serverFlag != "" {
+		cfg.Server.Addr = serverFlag
+	}
+	if tokenFlag, _ := cmd.Flags().GetString("token"); tokenFlag != "" {
+		cfg.Auth.Token = tokenFlag
+	}
+
+	// Get dashboard flags
+	dashboardEnabled := cfg.Dashboard.Enabled
+	dashboardPort := cfg.Dashboard.Port
+
+	if noDashboard, _ := cmd.Flags().GetBool("no-dashboard"); noDashboard {
+		dashboardEnabled = false
+	} else if dashboardFlag, _ := cmd.Flags().GetBool("dashboard"); !dashboardFlag {
+		dashboardEnabled = false
+	}
+
+	if portFlag, _ := cmd.Flags().GetInt("dashboard-port"); portFlag != 4041 {
+		dashboardPort = portFlag
+	}
+
+	// Build dashboard config
+	dashboardCfg := dashboard.Config{}
+	if dashboardEnabled {
+		dashboardCfg.Port = dashboardPort
+		dashboardCfg.MaxRequests = cfg.Dashboard.MaxRequests
+		dashboardCfg.MaxBodySize = cfg.Dashboard.MaxBodySize
+		dashboardCfg.EnableSSE = true
+	}
+
+	// Check version compatibility with server (non-blocking, non-fatal)
+	checkServerVersion(cfg.Server.Addr)
+
+	// Create tunnel client
+	client, err := tunnel.NewClient(tunnel.ClientConfig{
+		ServerAddr:    cfg.Server.Addr,
+		TLS:           cfg.Server.TLS,
+		TLSCertFile:   cfg.Server.TLSCertFile,
+		TLSInsecure:   cfg.Server.TLSInsecure,
+		TLSServerName: cfg.Server.TLSServerName,
+		AuthToken:     cfg.Auth.Token,
+		LocalAddr:     localAddr,
+		SavedName:     tlsSavedName,
+		Protocol:      "tls",
+		ReconnectCfg:  cfg.Reconnect,
+		DashboardCfg:  dashboardCfg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel client: %w", err)
+	}
+
+	// Setup context with cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logger.InfoEvent().Msg("Received shutdown signal, closing tunnel...")
+		cancel()
+	}()
+
+	// Start tunnel
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("tunnel error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
+	"github.com/pandeptwidyaop/grok/internal/client/proxy"
+	"github.com/pandeptwidyaop/grok/internal/client/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+var (
+	udpSavedName string
+	udpMaxFlows  int
+)
+
+// udpCmd represents the udp command.
+var udpCmd = &cobra.Command{
+	Use:   "udp [port]",
+	Short: "Start UDP tunnel",
+	Long: `Create a UDP tunnel to expose a local UDP service to the internet.
+
+Each public peer gets its own local socket, so the local service can tell
+peers apart by source address. Flows without traffic are closed after the
+server's idle timeout; beyond --max-flows peers, the one idle the longest
+loses its socket.
+
+Examples:
+  grok udp 53                       # Tunnel a DNS resolver on port 53
+  grok udp 51820 --name wireguard   # Persistent tunnel with custom name
+  grok udp 27015                    # Game server
+  grok udp localhost:5060           # Explicit host and port (SIP)`,
+	Args: cobra.ExactArgs(1),
+	RunE: runUDPTunnel,
+}
+
+func init() {
+	rootCmd.AddCommand(udpCmd)
+	udpCmd.Flags().StringVarP(&udpSavedName, "name", "n", "", "tunnel name for persistent tunnels (min 3 chars, recommended)")
+	udpCmd.Flags().IntVar(&udpMaxFlows, "max-flows", proxy.DefaultMaxUDPFlows, "local sockets kept open, one per public peer")
+}
+
+func runUDPTunnel(cmd *cobra.Command, args []string) error {
+	// Parse local address
+	localAddr := parseLocalAddr(args[0], "udp")
+
+	logger.InfoEvent().
+		Str("local_addr", localAddr).
+		Str("saved_name", udpSavedName).
+		Msg("Starting UDP tunnel")
+
+	// Get config with overrides from flags
+	cfg := GetConfig()
+	if serverFlag, _ := cmd.Flags().GetString("server"); serverFlag != "" {
+		cfg.Server.Addr = serverFlag
+	}
+	if tokenFlag, _ := cmd.Flags().GetString("token"); tokenFlag != "" {
+		cfg.Auth.Token = tokenFlag
+	}
+
+	// Get dashboard flags
+	dashboardEnabled := cfg.Dashboard.Enabled
+	dashboardPort := cfg.Dashboard.Port
+
+	if noDashboard, _ := cmd.Flags().GetBool("no-dashboard"); noDashboard {
+		dashboardEnabled = false
+	} else if dashboardFlag, _ := cmd.Flags().GetBool("dashboard"); !dashboardFlag {
+		dashboardEnabled = false
+	}
+
+	if portFlag, _ := cmd.Flags().GetInt("dashboard-port"); portFlag != 4041 {
+		dashboardPort = portFlag
+	}
+
+	// Build dashboard config
+	dashboardCfg := dashboard.Config{}
+	if dashboardEnabled {
+		dashboardCfg.Port = dashboardPort
+		dashboardCfg.MaxRequests = cfg.Dashboard.MaxRequests
+		dashboardCfg.MaxBodySize = cfg.Dashboard.MaxBodySize
+		dashboardCfg.EnableSSE = true
+	}
+
+	// Check version compatibility with server (non-blocking, non-fatal)
+	checkServerVersion(cfg.Server.Addr)
+
+	// Create tunnel client
+	client, err := tunnel.NewClient(tunnel.ClientConfig{
+		ServerAddr:    cfg.Server.Addr,
+		TLS:           cfg.Server.TLS,
+		TLSCertFile:   cfg.Server.TLSCertFile,
+		TLSInsecure:   cfg.Server.TLSInsecure,
+		TLSServerName: cfg.Server.TLSServerName,
+		AuthToken:     cfg.Auth.Token,
+		LocalAddr:     localAddr,
+		SavedName:     udpSavedName,
+		UDPMaxFlows:   udpMaxFlows,
+		Protocol:      "udp",
+		ReconnectCfg:  cfg.Reconnect,
+		DashboardCfg:  dashboardCfg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel client: %w", err)
+	}
+
+	// Setup context with cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle graceful shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logger.InfoEvent().Msg("Received shutdown signal, closing tunnel...")
+		cancel()
+	}()
+
+	// Start tunnel
+	if err := client.Start(ctx); err != nil {
+		return fmt.Errorf("tunnel error: %w", err)
+	}
+
+	return nil
+}
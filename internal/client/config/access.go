@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// AccessConfig restricts who may reach an HTTP tunnel. The server checks
+// visitors at the edge, before requests reach the client. A visitor is let
+// in by any one of the enabled methods; a tunnel without any is public.
+type AccessConfig struct {
+	BasicAuth   []string `mapstructure:"basic_auth"`   // Credentials as user:password
+	OIDCEmails  []string `mapstructure:"oidc_emails"`  // Emails allowed to log in with the server's OIDC provider
+	OIDCDomains []string `mapstructure:"oidc_domains"` // Email domains allowed to log in with the server's OIDC provider
+	ShareLinks  bool     `mapstructure:"share_links"`  // Accept expiring share links (grok share)
+}
+
+// Enabled reports whether the tunnel is protected.
+func (a AccessConfig) Enabled() bool {
+	return len(a.BasicAuth) > 0 || len(a.OIDCEmails) > 0 || len(a.OIDCDomains) > 0 || a.ShareLinks
+}
+
+// Validate checks the credentials and allowlists.
+func (a AccessConfig) Validate() error {
+	for _, cred := range a.BasicAuth {
+		username, password, ok := strings.Cut(cred, ":")
+		if !ok || username == "" || password == "" {
+			return fmt.Errorf("invalid basic auth %q (use user:password)", username)
+		}
+	}
+	for _, email := range a.OIDCEmails {
+		if local, domain, ok := strings.Cut(email, "@"); !ok || local == "" || domain == "" {
+			return fmt.Errorf("invalid OIDC email %q", email)
+		}
+	}
+	for _, domain := range a.OIDCDomains {
+		if strings.TrimPrefix(domain, "@") == "" {
+			return errors.New("OIDC domain must not be empty")
+		}
+	}
+	return nil
+}
+
+// Policy returns the access policy sent to the server, or nil for a public tunnel.
+func (a AccessConfig) Policy() *tunnelv1.AccessPolicy {
+	if !a.Enabled() {
+		return nil
+	}
+
+	policy := &tunnelv1.AccessPolicy{ShareLinks: a.ShareLinks}
+	for _, cred := range a.BasicAuth {
+		username, password, _ := strings.Cut(cred, ":")
+		policy.BasicAuth = append(policy.BasicAuth, &tunnelv1.BasicAuthCredential{Username: username, Password: password})
+	}
+	if len(a.OIDCEmails) > 0 || len(a.OIDCDomains) > 0 {
+		policy.Oidc = &tunnelv1.OIDCAccess{
+			AllowedEmails:  a.OIDCEmails,
+			AllowedDomains: a.OIDCDomains,
+		}
+	}
+	return policy
+}
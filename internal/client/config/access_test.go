@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		access  AccessConfig
+		wantErr bool
+	}{
+		{name: "public", access: AccessConfig{}},
+		{name: "basic auth", access: AccessConfig{BasicAuth: []string{"alice:s3cret:with:colons"}}},
+		{name: "oidc", access: AccessConfig{OIDCEmails: []string{"alice@example.com"}, OIDCDomains: []string{"@example.org"}}},
+		{name: "basic auth without password", access: AccessConfig{BasicAuth: []string{"alice"}}, wantErr: true},
+		{name: "basic auth empty password", access: AccessConfig{BasicAuth: []string{"alice:"}}, wantErr: true},
+		{name: "invalid email", access: AccessConfig{OIDCEmails: []string{"alice"}}, wantErr: true},
+		{name: "empty domain", access: AccessConfig{OIDCDomains: []string{"@"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.access.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAccessConfig_Policy(t *testing.T) {
+	assert.Nil(t, AccessConfig{}.Policy())
+
+	policy := AccessConfig{
+		BasicAuth:   []string{"alice:pa:ss"},
+		OIDCDomains: []string{"example.com"},
+		ShareLinks:  true,
+	}.Policy()
+	require.NotNil(t, policy)
+	require.Len(t, policy.BasicAuth, 1)
+	assert.Equal(t, "alice", policy.BasicAuth[0].Username)
+	assert.Equal(t, "pa:ss", policy.BasicAuth[0].Password)
+	assert.Equal(t, []string{"example.com"}, policy.GetOidc().GetAllowedDomains())
+	assert.True(t, policy.ShareLinks)
+
+	assert.Nil(t, AccessConfig{ShareLinks: true}.Policy().GetOidc())
+}
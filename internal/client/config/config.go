@@ -86,29 +86,8 @@ func Load(configPath string) (*Config, error) {
 	// Set defaults first
 	setDefaults(v)
 
-	// If config path is provided, use it
-	if configPath != "" {
-		v.SetConfigFile(configPath)
-	} else {
-		// Look for config in default locations
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get user home dir: %w", err)
-		}
-
-		configDir := filepath.Join(home, ".grok")
-		v.AddConfigPath(configDir)
-		v.AddConfigPath(".")
-		v.SetConfigName("config")
-		v.SetConfigType("yaml")
-	}
-
-	// Read config file (optional)
-	if err := v.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-		// Config file not found, use defaults
+	if err := readUserConfig(v, configPath); err != nil {
+		return nil, err
 	}
 
 	// Read from environment variables
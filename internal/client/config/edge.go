@@ -0,0 +1,23 @@
+package config
+
+import tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+
+// EdgeConfig opts an HTTP tunnel into compression and caching of its
+// responses by the server.
+type EdgeConfig struct {
+	Compress bool `mapstructure:"compress"` // gzip or brotli for visitors that accept it
+	Cache    bool `mapstructure:"cache"`    // Serve GET responses from the server's cache, as Cache-Control allows
+}
+
+// Enabled reports whether any edge feature is set.
+func (e EdgeConfig) Enabled() bool {
+	return e.Compress || e.Cache
+}
+
+// Proto returns the options sent to the server, or nil when none are set.
+func (e EdgeConfig) Proto() *tunnelv1.EdgeOptions {
+	if !e.Enabled() {
+		return nil
+	}
+	return &tunnelv1.EdgeOptions{Compress: e.Compress, Cache: e.Cache}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// HeaderRulesConfig changes the headers of requests to and responses from an
+// HTTP tunnel on the server. Add and set take "Name: value", where the value
+// may use {client_ip}, {tunnel}, {subdomain}, {host} and {request_id}. Per
+// direction, removes apply first, then sets, then adds.
+type HeaderRulesConfig struct {
+	RequestAdd     []string `mapstructure:"request_add"`     // Add values to requests forwarded to the local service
+	RequestSet     []string `mapstructure:"request_set"`     // Replace request headers, e.g. "X-Internal-Auth: secret"
+	RequestRemove  []string `mapstructure:"request_remove"`  // Header names removed from requests, e.g. Cookie
+	ResponseAdd    []string `mapstructure:"response_add"`    // Add values to responses sent to visitors
+	ResponseSet    []string `mapstructure:"response_set"`    // Replace response headers, e.g. "X-Robots-Tag: noindex"
+	ResponseRemove []string `mapstructure:"response_remove"` // Header names removed from responses, e.g. Server
+}
+
+// Enabled reports whether any rule is set.
+func (h HeaderRulesConfig) Enabled() bool {
+	return len(h.RequestAdd)+len(h.RequestSet)+len(h.RequestRemove)+
+		len(h.ResponseAdd)+len(h.ResponseSet)+len(h.ResponseRemove) > 0
+}
+
+// Validate checks the format of the rules. Header names and placeholders are
+// checked by the server.
+func (h HeaderRulesConfig) Validate() error {
+	for _, headers := range [][]string{h.RequestAdd, h.RequestSet, h.ResponseAdd, h.ResponseSet} {
+		for _, header := range headers {
+			if _, _, err := splitHeader(header); err != nil {
+				return err
+			}
+		}
+	}
+	for _, names := range [][]string{h.RequestRemove, h.ResponseRemove} {
+		for _, name := range names {
+			if strings.TrimSpace(name) == "" || strings.Contains(name, ":") {
+				return fmt.Errorf("invalid header name %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Proto returns the rules sent to the server, or nil when none are set.
+func (h HeaderRulesConfig) Proto() *tunnelv1.HeaderRules {
+	if !h.Enabled() {
+		return nil
+	}
+	return &tunnelv1.HeaderRules{
+		Request:  headerRules(h.RequestRemove, h.RequestSet, h.RequestAdd),
+		Response: headerRules(h.ResponseRemove, h.ResponseSet, h.ResponseAdd),
+	}
+}
+
+func headerRules(remove, set, add []string) []*tunnelv1.HeaderRule {
+	rules := make([]*tunnelv1.HeaderRule, 0, len(remove)+len(set)+len(add))
+	for _, name := range remove {
+		rules = append(rules, &tunnelv1.HeaderRule{
+			Action: tunnelv1.HeaderAction_HEADER_ACTION_REMOVE,
+			Name:   strings.TrimSpace(name),
+		})
+	}
+	for _, header := range set {
+		name, value, _ := splitHeader(header) // Validated
+		rules = append(rules, &tunnelv1.HeaderRule{Action: tunnelv1.HeaderAction_HEADER_ACTION_SET, Name: name, Value: value})
+	}
+	for _, header := range add {
+		name, value, _ := splitHeader(header)
+		rules = append(rules, &tunnelv1.HeaderRule{Action: tunnelv1.HeaderAction_HEADER_ACTION_ADD, Name: name, Value: value})
+	}
+	return rules
+}
+
+// splitHeader parses "Name: value".
+func splitHeader(header string) (name, value string, err error) {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid header %q (use \"Name: value\")", header)
+	}
+	return name, strings.TrimSpace(value), nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+func TestHeaderRulesConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   HeaderRulesConfig
+		wantErr bool
+	}{
+		{name: "none", rules: HeaderRulesConfig{}},
+		{name: "set and remove", rules: HeaderRulesConfig{RequestSet: []string{"X-Real-IP: {client_ip}"}, ResponseRemove: []string{"Server"}}},
+		{name: "empty value", rules: HeaderRulesConfig{ResponseAdd: []string{"X-Empty:"}}},
+		{name: "missing value", rules: HeaderRulesConfig{RequestAdd: []string{"X-Tunnel"}}, wantErr: true},
+		{name: "missing name", rules: HeaderRulesConfig{ResponseSet: []string{": noindex"}}, wantErr: true},
+		{name: "remove with value", rules: HeaderRulesConfig{RequestRemove: []string{"Cookie: a=b"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHeaderRulesConfig_Proto(t *testing.T) {
+	assert.Nil(t, HeaderRulesConfig{}.Proto())
+
+	rules := HeaderRulesConfig{
+		RequestAdd:     []string{"X-Via: grok"},
+		RequestRemove:  []string{" Cookie "},
+		ResponseSet:    []string{"X-Robots-Tag: noindex", "Content-Security-Policy: default-src 'self'"},
+		ResponseRemove: []string{"Server"},
+	}.Proto()
+	require.NotNil(t, rules)
+
+	assert.Equal(t, []*tunnelv1.HeaderRule{
+		{Action: tunnelv1.HeaderAction_HEADER_ACTION_REMOVE, Name: "Cookie"},
+		{Action: tunnelv1.HeaderAction_HEADER_ACTION_ADD, Name: "X-Via", Value: "grok"},
+	}, rules.Request)
+	require.Len(t, rules.Response, 3)
+	assert.Equal(t, tunnelv1.HeaderAction_HEADER_ACTION_REMOVE, rules.Response[0].Action)
+	assert.Equal(t, "Content-Security-Policy", rules.Response[2].Name)
+	assert.Equal(t, "default-src 'self'", rules.Response[2].Value)
+}
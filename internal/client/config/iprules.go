@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// IPRulesConfig restricts which addresses may reach an HTTP or TCP tunnel.
+// The server rejects visitors matching a deny rule and, when allow rules are
+// set, visitors matching none of them. Country rules need a GeoIP database
+// on the server.
+type IPRulesConfig struct {
+	AllowCIDRs     []string `mapstructure:"allow_cidrs"`     // Networks (or single addresses) let in
+	DenyCIDRs      []string `mapstructure:"deny_cidrs"`      // Networks (or single addresses) rejected
+	AllowCountries []string `mapstructure:"allow_countries"` // ISO 3166-1 alpha-2 codes let in, e.g. DE
+	DenyCountries  []string `mapstructure:"deny_countries"`  // ISO 3166-1 alpha-2 codes rejected
+}
+
+// Enabled reports whether any rule is set.
+func (r IPRulesConfig) Enabled() bool {
+	return len(r.AllowCIDRs)+len(r.DenyCIDRs)+len(r.AllowCountries)+len(r.DenyCountries) > 0
+}
+
+// Validate checks the networks and country codes.
+func (r IPRulesConfig) Validate() error {
+	for _, cidrs := range [][]string{r.AllowCIDRs, r.DenyCIDRs} {
+		for _, cidr := range cidrs {
+			cidr = strings.TrimSpace(cidr)
+			if _, err := netip.ParsePrefix(cidr); err == nil {
+				continue
+			}
+			if _, err := netip.ParseAddr(cidr); err != nil {
+				return fmt.Errorf("invalid CIDR %q", cidr)
+			}
+		}
+	}
+	for _, countries := range [][]string{r.AllowCountries, r.DenyCountries} {
+		for _, country := range countries {
+			code := strings.ToUpper(strings.TrimSpace(country))
+			if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+				return fmt.Errorf("invalid country code %q (use ISO 3166-1 alpha-2, e.g. DE)", country)
+			}
+		}
+	}
+	return nil
+}
+
+// Proto returns the rules sent to the server, or nil when none are set.
+func (r IPRulesConfig) Proto() *tunnelv1.IPRules {
+	if !r.Enabled() {
+		return nil
+	}
+	return &tunnelv1.IPRules{
+		AllowCidrs:     r.AllowCIDRs,
+		DenyCidrs:      r.DenyCIDRs,
+		AllowCountries: r.AllowCountries,
+		DenyCountries:  r.DenyCountries,
+	}
+}
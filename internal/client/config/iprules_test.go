@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPRulesConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   IPRulesConfig
+		wantErr bool
+	}{
+		{name: "none", rules: IPRulesConfig{}},
+		{name: "cidrs", rules: IPRulesConfig{AllowCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}, DenyCIDRs: []string{"10.1.2.3"}}},
+		{name: "countries", rules: IPRulesConfig{AllowCountries: []string{"de", "NL"}, DenyCountries: []string{"RU"}}},
+		{name: "invalid cidr", rules: IPRulesConfig{AllowCIDRs: []string{"10.0.0.0/33"}}, wantErr: true},
+		{name: "hostname", rules: IPRulesConfig{DenyCIDRs: []string{"example.com"}}, wantErr: true},
+		{name: "invalid country", rules: IPRulesConfig{AllowCountries: []string{"Germany"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestIPRulesConfig_Proto(t *testing.T) {
+	assert.Nil(t, IPRulesConfig{}.Proto())
+
+	rules := IPRulesConfig{AllowCIDRs: []string{"10.0.0.0/8"}, DenyCountries: []string{"RU"}}.Proto()
+	require.NotNil(t, rules)
+	assert.Equal(t, []string{"10.0.0.0/8"}, rules.AllowCidrs)
+	assert.Equal(t, []string{"RU"}, rules.DenyCountries)
+	assert.Empty(t, rules.DenyCidrs)
+}
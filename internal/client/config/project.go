@@ -0,0 +1,319 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
+	"github.com/pandeptwidyaop/grok/pkg/utils"
+)
+
+// ProjectFile is the default name of the project config file.
+const ProjectFile = "grok.yml"
+
+// ProjectConfig is a project config file: client settings plus named tunnels.
+// Settings in the project file override the user config.
+type ProjectConfig struct {
+	Config  `mapstructure:",squash"`
+	Tunnels map[string]TunnelConfig `mapstructure:"tunnels"`
+}
+
+// TunnelConfig holds the settings of one tunnel in a project file.
+type TunnelConfig struct {
+	Proto     string `mapstructure:"proto"`     // http, https, tcp, tls or udp (default: http)
+	Addr      string `mapstructure:"addr"`      // Local port or host:port (http: also https://host:port or unix:///path)
+	Subdomain string `mapstructure:"subdomain"` // Optional: custom subdomain
+	Name      string `mapstructure:"name"`      // Optional: persistent tunnel name
+	Group     string `mapstructure:"group"`     // Optional: join a load-balanced group under name (round-robin, least-inflight, sticky)
+
+	Routes      []RouteConfig     `mapstructure:"routes"`       // Optional: send matching HTTP requests to other local upstreams
+	UpstreamTLS UpstreamTLSConfig `mapstructure:"upstream_tls"` // Optional: TLS settings for https:// upstreams
+	Rewrite     RewriteConfig     `mapstructure:",squash"`      // Optional: host_header and disable_url_rewrite
+	Access      AccessConfig      `mapstructure:"access"`       // Optional: who may reach the tunnel (basic auth, OIDC, share links)
+	IPRules     IPRulesConfig     `mapstructure:"ip_rules"`     // Optional: networks and countries allowed or denied (http, https, tcp)
+	Headers     HeaderRulesConfig `mapstructure:"headers"`      // Optional: request and response headers added, set or removed by the server
+	Edge        EdgeConfig        `mapstructure:",squash"`      // Optional: compress and cache (http, https)
+
+	ProxyProtocol string `mapstructure:"proxy_protocol"` // Optional: PROXY header (v1 or v2) sent to the local service (tcp)
+	HTTP2         bool   `mapstructure:"http2"`          // Optional: speak HTTP/2 to the local service, h2c for plain addresses, e.g. gRPC (http, https)
+	MaxFlows      int    `mapstructure:"max_flows"`      // Optional: local sockets kept open, one per public peer (udp, default 1024)
+}
+
+// loadBalancing maps tunnel group strategy names to their protocol values.
+var loadBalancing = map[string]tunnelv1.LoadBalancing{
+	"round-robin":    tunnelv1.LoadBalancing_ROUND_ROBIN,
+	"least-inflight": tunnelv1.LoadBalancing_LEAST_INFLIGHT,
+	"sticky":         tunnelv1.LoadBalancing_STICKY,
+}
+
+// ParseLoadBalancing converts a tunnel group strategy name to its protocol
+// value. An empty name means the tunnel is not grouped.
+func ParseLoadBalancing(name string) (tunnelv1.LoadBalancing, error) {
+	if name == "" {
+		return tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED, nil
+	}
+	if lb, ok := loadBalancing[name]; ok {
+		return lb, nil
+	}
+	return tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED,
+		fmt.Errorf("unsupported group strategy %q (use round-robin, least-inflight or sticky)", name)
+}
+
+// LocalAddr returns the local address, expanding a bare port to localhost:port.
+func (t TunnelConfig) LocalAddr() string {
+	if port, err := strconv.Atoi(t.Addr); err == nil {
+		return fmt.Sprintf("localhost:%d", port)
+	}
+	return t.Addr
+}
+
+// LoadProject loads the project file at projectPath on top of the user config
+// at configPath (or the default location when empty).
+func LoadProject(projectPath, configPath string) (*ProjectConfig, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	if err := readUserConfig(v, configPath); err != nil {
+		return nil, err
+	}
+
+	if projectPath == "" {
+		projectPath = ProjectFile
+	}
+	v.SetConfigFile(projectPath)
+	if err := v.MergeInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read project file: %w", err)
+	}
+
+	v.SetEnvPrefix("GROK")
+	v.AutomaticEnv()
+
+	var cfg ProjectConfig
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal project file: %w", err)
+	}
+
+	for name, tun := range cfg.Tunnels {
+		if tun.Proto == "" {
+			tun.Proto = "http"
+			cfg.Tunnels[name] = tun
+		}
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks every tunnel and reports all problems at once.
+func (p *ProjectConfig) Validate() error {
+	if len(p.Tunnels) == 0 {
+		return errors.New("no tunnels defined")
+	}
+
+	var errs []error
+	subdomains := make(map[string]string)
+	names := make(map[string]string)
+
+	for _, key := range p.TunnelNames() {
+		tun := p.Tunnels[key]
+
+		switch tun.Proto {
+		case "http", "https", "tcp", "tls", "udp":
+		default:
+			errs = append(errs, fmt.Errorf("tunnel %q: unsupported proto %q (use http, https, tcp, tls or udp)", key, tun.Proto))
+		}
+
+		if tun.Proto == "http" || tun.Proto == "https" {
+			if err := validateUpstreamAddr(tun.Addr); err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %q: %w", key, err))
+			} else if _, err := ParseUpstream(tun.Addr, tun.UpstreamTLS); err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %q: %w", key, err))
+			}
+		} else if err := validateLocalAddr(tun.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel %q: %w", key, err))
+		}
+
+		if tun.Subdomain != "" {
+			sub := utils.NormalizeSubdomain(tun.Subdomain)
+			if !utils.IsValidSubdomain(sub) {
+				errs = append(errs, fmt.Errorf("tunnel %q: invalid subdomain %q", key, tun.Subdomain))
+			} else if other, ok := subdomains[sub]; ok {
+				errs = append(errs, fmt.Errorf("tunnel %q: subdomain %q already used by tunnel %q", key, tun.Subdomain, other))
+			} else {
+				subdomains[sub] = key
+			}
+		}
+
+		if tun.Name != "" {
+			name := utils.NormalizeSubdomain(tun.Name)
+			if !utils.IsValidSubdomain(name) {
+				errs = append(errs, fmt.Errorf("tunnel %q: invalid name %q (min 3 chars, letters, digits and hyphens)", key, tun.Name))
+			} else if other, ok := names[name]; ok {
+				errs = append(errs, fmt.Errorf("tunnel %q: name %q already used by tunnel %q", key, tun.Name, other))
+			} else {
+				names[name] = key
+			}
+		}
+
+		if tun.Group != "" {
+			if _, err := ParseLoadBalancing(tun.Group); err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %q: %w", key, err))
+			}
+			if tun.Name == "" {
+				errs = append(errs, fmt.Errorf("tunnel %q: group requires a name shared by the group members", key))
+			}
+			if tun.Proto != "http" && tun.Proto != "https" {
+				errs = append(errs, fmt.Errorf("tunnel %q: groups are only supported for http and https tunnels", key))
+			}
+		}
+
+		if len(tun.Routes) > 0 && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: routes are only supported for http and https tunnels", key))
+		}
+		if tun.Rewrite != (RewriteConfig{}) && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: host_header and disable_url_rewrite are only supported for http and https tunnels", key))
+		}
+		if tun.Access.Enabled() && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: access is only supported for http and https tunnels", key))
+		}
+		if err := tun.Access.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel %q: access: %w", key, err))
+		}
+		if tun.IPRules.Enabled() && tun.Proto != "http" && tun.Proto != "https" && tun.Proto != "tcp" {
+			errs = append(errs, fmt.Errorf("tunnel %q: ip_rules are only supported for http, https and tcp tunnels", key))
+		}
+		if err := tun.IPRules.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel %q: ip_rules: %w", key, err))
+		}
+		if tun.Headers.Enabled() && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: headers are only supported for http and https tunnels", key))
+		}
+		if err := tun.Headers.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel %q: headers: %w", key, err))
+		}
+		if tun.Edge.Enabled() && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: compress and cache are only supported for http and https tunnels", key))
+		}
+		if tun.HTTP2 && tun.Proto != "http" && tun.Proto != "https" {
+			errs = append(errs, fmt.Errorf("tunnel %q: http2 is only supported for http and https tunnels", key))
+		}
+		if tun.MaxFlows != 0 && tun.Proto != "udp" {
+			errs = append(errs, fmt.Errorf("tunnel %q: max_flows is only supported for udp tunnels", key))
+		}
+		if tun.MaxFlows < 0 {
+			errs = append(errs, fmt.Errorf("tunnel %q: max_flows must not be negative", key))
+		}
+		if tun.ProxyProtocol != "" {
+			if tun.Proto != "tcp" {
+				errs = append(errs, fmt.Errorf("tunnel %q: proxy_protocol is only supported for tcp tunnels", key))
+			}
+			if _, err := proxyproto.ParseVersion(tun.ProxyProtocol); err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %q: %w", key, err))
+			}
+		}
+		for i, route := range tun.Routes {
+			if err := route.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %q: route %d: %w", key, i+1, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// TunnelNames returns the tunnel names in sorted order.
+func (p *ProjectConfig) TunnelNames() []string {
+	names := make([]string, 0, len(p.Tunnels))
+	for name := range p.Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the names of the requested tunnels, or all tunnels when all is set.
+func (p *ProjectConfig) Select(names []string, all bool) ([]string, error) {
+	if all {
+		return p.TunnelNames(), nil
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no tunnels selected; pass tunnel names or --all")
+	}
+
+	seen := make(map[string]bool, len(names))
+	selected := make([]string, 0, len(names))
+	var unknown []string
+	for _, name := range names {
+		if _, ok := p.Tunnels[name]; !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			selected = append(selected, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown tunnel(s): %s (defined: %s)",
+			strings.Join(unknown, ", "), strings.Join(p.TunnelNames(), ", "))
+	}
+
+	return selected, nil
+}
+
+// validateLocalAddr checks that addr is a port or host:port.
+func validateLocalAddr(addr string) error {
+	if addr == "" {
+		return errors.New("addr is required")
+	}
+
+	port := addr
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		port = p
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid addr %q (use a port or host:port)", addr)
+	}
+	return nil
+}
+
+// readUserConfig reads the user config into v. A missing config file is not an error.
+func readUserConfig(v *viper.Viper, configPath string) error {
+	// If config path is provided, use it
+	if configPath != "" {
+		v.SetConfigFile(configPath)
+	} else {
+		// Look for config in default locations
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get user home dir: %w", err)
+		}
+
+		configDir := filepath.Join(home, ".grok")
+		v.AddConfigPath(configDir)
+		v.AddConfigPath(".")
+		v.SetConfigName("config")
+		v.SetConfigType("yaml")
+	}
+
+	// Read config file (optional)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		// Config file not found, use defaults
+	}
+
+	return nil
+}
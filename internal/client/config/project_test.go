@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeProjectFile writes a project file to a temporary directory.
+func writeProjectFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), ProjectFile)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// TestLoadProject tests loading a project file on top of the user config.
+func TestLoadProject(t *testing.T) {
+	tmpDir := t.TempDir()
+	userConfig := filepath.Join(tmpDir, "config.yaml")
+	require.NoError(t, os.WriteFile(userConfig, []byte(`
+server:
+  addr: "tunnel.example.com:443"
+auth:
+  token: "grok_user_token"
+dashboard:
+  port: 5000
+`), 0o600))
+
+	projectFile := writeProjectFile(t, `
+server:
+  addr: "project.example.com:443"
+tunnels:
+  web:
+    addr: 3000
+    subdomain: myapp
+    host_header: preserve
+    compress: true
+    cache: true
+    access:
+      basic_auth: ["alice:s3cret"]
+      oidc_domains: [example.com]
+      share_links: true
+    routes:
+      - path: /api
+        addr: 8080
+      - path: /ws
+        strip_prefix: true
+        addr: "localhost:9000"
+  db:
+    proto: tcp
+    addr: "db.local:5432"
+    name: my-db
+`)
+
+	cfg, err := LoadProject(projectFile, userConfig)
+	require.NoError(t, err)
+
+	// Project settings override the user config, the rest is inherited
+	assert.Equal(t, "project.example.com:443", cfg.Server.Addr)
+	assert.Equal(t, "grok_user_token", cfg.Auth.Token)
+	assert.Equal(t, 5000, cfg.Dashboard.Port)
+	assert.True(t, cfg.Reconnect.Enabled)
+
+	require.Len(t, cfg.Tunnels, 2)
+	assert.Equal(t, "http", cfg.Tunnels["web"].Proto)
+	assert.Equal(t, "localhost:3000", cfg.Tunnels["web"].LocalAddr())
+	assert.Equal(t, "myapp", cfg.Tunnels["web"].Subdomain)
+	assert.Equal(t, []RouteConfig{
+		{Path: "/api", Addr: "8080"},
+		{Path: "/ws", StripPrefix: true, Addr: "localhost:9000"},
+	}, cfg.Tunnels["web"].Routes)
+	assert.Equal(t, RewriteConfig{HostHeader: HostHeaderPreserve}, cfg.Tunnels["web"].Rewrite)
+	assert.Equal(t, EdgeConfig{Compress: true, Cache: true}, cfg.Tunnels["web"].Edge)
+	assert.Equal(t, AccessConfig{
+		BasicAuth:   []string{"alice:s3cret"},
+		OIDCDomains: []string{"example.com"},
+		ShareLinks:  true,
+	}, cfg.Tunnels["web"].Access)
+	assert.Equal(t, "tcp", cfg.Tunnels["db"].Proto)
+	assert.Equal(t, "db.local:5432", cfg.Tunnels["db"].LocalAddr())
+	assert.Equal(t, "my-db", cfg.Tunnels["db"].Name)
+
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, []string{"db", "web"}, cfg.TunnelNames())
+}
+
+// TestLoadProjectMissingFile tests that a missing project file is an error.
+func TestLoadProjectMissingFile(t *testing.T) {
+	_, err := LoadProject(filepath.Join(t.TempDir(), ProjectFile), filepath.Join(t.TempDir(), "config.yaml"))
+	assert.Error(t, err)
+}
+
+// TestProjectValidate tests that all problems are reported at once.
+func TestProjectValidate(t *testing.T) {
+	cfg := &ProjectConfig{Tunnels: map[string]TunnelConfig{
+		"web":   {Proto: "http", Addr: "3000", Subdomain: "shared"},
+		"api":   {Proto: "http", Addr: "3001", Subdomain: "shared"},
+		"ftp":   {Proto: "ftp", Addr: "21"},
+		"bad":   {Proto: "tcp", Addr: "localhost:http"},
+		"none":  {Proto: "http"},
+		"name":  {Proto: "tcp", Addr: "22", Name: "x"},
+		"lb":    {Proto: "http", Addr: "3002", Group: "random"},
+		"tlb":   {Proto: "tcp", Addr: "23", Name: "ssh-pool", Group: "round-robin"},
+		"slb":   {Proto: "tls", Addr: "8443", Name: "demo-pool", Group: "sticky"},
+		"ulb":   {Proto: "udp", Addr: "53", Name: "dns-pool", Group: "round-robin"},
+		"rt":    {Proto: "http", Addr: "3003", Routes: []RouteConfig{{Path: "/api", Addr: "8080"}, {Path: "api", Addr: "8081"}}},
+		"trt":   {Proto: "tcp", Addr: "24", Routes: []RouteConfig{{Path: "/api", Addr: "8080"}}},
+		"sec":   {Proto: "http", Addr: "https://localhost:8443", UpstreamTLS: UpstreamTLSConfig{Insecure: true}},
+		"sock":  {Proto: "http", Addr: "unix:///run/app.sock"},
+		"tsock": {Proto: "tcp", Addr: "unix:///run/app.sock"},
+		"thost": {Proto: "tcp", Addr: "25", Rewrite: RewriteConfig{HostHeader: "myapp.test"}},
+		"tacl":  {Proto: "tcp", Addr: "26", Access: AccessConfig{ShareLinks: true}},
+		"acl":   {Proto: "http", Addr: "3004", Access: AccessConfig{BasicAuth: []string{"alice"}}},
+		"tip":   {Proto: "tcp", Addr: "27", IPRules: IPRulesConfig{AllowCIDRs: []string{"10.0.0.0/8"}}},
+		"uip":   {Proto: "udp", Addr: "54", IPRules: IPRulesConfig{DenyCIDRs: []string{"10.0.0.0/8"}}},
+		"bip":   {Proto: "http", Addr: "3005", IPRules: IPRulesConfig{AllowCountries: []string{"Germany"}}},
+		"thdr":  {Proto: "tcp", Addr: "28", Headers: HeaderRulesConfig{RequestRemove: []string{"Cookie"}}},
+		"bhdr":  {Proto: "http", Addr: "3006", Headers: HeaderRulesConfig{ResponseSet: []string{"X-Robots-Tag"}}},
+		"tedge": {Proto: "tcp", Addr: "29", Edge: EdgeConfig{Cache: true}},
+		"pp":    {Proto: "tcp", Addr: "5432", ProxyProtocol: "v2"},
+		"hpp":   {Proto: "http", Addr: "3007", ProxyProtocol: "v1"},
+		"bpp":   {Proto: "tcp", Addr: "25", ProxyProtocol: "v3"},
+		"grpc":  {Proto: "http", Addr: "50051", HTTP2: true},
+		"th2":   {Proto: "tcp", Addr: "50052", HTTP2: true},
+		"uflow": {Proto: "udp", Addr: "55", MaxFlows: 64},
+		"tflow": {Proto: "tcp", Addr: "30", MaxFlows: 64},
+		"bflow": {Proto: "udp", Addr: "56", MaxFlows: -1},
+	}}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `subdomain "shared" already used`)
+	assert.Contains(t, err.Error(), `unsupported proto "ftp"`)
+	assert.Contains(t, err.Error(), `invalid addr "localhost:http"`)
+	assert.Contains(t, err.Error(), `tunnel "none": addr is required`)
+	assert.Contains(t, err.Error(), `invalid name "x"`)
+	assert.Contains(t, err.Error(), `tunnel "lb": unsupported group strategy "random"`)
+	assert.Contains(t, err.Error(), `tunnel "lb": group requires a name`)
+	assert.Contains(t, err.Error(), `tunnel "tlb": groups are only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "slb": groups are only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "ulb": groups are only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "rt": route 2: route path "api" must start with /`)
+	assert.NotContains(t, err.Error(), `tunnel "rt": route 1`)
+	assert.Contains(t, err.Error(), `tunnel "trt": routes are only supported for http and https tunnels`)
+	assert.NotContains(t, err.Error(), `tunnel "sec"`)
+	assert.NotContains(t, err.Error(), `tunnel "sock"`)
+	assert.Contains(t, err.Error(), `tunnel "tsock": invalid addr`)
+	assert.Contains(t, err.Error(), `tunnel "thost": host_header and disable_url_rewrite are only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "tacl": access is only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "acl": access: invalid basic auth "alice"`)
+	assert.NotContains(t, err.Error(), `tunnel "tip"`)
+	assert.Contains(t, err.Error(), `tunnel "uip": ip_rules are only supported for http, https and tcp tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "bip": ip_rules: invalid country code "Germany"`)
+	assert.Contains(t, err.Error(), `tunnel "thdr": headers are only supported for http and https tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "bhdr": headers: invalid header "X-Robots-Tag"`)
+	assert.Contains(t, err.Error(), `tunnel "tedge": compress and cache are only supported for http and https tunnels`)
+	assert.NotContains(t, err.Error(), `tunnel "pp"`)
+	assert.Contains(t, err.Error(), `tunnel "hpp": proxy_protocol is only supported for tcp tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "bpp": invalid PROXY protocol version "v3"`)
+	assert.NotContains(t, err.Error(), `tunnel "grpc"`)
+	assert.Contains(t, err.Error(), `tunnel "th2": http2 is only supported for http and https tunnels`)
+	assert.NotContains(t, err.Error(), `tunnel "uflow"`)
+	assert.Contains(t, err.Error(), `tunnel "tflow": max_flows is only supported for udp tunnels`)
+	assert.Contains(t, err.Error(), `tunnel "bflow": max_flows must not be negative`)
+	assert.NotContains(t, err.Error(), `unsupported proto "tls"`)
+	assert.NotContains(t, err.Error(), `unsupported proto "udp"`)
+
+	assert.Error(t, (&ProjectConfig{}).Validate())
+}
+
+// TestProjectSelect tests selecting tunnels by name.
+func TestProjectSelect(t *testing.T) {
+	cfg := &ProjectConfig{Tunnels: map[string]TunnelConfig{
+		"web": {Proto: "http", Addr: "3000"},
+		"api": {Proto: "http", Addr: "3001"},
+		"db":  {Proto: "tcp", Addr: "5432"},
+	}}
+
+	names, err := cfg.Select(nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "db", "web"}, names)
+
+	names, err = cfg.Select([]string{"web", "db", "web"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web", "db"}, names)
+
+	_, err = cfg.Select([]string{"web", "cache"}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cache")
+
+	_, err = cfg.Select(nil, false)
+	assert.Error(t, err)
+}
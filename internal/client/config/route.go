@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RouteConfig sends the requests of an HTTP tunnel that match it to another
+// local upstream. A request must match every condition that is set; the rules
+// of a tunnel are tried in order and the first match wins.
+type RouteConfig struct {
+	Path        string `mapstructure:"path"`         // Path prefix, e.g. /api (matches /api and /api/...)
+	Host        string `mapstructure:"host"`         // Host header, port ignored
+	Header      string `mapstructure:"header"`       // Header name, or "Name: value" to match its value
+	StripPrefix bool   `mapstructure:"strip_prefix"` // Remove Path before forwarding
+	Addr        string `mapstructure:"addr"`         // Local upstream: port, host:port, https://host:port or unix:///path
+}
+
+// LocalAddr returns the upstream address, expanding a bare port to localhost:port.
+func (r RouteConfig) LocalAddr() string {
+	if port, err := strconv.Atoi(r.Addr); err == nil {
+		return fmt.Sprintf("localhost:%d", port)
+	}
+	return r.Addr
+}
+
+// HeaderMatch returns the header name and value of the header condition. An
+// empty value matches any value.
+func (r RouteConfig) HeaderMatch() (string, string) {
+	name, value, _ := strings.Cut(r.Header, ":")
+	return strings.TrimSpace(name), strings.TrimSpace(value)
+}
+
+// Validate checks that the rule has a condition and a valid upstream.
+func (r RouteConfig) Validate() error {
+	if r.Path == "" && r.Host == "" && r.Header == "" {
+		return errors.New("route needs a path, host or header")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("route path %q must start with /", r.Path)
+	}
+	if r.StripPrefix && r.Path == "" {
+		return errors.New("route strip_prefix requires a path")
+	}
+	if name, _ := r.HeaderMatch(); r.Header != "" && name == "" {
+		return fmt.Errorf("invalid route header %q", r.Header)
+	}
+	return validateUpstreamAddr(r.Addr)
+}
+
+// ParseRoute parses a route rule given on the command line:
+//
+//	MATCH[,MATCH...]=ADDR
+//
+// where MATCH is a path prefix (/api), host:NAME, header:NAME or
+// header:NAME:VALUE, or strip to remove the path prefix before forwarding.
+func ParseRoute(spec string) (RouteConfig, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return RouteConfig{}, fmt.Errorf("invalid route %q (use MATCH=ADDR, e.g. /api=8080)", spec)
+	}
+
+	route := RouteConfig{Addr: spec[i+1:]}
+	for _, match := range strings.Split(spec[:i], ",") {
+		switch {
+		case strings.HasPrefix(match, "/"):
+			route.Path = match
+		case strings.HasPrefix(match, "host:"):
+			route.Host = strings.TrimPrefix(match, "host:")
+		case strings.HasPrefix(match, "header:"):
+			route.Header = strings.TrimPrefix(match, "header:")
+		case match == "strip":
+			route.StripPrefix = true
+		default:
+			return RouteConfig{}, fmt.Errorf("invalid route %q: unknown match %q", spec, match)
+		}
+	}
+
+	if err := route.Validate(); err != nil {
+		return RouteConfig{}, fmt.Errorf("invalid route %q: %w", spec, err)
+	}
+	return route, nil
+}
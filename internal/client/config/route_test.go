@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseRoute tests parsing route rules from the command line.
+func TestParseRoute(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RouteConfig
+		wantErr string
+	}{
+		{spec: "/api=8080", want: RouteConfig{Path: "/api", Addr: "8080"}},
+		{spec: "/ws,strip=localhost:9000", want: RouteConfig{Path: "/ws", StripPrefix: true, Addr: "localhost:9000"}},
+		{spec: "host:admin.localhost=4000", want: RouteConfig{Host: "admin.localhost", Addr: "4000"}},
+		{spec: "header:X-Version:2=8081", want: RouteConfig{Header: "X-Version:2", Addr: "8081"}},
+		{spec: "/api,header:X-Beta=8082", want: RouteConfig{Path: "/api", Header: "X-Beta", Addr: "8082"}},
+		{spec: "/api", wantErr: "use MATCH=ADDR"},
+		{spec: "api=8080", wantErr: `unknown match "api"`},
+		{spec: "strip=8080", wantErr: "needs a path, host or header"},
+		{spec: "host:a.localhost,strip=8080", wantErr: "strip_prefix requires a path"},
+		{spec: "/api=http", wantErr: "invalid addr"},
+		{spec: "header::2=8080", wantErr: "invalid route header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			route, err := ParseRoute(tt.spec)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, route)
+		})
+	}
+}
+
+// TestRouteConfig tests the derived route settings.
+func TestRouteConfig(t *testing.T) {
+	assert.Equal(t, "localhost:8080", RouteConfig{Addr: "8080"}.LocalAddr())
+	assert.Equal(t, "127.0.0.1:8080", RouteConfig{Addr: "127.0.0.1:8080"}.LocalAddr())
+
+	name, value := RouteConfig{Header: " X-Version : 2 "}.HeaderMatch()
+	assert.Equal(t, "X-Version", name)
+	assert.Equal(t, "2", value)
+
+	name, value = RouteConfig{Header: "X-Beta"}.HeaderMatch()
+	assert.Equal(t, "X-Beta", name)
+	assert.Empty(t, value)
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pandeptwidyaop/grok/pkg/pool"
+)
+
+// UpstreamTLSConfig holds the TLS settings for https:// local upstreams.
+type UpstreamTLSConfig struct {
+	Insecure   bool   `mapstructure:"insecure"`    // Skip certificate verification, e.g. for self-signed certs
+	CAFile     string `mapstructure:"ca_file"`     // Optional: CA that signed the upstream certificate (PEM)
+	ServerName string `mapstructure:"server_name"` // Optional: SNI and verified name (default: upstream host)
+}
+
+// HostHeaderPreserve sends the public Host of a request to the local upstream.
+const HostHeaderPreserve = "preserve"
+
+// RewriteConfig controls how an HTTP tunnel rewrites requests and responses
+// between its public URL and the local upstream.
+type RewriteConfig struct {
+	HostHeader        string `mapstructure:"host_header"`         // Host sent upstream: empty for the upstream address, "preserve" for the public host, or a fixed value
+	DisableURLRewrite bool   `mapstructure:"disable_url_rewrite"` // Keep local URLs in Location, Content-Location and Set-Cookie domains
+}
+
+// ParseUpstream parses the address of a local HTTP upstream (a port,
+// host:port, https://host:port or unix:///path) and applies tlsCfg when it
+// speaks TLS.
+func ParseUpstream(addr string, tlsCfg UpstreamTLSConfig) (pool.Upstream, error) {
+	if port, err := strconv.Atoi(addr); err == nil {
+		addr = fmt.Sprintf("localhost:%d", port)
+	}
+
+	upstream, err := pool.ParseUpstream(addr)
+	if err != nil || upstream.TLS == nil {
+		return upstream, err
+	}
+
+	upstream.TLS.InsecureSkipVerify = tlsCfg.Insecure //nolint:gosec // Opt-in for self-signed local services
+	if tlsCfg.ServerName != "" {
+		upstream.TLS.ServerName = tlsCfg.ServerName
+	}
+	if tlsCfg.CAFile != "" {
+		caCert, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return pool.Upstream{}, fmt.Errorf("failed to read upstream CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caCert) {
+			return pool.Upstream{}, fmt.Errorf("failed to parse upstream CA file %s", tlsCfg.CAFile)
+		}
+		upstream.TLS.RootCAs = roots
+	}
+
+	return upstream, nil
+}
+
+// validateUpstreamAddr checks that addr is a valid local HTTP upstream.
+func validateUpstreamAddr(addr string) error {
+	if addr == "" {
+		return errors.New("addr is required")
+	}
+	upstream, err := ParseUpstream(addr, UpstreamTLSConfig{})
+	if err != nil {
+		return fmt.Errorf("invalid addr %q (use a port, host:port, https://host:port or unix:///path)", addr)
+	}
+	if upstream.Network == "tcp" {
+		// Checks the port range
+		return validateLocalAddr(upstream.Address)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseUpstream tests applying the upstream TLS settings.
+func TestParseUpstream(t *testing.T) {
+	upstream, err := ParseUpstream("3000", UpstreamTLSConfig{Insecure: true})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:3000", upstream.Address)
+	assert.Nil(t, upstream.TLS, "TLS settings only apply to https:// upstreams")
+
+	upstream, err = ParseUpstream("https://localhost:8443", UpstreamTLSConfig{Insecure: true, ServerName: "app.local"})
+	require.NoError(t, err)
+	require.NotNil(t, upstream.TLS)
+	assert.True(t, upstream.TLS.InsecureSkipVerify)
+	assert.Equal(t, "app.local", upstream.TLS.ServerName)
+
+	dir := t.TempDir()
+	_, err = ParseUpstream("https://localhost:8443", UpstreamTLSConfig{CAFile: filepath.Join(dir, "missing.pem")})
+	assert.ErrorContains(t, err, "failed to read upstream CA file")
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0o600))
+	_, err = ParseUpstream("https://localhost:8443", UpstreamTLSConfig{CAFile: invalid})
+	assert.ErrorContains(t, err, "failed to parse upstream CA file")
+}
+
+// TestValidateUpstreamAddr tests the accepted HTTP upstream addresses.
+func TestValidateUpstreamAddr(t *testing.T) {
+	for _, addr := range []string{"3000", "localhost:3000", "https://localhost:8443", "https://app.local", "unix:///run/app.sock"} {
+		assert.NoError(t, validateUpstreamAddr(addr), addr)
+	}
+	for _, addr := range []string{"", "localhost", "localhost:99999", "ftp://localhost:21", "unix://"} {
+		assert.Error(t, validateUpstreamAddr(addr), addr)
+	}
+}
@@ -33,12 +33,18 @@ func respondError(w http.ResponseWriter, status int, message string) {
 func (s *Server) HandleStatus(w http.ResponseWriter, _ *http.Request) {
 	s.mu.RLock()
 	status := s.tunnelStatus
+	status.Tunnels = append([]TunnelStatus(nil), status.Tunnels...)
 	s.mu.RUnlock()
 
 	// Calculate uptime if connected
 	if status.Connected && !status.ConnectedAt.IsZero() {
 		status.Uptime = int64(time.Since(status.ConnectedAt).Seconds())
 	}
+	for i := range status.Tunnels {
+		if status.Tunnels[i].Connected && !status.Tunnels[i].ConnectedAt.IsZero() {
+			status.Tunnels[i].Uptime = int64(time.Since(status.Tunnels[i].ConnectedAt).Seconds())
+		}
+	}
 
 	respondJSON(w, http.StatusOK, status)
 }
@@ -37,6 +37,8 @@ type RequestStartedEvent struct {
 	RemoteAddr string              `json:"remote_addr"`
 	Protocol   string              `json:"protocol"` // "http" or "tcp"
 	Headers    map[string][]string `json:"headers,omitempty"`
+	Tunnel     string              `json:"tunnel,omitempty"`   // Tunnel name when several tunnels share the dashboard
+	Upstream   string              `json:"upstream,omitempty"` // Local address that served the request (HTTP)
 }
 
 // RequestCompletedEvent contains data for request completion events.
@@ -53,6 +55,7 @@ type RequestCompletedEvent struct {
 
 // ConnectionEvent contains data for connection state changes.
 type ConnectionEvent struct {
+	Name      string `json:"name,omitempty"` // Tunnel name, if any
 	TunnelID  string `json:"tunnel_id"`
 	PublicURL string `json:"public_url"`
 	LocalAddr string `json:"local_addr"`
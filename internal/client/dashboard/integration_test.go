@@ -200,6 +200,60 @@ func TestDashboardIntegration(t *testing.T) {
 		}
 	})
 
+	t.Run("Multiple tunnel status", func(t *testing.T) {
+		for _, name := range []string{"web", "api"} {
+			server.eventCollector.Publish(events.Event{
+				Type:      events.EventConnectionEstablished,
+				Timestamp: time.Now(),
+				Data: events.ConnectionEvent{
+					Name:      name,
+					TunnelID:  "tunnel-" + name,
+					PublicURL: "https://" + name + ".example.com",
+					LocalAddr: "localhost:3000",
+					Protocol:  "http",
+				},
+			})
+		}
+
+		server.eventCollector.Publish(events.Event{
+			Type:      events.EventConnectionLost,
+			Timestamp: time.Now(),
+			Data:      events.ConnectionEvent{TunnelID: "tunnel-web"},
+		})
+
+		// Wait for processing
+		time.Sleep(50 * time.Millisecond)
+
+		req := httptest.NewRequest("GET", "/api/status", nil)
+		w := httptest.NewRecorder()
+		server.HandleStatus(w, req)
+
+		var status TunnelStatus
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+
+		if !status.Connected {
+			t.Error("Expected status to be connected while a tunnel is up")
+		}
+
+		connected := make(map[string]bool)
+		for _, tunnel := range status.Tunnels {
+			connected[tunnel.Name] = tunnel.Connected
+		}
+
+		// Includes the tunnel from "Connection status updates"
+		if len(status.Tunnels) != 3 {
+			t.Fatalf("Expected 3 tunnels, got %d", len(status.Tunnels))
+		}
+		if connected["web"] {
+			t.Error("Expected tunnel 'web' to be disconnected")
+		}
+		if !connected["api"] {
+			t.Error("Expected tunnel 'api' to be connected")
+		}
+	})
+
 	t.Run("Clear functionality", func(t *testing.T) {
 		// Clear dashboard
 		req := httptest.NewRequest("POST", "/api/clear", nil)
@@ -23,14 +23,19 @@ type Config struct {
 }
 
 // TunnelStatus represents the current tunnel connection status.
+// When several tunnels share the dashboard, the top-level fields describe the
+// most recently connected tunnel and Tunnels lists all of them.
 type TunnelStatus struct {
 	Connected   bool      `json:"connected"`
+	Name        string    `json:"name,omitempty"`
 	TunnelID    string    `json:"tunnel_id,omitempty"`
 	PublicURL   string    `json:"public_url,omitempty"`
 	LocalAddr   string    `json:"local_addr,omitempty"`
 	Protocol    string    `json:"protocol,omitempty"`
 	Uptime      int64     `json:"uptime_seconds"`
 	ConnectedAt time.Time `json:"connected_at,omitempty"`
+
+	Tunnels []TunnelStatus `json:"tunnels,omitempty"`
 }
 
 // Server is the dashboard HTTP server.
@@ -42,6 +47,7 @@ type Server struct {
 	metricsAgg     *metrics.Aggregator
 	sseBroker      *SSEBroker
 	tunnelStatus   TunnelStatus
+	tunnels        []TunnelStatus // Status of each tunnel, in connection order
 	startTime      time.Time
 	mu             sync.RWMutex
 	running        bool
@@ -233,37 +239,86 @@ func (s *Server) processEvents() {
 			data, ok := event.Data.(events.ConnectionEvent)
 			if ok {
 				s.mu.Lock()
-				s.tunnelStatus = TunnelStatus{
+				s.setTunnelStatus(TunnelStatus{
 					Connected:   true,
+					Name:        data.Name,
 					TunnelID:    data.TunnelID,
 					PublicURL:   data.PublicURL,
 					LocalAddr:   data.LocalAddr,
 					Protocol:    data.Protocol,
 					ConnectedAt: event.Timestamp,
-				}
+				})
+				status := s.tunnelStatus
 				s.mu.Unlock()
 
 				s.sseBroker.Broadcast(SSEEvent{
 					Type: "connection_established",
-					Data: s.tunnelStatus,
+					Data: status,
 				})
 			}
 
 		case events.EventConnectionLost:
+			data, _ := event.Data.(events.ConnectionEvent)
+
 			s.mu.Lock()
-			s.tunnelStatus.Connected = false
+			s.setTunnelLost(data.TunnelID)
+			connected := s.tunnelStatus.Connected
 			s.mu.Unlock()
 
 			s.sseBroker.Broadcast(SSEEvent{
 				Type: "connection_lost",
-				Data: map[string]bool{
-					"connected": false,
+				Data: map[string]interface{}{
+					"connected": connected,
+					"tunnel_id": data.TunnelID,
 				},
 			})
 		}
 	}
 }
 
+// setTunnelStatus records a connected tunnel. Must be called with s.mu held.
+func (s *Server) setTunnelStatus(status TunnelStatus) {
+	replaced := false
+	for i := range s.tunnels {
+		if s.tunnels[i].TunnelID == status.TunnelID ||
+			(status.Name != "" && s.tunnels[i].Name == status.Name) {
+			s.tunnels[i] = status
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		s.tunnels = append(s.tunnels, status)
+	}
+
+	s.tunnelStatus = status
+	s.tunnelStatus.Tunnels = s.multiTunnelStatus()
+}
+
+// setTunnelLost marks a tunnel as disconnected, or all tunnels when tunnelID
+// is empty. Must be called with s.mu held.
+func (s *Server) setTunnelLost(tunnelID string) {
+	connected := false
+	for i := range s.tunnels {
+		if tunnelID == "" || s.tunnels[i].TunnelID == tunnelID {
+			s.tunnels[i].Connected = false
+		}
+		connected = connected || s.tunnels[i].Connected
+	}
+
+	s.tunnelStatus.Connected = connected
+	s.tunnelStatus.Tunnels = s.multiTunnelStatus()
+}
+
+// multiTunnelStatus returns a copy of the per-tunnel status, or nil with a
+// single tunnel. Must be called with s.mu held.
+func (s *Server) multiTunnelStatus() []TunnelStatus {
+	if len(s.tunnels) < 2 {
+		return nil
+	}
+	return append([]TunnelStatus(nil), s.tunnels...)
+}
+
 // broadcastMetrics periodically broadcasts metrics snapshots.
 func (s *Server) broadcastMetrics() {
 	ticker := time.NewTicker(5 * time.Second)
@@ -16,6 +16,8 @@ type RequestRecord struct {
 	Path            string              `json:"path"`
 	RemoteAddr      string              `json:"remote_addr"`
 	Protocol        string              `json:"protocol"` // "http" or "tcp"
+	Tunnel          string              `json:"tunnel,omitempty"`
+	Upstream        string              `json:"upstream,omitempty"`
 	StatusCode      int32               `json:"status_code"`
 	BytesIn         int64               `json:"bytes_in"`
 	BytesOut        int64               `json:"bytes_out"`
@@ -59,6 +61,8 @@ func (rs *RequestStore) RecordStart(event events.Event) {
 		Path:       data.Path,
 		RemoteAddr: data.RemoteAddr,
 		Protocol:   data.Protocol,
+		Tunnel:     data.Tunnel,
+		Upstream:   data.Upstream,
 		StartTime:  event.Timestamp,
 		Completed:  false,
 	}
@@ -143,6 +147,8 @@ func (rs *RequestStore) GetByID(id string) *RequestRecord {
 		Path:            record.Path,
 		RemoteAddr:      record.RemoteAddr,
 		Protocol:        record.Protocol,
+		Tunnel:          record.Tunnel,
+		Upstream:        record.Upstream,
 		StatusCode:      record.StatusCode,
 		BytesIn:         record.BytesIn,
 		BytesOut:        record.BytesOut,
@@ -123,6 +123,7 @@ func TestRequestStore_RecordStartAndCompletion(t *testing.T) {
 			Path:       "/api/users",
 			RemoteAddr: "192.168.1.1",
 			Protocol:   "http",
+			Upstream:   "localhost:8080",
 			Headers: map[string][]string{
 				"User-Agent": {"Mozilla/5.0"},
 			},
@@ -145,6 +146,10 @@ func TestRequestStore_RecordStartAndCompletion(t *testing.T) {
 		t.Errorf("expected path /api/users, got %s", record.Path)
 	}
 
+	if record.Upstream != "localhost:8080" {
+		t.Errorf("expected upstream localhost:8080, got %s", record.Upstream)
+	}
+
 	if record.Completed {
 		t.Error("request should not be marked as completed yet")
 	}
@@ -4,44 +4,136 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/textproto"
+	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/net/http/httpguts"
+
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/client/config"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 	"github.com/pandeptwidyaop/grok/pkg/pool"
 )
 
-// HTTPForwarder forwards HTTP requests to local service.
+// HTTPForwarder forwards HTTP requests to local service. Requests matching one
+// of its route rules go to the upstream of that rule instead.
 type HTTPForwarder struct {
-	localAddr  string
-	httpClient *http.Client // No timeout to support large file downloads via chunked transfer
-	connPool   *pool.ConnectionPool
-	bufferPool *pool.AdaptiveBufferPool
+	localAddr   string
+	upstream    pool.Upstream        // Where localAddr points: TCP, TLS or a Unix socket
+	rewrite     config.RewriteConfig // Host header and response URL rewriting
+	stripPrefix string               // Removed from request paths (upstreams of routes with strip_prefix)
+	httpClient  *http.Client         // No timeout to support large file downloads via chunked transfer
+	connPool    *pool.ConnectionPool
+	bufferPool  *pool.AdaptiveBufferPool
+	routes      []route          // Tried in order; the first match wins
+	upstreams   []*HTTPForwarder // Route upstreams owning their own connections
+}
+
+// TrailerReader is a streamed request body whose trailers are known once it
+// has been read to the end.
+type TrailerReader interface {
+	io.Reader
+	Trailer() http.Header
+}
+
+// trailerBody copies the trailers of a streamed request body to the request
+// when the body ends, before the transport sends them.
+type trailerBody struct {
+	TrailerReader
+	trailer http.Header
+}
+
+// Read implements io.Reader.
+func (b *trailerBody) Read(p []byte) (int, error) {
+	n, err := b.TrailerReader.Read(p)
+	if err == io.EOF {
+		for name, values := range b.TrailerReader.Trailer() {
+			b.trailer[name] = values
+		}
+	}
+	return n, err
+}
+
+// NewHTTPForwarder creates a new HTTP forwarder for localAddr (host:port,
+// https://host:port or unix:///path), sending the requests that match routes
+// to their upstreams. upstreamTLS, rewrite and http2 apply to every upstream:
+// with http2, requests use HTTP/2, negotiated by ALPN on https:// upstreams
+// and with prior knowledge (h2c) on the others, e.g. for gRPC services.
+func NewHTTPForwarder(localAddr string, upstreamTLS config.UpstreamTLSConfig, rewrite config.RewriteConfig, http2 bool, cfg config.PerformanceConfig, routes ...config.RouteConfig) (*HTTPForwarder, error) {
+	f, err := newHTTPForwarder(localAddr, upstreamTLS, rewrite, http2, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Routes to the same upstream share its connections
+	byAddr := map[string]*HTTPForwarder{localAddr: f}
+	for _, rule := range routes {
+		addr := rule.LocalAddr()
+		base, ok := byAddr[addr]
+		if !ok {
+			base, err = newHTTPForwarder(addr, upstreamTLS, rewrite, http2, cfg)
+			if err != nil {
+				_ = f.Close() // Best effort
+				return nil, err
+			}
+			byAddr[addr] = base
+			f.upstreams = append(f.upstreams, base)
+		}
+
+		upstream := base
+		if rule.StripPrefix || base == f {
+			upstream = &HTTPForwarder{
+				localAddr:  base.localAddr,
+				upstream:   base.upstream,
+				rewrite:    base.rewrite,
+				httpClient: base.httpClient,
+				connPool:   base.connPool,
+				bufferPool: base.bufferPool,
+			}
+			if rule.StripPrefix {
+				upstream.stripPrefix = rule.Path
+			}
+		}
+		f.routes = append(f.routes, route{rule: rule, upstream: upstream})
+
+		logger.InfoEvent().
+			Str("path", rule.Path).
+			Str("host", rule.Host).
+			Str("header", rule.Header).
+			Bool("strip_prefix", rule.StripPrefix).
+			Str("upstream", addr).
+			Msg("HTTP route added")
+	}
+
+	return f, nil
 }
 
-// NewHTTPForwarder creates a new HTTP forwarder.
-func NewHTTPForwarder(localAddr string, cfg config.PerformanceConfig) *HTTPForwarder {
-	// Create connection pool if enabled
+// newHTTPForwarder creates the forwarder of a single upstream.
+func newHTTPForwarder(localAddr string, upstreamTLS config.UpstreamTLSConfig, rewrite config.RewriteConfig, http2 bool, cfg config.PerformanceConfig) (*HTTPForwarder, error) {
+	upstream, err := config.ParseUpstream(localAddr, upstreamTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create connection pool if enabled. HTTP/2 multiplexes requests on
+	// connections of its own, so it does without.
 	var connPool *pool.ConnectionPool
-	var err error
 
-	if cfg.ConnectionPool.Enabled {
+	if cfg.ConnectionPool.Enabled && !http2 {
 		connPool, err = pool.NewConnectionPool(pool.Config{
 			MinSize:             cfg.ConnectionPool.MinSize,
 			MaxSize:             cfg.ConnectionPool.MaxSize,
 			IdleTimeout:         cfg.ConnectionPool.IdleTimeout,
 			HealthCheckInterval: cfg.ConnectionPool.HealthCheckInterval,
 			MaxWaitTime:         5 * time.Second,
-			Factory: func() (net.Conn, error) {
-				return net.DialTimeout("tcp", localAddr, 10*time.Second)
-			},
+			Factory:             upstream.Factory(10 * time.Second),
 		})
 	}
 
@@ -62,13 +154,32 @@ func NewHTTPForwarder(localAddr string, cfg config.PerformanceConfig) *HTTPForwa
 		DisableCompression:  false,
 		WriteBufferSize:     32 * 1024,
 		ReadBufferSize:      32 * 1024,
+		TLSClientConfig:     upstream.TLS,
+	}
+	if http2 {
+		transport.Protocols = new(http.Protocols)
+		if upstream.TLS != nil {
+			// ALPN picks HTTP/1.1 for upstreams without HTTP/2. WebSocket
+			// upgrades dial with upstream.TLS, which keeps offering HTTP/1.1 only.
+			transport.TLSClientConfig = upstream.TLS.Clone()
+			transport.Protocols.SetHTTP1(true)
+			transport.Protocols.SetHTTP2(true)
+		} else {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		}
 	}
 
-	// Use connection pool for dialing if available
+	// Use connection pool for dialing if available; pooled TLS connections
+	// have completed their handshake already
 	if connPool != nil {
-		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
 			return connPool.Get(ctx)
 		}
+		if upstream.TLS != nil {
+			transport.DialTLSContext = dial
+		} else {
+			transport.DialContext = dial
+		}
 
 		logger.InfoEvent().
 			Str("local_addr", localAddr).
@@ -76,8 +187,15 @@ func NewHTTPForwarder(localAddr string, cfg config.PerformanceConfig) *HTTPForwa
 			Int("pool_max", cfg.ConnectionPool.MaxSize).
 			Msg("HTTP forwarder initialized with connection pool")
 	} else {
+		if upstream.Network == "unix" {
+			transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return upstream.DialContext(ctx)
+			}
+		}
+
 		logger.InfoEvent().
 			Str("local_addr", localAddr).
+			Bool("http2", http2).
 			Msg("HTTP forwarder initialized with standard transport (no pool)")
 	}
 
@@ -89,6 +207,8 @@ func NewHTTPForwarder(localAddr string, cfg config.PerformanceConfig) *HTTPForwa
 
 	return &HTTPForwarder{
 		localAddr: localAddr,
+		upstream:  upstream,
+		rewrite:   rewrite,
 		httpClient: &http.Client{
 			Timeout:   0,
 			Transport: transport,
@@ -98,7 +218,7 @@ func NewHTTPForwarder(localAddr string, cfg config.PerformanceConfig) *HTTPForwa
 		},
 		connPool:   connPool,
 		bufferPool: bufferPool,
-	}
+	}, nil
 }
 
 const (
@@ -109,17 +229,8 @@ const (
 // Forward forwards a gRPC HTTP request to local service.
 // For small responses, returns complete response. For large responses, this is deprecated - use ForwardChunked.
 func (f *HTTPForwarder) Forward(ctx context.Context, req *tunnelv1.HTTPRequest) (*tunnelv1.HTTPResponse, error) {
-	// Build URL using strings.Builder to reduce allocations
-	var urlBuilder strings.Builder
-	urlBuilder.Grow(len("http://") + len(f.localAddr) + len(req.Path) + 1 + len(req.QueryString))
-	urlBuilder.WriteString("http://")
-	urlBuilder.WriteString(f.localAddr)
-	urlBuilder.WriteString(req.Path)
-	if req.QueryString != "" {
-		urlBuilder.WriteByte('?')
-		urlBuilder.WriteString(req.QueryString)
-	}
-	url := urlBuilder.String()
+	f = f.Route(req)
+	url := f.upstreamURL(req)
 
 	logger.DebugEvent().
 		Str("method", req.Method).
@@ -144,10 +255,13 @@ func (f *HTTPForwarder) Forward(ctx context.Context, req *tunnelv1.HTTPRequest)
 		}
 	}
 
-	// Override Host header if present
+	// Override Host header if present, or as configured
 	if host := httpReq.Header.Get("Host"); host != "" {
 		httpReq.Host = host
 	}
+	if host := f.requestHost(req); host != "" {
+		httpReq.Host = host
+	}
 
 	// Set X-Forwarded headers
 	if req.RemoteAddr != "" {
@@ -167,6 +281,8 @@ func (f *HTTPForwarder) Forward(ctx context.Context, req *tunnelv1.HTTPRequest)
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	f.rewriteResponseHeaders(req, httpResp.Header)
+
 	// Convert response headers
 	headers := make(map[string]*tunnelv1.HeaderValues, len(httpResp.Header))
 	for name, values := range httpResp.Header {
@@ -186,6 +302,7 @@ func (f *HTTPForwarder) Forward(ctx context.Context, req *tunnelv1.HTTPRequest)
 		StatusCode: int32(httpResp.StatusCode), //nolint:gosec // Safe conversion: HTTP status codes are always 100-599
 		Headers:    headers,
 		Body:       respBody,
+		Trailers:   trailerValues(httpResp.Trailer),
 	}, nil
 }
 
@@ -193,26 +310,34 @@ func (f *HTTPForwarder) Forward(ctx context.Context, req *tunnelv1.HTTPRequest)
 // This is memory-efficient for large responses as it streams data in 4MB chunks.
 // The sendChunk callback is called for each chunk with (response, isLastChunk).
 func (f *HTTPForwarder) ForwardChunked(ctx context.Context, req *tunnelv1.HTTPRequest, sendChunk func(*tunnelv1.HTTPResponse, bool) error) error {
-	// Build URL using strings.Builder to reduce allocations
-	var urlBuilder strings.Builder
-	urlBuilder.Grow(len("http://") + len(f.localAddr) + len(req.Path) + 1 + len(req.QueryString))
-	urlBuilder.WriteString("http://")
-	urlBuilder.WriteString(f.localAddr)
-	urlBuilder.WriteString(req.Path)
-	if req.QueryString != "" {
-		urlBuilder.WriteByte('?')
-		urlBuilder.WriteString(req.QueryString)
-	}
-	url := urlBuilder.String()
+	return f.ForwardStream(ctx, req, nil, sendChunk)
+}
+
+// ForwardStream works like ForwardChunked but reads the request body from body when it is
+// non-nil (streamed request bodies) instead of req.Body. The trailers of a body that is a
+// TrailerReader are sent after it. The first chunk always carries the status code and
+// headers; exactly one chunk is sent with isLastChunk set, carrying the response trailers.
+func (f *HTTPForwarder) ForwardStream(ctx context.Context, req *tunnelv1.HTTPRequest, body io.Reader, sendChunk func(*tunnelv1.HTTPResponse, bool) error) error {
+	f = f.Route(req)
+	url := f.upstreamURL(req)
 
 	logger.DebugEvent().
 		Str("method", req.Method).
 		Str("url", url).
+		Bool("streaming_body", body != nil).
 		Msg("Forwarding HTTP request to local service (chunked)")
 
-	// Create HTTP request
-	var bodyReader io.Reader
-	if len(req.Body) > 0 {
+	// Create HTTP request; trailers of inline bodies are known upfront, those
+	// of streamed bodies once they end
+	trailer := requestTrailer(req)
+	bodyReader := body
+	if tr, ok := body.(TrailerReader); ok {
+		if trailer == nil {
+			trailer = http.Header{}
+		}
+		bodyReader = &trailerBody{TrailerReader: tr, trailer: trailer}
+	}
+	if bodyReader == nil && len(req.Body) > 0 {
 		bodyReader = bytes.NewReader(req.Body)
 	}
 
@@ -228,10 +353,27 @@ func (f *HTTPForwarder) ForwardChunked(ctx context.Context, req *tunnelv1.HTTPRe
 		}
 	}
 
-	// Override Host header if present
+	httpReq.Header.Del("Trailer") // Announced from httpReq.Trailer by the transport
+	httpReq.Trailer = trailer
+
+	// Preserve declared length of streamed bodies; otherwise they are sent chunked,
+	// like inline bodies with trailers
+	if body != nil {
+		httpReq.ContentLength = -1
+		if cl, err := strconv.ParseInt(httpReq.Header.Get("Content-Length"), 10, 64); err == nil && cl >= 0 {
+			httpReq.ContentLength = cl
+		}
+	} else if len(req.Trailers) > 0 && bodyReader != nil {
+		httpReq.ContentLength = -1
+	}
+
+	// Override Host header if present, or as configured
 	if host := httpReq.Header.Get("Host"); host != "" {
 		httpReq.Host = host
 	}
+	if host := f.requestHost(req); host != "" {
+		httpReq.Host = host
+	}
 
 	// Set X-Forwarded headers
 	if req.RemoteAddr != "" {
@@ -245,6 +387,8 @@ func (f *HTTPForwarder) ForwardChunked(ctx context.Context, req *tunnelv1.HTTPRe
 	}
 	defer httpResp.Body.Close()
 
+	f.rewriteResponseHeaders(req, httpResp.Header)
+
 	// Convert response headers
 	headers := make(map[string]*tunnelv1.HeaderValues, len(httpResp.Header))
 	for name, values := range httpResp.Header {
@@ -281,7 +425,18 @@ func (f *HTTPForwarder) streamChunkedResponse(httpResp *http.Response, headers m
 	buffer := f.bufferPool.Get(estimatedSize)
 	defer buffer.Release()
 
+	statusCode := int32(httpResp.StatusCode) //nolint:gosec // Safe conversion
 	totalBytes := 0
+	lastSent := false
+
+	// Send headers right away when the body length is unknown so streamed responses
+	// (SSE, long-poll) reach the public client before the first body byte
+	if httpResp.ContentLength < 0 {
+		if err := sendChunk(&tunnelv1.HTTPResponse{StatusCode: statusCode, Headers: headers}, false); err != nil {
+			return fmt.Errorf("failed to send chunk: %w", err)
+		}
+		headers = nil
+	}
 
 	for {
 		n, err := httpResp.Body.Read(buffer.Bytes())
@@ -290,13 +445,17 @@ func (f *HTTPForwarder) streamChunkedResponse(httpResp *http.Response, headers m
 
 			// Create chunk response
 			chunk := &tunnelv1.HTTPResponse{
-				StatusCode: int32(httpResp.StatusCode), //nolint:gosec // Safe conversion
+				StatusCode: statusCode,
 				Headers:    headers,
 				Body:       append([]byte(nil), buffer.Bytes()[:n]...),
 			}
 
-			// Check if this is the last chunk
+			// Check if this is the last chunk; trailers are read along with EOF
 			isLast := (err == io.EOF)
+			lastSent = isLast
+			if isLast {
+				chunk.Trailers = trailerValues(httpResp.Trailer)
+			}
 
 			// Send chunk via callback
 			if sendErr := sendChunk(chunk, isLast); sendErr != nil {
@@ -321,6 +480,14 @@ func (f *HTTPForwarder) streamChunkedResponse(httpResp *http.Response, headers m
 		}
 	}
 
+	// Terminate the stream if EOF arrived without data (empty body or after the last read)
+	if !lastSent {
+		last := &tunnelv1.HTTPResponse{StatusCode: statusCode, Headers: headers, Trailers: trailerValues(httpResp.Trailer)}
+		if err := sendChunk(last, true); err != nil {
+			return fmt.Errorf("failed to send chunk: %w", err)
+		}
+	}
+
 	logger.InfoEvent().
 		Int("total_bytes", totalBytes).
 		Msg("Completed chunked response streaming")
@@ -328,6 +495,50 @@ func (f *HTTPForwarder) streamChunkedResponse(httpResp *http.Response, headers m
 	return nil
 }
 
+// requestTrailer returns the trailers of req: those announced in its Trailer
+// header, without values until the body ends, and those of an inline body.
+// It returns nil when there are none.
+func requestTrailer(req *tunnelv1.HTTPRequest) http.Header {
+	trailer := http.Header{}
+	for name, values := range req.Headers {
+		if !strings.EqualFold(name, "Trailer") {
+			continue
+		}
+		for _, value := range values.GetValues() {
+			for _, key := range strings.Split(value, ",") {
+				if key = strings.TrimSpace(key); httpguts.ValidTrailerHeader(key) {
+					trailer[http.CanonicalHeaderKey(key)] = nil
+				}
+			}
+		}
+	}
+	for name, values := range req.Trailers {
+		if httpguts.ValidTrailerHeader(name) {
+			trailer[http.CanonicalHeaderKey(name)] = values.GetValues()
+		}
+	}
+	if len(trailer) == 0 {
+		return nil
+	}
+	return trailer
+}
+
+// trailerValues converts the trailers of a response read to the end, or
+// returns nil when there are none. Trailers announced but not sent are empty.
+func trailerValues(trailer http.Header) map[string]*tunnelv1.HeaderValues {
+	var trailers map[string]*tunnelv1.HeaderValues
+	for name, values := range trailer {
+		if len(values) == 0 {
+			continue
+		}
+		if trailers == nil {
+			trailers = make(map[string]*tunnelv1.HeaderValues, len(trailer))
+		}
+		trailers[name] = &tunnelv1.HeaderValues{Values: values}
+	}
+	return trailers
+}
+
 // IsWebSocketUpgrade checks if the request is a WebSocket upgrade request.
 func IsWebSocketUpgrade(req *tunnelv1.HTTPRequest) bool {
 	if req.Headers == nil {
@@ -349,12 +560,12 @@ func IsWebSocketUpgrade(req *tunnelv1.HTTPRequest) bool {
 
 // ForwardWebSocketUpgrade handles WebSocket upgrade and returns the upgrade response and connection.
 func (f *HTTPForwarder) ForwardWebSocketUpgrade(ctx context.Context, req *tunnelv1.HTTPRequest) (*tunnelv1.HTTPResponse, net.Conn, error) {
-	// Dial raw TCP connection to local service
-	dialer := &net.Dialer{
-		Timeout: 10 * time.Second,
-	}
+	f = f.Route(req)
 
-	conn, err := dialer.DialContext(ctx, "tcp", f.localAddr)
+	// Dial a raw connection to local service (TLS for https:// upstreams)
+	dialCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	conn, err := f.upstream.DialContext(dialCtx)
+	cancel()
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to connect to local service: %w", err)
 	}
@@ -362,10 +573,10 @@ func (f *HTTPForwarder) ForwardWebSocketUpgrade(ctx context.Context, req *tunnel
 	logger.InfoEvent().
 		Str("local_addr", f.localAddr).
 		Str("path", req.Path).
-		Msg("Established TCP connection for WebSocket upgrade")
+		Msg("Established connection for WebSocket upgrade")
 
 	// Build and write HTTP upgrade request
-	url := req.Path
+	url := f.upstreamPath(req.Path)
 	if req.QueryString != "" {
 		url += "?" + req.QueryString
 	}
@@ -377,8 +588,22 @@ func (f *HTTPForwarder) ForwardWebSocketUpgrade(ctx context.Context, req *tunnel
 		return nil, nil, fmt.Errorf("failed to write request line: %w", err)
 	}
 
-	// Write headers
+	// Write headers; HTTP/1.1 requires Host
+	host := f.requestHost(req)
+	if host == "" {
+		host = firstHeader(req, "Host")
+	}
+	if host == "" {
+		host = f.urlHost()
+	}
+	if _, err := conn.Write([]byte("Host: " + host + "\r\n")); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to write header: %w", err)
+	}
 	for name, headerVals := range req.Headers {
+		if strings.EqualFold(name, "Host") {
+			continue
+		}
 		for _, val := range headerVals.Values {
 			headerLine := fmt.Sprintf("%s: %s\r\n", name, val)
 			if _, err := conn.Write([]byte(headerLine)); err != nil {
@@ -435,6 +660,8 @@ func (f *HTTPForwarder) ForwardWebSocketUpgrade(ctx context.Context, req *tunnel
 		return nil, nil, fmt.Errorf("failed to clear read deadline: %w", err)
 	}
 
+	f.rewriteResponseHeaders(req, http.Header(mimeHeader))
+
 	// Convert headers to protobuf format
 	headers := make(map[string]*tunnelv1.HeaderValues, len(mimeHeader))
 	for name, values := range mimeHeader {
@@ -473,10 +700,18 @@ func (bc *bufferedConn) Read(b []byte) (int, error) {
 	return bc.reader.Read(b)
 }
 
-// Close gracefully shuts down the HTTP forwarder and its connection pool.
+// Close gracefully shuts down the HTTP forwarder and the connection pools of its upstreams.
 func (f *HTTPForwarder) Close() error {
+	var errs []error
+	for _, upstream := range f.upstreams {
+		if err := upstream.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if f.connPool != nil {
-		return f.connPool.Close()
+		if err := f.connPool.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
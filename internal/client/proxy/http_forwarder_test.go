@@ -2,10 +2,14 @@ package proxy
 
 import (
 	"context"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -18,7 +22,7 @@ import (
 )
 
 // createTestForwarder creates a forwarder with default test config.
-func createTestForwarder(addr string) *HTTPForwarder {
+func createTestForwarder(t testing.TB, addr string) *HTTPForwarder {
 	cfg := config.PerformanceConfig{}
 	cfg.ConnectionPool.Enabled = true
 	cfg.ConnectionPool.MinSize = 1
@@ -29,12 +33,14 @@ func createTestForwarder(addr string) *HTTPForwarder {
 	// Create adaptive buffer pool config
 	cfg.BufferPool.Enabled = true
 
-	return NewHTTPForwarder(addr, cfg)
+	forwarder, err := NewHTTPForwarder(addr, config.UpstreamTLSConfig{}, config.RewriteConfig{}, false, cfg)
+	require.NoError(t, err)
+	return forwarder
 }
 
 // TestNewHTTPForwarder tests HTTP forwarder creation.
 func TestNewHTTPForwarder(t *testing.T) {
-	forwarder := createTestForwarder("localhost:3000")
+	forwarder := createTestForwarder(t, "localhost:3000")
 
 	require.NotNil(t, forwarder)
 	assert.Equal(t, "localhost:3000", forwarder.localAddr)
@@ -55,7 +61,7 @@ func TestHTTPForwarder_Forward_SimpleGET(t *testing.T) {
 
 	// Extract host:port from server URL
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	// Create gRPC request
 	req := &tunnelv1.HTTPRequest{
@@ -91,7 +97,7 @@ func TestHTTPForwarder_Forward_POST(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "POST",
@@ -118,7 +124,7 @@ func TestHTTPForwarder_Forward_QueryString(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:      "GET",
@@ -146,7 +152,7 @@ func TestHTTPForwarder_Forward_Headers(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method: "GET",
@@ -175,7 +181,7 @@ func TestHTTPForwarder_Forward_XForwardedFor(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:     "GET",
@@ -199,7 +205,7 @@ func TestHTTPForwarder_Forward_HostHeader(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method: "GET",
@@ -224,7 +230,7 @@ func TestHTTPForwarder_Forward_4xxError(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -248,7 +254,7 @@ func TestHTTPForwarder_Forward_5xxError(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -271,7 +277,7 @@ func TestHTTPForwarder_Forward_Redirect(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -291,7 +297,7 @@ func TestHTTPForwarder_Forward_Redirect(t *testing.T) {
 // TestHTTPForwarder_Forward_InvalidLocalAddr tests error when local service unreachable.
 func TestHTTPForwarder_Forward_InvalidLocalAddr(t *testing.T) {
 	// Use an invalid address that will fail to connect
-	forwarder := createTestForwarder("localhost:99999")
+	forwarder := createTestForwarder(t, "localhost:99999")
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -316,7 +322,7 @@ func TestHTTPForwarder_Forward_ContextCanceled(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -347,7 +353,7 @@ func TestHTTPForwarder_ForwardChunked(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -387,7 +393,7 @@ func TestHTTPForwarder_ForwardChunked_SmallResponse(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -415,7 +421,7 @@ func TestHTTPForwarder_ForwardChunked_SendError(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -432,6 +438,184 @@ func TestHTTPForwarder_ForwardChunked_SendError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to send chunk")
 }
 
+// TestHTTPForwarder_ForwardStream_RequestBody tests forwarding a streamed request body.
+func TestHTTPForwarder_ForwardStream_RequestBody(t *testing.T) {
+	var receivedBody string
+	var receivedLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receivedBody = string(body)
+		receivedLength = r.ContentLength
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	serverAddr := strings.TrimPrefix(server.URL, "http://")
+	forwarder := createTestForwarder(t, serverAddr)
+
+	payload := strings.Repeat("B", 200*1024)
+	req := &tunnelv1.HTTPRequest{
+		Method: "POST",
+		Path:   "/upload",
+		Headers: map[string]*tunnelv1.HeaderValues{
+			"Content-Length": {Values: []string{fmt.Sprint(len(payload))}},
+		},
+		StreamingBody: true,
+	}
+
+	var chunks []*tunnelv1.HTTPResponse
+	var lastFlags []bool
+	err := forwarder.ForwardStream(context.Background(), req, strings.NewReader(payload), func(resp *tunnelv1.HTTPResponse, isLast bool) error {
+		chunks = append(chunks, resp)
+		lastFlags = append(lastFlags, isLast)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, payload, receivedBody)
+	assert.Equal(t, int64(len(payload)), receivedLength)
+
+	// Empty body still yields a single terminating chunk with the status
+	require.Len(t, chunks, 1)
+	assert.Equal(t, int32(http.StatusCreated), chunks[0].StatusCode)
+	assert.Equal(t, []bool{true}, lastFlags)
+}
+
+// TestHTTPForwarder_ForwardStream_UnknownLength tests that headers are sent before the body for streamed responses.
+func TestHTTPForwarder_ForwardStream_UnknownLength(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: hello\n\n"))
+	}))
+	defer server.Close()
+
+	serverAddr := strings.TrimPrefix(server.URL, "http://")
+	forwarder := createTestForwarder(t, serverAddr)
+
+	req := &tunnelv1.HTTPRequest{
+		Method:  "GET",
+		Path:    "/events",
+		Headers: make(map[string]*tunnelv1.HeaderValues),
+	}
+
+	var chunks []*tunnelv1.HTTPResponse
+	var lastCount int
+	err := forwarder.ForwardStream(context.Background(), req, nil, func(resp *tunnelv1.HTTPResponse, isLast bool) error {
+		if len(chunks) == 0 {
+			// Headers arrive while the local service is still holding the body
+			assert.Empty(t, resp.Body)
+			close(release)
+		}
+		chunks = append(chunks, resp)
+		if isLast {
+			lastCount++
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(chunks), 2)
+	assert.Equal(t, "text/event-stream", chunks[0].Headers["Content-Type"].Values[0])
+	assert.Nil(t, chunks[1].Headers)
+	assert.Equal(t, 1, lastCount)
+
+	var body []byte
+	for _, chunk := range chunks {
+		body = append(body, chunk.Body...)
+	}
+	assert.Equal(t, "data: hello\n\n", string(body))
+}
+
+// trailerReader is a streamed request body with trailers.
+type trailerReader struct {
+	io.Reader
+	trailer http.Header
+}
+
+func (r *trailerReader) Trailer() http.Header {
+	return r.trailer
+}
+
+// TestHTTPForwarder_HTTP2 tests that HTTP/2 upstreams, over TLS and with prior
+// knowledge, get streamed bodies and trailers in both directions, as gRPC
+// services need.
+func TestHTTPForwarder_HTTP2(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = fmt.Fprintf(w, "%s %s checksum=%s", r.Proto, body, r.Trailer.Get("Checksum"))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	h2c := httptest.NewUnstartedServer(handler)
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	tests := []struct {
+		name        string
+		addr        string
+		upstreamTLS config.UpstreamTLSConfig
+	}{
+		{"h2c", strings.TrimPrefix(h2c.URL, "http://"), config.UpstreamTLSConfig{}},
+		{"h2", h2.URL, config.UpstreamTLSConfig{Insecure: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.PerformanceConfig{}
+			cfg.ConnectionPool.Enabled = true // Not used by HTTP/2
+			cfg.ConnectionPool.MaxSize = 2
+			cfg.ConnectionPool.IdleTimeout = time.Minute
+			cfg.ConnectionPool.HealthCheckInterval = time.Minute
+
+			forwarder, err := NewHTTPForwarder(tt.addr, tt.upstreamTLS, config.RewriteConfig{}, true, cfg)
+			require.NoError(t, err)
+			defer forwarder.Close()
+
+			req := &tunnelv1.HTTPRequest{
+				Method: "POST",
+				Path:   "/helloworld.Greeter/SayHello",
+				Headers: map[string]*tunnelv1.HeaderValues{
+					"Content-Type": {Values: []string{"application/grpc"}},
+					"Te":           {Values: []string{"trailers"}},
+					"Trailer":      {Values: []string{"Checksum"}},
+				},
+				StreamingBody: true,
+			}
+			body := &trailerReader{Reader: strings.NewReader("hello"), trailer: http.Header{"Checksum": {"abc"}}}
+
+			var chunks []*tunnelv1.HTTPResponse
+			var last *tunnelv1.HTTPResponse
+			err = forwarder.ForwardStream(context.Background(), req, body, func(resp *tunnelv1.HTTPResponse, isLast bool) error {
+				chunks = append(chunks, resp)
+				if isLast {
+					last = resp
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			require.NotNil(t, last)
+
+			var received []byte
+			for _, chunk := range chunks {
+				received = append(received, chunk.Body...)
+			}
+			assert.Equal(t, "HTTP/2.0 hello checksum=abc", string(received))
+			assert.Equal(t, []string{"0"}, last.Trailers["Grpc-Status"].GetValues())
+		})
+	}
+}
+
 // TestIsWebSocketUpgrade tests WebSocket upgrade detection.
 func TestIsWebSocketUpgrade(t *testing.T) {
 	tests := []struct {
@@ -553,7 +737,7 @@ func TestHTTPForwarder_ForwardWebSocketUpgrade(t *testing.T) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(t, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method: "GET",
@@ -585,7 +769,7 @@ func TestHTTPForwarder_ForwardWebSocketUpgrade(t *testing.T) {
 
 // TestHTTPForwarder_ForwardWebSocketUpgrade_ConnectError tests connection error.
 func TestHTTPForwarder_ForwardWebSocketUpgrade_ConnectError(t *testing.T) {
-	forwarder := createTestForwarder("localhost:99999")
+	forwarder := createTestForwarder(t, "localhost:99999")
 
 	req := &tunnelv1.HTTPRequest{
 		Method: "GET",
@@ -604,6 +788,104 @@ func TestHTTPForwarder_ForwardWebSocketUpgrade_ConnectError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to connect to local service")
 }
 
+// TestHTTPForwarder_HTTPSUpstream tests forwarding to a local HTTPS service.
+func TestHTTPForwarder_HTTPSUpstream(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "tls=%t sni=%s path=%s", r.TLS != nil, r.TLS.ServerName, r.URL.Path)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	req := &tunnelv1.HTTPRequest{Method: "GET", Path: "/secure"}
+
+	tests := []struct {
+		name        string
+		upstreamTLS config.UpstreamTLSConfig
+		pooled      bool
+		want        string
+		wantErr     bool
+	}{
+		{name: "self-signed rejected", wantErr: true},
+		{name: "skip verify", upstreamTLS: config.UpstreamTLSConfig{Insecure: true}, want: "tls=true sni= path=/secure"},
+		{name: "skip verify pooled", upstreamTLS: config.UpstreamTLSConfig{Insecure: true}, pooled: true, want: "tls=true sni= path=/secure"},
+		{
+			name:        "custom CA and SNI",
+			upstreamTLS: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"},
+			want:        "tls=true sni=example.com path=/secure",
+		},
+		{
+			name:        "custom CA and SNI pooled",
+			upstreamTLS: config.UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"},
+			pooled:      true,
+			want:        "tls=true sni=example.com path=/secure",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.PerformanceConfig{}
+			if tt.pooled {
+				cfg.ConnectionPool.Enabled = true
+				cfg.ConnectionPool.MaxSize = 2
+				cfg.ConnectionPool.IdleTimeout = time.Minute
+				cfg.ConnectionPool.HealthCheckInterval = time.Minute
+			}
+
+			// 127.0.0.1 sends no SNI; example.com is in the test certificate
+			forwarder, err := NewHTTPForwarder(server.URL, tt.upstreamTLS, config.RewriteConfig{}, false, cfg)
+			require.NoError(t, err)
+			defer forwarder.Close()
+
+			resp, err := forwarder.Forward(context.Background(), req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(resp.Body))
+		})
+	}
+
+	_, err := NewHTTPForwarder(server.URL, config.UpstreamTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, config.RewriteConfig{}, false, config.PerformanceConfig{})
+	assert.Error(t, err)
+}
+
+// TestHTTPForwarder_UnixUpstream tests forwarding to a service on a Unix domain socket.
+func TestHTTPForwarder_UnixUpstream(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "unix %s", r.URL.RequestURI())
+		}),
+		ReadHeaderTimeout: time.Second,
+	}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	for _, pooled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pooled=%t", pooled), func(t *testing.T) {
+			cfg := config.PerformanceConfig{}
+			cfg.ConnectionPool.Enabled = pooled
+			cfg.ConnectionPool.MaxSize = 2
+			cfg.ConnectionPool.IdleTimeout = time.Minute
+			cfg.ConnectionPool.HealthCheckInterval = time.Minute
+
+			forwarder, err := NewHTTPForwarder("unix://"+socket, config.UpstreamTLSConfig{}, config.RewriteConfig{}, false, cfg)
+			require.NoError(t, err)
+			defer forwarder.Close()
+
+			resp, err := forwarder.Forward(context.Background(), &tunnelv1.HTTPRequest{Method: "GET", Path: "/status", QueryString: "full=1"})
+			require.NoError(t, err)
+			assert.Equal(t, int32(http.StatusOK), resp.StatusCode)
+			assert.Equal(t, "unix /status?full=1", string(resp.Body))
+		})
+	}
+}
+
 // BenchmarkHTTPForwarder_Forward benchmarks HTTP forwarding.
 func BenchmarkHTTPForwarder_Forward(b *testing.B) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -613,7 +895,7 @@ func BenchmarkHTTPForwarder_Forward(b *testing.B) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(b, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -638,7 +920,7 @@ func BenchmarkHTTPForwarder_ForwardChunked(b *testing.B) {
 	defer server.Close()
 
 	serverAddr := strings.TrimPrefix(server.URL, "http://")
-	forwarder := createTestForwarder(serverAddr)
+	forwarder := createTestForwarder(b, serverAddr)
 
 	req := &tunnelv1.HTTPRequest{
 		Method:  "GET",
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/client/config"
+)
+
+// urlHost returns the host of the upstream URLs.
+func (f *HTTPForwarder) urlHost() string {
+	if f.upstream.Network == "unix" {
+		return "localhost" // The transport dials the socket; the host only names the request
+	}
+	return f.upstream.Address
+}
+
+// requestHost returns the Host header to send upstream for req, or "" to use
+// the upstream address.
+func (f *HTTPForwarder) requestHost(req *tunnelv1.HTTPRequest) string {
+	switch f.rewrite.HostHeader {
+	case "":
+		return ""
+	case config.HostHeaderPreserve:
+		return firstHeader(req, "X-Forwarded-Host")
+	default:
+		return f.rewrite.HostHeader
+	}
+}
+
+// rewriteResponseHeaders points the local URLs in the response headers
+// (Location, Content-Location and Set-Cookie domains) at the public URL req
+// came in on, as reported by the server in X-Forwarded-Host and -Proto.
+func (f *HTTPForwarder) rewriteResponseHeaders(req *tunnelv1.HTTPRequest, header http.Header) {
+	if f.rewrite.DisableURLRewrite {
+		return
+	}
+
+	publicHost := firstHeader(req, "X-Forwarded-Host")
+	if publicHost == "" {
+		return
+	}
+	publicScheme := firstHeader(req, "X-Forwarded-Proto")
+	if publicScheme == "" {
+		publicScheme = "http"
+	}
+
+	// The upstream may call itself by its address or by the Host it was sent
+	localHosts := []string{f.urlHost()}
+	if host := f.requestHost(req); host != "" {
+		localHosts = append(localHosts, host)
+	}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := header.Get(name); value != "" {
+			header.Set(name, f.rewriteURL(value, localHosts, publicScheme, publicHost))
+		}
+	}
+
+	if cookies := header.Values("Set-Cookie"); len(cookies) > 0 {
+		rewritten := make([]string, len(cookies))
+		for i, cookie := range cookies {
+			rewritten[i] = rewriteCookieDomain(cookie, localHosts, hostname(publicHost))
+		}
+		header["Set-Cookie"] = rewritten
+	}
+}
+
+// rewriteURL moves a URL on the local upstream to the public URL. Path-only
+// URLs get the stripped route prefix back; other URLs are left alone.
+func (f *HTTPForwarder) rewriteURL(raw string, localHosts []string, publicScheme, publicHost string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	switch {
+	case u.Host != "":
+		if !isLocalHost(u.Host, localHosts) {
+			return raw
+		}
+		u.Host = publicHost
+		if u.Scheme != "" {
+			u.Scheme = publicScheme
+		}
+	case u.Scheme == "" && strings.HasPrefix(u.Path, "/") && f.stripPrefix != "":
+	default:
+		return raw
+	}
+
+	if f.stripPrefix != "" {
+		prefix := strings.TrimSuffix(f.stripPrefix, "/")
+		u.Path = prefix + u.Path
+		if u.RawPath != "" {
+			u.RawPath = prefix + u.RawPath
+		}
+	}
+	return u.String()
+}
+
+// rewriteCookieDomain replaces a Domain attribute naming the local upstream
+// with the public hostname.
+func rewriteCookieDomain(cookie string, localHosts []string, publicHostname string) string {
+	parts := strings.Split(cookie, ";")
+	changed := false
+	for i := 1; i < len(parts); i++ {
+		attr := strings.TrimSpace(parts[i])
+		if len(attr) < len("domain=") || !strings.EqualFold(attr[:len("domain=")], "domain=") {
+			continue
+		}
+
+		domain := strings.TrimPrefix(attr[len("domain="):], ".")
+		if isLocalHost(domain, localHosts) {
+			parts[i] = " Domain=" + publicHostname
+			changed = true
+		}
+	}
+
+	if !changed {
+		return cookie
+	}
+	return strings.Join(parts, ";")
+}
+
+// isLocalHost reports whether host names the local upstream: one of
+// localHosts, or another loopback name for the same port. A host without a
+// port (cookie domains) matches on the hostname alone.
+func isLocalHost(host string, localHosts []string) bool {
+	name, port := splitHostPort(host)
+
+	for _, local := range localHosts {
+		localName, localPort := splitHostPort(local)
+		if port != "" && port != localPort {
+			continue
+		}
+		if strings.EqualFold(name, localName) || (isLoopback(name) && isLoopback(localName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether name is a loopback (or unspecified) address or localhost.
+func isLoopback(name string) bool {
+	if strings.EqualFold(name, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(name)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// splitHostPort splits host into its hostname and port, if any.
+func splitHostPort(host string) (string, string) {
+	if name, port, err := net.SplitHostPort(host); err == nil {
+		return name, port
+	}
+	return strings.Trim(host, "[]"), ""
+}
+
+// hostname returns host without its port.
+func hostname(host string) string {
+	name, _ := splitHostPort(host)
+	return name
+}
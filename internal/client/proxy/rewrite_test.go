@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/client/config"
+	"github.com/pandeptwidyaop/grok/pkg/pool"
+)
+
+// publicRequest returns a request that came in on https://demo.grok.io.
+func publicRequest(path string) *tunnelv1.HTTPRequest {
+	return &tunnelv1.HTTPRequest{
+		Method: "GET",
+		Path:   path,
+		Headers: map[string]*tunnelv1.HeaderValues{
+			"X-Forwarded-Host":  {Values: []string{"demo.grok.io"}},
+			"X-Forwarded-Proto": {Values: []string{"https"}},
+		},
+	}
+}
+
+// TestRewriteResponseHeaders tests moving local URLs to the public URL.
+func TestRewriteResponseHeaders(t *testing.T) {
+	upstream, err := pool.ParseUpstream("localhost:3000")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		want        string
+		stripPrefix string
+		rewrite     config.RewriteConfig
+	}{
+		{name: "local redirect", header: "Location", value: "http://localhost:3000/login?next=/", want: "https://demo.grok.io/login?next=/"},
+		{name: "other loopback name", header: "Location", value: "http://127.0.0.1:3000/login", want: "https://demo.grok.io/login"},
+		{name: "scheme-relative", header: "Location", value: "//localhost:3000/login", want: "//demo.grok.io/login"},
+		{name: "content location", header: "Content-Location", value: "http://localhost:3000/doc.json", want: "https://demo.grok.io/doc.json"},
+		{name: "other port", header: "Location", value: "http://localhost:4000/login", want: "http://localhost:4000/login"},
+		{name: "external", header: "Location", value: "https://accounts.example.com/auth", want: "https://accounts.example.com/auth"},
+		{name: "path only", header: "Location", value: "/login", want: "/login"},
+		{name: "relative", header: "Location", value: "login", want: "login"},
+		{name: "path under stripped prefix", header: "Location", value: "/login", stripPrefix: "/app", want: "/app/login"},
+		{name: "local URL under stripped prefix", header: "Location", value: "http://localhost:3000/login", stripPrefix: "/app/", want: "https://demo.grok.io/app/login"},
+		{name: "fixed host", header: "Location", value: "http://myapp.test/login", rewrite: config.RewriteConfig{HostHeader: "myapp.test"}, want: "https://demo.grok.io/login"},
+		{name: "disabled", header: "Location", value: "http://localhost:3000/login", rewrite: config.RewriteConfig{DisableURLRewrite: true}, want: "http://localhost:3000/login"},
+		{name: "cookie domain", header: "Set-Cookie", value: "sid=1; Domain=localhost; Path=/; HttpOnly", want: "sid=1; Domain=demo.grok.io; Path=/; HttpOnly"},
+		{name: "cookie dotted domain", header: "Set-Cookie", value: "sid=1; domain=.myapp.test", rewrite: config.RewriteConfig{HostHeader: "myapp.test"}, want: "sid=1; Domain=demo.grok.io"},
+		{name: "cookie other domain", header: "Set-Cookie", value: "sid=1; Domain=example.com", want: "sid=1; Domain=example.com"},
+		{name: "host-only cookie", header: "Set-Cookie", value: "sid=1; Path=/", want: "sid=1; Path=/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &HTTPForwarder{upstream: upstream, stripPrefix: tt.stripPrefix, rewrite: tt.rewrite}
+			header := http.Header{}
+			header.Set(tt.header, tt.value)
+
+			f.rewriteResponseHeaders(publicRequest("/"), header)
+			assert.Equal(t, tt.want, header.Get(tt.header))
+		})
+	}
+
+	t.Run("without public host", func(t *testing.T) {
+		f := &HTTPForwarder{upstream: upstream}
+		header := http.Header{"Location": {"http://localhost:3000/login"}}
+		f.rewriteResponseHeaders(&tunnelv1.HTTPRequest{Path: "/"}, header)
+		assert.Equal(t, "http://localhost:3000/login", header.Get("Location"))
+	})
+}
+
+// TestHTTPForwarder_HostHeader tests the Host sent to the local service and
+// that its redirects point at the public URL.
+func TestHTTPForwarder_HostHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+"/login", http.StatusFound)
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name     string
+		rewrite  config.RewriteConfig
+		location string
+	}{
+		{name: "local address", location: "https://demo.grok.io/login"},
+		{name: "preserve", rewrite: config.RewriteConfig{HostHeader: config.HostHeaderPreserve}, location: "https://demo.grok.io/login"},
+		{name: "fixed", rewrite: config.RewriteConfig{HostHeader: "myapp.test"}, location: "https://demo.grok.io/login"},
+		{name: "no rewrite", rewrite: config.RewriteConfig{HostHeader: "myapp.test", DisableURLRewrite: true}, location: "http://myapp.test/login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder, err := NewHTTPForwarder(addr, config.UpstreamTLSConfig{}, tt.rewrite, false, config.PerformanceConfig{})
+			require.NoError(t, err)
+			defer forwarder.Close()
+
+			resp, err := forwarder.Forward(context.Background(), publicRequest("/"))
+			require.NoError(t, err)
+			assert.Equal(t, int32(http.StatusFound), resp.StatusCode)
+			assert.Equal(t, tt.location, resp.Headers["Location"].Values[0])
+		})
+	}
+}
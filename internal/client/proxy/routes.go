@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/client/config"
+)
+
+// route is a route rule and the forwarder of its upstream.
+type route struct {
+	rule     config.RouteConfig
+	upstream *HTTPForwarder
+}
+
+// Route returns the forwarder of the upstream that serves req: the upstream of
+// the first matching route rule, or f itself.
+func (f *HTTPForwarder) Route(req *tunnelv1.HTTPRequest) *HTTPForwarder {
+	for _, r := range f.routes {
+		if matchRoute(r.rule, req) {
+			return r.upstream
+		}
+	}
+	return f
+}
+
+// LocalAddr returns the address of the local upstream.
+func (f *HTTPForwarder) LocalAddr() string {
+	return f.localAddr
+}
+
+// upstreamURL returns the URL of req on the local upstream.
+func (f *HTTPForwarder) upstreamURL(req *tunnelv1.HTTPRequest) string {
+	path := f.upstreamPath(req.Path)
+
+	scheme, host := "http://", f.urlHost()
+	if f.upstream.TLS != nil {
+		scheme = "https://"
+	}
+
+	// Build URL using strings.Builder to reduce allocations
+	var urlBuilder strings.Builder
+	urlBuilder.Grow(len(scheme) + len(host) + len(path) + 1 + len(req.QueryString))
+	urlBuilder.WriteString(scheme)
+	urlBuilder.WriteString(host)
+	urlBuilder.WriteString(path)
+	if req.QueryString != "" {
+		urlBuilder.WriteByte('?')
+		urlBuilder.WriteString(req.QueryString)
+	}
+	return urlBuilder.String()
+}
+
+// upstreamPath removes the stripped route prefix from path.
+func (f *HTTPForwarder) upstreamPath(path string) string {
+	if f.stripPrefix == "" {
+		return path
+	}
+
+	path = strings.TrimPrefix(path, strings.TrimSuffix(f.stripPrefix, "/"))
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// matchRoute reports whether req meets every condition of rule.
+func matchRoute(rule config.RouteConfig, req *tunnelv1.HTTPRequest) bool {
+	if rule.Path != "" && !matchPathPrefix(req.Path, rule.Path) {
+		return false
+	}
+
+	if rule.Host != "" {
+		// Go servers drop Host from the headers; the tunnel server passes it as X-Forwarded-Host
+		host := firstHeader(req, "Host")
+		if host == "" {
+			host = firstHeader(req, "X-Forwarded-Host")
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, rule.Host) {
+			return false
+		}
+	}
+
+	if rule.Header != "" {
+		name, value := rule.HeaderMatch()
+		values := headerValues(req, name)
+		if len(values) == 0 {
+			return false
+		}
+		if value != "" && !containsFold(values, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchPathPrefix reports whether path is prefix or lies below it. /api
+// matches /api and /api/users but not /apix.
+func matchPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// headerValues returns the values of a request header, matching its name case-insensitively.
+func headerValues(req *tunnelv1.HTTPRequest, name string) []string {
+	for key, values := range req.Headers {
+		if strings.EqualFold(key, name) {
+			return values.GetValues()
+		}
+	}
+	return nil
+}
+
+// firstHeader returns the first value of a request header, or "".
+func firstHeader(req *tunnelv1.HTTPRequest, name string) string {
+	if values := headerValues(req, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// containsFold reports whether values contains value, ignoring case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
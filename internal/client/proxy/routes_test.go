@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/client/config"
+)
+
+// TestMatchRoute tests the route conditions.
+func TestMatchRoute(t *testing.T) {
+	req := &tunnelv1.HTTPRequest{
+		Path: "/api/users",
+		Headers: map[string]*tunnelv1.HeaderValues{
+			"X-Forwarded-Host": {Values: []string{"Admin.Localhost:443"}},
+			"X-Version":        {Values: []string{"2"}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		rule config.RouteConfig
+		want bool
+	}{
+		{"path prefix", config.RouteConfig{Path: "/api"}, true},
+		{"path prefix with slash", config.RouteConfig{Path: "/api/"}, true},
+		{"exact path", config.RouteConfig{Path: "/api/users"}, true},
+		{"partial segment", config.RouteConfig{Path: "/ap"}, false},
+		{"other path", config.RouteConfig{Path: "/ws"}, false},
+		{"host ignores case and port", config.RouteConfig{Host: "admin.localhost"}, true},
+		{"other host", config.RouteConfig{Host: "www.localhost"}, false},
+		{"header present", config.RouteConfig{Header: "x-version"}, true},
+		{"header value", config.RouteConfig{Header: "X-Version: 2"}, true},
+		{"other header value", config.RouteConfig{Header: "X-Version: 1"}, false},
+		{"missing header", config.RouteConfig{Header: "X-Beta"}, false},
+		{"all conditions", config.RouteConfig{Path: "/api", Host: "admin.localhost", Header: "X-Version:2"}, true},
+		{"one condition fails", config.RouteConfig{Path: "/api", Host: "www.localhost"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchRoute(tt.rule, req))
+		})
+	}
+}
+
+// TestHTTPForwarder_Routes tests that requests reach the upstream of the first
+// matching rule, with the prefix stripped when asked.
+func TestHTTPForwarder_Routes(t *testing.T) {
+	upstream := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name + " " + r.URL.RequestURI()))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	frontend, api, ws := upstream("frontend"), upstream("api"), upstream("ws")
+	addr := func(server *httptest.Server) string {
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	forwarder, err := NewHTTPForwarder(addr(frontend), config.UpstreamTLSConfig{}, config.RewriteConfig{}, false, config.PerformanceConfig{},
+		config.RouteConfig{Path: "/api", Addr: addr(api)},
+		config.RouteConfig{Path: "/ws/", StripPrefix: true, Addr: addr(ws)},
+		config.RouteConfig{Header: "X-Legacy", Addr: addr(frontend)},
+	)
+	require.NoError(t, err)
+	defer forwarder.Close()
+
+	tests := []struct {
+		path     string
+		query    string
+		headers  map[string]*tunnelv1.HeaderValues
+		upstream string
+		want     string
+	}{
+		{path: "/", upstream: addr(frontend), want: "frontend /"},
+		{path: "/api/users", query: "page=2", upstream: addr(api), want: "api /api/users?page=2"},
+		{path: "/apix", upstream: addr(frontend), want: "frontend /apix"},
+		{path: "/ws/chat", upstream: addr(ws), want: "ws /chat"},
+		{path: "/ws", upstream: addr(ws), want: "ws /"},
+		{
+			path:     "/legacy",
+			headers:  map[string]*tunnelv1.HeaderValues{"X-Legacy": {Values: []string{"1"}}},
+			upstream: addr(frontend),
+			want:     "frontend /legacy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := &tunnelv1.HTTPRequest{Method: "GET", Path: tt.path, QueryString: tt.query, Headers: tt.headers}
+			assert.Equal(t, tt.upstream, forwarder.Route(req).LocalAddr())
+
+			resp, err := forwarder.Forward(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(resp.Body))
+		})
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
 )
 
 // tcpBufferPool pools 32KB buffers for TCP read operations to reduce GC pressure.
@@ -27,12 +31,16 @@ type TCPConnection struct {
 	mu              sync.Mutex
 	closed          bool
 	readLoopStarted bool
+	window          *protocol.Window // Send credit granted by the server (nil without flow control)
+	credit          *protocol.Credit // Bytes written locally, not yet acknowledged to the server
 }
 
 // TCPForwarder manages TCP connections and forwards data to local service.
 type TCPForwarder struct {
-	localAddr   string
-	connections sync.Map // requestID → *TCPConnection
+	localAddr     string
+	connections   sync.Map // requestID → *TCPConnection
+	flowControl   atomic.Bool
+	proxyProtocol int // PROXY protocol version sent to the local service, or 0
 }
 
 // NewTCPForwarder creates a new TCP forwarder.
@@ -42,10 +50,22 @@ func NewTCPForwarder(localAddr string) *TCPForwarder {
 	}
 }
 
+// SetFlowControl enables credit-based flow control for connections opened from now on.
+func (f *TCPForwarder) SetFlowControl(enabled bool) {
+	f.flowControl.Store(enabled)
+}
+
+// SetProxyProtocol makes connections to the local service start with a PROXY
+// header of version (proxyproto.V1 or V2) carrying the visitor's address.
+func (f *TCPForwarder) SetProxyProtocol(version int) {
+	f.proxyProtocol = version
+}
+
 // Forward forwards TCP data to local service and returns whether to start read loop.
-func (f *TCPForwarder) Forward(ctx context.Context, requestID string, data *tunnelv1.TCPData, _ func(*tunnelv1.TCPData) error) (startReadLoop bool, err error) {
+// With flow control, sendResponse receives window updates for the data written locally.
+func (f *TCPForwarder) Forward(ctx context.Context, requestID string, data *tunnelv1.TCPData, sendResponse func(*tunnelv1.TCPData) error) (startReadLoop bool, err error) {
 	// Handle connection close signal (empty data)
-	if len(data.Data) == 0 {
+	if protocol.IsTCPClose(data) {
 		logger.DebugEvent().
 			Str("request_id", requestID).
 			Msg("Received TCP close signal")
@@ -53,8 +73,8 @@ func (f *TCPForwarder) Forward(ctx context.Context, requestID string, data *tunn
 		return false, nil
 	}
 
-	// Get or create connection
-	tcpConn, isNew, err := f.getOrCreateConnection(ctx, requestID)
+	// Get or create connection; the first frame may only open it
+	tcpConn, isNew, err := f.getOrCreateConnection(ctx, requestID, data.RemoteAddr)
 	if err != nil {
 		return false, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -66,7 +86,9 @@ func (f *TCPForwarder) Forward(ctx context.Context, requestID string, data *tunn
 		return false, fmt.Errorf("connection already closed")
 	}
 
-	_, err = tcpConn.conn.Write(data.Data)
+	if len(data.Data) > 0 {
+		_, err = tcpConn.conn.Write(data.Data)
+	}
 
 	// Check if we should start read loop (only for new connections)
 	shouldStartReadLoop := isNew && !tcpConn.readLoopStarted
@@ -89,11 +111,28 @@ func (f *TCPForwarder) Forward(ctx context.Context, requestID string, data *tunn
 		Int("bytes", len(data.Data)).
 		Msg("Forwarded TCP data to local service")
 
+	// Let the server send more once enough has been written out
+	if grant := tcpConn.credit.Consumed(len(data.Data)); grant > 0 && sendResponse != nil {
+		if err := sendResponse(&tunnelv1.TCPData{WindowUpdate: grant}); err != nil {
+			logger.WarnEvent().Err(err).Str("request_id", requestID).Msg("Failed to send TCP window update")
+		}
+	}
+
 	return shouldStartReadLoop, nil
 }
 
-// getOrCreateConnection gets existing connection or creates new one, returns (conn, isNew, error).
-func (f *TCPForwarder) getOrCreateConnection(ctx context.Context, requestID string) (*TCPConnection, bool, error) {
+// WindowUpdate returns n bytes of send credit to a connection's read loop.
+func (f *TCPForwarder) WindowUpdate(requestID string, n uint32) {
+	if conn, ok := f.connections.Load(requestID); ok {
+		if tcpConn, ok := conn.(*TCPConnection); ok {
+			tcpConn.window.Release(int(n))
+		}
+	}
+}
+
+// getOrCreateConnection gets existing connection or creates new one for a
+// visitor at remoteAddr, returns (conn, isNew, error).
+func (f *TCPForwarder) getOrCreateConnection(ctx context.Context, requestID, remoteAddr string) (*TCPConnection, bool, error) {
 	// Check if connection exists
 	if conn, ok := f.connections.Load(requestID); ok {
 		tcpConn, ok := conn.(*TCPConnection)
@@ -113,12 +152,23 @@ func (f *TCPForwarder) getOrCreateConnection(ctx context.Context, requestID stri
 		return nil, false, fmt.Errorf("failed to connect to %s: %w", f.localAddr, err)
 	}
 
+	if f.proxyProtocol != 0 {
+		if _, err := conn.Write(proxyHeader(f.proxyProtocol, remoteAddr, conn.RemoteAddr()).Format()); err != nil {
+			conn.Close()
+			return nil, false, fmt.Errorf("failed to send PROXY header to %s: %w", f.localAddr, err)
+		}
+	}
+
 	tcpConn := &TCPConnection{
 		conn:            conn,
 		requestID:       requestID,
 		closed:          false,
 		readLoopStarted: false,
 	}
+	if f.flowControl.Load() {
+		tcpConn.window = protocol.NewWindow(protocol.DefaultWindow)
+		tcpConn.credit = protocol.NewCredit(protocol.DefaultWindow)
+	}
 
 	f.connections.Store(requestID, tcpConn)
 
@@ -130,6 +180,20 @@ func (f *TCPForwarder) getOrCreateConnection(ctx context.Context, requestID stri
 	return tcpConn, true, nil
 }
 
+// proxyHeader returns the PROXY header of a connection from the visitor at
+// remoteAddr to the local service at localAddr. The header carries no
+// addresses when the visitor's address is unknown.
+func proxyHeader(version int, remoteAddr string, localAddr net.Addr) *proxyproto.Header {
+	header := &proxyproto.Header{Version: version}
+	source, err := netip.ParseAddrPort(remoteAddr)
+	tcpAddr, ok := localAddr.(*net.TCPAddr)
+	if err == nil && ok {
+		header.Source = source
+		header.Destination = tcpAddr.AddrPort()
+	}
+	return header
+}
+
 // StartReadLoop starts reading from a TCP connection and sends responses back.
 func (f *TCPForwarder) StartReadLoop(ctx context.Context, requestID string, sendResponse func(*tunnelv1.TCPData) error) {
 	conn, ok := f.connections.Load(requestID)
@@ -161,12 +225,20 @@ func (f *TCPForwarder) StartReadLoop(ctx context.Context, requestID string, send
 		default:
 		}
 
+		// Never read more than the server has room for
+		size, err := tcpConn.window.Acquire(ctx, len(buffer))
+		if err != nil {
+			f.closeConnection(requestID)
+			return
+		}
+
 		// Set read deadline to allow periodic context checks
 		if err := tcpConn.conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
 			logger.WarnEvent().Err(err).Str("request_id", requestID).Msg("Failed to set read deadline")
 		}
 
-		n, err := tcpConn.conn.Read(buffer)
+		n, err := tcpConn.conn.Read(buffer[:size])
+		tcpConn.window.Release(size - n)
 		if err != nil {
 			if err == io.EOF {
 				// Normal connection close
@@ -230,6 +302,7 @@ func (f *TCPForwarder) closeConnection(requestID string) {
 		tcpConn.mu.Lock()
 		if !tcpConn.closed {
 			tcpConn.conn.Close()
+			tcpConn.window.Close()
 			tcpConn.closed = true
 			logger.InfoEvent().
 				Str("request_id", requestID).
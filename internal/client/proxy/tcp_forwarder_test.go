@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
 	"testing"
 	"time"
@@ -12,6 +13,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
 )
 
 // TestNewTCPForwarder tests TCP forwarder creation.
@@ -572,6 +575,108 @@ func TestTCPForwarder_MultipleConnections(t *testing.T) {
 	serverWg.Wait()
 }
 
+// TestTCPForwarder_FlowControl tests that the read loop stops at the server's window
+// and that forwarded data is acknowledged with window updates.
+func TestTCPForwarder_FlowControl(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// A fast local service: reads the request and sends far more than the window
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		go func() {
+			_, _ = conn.Write(make([]byte, 4*protocol.DefaultWindow))
+		}()
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	forwarder := NewTCPForwarder(listener.Addr().String())
+	forwarder.SetFlowControl(true)
+	defer forwarder.Close()
+
+	var mu sync.Mutex
+	var updates []uint32
+	sent := 0
+	sendResponse := func(data *tunnelv1.TCPData) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if data.WindowUpdate > 0 {
+			updates = append(updates, data.WindowUpdate)
+		}
+		sent += len(data.Data)
+		return nil
+	}
+
+	// Writing a quarter of the window returns that much credit to the server
+	request := &tunnelv1.TCPData{Data: make([]byte, protocol.DefaultWindow/4)}
+	startReadLoop, err := forwarder.Forward(context.Background(), "req-1", request, sendResponse)
+	require.NoError(t, err)
+	assert.True(t, startReadLoop)
+
+	mu.Lock()
+	assert.Equal(t, []uint32{protocol.DefaultWindow / 4}, updates)
+	mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go forwarder.StartReadLoop(ctx, "req-1", sendResponse)
+
+	sentBytes := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return sent
+	}
+
+	require.Eventually(t, func() bool { return sentBytes() == protocol.DefaultWindow }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, protocol.DefaultWindow, sentBytes(), "read loop must wait for credit")
+
+	forwarder.WindowUpdate("req-1", protocol.DefaultWindow/2)
+	require.Eventually(t, func() bool { return sentBytes() == protocol.DefaultWindow+protocol.DefaultWindow/2 }, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestTCPForwarder_ProxyProtocol tests that an opening frame connects to the
+// local service, which learns the visitor's address from the PROXY header.
+func TestTCPForwarder_ProxyProtocol(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := proxyproto.NewListener(inner, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+	defer listener.Close()
+
+	for _, version := range []int{proxyproto.V1, proxyproto.V2} {
+		forwarder := NewTCPForwarder(listener.Addr().String())
+		forwarder.SetProxyProtocol(version)
+
+		open := &tunnelv1.TCPData{RemoteAddr: "203.0.113.7:51234"}
+		startReadLoop, err := forwarder.Forward(context.Background(), "req-1", open, nil)
+		require.NoError(t, err)
+		assert.True(t, startReadLoop, "opened before any data")
+
+		conn, err := listener.Accept()
+		require.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:51234", conn.RemoteAddr().String())
+
+		_, err = forwarder.Forward(context.Background(), "req-1", &tunnelv1.TCPData{Data: []byte("hello")}, nil)
+		require.NoError(t, err)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf))
+
+		conn.Close()
+		forwarder.Close()
+	}
+
+	// Without the visitor's address, the header says so
+	assert.True(t, proxyHeader(proxyproto.V1, "", listener.Addr()).Local())
+}
+
 // BenchmarkTCPForwarder_Forward benchmarks TCP forwarding.
 func BenchmarkTCPForwarder_Forward(b *testing.B) {
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
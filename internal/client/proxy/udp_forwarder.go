@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+const (
+	// maxDatagramSize is the largest UDP payload read from the local service.
+	maxDatagramSize = 64 * 1024
+	// DefaultMaxUDPFlows is the number of local sockets a UDP forwarder keeps open by default.
+	DefaultMaxUDPFlows = 1024
+)
+
+// UDPFlow is the local socket of one UDP flow, i.e. one public peer.
+type UDPFlow struct {
+	conn       *net.UDPConn
+	flowID     string
+	remoteAddr string       // Public peer, as reported by the server
+	lastActive atomic.Int64 // Unix nanoseconds of the last datagram either way
+}
+
+// UDPForwarder keeps a local UDP socket per flow, so the local service sees
+// every public peer as a separate source address and its replies can be
+// told apart. Once maxFlows sockets are open, the least recently active one
+// is closed for a new flow; a later datagram of its flow opens a new socket.
+type UDPForwarder struct {
+	localAddr string
+	maxFlows  int
+	flows     sync.Map     // flow ID → *UDPFlow
+	count     atomic.Int64 // Number of open flows
+}
+
+// NewUDPForwarder creates a new UDP forwarder.
+func NewUDPForwarder(localAddr string) *UDPForwarder {
+	return &UDPForwarder{
+		localAddr: localAddr,
+		maxFlows:  DefaultMaxUDPFlows,
+	}
+}
+
+// SetMaxFlows sets the number of local sockets kept open. Values below 1 keep the default.
+func (f *UDPForwarder) SetMaxFlows(n int) {
+	if n > 0 {
+		f.maxFlows = n
+	}
+}
+
+// Forward sends a datagram of a flow to the local service. The first datagram
+// of a flow opens its local socket; replies read from it are passed to
+// sendResponse until the flow is closed.
+func (f *UDPForwarder) Forward(flowID string, datagram *tunnelv1.UDPDatagram, sendResponse func(*tunnelv1.UDPDatagram) error) error {
+	// The server expired the flow
+	if datagram.Close {
+		f.closeFlow(flowID)
+		return nil
+	}
+
+	flow, err := f.getOrCreateFlow(flowID, datagram.RemoteAddr, sendResponse)
+	if err != nil {
+		return err
+	}
+
+	if _, err := flow.conn.Write(datagram.Data); err != nil {
+		f.closeFlow(flowID)
+		return fmt.Errorf("failed to write to local service: %w", err)
+	}
+	flow.touch()
+
+	logger.DebugEvent().
+		Str("flow_id", flowID).
+		Int("bytes", len(datagram.Data)).
+		Msg("Forwarded UDP datagram to local service")
+
+	return nil
+}
+
+// getOrCreateFlow returns the local socket of a flow, opening it on first use.
+func (f *UDPForwarder) getOrCreateFlow(flowID, remoteAddr string, sendResponse func(*tunnelv1.UDPDatagram) error) (*UDPFlow, error) {
+	if value, ok := f.flows.Load(flowID); ok {
+		return value.(*UDPFlow), nil
+	}
+
+	// At the limit, the least recently active flow makes room for the new one
+	if int(f.count.Load()) >= f.maxFlows {
+		if oldest := f.oldestFlow(); oldest != nil {
+			logger.DebugEvent().
+				Int("max_flows", f.maxFlows).
+				Str("flow_id", oldest.flowID).
+				Msg("UDP flow limit reached, closing least recently active flow")
+			f.closeFlow(oldest.flowID)
+		}
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", f.localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", f.localAddr, err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", f.localAddr, err)
+	}
+
+	flow := &UDPFlow{conn: conn, flowID: flowID, remoteAddr: remoteAddr}
+	flow.touch()
+	f.flows.Store(flowID, flow)
+	f.count.Add(1)
+	go f.readReplies(flow, sendResponse)
+
+	logger.InfoEvent().
+		Str("flow_id", flowID).
+		Str("remote_addr", remoteAddr).
+		Str("local_addr", f.localAddr).
+		Msg("Opened UDP flow to local service")
+
+	return flow, nil
+}
+
+// readReplies sends the datagrams the local service replies with back through the tunnel.
+func (f *UDPForwarder) readReplies(flow *UDPFlow, sendResponse func(*tunnelv1.UDPDatagram) error) {
+	buffer := make([]byte, maxDatagramSize)
+
+	for {
+		n, err := flow.conn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// E.g. nothing listening locally: the server drops the flow, and the
+			// peer's next datagram opens a new one
+			logger.WarnEvent().
+				Err(err).
+				Str("flow_id", flow.flowID).
+				Msg("Error reading from local UDP service")
+			if err := sendResponse(&tunnelv1.UDPDatagram{Close: true}); err != nil {
+				logger.WarnEvent().Err(err).Str("flow_id", flow.flowID).Msg("Failed to send UDP flow close")
+			}
+			f.closeFlow(flow.flowID)
+			return
+		}
+
+		flow.touch()
+		if err := sendResponse(&tunnelv1.UDPDatagram{Data: append([]byte(nil), buffer[:n]...)}); err != nil {
+			logger.ErrorEvent().
+				Err(err).
+				Str("flow_id", flow.flowID).
+				Msg("Failed to send UDP reply")
+			f.closeFlow(flow.flowID)
+			return
+		}
+	}
+}
+
+// closeFlow closes and removes a flow.
+func (f *UDPForwarder) closeFlow(flowID string) {
+	if value, ok := f.flows.LoadAndDelete(flowID); ok {
+		flow := value.(*UDPFlow)
+		f.count.Add(-1)
+		flow.conn.Close()
+		logger.DebugEvent().
+			Str("flow_id", flowID).
+			Str("remote_addr", flow.remoteAddr).
+			Msg("Closed UDP flow to local service")
+	}
+}
+
+// Close closes all flows.
+func (f *UDPForwarder) Close() {
+	f.flows.Range(func(key, _ interface{}) bool {
+		if flowID, ok := key.(string); ok {
+			f.closeFlow(flowID)
+		}
+		return true
+	})
+}
+
+// oldestFlow returns the flow that has been idle the longest, or nil when there are none.
+func (f *UDPForwarder) oldestFlow() *UDPFlow {
+	var oldest *UDPFlow
+	f.flows.Range(func(_, value interface{}) bool {
+		if flow := value.(*UDPFlow); oldest == nil || flow.lastActive.Load() < oldest.lastActive.Load() {
+			oldest = flow
+		}
+		return true
+	})
+	return oldest
+}
+
+// touch records traffic on the flow.
+func (f *UDPFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// startUDPEcho starts a local UDP service that replies with the sender's address.
+func startUDPEcho(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP([]byte(string(buf[:n])+" from "+addr.String()), addr)
+		}
+	}()
+
+	return conn
+}
+
+// recvReply waits for the next datagram passed to sendResponse.
+func recvReply(t *testing.T, replies chan *tunnelv1.UDPDatagram) *tunnelv1.UDPDatagram {
+	select {
+	case datagram := <-replies:
+		return datagram
+	case <-time.After(time.Second):
+		t.Fatal("no reply from the local service")
+		return nil
+	}
+}
+
+// TestUDPForwarder_Flows tests that each flow gets its own local socket.
+func TestUDPForwarder_Flows(t *testing.T) {
+	echo := startUDPEcho(t)
+	forwarder := NewUDPForwarder(echo.LocalAddr().String())
+	defer forwarder.Close()
+
+	replies := make(chan *tunnelv1.UDPDatagram, 10)
+	sendResponse := func(datagram *tunnelv1.UDPDatagram) error {
+		replies <- datagram
+		return nil
+	}
+
+	require.NoError(t, forwarder.Forward("flow-a", &tunnelv1.UDPDatagram{Data: []byte("a"), RemoteAddr: "203.0.113.1:5000"}, sendResponse))
+	replyA := recvReply(t, replies)
+	require.NoError(t, forwarder.Forward("flow-a", &tunnelv1.UDPDatagram{Data: []byte("a")}, sendResponse))
+	assert.Equal(t, replyA.Data, recvReply(t, replies).Data, "same flow, same local socket")
+
+	require.NoError(t, forwarder.Forward("flow-b", &tunnelv1.UDPDatagram{Data: []byte("a"), RemoteAddr: "203.0.113.2:5000"}, sendResponse))
+	assert.NotEqual(t, replyA.Data, recvReply(t, replies).Data, "new flow, new local socket")
+
+	// The server expires a flow
+	require.NoError(t, forwarder.Forward("flow-a", &tunnelv1.UDPDatagram{Close: true}, sendResponse))
+	_, ok := forwarder.flows.Load("flow-a")
+	assert.False(t, ok)
+	_, ok = forwarder.flows.Load("flow-b")
+	assert.True(t, ok)
+}
+
+// TestUDPForwarder_LocalServiceDown tests that the flow is closed when nothing listens locally.
+func TestUDPForwarder_LocalServiceDown(t *testing.T) {
+	// Reserve a port, then free it so nothing listens on it
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	forwarder := NewUDPForwarder(addr)
+	defer forwarder.Close()
+
+	replies := make(chan *tunnelv1.UDPDatagram, 10)
+	require.NoError(t, forwarder.Forward("flow", &tunnelv1.UDPDatagram{Data: []byte("ping")}, func(datagram *tunnelv1.UDPDatagram) error {
+		replies <- datagram
+		return nil
+	}))
+
+	assert.True(t, recvReply(t, replies).Close)
+	_, ok := forwarder.flows.Load("flow")
+	assert.False(t, ok)
+}
+
+// TestUDPForwarder_FlowLimit tests that the least recently active local socket
+// is closed for a new flow once the limit is reached.
+func TestUDPForwarder_FlowLimit(t *testing.T) {
+	echo := startUDPEcho(t)
+	forwarder := NewUDPForwarder(echo.LocalAddr().String())
+	forwarder.SetMaxFlows(2)
+	defer forwarder.Close()
+
+	replies := make(chan *tunnelv1.UDPDatagram, 10)
+	sendResponse := func(datagram *tunnelv1.UDPDatagram) error {
+		replies <- datagram
+		return nil
+	}
+	forward := func(flowID string) {
+		require.NoError(t, forwarder.Forward(flowID, &tunnelv1.UDPDatagram{Data: []byte("ping")}, sendResponse))
+		recvReply(t, replies)
+	}
+
+	forward("flow-a")
+	forward("flow-b")
+	time.Sleep(10 * time.Millisecond)
+	forward("flow-a") // flow-b is now the oldest
+	forward("flow-c")
+
+	_, ok := forwarder.flows.Load("flow-b")
+	assert.False(t, ok, "least recently active flow should be closed")
+	for _, flowID := range []string{"flow-a", "flow-c"} {
+		_, ok := forwarder.flows.Load(flowID)
+		assert.True(t, ok, flowID)
+	}
+	assert.Equal(t, int64(2), forwarder.count.Load())
+}
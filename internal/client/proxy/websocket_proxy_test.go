@@ -51,7 +51,8 @@ func TestForwardWebSocketUpgrade_BufferedData(t *testing.T) {
 	// Setup forwarder
 	cfg := config.PerformanceConfig{}
 	cfg.ConnectionPool.Enabled = false // Disable pool for simple test
-	forwarder := NewHTTPForwarder(serverAddr, cfg)
+	forwarder, err := NewHTTPForwarder(serverAddr, config.UpstreamTLSConfig{}, config.RewriteConfig{}, false, cfg)
+	require.NoError(t, err)
 	defer forwarder.Close()
 
 	// Create upgrade request
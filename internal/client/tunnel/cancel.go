@@ -0,0 +1,60 @@
+package tunnel
+
+import (
+	"context"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// trackRequest derives a cancelable context for an in-flight request.
+// It runs in the receive loop, before any cancel frame for requestID can arrive.
+func (c *Client) trackRequest(ctx context.Context, requestID string) context.Context {
+	reqCtx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	if c.inflight == nil {
+		c.inflight = make(map[string]context.CancelFunc)
+	}
+	c.inflight[requestID] = cancel
+	c.mu.Unlock()
+
+	return reqCtx
+}
+
+// untrackRequest releases the context of a finished request.
+func (c *Client) untrackRequest(requestID string) {
+	c.mu.Lock()
+	cancel, ok := c.inflight[requestID]
+	delete(c.inflight, requestID)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+// cancelRequest stops work on a request the server no longer waits for: an HTTP
+// request's context is canceled (which also closes a proxied WebSocket) and a TCP
+// connection is closed once the data already queued for it has been written.
+func (c *Client) cancelRequest(requestID string, reason tunnelv1.CancelRequest_Reason) {
+	c.mu.Lock()
+	cancel, isRequest := c.inflight[requestID]
+	delete(c.inflight, requestID)
+	stream, isTCP := c.tcpStreams[requestID]
+	if isTCP {
+		stream.push(&tunnelv1.TCPData{})
+	}
+	c.mu.Unlock()
+
+	if isRequest {
+		cancel()
+	}
+
+	if isRequest || isTCP {
+		logger.InfoEvent().
+			Str("request_id", requestID).
+			Str("reason", reason.String()).
+			Msg("Request canceled by server")
+	}
+}
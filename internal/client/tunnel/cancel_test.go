@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// TestCancelRequest_StopsLocalHandler tests that a cancel frame cancels the request to the local service.
+func TestCancelRequest_StopsLocalHandler(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	local := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(stopped)
+	}))
+	defer local.Close()
+
+	client, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: strings.TrimPrefix(local.URL, "http://")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client.handleMessage(ctx, &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Request{
+		Request: &tunnelv1.ProxyRequest{
+			RequestId: "req-1",
+			Payload:   &tunnelv1.ProxyRequest_Http{Http: &tunnelv1.HTTPRequest{Method: "GET", Path: "/report"}},
+		},
+	}})
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not reach the local service")
+	}
+
+	client.handleMessage(ctx, &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Request{
+		Request: &tunnelv1.ProxyRequest{
+			RequestId: "req-1",
+			Payload:   &tunnelv1.ProxyRequest_Cancel{Cancel: &tunnelv1.CancelRequest{Reason: tunnelv1.CancelRequest_CLIENT_DISCONNECTED}},
+		},
+	}})
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("local handler was not canceled")
+	}
+
+	assert.Eventually(t, func() bool {
+		client.mu.RLock()
+		defer client.mu.RUnlock()
+		return len(client.inflight) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestCancelRequest_Unknown tests that cancel frames for finished requests are ignored.
+func TestCancelRequest_Unknown(t *testing.T) {
+	client, err := NewClient(ClientConfig{Protocol: "tcp", LocalAddr: "localhost:5432"})
+	require.NoError(t, err)
+
+	client.cancelRequest("gone", tunnelv1.CancelRequest_TIMEOUT)
+	assert.Empty(t, client.tcpStreams)
+}
@@ -13,17 +13,22 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	_ "google.golang.org/grpc/encoding/gzip" // Register gzip compressor
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/client/config"
 	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
 	"github.com/pandeptwidyaop/grok/internal/client/dashboard/events"
 	"github.com/pandeptwidyaop/grok/internal/client/proxy"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
 )
 
 // cryptoRandFloat64 generates a cryptographically secure random float64 in range [0, 1).
@@ -39,6 +44,7 @@ func cryptoRandFloat64() float64 {
 
 // ClientConfig holds tunnel client configuration.
 type ClientConfig struct {
+	Name           string // Tunnel name for status output and the dashboard (optional)
 	ServerAddr     string
 	TLS            bool
 	TLSCertFile    string
@@ -48,102 +54,108 @@ type ClientConfig struct {
 	LocalAddr      string
 	Subdomain      string
 	Protocol       string
-	SavedName      string // Saved tunnel name (optional, for persistent tunnels)
-	WebhookAppID   string // Webhook app ID (optional, for webhook tunnels)
+	SavedName      string                   // Saved tunnel name (optional, for persistent tunnels)
+	WebhookAppID   string                   // Webhook app ID (optional, for webhook tunnels)
+	LoadBalancing  tunnelv1.LoadBalancing   // Join the tunnel group of SavedName with this strategy (optional)
+	Routes         []config.RouteConfig     // Send matching HTTP requests to other local upstreams (optional)
+	UpstreamTLS    config.UpstreamTLSConfig // TLS settings for https:// local upstreams (optional)
+	Rewrite        config.RewriteConfig     // Host header and response URL rewriting for HTTP tunnels (optional)
+	Access         config.AccessConfig      // Who may reach an HTTP tunnel, checked by the server (optional)
+	IPRules        config.IPRulesConfig     // Networks and countries allowed to reach an HTTP or TCP tunnel (optional)
+	Headers        config.HeaderRulesConfig // Headers the server changes on an HTTP tunnel (optional)
+	Edge           config.EdgeConfig        // Compression and caching of an HTTP tunnel by the server (optional)
+	ProxyProtocol  string                   // PROXY protocol version (v1 or v2) sent to the local service of a TCP tunnel (optional)
+	HTTP2          bool                     // Speak HTTP/2 (h2 or h2c) to the local services of an HTTP tunnel, e.g. gRPC (optional)
+	UDPMaxFlows    int                      // Local sockets kept open by a UDP tunnel, one per public peer (optional, default 1024)
 	ReconnectCfg   config.ReconnectConfig
 	DashboardCfg   dashboard.Config         // Dashboard configuration
 	PerformanceCfg config.PerformanceConfig // Performance configuration
 }
 
-// Client represents a tunnel client.
+// Client represents one tunnel. Tunnels share the connection of the Session they belong to.
 type Client struct {
-	cfg             ClientConfig
-	conn            *grpc.ClientConn
-	tunnelSvc       tunnelv1.TunnelServiceClient
-	tunnelID        string
-	publicURL       string
-	stream          tunnelv1.TunnelService_ProxyStreamClient
-	httpForwarder   *proxy.HTTPForwarder
-	tcpForwarder    *proxy.TCPForwarder
-	wsConnections   map[string]chan []byte // WebSocket connections by request ID
-	dashboardServer *dashboard.Server      // Dashboard HTTP server
-	eventCollector  *events.EventCollector // Event collector for dashboard
-	mu              sync.RWMutex
-	streamMu        sync.Mutex // Protects gRPC stream Send operations
-	connected       bool
-	stopCh          chan struct{}
+	cfg            ClientConfig
+	session        *Session
+	ref            string     // Session-unique reference echoed by the server in Registered/Rejected
+	registration   chan error // Receives the server's reply to a pending registration
+	tunnelID       string
+	publicURL      string
+	stream         tunnelv1.TunnelService_ProxyStreamClient
+	features       protocol.Features // Protocol features negotiated with the server
+	httpForwarder  *proxy.HTTPForwarder
+	tcpForwarder   *proxy.TCPForwarder
+	udpForwarder   *proxy.UDPForwarder
+	wsConnections  map[string]*wsConnection      // WebSocket connections by request ID
+	tcpStreams     map[string]*tcpStream         // Ordered TCP frame queues by connection ID
+	requestBodies  map[string]*requestBody       // Streamed HTTP request bodies by request ID
+	respWindows    map[string]*protocol.Window   // Send credit of streamed HTTP responses by request ID
+	inflight       map[string]context.CancelFunc // In-flight requests by request ID
+	eventCollector *events.EventCollector        // Event collector for dashboard
+	mu             sync.RWMutex
+	streamMu       sync.Mutex // Protects gRPC stream Send operations
+	connected      bool
+	stopCh         chan struct{}
 }
 
-// NewClient creates a new tunnel client.
+// NewClient creates a tunnel client with its own session.
 func NewClient(cfg ClientConfig) (*Client, error) {
+	session := NewSession(cfg)
+	client, err := newClient(cfg, session)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.Lock()
+	session.tunnels = append(session.tunnels, client)
+	session.mu.Unlock()
+
+	return client, nil
+}
+
+// newClient creates a tunnel bound to session.
+func newClient(cfg ClientConfig, session *Session) (*Client, error) {
 	// Create forwarder based on protocol
 	var httpForwarder *proxy.HTTPForwarder
 	var tcpForwarder *proxy.TCPForwarder
+	var udpForwarder *proxy.UDPForwarder
 
 	switch cfg.Protocol {
 	case "http", "https":
-		httpForwarder = proxy.NewHTTPForwarder(cfg.LocalAddr, cfg.PerformanceCfg)
-	case "tcp":
+		var err error
+		httpForwarder, err = proxy.NewHTTPForwarder(cfg.LocalAddr, cfg.UpstreamTLS, cfg.Rewrite, cfg.HTTP2, cfg.PerformanceCfg, cfg.Routes...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP forwarder: %w", err)
+		}
+	case "tcp", "tls":
+		// TLS tunnels relay the encrypted stream; the local service terminates it
 		tcpForwarder = proxy.NewTCPForwarder(cfg.LocalAddr)
-	}
-
-	client := &Client{
-		cfg:           cfg,
-		httpForwarder: httpForwarder,
-		tcpForwarder:  tcpForwarder,
-		stopCh:        make(chan struct{}),
-	}
-
-	// Initialize dashboard if port is configured
-	if cfg.DashboardCfg.Port > 0 {
-		client.dashboardServer = dashboard.NewServer(cfg.DashboardCfg)
-		client.eventCollector = client.dashboardServer.GetEventCollector()
-
-		logger.InfoEvent().
-			Int("port", cfg.DashboardCfg.Port).
-			Msg("Dashboard enabled")
-	}
-
-	return client, nil
+		if cfg.ProxyProtocol != "" {
+			version, err := proxyproto.ParseVersion(cfg.ProxyProtocol)
+			if err != nil {
+				return nil, err
+			}
+			tcpForwarder.SetProxyProtocol(version)
+		}
+	case "udp":
+		udpForwarder = proxy.NewUDPForwarder(cfg.LocalAddr)
+		udpForwarder.SetMaxFlows(cfg.UDPMaxFlows)
+	}
+
+	return &Client{
+		cfg:            cfg,
+		session:        session,
+		ref:            session.nextRef(),
+		httpForwarder:  httpForwarder,
+		tcpForwarder:   tcpForwarder,
+		udpForwarder:   udpForwarder,
+		eventCollector: session.eventCollector,
+		stopCh:         make(chan struct{}),
+	}, nil
 }
 
-// Start starts the tunnel client.
+// Start starts the session the tunnel belongs to.
 func (c *Client) Start(ctx context.Context) error {
-	logger.InfoEvent().
-		Str("server", c.cfg.ServerAddr).
-		Str("protocol", c.cfg.Protocol).
-		Msg("Starting tunnel client")
-
-	// Start dashboard server if enabled
-	if c.dashboardServer != nil {
-		dashboardCtx, dashboardCancel := context.WithCancel(ctx)
-		defer dashboardCancel()
-
-		go func() {
-			if err := c.dashboardServer.Start(dashboardCtx); err != nil {
-				logger.ErrorEvent().Err(err).Msg("Dashboard server error")
-			}
-		}()
-
-		// Wait briefly for dashboard to start
-		time.Sleep(100 * time.Millisecond)
-
-		fmt.Printf("\n")
-		fmt.Printf("╔═════════════════════════════════════════════════════════╗\n")
-		fmt.Printf("║              Dashboard Available                        ║\n")
-		fmt.Printf("╠═════════════════════════════════════════════════════════╣\n")
-		fmt.Printf("║  Dashboard:  http://127.0.0.1:%-26d║\n", c.dashboardServer.Port())
-		fmt.Printf("╚═════════════════════════════════════════════════════════╝\n")
-		fmt.Printf("\n")
-	}
-
-	// Start connection loop with reconnection
-	if c.cfg.ReconnectCfg.Enabled {
-		return c.maintainConnection(ctx)
-	}
-
-	// Single connection without reconnection
-	return c.connect(ctx)
+	return c.session.Start(ctx)
 }
 
 // tcpDialer creates a TCP connection with TCP_NODELAY enabled.
@@ -170,18 +182,18 @@ func tcpDialer(ctx context.Context, addr string) (net.Conn, error) {
 }
 
 // setupTLSConfig configures TLS for gRPC connection.
-func (c *Client) setupTLSConfig() (*tls.Config, error) {
+func (s *Session) setupTLSConfig() (*tls.Config, error) {
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: c.cfg.TLSInsecure, //nolint:gosec // User-configurable option
+		InsecureSkipVerify: s.cfg.TLSInsecure, //nolint:gosec // User-configurable option
 	}
 
-	if c.cfg.TLSServerName != "" {
-		tlsConfig.ServerName = c.cfg.TLSServerName
+	if s.cfg.TLSServerName != "" {
+		tlsConfig.ServerName = s.cfg.TLSServerName
 	}
 
-	if c.cfg.TLSCertFile != "" && !c.cfg.TLSInsecure {
+	if s.cfg.TLSCertFile != "" && !s.cfg.TLSInsecure {
 		certPool := x509.NewCertPool()
-		caCert, err := os.ReadFile(c.cfg.TLSCertFile)
+		caCert, err := os.ReadFile(s.cfg.TLSCertFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read TLS certificate file: %w", err)
 		}
@@ -189,8 +201,8 @@ func (c *Client) setupTLSConfig() (*tls.Config, error) {
 			return nil, fmt.Errorf("failed to parse TLS certificate")
 		}
 		tlsConfig.RootCAs = certPool
-		logger.InfoEvent().Str("cert_file", c.cfg.TLSCertFile).Msg("Loaded custom CA certificate")
-	} else if c.cfg.TLSInsecure {
+		logger.InfoEvent().Str("cert_file", s.cfg.TLSCertFile).Msg("Loaded custom CA certificate")
+	} else if s.cfg.TLSInsecure {
 		logger.WarnEvent().Msg("TLS certificate verification disabled (insecure mode)")
 	}
 
@@ -198,7 +210,7 @@ func (c *Client) setupTLSConfig() (*tls.Config, error) {
 }
 
 // createGRPCDialOptions creates gRPC dial options for connection.
-func (c *Client) createGRPCDialOptions() ([]grpc.DialOption, error) {
+func (s *Session) createGRPCDialOptions() ([]grpc.DialOption, error) {
 	opts := []grpc.DialOption{
 		grpc.WithContextDialer(tcpDialer),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
@@ -209,12 +221,11 @@ func (c *Client) createGRPCDialOptions() ([]grpc.DialOption, error) {
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(256<<20), // 256MB for large file support
 			grpc.MaxCallSendMsgSize(256<<20), // 256MB for large file support
-			grpc.UseCompressor("gzip"),       // ✅ Enable gzip compression
 		),
 	}
 
-	if c.cfg.TLS {
-		tlsConfig, err := c.setupTLSConfig()
+	if s.cfg.TLS {
+		tlsConfig, err := s.setupTLSConfig()
 		if err != nil {
 			return nil, err
 		}
@@ -222,7 +233,7 @@ func (c *Client) createGRPCDialOptions() ([]grpc.DialOption, error) {
 		opts = append(opts, grpc.WithTransportCredentials(creds))
 		logger.InfoEvent().
 			Bool("tls_enabled", true).
-			Bool("tls_insecure", c.cfg.TLSInsecure).
+			Bool("tls_insecure", s.cfg.TLSInsecure).
 			Msg("TLS enabled for gRPC connection")
 	} else {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
@@ -230,147 +241,71 @@ func (c *Client) createGRPCDialOptions() ([]grpc.DialOption, error) {
 	}
 
 	logger.InfoEvent().
-		Int("max_recv_mb", 16).
-		Int("max_send_mb", 16).
-		Str("compression", "gzip").
-		Msg("gRPC client configured with compression enabled")
+		Int("max_recv_mb", 256).
+		Int("max_send_mb", 256).
+		Msg("gRPC client configured")
 
 	return opts, nil
 }
 
-// connect establishes connection to server and creates tunnel.
-func (c *Client) connect(ctx context.Context) error {
-	opts, err := c.createGRPCDialOptions()
-	if err != nil {
-		return err
+// tunnelProtocol converts the configured protocol name to its proto value.
+func (c *Client) tunnelProtocol() tunnelv1.TunnelProtocol {
+	switch c.cfg.Protocol {
+	case "https":
+		return tunnelv1.TunnelProtocol_HTTPS
+	case "tcp":
+		return tunnelv1.TunnelProtocol_TCP
+	case "tls":
+		return tunnelv1.TunnelProtocol_TLS
+	case "udp":
+		return tunnelv1.TunnelProtocol_UDP
+	default:
+		return tunnelv1.TunnelProtocol_HTTP
 	}
+}
 
-	logger.InfoEvent().
-		Str("server", c.cfg.ServerAddr).
-		Msg("Connecting to server")
-
-	conn, err := grpc.NewClient(c.cfg.ServerAddr, opts...)
-	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+// tunnelOptions returns the per-tunnel settings sent with CreateTunnel and RegisterTunnel.
+func (c *Client) tunnelOptions() *tunnelv1.TunnelOptions {
+	return &tunnelv1.TunnelOptions{
+		LoadBalancing: c.cfg.LoadBalancing,
+		Access:        c.cfg.Access.Policy(),
+		IpRules:       c.cfg.IPRules.Proto(),
+		HeaderRules:   c.cfg.Headers.Proto(),
+		Edge:          c.cfg.Edge.Proto(),
 	}
+}
 
-	c.mu.Lock()
-	c.conn = conn
-	c.tunnelSvc = tunnelv1.NewTunnelServiceClient(conn)
-	c.mu.Unlock()
-
-	// Create tunnel
-	if err := c.createTunnel(ctx); err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to create tunnel: %w", err)
+// capabilities returns the capabilities sent with the tunnel. Protected
+// tunnels require access policies, IP rules and header rules, so that servers
+// that cannot enforce them refuse the tunnel instead of exposing it. Edge
+// options are required too, so that they never go silently unapplied, and so
+// are the visitor addresses that PROXY headers to the local service carry.
+func (c *Client) capabilities() *tunnelv1.Capabilities {
+	caps := protocol.Local()
+	if c.cfg.Access.Enabled() {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_ACCESS_POLICY)
 	}
-
-	// Start proxy stream
-	if err := c.startProxyStream(ctx); err != nil {
-		conn.Close()
-		return fmt.Errorf("failed to start proxy stream: %w", err)
+	if c.cfg.IPRules.Enabled() {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_IP_RULES)
 	}
-
-	c.mu.Lock()
-	c.connected = true
-	c.mu.Unlock()
-
-	logger.InfoEvent().
-		Str("public_url", c.publicURL).
-		Msg("Tunnel established")
-
-	// Publish connection established event to dashboard
-	if c.eventCollector != nil {
-		c.eventCollector.Publish(events.Event{
-			Type:      events.EventConnectionEstablished,
-			Timestamp: time.Now(),
-			Data: events.ConnectionEvent{
-				TunnelID:  c.tunnelID,
-				PublicURL: c.publicURL,
-				LocalAddr: c.cfg.LocalAddr,
-				Protocol:  c.cfg.Protocol,
-			},
-		})
-	}
-
-	fmt.Printf("\n")
-	fmt.Printf("╔═════════════════════════════════════════════════════════╗\n")
-	fmt.Printf("║                 Tunnel Active                           ║\n")
-	fmt.Printf("╠═════════════════════════════════════════════════════════╣\n")
-	fmt.Printf("║  Public URL:  %-42s║\n", c.publicURL)
-	fmt.Printf("║  Local Addr:  %-42s║\n", c.cfg.LocalAddr)
-	fmt.Printf("║  Protocol:    %-42s║\n", c.cfg.Protocol)
-	fmt.Printf("╚═════════════════════════════════════════════════════════╝\n")
-	fmt.Printf("\n")
-
-	// Create connection monitor channel
-	connLostCh := make(chan struct{}, 1)
-
-	// Start heartbeat with connection monitor
-	go c.startHeartbeat(ctx, connLostCh)
-
-	// Block until context is canceled or connection lost
-	select {
-	case <-ctx.Done():
-		logger.InfoEvent().Msg("Context canceled, closing tunnel")
-
-		// Publish connection lost event
-		if c.eventCollector != nil {
-			c.eventCollector.Publish(events.Event{
-				Type:      events.EventConnectionLost,
-				Timestamp: time.Now(),
-				Data: events.ConnectionEvent{
-					TunnelID: c.tunnelID,
-				},
-			})
-		}
-	case <-connLostCh:
-		logger.WarnEvent().Msg("Connection lost, will reconnect")
-
-		// Publish connection lost event
-		if c.eventCollector != nil {
-			c.eventCollector.Publish(events.Event{
-				Type:      events.EventConnectionLost,
-				Timestamp: time.Now(),
-				Data: events.ConnectionEvent{
-					TunnelID: c.tunnelID,
-				},
-			})
-		}
+	if c.cfg.Headers.Enabled() {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_HEADER_RULES)
 	}
-
-	c.mu.Lock()
-	c.connected = false
-	c.mu.Unlock()
-
-	// Close connection
-	if c.stream != nil {
-		if err := c.stream.CloseSend(); err != nil {
-			logger.WarnEvent().Err(err).Msg("Failed to close stream")
-		}
+	if c.cfg.Edge.Enabled() {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_EDGE_CACHE)
 	}
-	if c.conn != nil {
-		c.conn.Close()
+	if c.cfg.ProxyProtocol != "" {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_TCP_OPEN)
 	}
-
-	// If connection was lost (not context canceled), return error to trigger reconnect
-	select {
-	case <-connLostCh:
-		return fmt.Errorf("connection lost")
-	default:
-		logger.InfoEvent().Msg("Tunnel closed gracefully")
-		return nil
+	if c.cfg.HTTP2 {
+		caps.Required = append(caps.Required, tunnelv1.Feature_FEATURE_TRAILERS)
 	}
+	return caps
 }
 
 // createTunnel creates a tunnel on the server.
 func (c *Client) createTunnel(ctx context.Context) error {
-	protocol := tunnelv1.TunnelProtocol_HTTP
-	if c.cfg.Protocol == "https" {
-		protocol = tunnelv1.TunnelProtocol_HTTPS
-	} else if c.cfg.Protocol == "tcp" {
-		protocol = tunnelv1.TunnelProtocol_TCP
-	}
+	tunnelProtocol := c.tunnelProtocol()
 
 	// Priority: --subdomain takes precedence over --name for subdomain allocation
 	// --name is used for persistent tunnel naming (stored in SavedName field)
@@ -382,78 +317,71 @@ func (c *Client) createTunnel(ctx context.Context) error {
 
 	req := &tunnelv1.CreateTunnelRequest{
 		AuthToken:    c.cfg.AuthToken,
-		Protocol:     protocol,
+		Protocol:     tunnelProtocol,
 		LocalAddress: c.cfg.LocalAddr,
 		Subdomain:    requestedSubdomain,
+		Capabilities: c.capabilities(),
+		Options:      c.tunnelOptions(),
 	}
 
-	resp, err := c.tunnelSvc.CreateTunnel(ctx, req)
+	resp, err := c.session.client().CreateTunnel(ctx, req)
 	if err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			return fmt.Errorf("%w: %s", pkgerrors.ErrIncompatibleProtocol, status.Convert(err).Message())
+		}
 		return fmt.Errorf("CreateTunnel RPC failed: %w", err)
 	}
 
+	// Fall back to the features both sides support (none for servers that predate negotiation)
+	features, err := protocol.Negotiate(resp.Capabilities)
+	if err != nil {
+		return fmt.Errorf("server is not compatible with this client: %w", err)
+	}
+
+	if tunnelProtocol == tunnelv1.TunnelProtocol_UDP && !features.Has(tunnelv1.Feature_FEATURE_UDP) {
+		return fmt.Errorf("%w: server does not support UDP tunnels", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.Access.Enabled() && !features.Has(tunnelv1.Feature_FEATURE_ACCESS_POLICY) {
+		return fmt.Errorf("%w: server does not support tunnel access policies", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.IPRules.Enabled() && !features.Has(tunnelv1.Feature_FEATURE_IP_RULES) {
+		return fmt.Errorf("%w: server does not support tunnel IP rules", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.Headers.Enabled() && !features.Has(tunnelv1.Feature_FEATURE_HEADER_RULES) {
+		return fmt.Errorf("%w: server does not support tunnel header rules", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.Edge.Enabled() && !features.Has(tunnelv1.Feature_FEATURE_EDGE_CACHE) {
+		return fmt.Errorf("%w: server does not support edge compression and caching", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.ProxyProtocol != "" && !features.Has(tunnelv1.Feature_FEATURE_TCP_OPEN) {
+		return fmt.Errorf("%w: server does not send the visitor addresses needed for PROXY headers", pkgerrors.ErrIncompatibleProtocol)
+	}
+	if c.cfg.HTTP2 && !features.Has(tunnelv1.Feature_FEATURE_TRAILERS) {
+		return fmt.Errorf("%w: server does not carry the trailers needed for HTTP/2 upstreams (gRPC)", pkgerrors.ErrIncompatibleProtocol)
+	}
+
+	c.session.setFeatures(features)
+
+	if c.tcpForwarder != nil {
+		c.tcpForwarder.SetFlowControl(features.Has(tunnelv1.Feature_FEATURE_TCP_FLOW_CONTROL))
+	}
+
+	c.mu.Lock()
+	c.features = features
 	c.tunnelID = resp.TunnelId
 	c.publicURL = resp.PublicUrl
+	c.mu.Unlock()
 
 	logger.InfoEvent().
 		Str("tunnel_id", c.tunnelID).
 		Str("public_url", c.publicURL).
+		Str("server_version", resp.GetCapabilities().GetSoftwareVersion()).
+		Int("features", len(features)).
 		Msg("Tunnel created")
 
 	return nil
 }
 
-// maintainConnection maintains connection with automatic reconnection.
-func (c *Client) maintainConnection(ctx context.Context) error {
-	delay := time.Duration(c.cfg.ReconnectCfg.InitialDelay) * time.Second
-	maxDelay := time.Duration(c.cfg.ReconnectCfg.MaxDelay) * time.Second
-	attempts := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
-
-		// Check if we've exceeded max attempts
-		if c.cfg.ReconnectCfg.MaxAttempts > 0 && attempts >= c.cfg.ReconnectCfg.MaxAttempts {
-			return fmt.Errorf("max reconnection attempts (%d) exceeded", c.cfg.ReconnectCfg.MaxAttempts)
-		}
-
-		attempts++
-
-		// Try to connect
-		err := c.connect(ctx)
-		if err == nil {
-			// Connection successful, reset delay
-			delay = time.Duration(c.cfg.ReconnectCfg.InitialDelay) * time.Second
-			attempts = 0
-			continue
-		}
-
-		// Log connection error
-		logger.WarnEvent().
-			Err(err).
-			Int("attempt", attempts).
-			Dur("retry_in", delay).
-			Msg("Connection failed, retrying")
-
-		// Wait before retrying with exponential backoff and jitter
-		jitter := time.Duration(cryptoRandFloat64() * float64(delay) * 0.2)
-		select {
-		case <-time.After(delay + jitter):
-			// Calculate next delay with exponential backoff
-			delay = time.Duration(float64(delay) * float64(c.cfg.ReconnectCfg.BackoffFactor))
-			if delay > maxDelay {
-				delay = maxDelay
-			}
-		case <-ctx.Done():
-			return nil
-		}
-	}
-}
-
 // IsConnected returns whether the client is connected.
 func (c *Client) IsConnected() bool {
 	c.mu.RLock()
@@ -468,19 +396,23 @@ func (c *Client) GetPublicURL() string {
 	return c.publicURL
 }
 
-// Stop gracefully shuts down the client and cleans up resources.
+// Stop gracefully shuts down the session the tunnel belongs to.
 func (c *Client) Stop() error {
-	logger.InfoEvent().Msg("Stopping tunnel client...")
-
-	// Signal stop to all goroutines
-	close(c.stopCh)
+	return c.session.Stop()
+}
 
-	// Close dashboard server
-	if c.dashboardServer != nil {
-		if err := c.dashboardServer.Close(); err != nil {
-			logger.WarnEvent().Err(err).Msg("Failed to close dashboard server")
-		}
+// close releases the tunnel's forwarders.
+func (c *Client) close() {
+	c.mu.Lock()
+	select {
+	case <-c.stopCh:
+		c.mu.Unlock()
+		return
+	default:
+		close(c.stopCh)
 	}
+	c.connected = false
+	c.mu.Unlock()
 
 	// Close forwarders (fixes resource leak)
 	if c.httpForwarder != nil {
@@ -493,13 +425,7 @@ func (c *Client) Stop() error {
 		c.tcpForwarder.Close()
 	}
 
-	// Close gRPC connection
-	if c.conn != nil {
-		if err := c.conn.Close(); err != nil {
-			logger.WarnEvent().Err(err).Msg("Failed to close gRPC connection")
-		}
+	if c.udpForwarder != nil {
+		c.udpForwarder.Close()
 	}
-
-	logger.InfoEvent().Msg("Tunnel client stopped successfully")
-	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/client/config"
 )
 
@@ -60,6 +61,35 @@ func TestNewClient(t *testing.T) {
 				assert.Equal(t, "tcp", c.cfg.Protocol)
 			},
 		},
+		{
+			name: "TLS client",
+			cfg: ClientConfig{
+				ServerAddr: "localhost:50051",
+				Protocol:   "tls",
+				LocalAddr:  "localhost:8443",
+				AuthToken:  "grok_test123",
+			},
+			checkFunc: func(t *testing.T, c *Client) {
+				assert.Nil(t, c.httpForwarder)
+				assert.NotNil(t, c.tcpForwarder)
+				assert.Equal(t, tunnelv1.TunnelProtocol_TLS, c.tunnelProtocol())
+			},
+		},
+		{
+			name: "UDP client",
+			cfg: ClientConfig{
+				ServerAddr: "localhost:50051",
+				Protocol:   "udp",
+				LocalAddr:  "localhost:53",
+				AuthToken:  "grok_test123",
+			},
+			checkFunc: func(t *testing.T, c *Client) {
+				assert.Nil(t, c.httpForwarder)
+				assert.Nil(t, c.tcpForwarder)
+				assert.NotNil(t, c.udpForwarder)
+				assert.Equal(t, tunnelv1.TunnelProtocol_UDP, c.tunnelProtocol())
+			},
+		},
 		{
 			name: "client with custom subdomain",
 			cfg: ClientConfig{
@@ -191,6 +221,71 @@ func TestGetPublicURL(t *testing.T) {
 	assert.Equal(t, "http://abc123.grok.io", client.GetPublicURL())
 }
 
+// TestCapabilities tests that protected tunnels require access policy and IP rule support.
+func TestCapabilities(t *testing.T) {
+	public, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+	assert.Empty(t, public.capabilities().Required)
+	assert.Nil(t, public.tunnelOptions().Access)
+
+	private, err := NewClient(ClientConfig{
+		Protocol:  "http",
+		LocalAddr: "localhost:3000",
+		Access:    config.AccessConfig{BasicAuth: []string{"alice:s3cret"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_ACCESS_POLICY}, private.capabilities().Required)
+	assert.Equal(t, "alice", private.tunnelOptions().GetAccess().GetBasicAuth()[0].GetUsername())
+
+	filtered, err := NewClient(ClientConfig{
+		Protocol:  "tcp",
+		LocalAddr: "localhost:22",
+		IPRules:   config.IPRulesConfig{AllowCIDRs: []string{"203.0.113.0/24"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_IP_RULES}, filtered.capabilities().Required)
+	assert.Equal(t, []string{"203.0.113.0/24"}, filtered.tunnelOptions().GetIpRules().GetAllowCidrs())
+
+	rewritten, err := NewClient(ClientConfig{
+		Protocol:  "http",
+		LocalAddr: "localhost:3000",
+		Headers:   config.HeaderRulesConfig{ResponseRemove: []string{"Server"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_HEADER_RULES}, rewritten.capabilities().Required)
+	assert.Equal(t, "Server", rewritten.tunnelOptions().GetHeaderRules().GetResponse()[0].GetName())
+	assert.Nil(t, rewritten.tunnelOptions().Edge)
+
+	cached, err := NewClient(ClientConfig{
+		Protocol:  "http",
+		LocalAddr: "localhost:3000",
+		Edge:      config.EdgeConfig{Cache: true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_EDGE_CACHE}, cached.capabilities().Required)
+	assert.True(t, cached.tunnelOptions().GetEdge().GetCache())
+	assert.False(t, cached.tunnelOptions().GetEdge().GetCompress())
+
+	proxied, err := NewClient(ClientConfig{
+		Protocol:      "tcp",
+		LocalAddr:     "localhost:5432",
+		ProxyProtocol: "v2",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_TCP_OPEN}, proxied.capabilities().Required)
+
+	h2, err := NewClient(ClientConfig{
+		Protocol:  "http",
+		LocalAddr: "localhost:50051",
+		HTTP2:     true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []tunnelv1.Feature{tunnelv1.Feature_FEATURE_TRAILERS}, h2.capabilities().Required)
+
+	_, err = NewClient(ClientConfig{Protocol: "tcp", LocalAddr: "localhost:5432", ProxyProtocol: "v3"})
+	assert.Error(t, err)
+}
+
 // TestGetSubdomain tests subdomain extraction.
 func TestGetSubdomain(t *testing.T) {
 	tests := []struct {
@@ -295,7 +390,7 @@ func TestSetupTLSConfig(t *testing.T) {
 			},
 			expectError: false,
 			checkFunc: func(t *testing.T, c *Client) {
-				tlsConfig, err := c.setupTLSConfig()
+				tlsConfig, err := c.session.setupTLSConfig()
 				require.NoError(t, err)
 				assert.True(t, tlsConfig.InsecureSkipVerify)
 			},
@@ -313,7 +408,7 @@ func TestSetupTLSConfig(t *testing.T) {
 			},
 			expectError: false,
 			checkFunc: func(t *testing.T, c *Client) {
-				tlsConfig, err := c.setupTLSConfig()
+				tlsConfig, err := c.session.setupTLSConfig()
 				require.NoError(t, err)
 				assert.Equal(t, "tunnel.example.com", tlsConfig.ServerName)
 			},
@@ -337,7 +432,7 @@ func TestSetupTLSConfig(t *testing.T) {
 			client, err := NewClient(tt.cfg)
 			require.NoError(t, err)
 
-			tlsConfig, err := client.setupTLSConfig()
+			tlsConfig, err := client.session.setupTLSConfig()
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -404,7 +499,7 @@ func TestCreateGRPCDialOptions(t *testing.T) {
 			client, err := NewClient(tt.cfg)
 			require.NoError(t, err)
 
-			opts, err := client.createGRPCDialOptions()
+			opts, err := client.session.createGRPCDialOptions()
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// requestBodyBuffer is the number of body frames buffered per streamed request.
+// Servers with body flow control never send more; for others the request is
+// canceled once the local service falls this far behind.
+const requestBodyBuffer = protocol.BodyWindow
+
+// requestBody is an io.ReadCloser fed by the BodyChunk frames of a streamed HTTP request.
+type requestBody struct {
+	chunks    chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	pending   []byte
+	bytesRead atomic.Int64
+	trailer   http.Header // Set from the last frame, before the reader sees EOF
+	consumed  func()      // Called for each frame taken by the reader (nil: no flow control)
+}
+
+// newRequestBody creates an empty streamed request body.
+func newRequestBody() *requestBody {
+	return &requestBody{
+		chunks:  make(chan []byte, requestBodyBuffer),
+		done:    make(chan struct{}),
+		trailer: http.Header{},
+	}
+}
+
+// Trailer implements proxy.TrailerReader.
+func (b *requestBody) Trailer() http.Header {
+	return b.trailer
+}
+
+// push delivers a body frame to the reader without blocking. It reports false when
+// the buffer is full, in which case the frame is not delivered.
+// Must only be called from a single goroutine.
+func (b *requestBody) push(data []byte, endOfStream bool) bool {
+	if len(data) > 0 {
+		select {
+		case b.chunks <- data:
+		case <-b.done:
+		default:
+			return false
+		}
+	}
+
+	if endOfStream {
+		close(b.chunks)
+	}
+	return true
+}
+
+// Read implements io.Reader.
+func (b *requestBody) Read(p []byte) (int, error) {
+	select {
+	case <-b.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	for len(b.pending) == 0 {
+		select {
+		case data, ok := <-b.chunks:
+			if !ok {
+				return 0, io.EOF
+			}
+			b.pending = data
+			if b.consumed != nil {
+				b.consumed()
+			}
+		case <-b.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	b.bytesRead.Add(int64(n))
+	return n, nil
+}
+
+// Close implements io.Closer. Frames pushed later are discarded.
+func (b *requestBody) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+// registerRequestBody creates the body reader for a streamed request.
+// It runs in the receive loop before any body frame for requestID can arrive.
+func (c *Client) registerRequestBody(requestID string) {
+	body := newRequestBody()
+
+	// With flow control, frames read by the local service are returned to the server as credit
+	if c.features.Has(tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL) {
+		credit := protocol.NewCredit(protocol.BodyWindow)
+		body.consumed = func() {
+			grant := credit.Consumed(1)
+			if grant == 0 {
+				return
+			}
+			if err := c.sendBodyWindowUpdate(requestID, grant); err != nil {
+				logger.WarnEvent().
+					Err(err).
+					Str("request_id", requestID).
+					Msg("Failed to send body window update")
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if c.requestBodies == nil {
+		c.requestBodies = make(map[string]*requestBody)
+	}
+	c.requestBodies[requestID] = body
+	c.mu.Unlock()
+}
+
+// deliverRequestBody routes a body frame to its request. Frames for requests that
+// already finished are dropped. A request whose buffer is full is canceled rather
+// than holding up the receive loop, or losing part of its body.
+func (c *Client) deliverRequestBody(requestID string, chunk *tunnelv1.BodyChunk, endOfStream bool) {
+	c.mu.RLock()
+	body, ok := c.requestBodies[requestID]
+	c.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if endOfStream {
+		c.mu.Lock()
+		delete(c.requestBodies, requestID)
+		c.mu.Unlock()
+
+		for name, values := range chunk.GetTrailers() {
+			body.trailer[name] = values.GetValues()
+		}
+	}
+
+	if body.push(chunk.GetData(), endOfStream) {
+		return
+	}
+
+	logger.WarnEvent().
+		Str("request_id", requestID).
+		Msg("Request body buffer full, canceling request")
+	c.releaseRequestBody(requestID, body)
+	c.untrackRequest(requestID)
+}
+
+// takeRequestBody returns the streamed body registered for requestID, or nil.
+func (c *Client) takeRequestBody(requestID string) *requestBody {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.requestBodies[requestID]
+}
+
+// releaseRequestBody closes a streamed body once its request has finished.
+func (c *Client) releaseRequestBody(requestID string, body *requestBody) {
+	c.mu.Lock()
+	if c.requestBodies[requestID] == body {
+		delete(c.requestBodies, requestID)
+	}
+	c.mu.Unlock()
+
+	_ = body.Close()
+}
@@ -0,0 +1,146 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+)
+
+// TestRequestBody_ReadsFramesInOrder tests that pushed frames are read back in order until end of stream.
+func TestRequestBody_ReadsFramesInOrder(t *testing.T) {
+	body := newRequestBody()
+
+	go func() {
+		body.push([]byte("hello "), false)
+		body.push([]byte("streamed "), false)
+		body.push([]byte("world"), true)
+	}()
+
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello streamed world", string(data))
+	assert.Equal(t, int64(len(data)), body.bytesRead.Load())
+}
+
+// TestRequestBody_PushNeverBlocks tests that a full buffer is reported instead of
+// blocking the receive loop, and that frames for a closed reader are discarded.
+func TestRequestBody_PushNeverBlocks(t *testing.T) {
+	body := newRequestBody()
+	for i := 0; i < requestBodyBuffer; i++ {
+		require.True(t, body.push([]byte("x"), false))
+	}
+	assert.False(t, body.push([]byte("overflow"), false))
+
+	require.NoError(t, body.Close())
+	assert.True(t, body.push([]byte("discarded"), false))
+
+	_, err := body.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+// TestRequestBody_ConsumedPerFrame tests that each frame taken by the reader is
+// counted once, however it is read.
+func TestRequestBody_ConsumedPerFrame(t *testing.T) {
+	body := newRequestBody()
+	var consumed int
+	body.consumed = func() { consumed++ }
+
+	body.push([]byte("hello "), false)
+	body.push([]byte("world"), true)
+
+	buf := make([]byte, 2)
+	var data []byte
+	for {
+		n, err := body.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "hello world", string(data))
+	assert.Equal(t, 2, consumed)
+}
+
+// TestClient_DeliverRequestBody tests routing of body frames to registered
+// requests, and the trailers of the last frame.
+func TestClient_DeliverRequestBody(t *testing.T) {
+	c := &Client{}
+	c.registerRequestBody("req-1")
+
+	body := c.takeRequestBody("req-1")
+	require.NotNil(t, body)
+
+	c.deliverRequestBody("req-1", &tunnelv1.BodyChunk{
+		Data:     []byte("payload"),
+		Trailers: map[string]*tunnelv1.HeaderValues{"Checksum": {Values: []string{"abc"}}},
+	}, true)
+	c.deliverRequestBody("unknown", &tunnelv1.BodyChunk{Data: []byte("ignored")}, true)
+
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(data))
+	assert.Equal(t, "abc", body.Trailer().Get("Checksum"))
+	assert.Nil(t, c.takeRequestBody("req-1"))
+}
+
+// TestClient_DeliverRequestBodyOverflow tests that a request whose body buffer is
+// full is canceled instead of blocking the receive loop.
+func TestClient_DeliverRequestBodyOverflow(t *testing.T) {
+	c := &Client{}
+	c.registerRequestBody("req-1")
+	reqCtx := c.trackRequest(context.Background(), "req-1")
+	body := c.takeRequestBody("req-1")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= requestBodyBuffer; i++ {
+			c.deliverRequestBody("req-1", &tunnelv1.BodyChunk{Data: []byte("x")}, false)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("deliverRequestBody blocked on a full buffer")
+	}
+
+	assert.Error(t, reqCtx.Err(), "request should be canceled")
+	assert.Nil(t, c.takeRequestBody("req-1"))
+	_, err := body.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+}
+
+// TestClient_ResponseWindow tests that window updates from the server return
+// credit to a streamed response, and that flow control follows negotiation.
+func TestClient_ResponseWindow(t *testing.T) {
+	assert.Nil(t, (&Client{}).openResponseWindow("req-1"))
+
+	c := &Client{features: protocol.Features{tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL: {}}}
+	window := c.openResponseWindow("req-1")
+	require.NotNil(t, window)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := window.Acquire(ctx, protocol.BodyWindow+1)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.BodyWindow, n)
+
+	c.responseWindowUpdate("req-1", 4)
+	n, err = window.Acquire(ctx, protocol.BodyWindow)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	c.closeResponseWindow("req-1", window)
+	c.responseWindowUpdate("req-1", 4) // Finished responses ignore late updates
+	_, err = window.Acquire(ctx, 1)
+	assert.ErrorIs(t, err, protocol.ErrWindowClosed)
+}
@@ -0,0 +1,45 @@
+package tunnel
+
+import (
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+)
+
+// openResponseWindow creates the send credit of a streamed response, or returns nil
+// when the server takes body frames without credit.
+func (c *Client) openResponseWindow(requestID string) *protocol.Window {
+	if !c.features.Has(tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL) {
+		return nil
+	}
+
+	window := protocol.NewWindow(protocol.BodyWindow)
+	c.mu.Lock()
+	if c.respWindows == nil {
+		c.respWindows = make(map[string]*protocol.Window)
+	}
+	c.respWindows[requestID] = window
+	c.mu.Unlock()
+	return window
+}
+
+// closeResponseWindow releases the send credit of a finished response. Safe on nil.
+func (c *Client) closeResponseWindow(requestID string, window *protocol.Window) {
+	if window == nil {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.respWindows, requestID)
+	c.mu.Unlock()
+	window.Close()
+}
+
+// responseWindowUpdate returns credit from the server to a streamed response.
+// Updates for responses that already finished are dropped.
+func (c *Client) responseWindowUpdate(requestID string, frames uint32) {
+	c.mu.RLock()
+	window := c.respWindows[requestID]
+	c.mu.RUnlock()
+
+	window.Release(int(frames))
+}
@@ -0,0 +1,672 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/client/dashboard"
+	"github.com/pandeptwidyaop/grok/internal/client/dashboard/events"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// Session is a single connection to the server carrying any number of tunnels.
+// When the server supports multiplexing, all tunnels share one ProxyStream and
+// messages are routed by tunnel ID; otherwise each tunnel opens its own stream
+// on the shared connection.
+type Session struct {
+	cfg             ClientConfig // Server, auth, reconnect and dashboard settings
+	conn            *grpc.ClientConn
+	tunnelSvc       tunnelv1.TunnelServiceClient
+	features        protocol.Features                        // Protocol features negotiated with the server
+	stream          tunnelv1.TunnelService_ProxyStreamClient // Shared stream when multiplexing
+	tunnels         []*Client
+	dashboardServer *dashboard.Server      // Dashboard HTTP server
+	eventCollector  *events.EventCollector // Event collector for dashboard
+	connCtx         context.Context        // Context of the current connection
+	connLostCh      chan struct{}          // Signals connect to reconnect
+	refs            int
+	mu              sync.RWMutex
+	sendMu          sync.Mutex // Protects Send on the shared stream
+	connected       bool
+}
+
+// NewSession creates a session. Tunnel-specific fields of cfg are ignored;
+// tunnels are added with AddTunnel.
+func NewSession(cfg ClientConfig) *Session {
+	s := &Session{cfg: cfg}
+
+	// Initialize dashboard if port is configured
+	if cfg.DashboardCfg.Port > 0 {
+		s.dashboardServer = dashboard.NewServer(cfg.DashboardCfg)
+		s.eventCollector = s.dashboardServer.GetEventCollector()
+
+		logger.InfoEvent().
+			Int("port", cfg.DashboardCfg.Port).
+			Msg("Dashboard enabled")
+	}
+
+	return s
+}
+
+// AddTunnel adds a tunnel to the session. Server, auth and reconnect settings
+// come from the session. If the session is connected, the tunnel is created
+// and registered right away without reconnecting.
+func (s *Session) AddTunnel(cfg ClientConfig) (*Client, error) {
+	cfg.ServerAddr = s.cfg.ServerAddr
+	cfg.TLS = s.cfg.TLS
+	cfg.TLSCertFile = s.cfg.TLSCertFile
+	cfg.TLSInsecure = s.cfg.TLSInsecure
+	cfg.TLSServerName = s.cfg.TLSServerName
+	cfg.AuthToken = s.cfg.AuthToken
+	cfg.ReconnectCfg = s.cfg.ReconnectCfg
+	cfg.DashboardCfg = s.cfg.DashboardCfg
+
+	c, err := newClient(cfg, s)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.tunnels = append(s.tunnels, c)
+	connected := s.connected
+	ctx := s.connCtx
+	s.mu.Unlock()
+
+	if !connected {
+		return c, nil
+	}
+
+	if err := s.openTunnel(ctx, c); err != nil {
+		s.removeFromList(c)
+		c.disconnect()
+		c.close()
+		return nil, fmt.Errorf("failed to add tunnel: %w", err)
+	}
+	c.announce()
+	c.printBanner()
+
+	return c, nil
+}
+
+// RemoveTunnel unregisters a tunnel and releases its resources. Other tunnels
+// on the session are not affected.
+func (s *Session) RemoveTunnel(c *Client) error {
+	if !s.removeFromList(c) {
+		return fmt.Errorf("tunnel %s is not part of this session", c.ref)
+	}
+
+	c.mu.RLock()
+	tunnelID := c.tunnelID
+	stream := c.stream
+	connected := c.connected
+	c.mu.RUnlock()
+
+	if connected && stream != nil {
+		if s.hasFeature(tunnelv1.Feature_FEATURE_MULTIPLEX) {
+			msg := &tunnelv1.ProxyMessage{
+				Message: &tunnelv1.ProxyMessage_Unregister{
+					Unregister: &tunnelv1.UnregisterTunnel{TunnelId: tunnelID},
+				},
+			}
+			if err := c.sendStream(msg); err != nil {
+				logger.WarnEvent().Err(err).Str("tunnel_id", tunnelID).Msg("Failed to unregister tunnel")
+			}
+		} else {
+			// Detached first so the receive loop does not take this for a lost connection
+			c.mu.Lock()
+			c.stream = nil
+			c.mu.Unlock()
+			if err := stream.CloseSend(); err != nil {
+				logger.WarnEvent().Err(err).Msg("Failed to close stream")
+			}
+		}
+	}
+
+	c.close()
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnelID).
+		Str("local_addr", c.cfg.LocalAddr).
+		Msg("Tunnel removed")
+
+	return nil
+}
+
+// Tunnels returns the tunnels of the session.
+func (s *Session) Tunnels() []*Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Client(nil), s.tunnels...)
+}
+
+// Start connects to the server and serves all tunnels until ctx is canceled.
+func (s *Session) Start(ctx context.Context) error {
+	logger.InfoEvent().
+		Str("server", s.cfg.ServerAddr).
+		Int("tunnels", len(s.Tunnels())).
+		Msg("Starting tunnel client")
+
+	// Start dashboard server if enabled
+	if s.dashboardServer != nil {
+		dashboardCtx, dashboardCancel := context.WithCancel(ctx)
+		defer dashboardCancel()
+
+		go func() {
+			if err := s.dashboardServer.Start(dashboardCtx); err != nil {
+				logger.ErrorEvent().Err(err).Msg("Dashboard server error")
+			}
+		}()
+
+		// Wait briefly for dashboard to start
+		time.Sleep(100 * time.Millisecond)
+
+		fmt.Printf("\n")
+		fmt.Printf("╔═════════════════════════════════════════════════════════╗\n")
+		fmt.Printf("║              Dashboard Available                        ║\n")
+		fmt.Printf("╠═════════════════════════════════════════════════════════╣\n")
+		fmt.Printf("║  Dashboard:  http://127.0.0.1:%-26d║\n", s.dashboardServer.Port())
+		fmt.Printf("╚═════════════════════════════════════════════════════════╝\n")
+		fmt.Printf("\n")
+	}
+
+	// Start connection loop with reconnection
+	if s.cfg.ReconnectCfg.Enabled {
+		return s.maintainConnection(ctx)
+	}
+
+	// Single connection without reconnection
+	return s.connect(ctx)
+}
+
+// Stop gracefully shuts down the session and cleans up resources.
+func (s *Session) Stop() error {
+	logger.InfoEvent().Msg("Stopping tunnel client...")
+
+	// Close dashboard server
+	if s.dashboardServer != nil {
+		if err := s.dashboardServer.Close(); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to close dashboard server")
+		}
+	}
+
+	for _, c := range s.Tunnels() {
+		c.close()
+	}
+
+	// Close gRPC connection
+	s.mu.RLock()
+	conn := s.conn
+	s.mu.RUnlock()
+	if conn != nil {
+		if err := conn.Close(); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to close gRPC connection")
+		}
+	}
+
+	logger.InfoEvent().Msg("Tunnel client stopped successfully")
+	return nil
+}
+
+// connect establishes connection to server and registers all tunnels.
+func (s *Session) connect(ctx context.Context) error {
+	opts, err := s.createGRPCDialOptions()
+	if err != nil {
+		return err
+	}
+
+	logger.InfoEvent().
+		Str("server", s.cfg.ServerAddr).
+		Msg("Connecting to server")
+
+	conn, err := grpc.NewClient(s.cfg.ServerAddr, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %w", err)
+	}
+
+	// Create connection monitor channel
+	connLostCh := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	s.conn = conn
+	s.tunnelSvc = tunnelv1.NewTunnelServiceClient(conn)
+	s.stream = nil
+	s.connCtx = ctx
+	s.connLostCh = connLostCh
+	s.mu.Unlock()
+
+	// A tunnel the server rejects stays offline while the others are served;
+	// it is registered again on the next reconnect
+	tunnels := s.Tunnels()
+	var online []*Client
+	var rejectErr error
+	for _, c := range tunnels {
+		err := s.openTunnel(ctx, c)
+		if err == nil {
+			online = append(online, c)
+			continue
+		}
+		if errors.Is(err, pkgerrors.ErrTunnelRejected) && len(tunnels) > 1 {
+			logger.ErrorEvent().
+				Err(err).
+				Str("local_addr", c.cfg.LocalAddr).
+				Msg("Tunnel is offline")
+			c.disconnect()
+			rejectErr = err
+			continue
+		}
+		conn.Close()
+		return err
+	}
+	if len(online) == 0 && rejectErr != nil {
+		conn.Close()
+		return rejectErr
+	}
+
+	s.mu.Lock()
+	s.connected = true
+	s.mu.Unlock()
+
+	for _, c := range online {
+		c.announce()
+	}
+	s.printStatus(tunnels)
+
+	// Start heartbeat with connection monitor
+	go s.startHeartbeat(ctx, connLostCh)
+
+	// Block until context is canceled or connection lost
+	select {
+	case <-ctx.Done():
+		logger.InfoEvent().Msg("Context canceled, closing tunnel")
+	case <-connLostCh:
+		logger.WarnEvent().Msg("Connection lost, will reconnect")
+	}
+
+	s.mu.Lock()
+	s.connected = false
+	s.mu.Unlock()
+
+	for _, c := range s.Tunnels() {
+		c.disconnect()
+	}
+
+	// Close connection
+	s.mu.Lock()
+	if s.stream != nil {
+		if err := s.stream.CloseSend(); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to close stream")
+		}
+		s.stream = nil
+	}
+	s.mu.Unlock()
+	conn.Close()
+
+	// If connection was lost (not context canceled), return error to trigger reconnect
+	select {
+	case <-connLostCh:
+		return fmt.Errorf("connection lost")
+	default:
+		logger.InfoEvent().Msg("Tunnel closed gracefully")
+		return nil
+	}
+}
+
+// printStatus prints the tunnel banner, or a combined table for several tunnels.
+func (s *Session) printStatus(tunnels []*Client) {
+	if len(tunnels) == 1 {
+		tunnels[0].printBanner()
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nTUNNEL\tPROTOCOL\tPUBLIC URL\tLOCAL ADDR\tSTATUS\n")
+	for _, c := range tunnels {
+		name := c.cfg.Name
+		if name == "" {
+			name = c.getSubdomain()
+		}
+
+		status := "offline"
+		if c.IsConnected() {
+			status = "online"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, c.cfg.Protocol, c.GetPublicURL(), c.cfg.LocalAddr, status)
+	}
+	if err := w.Flush(); err != nil {
+		logger.WarnEvent().Err(err).Msg("Failed to print tunnel status")
+	}
+	fmt.Printf("\n")
+}
+
+// openTunnel creates a tunnel on the server and registers it, on the shared
+// stream when the server supports multiplexing or on a stream of its own otherwise.
+func (s *Session) openTunnel(ctx context.Context, c *Client) error {
+	if err := c.createTunnel(ctx); err != nil {
+		return fmt.Errorf("failed to create tunnel: %w", err)
+	}
+
+	if !s.hasFeature(tunnelv1.Feature_FEATURE_MULTIPLEX) {
+		if err := c.startProxyStream(ctx); err != nil {
+			return fmt.Errorf("failed to start proxy stream: %w", err)
+		}
+		return nil
+	}
+
+	stream, err := s.sharedStream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start proxy stream: %w", err)
+	}
+
+	c.mu.Lock()
+	c.stream = stream
+	c.mu.Unlock()
+
+	return c.register(ctx)
+}
+
+// maintainConnection maintains connection with automatic reconnection.
+func (s *Session) maintainConnection(ctx context.Context) error {
+	delay := time.Duration(s.cfg.ReconnectCfg.InitialDelay) * time.Second
+	maxDelay := time.Duration(s.cfg.ReconnectCfg.MaxDelay) * time.Second
+	attempts := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		// Check if we've exceeded max attempts
+		if s.cfg.ReconnectCfg.MaxAttempts > 0 && attempts >= s.cfg.ReconnectCfg.MaxAttempts {
+			return fmt.Errorf("max reconnection attempts (%d) exceeded", s.cfg.ReconnectCfg.MaxAttempts)
+		}
+
+		attempts++
+
+		// Try to connect
+		err := s.connect(ctx)
+		if err == nil {
+			// Connection successful, reset delay
+			delay = time.Duration(s.cfg.ReconnectCfg.InitialDelay) * time.Second
+			attempts = 0
+			continue
+		}
+
+		// Incompatible versions won't fix themselves; stop retrying
+		if errors.Is(err, pkgerrors.ErrIncompatibleProtocol) {
+			return err
+		}
+
+		// Log connection error
+		logger.WarnEvent().
+			Err(err).
+			Int("attempt", attempts).
+			Dur("retry_in", delay).
+			Msg("Connection failed, retrying")
+
+		// Wait before retrying with exponential backoff and jitter
+		jitter := time.Duration(cryptoRandFloat64() * float64(delay) * 0.2)
+		select {
+		case <-time.After(delay + jitter):
+			// Calculate next delay with exponential backoff
+			delay = time.Duration(float64(delay) * float64(s.cfg.ReconnectCfg.BackoffFactor))
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// client returns the gRPC service client of the current connection.
+func (s *Session) client() tunnelv1.TunnelServiceClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tunnelSvc
+}
+
+// setFeatures records the features negotiated with the server.
+func (s *Session) setFeatures(features protocol.Features) {
+	s.mu.Lock()
+	s.features = features
+	s.mu.Unlock()
+}
+
+// hasFeature reports whether a feature was negotiated with the server.
+func (s *Session) hasFeature(f tunnelv1.Feature) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.features.Has(f)
+}
+
+// nextRef returns a new session-unique tunnel reference.
+func (s *Session) nextRef() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refs++
+	return strconv.Itoa(s.refs)
+}
+
+// removeFromList drops c from the session's tunnels and reports whether it was present.
+func (s *Session) removeFromList(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, other := range s.tunnels {
+		if other == c {
+			s.tunnels = append(s.tunnels[:i], s.tunnels[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// callOptions returns the options of streams opened after negotiation:
+// messages are gzip-compressed when the server supports it.
+func (s *Session) callOptions() []grpc.CallOption {
+	if s.hasFeature(tunnelv1.Feature_FEATURE_COMPRESSION) {
+		return []grpc.CallOption{grpc.UseCompressor("gzip")}
+	}
+	return nil
+}
+
+// openStream opens a ProxyStream on the current connection.
+func (s *Session) openStream(ctx context.Context) (tunnelv1.TunnelService_ProxyStreamClient, error) {
+	stream, err := s.client().ProxyStream(ctx, s.callOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy stream: %w", err)
+	}
+
+	logger.InfoEvent().Msg("Proxy stream established")
+	return stream, nil
+}
+
+// sharedStream returns the multiplexed stream, opening it on first use.
+func (s *Session) sharedStream(ctx context.Context) (tunnelv1.TunnelService_ProxyStreamClient, error) {
+	s.mu.RLock()
+	stream := s.stream
+	s.mu.RUnlock()
+	if stream != nil {
+		return stream, nil
+	}
+
+	raw, err := s.openStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream = &lockedStream{TunnelService_ProxyStreamClient: raw, mu: &s.sendMu}
+
+	s.mu.Lock()
+	s.stream = stream
+	s.mu.Unlock()
+
+	go s.receive(ctx, stream)
+	return stream, nil
+}
+
+// receive reads the shared stream and hands each message to its tunnel.
+func (s *Session) receive(ctx context.Context, stream tunnelv1.TunnelService_ProxyStreamClient) {
+	// The heartbeat may keep succeeding while the stream is gone
+	connLostCh := s.connectionLostCh()
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				logger.InfoEvent().Msg("Server closed stream")
+			} else {
+				logger.ErrorEvent().
+					Err(err).
+					Msg("Error receiving from stream")
+			}
+
+			// All tunnels are gone with the stream; reconnect unless it was closed on purpose
+			s.mu.RLock()
+			current := s.stream == stream
+			s.mu.RUnlock()
+			if current && ctx.Err() == nil {
+				signalConnectionLost(connLostCh)
+			}
+			return
+		}
+
+		// Unregister acknowledgements arrive after the tunnel was removed
+		c := s.route(msg)
+		if c == nil {
+			logger.DebugEvent().Msg("Received message for unknown tunnel")
+			continue
+		}
+
+		c.handleMessage(ctx, msg)
+	}
+}
+
+// route returns the tunnel a server message is addressed to. Registration
+// replies carry the client reference; everything else carries the tunnel ID.
+// Messages for unknown tunnels, e.g. one that was just removed, are dropped;
+// only messages without an address (legacy servers) go to a lone tunnel.
+func (s *Session) route(msg *tunnelv1.ProxyMessage) *Client {
+	var ref, tunnelID string
+	switch payload := msg.Message.(type) {
+	case *tunnelv1.ProxyMessage_Request:
+		tunnelID = payload.Request.TunnelId
+	case *tunnelv1.ProxyMessage_Registered:
+		ref = payload.Registered.Ref
+	case *tunnelv1.ProxyMessage_Rejected:
+		ref = payload.Rejected.Ref
+	case *tunnelv1.ProxyMessage_Control:
+		tunnelID = payload.Control.TunnelId
+	case *tunnelv1.ProxyMessage_Error:
+		tunnelID = payload.Error.TunnelId
+	}
+
+	tunnels := s.Tunnels()
+	for _, c := range tunnels {
+		c.mu.RLock()
+		match := (ref != "" && c.ref == ref) || (tunnelID != "" && c.tunnelID == tunnelID)
+		c.mu.RUnlock()
+		if match {
+			return c
+		}
+	}
+
+	if ref == "" && tunnelID == "" && len(tunnels) == 1 {
+		return tunnels[0]
+	}
+	return nil
+}
+
+// connectionLostCh returns the channel that makes connect tear down the
+// current connection and reconnect.
+func (s *Session) connectionLostCh() chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.connLostCh
+}
+
+// startHeartbeat starts sending heartbeat messages to server.
+func (s *Session) startHeartbeat(ctx context.Context, connLostCh chan struct{}) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	heartbeatStream, err := s.client().Heartbeat(ctx, s.callOptions()...)
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to create heartbeat stream")
+		signalConnectionLost(connLostCh)
+		return
+	}
+
+	logger.DebugEvent().Msg("Heartbeat stream established")
+
+	heartbeatErrCh := make(chan error, 1)
+	go receiveHeartbeats(heartbeatStream, heartbeatErrCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := heartbeatStream.CloseSend(); err != nil {
+				logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
+			}
+			return
+
+		case err := <-heartbeatErrCh:
+			logger.WarnEvent().Err(err).Msg("Heartbeat failed, signaling connection lost")
+			if err := heartbeatStream.CloseSend(); err != nil {
+				logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
+			}
+			signalConnectionLost(connLostCh)
+			return
+
+		case <-ticker.C:
+			var tunnelID string
+			if tunnels := s.Tunnels(); len(tunnels) > 0 {
+				tunnels[0].mu.RLock()
+				tunnelID = tunnels[0].tunnelID
+				tunnels[0].mu.RUnlock()
+			}
+
+			req := &tunnelv1.HeartbeatRequest{
+				TunnelId:  tunnelID,
+				Timestamp: time.Now().Unix(),
+			}
+
+			if err := heartbeatStream.Send(req); err != nil {
+				logger.ErrorEvent().Err(err).Msg("Failed to send heartbeat")
+				if err := heartbeatStream.CloseSend(); err != nil {
+					logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
+				}
+				signalConnectionLost(connLostCh)
+				return
+			}
+
+			logger.DebugEvent().Msg("Heartbeat sent")
+		}
+	}
+}
+
+// lockedStream serializes sends on a ProxyStream shared by several tunnels.
+type lockedStream struct {
+	tunnelv1.TunnelService_ProxyStreamClient
+	mu *sync.Mutex
+}
+
+// Send sends a message under the shared stream lock.
+func (l *lockedStream) Send(msg *tunnelv1.ProxyMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.TunnelService_ProxyStreamClient.Send(msg)
+}
@@ -0,0 +1,190 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// TestSessionAddTunnel tests that tunnels inherit connection settings from the session.
+func TestSessionAddTunnel(t *testing.T) {
+	session := NewSession(ClientConfig{
+		ServerAddr: "localhost:50051",
+		AuthToken:  "grok_test123",
+		TLS:        true,
+	})
+
+	web, err := session.AddTunnel(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+	db, err := session.AddTunnel(ClientConfig{Protocol: "tcp", LocalAddr: "localhost:5432"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:50051", web.cfg.ServerAddr)
+	assert.Equal(t, "grok_test123", db.cfg.AuthToken)
+	assert.True(t, db.cfg.TLS)
+	assert.NotNil(t, web.httpForwarder)
+	assert.NotNil(t, db.tcpForwarder)
+	assert.NotEqual(t, web.ref, db.ref)
+	assert.Len(t, session.Tunnels(), 2)
+
+	require.NoError(t, session.RemoveTunnel(web))
+	assert.Equal(t, []*Client{db}, session.Tunnels())
+	assert.Error(t, session.RemoveTunnel(web))
+}
+
+// TestSessionRoute tests routing of server messages to tunnels.
+func TestSessionRoute(t *testing.T) {
+	session := NewSession(ClientConfig{})
+
+	web, err := session.AddTunnel(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+	api, err := session.AddTunnel(ClientConfig{Protocol: "http", LocalAddr: "localhost:4000"})
+	require.NoError(t, err)
+
+	web.tunnelID = "tunnel-web"
+	api.tunnelID = "tunnel-api"
+
+	tests := []struct {
+		name     string
+		msg      *tunnelv1.ProxyMessage
+		expected *Client
+	}{
+		{
+			name: "request by tunnel ID",
+			msg: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Request{
+				Request: &tunnelv1.ProxyRequest{TunnelId: "tunnel-api"},
+			}},
+			expected: api,
+		},
+		{
+			name: "registered by ref",
+			msg: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Registered{
+				Registered: &tunnelv1.Registered{TunnelId: "reassigned", Ref: web.ref},
+			}},
+			expected: web,
+		},
+		{
+			name: "rejected by ref",
+			msg: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Rejected{
+				Rejected: &tunnelv1.Rejected{Ref: api.ref},
+			}},
+			expected: api,
+		},
+		{
+			name: "control by tunnel ID",
+			msg: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Control{
+				Control: &tunnelv1.ControlMessage{TunnelId: "tunnel-web"},
+			}},
+			expected: web,
+		},
+		{
+			name: "unknown tunnel",
+			msg: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Request{
+				Request: &tunnelv1.ProxyRequest{TunnelId: "other"},
+			}},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Same(t, tt.expected, session.route(tt.msg))
+		})
+	}
+}
+
+// TestSessionRoute_SingleTunnel tests that a lone tunnel receives messages
+// without a tunnel ID, but not those of other tunnels.
+func TestSessionRoute_SingleTunnel(t *testing.T) {
+	client, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+
+	msg := &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Control{
+		Control: &tunnelv1.ControlMessage{Type: tunnelv1.ControlMessage_UNKNOWN},
+	}}
+	assert.Same(t, client, client.session.route(msg))
+
+	// Messages of another tunnel, e.g. the close ack of a removed one, are not its own
+	client.tunnelID = "tunnel-web"
+	closed := &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Control{
+		Control: &tunnelv1.ControlMessage{Type: tunnelv1.ControlMessage_TUNNEL_CLOSED, TunnelId: "tunnel-removed"},
+	}}
+	assert.Nil(t, client.session.route(closed))
+}
+
+// replyingStream answers every message sent on it.
+type replyingStream struct {
+	tunnelv1.TunnelService_ProxyStreamClient
+	reply func(*tunnelv1.ProxyMessage)
+}
+
+func (s *replyingStream) Send(msg *tunnelv1.ProxyMessage) error {
+	go s.reply(msg)
+	return nil
+}
+
+// TestClientRegister tests that registration waits for the server's reply.
+func TestClientRegister(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    *tunnelv1.ProxyMessage
+		expected error
+	}{
+		{
+			name: "registered",
+			reply: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Registered{
+				Registered: &tunnelv1.Registered{TunnelId: "tunnel-web"},
+			}},
+		},
+		{
+			name: "rejected",
+			reply: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Rejected{
+				Rejected: &tunnelv1.Rejected{Code: tunnelv1.ErrorCode_SUBDOMAIN_TAKEN, Message: "subdomain taken"},
+			}},
+			expected: pkgerrors.ErrTunnelRejected,
+		},
+		{
+			name: "incompatible",
+			reply: &tunnelv1.ProxyMessage{Message: &tunnelv1.ProxyMessage_Rejected{
+				Rejected: &tunnelv1.Rejected{Code: tunnelv1.ErrorCode_INCOMPATIBLE_CLIENT},
+			}},
+			expected: pkgerrors.ErrIncompatibleProtocol,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+			require.NoError(t, err)
+			client.features = protocol.Features{tunnelv1.Feature_FEATURE_TYPED_REGISTRATION: {}}
+			client.stream = &replyingStream{reply: func(*tunnelv1.ProxyMessage) {
+				client.handleMessage(context.Background(), tt.reply)
+			}}
+
+			err = client.register(context.Background())
+			if tt.expected == nil {
+				require.NoError(t, err)
+				assert.Equal(t, "tunnel-web", client.tunnelID)
+			} else {
+				assert.ErrorIs(t, err, tt.expected)
+			}
+		})
+	}
+
+	// No reply: registration gives up with its context
+	client, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+	client.features = protocol.Features{tunnelv1.Feature_FEATURE_TYPED_REGISTRATION: {}}
+	client.stream = &replyingStream{reply: func(*tunnelv1.ProxyMessage) {}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, client.register(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// CreateShareLink asks the server for a link that lets visitors into the
+// online tunnel with the given saved name or subdomain for ttl (the server
+// default when 0). Only server and auth settings of cfg are used.
+func CreateShareLink(ctx context.Context, cfg ClientConfig, name string, ttl time.Duration) (string, time.Time, error) {
+	s := &Session{cfg: cfg}
+	opts, err := s.createGRPCDialOptions()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	conn, err := grpc.NewClient(cfg.ServerAddr, opts...)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	defer conn.Close()
+
+	resp, err := tunnelv1.NewTunnelServiceClient(conn).CreateShareLink(ctx, &tunnelv1.CreateShareLinkRequest{
+		AuthToken:  cfg.AuthToken,
+		Tunnel:     name,
+		TtlSeconds: int64(ttl / time.Second),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create share link: %s", status.Convert(err).Message())
+	}
+
+	return resp.Url, time.Unix(resp.ExpiresAt, 0), nil
+}
@@ -12,9 +12,14 @@ import (
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/client/dashboard/events"
 	"github.com/pandeptwidyaop/grok/internal/client/proxy"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 )
 
+// registrationTimeout bounds how long a tunnel waits for the server to accept its registration.
+const registrationTimeout = 30 * time.Second
+
 // wsClientBufferPool pools 32KB buffers for WebSocket read operations to reduce GC pressure.
 var wsClientBufferPool = sync.Pool{
 	New: func() interface{} {
@@ -38,29 +43,116 @@ func convertHeaders(pbHeaders map[string]*tunnelv1.HeaderValues) map[string][]st
 	return headers
 }
 
-// startProxyStream starts the bidirectional proxy stream.
+// startProxyStream opens a stream of the tunnel's own and registers on it.
+// Used when the server does not support multiplexing.
 func (c *Client) startProxyStream(ctx context.Context) error {
-	stream, err := c.tunnelSvc.ProxyStream(ctx)
+	stream, err := c.session.openStream(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create proxy stream: %w", err)
+		return err
 	}
 
 	c.mu.Lock()
 	c.stream = stream
 	c.mu.Unlock()
 
-	logger.InfoEvent().Msg("Proxy stream established")
+	// Start receiving before registering, the reply arrives on the stream
+	go c.receiveRequests(ctx, stream)
 
-	// Send registration control message with tunnel details
-	// Format: subdomain|token|localaddr|publicurl|savedname(optional)
-	c.mu.RLock()
+	return c.register(ctx)
+}
+
+// register sends the tunnel registration on the tunnel's stream: typed tunnel
+// details, or the legacy pipe-delimited control message for servers without
+// typed registration. Typed registrations wait for the server's Registered or
+// Rejected reply.
+func (c *Client) register(ctx context.Context) error {
 	subdomain := c.getSubdomain()
-	token := c.cfg.AuthToken
-	localAddr := c.cfg.LocalAddr
-	publicURL := c.publicURL
-	savedName := c.cfg.SavedName
+	c.mu.RLock()
+	typed := c.features.Has(tunnelv1.Feature_FEATURE_TYPED_REGISTRATION)
+	var regMsg *tunnelv1.ProxyMessage
+	if typed {
+		regMsg = &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    c.cfg.AuthToken,
+					Protocol:     c.tunnelProtocol(),
+					LocalAddress: c.cfg.LocalAddr,
+					Subdomain:    subdomain,
+					PublicUrl:    c.publicURL,
+					SavedName:    c.cfg.SavedName,
+					WebhookAppId: c.cfg.WebhookAppID,
+					Options:      c.tunnelOptions(),
+					Capabilities: c.capabilities(),
+					Ref:          c.ref,
+				},
+			},
+		}
+	} else {
+		regMsg = legacyRegistrationMessage(subdomain, c.cfg.AuthToken, c.cfg.LocalAddr, c.publicURL, c.cfg.SavedName)
+	}
 	c.mu.RUnlock()
 
+	var reply chan error
+	if typed {
+		reply = make(chan error, 1)
+		c.mu.Lock()
+		c.registration = reply
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			if c.registration == reply {
+				c.registration = nil
+			}
+			c.mu.Unlock()
+		}()
+	}
+
+	if err := c.sendStream(regMsg); err != nil {
+		return fmt.Errorf("failed to send registration message: %w", err)
+	}
+
+	logger.DebugEvent().Msg("Sent tunnel registration message")
+
+	// Servers without typed registration close the stream on failure instead of replying
+	if !typed {
+		return nil
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(registrationTimeout):
+		return fmt.Errorf("no registration reply from server within %s", registrationTimeout)
+	}
+}
+
+// completeRegistration hands the server's reply to a pending register call.
+func (c *Client) completeRegistration(err error) {
+	c.mu.Lock()
+	reply := c.registration
+	c.registration = nil
+	c.mu.Unlock()
+
+	if reply != nil {
+		reply <- err
+	}
+}
+
+// rejectionError converts a Rejected reply into an error. Incompatible
+// clients get ErrIncompatibleProtocol, which stops reconnecting.
+func rejectionError(rejected *tunnelv1.Rejected) error {
+	sentinel := pkgerrors.ErrTunnelRejected
+	if rejected.Code == tunnelv1.ErrorCode_INCOMPATIBLE_CLIENT {
+		sentinel = pkgerrors.ErrIncompatibleProtocol
+	}
+	return fmt.Errorf("%w (%s): %s", sentinel, rejected.Code, rejected.Message)
+}
+
+// legacyRegistrationMessage builds the deprecated pipe-delimited registration control message.
+// Format: subdomain|token|localaddr|publicurl|savedname(optional).
+func legacyRegistrationMessage(subdomain, token, localAddr, publicURL, savedName string) *tunnelv1.ProxyMessage {
 	// Build registration data using strings.Builder to reduce allocations
 	var regBuilder strings.Builder
 	regBuilder.Grow(len(subdomain) + len(token) + len(localAddr) + len(publicURL) + len(savedName) + 4)
@@ -73,27 +165,15 @@ func (c *Client) startProxyStream(ctx context.Context) error {
 	regBuilder.WriteString(publicURL)
 	regBuilder.WriteByte('|')
 	regBuilder.WriteString(savedName)
-	regData := regBuilder.String()
 
-	regMsg := &tunnelv1.ProxyMessage{
+	return &tunnelv1.ProxyMessage{
 		Message: &tunnelv1.ProxyMessage_Control{
 			Control: &tunnelv1.ControlMessage{
 				Type:     tunnelv1.ControlMessage_UNKNOWN, // Use UNKNOWN for registration
-				TunnelId: regData,
+				TunnelId: regBuilder.String(),
 			},
 		},
 	}
-
-	if err := stream.Send(regMsg); err != nil {
-		return fmt.Errorf("failed to send registration message: %w", err)
-	}
-
-	logger.DebugEvent().Msg("Sent tunnel registration message")
-
-	// Start receiving requests from server
-	go c.receiveRequests(ctx)
-
-	return nil
 }
 
 // getSubdomain extracts subdomain from public URL.
@@ -111,6 +191,8 @@ func (c *Client) getSubdomain() string {
 	url = strings.TrimPrefix(url, "https://")
 	url = strings.TrimPrefix(url, "http://")
 	url = strings.TrimPrefix(url, "tcp://")
+	url = strings.TrimPrefix(url, "tls://")
+	url = strings.TrimPrefix(url, "udp://")
 
 	// Find the subdomain part (everything before the first dot)
 	if dotIdx := strings.Index(url, "."); dotIdx != -1 {
@@ -121,8 +203,10 @@ func (c *Client) getSubdomain() string {
 	return url
 }
 
-// receiveRequests receives and handles proxy requests from server.
-func (c *Client) receiveRequests(ctx context.Context) {
+// receiveRequests receives and handles proxy requests from the tunnel's own stream.
+func (c *Client) receiveRequests(ctx context.Context, stream tunnelv1.TunnelService_ProxyStreamClient) {
+	// The heartbeat may keep succeeding while the stream is gone
+	connLostCh := c.session.connectionLostCh()
 	for {
 		select {
 		case <-ctx.Done():
@@ -130,38 +214,176 @@ func (c *Client) receiveRequests(ctx context.Context) {
 		default:
 		}
 
-		msg, err := c.stream.Recv()
-		if err == io.EOF {
-			logger.InfoEvent().Msg("Server closed stream")
+		msg, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				logger.InfoEvent().Msg("Server closed stream")
+			} else {
+				logger.ErrorEvent().
+					Err(err).
+					Msg("Error receiving from stream")
+			}
+
+			// Reconnect unless the stream was closed on purpose
+			c.mu.RLock()
+			current := c.stream == stream
+			c.mu.RUnlock()
+			if current && ctx.Err() == nil {
+				signalConnectionLost(connLostCh)
+			}
 			return
 		}
-		if err != nil {
-			logger.ErrorEvent().
-				Err(err).
-				Msg("Error receiving from stream")
+
+		c.handleMessage(ctx, msg)
+	}
+}
+
+// handleMessage handles a message from the server addressed to this tunnel.
+// It runs in the receive loop, so it must not block on the local service.
+func (c *Client) handleMessage(ctx context.Context, msg *tunnelv1.ProxyMessage) {
+	switch payload := msg.Message.(type) {
+	case *tunnelv1.ProxyMessage_Request:
+		req := payload.Request
+
+		// Body frames are delivered in the receive loop so they stay in order;
+		// window updates return credit to a streamed response
+		if chunk := req.GetBody(); chunk != nil {
+			if chunk.WindowUpdate > 0 {
+				c.responseWindowUpdate(req.RequestId, chunk.WindowUpdate)
+				return
+			}
+			c.deliverRequestBody(req.RequestId, chunk, req.EndOfStream)
 			return
 		}
 
-		// Handle different message types
-		switch payload := msg.Message.(type) {
-		case *tunnelv1.ProxyMessage_Request:
-			// Handle request from server (public internet → local service)
-			go c.handleProxyRequest(ctx, payload.Request)
+		// Cancel frames stop work on requests the server no longer waits for
+		if cancel := req.GetCancel(); cancel != nil {
+			c.cancelRequest(req.RequestId, cancel.Reason)
+			return
+		}
 
-		case *tunnelv1.ProxyMessage_Control:
-			// Handle control messages
-			c.handleControlMessage(payload.Control)
+		// TCP and WebSocket frames are queued per connection so they stay in order
+		if tcpData := req.GetTcp(); tcpData != nil {
+			c.deliverTCP(ctx, req.RequestId, tcpData)
+			return
+		}
 
-		case *tunnelv1.ProxyMessage_Error:
-			// Handle error messages
-			logger.ErrorEvent().
-				Str("request_id", payload.Error.RequestId).
-				Str("code", payload.Error.Code.String()).
-				Str("message", payload.Error.Message).
-				Msg("Received error from server")
+		// UDP datagrams are written right away; local sockets do not block
+		if datagram := req.GetUdp(); datagram != nil {
+			c.handleUDPDatagram(req.RequestId, datagram)
+			return
+		}
 
-		default:
-			logger.WarnEvent().Msg("Received unknown message type")
+		// Register streamed body before its first frame can arrive
+		if httpReq := req.GetHttp(); httpReq != nil && httpReq.StreamingBody {
+			c.registerRequestBody(req.RequestId)
+		}
+
+		// Handle request from server (public internet → local service).
+		// Tracked before the goroutine starts so a cancel frame can never miss it.
+		reqCtx := c.trackRequest(ctx, req.RequestId)
+		go func() {
+			defer c.untrackRequest(req.RequestId)
+			c.handleProxyRequest(reqCtx, req)
+		}()
+
+	case *tunnelv1.ProxyMessage_Registered:
+		c.handleRegistered(payload.Registered)
+		c.completeRegistration(nil)
+
+	case *tunnelv1.ProxyMessage_Rejected:
+		logger.ErrorEvent().
+			Str("code", payload.Rejected.Code.String()).
+			Str("message", payload.Rejected.Message).
+			Str("local_addr", c.cfg.LocalAddr).
+			Msg("Tunnel registration rejected by server")
+		c.completeRegistration(rejectionError(payload.Rejected))
+
+	case *tunnelv1.ProxyMessage_Control:
+		// Handle control messages
+		c.handleControlMessage(payload.Control)
+
+	case *tunnelv1.ProxyMessage_Error:
+		// Handle error messages
+		logger.ErrorEvent().
+			Str("request_id", payload.Error.RequestId).
+			Str("code", payload.Error.Code.String()).
+			Str("message", payload.Error.Message).
+			Msg("Received error from server")
+
+	default:
+		logger.WarnEvent().Msg("Received unknown message type")
+	}
+}
+
+// announce marks the tunnel as connected and publishes it to the dashboard.
+func (c *Client) announce() {
+	c.mu.Lock()
+	c.connected = true
+	tunnelID := c.tunnelID
+	publicURL := c.publicURL
+	c.mu.Unlock()
+
+	logger.InfoEvent().
+		Str("public_url", publicURL).
+		Msg("Tunnel established")
+
+	// Publish connection established event to dashboard
+	if c.eventCollector != nil {
+		c.eventCollector.Publish(events.Event{
+			Type:      events.EventConnectionEstablished,
+			Timestamp: time.Now(),
+			Data: events.ConnectionEvent{
+				Name:      c.cfg.Name,
+				TunnelID:  tunnelID,
+				PublicURL: publicURL,
+				LocalAddr: c.cfg.LocalAddr,
+				Protocol:  c.cfg.Protocol,
+			},
+		})
+	}
+}
+
+// printBanner prints the tunnel details.
+func (c *Client) printBanner() {
+	publicURL := c.GetPublicURL()
+
+	fmt.Printf("\n")
+	fmt.Printf("╔═════════════════════════════════════════════════════════╗\n")
+	fmt.Printf("║                 Tunnel Active                           ║\n")
+	fmt.Printf("╠═════════════════════════════════════════════════════════╣\n")
+	fmt.Printf("║  Public URL:  %-42s║\n", publicURL)
+	fmt.Printf("║  Local Addr:  %-42s║\n", c.cfg.LocalAddr)
+	fmt.Printf("║  Protocol:    %-42s║\n", c.cfg.Protocol)
+	fmt.Printf("╚═════════════════════════════════════════════════════════╝\n")
+	fmt.Printf("\n")
+}
+
+// disconnect marks the tunnel as disconnected and closes its own stream.
+func (c *Client) disconnect() {
+	c.mu.Lock()
+	wasConnected := c.connected
+	c.connected = false
+	tunnelID := c.tunnelID
+	stream := c.stream
+	c.stream = nil
+	c.mu.Unlock()
+
+	// Publish connection lost event
+	if wasConnected && c.eventCollector != nil {
+		c.eventCollector.Publish(events.Event{
+			Type:      events.EventConnectionLost,
+			Timestamp: time.Now(),
+			Data: events.ConnectionEvent{
+				TunnelID: tunnelID,
+			},
+		})
+	}
+
+	// The shared stream is closed by the session
+	if _, shared := stream.(*lockedStream); stream != nil && !shared {
+		if err := stream.CloseSend(); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to close stream")
 		}
 	}
 }
@@ -177,9 +399,6 @@ func (c *Client) handleProxyRequest(ctx context.Context, req *tunnelv1.ProxyRequ
 	case *tunnelv1.ProxyRequest_Http:
 		c.handleHTTPRequest(ctx, req.RequestId, payload.Http)
 
-	case *tunnelv1.ProxyRequest_Tcp:
-		c.handleTCPRequest(ctx, req.RequestId, payload.Tcp)
-
 	default:
 		logger.WarnEvent().
 			Str("request_id", req.RequestId).
@@ -187,10 +406,11 @@ func (c *Client) handleProxyRequest(ctx context.Context, req *tunnelv1.ProxyRequ
 	}
 }
 
-// handleHTTPRequest forwards HTTP request to local service using chunked streaming.
-// handleHTTPRequest forwards HTTP request to local service.
+// handleHTTPRequest forwards HTTP request to local service, streaming the request body
+// (when the server streams it) and the response body in chunks.
 func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpReq *tunnelv1.HTTPRequest) {
 	start := time.Now()
+	upstream := c.httpForwarder.Route(httpReq)
 
 	// Publish request started event to dashboard
 	if c.eventCollector != nil {
@@ -210,6 +430,8 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 				RemoteAddr: httpReq.RemoteAddr,
 				Protocol:   "http",
 				Headers:    convertHeaders(httpReq.Headers),
+				Tunnel:     c.cfg.Name,
+				Upstream:   upstream.LocalAddr(),
 			},
 		})
 	}
@@ -220,9 +442,108 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 		return
 	}
 
-	// Forward regular HTTP request to local service
-	httpResp, err := c.httpForwarder.Forward(ctx, httpReq)
-	if err != nil {
+	// Attach streamed request body, if any
+	var body io.Reader
+	streamedBody := c.takeRequestBody(requestID)
+	if streamedBody != nil {
+		defer c.releaseRequestBody(requestID, streamedBody)
+		body = streamedBody
+	}
+	bytesIn := func() int64 {
+		if streamedBody != nil {
+			return streamedBody.bytesRead.Load()
+		}
+		return int64(len(httpReq.Body))
+	}
+
+	// Forward request to local service; the first chunk becomes the response head,
+	// later chunks are sent as body frames. Servers without streaming support get
+	// the whole response in a single message.
+	const maxBodyCapture = 1024 * 1024 // 1MB
+	streaming := c.features.Has(tunnelv1.Feature_FEATURE_STREAMING_BODY)
+	window := c.openResponseWindow(requestID)
+	defer c.closeResponseWindow(requestID, window)
+	var (
+		headSent        bool
+		statusCode      int32
+		responseHeaders map[string][]string
+		responseBody    []byte
+		bytesOut        int64
+		buffered        *tunnelv1.HTTPResponse
+	)
+
+	err := upstream.ForwardStream(ctx, httpReq, body, func(chunk *tunnelv1.HTTPResponse, isLast bool) error {
+		if !streaming {
+			if buffered == nil {
+				buffered = chunk
+			} else {
+				buffered.Body = append(buffered.Body, chunk.Body...)
+			}
+			if !isLast {
+				return nil
+			}
+			buffered.Trailers = chunk.Trailers
+			chunk = buffered
+		}
+
+		proxyResp := &tunnelv1.ProxyResponse{
+			RequestId:   requestID,
+			TunnelId:    c.tunnelID,
+			EndOfStream: isLast,
+		}
+		if !headSent {
+			proxyResp.Payload = &tunnelv1.ProxyResponse_Http{Http: chunk}
+			statusCode = chunk.StatusCode
+			responseHeaders = convertHeaders(chunk.Headers)
+			headSent = true
+		} else {
+			// Never send more body frames than the server has room for
+			if _, err := window.Acquire(ctx, 1); err != nil {
+				return err
+			}
+			proxyResp.Payload = &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: chunk.Body, Trailers: chunk.Trailers}}
+		}
+
+		bytesOut += int64(len(chunk.Body))
+		if c.eventCollector != nil && len(responseBody) < maxBodyCapture {
+			captured := chunk.Body
+			if remaining := maxBodyCapture - len(responseBody); len(captured) > remaining {
+				captured = captured[:remaining]
+			}
+			responseBody = append(responseBody, captured...)
+		}
+
+		return c.sendStream(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Response{Response: proxyResp},
+		})
+	})
+	if err != nil && ctx.Err() != nil {
+		// The server stopped waiting (visitor gone or timeout); nobody reads a reply
+		logger.InfoEvent().
+			Str("request_id", requestID).
+			Str("method", httpReq.Method).
+			Str("path", httpReq.Path).
+			Dur("duration", time.Since(start)).
+			Msg("HTTP request canceled")
+
+		if c.eventCollector != nil {
+			c.eventCollector.Publish(events.Event{
+				Type:      events.EventRequestCompleted,
+				Timestamp: time.Now(),
+				Data: events.RequestCompletedEvent{
+					RequestID:       requestID,
+					StatusCode:      statusCode,
+					BytesIn:         bytesIn(),
+					BytesOut:        bytesOut,
+					Duration:        time.Since(start),
+					ResponseHeaders: responseHeaders,
+					Error:           "request canceled",
+				},
+			})
+		}
+		return
+	}
+	if err != nil && !headSent {
 		logger.ErrorEvent().
 			Err(err).
 			Str("request_id", requestID).
@@ -239,7 +560,7 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 				Data: events.RequestCompletedEvent{
 					RequestID:  requestID,
 					StatusCode: 500,
-					BytesIn:    int64(len(httpReq.Body)),
+					BytesIn:    bytesIn(),
 					BytesOut:   0,
 					Duration:   time.Since(start),
 					Error:      err.Error(),
@@ -251,24 +572,22 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 		c.sendError(requestID, tunnelv1.ErrorCode_LOCAL_SERVICE_UNREACHABLE, err.Error())
 		return
 	}
-
-	// Send response back to server
-	proxyResp := &tunnelv1.ProxyResponse{
-		RequestId:   requestID,
-		TunnelId:    c.tunnelID,
-		Payload:     &tunnelv1.ProxyResponse_Http{Http: httpResp},
-		EndOfStream: true,
-	}
-
-	msg := &tunnelv1.ProxyMessage{
-		Message: &tunnelv1.ProxyMessage_Response{Response: proxyResp},
-	}
-
-	if sendErr := c.sendStream(msg); sendErr != nil {
+	if err != nil {
 		logger.ErrorEvent().
-			Err(sendErr).
+			Err(err).
 			Str("request_id", requestID).
-			Msg("Failed to send response")
+			Msg("Failed to stream response")
+
+		// Response head already went out; end the stream so the server stops waiting
+		endMsg := &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Response{Response: &tunnelv1.ProxyResponse{
+				RequestId:   requestID,
+				TunnelId:    c.tunnelID,
+				Payload:     &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{}},
+				EndOfStream: true,
+			}},
+		}
+		_ = c.sendStream(endMsg)
 	}
 
 	// Log access with details
@@ -277,30 +596,23 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 		Str("method", httpReq.Method).
 		Str("path", httpReq.Path).
 		Str("remote_addr", httpReq.RemoteAddr).
-		Int32("status", httpResp.StatusCode).
-		Int("bytes_in", len(httpReq.Body)).
-		Int("bytes_out", len(httpResp.Body)).
+		Str("upstream", upstream.LocalAddr()).
+		Int32("status", statusCode).
+		Int64("bytes_in", bytesIn()).
+		Int64("bytes_out", bytesOut).
 		Dur("duration", duration).
 		Msg("HTTP request processed")
 
 	// Publish request completed event to dashboard
 	if c.eventCollector != nil {
-		// Capture response headers and body for dashboard (limited)
-		responseHeaders := convertHeaders(httpResp.Headers)
-		responseBody := httpResp.Body
-		const maxBodyCapture = 1024 * 1024 // 1MB
-		if len(responseBody) > maxBodyCapture {
-			responseBody = responseBody[:maxBodyCapture]
-		}
-
 		c.eventCollector.Publish(events.Event{
 			Type:      events.EventRequestCompleted,
 			Timestamp: time.Now(),
 			Data: events.RequestCompletedEvent{
 				RequestID:       requestID,
-				StatusCode:      httpResp.StatusCode,
-				BytesIn:         int64(len(httpReq.Body)),
-				BytesOut:        int64(len(httpResp.Body)),
+				StatusCode:      statusCode,
+				BytesIn:         bytesIn(),
+				BytesOut:        bytesOut,
 				Duration:        duration,
 				ResponseHeaders: responseHeaders,
 				ResponseBody:    responseBody,
@@ -309,8 +621,8 @@ func (c *Client) handleHTTPRequest(ctx context.Context, requestID string, httpRe
 	}
 }
 
-// handleTCPRequest forwards TCP data to local service.
-func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData *tunnelv1.TCPData) {
+// handleTCPRequest forwards TCP data to local service and reports whether the connection is still usable.
+func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData *tunnelv1.TCPData) bool {
 	start := time.Now()
 
 	// Publish request started event to dashboard
@@ -324,6 +636,7 @@ func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData
 				Path:       fmt.Sprintf("seq:%d", tcpData.Sequence),
 				RemoteAddr: "",
 				Protocol:   "tcp",
+				Tunnel:     c.cfg.Name,
 			},
 		})
 	}
@@ -334,29 +647,6 @@ func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData
 		Int64("sequence", tcpData.Sequence).
 		Msg("TCP data received")
 
-	// Check if this is WebSocket data (from server to client)
-	c.mu.RLock()
-	wsChan, isWebSocket := c.wsConnections[requestID]
-	c.mu.RUnlock()
-
-	if isWebSocket {
-		// This is WebSocket data, route to WebSocket channel
-		select {
-		case wsChan <- tcpData.Data:
-			logger.DebugEvent().
-				Str("request_id", requestID).
-				Int("bytes", len(tcpData.Data)).
-				Msg("Routed WebSocket data to connection")
-		default:
-			// Non-blocking drop to prevent blocking the main receive loop
-			logger.WarnEvent().
-				Str("request_id", requestID).
-				Int("bytes", len(tcpData.Data)).
-				Msg("WebSocket channel full, dropping message")
-		}
-		return
-	}
-
 	// Create response sender function
 	sendResponse := func(respData *tunnelv1.TCPData) error {
 		response := &tunnelv1.ProxyResponse{
@@ -417,7 +707,7 @@ func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData
 		}); err != nil {
 			logger.WarnEvent().Err(err).Msg("Failed to send error response")
 		}
-		return
+		return false
 	}
 
 	// Publish request completed event to dashboard (success case)
@@ -439,6 +729,82 @@ func (c *Client) handleTCPRequest(ctx context.Context, requestID string, tcpData
 	if startReadLoop {
 		go c.tcpForwarder.StartReadLoop(ctx, requestID, sendResponse)
 	}
+
+	return true
+}
+
+// handleUDPDatagram forwards a datagram of a UDP flow to the local service.
+func (c *Client) handleUDPDatagram(flowID string, datagram *tunnelv1.UDPDatagram) {
+	if c.udpForwarder == nil {
+		logger.DebugEvent().
+			Str("request_id", flowID).
+			Msg("Dropping UDP datagram for non-UDP tunnel")
+		return
+	}
+
+	sendResponse := func(reply *tunnelv1.UDPDatagram) error {
+		msg := &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Response{
+				Response: &tunnelv1.ProxyResponse{
+					RequestId: flowID,
+					TunnelId:  c.tunnelID,
+					Payload: &tunnelv1.ProxyResponse_Udp{
+						Udp: reply,
+					},
+				},
+			},
+		}
+
+		c.mu.RLock()
+		stream := c.stream
+		c.mu.RUnlock()
+
+		if stream == nil {
+			return fmt.Errorf("stream not available")
+		}
+
+		c.streamMu.Lock()
+		defer c.streamMu.Unlock()
+		return stream.Send(msg)
+	}
+
+	if err := c.udpForwarder.Forward(flowID, datagram, sendResponse); err != nil {
+		logger.WarnEvent().
+			Err(err).
+			Str("request_id", flowID).
+			Msg("Failed to forward UDP datagram")
+
+		// Let the server drop the flow; the peer's next datagram opens a new one
+		if err := sendResponse(&tunnelv1.UDPDatagram{Close: true}); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to send UDP flow close")
+		}
+	}
+}
+
+// handleRegistered applies the tunnel details confirmed by the server.
+func (c *Client) handleRegistered(reg *tunnelv1.Registered) {
+	c.mu.Lock()
+	oldURL := c.publicURL
+	c.tunnelID = reg.TunnelId
+	if reg.PublicUrl != "" {
+		c.publicURL = reg.PublicUrl
+	}
+	c.mu.Unlock()
+
+	logger.InfoEvent().
+		Str("tunnel_id", reg.TunnelId).
+		Str("saved_name", reg.SavedName).
+		Msg("Tunnel registered")
+
+	if reg.PublicUrl != "" && oldURL != reg.PublicUrl {
+		logger.InfoEvent().
+			Str("old_url", oldURL).
+			Str("new_url", reg.PublicUrl).
+			Msg("Public URL updated")
+
+		// Print updated URL to console
+		fmt.Printf("\n✓ Public URL updated: %s\n", reg.PublicUrl)
+	}
 }
 
 // handleControlMessage handles control messages from server.
@@ -474,7 +840,17 @@ func (c *Client) handleControlMessage(ctrl *tunnelv1.ControlMessage) {
 		// Connection will be reestablished by maintainConnection
 
 	case tunnelv1.ControlMessage_RATE_LIMIT:
-		logger.WarnEvent().Msg("Rate limit exceeded")
+		md := ctrl.GetMetadata()
+		logger.WarnEvent().
+			Str("scope", md["scope"]).
+			Str("limit", md["limit"]).
+			Str("retry_after", md["retry_after"]).
+			Msg("Rate limit exceeded")
+
+		// Print the limit to console, traffic is rejected or slowed down
+		if message := md["message"]; message != "" {
+			fmt.Printf("\n⚠ %s (retry after %ss)\n", message, md["retry_after"])
+		}
 
 	case tunnelv1.ControlMessage_RECONNECT:
 		logger.InfoEvent().Msg("Server requested reconnect")
@@ -499,6 +875,32 @@ func (c *Client) sendStream(msg *tunnelv1.ProxyMessage) error {
 	return c.stream.Send(msg)
 }
 
+// sendWindowUpdate returns n bytes of send credit to the server for a connection.
+func (c *Client) sendWindowUpdate(requestID string, n uint32) error {
+	return c.sendStream(&tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Response{
+			Response: &tunnelv1.ProxyResponse{
+				RequestId: requestID,
+				TunnelId:  c.tunnelID,
+				Payload:   &tunnelv1.ProxyResponse_Tcp{Tcp: &tunnelv1.TCPData{WindowUpdate: n}},
+			},
+		},
+	})
+}
+
+// sendBodyWindowUpdate returns n body frames of send credit to the server for a streamed request.
+func (c *Client) sendBodyWindowUpdate(requestID string, n uint32) error {
+	return c.sendStream(&tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Response{
+			Response: &tunnelv1.ProxyResponse{
+				RequestId: requestID,
+				TunnelId:  c.tunnelID,
+				Payload:   &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{WindowUpdate: n}},
+			},
+		},
+	})
+}
+
 // sendError sends an error response to the server.
 func (c *Client) sendError(requestID string, code tunnelv1.ErrorCode, message string) {
 	errorMsg := &tunnelv1.ProxyError{
@@ -543,59 +945,6 @@ func signalConnectionLost(connLostCh chan struct{}) {
 	}
 }
 
-// startHeartbeat starts sending heartbeat messages to server.
-func (c *Client) startHeartbeat(ctx context.Context, connLostCh chan struct{}) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	heartbeatStream, err := c.tunnelSvc.Heartbeat(ctx)
-	if err != nil {
-		logger.ErrorEvent().Err(err).Msg("Failed to create heartbeat stream")
-		signalConnectionLost(connLostCh)
-		return
-	}
-
-	logger.DebugEvent().Msg("Heartbeat stream established")
-
-	heartbeatErrCh := make(chan error, 1)
-	go receiveHeartbeats(heartbeatStream, heartbeatErrCh)
-
-	for {
-		select {
-		case <-ctx.Done():
-			if err := heartbeatStream.CloseSend(); err != nil {
-				logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
-			}
-			return
-
-		case err := <-heartbeatErrCh:
-			logger.WarnEvent().Err(err).Msg("Heartbeat failed, signaling connection lost")
-			if err := heartbeatStream.CloseSend(); err != nil {
-				logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
-			}
-			signalConnectionLost(connLostCh)
-			return
-
-		case <-ticker.C:
-			req := &tunnelv1.HeartbeatRequest{
-				TunnelId:  c.tunnelID,
-				Timestamp: time.Now().Unix(),
-			}
-
-			if err := heartbeatStream.Send(req); err != nil {
-				logger.ErrorEvent().Err(err).Msg("Failed to send heartbeat")
-				if err := heartbeatStream.CloseSend(); err != nil {
-					logger.WarnEvent().Err(err).Msg("Failed to close heartbeat stream")
-				}
-				signalConnectionLost(connLostCh)
-				return
-			}
-
-			logger.DebugEvent().Msg("Heartbeat sent")
-		}
-	}
-}
-
 // handleWebSocketUpgrade handles WebSocket upgrade and bidirectional streaming.
 func (c *Client) handleWebSocketUpgrade(ctx context.Context, requestID string, httpReq *tunnelv1.HTTPRequest) {
 	logger.InfoEvent().
@@ -640,12 +989,16 @@ func (c *Client) handleWebSocketUpgrade(ctx context.Context, requestID string, h
 	// Register WebSocket channel BEFORE sending 101 response to server.
 	// This prevents a race condition where the server starts sending WebSocket
 	// data before the channel is registered, causing data to be misrouted as TCP.
-	wsChan := make(chan []byte, 1000)
+	ws := &wsConnection{data: make(chan []byte, 1000)}
+	if c.flowControl() {
+		ws.window = protocol.NewWindow(protocol.DefaultWindow)
+		ws.credit = protocol.NewCredit(protocol.DefaultWindow)
+	}
 	c.mu.Lock()
 	if c.wsConnections == nil {
-		c.wsConnections = make(map[string]chan []byte)
+		c.wsConnections = make(map[string]*wsConnection)
 	}
-	c.wsConnections[requestID] = wsChan
+	c.wsConnections[requestID] = ws
 	c.mu.Unlock()
 
 	// Send upgrade response back to server
@@ -669,7 +1022,7 @@ func (c *Client) handleWebSocketUpgrade(ctx context.Context, requestID string, h
 		c.mu.Lock()
 		delete(c.wsConnections, requestID)
 		c.mu.Unlock()
-		close(wsChan)
+		close(ws.data)
 		wsConn.Close()
 		return
 	}
@@ -679,17 +1032,22 @@ func (c *Client) handleWebSocketUpgrade(ctx context.Context, requestID string, h
 		Msg("WebSocket upgraded, starting bidirectional streaming")
 
 	// Start bidirectional streaming (channel already registered)
-	c.streamWebSocketData(ctx, requestID, wsConn, wsChan)
+	c.streamWebSocketData(ctx, requestID, wsConn, ws)
 }
 
 // streamWebSocketData handles bidirectional WebSocket data streaming.
-// wsChan is pre-registered in wsConnections before the 101 response is sent to prevent race conditions.
-func (c *Client) streamWebSocketData(ctx context.Context, requestID string, wsConn net.Conn, wsChan chan []byte) {
+// ws is pre-registered in wsConnections before the 101 response is sent to prevent race conditions.
+func (c *Client) streamWebSocketData(ctx context.Context, requestID string, wsConn net.Conn, ws *wsConnection) {
 	defer wsConn.Close()
 
 	// done channel signals both goroutines to stop when either direction closes
 	done := make(chan struct{})
 	var closeOnce sync.Once
+	stop := func() {
+		close(done)
+		ws.window.Close()
+		wsConn.Close() // Unblock the reader
+	}
 
 	// Cleanup: unregister WebSocket channel when streaming ends
 	defer func() {
@@ -697,7 +1055,7 @@ func (c *Client) streamWebSocketData(ctx context.Context, requestID string, wsCo
 		delete(c.wsConnections, requestID)
 		c.mu.Unlock()
 		// Drain and close channel to unblock any pending senders
-		close(wsChan)
+		close(ws.data)
 	}()
 
 	var wg sync.WaitGroup
@@ -705,14 +1063,14 @@ func (c *Client) streamWebSocketData(ctx context.Context, requestID string, wsCo
 
 	go func() {
 		defer wg.Done()
-		defer closeOnce.Do(func() { close(done) })
-		c.wsReadLocalAndSend(requestID, wsConn)
+		defer closeOnce.Do(stop)
+		c.wsReadLocalAndSend(ctx, requestID, wsConn, ws.window)
 	}()
 
 	go func() {
 		defer wg.Done()
-		defer closeOnce.Do(func() { close(done) })
-		c.wsReceiveAndWriteLocal(ctx, requestID, wsConn, wsChan, done)
+		defer closeOnce.Do(stop)
+		c.wsReceiveAndWriteLocal(ctx, requestID, wsConn, ws, done)
 	}()
 
 	wg.Wait()
@@ -723,7 +1081,7 @@ func (c *Client) streamWebSocketData(ctx context.Context, requestID string, wsCo
 }
 
 // wsReadLocalAndSend reads from local WebSocket connection and sends data to server via gRPC.
-func (c *Client) wsReadLocalAndSend(requestID string, wsConn net.Conn) {
+func (c *Client) wsReadLocalAndSend(ctx context.Context, requestID string, wsConn net.Conn, window *protocol.Window) {
 	bufPtr := wsClientBufferPool.Get().(*[]byte) //nolint:errcheck // sync.Pool.Get() doesn't return error
 	buffer := *bufPtr
 	defer wsClientBufferPool.Put(bufPtr)
@@ -731,7 +1089,14 @@ func (c *Client) wsReadLocalAndSend(requestID string, wsConn net.Conn) {
 	sequence := int64(0)
 
 	for {
-		n, err := wsConn.Read(buffer)
+		// Never read more than the server has room for
+		size, err := window.Acquire(ctx, len(buffer))
+		if err != nil {
+			return
+		}
+
+		n, err := wsConn.Read(buffer[:size])
+		window.Release(size - n)
 		if err != nil {
 			if err != io.EOF {
 				logger.DebugEvent().Err(err).Msg("WebSocket connection read error")
@@ -763,14 +1128,14 @@ func (c *Client) wsReadLocalAndSend(requestID string, wsConn net.Conn) {
 }
 
 // wsReceiveAndWriteLocal receives data from server via channel and writes to local WebSocket connection.
-func (c *Client) wsReceiveAndWriteLocal(ctx context.Context, requestID string, wsConn net.Conn, wsChan chan []byte, done chan struct{}) {
+func (c *Client) wsReceiveAndWriteLocal(ctx context.Context, requestID string, wsConn net.Conn, ws *wsConnection, done chan struct{}) {
 	idleTimeout := 5 * time.Minute
 	timer := time.NewTimer(idleTimeout)
 	defer timer.Stop()
 
 	for {
 		select {
-		case data, ok := <-wsChan:
+		case data, ok := <-ws.data:
 			if !ok {
 				return
 			}
@@ -779,6 +1144,14 @@ func (c *Client) wsReceiveAndWriteLocal(ctx context.Context, requestID string, w
 					logger.ErrorEvent().Err(err).Msg("Failed to write data to WebSocket")
 					return
 				}
+
+				// Let the server send more once enough has been written out
+				if grant := ws.credit.Consumed(len(data)); grant > 0 {
+					if err := c.sendWindowUpdate(requestID, grant); err != nil {
+						logger.ErrorEvent().Err(err).Msg("Failed to send WebSocket window update")
+						return
+					}
+				}
 			}
 			// Reset idle timer on data received
 			if !timer.Stop() {
@@ -0,0 +1,152 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// wsConnection is a WebSocket connection proxied to the local service.
+type wsConnection struct {
+	data   chan []byte
+	window *protocol.Window // Send credit granted by the server (nil without flow control)
+	credit *protocol.Credit // Bytes written locally, not yet acknowledged to the server
+}
+
+// tcpStream queues the frames of one TCP connection so they reach the local
+// service in order without blocking the receive loop. With flow control, the
+// queued bytes are bounded by the window the server may use.
+type tcpStream struct {
+	mu     sync.Mutex
+	frames []*tunnelv1.TCPData
+	ready  chan struct{}
+}
+
+// newTCPStream creates an empty frame queue.
+func newTCPStream() *tcpStream {
+	return &tcpStream{ready: make(chan struct{}, 1)}
+}
+
+// push queues a frame. It never blocks.
+func (s *tcpStream) push(data *tunnelv1.TCPData) {
+	s.mu.Lock()
+	s.frames = append(s.frames, data)
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest queued frame, waiting for one until ctx is done.
+func (s *tcpStream) next(ctx context.Context) (*tunnelv1.TCPData, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.frames) > 0 {
+			data := s.frames[0]
+			s.frames[0] = nil
+			s.frames = s.frames[1:]
+			s.mu.Unlock()
+			return data, true
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.ready:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// flowControl reports whether TCP flow control was negotiated with the server.
+func (c *Client) flowControl() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.features.Has(tunnelv1.Feature_FEATURE_TCP_FLOW_CONTROL)
+}
+
+// deliverTCP routes a TCP frame from the server to its WebSocket or TCP connection.
+// It runs in the receive loop, so frames of a connection keep their order.
+func (c *Client) deliverTCP(ctx context.Context, requestID string, tcpData *tunnelv1.TCPData) {
+	// The lock keeps the WebSocket channel open until the frame is queued
+	c.mu.RLock()
+	ws, isWebSocket := c.wsConnections[requestID]
+	if isWebSocket {
+		ws.window.Release(int(tcpData.WindowUpdate))
+		if len(tcpData.Data) > 0 {
+			select {
+			case ws.data <- tcpData.Data:
+				logger.DebugEvent().
+					Str("request_id", requestID).
+					Int("bytes", len(tcpData.Data)).
+					Msg("Routed WebSocket data to connection")
+			default:
+				// Non-blocking drop to prevent blocking the main receive loop
+				logger.WarnEvent().
+					Str("request_id", requestID).
+					Int("bytes", len(tcpData.Data)).
+					Msg("WebSocket channel full, dropping message")
+			}
+		}
+	}
+	c.mu.RUnlock()
+	if isWebSocket {
+		return
+	}
+
+	// Late frames of a closed WebSocket on an HTTP tunnel
+	if c.tcpForwarder == nil {
+		logger.DebugEvent().
+			Str("request_id", requestID).
+			Msg("Dropping TCP data for unknown connection")
+		return
+	}
+
+	// Window updates return credit to the connection's read loop; they need no ordering
+	if tcpData.WindowUpdate > 0 {
+		c.tcpForwarder.WindowUpdate(requestID, tcpData.WindowUpdate)
+		if len(tcpData.Data) == 0 {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	if c.tcpStreams == nil {
+		c.tcpStreams = make(map[string]*tcpStream)
+	}
+	stream, ok := c.tcpStreams[requestID]
+	if !ok {
+		stream = newTCPStream()
+		c.tcpStreams[requestID] = stream
+		go c.runTCPStream(ctx, requestID, stream)
+	}
+	stream.push(tcpData)
+	c.mu.Unlock()
+}
+
+// runTCPStream forwards the queued frames of a TCP connection until it is closed.
+func (c *Client) runTCPStream(ctx context.Context, requestID string, stream *tcpStream) {
+	defer func() {
+		c.mu.Lock()
+		if c.tcpStreams[requestID] == stream {
+			delete(c.tcpStreams, requestID)
+		}
+		c.mu.Unlock()
+	}()
+
+	for {
+		tcpData, ok := stream.next(ctx)
+		if !ok {
+			return
+		}
+
+		if !c.handleTCPRequest(ctx, requestID, tcpData) || protocol.IsTCPClose(tcpData) {
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+)
+
+// TestTCPStream_Order tests that queued frames are returned in order.
+func TestTCPStream_Order(t *testing.T) {
+	stream := newTCPStream()
+	for i := 0; i < 3; i++ {
+		stream.push(&tunnelv1.TCPData{Sequence: int64(i)})
+	}
+
+	for i := 0; i < 3; i++ {
+		data, ok := stream.next(context.Background())
+		require.True(t, ok)
+		assert.Equal(t, int64(i), data.Sequence)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, ok := stream.next(ctx)
+	assert.False(t, ok)
+}
+
+// TestDeliverTCP_PreservesOrder tests that frames of a connection reach the local service in order.
+func TestDeliverTCP_PreservesOrder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	client, err := NewClient(ClientConfig{Protocol: "tcp", LocalAddr: listener.Addr().String()})
+	require.NoError(t, err)
+	defer client.tcpForwarder.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var expected strings.Builder
+	for i := 0; i < 200; i++ {
+		frame := fmt.Sprintf("%d,", i)
+		expected.WriteString(frame)
+		client.deliverTCP(ctx, "conn-1", &tunnelv1.TCPData{Data: []byte(frame)})
+	}
+	client.deliverTCP(ctx, "conn-1", &tunnelv1.TCPData{})
+
+	select {
+	case data := <-received:
+		assert.Equal(t, expected.String(), data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("local service did not receive the data")
+	}
+}
+
+// TestDeliverTCP_WebSocketWindowUpdate tests that window updates return credit to a WebSocket sender.
+func TestDeliverTCP_WebSocketWindowUpdate(t *testing.T) {
+	client, err := NewClient(ClientConfig{Protocol: "http", LocalAddr: "localhost:3000"})
+	require.NoError(t, err)
+
+	ws := &wsConnection{data: make(chan []byte, 1), window: protocol.NewWindow(0)}
+	client.wsConnections = map[string]*wsConnection{"ws-1": ws}
+
+	client.deliverTCP(context.Background(), "ws-1", &tunnelv1.TCPData{WindowUpdate: 512})
+
+	n, err := ws.window.Acquire(context.Background(), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, 512, n)
+	assert.Empty(t, ws.data, "a window update carries no data")
+
+	// Late frames for unknown connections on an HTTP tunnel are dropped
+	client.deliverTCP(context.Background(), "gone", &tunnelv1.TCPData{Data: []byte("late")})
+}
@@ -76,8 +76,10 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.User{},
 		&models.AuthToken{},
 		&models.Domain{},
+		&models.CustomDomain{},
 		&models.Tunnel{},
 		&models.RequestLog{},
+		&models.TransferUsage{},
 		// Webhook system models
 		&models.WebhookApp{},
 		&models.WebhookRoute{},
@@ -175,8 +175,10 @@ func TestAutoMigrate(t *testing.T) {
 		"users",
 		"auth_tokens",
 		"domains",
+		"custom_domains",
 		"tunnels",
 		"request_logs",
+		"transfer_usages",
 		"webhook_apps",
 		"webhook_routes",
 		"webhook_events",
@@ -0,0 +1,35 @@
+-- Migration: 003_custom_domains
+-- Description: Add custom domains routed to tunnels after ownership verification
+-- Created: 2026-10-16
+
+-- ============================================================================
+-- Custom Domains Table
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS custom_domains (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    organization_id UUID REFERENCES organizations(id) ON DELETE CASCADE,
+    hostname VARCHAR(253) NOT NULL,
+    subdomain VARCHAR(255) NOT NULL,
+    verification_method VARCHAR(10) NOT NULL DEFAULT 'dns',
+    verification_token VARCHAR(64) NOT NULL,
+    verification_error TEXT,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_custom_domains_hostname UNIQUE (hostname)
+);
+
+-- Indexes for custom_domains
+CREATE INDEX idx_custom_domains_user ON custom_domains(user_id);
+CREATE INDEX idx_custom_domains_org ON custom_domains(organization_id);
+
+-- ============================================================================
+-- Comments for documentation
+-- ============================================================================
+COMMENT ON TABLE custom_domains IS 'Hostnames outside the server domain routed to tunnels once ownership is verified';
+
+COMMENT ON COLUMN custom_domains.subdomain IS 'Tunnel subdomain the hostname routes to';
+COMMENT ON COLUMN custom_domains.verification_method IS 'Ownership check: dns (TXT record at _grok-challenge.<hostname>) or http (token served by the server)';
+COMMENT ON COLUMN custom_domains.verified_at IS 'When ownership was verified; NULL while pending';
@@ -0,0 +1,12 @@
+-- Migration: 004_ip_rules
+-- Description: Add organization IP rules and record visitors blocked at the edge
+-- Created: 2026-10-16
+
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS ip_rules JSON;
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS blocked VARCHAR(255);
+
+-- ============================================================================
+-- Comments for documentation
+-- ============================================================================
+COMMENT ON COLUMN organizations.ip_rules IS 'CIDR and country allow/deny rules applied to every HTTP and TCP tunnel of the organization';
+COMMENT ON COLUMN request_logs.blocked IS 'Why the visitor was rejected by IP rules; NULL or empty when the request was forwarded';
@@ -0,0 +1,25 @@
+-- Migration: 005_transfer_usages
+-- Description: Track monthly traffic of users and organizations for transfer quotas
+-- Created: 2026-10-16
+
+-- ============================================================================
+-- Transfer Usages Table
+-- ============================================================================
+CREATE TABLE IF NOT EXISTS transfer_usages (
+    id UUID PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL,
+    owner_id UUID NOT NULL,
+    month VARCHAR(7) NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT idx_transfer_usages_owner_month UNIQUE (scope, owner_id, month)
+);
+
+-- ============================================================================
+-- Comments for documentation
+-- ============================================================================
+COMMENT ON TABLE transfer_usages IS 'Bytes in and out of the tunnels of a user or organization per calendar month (UTC)';
+COMMENT ON COLUMN transfer_usages.scope IS 'user or organization';
+COMMENT ON COLUMN transfer_usages.owner_id IS 'ID of the user or organization';
+COMMENT ON COLUMN transfer_usages.month IS 'Calendar month in UTC, YYYY-MM';
@@ -0,0 +1,10 @@
+-- Migration: 006_header_rules
+-- Description: Add header rules set by admins to tunnels
+-- Created: 2026-10-16
+
+ALTER TABLE tunnels ADD COLUMN IF NOT EXISTS header_rules JSON;
+
+-- ============================================================================
+-- Comments for documentation
+-- ============================================================================
+COMMENT ON COLUMN tunnels.header_rules IS 'Request and response header add/set/remove rules set by admins, applied after the rules declared by the client';
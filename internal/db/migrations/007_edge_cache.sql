@@ -0,0 +1,10 @@
+-- Migration: 007_edge_cache
+-- Description: Record the edge cache status of requests
+-- Created: 2026-10-16
+
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS cache VARCHAR(16);
+
+-- ============================================================================
+-- Comments for documentation
+-- ============================================================================
+COMMENT ON COLUMN request_logs.cache IS 'Edge cache status: HIT, MISS, REVALIDATED or BYPASS; NULL or empty for tunnels that do not cache';
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Verification methods for custom domain ownership.
+const (
+	VerificationDNS  = "dns"  // TXT record at _grok-challenge.<hostname>
+	VerificationHTTP = "http" // Token served by this server at the hostname
+)
+
+// ChallengePathPrefix is the URL path the HTTP verification token is fetched from.
+const ChallengePathPrefix = "/.well-known/grok-challenge/"
+
+// CustomDomain is a hostname outside the server domain (e.g. demo.example.com,
+// CNAMEd to the server) that routes to a tunnel subdomain once its ownership
+// is verified.
+type CustomDomain struct {
+	ID                 uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID             uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	OrganizationID     *uuid.UUID `gorm:"type:uuid;index" json:"organization_id,omitempty"` // Set for organization domains
+	Hostname           string     `gorm:"not null;uniqueIndex" json:"hostname"`
+	Subdomain          string     `gorm:"not null" json:"subdomain"`                       // Tunnel subdomain the hostname routes to
+	VerificationMethod string     `gorm:"not null;default:dns" json:"verification_method"` // dns or http
+	VerificationToken  string     `gorm:"not null" json:"verification_token"`
+	VerificationError  string     `json:"verification_error,omitempty"` // Why the last check failed
+	VerifiedAt         *time.Time `json:"verified_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+
+	// Relationships
+	User         *User         `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Organization *Organization `gorm:"foreignKey:OrganizationID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate hook to set UUID if not provided.
+func (d *CustomDomain) BeforeCreate(_ *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name.
+func (CustomDomain) TableName() string {
+	return "custom_domains"
+}
+
+// IsVerified reports whether ownership of the hostname has been verified.
+func (d *CustomDomain) IsVerified() bool {
+	return d.VerifiedAt != nil
+}
+
+// ChallengeRecord returns the name of the TXT record holding the verification token.
+func (d *CustomDomain) ChallengeRecord() string {
+	return "_grok-challenge." + d.Hostname
+}
+
+// DelegatedChallengeRecord returns the name under baseDomain that the
+// challenge record may be a CNAME to when grok serves the baseDomain zone.
+func (d *CustomDomain) DelegatedChallengeRecord(baseDomain string) string {
+	return d.ChallengeRecord() + "." + baseDomain
+}
+
+// ChallengePath returns the URL path the HTTP verification token is served at.
+func (d *CustomDomain) ChallengePath() string {
+	return ChallengePathPrefix + d.VerificationToken
+}
@@ -4,18 +4,20 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
 // Organization represents a tenant organization with its own subdomain.
 type Organization struct {
-	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
-	Name        string    `gorm:"not null" json:"name"`
-	Subdomain   string    `gorm:"uniqueIndex;not null" json:"subdomain"` // org identifier in URLs (e.g., "trofeo")
-	Description string    `json:"description,omitempty"`
-	IsActive    bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id"`
+	Name        string         `gorm:"not null" json:"name"`
+	Subdomain   string         `gorm:"uniqueIndex;not null" json:"subdomain"` // org identifier in URLs (e.g., "trofeo")
+	Description string         `json:"description,omitempty"`
+	IsActive    bool           `gorm:"default:true" json:"is_active"`
+	IPRules     datatypes.JSON `gorm:"type:json" json:"ip_rules,omitempty"` // CIDR and country rules of all HTTP and TCP tunnels
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
 
 	// Relationships
 	Users   []User   `gorm:"foreignKey:OrganizationID" json:"-"`
@@ -20,6 +20,8 @@ type RequestLog struct {
 	BytesOut   int    `json:"bytes_out"`
 
 	ClientIP string `json:"client_ip"`
+	Blocked  string `json:"blocked,omitempty"` // Why the visitor was rejected at the edge (IP rules); empty when forwarded
+	Cache    string `json:"cache,omitempty"`   // Edge cache status (HIT, MISS, REVALIDATED, BYPASS); empty for tunnels that do not cache
 	// Composite index (tunnel_id, created_at ASC) for efficient cleanup and pagination
 	CreatedAt time.Time `gorm:"index:idx_request_logs_tunnel_created,priority:2,sort:asc" json:"created_at"`
 
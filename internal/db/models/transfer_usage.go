@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Owners of transfer usage.
+const (
+	UsageScopeUser         = "user"
+	UsageScopeOrganization = "organization"
+)
+
+// TransferUsage is the traffic (bytes in and out) of the tunnels of a user or
+// organization in a calendar month (UTC), counted against monthly transfer quotas.
+type TransferUsage struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	Scope     string    `gorm:"size:20;not null;uniqueIndex:idx_transfer_usages_owner_month,priority:1" json:"scope"` // user or organization
+	OwnerID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_transfer_usages_owner_month,priority:2" json:"owner_id"`
+	Month     string    `gorm:"size:7;not null;uniqueIndex:idx_transfer_usages_owner_month,priority:3" json:"month"` // YYYY-MM
+	Bytes     int64     `gorm:"not null;default:0" json:"bytes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook to set UUID.
+func (u *TransferUsage) BeforeCreate(_ *gorm.DB) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return nil
+}
+
+// TableName specifies the table name.
+func (TransferUsage) TableName() string {
+	return "transfer_usages"
+}
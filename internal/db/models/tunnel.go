@@ -31,6 +31,9 @@ type Tunnel struct {
 	Status   string         `gorm:"default:'active';index" json:"status"`
 	Metadata datatypes.JSON `gorm:"type:json" json:"metadata,omitempty"`
 
+	// Header rules set by admins, applied after the client's (headerrules.Config)
+	HeaderRules datatypes.JSON `gorm:"type:json" json:"header_rules,omitempty"`
+
 	BytesIn       int64 `gorm:"default:0" json:"bytes_in"`
 	BytesOut      int64 `gorm:"default:0" json:"bytes_out"`
 	RequestsCount int64 `gorm:"default:0" json:"requests_count"`
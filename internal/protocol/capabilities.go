@@ -0,0 +1,100 @@
+// Package protocol implements tunnel protocol version and feature negotiation
+// shared by grok and grok-server.
+package protocol
+
+import (
+	"fmt"
+	"sort"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/version"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+const (
+	// Version is the tunnel protocol version spoken by this build.
+	Version = 2
+	// MinVersion is the oldest protocol version this build interoperates with.
+	MinVersion = 1
+	// LegacyVersion is assumed for peers that send no capabilities (pre-negotiation builds).
+	LegacyVersion = 1
+)
+
+// supported lists the optional features implemented by this build.
+var supported = []tunnelv1.Feature{
+	tunnelv1.Feature_FEATURE_TYPED_REGISTRATION,
+	tunnelv1.Feature_FEATURE_STREAMING_BODY,
+	tunnelv1.Feature_FEATURE_COMPRESSION,
+	tunnelv1.Feature_FEATURE_TCP_FLOW_CONTROL,
+	tunnelv1.Feature_FEATURE_MULTIPLEX,
+	tunnelv1.Feature_FEATURE_CANCEL,
+	tunnelv1.Feature_FEATURE_UDP,
+	tunnelv1.Feature_FEATURE_ACCESS_POLICY,
+	tunnelv1.Feature_FEATURE_IP_RULES,
+	tunnelv1.Feature_FEATURE_HEADER_RULES,
+	tunnelv1.Feature_FEATURE_EDGE_CACHE,
+	tunnelv1.Feature_FEATURE_TCP_OPEN,
+	tunnelv1.Feature_FEATURE_TRAILERS,
+	tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL,
+}
+
+// Local returns the capabilities advertised by this build.
+func Local() *tunnelv1.Capabilities {
+	return &tunnelv1.Capabilities{
+		ProtocolVersion: Version,
+		Features:        append([]tunnelv1.Feature(nil), supported...),
+		SoftwareVersion: version.Version,
+	}
+}
+
+// Features is a negotiated feature set. The zero value has no features.
+type Features map[tunnelv1.Feature]struct{}
+
+// Has reports whether feature was negotiated.
+func (f Features) Has(feature tunnelv1.Feature) bool {
+	_, ok := f[feature]
+	return ok
+}
+
+// List returns negotiated features in ascending order.
+func (f Features) List() []tunnelv1.Feature {
+	list := make([]tunnelv1.Feature, 0, len(f))
+	for feature := range f {
+		list = append(list, feature)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// Negotiate checks that peer can talk to this build and returns the features both sides support.
+// A nil peer predates negotiation: it is compatible and gets no optional features.
+// Returns an error wrapping pkgerrors.ErrIncompatibleProtocol otherwise.
+func Negotiate(peer *tunnelv1.Capabilities) (Features, error) {
+	features := Features{}
+	if peer == nil {
+		return features, nil
+	}
+
+	if peer.ProtocolVersion < MinVersion {
+		return nil, fmt.Errorf("%w: peer speaks protocol %d, minimum is %d", pkgerrors.ErrIncompatibleProtocol, peer.ProtocolVersion, MinVersion)
+	}
+
+	local := make(Features, len(supported))
+	for _, feature := range supported {
+		local[feature] = struct{}{}
+	}
+
+	for _, feature := range peer.Required {
+		if !local.Has(feature) {
+			return nil, fmt.Errorf("%w: peer requires unsupported feature %s", pkgerrors.ErrIncompatibleProtocol, feature)
+		}
+	}
+
+	for _, feature := range peer.Features {
+		if local.Has(feature) {
+			features[feature] = struct{}{}
+		}
+	}
+
+	return features, nil
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// TestNegotiate tests feature negotiation between peers.
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		peer        *tunnelv1.Capabilities
+		expected    []tunnelv1.Feature
+		expectError bool
+	}{
+		{
+			name:     "legacy peer gets no features",
+			peer:     nil,
+			expected: []tunnelv1.Feature{},
+		},
+		{
+			name:     "current peer gets all local features",
+			peer:     Local(),
+			expected: Local().Features,
+		},
+		{
+			name: "unknown features are dropped",
+			peer: &tunnelv1.Capabilities{
+				ProtocolVersion: Version,
+				Features: []tunnelv1.Feature{
+					tunnelv1.Feature_FEATURE_STREAMING_BODY,
+					tunnelv1.Feature(999),
+				},
+			},
+			expected: []tunnelv1.Feature{tunnelv1.Feature_FEATURE_STREAMING_BODY},
+		},
+		{
+			name:        "protocol version too old",
+			peer:        &tunnelv1.Capabilities{ProtocolVersion: 0},
+			expectError: true,
+		},
+		{
+			name: "required feature not supported",
+			peer: &tunnelv1.Capabilities{
+				ProtocolVersion: Version,
+				Required:        []tunnelv1.Feature{tunnelv1.Feature(999)},
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			features, err := Negotiate(tt.peer)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, pkgerrors.ErrIncompatibleProtocol))
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, features.List())
+		})
+	}
+}
+
+// TestFeatures_Has tests feature lookups on nil and populated sets.
+func TestFeatures_Has(t *testing.T) {
+	var none Features
+	assert.False(t, none.Has(tunnelv1.Feature_FEATURE_STREAMING_BODY))
+
+	features := Features{tunnelv1.Feature_FEATURE_COMPRESSION: {}}
+	assert.True(t, features.Has(tunnelv1.Feature_FEATURE_COMPRESSION))
+	assert.False(t, features.Has(tunnelv1.Feature_FEATURE_STREAMING_BODY))
+}
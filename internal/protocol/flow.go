@@ -0,0 +1,147 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// DefaultWindow is the send credit, in bytes, each side of a TCP or WebSocket
+// connection starts with when FEATURE_TCP_FLOW_CONTROL is negotiated. It bounds
+// the data buffered by the receiving peer for one connection.
+const DefaultWindow = 256 * 1024
+
+// BodyWindow is the send credit, in frames, each side of a streamed HTTP body
+// starts with when FEATURE_BODY_FLOW_CONTROL is negotiated. Receivers buffer
+// this many frames per request, so delivering a frame never blocks.
+const BodyWindow = 16
+
+// ErrWindowClosed is returned by Window.Acquire once the connection is closed.
+var ErrWindowClosed = errors.New("flow control window closed")
+
+// Window is the send credit left for one connection. The sender acquires credit
+// before reading from its socket and the peer returns it with window updates
+// once the data has been written out on the other side.
+//
+// A nil Window grants unlimited credit, so callers need no special case when
+// flow control was not negotiated.
+type Window struct {
+	mu     sync.Mutex
+	credit int
+	closed bool
+	ready  chan struct{} // closed and replaced when credit is released
+}
+
+// NewWindow creates a window with the given initial credit.
+func NewWindow(credit int) *Window {
+	return &Window{
+		credit: credit,
+		ready:  make(chan struct{}),
+	}
+}
+
+// Acquire takes up to max bytes of credit, blocking until some is available.
+// It returns the number of bytes the caller may send.
+func (w *Window) Acquire(ctx context.Context, max int) (int, error) {
+	if w == nil {
+		return max, nil
+	}
+
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return 0, ErrWindowClosed
+		}
+		if w.credit > 0 {
+			n := min(max, w.credit)
+			w.credit -= n
+			w.mu.Unlock()
+			return n, nil
+		}
+		ready := w.ready
+		w.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Release returns n bytes of credit, from a window update or unused after
+// Acquire. Credit released after Close is dropped.
+func (w *Window) Release(n int) {
+	if w == nil || n <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.credit += n
+	close(w.ready)
+	w.ready = make(chan struct{})
+}
+
+// Close wakes up blocked senders; later calls to Acquire fail with ErrWindowClosed.
+func (w *Window) Close() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.ready)
+	}
+}
+
+// Credit counts bytes a receiver has written out and batches them into window
+// updates, so the peer is not sent an update per frame.
+//
+// A nil Credit never produces updates.
+type Credit struct {
+	mu        sync.Mutex
+	pending   int
+	threshold int
+}
+
+// NewCredit creates a credit counter for a peer sending with the given window.
+func NewCredit(window int) *Credit {
+	return &Credit{threshold: max(window/4, 1)}
+}
+
+// Consumed records n bytes written out and returns the credit to grant the
+// peer, or 0 when no window update is due yet.
+func (c *Credit) Consumed(n int) uint32 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending += n
+	if c.pending < c.threshold {
+		return 0
+	}
+
+	grant := c.pending
+	c.pending = 0
+	return uint32(grant) //nolint:gosec // bounded by the window size
+}
+
+// IsTCPClose reports whether data is a close signal: no data, no window
+// update and no remote address opening a connection.
+func IsTCPClose(data *tunnelv1.TCPData) bool {
+	return len(data.GetData()) == 0 && data.GetWindowUpdate() == 0 && data.GetRemoteAddr() == ""
+}
@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// TestWindow_Acquire tests that senders are limited to the available credit.
+func TestWindow_Acquire(t *testing.T) {
+	window := NewWindow(100)
+
+	n, err := window.Acquire(context.Background(), 64)
+	require.NoError(t, err)
+	assert.Equal(t, 64, n)
+
+	// Only the remaining credit is granted
+	n, err = window.Acquire(context.Background(), 64)
+	require.NoError(t, err)
+	assert.Equal(t, 36, n)
+
+	// Out of credit: blocks until a window update arrives
+	acquired := make(chan int, 1)
+	go func() {
+		n, _ := window.Acquire(context.Background(), 64)
+		acquired <- n
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Acquire returned without credit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	window.Release(10)
+	select {
+	case n := <-acquired:
+		assert.Equal(t, 10, n)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire not woken up by Release")
+	}
+}
+
+// TestWindow_Close tests that closing the window releases blocked senders.
+func TestWindow_Close(t *testing.T) {
+	window := NewWindow(0)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := window.Acquire(context.Background(), 1)
+		errCh <- err
+	}()
+
+	window.Close()
+	select {
+	case err := <-errCh:
+		assert.True(t, errors.Is(err, ErrWindowClosed))
+	case <-time.After(time.Second):
+		t.Fatal("Acquire not woken up by Close")
+	}
+
+	// Closing twice is safe
+	window.Close()
+}
+
+// TestWindow_ReleaseAfterClose tests that credit released after Close, e.g. by
+// a reader unblocked by the close or a late window update, is dropped.
+func TestWindow_ReleaseAfterClose(t *testing.T) {
+	window := NewWindow(0)
+	window.Close()
+
+	assert.NotPanics(t, func() {
+		window.Release(1)
+		window.Release(1)
+	})
+	_, err := window.Acquire(context.Background(), 1)
+	assert.True(t, errors.Is(err, ErrWindowClosed))
+}
+
+// TestWindow_AcquireContext tests that a cancelled context stops waiting for credit.
+func TestWindow_AcquireContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := NewWindow(0).Acquire(ctx, 1)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+// TestWindow_Nil tests that a nil window does not limit the sender.
+func TestWindow_Nil(t *testing.T) {
+	var window *Window
+
+	n, err := window.Acquire(context.Background(), 32*1024)
+	require.NoError(t, err)
+	assert.Equal(t, 32*1024, n)
+
+	window.Release(1)
+	window.Close()
+}
+
+// TestCredit_Consumed tests that window updates are batched.
+func TestCredit_Consumed(t *testing.T) {
+	credit := NewCredit(400)
+
+	assert.Equal(t, uint32(0), credit.Consumed(60))
+	assert.Equal(t, uint32(0), credit.Consumed(39))
+	assert.Equal(t, uint32(120), credit.Consumed(21))
+	assert.Equal(t, uint32(0), credit.Consumed(99))
+
+	var none *Credit
+	assert.Equal(t, uint32(0), none.Consumed(1000))
+}
+
+// TestIsTCPClose tests telling close signals from window updates and openings.
+func TestIsTCPClose(t *testing.T) {
+	assert.True(t, IsTCPClose(&tunnelv1.TCPData{}))
+	assert.False(t, IsTCPClose(&tunnelv1.TCPData{Data: []byte("x")}))
+	assert.False(t, IsTCPClose(&tunnelv1.TCPData{WindowUpdate: 1024}))
+	assert.False(t, IsTCPClose(&tunnelv1.TCPData{RemoteAddr: "203.0.113.7:51234"}))
+}
@@ -0,0 +1,432 @@
+package access
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// Paths served by the guard. Login and callback are on the host of the OIDC
+// redirect URL (the server's base domain); the others on every protected tunnel host.
+const (
+	loginPath  = "/_grok/login"
+	ticketPath = "/_grok/auth"
+	logoutPath = "/_grok/logout"
+
+	// CallbackPath is the default path of the OIDC redirect URL.
+	CallbackPath = "/_grok/callback"
+
+	// ShareParam is the query parameter carrying the token of a share link.
+	ShareParam = "grok_share"
+
+	sessionCookie = "grok_access" // Session on a tunnel host
+	loginCookie   = "grok_login"  // Nonce of a login in progress, on the login host
+
+	loginTimeout  = 10 * time.Minute // Time to complete a login with the provider
+	ticketTimeout = 2 * time.Minute  // Time to redeem a ticket on the tunnel host
+
+	// DefaultShareLinkTTL is the validity of share links created without one.
+	DefaultShareLinkTTL = 24 * time.Hour
+)
+
+// ErrShareLinkTTL is returned for share links valid for longer than allowed.
+var ErrShareLinkTTL = errors.New("share link validity exceeds the maximum")
+
+// Config configures a Guard.
+type Config struct {
+	Secret          []byte        // Signs share links, sessions and login state
+	SessionDuration time.Duration // Lifetime of sessions opened with an OIDC login
+	MaxShareLinkTTL time.Duration // Longest validity of a share link (0: no limit)
+	OIDC            *OIDCConfig   // Optional: OIDC login is not available when nil
+}
+
+// Guard checks visitors of protected tunnels against their access policy.
+// A visitor let in with OIDC or a share link gets a session cookie scoped to
+// the tunnel host; the cookie is never forwarded to the client.
+type Guard struct {
+	secret          []byte
+	sessionDuration time.Duration
+	maxShareLinkTTL time.Duration
+	oidc            *oidcProvider
+	loginURL        *url.URL // OIDC login endpoint, on the redirect URL's host
+	callbackPath    string
+	now             func() time.Time
+}
+
+// NewGuard creates a guard.
+func NewGuard(cfg Config) (*Guard, error) {
+	if len(cfg.Secret) < 32 {
+		return nil, errors.New("access: secret must be at least 32 bytes")
+	}
+	if cfg.SessionDuration <= 0 {
+		cfg.SessionDuration = 24 * time.Hour
+	}
+
+	g := &Guard{
+		secret:          cfg.Secret,
+		sessionDuration: cfg.SessionDuration,
+		maxShareLinkTTL: cfg.MaxShareLinkTTL,
+		now:             time.Now,
+	}
+
+	if cfg.OIDC != nil {
+		mac := hmac.New(sha256.New, cfg.Secret)
+		mac.Write([]byte("pkce"))
+		provider, err := newOIDCProvider(*cfg.OIDC, mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		redirect, _ := url.Parse(cfg.OIDC.RedirectURL)
+		g.oidc = provider
+		g.callbackPath = redirect.Path
+		g.loginURL = &url.URL{Scheme: redirect.Scheme, Host: redirect.Host, Path: loginPath}
+	}
+
+	return g, nil
+}
+
+// Scope identifies a tunnel to the guard: share links and sessions of one
+// tunnel are not accepted by another, even one later given the same subdomain.
+func Scope(subdomain string, owner uuid.UUID) string {
+	return subdomain + "/" + owner.String()
+}
+
+// OIDCEnabled reports whether visitors can log in with OIDC.
+func (g *Guard) OIDCEnabled() bool {
+	return g != nil && g.oidc != nil
+}
+
+// CreateShareLink signs a link to publicURL that lets visitors into the
+// tunnel of scope for ttl (DefaultShareLinkTTL when 0).
+func (g *Guard) CreateShareLink(scope, publicURL string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 {
+		ttl = DefaultShareLinkTTL
+	}
+	if g.maxShareLinkTTL > 0 && ttl > g.maxShareLinkTTL {
+		return "", time.Time{}, fmt.Errorf("%w (%s)", ErrShareLinkTTL, g.maxShareLinkTTL)
+	}
+
+	expires := g.now().Add(ttl).Truncate(time.Second)
+	token, err := g.signToken(audienceShare, scope, expires, tokenClaims{})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return strings.TrimSuffix(publicURL, "/") + "/?" + ShareParam + "=" + token, expires, nil
+}
+
+// Authorize checks r against the policy of the tunnel of scope. It returns
+// true when the request may be forwarded, with the guard's credentials
+// removed; otherwise the response has been written (a challenge, a redirect
+// to log in, or an error).
+func (g *Guard) Authorize(w http.ResponseWriter, r *http.Request, scope string, policy *Policy) bool {
+	if policy == nil {
+		return true
+	}
+	if g == nil {
+		// Never forward requests for a protected tunnel that cannot be checked
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return false
+	}
+
+	switch r.URL.Path {
+	case ticketPath:
+		g.redeemTicket(w, r, scope, policy)
+		return false
+	case logoutPath:
+		http.SetCookie(w, g.cookie(r, sessionCookie, "", -1))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("Signed out\n"))
+		return false
+	}
+
+	if token := r.URL.Query().Get(ShareParam); token != "" && policy.ShareLinks {
+		g.redeemShareLink(w, r, scope, token)
+		return false
+	}
+
+	if len(policy.BasicAuth) > 0 {
+		if username, password, ok := r.BasicAuth(); ok && policy.checkBasicAuth(username, password) {
+			r.Header.Del("Authorization")
+			stripSessionCookie(r)
+			return true
+		}
+	}
+
+	if g.validSession(r, scope, policy) {
+		stripSessionCookie(r)
+		return true
+	}
+
+	logger.DebugEvent().
+		Str("host", r.Host).
+		Str("path", r.URL.Path).
+		Strs("methods", policy.Methods()).
+		Msg("Visitor not authorized for tunnel")
+
+	// Browsers log in with OIDC; other clients are asked for basic auth when possible
+	if g.OIDCEnabled() && policy.OIDC != nil &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		(len(policy.BasicAuth) == 0 || strings.Contains(r.Header.Get("Accept"), "text/html")) {
+		g.startLogin(w, r, scope)
+		return false
+	}
+	if len(policy.BasicAuth) > 0 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="grok", charset="UTF-8"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	http.Error(w, "Access denied: this tunnel is private", http.StatusForbidden)
+	return false
+}
+
+// validSession reports whether r carries a session for the tunnel that its
+// current policy still accepts.
+func (g *Guard) validSession(r *http.Request, scope string, policy *Policy) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	claims, err := g.parseToken(cookie.Value, audienceSession)
+	if err != nil || claims.Subject != scope {
+		return false
+	}
+
+	switch claims.Via {
+	case viaOIDC:
+		return policy.OIDC != nil && policy.OIDC.Allows(claims.User)
+	case viaShare:
+		return policy.ShareLinks
+	default:
+		return false
+	}
+}
+
+// redeemShareLink opens a session lasting as long as the share link and
+// redirects to the link's URL without the token.
+func (g *Guard) redeemShareLink(w http.ResponseWriter, r *http.Request, scope, token string) {
+	claims, err := g.parseToken(token, audienceShare)
+	if err != nil || claims.Subject != scope {
+		http.Error(w, "This share link is invalid or has expired", http.StatusForbidden)
+		return
+	}
+
+	if !g.openSession(w, r, scope, claims.ExpiresAt.Time, tokenClaims{Via: viaShare}) {
+		return
+	}
+
+	target := *r.URL
+	query := target.Query()
+	query.Del(ShareParam)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.RequestURI(), http.StatusFound)
+}
+
+// startLogin sends the visitor to the login endpoint with the URL to return to.
+func (g *Guard) startLogin(w http.ResponseWriter, r *http.Request, scope string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	returnURL := scheme + "://" + r.Host + r.URL.RequestURI()
+
+	state, err := g.signToken(audienceLogin, scope, g.now().Add(loginTimeout), tokenClaims{URL: returnURL})
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to sign login state")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	login := *g.loginURL
+	login.RawQuery = url.Values{"state": {state}}.Encode()
+	http.Redirect(w, r, login.String(), http.StatusFound)
+}
+
+// IsLoginRequest reports whether r is for the OIDC login or callback endpoint.
+func (g *Guard) IsLoginRequest(r *http.Request) bool {
+	if !g.OIDCEnabled() || hostname(r.Host) != g.loginURL.Hostname() {
+		return false
+	}
+	return r.URL.Path == loginPath || r.URL.Path == g.callbackPath
+}
+
+// ServeLogin serves the OIDC login and callback endpoints.
+func (g *Guard) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == loginPath {
+		g.login(w, r)
+		return
+	}
+	g.callback(w, r)
+}
+
+// login binds a nonce to the browser and redirects to the provider.
+func (g *Guard) login(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if _, err := g.parseToken(state, audienceLogin); err != nil {
+		http.Error(w, "Login link is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(random[:])
+
+	authURL, err := g.oidc.authCodeURL(r.Context(), state, nonce)
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("OIDC provider unavailable")
+		http.Error(w, "Login provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, g.cookie(r, loginCookie, nonce, int(loginTimeout/time.Second)))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// callback completes a login and hands it over to the tunnel host with a ticket.
+func (g *Guard) callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode+" "+query.Get("error_description"), http.StatusForbidden)
+		return
+	}
+
+	state, err := g.parseToken(query.Get("state"), audienceLogin)
+	if err != nil {
+		http.Error(w, "Login is invalid or has expired", http.StatusBadRequest)
+		return
+	}
+	nonce, err := r.Cookie(loginCookie)
+	if err != nil {
+		http.Error(w, "Login was started in another browser", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, g.cookie(r, loginCookie, "", -1))
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcTimeout)
+	defer cancel()
+	email, err := g.oidc.exchange(ctx, query.Get("code"), nonce.Value, g.now())
+	if err != nil {
+		logger.WarnEvent().Err(err).Msg("OIDC login failed")
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	returnURL, err := url.Parse(state.URL)
+	if err != nil {
+		http.Error(w, "Login is invalid", http.StatusBadRequest)
+		return
+	}
+	ticket, err := g.signToken(audienceTicket, state.Subject, g.now().Add(ticketTimeout), tokenClaims{User: email, URL: state.URL})
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to sign login ticket")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	redeem := url.URL{
+		Scheme:   returnURL.Scheme,
+		Host:     returnURL.Host,
+		Path:     ticketPath,
+		RawQuery: url.Values{"ticket": {ticket}}.Encode(),
+	}
+	http.Redirect(w, r, redeem.String(), http.StatusFound)
+}
+
+// redeemTicket opens a session for the user of a login ticket, if the
+// tunnel's policy allows them, and returns to the page they came from.
+func (g *Guard) redeemTicket(w http.ResponseWriter, r *http.Request, scope string, policy *Policy) {
+	claims, err := g.parseToken(r.URL.Query().Get("ticket"), audienceTicket)
+	if err != nil || claims.Subject != scope {
+		http.Error(w, "Login is invalid or has expired", http.StatusForbidden)
+		return
+	}
+	if policy.OIDC == nil || !policy.OIDC.Allows(claims.User) {
+		logger.InfoEvent().
+			Str("host", r.Host).
+			Str("email", claims.User).
+			Msg("OIDC user not allowed on tunnel")
+		http.Error(w, claims.User+" is not allowed to access this tunnel", http.StatusForbidden)
+		return
+	}
+
+	if !g.openSession(w, r, scope, g.now().Add(g.sessionDuration), tokenClaims{User: claims.User, Via: viaOIDC}) {
+		return
+	}
+
+	next := "/"
+	if returnURL, err := url.Parse(claims.URL); err == nil {
+		next = returnURL.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// openSession sets the session cookie of the tunnel host.
+func (g *Guard) openSession(w http.ResponseWriter, r *http.Request, scope string, expires time.Time, claims tokenClaims) bool {
+	session, err := g.signToken(audienceSession, scope, expires, claims)
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to sign session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	http.SetCookie(w, g.cookie(r, sessionCookie, session, int(expires.Sub(g.now())/time.Second)))
+	return true
+}
+
+// cookie builds a host-only cookie; a negative maxAge deletes it.
+func (g *Guard) cookie(r *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// stripSessionCookie removes the session cookie from r, leaving the others untouched.
+func stripSessionCookie(r *http.Request) {
+	headers := r.Header.Values("Cookie")
+	if len(headers) == 0 {
+		return
+	}
+
+	var kept []string
+	for _, header := range headers {
+		for _, pair := range strings.Split(header, ";") {
+			pair = strings.TrimSpace(pair)
+			if pair != "" && !strings.HasPrefix(pair, sessionCookie+"=") {
+				kept = append(kept, pair)
+			}
+		}
+	}
+
+	r.Header.Del("Cookie")
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// hostname returns host without its port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return strings.ToLower(h)
+	}
+	return strings.ToLower(host)
+}
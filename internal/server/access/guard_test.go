@@ -0,0 +1,341 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// mockIdP is an OpenID Connect provider that logs in the configured user
+// without asking and checks the client's token requests.
+type mockIdP struct {
+	server *httptest.Server
+	email  string
+
+	mu    sync.Mutex
+	codes map[string]url.Values // Code → parameters of its authorization request
+}
+
+func newMockIdP(t *testing.T, email string) *mockIdP {
+	t.Helper()
+
+	idp := &mockIdP{email: email, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":%q,"token_endpoint":%q}`,
+			idp.server.URL, idp.server.URL+"/authorize", idp.server.URL+"/token")
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != "grok" || query.Get("code_challenge_method") != "S256" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		code := uuid.NewString()
+		idp.mu.Lock()
+		idp.codes[code] = query
+		idp.mu.Unlock()
+
+		callback := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, callback, http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "grok" || secret != "client-secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		idp.mu.Lock()
+		authorize, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || r.FormValue("redirect_uri") != authorize.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != authorize.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":            idp.server.URL,
+			"aud":            "grok",
+			"sub":            "user-1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          authorize.Get("nonce"),
+			"email":          idp.email,
+			"email_verified": true,
+		}).SignedString([]byte("idp-key"))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"at","token_type":"Bearer","id_token":%q}`, idToken)
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// edge runs a guard in front of an app that echoes what it receives, for
+// the hosts grok.test (login) and myapp.grok.test (a tunnel).
+type edge struct {
+	guard  *Guard
+	server *httptest.Server
+	client *http.Client
+	policy *Policy
+	scope  string
+}
+
+func newEdge(t *testing.T, idp *mockIdP, msg *tunnelv1.AccessPolicy) *edge {
+	t.Helper()
+
+	policy, err := PolicyFromProto(msg)
+	require.NoError(t, err)
+	e := &edge{policy: policy, scope: Scope("myapp", uuid.New())}
+
+	e.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e.guard.IsLoginRequest(r) {
+			e.guard.ServeLogin(w, r)
+			return
+		}
+		if hostname(r.Host) != "myapp.grok.test" {
+			http.NotFound(w, r)
+			return
+		}
+		if e.guard.Authorize(w, r, e.scope, e.policy) {
+			fmt.Fprintf(w, "app %s authorization=%q cookie=%q", r.URL.RequestURI(), r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+		}
+	}))
+	t.Cleanup(e.server.Close)
+	_, port, _ := net.SplitHostPort(e.server.Listener.Addr().String())
+
+	cfg := Config{Secret: testSecret}
+	if idp != nil {
+		cfg.OIDC = &OIDCConfig{
+			Issuer:       idp.server.URL,
+			ClientID:     "grok",
+			ClientSecret: "client-secret",
+			RedirectURL:  "http://grok.test:" + port + CallbackPath,
+		}
+	}
+	e.guard, err = NewGuard(cfg)
+	require.NoError(t, err)
+
+	// *.grok.test resolves to the edge
+	jar, _ := cookiejar.New(nil)
+	e.client = &http.Client{
+		Jar: jar,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if host, _, _ := net.SplitHostPort(addr); strings.HasSuffix(host, "grok.test") {
+					addr = e.server.Listener.Addr().String()
+				}
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	return e
+}
+
+// url returns the URL of path on the tunnel host.
+func (e *edge) url(path string) string {
+	_, port, _ := net.SplitHostPort(e.server.Listener.Addr().String())
+	return "http://myapp.grok.test:" + port + path
+}
+
+func (e *edge) get(t *testing.T, rawURL string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := e.client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// TestGuard_Public tests that tunnels without a policy are not touched.
+func TestGuard_Public(t *testing.T) {
+	e := newEdge(t, nil, nil)
+
+	status, body := e.get(t, e.url("/"), http.Header{"Authorization": {"Bearer app-token"}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `authorization="Bearer app-token"`)
+}
+
+// TestGuard_BasicAuth tests basic auth and that credentials are not forwarded.
+func TestGuard_BasicAuth(t *testing.T) {
+	e := newEdge(t, nil, &tunnelv1.AccessPolicy{
+		BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "alice", Password: "s3cret"}},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, e.url("/"), nil)
+	resp, err := e.client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+
+	req, _ = http.NewRequest(http.MethodGet, e.url("/"), nil)
+	req.SetBasicAuth("alice", "wrong")
+	resp, err = e.client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodGet, e.url("/page"), nil)
+	req.SetBasicAuth("alice", "s3cret")
+	resp, err = e.client.Do(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `app /page authorization=""`)
+}
+
+// TestGuard_ShareLink tests that a share link opens a session lasting until
+// it expires, for its tunnel only.
+func TestGuard_ShareLink(t *testing.T) {
+	e := newEdge(t, nil, &tunnelv1.AccessPolicy{ShareLinks: true})
+
+	status, _ := e.get(t, e.url("/"), nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	link, expires, err := e.guard.CreateShareLink(e.scope, e.url(""), time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, 2*time.Second)
+
+	// The token is swapped for a session cookie, which the app does not see
+	status, body := e.get(t, link, http.Header{"Cookie": {"theme=dark"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `app / authorization="" cookie="theme=dark"`, body)
+
+	status, _ = e.get(t, e.url("/other"), nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	t.Run("other tunnel", func(t *testing.T) {
+		other, _, err := e.guard.CreateShareLink(Scope("myapp", uuid.New()), e.url(""), time.Hour)
+		require.NoError(t, err)
+		fresh := newEdge(t, nil, &tunnelv1.AccessPolicy{ShareLinks: true})
+		fresh.guard = e.guard
+		status, _ := fresh.get(t, strings.Replace(other, e.url(""), fresh.url(""), 1), nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		fresh := newEdge(t, nil, &tunnelv1.AccessPolicy{ShareLinks: true})
+		fresh.guard.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+		link, _, err := fresh.guard.CreateShareLink(fresh.scope, fresh.url(""), time.Hour)
+		require.NoError(t, err)
+		fresh.guard.now = time.Now
+
+		status, _ := fresh.get(t, link, nil)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("share links disabled", func(t *testing.T) {
+		e.policy, err = PolicyFromProto(&tunnelv1.AccessPolicy{BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "a", Password: "b"}}})
+		require.NoError(t, err)
+		status, _ := e.get(t, e.url("/"), nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("max ttl", func(t *testing.T) {
+		guard, err := NewGuard(Config{Secret: testSecret, MaxShareLinkTTL: time.Hour})
+		require.NoError(t, err)
+		_, _, err = guard.CreateShareLink(e.scope, e.url(""), 2*time.Hour)
+		assert.ErrorIs(t, err, ErrShareLinkTTL)
+	})
+}
+
+// TestGuard_OIDC tests the login flow against a mock provider.
+func TestGuard_OIDC(t *testing.T) {
+	idp := newMockIdP(t, "Bob@Example.com")
+	e := newEdge(t, idp, &tunnelv1.AccessPolicy{
+		Oidc: &tunnelv1.OIDCAccess{AllowedDomains: []string{"example.com"}},
+	})
+
+	// Provider login, callback and ticket end on the page first asked for
+	status, body := e.get(t, e.url("/dashboard?tab=2"), http.Header{"Accept": {"text/html"}})
+	require.Equal(t, http.StatusOK, status, body)
+	assert.Equal(t, `app /dashboard?tab=2 authorization="" cookie=""`, body)
+
+	status, body = e.get(t, e.url("/api"), nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "app /api")
+
+	t.Run("user removed from allowlist", func(t *testing.T) {
+		e.policy, _ = PolicyFromProto(&tunnelv1.AccessPolicy{
+			Oidc: &tunnelv1.OIDCAccess{AllowedEmails: []string{"alice@example.com"}},
+		})
+		e.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		defer func() { e.client.CheckRedirect = nil }()
+
+		// The session is no longer accepted and a new login is required
+		req, _ := http.NewRequest(http.MethodGet, e.url("/"), nil)
+		resp, err := e.client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusFound, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get("Location"), "/_grok/login?state=")
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		other := newEdge(t, newMockIdP(t, "mallory@evil.io"), &tunnelv1.AccessPolicy{
+			Oidc: &tunnelv1.OIDCAccess{AllowedDomains: []string{"example.com"}},
+		})
+		status, body := other.get(t, other.url("/"), nil)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, "mallory@evil.io is not allowed")
+	})
+
+	t.Run("callback without login cookie", func(t *testing.T) {
+		state, err := e.guard.signToken(audienceLogin, e.scope, time.Now().Add(time.Minute), tokenClaims{URL: e.url("/")})
+		require.NoError(t, err)
+		_, port, _ := net.SplitHostPort(e.server.Listener.Addr().String())
+
+		jar, _ := cookiejar.New(nil)
+		e.client.Jar = jar
+		status, _ := e.get(t, "http://grok.test:"+port+CallbackPath+"?code=x&state="+url.QueryEscape(state), nil)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+// TestGuard_NoOIDC tests that OIDC tunnels fall back to basic auth and are
+// never forwarded unchecked.
+func TestGuard_NoOIDC(t *testing.T) {
+	e := newEdge(t, nil, &tunnelv1.AccessPolicy{
+		Oidc: &tunnelv1.OIDCAccess{AllowedDomains: []string{"example.com"}},
+	})
+	status, _ := e.get(t, e.url("/"), nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	var guard *Guard
+	rec := httptest.NewRecorder()
+	assert.False(t, guard.Authorize(rec, httptest.NewRequest(http.MethodGet, "/", nil), e.scope, e.policy))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.True(t, guard.Authorize(rec, httptest.NewRequest(http.MethodGet, "/", nil), e.scope, nil))
+}
@@ -0,0 +1,232 @@
+package access
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcTimeout bounds requests to the OIDC provider.
+const oidcTimeout = 10 * time.Second
+
+// OIDCConfig configures the OpenID Connect provider visitors log in with.
+type OIDCConfig struct {
+	Issuer       string   // Issuer URL; endpoints are discovered from it
+	ClientID     string   // Client registered with the provider
+	ClientSecret string   // Secret of the client
+	Scopes       []string // Requested scopes (default: openid email)
+	RedirectURL  string   // Callback URL registered with the provider, on the server's base domain
+	HTTPClient   *http.Client
+
+	// AllowMissingEmailVerified accepts ID tokens without an email_verified
+	// claim, for providers that only issue verified emails and omit it.
+	// A claim set to false is always refused.
+	AllowMissingEmailVerified bool
+}
+
+// discovery holds the endpoints read from the provider's discovery document.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// oidcProvider runs the authorization code flow with an OpenID Connect provider.
+type oidcProvider struct {
+	cfg         OIDCConfig
+	client      *http.Client
+	verifierKey []byte // Derives PKCE verifiers
+
+	mu        sync.Mutex
+	endpoints *discovery // Cached after the first successful discovery
+}
+
+func newOIDCProvider(cfg OIDCConfig, verifierKey []byte) (*oidcProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc: issuer and client_id are required")
+	}
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || redirect.Host == "" {
+		return nil, fmt.Errorf("oidc: invalid redirect URL %q", cfg.RedirectURL)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email"}
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: oidcTimeout}
+	}
+	return &oidcProvider{cfg: cfg, client: client, verifierKey: verifierKey}, nil
+}
+
+// discover returns the provider's endpoints.
+func (p *oidcProvider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc discovery
+	if err := p.do(req, &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if doc.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, errors.New("oidc discovery: endpoints missing")
+	}
+
+	p.endpoints = &doc
+	return p.endpoints, nil
+}
+
+// authCodeURL returns the URL of the provider's login page. The nonce is
+// bound to the visitor's browser and derives the PKCE verifier.
+func (p *oidcProvider) authCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(p.codeVerifier(nonce)))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// codeVerifier derives the PKCE verifier of a login from its nonce, so that
+// it does not have to be stored.
+func (p *oidcProvider) codeVerifier(nonce string) string {
+	mac := hmac.New(sha256.New, p.verifierKey)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// exchange redeems an authorization code and returns the verified email of the user.
+func (p *oidcProvider) exchange(ctx context.Context, code, nonce string, now time.Time) (string, error) {
+	endpoints, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {p.codeVerifier(nonce)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var resp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &resp); err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if resp.IDToken == "" {
+		return "", errors.New("oidc token exchange: no id_token in response")
+	}
+
+	return p.verifiedEmail(resp.IDToken, endpoints.Issuer, nonce, now)
+}
+
+// verifiedEmail checks the claims of an ID token and returns its email.
+// The token comes straight from the token endpoint over TLS, which
+// authenticates the provider in place of the token signature (OpenID
+// Connect Core 1.0, section 3.1.3.7).
+func (p *oidcProvider) verifiedEmail(idToken, issuer, nonce string, now time.Time) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		return "", fmt.Errorf("oidc: invalid id_token: %w", err)
+	}
+
+	if iss, _ := claims.GetIssuer(); iss != issuer {
+		return "", fmt.Errorf("oidc: id_token issued by %q", iss)
+	}
+	audience, _ := claims.GetAudience()
+	if !slices.Contains(audience, p.cfg.ClientID) {
+		return "", errors.New("oidc: id_token is not for this client")
+	}
+	if exp, _ := claims.GetExpirationTime(); exp == nil || !now.Before(exp.Time) {
+		return "", errors.New("oidc: id_token expired")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return "", errors.New("oidc: id_token nonce mismatch")
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", errors.New("oidc: id_token has no email (request the email scope)")
+	}
+	// Providers send email_verified as a boolean, some as a string
+	var verified bool
+	switch claim := claims["email_verified"].(type) {
+	case bool:
+		verified = claim
+	case string:
+		verified = claim == "true"
+	case nil:
+		verified = p.cfg.AllowMissingEmailVerified
+	}
+	if !verified {
+		return "", fmt.Errorf("oidc: email %s is not verified", email)
+	}
+	return strings.ToLower(email), nil
+}
+
+// do sends req and decodes the JSON response into v.
+func (p *oidcProvider) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d: %s", req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
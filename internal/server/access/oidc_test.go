@@ -0,0 +1,59 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOIDCProvider_VerifiedEmail tests that only emails the provider marks
+// as verified are accepted.
+func TestOIDCProvider_VerifiedEmail(t *testing.T) {
+	now := time.Now()
+	idToken := func(verified interface{}) string {
+		claims := jwt.MapClaims{
+			"iss":   "https://idp.test",
+			"aud":   "grok",
+			"exp":   now.Add(time.Hour).Unix(),
+			"nonce": "n",
+			"email": "Bob@Example.com",
+		}
+		if verified != nil {
+			claims["email_verified"] = verified
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("idp-key"))
+		require.NoError(t, err)
+		return token
+	}
+
+	tests := []struct {
+		name         string
+		verified     interface{}
+		allowMissing bool
+		wantErr      bool
+	}{
+		{name: "verified", verified: true},
+		{name: "verified string", verified: "true"},
+		{name: "not verified", verified: false, wantErr: true},
+		{name: "not verified string", verified: "false", wantErr: true},
+		{name: "missing", wantErr: true},
+		{name: "missing allowed", allowMissing: true},
+		{name: "not verified with missing allowed", verified: false, allowMissing: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &oidcProvider{cfg: OIDCConfig{ClientID: "grok", AllowMissingEmailVerified: tt.allowMissing}}
+			email, err := p.verifiedEmail(idToken(tt.verified), "https://idp.test", "n", now)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "not verified")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "bob@example.com", email)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Package access enforces the access policies of HTTP tunnels at the edge:
+// basic auth, login with an OpenID Connect provider and signed share links.
+// Visitors are checked by the server before any request reaches the client.
+package access
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// Policy is the access policy of a tunnel. Visitors get in with any of the
+// enabled methods; a nil policy leaves the tunnel public.
+type Policy struct {
+	BasicAuth  map[string][sha256.Size]byte // Username → SHA-256 of the password
+	OIDC       *OIDCRule                    // Login with the server's OIDC provider
+	ShareLinks bool                         // Accept signed share links
+}
+
+// OIDCRule restricts OIDC login to some users.
+type OIDCRule struct {
+	Emails  []string // Lowercase email addresses
+	Domains []string // Lowercase email domains
+}
+
+// PolicyFromProto converts the policy declared by a client. It returns nil
+// when no method is enabled.
+func PolicyFromProto(msg *tunnelv1.AccessPolicy) (*Policy, error) {
+	if msg == nil {
+		return nil, nil
+	}
+
+	policy := &Policy{ShareLinks: msg.ShareLinks}
+
+	for _, cred := range msg.BasicAuth {
+		if cred.Username == "" || strings.Contains(cred.Username, ":") {
+			return nil, fmt.Errorf("invalid basic auth username %q", cred.Username)
+		}
+		if cred.Password == "" {
+			return nil, fmt.Errorf("basic auth user %q has no password", cred.Username)
+		}
+		if policy.BasicAuth == nil {
+			policy.BasicAuth = make(map[string][sha256.Size]byte)
+		}
+		policy.BasicAuth[cred.Username] = sha256.Sum256([]byte(cred.Password))
+	}
+
+	if msg.Oidc != nil {
+		rule := &OIDCRule{}
+		for _, email := range msg.Oidc.AllowedEmails {
+			email = strings.ToLower(strings.TrimSpace(email))
+			if !strings.Contains(email, "@") {
+				return nil, fmt.Errorf("invalid allowed email %q", email)
+			}
+			rule.Emails = append(rule.Emails, email)
+		}
+		for _, domain := range msg.Oidc.AllowedDomains {
+			domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+			if domain == "" || strings.Contains(domain, "@") {
+				return nil, fmt.Errorf("invalid allowed domain %q", domain)
+			}
+			rule.Domains = append(rule.Domains, domain)
+		}
+		// Any account of a public provider could log in otherwise
+		if len(rule.Emails) == 0 && len(rule.Domains) == 0 {
+			return nil, errors.New("oidc access needs allowed emails or domains")
+		}
+		policy.OIDC = rule
+	}
+
+	if policy.BasicAuth == nil && policy.OIDC == nil && !policy.ShareLinks {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// checkBasicAuth reports whether username and password match a credential.
+func (p *Policy) checkBasicAuth(username, password string) bool {
+	want, ok := p.BasicAuth[username]
+	sum := sha256.Sum256([]byte(password))
+	return ok && subtle.ConstantTimeCompare(want[:], sum[:]) == 1
+}
+
+// Allows reports whether the user with the (verified) email may log in.
+func (r *OIDCRule) Allows(email string) bool {
+	email = strings.ToLower(email)
+	for _, allowed := range r.Emails {
+		if email == allowed {
+			return true
+		}
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return false
+	}
+	for _, domain := range r.Domains {
+		if email[at+1:] == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Methods names the enabled methods, for logs.
+func (p *Policy) Methods() []string {
+	var methods []string
+	if len(p.BasicAuth) > 0 {
+		methods = append(methods, "basic_auth")
+	}
+	if p.OIDC != nil {
+		methods = append(methods, "oidc")
+	}
+	if p.ShareLinks {
+		methods = append(methods, "share_links")
+	}
+	return methods
+}
@@ -0,0 +1,60 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// TestPolicyFromProto tests the validation of client-declared policies.
+func TestPolicyFromProto(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *tunnelv1.AccessPolicy
+		public  bool
+		wantErr bool
+	}{
+		{name: "unset", msg: nil, public: true},
+		{name: "nothing enabled", msg: &tunnelv1.AccessPolicy{}, public: true},
+		{name: "basic auth", msg: &tunnelv1.AccessPolicy{BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "alice", Password: "s3cret"}}}},
+		{name: "share links", msg: &tunnelv1.AccessPolicy{ShareLinks: true}},
+		{name: "oidc", msg: &tunnelv1.AccessPolicy{Oidc: &tunnelv1.OIDCAccess{AllowedDomains: []string{"@Example.com"}}}},
+		{name: "empty password", msg: &tunnelv1.AccessPolicy{BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "alice"}}}, wantErr: true},
+		{name: "colon in username", msg: &tunnelv1.AccessPolicy{BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "a:b", Password: "x"}}}, wantErr: true},
+		{name: "oidc without allowlist", msg: &tunnelv1.AccessPolicy{Oidc: &tunnelv1.OIDCAccess{}}, wantErr: true},
+		{name: "invalid email", msg: &tunnelv1.AccessPolicy{Oidc: &tunnelv1.OIDCAccess{AllowedEmails: []string{"alice"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := PolicyFromProto(tt.msg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.public, policy == nil)
+		})
+	}
+}
+
+// TestOIDCRule_Allows tests the email and domain allowlists.
+func TestOIDCRule_Allows(t *testing.T) {
+	policy, err := PolicyFromProto(&tunnelv1.AccessPolicy{Oidc: &tunnelv1.OIDCAccess{
+		AllowedEmails:  []string{"Alice@Partner.org"},
+		AllowedDomains: []string{"example.com"},
+	}})
+	require.NoError(t, err)
+	rule := policy.OIDC
+
+	assert.True(t, rule.Allows("alice@partner.org"))
+	assert.True(t, rule.Allows("bob@example.com"))
+	assert.True(t, rule.Allows("BOB@EXAMPLE.COM"))
+	assert.False(t, rule.Allows("mallory@partner.org"))
+	assert.False(t, rule.Allows("bob@sub.example.com"))
+	assert.False(t, rule.Allows("bob@example.com.evil.io"))
+	assert.False(t, rule.Allows("example.com"))
+}
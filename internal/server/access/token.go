@@ -0,0 +1,58 @@
+package access
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audiences of the tokens signed by the guard, so that one kind of token is
+// never accepted as another.
+const (
+	audienceShare   = "grok-share"   // Share link
+	audienceSession = "grok-session" // Cookie of a visitor let into a tunnel
+	audienceLogin   = "grok-login"   // OIDC login state
+	audienceTicket  = "grok-ticket"  // Hands a completed login over to the tunnel host
+)
+
+// Session methods.
+const (
+	viaOIDC  = "oidc"
+	viaShare = "share"
+)
+
+// tokenClaims are the claims of the tokens signed by the guard. The subject
+// is the scope of the tunnel the token is valid for.
+type tokenClaims struct {
+	User string `json:"email,omitempty"` // Email of an OIDC user
+	Via  string `json:"via,omitempty"`   // Method a session was opened with
+	URL  string `json:"url,omitempty"`   // Login: URL to return to
+	jwt.RegisteredClaims
+}
+
+// signToken signs claims as a token of the audience for scope, valid until expires.
+func (g *Guard) signToken(audience, scope string, expires time.Time, claims tokenClaims) (string, error) {
+	claims.Subject = scope
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.ExpiresAt = jwt.NewNumericDate(expires)
+	claims.IssuedAt = jwt.NewNumericDate(g.now())
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(g.secret)
+}
+
+// parseToken verifies a token of the audience and returns its claims.
+func (g *Guard) parseToken(token, audience string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return g.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(g.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s token: %w", audience, err)
+	}
+	return claims, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -12,7 +13,12 @@ type Config struct {
 	Server   ServerConfig   `mapstructure:"server"`
 	Database DatabaseConfig `mapstructure:"database"`
 	TLS      TLSConfig      `mapstructure:"tls"`
+	DNS      DNSConfig      `mapstructure:"dns"`
 	Auth     AuthConfig     `mapstructure:"auth"`
+	Access   AccessConfig   `mapstructure:"access"`
+	GeoIP    GeoIPConfig    `mapstructure:"geoip"`
+	Limits   LimitsConfig   `mapstructure:"limits"`
+	Cache    CacheConfig    `mapstructure:"cache"`
 	Tunnels  TunnelsConfig  `mapstructure:"tunnels"`
 	Webhooks WebhooksConfig `mapstructure:"webhooks"`
 	Logging  LoggingConfig  `mapstructure:"logging"`
@@ -28,6 +34,22 @@ type ServerConfig struct {
 	TCPPortStart   int      `mapstructure:"tcp_port_start"`
 	TCPPortEnd     int      `mapstructure:"tcp_port_end"`
 	AllowedOrigins []string `mapstructure:"allowed_origins"`
+
+	// PROXY protocol on the public HTTP, HTTPS and TCP tunnel listeners
+	ProxyProtocol ProxyProtocolConfig `mapstructure:"proxy_protocol"`
+
+	// Unencrypted HTTP/2 (prior knowledge) on the public HTTP listener,
+	// alongside HTTP/1.1. HTTPS visitors always negotiate HTTP/2.
+	H2C bool `mapstructure:"h2c"`
+}
+
+// ProxyProtocolConfig holds the PROXY protocol settings of the public
+// listeners, for servers behind an L4 load balancer. Connections from trusted
+// networks must start with a PROXY header (version 1 or 2); others are
+// accepted as they are.
+type ProxyProtocolConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	TrustedCIDRs []string `mapstructure:"trusted_cidrs"` // Networks or addresses of the load balancers
 }
 
 // DatabaseConfig holds database settings.
@@ -48,6 +70,42 @@ type TLSConfig struct {
 	CertFile string `mapstructure:"cert_file"`
 	KeyFile  string `mapstructure:"key_file"`
 	Email    string `mapstructure:"email"`
+
+	// On-demand certificates for tunnel subdomains (auto_cert)
+	MaxCertsPerHour int    `mapstructure:"max_certs_per_hour"` // Cap on new certificates per hour; 0 for no cap
+	ACMEDirectory   string `mapstructure:"acme_directory"`     // ACME directory URL (default: Let's Encrypt)
+	ACMECAFile      string `mapstructure:"acme_ca_file"`       // CA trusted for acme_directory, e.g. a local test CA
+
+	// Wildcard certificate for the domain and its subdomains via ACME DNS-01 (auto_cert)
+	DNSProvider         string         `mapstructure:"dns_provider"`          // rfc2136, exec or builtin (dns server); empty for per-subdomain certificates
+	DNSPropagationDelay time.Duration  `mapstructure:"dns_propagation_delay"` // Wait after publishing challenge records
+	RFC2136             RFC2136Config  `mapstructure:"rfc2136"`
+	Exec                ExecHookConfig `mapstructure:"exec"`
+}
+
+// RFC2136Config holds the settings of the RFC 2136 (dynamic update) DNS provider.
+type RFC2136Config struct {
+	Nameserver    string `mapstructure:"nameserver"`     // Primary server of the zone, host[:port]
+	Zone          string `mapstructure:"zone"`           // Zone of the challenge records (default: server.domain)
+	TSIGKey       string `mapstructure:"tsig_key"`       // TSIG key name
+	TSIGSecret    string `mapstructure:"tsig_secret"`    // TSIG secret (base64)
+	TSIGAlgorithm string `mapstructure:"tsig_algorithm"` // hmac-sha256 (default), hmac-sha512 or hmac-sha1
+}
+
+// ExecHookConfig holds the settings of the exec hook DNS provider.
+type ExecHookConfig struct {
+	Command string `mapstructure:"command"` // Run as: <command> present|cleanup <fqdn> <value>
+}
+
+// DNSConfig holds the settings of the built-in authoritative DNS server for
+// server.domain, used when the zone is delegated to grok.
+type DNSConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	Addr        string   `mapstructure:"addr"`        // UDP and TCP listen address
+	Addresses   []string `mapstructure:"addresses"`   // Public IPs the domain and its subdomains resolve to
+	Nameservers []string `mapstructure:"nameservers"` // NS records of the zone (default: ns1.<domain>)
+	Hostmaster  string   `mapstructure:"hostmaster"`  // SOA contact (default: hostmaster@<domain>)
+	TTL         uint32   `mapstructure:"ttl"`         // TTL of address records
 }
 
 // AuthConfig holds authentication settings.
@@ -57,12 +115,78 @@ type AuthConfig struct {
 	AdminPassword string `mapstructure:"admin_password"`
 }
 
+// AccessConfig holds the settings of the access policies clients declare for
+// HTTP tunnels (basic auth, OIDC login and share links).
+type AccessConfig struct {
+	SessionDuration time.Duration `mapstructure:"session_duration"`   // Lifetime of a visitor's session after an OIDC login
+	MaxShareLinkTTL time.Duration `mapstructure:"max_share_link_ttl"` // Longest validity of a share link
+	OIDC            OIDCConfig    `mapstructure:"oidc"`
+}
+
+// OIDCConfig holds the OpenID Connect provider visitors of protected tunnels log in with.
+type OIDCConfig struct {
+	Issuer       string   `mapstructure:"issuer"` // Empty disables OIDC login
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	Scopes       []string `mapstructure:"scopes"`
+	RedirectURL  string   `mapstructure:"redirect_url"` // Default: /_grok/callback on server.domain
+
+	// Accept ID tokens without an email_verified claim; only for providers
+	// that never issue unverified emails
+	AllowMissingEmailVerified bool `mapstructure:"allow_missing_email_verified"`
+}
+
+// GeoIPConfig holds the database used by the country rules of tunnels and organizations.
+type GeoIPConfig struct {
+	Database string `mapstructure:"database"` // MaxMind DB file, e.g. GeoLite2-Country.mmdb; empty disables country rules
+}
+
+// LimitsConfig holds the rate limits and monthly transfer quotas enforced at
+// the edge. Zero values are unlimited.
+type LimitsConfig struct {
+	Tunnel          RateLimitConfig       `mapstructure:"tunnel"`       // Each tunnel
+	User            RateLimitConfig       `mapstructure:"user"`         // All tunnels of a user
+	Organization    RateLimitConfig       `mapstructure:"organization"` // All tunnels of an organization
+	MonthlyTransfer MonthlyTransferConfig `mapstructure:"monthly_transfer"`
+}
+
+// RateLimitConfig holds token bucket limits for requests and bandwidth.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"` // HTTP requests and new TCP connections
+	RequestBurst      int     `mapstructure:"request_burst"`
+	BytesPerSecond    int64   `mapstructure:"bytes_per_second"` // Traffic in and out together
+	ByteBurst         int64   `mapstructure:"byte_burst"`
+}
+
+// MonthlyTransferConfig holds the bytes users and organizations may transfer
+// per calendar month (UTC).
+type MonthlyTransferConfig struct {
+	User         int64 `mapstructure:"user"`
+	Organization int64 `mapstructure:"organization"`
+}
+
+// CacheConfig holds the edge cache for responses of HTTP tunnels that opt
+// into caching. Caching is disabled when both max_memory and max_disk are 0.
+type CacheConfig struct {
+	MaxMemory     int64  `mapstructure:"max_memory"`      // Bytes of responses kept in memory
+	MaxObjectSize int64  `mapstructure:"max_object_size"` // Larger responses are not cached
+	Dir           string `mapstructure:"dir"`             // Directory for responses evicted from memory; empty for memory only
+	MaxDisk       int64  `mapstructure:"max_disk"`        // Bytes of responses kept in dir
+}
+
 // TunnelsConfig holds tunnel settings.
 type TunnelsConfig struct {
 	MaxPerUser        int    `mapstructure:"max_per_user"`
 	IdleTimeout       string `mapstructure:"idle_timeout"`
 	HeartbeatInterval string `mapstructure:"heartbeat_interval"`
 	MaxRequestLogs    int    `mapstructure:"max_request_logs"` // Maximum number of request logs to keep per tunnel
+
+	// Persistent tunnels whose client disconnects hold incoming HTTP requests
+	// for this long, waiting for the client to reconnect (0 disables)
+	ReconnectGracePeriod time.Duration `mapstructure:"reconnect_grace_period"`
+	MaxHeldRequests      int           `mapstructure:"max_held_requests"` // Maximum requests held per tunnel during the grace period
+	UDPFlowTimeout       time.Duration `mapstructure:"udp_flow_timeout"`  // UDP flows without traffic for this long are closed
+	UDPMaxFlows          int           `mapstructure:"udp_max_flows"`     // Flows kept per UDP tunnel; the least recently active is closed for a new peer
 }
 
 // WebhooksConfig holds webhook settings.
@@ -154,6 +278,7 @@ func setDefaults() {
 	viper.SetDefault("server.domain", "grok.io")
 	viper.SetDefault("server.tcp_port_start", 10000)
 	viper.SetDefault("server.tcp_port_end", 20000)
+	viper.SetDefault("server.h2c", false)
 	// CORS defaults - localhost for development
 	viper.SetDefault("server.allowed_origins", []string{
 		"http://localhost:5173", // Vite dev server
@@ -172,16 +297,36 @@ func setDefaults() {
 	// TLS defaults
 	viper.SetDefault("tls.auto_cert", true)
 	viper.SetDefault("tls.cert_dir", "/var/lib/grok/certs")
+	viper.SetDefault("tls.max_certs_per_hour", 20)
+	viper.SetDefault("tls.dns_propagation_delay", "10s")
+
+	// DNS defaults
+	viper.SetDefault("dns.enabled", false)
+	viper.SetDefault("dns.addr", ":53")
+	viper.SetDefault("dns.ttl", 300)
 
 	// Auth defaults
 	viper.SetDefault("auth.admin_username", "admin")
 	// Note: auth.admin_password must be set via config file or GROK_AUTH_ADMIN_PASSWORD environment variable
 
+	// Access policy defaults
+	viper.SetDefault("access.session_duration", "24h")
+	viper.SetDefault("access.max_share_link_ttl", "720h") // 30 days
+	viper.SetDefault("access.oidc.scopes", []string{"openid", "email"})
+	viper.SetDefault("access.oidc.allow_missing_email_verified", false)
+
+	// Edge cache defaults
+	viper.SetDefault("cache.max_object_size", 8<<20) // 8MB
+
 	// Tunnel defaults
 	viper.SetDefault("tunnels.max_per_user", 5)
 	viper.SetDefault("tunnels.idle_timeout", "10m")
 	viper.SetDefault("tunnels.heartbeat_interval", "30s")
 	viper.SetDefault("tunnels.max_request_logs", 1000) // Keep last 1000 requests per tunnel
+	viper.SetDefault("tunnels.reconnect_grace_period", "10s")
+	viper.SetDefault("tunnels.max_held_requests", 100)
+	viper.SetDefault("tunnels.udp_flow_timeout", "60s")
+	viper.SetDefault("tunnels.udp_max_flows", 1024)
 
 	// Webhook defaults
 	viper.SetDefault("webhooks.max_events", 500) // Keep last 500 webhook events per app
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -117,6 +118,10 @@ auth:
 	assert.Equal(t, "sqlite", cfg.Database.Driver)
 	assert.Equal(t, "grok.db", cfg.Database.Database)
 	assert.Equal(t, 5, cfg.Tunnels.MaxPerUser)
+	assert.Equal(t, 10*time.Second, cfg.Tunnels.ReconnectGracePeriod)
+	assert.Equal(t, 100, cfg.Tunnels.MaxHeldRequests)
+	assert.Equal(t, 60*time.Second, cfg.Tunnels.UDPFlowTimeout)
+	assert.Equal(t, 1024, cfg.Tunnels.UDPMaxFlows)
 	assert.Equal(t, "info", cfg.Logging.Level)
 }
 
@@ -315,6 +320,10 @@ tunnels:
   max_per_user: 20
   idle_timeout: "30m"
   heartbeat_interval: "60s"
+  reconnect_grace_period: "30s"
+  max_held_requests: 10
+  udp_flow_timeout: "2m"
+  udp_max_flows: 64
 `
 
 	err := os.WriteFile(configFile, []byte(configContent), 0o644)
@@ -326,6 +335,10 @@ tunnels:
 	assert.Equal(t, 20, cfg.Tunnels.MaxPerUser)
 	assert.Equal(t, "30m", cfg.Tunnels.IdleTimeout)
 	assert.Equal(t, "60s", cfg.Tunnels.HeartbeatInterval)
+	assert.Equal(t, 30*time.Second, cfg.Tunnels.ReconnectGracePeriod)
+	assert.Equal(t, 10, cfg.Tunnels.MaxHeldRequests)
+	assert.Equal(t, 2*time.Minute, cfg.Tunnels.UDPFlowTimeout)
+	assert.Equal(t, 64, cfg.Tunnels.UDPMaxFlows)
 }
 
 // TestLoad_AllowedOrigins tests loading CORS allowed origins.
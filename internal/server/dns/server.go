@@ -0,0 +1,512 @@
+// Package dns implements an authoritative DNS server for the tunnel domain,
+// so that the zone can be delegated to grok instead of being managed at an
+// external DNS provider.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+const (
+	// defaultTTL is the TTL of answers when none is configured.
+	defaultTTL = 300
+
+	// challengeTTL is the TTL of challenge TXT records, short so that new
+	// values are seen quickly.
+	challengeTTL = 60
+
+	// maxUDPSize is the largest UDP response sent to EDNS clients; larger
+	// answers are truncated so the client retries over TCP.
+	maxUDPSize = 1232
+
+	// tcpIdleTimeout closes TCP connections without queries for this long.
+	tcpIdleTimeout = 10 * time.Second
+
+	// verificationLabel prefixes the delegated custom domain challenge names
+	// (models.CustomDomain.DelegatedChallengeRecord).
+	verificationLabel = "_grok-challenge."
+)
+
+// Config holds the settings of the DNS server.
+type Config struct {
+	Addr        string   // Listen address for UDP and TCP, e.g. ":53"
+	Domain      string   // Zone served: the server domain
+	Addresses   []string // IPv4 and IPv6 addresses the domain and its subdomains resolve to
+	Nameservers []string // NS records of the zone (default: ns1.<domain>)
+	Hostmaster  string   // SOA contact mailbox (default: hostmaster.<domain>)
+	TTL         uint32   // TTL of A, AAAA, NS and SOA answers (default: 300)
+}
+
+// ChallengeTokens looks up the verification token of a custom domain.
+type ChallengeTokens interface {
+	VerificationToken(hostname string) (string, bool)
+}
+
+// Server answers queries for the zone of the server domain: A and AAAA
+// records for the domain and every name under it, NS and SOA records at the
+// apex, and TXT records for ACME DNS-01 challenges and custom domain
+// verification. It is a tls.DNSProvider for the challenges it serves.
+type Server struct {
+	addr     string
+	zone     string // Lowercase, with a trailing dot
+	ttl      uint32
+	a        []dnsmessage.AResource
+	aaaa     []dnsmessage.AAAAResource
+	ns       []dnsmessage.Name
+	soa      dnsmessage.SOAResource
+	soaName  dnsmessage.Name
+	nsGlue   map[string]bool // NS names inside the zone, answered with glue
+	domains  ChallengeTokens // Set with SetCustomDomains
+	udpConn  net.PacketConn
+	listener net.Listener
+
+	mu  sync.RWMutex
+	txt map[string][]string // lowercase name → values
+}
+
+// NewServer creates a DNS server for cfg.Domain.
+func NewServer(cfg Config) (*Server, error) {
+	domain := strings.TrimSuffix(strings.ToLower(cfg.Domain), ".")
+	if domain == "" {
+		return nil, errors.New("dns: domain is required")
+	}
+	if len(cfg.Addresses) == 0 {
+		return nil, errors.New("dns: at least one address is required")
+	}
+
+	s := &Server{
+		addr:   cfg.Addr,
+		zone:   domain + ".",
+		ttl:    cfg.TTL,
+		nsGlue: make(map[string]bool),
+		txt:    make(map[string][]string),
+	}
+	if s.ttl == 0 {
+		s.ttl = defaultTTL
+	}
+
+	for _, addr := range cfg.Addresses {
+		ip := net.ParseIP(addr)
+		switch {
+		case ip == nil:
+			return nil, fmt.Errorf("dns: invalid address %q", addr)
+		case ip.To4() != nil:
+			s.a = append(s.a, dnsmessage.AResource{A: [4]byte(ip.To4())})
+		default:
+			s.aaaa = append(s.aaaa, dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+		}
+	}
+
+	nameservers := cfg.Nameservers
+	if len(nameservers) == 0 {
+		nameservers = []string{"ns1." + domain}
+	}
+	for _, ns := range nameservers {
+		name, err := dnsmessage.NewName(fqdn(ns))
+		if err != nil {
+			return nil, fmt.Errorf("dns: invalid nameserver %q: %w", ns, err)
+		}
+		s.ns = append(s.ns, name)
+		if s.inZone(strings.ToLower(name.String())) {
+			s.nsGlue[strings.ToLower(name.String())] = true
+		}
+	}
+
+	hostmaster := cfg.Hostmaster
+	if hostmaster == "" {
+		hostmaster = "hostmaster." + domain
+	}
+	mbox, err := dnsmessage.NewName(fqdn(strings.Replace(hostmaster, "@", ".", 1)))
+	if err != nil {
+		return nil, fmt.Errorf("dns: invalid hostmaster %q: %w", hostmaster, err)
+	}
+	s.soaName, _ = dnsmessage.NewName(s.zone)
+	s.soa = dnsmessage.SOAResource{
+		NS:      s.ns[0],
+		MBox:    mbox,
+		Serial:  uint32(time.Now().Unix()), //nolint:gosec // Serials wrap by design (RFC 1982)
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		MinTTL:  challengeTTL, // Negative answers are cached briefly, challenges come and go
+	}
+
+	return s, nil
+}
+
+// SetCustomDomains serves the verification tokens of custom domains at
+// _grok-challenge.<hostname>.<zone>, the target of a delegated challenge record.
+// It must be called before Start.
+func (s *Server) SetCustomDomains(domains ChallengeTokens) {
+	s.domains = domains
+}
+
+// Start listens on the configured address over UDP and TCP and serves
+// queries in the background.
+func (s *Server) Start() error {
+	udpConn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("dns: failed to listen on udp %s: %w", s.addr, err)
+	}
+	// Same port over TCP, also when an ephemeral one was picked
+	listener, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("dns: failed to listen on tcp %s: %w", s.addr, err)
+	}
+	s.udpConn = udpConn
+	s.listener = listener
+
+	go s.serveUDP()
+	go s.serveTCP()
+
+	logger.InfoEvent().
+		Str("addr", udpConn.LocalAddr().String()).
+		Str("zone", s.zone).
+		Msg("DNS server listening")
+	return nil
+}
+
+// Addr returns the address the server listens on, once started.
+func (s *Server) Addr() string {
+	if s.udpConn == nil {
+		return s.addr
+	}
+	return s.udpConn.LocalAddr().String()
+}
+
+// Shutdown stops serving.
+func (s *Server) Shutdown() {
+	if s.udpConn != nil {
+		s.udpConn.Close()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+// Present adds a TXT record, e.g. for an ACME DNS-01 challenge.
+func (s *Server) Present(_ context.Context, name, value string) error {
+	name = strings.ToLower(fqdn(name))
+	if !s.inZone(name) {
+		return fmt.Errorf("dns: %s is not in zone %s", name, s.zone)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.txt[name] {
+		if v == value {
+			return nil
+		}
+	}
+	s.txt[name] = append(s.txt[name], value)
+	s.soa.Serial++
+	return nil
+}
+
+// CleanUp removes a TXT record added with Present.
+func (s *Server) CleanUp(_ context.Context, name, value string) error {
+	name = strings.ToLower(fqdn(name))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.txt[name]
+	for i, v := range values {
+		if v == value {
+			values = append(values[:i:i], values[i+1:]...)
+			if len(values) == 0 {
+				delete(s.txt, name)
+			} else {
+				s.txt[name] = values
+			}
+			s.soa.Serial++
+			return nil
+		}
+	}
+	return nil
+}
+
+// serveUDP answers queries received over UDP.
+func (s *Server) serveUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.WarnEvent().Err(err).Msg("DNS UDP read failed")
+			continue
+		}
+
+		if resp := s.handle(buf[:n], true); resp != nil {
+			if _, err := s.udpConn.WriteTo(resp, addr); err != nil {
+				logger.DebugEvent().Err(err).Str("remote", addr.String()).Msg("DNS UDP write failed")
+			}
+		}
+	}
+}
+
+// serveTCP accepts TCP connections.
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.WarnEvent().Err(err).Msg("DNS TCP accept failed")
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the length-prefixed queries of a TCP connection.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		if err := conn.SetDeadline(time.Now().Add(tcpIdleTimeout)); err != nil {
+			return
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+
+		resp := s.handle(msg, false)
+		if resp == nil {
+			return
+		}
+		out := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(resp)), uint16(len(resp))) //nolint:gosec // Messages are below 64 KiB
+		if _, err := conn.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers the query in msg. It returns nil for messages that get no
+// response.
+func (s *Server) handle(msg []byte, udp bool) []byte {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil || header.Response {
+		return nil
+	}
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		RecursionDesired: header.RecursionDesired,
+	}}
+
+	questions, err := p.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		resp.Header.RCode = dnsmessage.RCodeFormatError
+		return s.build(resp, false, 512)
+	}
+	resp.Questions = questions
+	q := questions[0]
+
+	// EDNS clients announce a larger UDP buffer
+	size, edns := ednsSize(&p)
+	if !udp {
+		size = 65535
+	}
+
+	if header.OpCode != 0 {
+		resp.Header.RCode = dnsmessage.RCodeNotImplemented
+		return s.build(resp, edns, size)
+	}
+
+	name := strings.ToLower(q.Name.String())
+	if !s.inZone(name) || q.Class != dnsmessage.ClassINET {
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return s.build(resp, edns, size)
+	}
+
+	resp.Header.Authoritative = true
+	resp.Answers, resp.Additionals = s.answer(q, name)
+	if len(resp.Answers) == 0 {
+		// No data: the SOA tells resolvers how long to cache that
+		resp.Authorities = []dnsmessage.Resource{s.soaRecord(s.soaName, min(s.ttl, challengeTTL))}
+	}
+	return s.build(resp, edns, size)
+}
+
+// ednsSize returns the UDP response size a query allows and whether it has
+// an EDNS OPT record. p must be past the questions.
+func ednsSize(p *dnsmessage.Parser) (int, bool) {
+	if err := p.SkipAllAnswers(); err != nil {
+		return 512, false
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return 512, false
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return 512, false
+		}
+		if h.Type == dnsmessage.TypeOPT {
+			return max(512, min(int(h.Class), maxUDPSize)), true
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return 512, false
+		}
+	}
+}
+
+// answer returns the records of q, for name in the zone, and the glue
+// addresses of in-zone nameservers in NS answers.
+func (s *Server) answer(q dnsmessage.Question, name string) ([]dnsmessage.Resource, []dnsmessage.Resource) {
+	apex := name == s.zone
+
+	switch q.Type {
+	case dnsmessage.TypeA:
+		return s.addresses(q.Name, dnsmessage.TypeA), nil
+	case dnsmessage.TypeAAAA:
+		return s.addresses(q.Name, dnsmessage.TypeAAAA), nil
+	case dnsmessage.TypeTXT:
+		var answers []dnsmessage.Resource
+		for _, value := range s.txtValues(name) {
+			answers = append(answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: challengeTTL},
+				Body:   &dnsmessage.TXTResource{TXT: []string{value}},
+			})
+		}
+		return answers, nil
+	case dnsmessage.TypeNS:
+		if !apex {
+			return nil, nil
+		}
+		var answers, glue []dnsmessage.Resource
+		for _, ns := range s.ns {
+			answers = append(answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeNS, Class: dnsmessage.ClassINET, TTL: s.ttl},
+				Body:   &dnsmessage.NSResource{NS: ns},
+			})
+			if s.nsGlue[strings.ToLower(ns.String())] {
+				glue = append(glue, s.addresses(ns, dnsmessage.TypeA)...)
+				glue = append(glue, s.addresses(ns, dnsmessage.TypeAAAA)...)
+			}
+		}
+		return answers, glue
+	case dnsmessage.TypeSOA:
+		if !apex {
+			return nil, nil
+		}
+		return []dnsmessage.Resource{s.soaRecord(q.Name, s.ttl)}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// addresses returns the A or AAAA records for name.
+func (s *Server) addresses(name dnsmessage.Name, typ dnsmessage.Type) []dnsmessage.Resource {
+	var records []dnsmessage.Resource
+	header := dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: s.ttl}
+	if typ == dnsmessage.TypeA {
+		for i := range s.a {
+			records = append(records, dnsmessage.Resource{Header: header, Body: &s.a[i]})
+		}
+	} else {
+		for i := range s.aaaa {
+			records = append(records, dnsmessage.Resource{Header: header, Body: &s.aaaa[i]})
+		}
+	}
+	return records
+}
+
+// txtValues returns the TXT values of name: challenge records added with
+// Present and the token of a delegated custom domain challenge.
+func (s *Server) txtValues(name string) []string {
+	s.mu.RLock()
+	values := append([]string(nil), s.txt[name]...)
+	s.mu.RUnlock()
+
+	if s.domains != nil {
+		if hostname, ok := strings.CutPrefix(strings.TrimSuffix(name, "."+s.zone), verificationLabel); ok {
+			if token, ok := s.domains.VerificationToken(hostname); ok {
+				values = append(values, token)
+			}
+		}
+	}
+	return values
+}
+
+// soaRecord returns the SOA record of the zone under name.
+func (s *Server) soaRecord(name dnsmessage.Name, ttl uint32) dnsmessage.Resource {
+	s.mu.RLock()
+	soa := s.soa
+	s.mu.RUnlock()
+
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &soa,
+	}
+}
+
+// build packs msg, adding an OPT record for EDNS clients. Responses larger
+// than size are replaced by a truncated one, with the question only, so the
+// client retries over TCP.
+func (s *Server) build(msg dnsmessage.Message, edns bool, size int) []byte {
+	var opt []dnsmessage.Resource
+	if edns {
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err == nil {
+			opt = []dnsmessage.Resource{{Header: h, Body: &dnsmessage.OPTResource{}}}
+		}
+	}
+	msg.Additionals = append(msg.Additionals, opt...)
+
+	packed, err := msg.AppendPack(make([]byte, 0, 512))
+	if err == nil && len(packed) <= size {
+		return packed
+	}
+	if err != nil {
+		logger.WarnEvent().Err(err).Msg("Failed to pack DNS response")
+		msg.Header.RCode = dnsmessage.RCodeServerFailure
+	} else {
+		msg.Header.Truncated = true
+	}
+
+	msg.Answers, msg.Authorities, msg.Additionals = nil, nil, opt
+	packed, err = msg.AppendPack(make([]byte, 0, 512))
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+// inZone reports whether the lowercase fully qualified name is in the zone.
+func (s *Server) inZone(name string) bool {
+	return name == s.zone || strings.HasSuffix(name, "."+s.zone)
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
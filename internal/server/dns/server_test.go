@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// staticTokens serves verification tokens from a map.
+type staticTokens map[string]string
+
+func (t staticTokens) VerificationToken(hostname string) (string, bool) {
+	token, ok := t[hostname]
+	return token, ok
+}
+
+// startServer starts a DNS server for grok.test on a random local port,
+// serving the verification tokens of domains (optional).
+func startServer(t *testing.T, domains ChallengeTokens) *Server {
+	t.Helper()
+
+	s, err := NewServer(Config{
+		Addr:        "127.0.0.1:0",
+		Domain:      "Grok.Test",
+		Addresses:   []string{"203.0.113.10", "2001:db8::10"},
+		Nameservers: []string{"ns1.grok.test", "ns.example.net"},
+	})
+	require.NoError(t, err)
+	if domains != nil {
+		s.SetCustomDomains(domains)
+	}
+	require.NoError(t, s.Start())
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+// resolver returns a resolver that asks s only, over network (udp or tcp).
+func resolver(s *Server, network string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.Addr())
+		},
+	}
+}
+
+// query sends a query for name and typ to the handler.
+func query(t *testing.T, s *Server, name string, typ dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}))
+	msg, err := b.Finish()
+	require.NoError(t, err)
+
+	var resp dnsmessage.Message
+	require.NoError(t, resp.Unpack(s.handle(msg, true)))
+	assert.Equal(t, uint16(42), resp.Header.ID)
+	return resp
+}
+
+// TestServer_Resolve tests lookups through a resolver over UDP and TCP.
+func TestServer_Resolve(t *testing.T) {
+	s := startServer(t, staticTokens{"demo.example.com": "verify-token"})
+	ctx := context.Background()
+
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			r := resolver(s, network)
+
+			// The domain and every name under it
+			for _, host := range []string{"grok.test", "myapp.grok.test", "a.b.GROK.test"} {
+				addrs, err := r.LookupHost(ctx, host)
+				require.NoError(t, err, host)
+				assert.ElementsMatch(t, []string{"203.0.113.10", "2001:db8::10"}, addrs, host)
+			}
+
+			nss, err := r.LookupNS(ctx, "grok.test")
+			require.NoError(t, err)
+			require.Len(t, nss, 2)
+			assert.Equal(t, "ns1.grok.test.", nss[0].Host)
+
+			// Delegated custom domain verification
+			txt, err := r.LookupTXT(ctx, "_grok-challenge.Demo.Example.com.grok.test")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"verify-token"}, txt)
+
+			_, err = r.LookupTXT(ctx, "_grok-challenge.other.example.com.grok.test")
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestServer_Challenges tests serving ACME challenge records.
+func TestServer_Challenges(t *testing.T) {
+	s := startServer(t, nil)
+	r := resolver(s, "udp")
+	ctx := context.Background()
+	name := "_acme-challenge.grok.test."
+
+	serial := query(t, s, "grok.test.", dnsmessage.TypeSOA).Answers[0].Body.(*dnsmessage.SOAResource).Serial
+
+	require.NoError(t, s.Present(ctx, name, "wildcard-token"))
+	require.NoError(t, s.Present(ctx, "_ACME-challenge.grok.test", "base-token"))
+	txt, err := r.LookupTXT(ctx, name)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"wildcard-token", "base-token"}, txt)
+
+	require.NoError(t, s.CleanUp(ctx, name, "wildcard-token"))
+	txt, err = r.LookupTXT(ctx, name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base-token"}, txt)
+
+	require.NoError(t, s.CleanUp(ctx, name, "base-token"))
+	_, err = r.LookupTXT(ctx, name)
+	assert.Error(t, err)
+
+	// Changes bump the serial
+	updated := query(t, s, "grok.test.", dnsmessage.TypeSOA).Answers[0].Body.(*dnsmessage.SOAResource).Serial
+	assert.Equal(t, serial+4, updated)
+
+	// Names outside the zone are not accepted
+	assert.Error(t, s.Present(ctx, "_acme-challenge.example.com.", "token"))
+}
+
+// TestServer_Answers tests the answer flags and sections.
+func TestServer_Answers(t *testing.T) {
+	s := startServer(t, nil)
+
+	t.Run("apex soa", func(t *testing.T) {
+		resp := query(t, s, "grok.test.", dnsmessage.TypeSOA)
+		assert.True(t, resp.Header.Authoritative)
+		require.Len(t, resp.Answers, 1)
+		soa := resp.Answers[0].Body.(*dnsmessage.SOAResource)
+		assert.Equal(t, "ns1.grok.test.", soa.NS.String())
+		assert.Equal(t, "hostmaster.grok.test.", soa.MBox.String())
+	})
+
+	t.Run("ns glue", func(t *testing.T) {
+		resp := query(t, s, "grok.test.", dnsmessage.TypeNS)
+		require.Len(t, resp.Answers, 2)
+		// Only the in-zone nameserver gets glue
+		require.Len(t, resp.Additionals, 2)
+		for _, glue := range resp.Additionals {
+			assert.Equal(t, "ns1.grok.test.", glue.Header.Name.String())
+		}
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		resp := query(t, s, "myapp.grok.test.", dnsmessage.TypeMX)
+		assert.Equal(t, dnsmessage.RCodeSuccess, resp.Header.RCode)
+		assert.True(t, resp.Header.Authoritative)
+		assert.Empty(t, resp.Answers)
+		require.Len(t, resp.Authorities, 1)
+		assert.Equal(t, dnsmessage.TypeSOA, resp.Authorities[0].Header.Type)
+	})
+
+	t.Run("case preserved", func(t *testing.T) {
+		resp := query(t, s, "MyApp.Grok.Test.", dnsmessage.TypeA)
+		require.Len(t, resp.Answers, 1)
+		assert.Equal(t, "MyApp.Grok.Test.", resp.Answers[0].Header.Name.String())
+	})
+
+	t.Run("outside zone", func(t *testing.T) {
+		resp := query(t, s, "example.com.", dnsmessage.TypeA)
+		assert.Equal(t, dnsmessage.RCodeRefused, resp.Header.RCode)
+		assert.False(t, resp.Header.Authoritative)
+		assert.Empty(t, resp.Answers)
+	})
+}
+
+// TestNewServer tests the server settings.
+func TestNewServer(t *testing.T) {
+	_, err := NewServer(Config{Domain: "grok.test"})
+	assert.Error(t, err, "addresses are required")
+
+	_, err = NewServer(Config{Domain: "grok.test", Addresses: []string{"not-an-ip"}})
+	assert.Error(t, err)
+
+	s, err := NewServer(Config{Domain: "grok.test", Addresses: []string{"203.0.113.10"}, Hostmaster: "admin@grok.test"})
+	require.NoError(t, err)
+	assert.Equal(t, "admin.grok.test.", s.soa.MBox.String())
+	assert.Equal(t, "ns1.grok.test.", s.soa.NS.String())
+	assert.Equal(t, uint32(defaultTTL), s.ttl)
+}
@@ -0,0 +1,48 @@
+package domains
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// labelRegex matches one DNS label: alphanumerics and inner hyphens.
+var labelRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// NormalizeHostname lowercases host and removes its port and trailing dot.
+func NormalizeHostname(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// ValidateHostname checks that hostname is a fully qualified DNS name that
+// can point at the server, e.g. demo.example.com. IP addresses, single-label
+// names and wildcards are rejected.
+func ValidateHostname(hostname string) error {
+	if hostname == "" || len(hostname) > 253 {
+		return fmt.Errorf("%w: hostname must be 1-253 characters", pkgerrors.ErrInvalidDomain)
+	}
+	if net.ParseIP(hostname) != nil {
+		return fmt.Errorf("%w: %s is an IP address", pkgerrors.ErrInvalidDomain, hostname)
+	}
+
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%w: %s is not a fully qualified name", pkgerrors.ErrInvalidDomain, hostname)
+	}
+	for _, label := range labels {
+		if !labelRegex.MatchString(label) {
+			return fmt.Errorf("%w: %q", pkgerrors.ErrInvalidDomain, hostname)
+		}
+	}
+	if labels[len(labels)-1] == "localhost" {
+		return fmt.Errorf("%w: %s is local", pkgerrors.ErrInvalidDomain, hostname)
+	}
+	return nil
+}
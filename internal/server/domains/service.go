@@ -0,0 +1,332 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"github.com/pandeptwidyaop/grok/pkg/utils"
+)
+
+// verifyTimeout bounds a single ownership check.
+const verifyTimeout = 10 * time.Second
+
+// TXTResolver looks up DNS TXT records.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Service manages custom domains: registration, ownership verification and
+// resolving verified hostnames to tunnel subdomains. Domains are kept in
+// memory so that routing and certificate checks don't hit the database.
+type Service struct {
+	db         *gorm.DB
+	baseDomain string
+	httpPort   int
+
+	resolver TXTResolver
+	client   *http.Client
+
+	mu     sync.RWMutex
+	byHost map[string]models.CustomDomain // hostname → domain, verified or not
+}
+
+// NewService creates a custom domain service. httpPort is the public HTTP
+// port the HTTP ownership check connects to.
+func NewService(db *gorm.DB, baseDomain string, httpPort int) *Service {
+	return &Service{
+		db:         db,
+		baseDomain: strings.ToLower(baseDomain),
+		httpPort:   httpPort,
+		resolver:   net.DefaultResolver,
+		client: &http.Client{
+			Timeout: verifyTimeout,
+			// The token must be served by this server, not wherever the hostname redirects to
+			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		byHost: make(map[string]models.CustomDomain),
+	}
+}
+
+// Load reads all custom domains from the database.
+func (s *Service) Load(ctx context.Context) error {
+	var domains []models.CustomDomain
+	if err := s.db.WithContext(ctx).Find(&domains).Error; err != nil {
+		return fmt.Errorf("failed to load custom domains: %w", err)
+	}
+
+	byHost := make(map[string]models.CustomDomain, len(domains))
+	for _, d := range domains {
+		byHost[d.Hostname] = d
+	}
+
+	s.mu.Lock()
+	s.byHost = byHost
+	s.mu.Unlock()
+
+	logger.InfoEvent().Int("count", len(domains)).Msg("Loaded custom domains")
+	return nil
+}
+
+// Register adds a pending custom domain. The hostname is normalized and a
+// verification token generated; the domain routes nowhere until verified.
+func (s *Service) Register(ctx context.Context, d *models.CustomDomain) error {
+	d.Hostname = NormalizeHostname(d.Hostname)
+	if err := s.validate(d); err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+	d.VerificationToken = token
+	d.VerifiedAt = nil
+
+	if _, taken := s.lookup(d.Hostname); taken {
+		return pkgerrors.ErrDomainTaken
+	}
+	if err := s.db.WithContext(ctx).Create(d).Error; err != nil {
+		return fmt.Errorf("failed to create custom domain: %w", err)
+	}
+
+	s.store(*d)
+	return nil
+}
+
+// Get returns the domain with id. The error wraps gorm.ErrRecordNotFound
+// for unknown IDs.
+func (s *Service) Get(ctx context.Context, id uuid.UUID) (*models.CustomDomain, error) {
+	var d models.CustomDomain
+	if err := s.db.WithContext(ctx).First(&d, "id = ?", id).Error; err != nil {
+		return nil, fmt.Errorf("failed to get custom domain: %w", err)
+	}
+	return &d, nil
+}
+
+// Update saves changes to the target subdomain or verification method of a domain.
+func (s *Service) Update(ctx context.Context, d *models.CustomDomain) error {
+	if err := s.validate(d); err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Model(d).Updates(map[string]interface{}{
+		"subdomain":           d.Subdomain,
+		"verification_method": d.VerificationMethod,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update custom domain: %w", err)
+	}
+
+	s.store(*d)
+	return nil
+}
+
+// Delete removes a custom domain; its hostname stops routing right away.
+func (s *Service) Delete(ctx context.Context, d *models.CustomDomain) error {
+	if err := s.db.WithContext(ctx).Delete(&models.CustomDomain{}, "id = ?", d.ID).Error; err != nil {
+		return fmt.Errorf("failed to delete custom domain: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.byHost, d.Hostname)
+	s.mu.Unlock()
+	return nil
+}
+
+// Verify checks ownership of d with its verification method and records the
+// result. It returns an error wrapping ErrDomainNotVerified when the check fails.
+func (s *Service) Verify(ctx context.Context, d *models.CustomDomain) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	var checkErr error
+	switch d.VerificationMethod {
+	case models.VerificationHTTP:
+		checkErr = s.checkHTTP(ctx, d)
+	default:
+		checkErr = s.checkDNS(ctx, d)
+	}
+
+	updates := map[string]interface{}{}
+	if checkErr != nil {
+		d.VerificationError = checkErr.Error()
+		updates["verification_error"] = d.VerificationError
+	} else {
+		now := time.Now()
+		d.VerifiedAt = &now
+		d.VerificationError = ""
+		updates["verified_at"] = now
+		updates["verification_error"] = ""
+	}
+
+	if err := s.db.WithContext(ctx).Model(d).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to save verification result: %w", err)
+	}
+	s.store(*d)
+
+	if checkErr != nil {
+		logger.InfoEvent().
+			Str("hostname", d.Hostname).
+			Str("method", d.VerificationMethod).
+			Str("reason", checkErr.Error()).
+			Msg("Custom domain verification failed")
+		return fmt.Errorf("%w: %v", pkgerrors.ErrDomainNotVerified, checkErr)
+	}
+
+	logger.InfoEvent().
+		Str("hostname", d.Hostname).
+		Str("subdomain", d.Subdomain).
+		Str("method", d.VerificationMethod).
+		Msg("Custom domain verified")
+	return nil
+}
+
+// Resolve returns the verified custom domain for a request host (port ignored).
+func (s *Service) Resolve(host string) (models.CustomDomain, bool) {
+	d, ok := s.lookup(NormalizeHostname(host))
+	if !ok || !d.IsVerified() {
+		return models.CustomDomain{}, false
+	}
+	return d, true
+}
+
+// VerificationToken returns the verification token of a registered domain,
+// for answering delegated DNS challenges.
+func (s *Service) VerificationToken(hostname string) (string, bool) {
+	d, ok := s.lookup(NormalizeHostname(hostname))
+	if !ok {
+		return "", false
+	}
+	return d.VerificationToken, true
+}
+
+// HostPolicy allows certificates for verified custom domains only. It has
+// the signature of autocert.HostPolicy.
+func (s *Service) HostPolicy(_ context.Context, host string) error {
+	if _, ok := s.Resolve(host); !ok {
+		return fmt.Errorf("%w: %s", pkgerrors.ErrDomainNotVerified, host)
+	}
+	return nil
+}
+
+// HTTPHandler answers HTTP ownership checks for pending domains and passes
+// all other requests to next.
+func (s *Service) HTTPHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, isChallenge := strings.CutPrefix(r.URL.Path, models.ChallengePathPrefix)
+		if !isChallenge {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Paths that are not a token of this host fall through to the tunnel
+		d, ok := s.lookup(NormalizeHostname(r.Host))
+		if !ok || d.VerificationMethod != models.VerificationHTTP || !utils.SecureCompareStrings(token, d.VerificationToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, d.VerificationToken)
+	})
+}
+
+// checkDNS looks for the verification token in the TXT records of the challenge name.
+func (s *Service) checkDNS(ctx context.Context, d *models.CustomDomain) error {
+	records, err := s.resolver.LookupTXT(ctx, d.ChallengeRecord())
+	if err != nil {
+		return fmt.Errorf("TXT lookup for %s failed: %w", d.ChallengeRecord(), err)
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == d.VerificationToken {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record %s with the verification token", d.ChallengeRecord())
+}
+
+// checkHTTP fetches the verification token through the hostname, which
+// proves that the hostname points at this server.
+func (s *Service) checkHTTP(ctx context.Context, d *models.CustomDomain) error {
+	host := d.Hostname
+	if s.httpPort != 0 && s.httpPort != 80 {
+		host = net.JoinHostPort(host, strconv.Itoa(s.httpPort))
+	}
+	url := "http://" + host + d.ChallengePath()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("fetching %s failed: %w", url, err)
+	}
+	if strings.TrimSpace(string(body)) != d.VerificationToken {
+		return fmt.Errorf("%s did not return the verification token", url)
+	}
+	return nil
+}
+
+// validate checks the hostname, target subdomain and verification method of d.
+func (s *Service) validate(d *models.CustomDomain) error {
+	if err := ValidateHostname(d.Hostname); err != nil {
+		return err
+	}
+	if d.Hostname == s.baseDomain || strings.HasSuffix(d.Hostname, "."+s.baseDomain) {
+		return fmt.Errorf("%w: %s is under the server domain", pkgerrors.ErrInvalidDomain, d.Hostname)
+	}
+
+	d.Subdomain = utils.NormalizeSubdomain(d.Subdomain)
+	if !utils.IsValidSubdomain(d.Subdomain) {
+		return fmt.Errorf("%w: %q", pkgerrors.ErrInvalidSubdomain, d.Subdomain)
+	}
+
+	switch d.VerificationMethod {
+	case "":
+		d.VerificationMethod = models.VerificationDNS
+	case models.VerificationDNS, models.VerificationHTTP:
+	default:
+		return fmt.Errorf("%w: unknown verification method %q (use dns or http)", pkgerrors.ErrInvalidDomain, d.VerificationMethod)
+	}
+	return nil
+}
+
+// lookup returns the domain registered for hostname, verified or not.
+func (s *Service) lookup(hostname string) (models.CustomDomain, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.byHost[hostname]
+	return d, ok
+}
+
+// store caches d.
+func (s *Service) store(d models.CustomDomain) {
+	s.mu.Lock()
+	s.byHost[d.Hostname] = d
+	s.mu.Unlock()
+}
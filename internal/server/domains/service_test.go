@@ -0,0 +1,206 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pandeptwidyaop/grok/internal/db"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// fakeResolver serves TXT records from a map.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// setupService creates a service for grok.io on an in-memory database.
+func setupService(t *testing.T) *Service {
+	t.Helper()
+
+	database, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(database))
+
+	return NewService(database, "grok.io", 80)
+}
+
+// register registers hostname for a new user.
+func register(t *testing.T, s *Service, hostname, method string) *models.CustomDomain {
+	t.Helper()
+
+	d := &models.CustomDomain{UserID: uuid.New(), Hostname: hostname, Subdomain: "myapp", VerificationMethod: method}
+	require.NoError(t, s.Register(context.Background(), d))
+	return d
+}
+
+// TestService_Register tests validating and registering custom domains.
+func TestService_Register(t *testing.T) {
+	s := setupService(t)
+
+	d := register(t, s, "Demo.Example.com.", "")
+	assert.Equal(t, "demo.example.com", d.Hostname)
+	assert.Equal(t, models.VerificationDNS, d.VerificationMethod)
+	assert.Len(t, d.VerificationToken, 32)
+	assert.False(t, d.IsVerified())
+
+	tests := []struct {
+		name     string
+		hostname string
+		sub      string
+		method   string
+		err      error
+	}{
+		{name: "taken", hostname: "demo.example.com", sub: "myapp", err: pkgerrors.ErrDomainTaken},
+		{name: "server domain", hostname: "grok.io", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "under server domain", hostname: "other.grok.io", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "single label", hostname: "intranet", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "ip address", hostname: "10.0.0.1", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "wildcard", hostname: "*.example.com", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "localhost", hostname: "app.localhost", sub: "myapp", err: pkgerrors.ErrInvalidDomain},
+		{name: "bad subdomain", hostname: "api.example.com", sub: "my.app", err: pkgerrors.ErrInvalidSubdomain},
+		{name: "bad method", hostname: "api.example.com", sub: "myapp", method: "email", err: pkgerrors.ErrInvalidDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Register(context.Background(), &models.CustomDomain{
+				UserID: uuid.New(), Hostname: tt.hostname, Subdomain: tt.sub, VerificationMethod: tt.method,
+			})
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+// TestService_VerifyDNS tests verification with a TXT record.
+func TestService_VerifyDNS(t *testing.T) {
+	s := setupService(t)
+	resolver := fakeResolver{}
+	s.resolver = resolver
+
+	d := register(t, s, "demo.example.com", models.VerificationDNS)
+	token, ok := s.VerificationToken("Demo.Example.com")
+	assert.True(t, ok)
+	assert.Equal(t, d.VerificationToken, token)
+
+	// No record yet: pending, nothing routes
+	err := s.Verify(context.Background(), d)
+	assert.ErrorIs(t, err, pkgerrors.ErrDomainNotVerified)
+	assert.NotEmpty(t, d.VerificationError)
+	_, ok = s.Resolve("demo.example.com")
+	assert.False(t, ok)
+	assert.Error(t, s.HostPolicy(context.Background(), "demo.example.com"))
+
+	// Wrong token
+	resolver["_grok-challenge.demo.example.com"] = []string{"something-else"}
+	assert.ErrorIs(t, s.Verify(context.Background(), d), pkgerrors.ErrDomainNotVerified)
+
+	resolver["_grok-challenge.demo.example.com"] = []string{"v=spf1 -all", d.VerificationToken}
+	require.NoError(t, s.Verify(context.Background(), d))
+	assert.True(t, d.IsVerified())
+	assert.Empty(t, d.VerificationError)
+
+	resolved, ok := s.Resolve("DEMO.example.com:443")
+	require.True(t, ok)
+	assert.Equal(t, "myapp", resolved.Subdomain)
+	assert.NoError(t, s.HostPolicy(context.Background(), "demo.example.com"))
+	assert.Error(t, s.HostPolicy(context.Background(), "other.example.com"))
+
+	// The result survives a restart
+	restarted := NewService(s.db, "grok.io", 80)
+	require.NoError(t, restarted.Load(context.Background()))
+	_, ok = restarted.Resolve("demo.example.com")
+	assert.True(t, ok)
+
+	// Deleted domains stop routing
+	require.NoError(t, s.Delete(context.Background(), d))
+	_, ok = s.Resolve("demo.example.com")
+	assert.False(t, ok)
+}
+
+// TestService_VerifyHTTP tests verification with the token served by the
+// server's own HTTP handler.
+func TestService_VerifyHTTP(t *testing.T) {
+	s := setupService(t)
+
+	tunnelHandler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	server := httptest.NewServer(s.HTTPHandler(tunnelHandler))
+	defer server.Close()
+
+	// Every hostname resolves to the test server
+	s.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+
+	d := register(t, s, "demo.example.com", models.VerificationHTTP)
+	other := register(t, s, "other.example.com", models.VerificationHTTP)
+
+	t.Run("challenge handler", func(t *testing.T) {
+		get := func(host, path string) *http.Response {
+			req := httptest.NewRequest("GET", "http://"+host+path, nil)
+			rec := httptest.NewRecorder()
+			s.HTTPHandler(tunnelHandler).ServeHTTP(rec, req)
+			return rec.Result()
+		}
+
+		assert.Equal(t, http.StatusOK, get("demo.example.com", d.ChallengePath()).StatusCode)
+		// Tokens of other domains and other paths go to the tunnel
+		assert.Equal(t, http.StatusTeapot, get("demo.example.com", other.ChallengePath()).StatusCode)
+		assert.Equal(t, http.StatusTeapot, get("demo.example.com", "/").StatusCode)
+	})
+
+	require.NoError(t, s.Verify(context.Background(), d))
+	_, ok := s.Resolve("demo.example.com")
+	assert.True(t, ok)
+
+	// A hostname that doesn't reach this server fails
+	s.client.Transport = &http.Transport{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+	assert.ErrorIs(t, s.Verify(context.Background(), other), pkgerrors.ErrDomainNotVerified)
+	_, ok = s.Resolve("other.example.com")
+	assert.False(t, ok)
+}
+
+// TestService_Update tests retargeting a verified domain.
+func TestService_Update(t *testing.T) {
+	s := setupService(t)
+	s.resolver = fakeResolver{}
+
+	d := register(t, s, "demo.example.com", "")
+	now := d.CreatedAt
+	d.VerifiedAt = &now
+	require.NoError(t, s.db.Model(d).Update("verified_at", now).Error)
+	require.NoError(t, s.Load(context.Background()))
+
+	d.Subdomain = "other"
+	require.NoError(t, s.Update(context.Background(), d))
+	resolved, ok := s.Resolve("demo.example.com")
+	require.True(t, ok)
+	assert.Equal(t, "other", resolved.Subdomain)
+
+	d.Subdomain = "-bad-"
+	assert.ErrorIs(t, s.Update(context.Background(), d), pkgerrors.ErrInvalidSubdomain)
+}
@@ -0,0 +1,338 @@
+// Package edgecache caches GET responses of HTTP tunnels at the edge, as
+// allowed by their Cache-Control, ETag and Vary headers. Responses are kept
+// in memory and, when a directory is configured, moved to disk when memory
+// is full. Both tiers are bounded and evict the least recently used entries.
+package edgecache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// Cache statuses, recorded in the request log.
+const (
+	StatusHit         = "HIT"         // Served from the cache
+	StatusMiss        = "MISS"        // Fetched from the tunnel
+	StatusRevalidated = "REVALIDATED" // Stale entry confirmed by the tunnel with a 304
+	StatusBypass      = "BYPASS"      // Request the cache does not answer (not a plain GET)
+)
+
+// DefaultMaxObjectSize is the largest response cached when Config.MaxObjectSize is unset.
+const DefaultMaxObjectSize = 8 << 20 // 8MB
+
+// fileSuffix marks the files of the cache in Config.Dir.
+const fileSuffix = ".cache"
+
+// Config holds the bounds of a cache.
+type Config struct {
+	MaxMemory     int64  // Bytes of responses kept in memory
+	MaxObjectSize int64  // Larger responses are not cached (default: DefaultMaxObjectSize)
+	Dir           string // Directory for responses evicted from memory; empty keeps them in memory only
+	MaxDisk       int64  // Bytes of responses kept in Dir
+}
+
+// Cache stores responses per tunnel. A nil *Cache stores nothing.
+type Cache struct {
+	cfg Config
+
+	mu          sync.Mutex
+	items       map[string]*item    // By variant key
+	primaries   map[string]*primary // By primary key
+	memory      *list.List          // Items in memory, most recently used first
+	disk        *list.List          // Items on disk, most recently used first
+	memoryBytes int64
+	diskBytes   int64
+}
+
+// primary tracks the variants of a URL.
+type primary struct {
+	vary     []string // Vary header names of the last response stored
+	variants int
+}
+
+type item struct {
+	key     string
+	primary string
+	tunnel  uuid.UUID
+	path    string // URL path, for purges by prefix
+	entry   *Entry // Body is nil while the item is on disk
+	size    int64
+	onDisk  bool
+	elem    *list.Element
+}
+
+// New creates a cache. Files left in cfg.Dir by a previous run are removed.
+func New(cfg Config) (*Cache, error) {
+	if cfg.MaxObjectSize <= 0 {
+		cfg.MaxObjectSize = DefaultMaxObjectSize
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		stale, err := filepath.Glob(filepath.Join(cfg.Dir, "*"+fileSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cache directory: %w", err)
+		}
+		for _, file := range stale {
+			_ = os.Remove(file)
+		}
+	} else {
+		cfg.MaxDisk = 0
+	}
+
+	return &Cache{
+		cfg:       cfg,
+		items:     make(map[string]*item),
+		primaries: make(map[string]*primary),
+		memory:    list.New(),
+		disk:      list.New(),
+	}, nil
+}
+
+// MaxObjectSize returns the size of the largest body the cache stores.
+func (c *Cache) MaxObjectSize() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.cfg.MaxObjectSize
+}
+
+// Lookup returns the entry stored for r on tunnelID, fresh or not, or nil.
+func (c *Cache) Lookup(tunnelID uuid.UUID, r *http.Request) *Entry {
+	if c == nil {
+		return nil
+	}
+	primaryKey := primaryKey(tunnelID, r)
+
+	c.mu.Lock()
+	p, ok := c.primaries[primaryKey]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	it, ok := c.items[variantKey(primaryKey, p.vary, r.Header)]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	if !it.onDisk {
+		if it.elem != nil {
+			c.memory.MoveToFront(it.elem)
+		}
+		entry := it.entry
+		c.mu.Unlock()
+		return entry
+	}
+	c.disk.MoveToFront(it.elem)
+	entry, file := *it.entry, c.file(it.key)
+	c.mu.Unlock()
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) { // Evicted meanwhile otherwise
+			logger.WarnEvent().Err(err).Str("file", file).Msg("Failed to read cached response")
+		}
+		c.mu.Lock()
+		if c.items[it.key] == it {
+			c.remove(it)
+		}
+		c.mu.Unlock()
+		return nil
+	}
+	entry.Body = body
+	return &entry
+}
+
+// Store saves the response to r on tunnelID, replacing the entry of the same
+// variant. Entries larger than MaxObjectSize are not stored.
+func (c *Cache) Store(tunnelID uuid.UUID, r *http.Request, entry *Entry) {
+	if c == nil {
+		return
+	}
+	size := entry.size()
+	if size > c.cfg.MaxObjectSize || size > max(c.cfg.MaxMemory, c.cfg.MaxDisk) {
+		return
+	}
+	primaryKey := primaryKey(tunnelID, r)
+	vary := varyNames(entry.Header)
+
+	c.mu.Lock()
+	key := variantKey(primaryKey, vary, r.Header)
+	if old, ok := c.items[key]; ok {
+		c.remove(old)
+	}
+	p, ok := c.primaries[primaryKey]
+	if !ok {
+		p = &primary{}
+		c.primaries[primaryKey] = p
+	}
+	p.vary = vary // Variants of an older Vary are no longer found and age out
+
+	it := &item{
+		key:     key,
+		primary: primaryKey,
+		tunnel:  tunnelID,
+		path:    r.URL.Path,
+		entry:   entry,
+		size:    size,
+	}
+	it.elem = c.memory.PushFront(it)
+	c.items[key] = it
+	p.variants++
+	c.memoryBytes += size
+
+	evicted := c.evictMemory()
+	c.mu.Unlock()
+
+	for _, it := range evicted {
+		c.moveToDisk(it)
+	}
+}
+
+// Purge removes the entries of tunnelID whose URL path starts with
+// pathPrefix (all of them when empty) and returns how many were removed.
+func (c *Cache) Purge(tunnelID uuid.UUID, pathPrefix string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for _, it := range c.items {
+		if it.tunnel == tunnelID && strings.HasPrefix(it.path, pathPrefix) {
+			c.remove(it)
+			purged++
+		}
+	}
+	return purged
+}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// evictMemory takes least recently used items out of memory until it fits
+// MaxMemory. Items that fit on disk are returned to be written there; the
+// others are dropped. The caller holds c.mu.
+func (c *Cache) evictMemory() []*item {
+	var toDisk []*item
+	for c.memoryBytes > c.cfg.MaxMemory {
+		it := c.memory.Back().Value.(*item)
+		c.memory.Remove(it.elem)
+		c.memoryBytes -= it.size
+		if it.size <= c.cfg.MaxDisk {
+			it.elem = nil // In transit: in items, but in no list
+			toDisk = append(toDisk, it)
+		} else {
+			c.forget(it)
+		}
+	}
+	return toDisk
+}
+
+// moveToDisk writes the body of an item evicted from memory to disk.
+func (c *Cache) moveToDisk(it *item) {
+	file := c.file(it.key)
+	err := os.WriteFile(file, it.entry.Body, 0o600)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items[it.key] != it {
+		// Replaced or purged while the file was written
+		if err == nil {
+			_ = os.Remove(file)
+		}
+		return
+	}
+	if err != nil {
+		logger.WarnEvent().Err(err).Str("file", file).Msg("Failed to write cached response")
+		c.forget(it)
+		return
+	}
+
+	entry := *it.entry
+	entry.Body = nil
+	it.entry = &entry
+	it.onDisk = true
+	it.elem = c.disk.PushFront(it)
+	c.diskBytes += it.size
+
+	for c.diskBytes > c.cfg.MaxDisk {
+		c.remove(c.disk.Back().Value.(*item))
+	}
+}
+
+// remove deletes an item from the cache. The caller holds c.mu.
+func (c *Cache) remove(it *item) {
+	switch {
+	case it.elem == nil:
+		// Being written to disk: moveToDisk drops it
+	case it.onDisk:
+		c.disk.Remove(it.elem)
+		c.diskBytes -= it.size
+		_ = os.Remove(c.file(it.key))
+	default:
+		c.memory.Remove(it.elem)
+		c.memoryBytes -= it.size
+	}
+	c.forget(it)
+}
+
+// forget deletes an item that is in no list from the indexes. The caller holds c.mu.
+func (c *Cache) forget(it *item) {
+	delete(c.items, it.key)
+	if p, ok := c.primaries[it.primary]; ok {
+		if p.variants--; p.variants <= 0 {
+			delete(c.primaries, it.primary)
+		}
+	}
+}
+
+// file returns the path of the body of an item on disk.
+func (c *Cache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.cfg.Dir, hex.EncodeToString(sum[:])+fileSuffix)
+}
+
+// primaryKey identifies the URL of r on a tunnel.
+func primaryKey(tunnelID uuid.UUID, r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return tunnelID.String() + " " + scheme + "://" + strings.ToLower(r.Host) + r.URL.RequestURI()
+}
+
+// variantKey identifies the response to r among those stored for its URL,
+// by the values of the request headers listed in Vary.
+func variantKey(primaryKey string, vary []string, header http.Header) string {
+	var b strings.Builder
+	b.WriteString(primaryKey)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package edgecache
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEntry(t *testing.T, body string, pairs ...string) *Entry {
+	t.Helper()
+	entry, ok := NewEntry(200, header(append([]string{"Cache-Control", "max-age=60"}, pairs...)...), time.Now())
+	require.True(t, ok)
+	entry.Body = []byte(body)
+	return entry
+}
+
+// TestCache_Nil tests that a nil cache stores nothing.
+func TestCache_Nil(t *testing.T) {
+	var c *Cache
+	r := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+	c.Store(uuid.New(), r, newTestEntry(t, "x"))
+	assert.Nil(t, c.Lookup(uuid.New(), r))
+	assert.Zero(t, c.Purge(uuid.New(), ""))
+	assert.Zero(t, c.MaxObjectSize())
+}
+
+// TestCache_LookupAndVary tests that entries are found per tunnel, URL and
+// the request headers listed in Vary.
+func TestCache_LookupAndVary(t *testing.T) {
+	c, err := New(Config{MaxMemory: 1 << 20})
+	require.NoError(t, err)
+	tunnelID := uuid.New()
+
+	english := httptest.NewRequest("GET", "http://app.grok.io/page?x=1", nil)
+	english.Header.Set("Accept-Language", "en")
+	c.Store(tunnelID, english, newTestEntry(t, "hello", "Vary", "accept-language"))
+
+	found := c.Lookup(tunnelID, english)
+	require.NotNil(t, found)
+	assert.Equal(t, "hello", string(found.Body))
+
+	german := httptest.NewRequest("GET", "http://app.grok.io/page?x=1", nil)
+	german.Header.Set("Accept-Language", "de")
+	assert.Nil(t, c.Lookup(tunnelID, german), "other variant")
+	c.Store(tunnelID, german, newTestEntry(t, "hallo", "Vary", "Accept-Language"))
+	assert.Equal(t, "hallo", string(c.Lookup(tunnelID, german).Body))
+	assert.Equal(t, "hello", string(c.Lookup(tunnelID, english).Body))
+
+	assert.Nil(t, c.Lookup(uuid.New(), english), "other tunnel")
+	assert.Nil(t, c.Lookup(tunnelID, httptest.NewRequest("GET", "http://app.grok.io/page?x=2", nil)), "other query")
+
+	// Replacing a variant keeps one entry
+	c.Store(tunnelID, german, newTestEntry(t, "guten tag", "Vary", "Accept-Language"))
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, "guten tag", string(c.Lookup(tunnelID, german).Body))
+}
+
+// TestCache_Bounds tests that memory is bounded and the least recently used
+// entries are evicted, and that large objects are not stored.
+func TestCache_Bounds(t *testing.T) {
+	c, err := New(Config{MaxMemory: 3000, MaxObjectSize: 1500})
+	require.NoError(t, err)
+	tunnelID := uuid.New()
+	body := string(bytes.Repeat([]byte("a"), 900))
+	request := func(path string) *http.Request {
+		return httptest.NewRequest("GET", "http://app.grok.io"+path, nil)
+	}
+
+	c.Store(tunnelID, request("/big"), newTestEntry(t, string(bytes.Repeat([]byte("b"), 2000))))
+	assert.Zero(t, c.Len(), "larger than max object size")
+
+	c.Store(tunnelID, request("/1"), newTestEntry(t, body))
+	c.Store(tunnelID, request("/2"), newTestEntry(t, body))
+	c.Store(tunnelID, request("/3"), newTestEntry(t, body))
+	require.NotNil(t, c.Lookup(tunnelID, request("/1"))) // /2 is now least recently used
+	c.Store(tunnelID, request("/4"), newTestEntry(t, body))
+
+	assert.Equal(t, 3, c.Len())
+	assert.Nil(t, c.Lookup(tunnelID, request("/2")))
+	assert.NotNil(t, c.Lookup(tunnelID, request("/1")))
+	assert.NotNil(t, c.Lookup(tunnelID, request("/4")))
+}
+
+// TestCache_Disk tests that entries evicted from memory move to disk and are
+// still served from there.
+func TestCache_Disk(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "old"+fileSuffix)
+	require.NoError(t, os.WriteFile(stale, []byte("x"), 0o600))
+
+	c, err := New(Config{MaxMemory: 1000, Dir: dir, MaxDisk: 2000})
+	require.NoError(t, err)
+	assert.NoFileExists(t, stale, "left over from a previous run")
+
+	tunnelID := uuid.New()
+	body := string(bytes.Repeat([]byte("a"), 900))
+	request := func(path string) *http.Request {
+		return httptest.NewRequest("GET", "http://app.grok.io"+path, nil)
+	}
+
+	c.Store(tunnelID, request("/1"), newTestEntry(t, body+"1"))
+	c.Store(tunnelID, request("/2"), newTestEntry(t, body+"2"))
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	assert.Len(t, files, 1)
+
+	found := c.Lookup(tunnelID, request("/1"))
+	require.NotNil(t, found, "served from disk")
+	assert.Equal(t, body+"1", string(found.Body))
+
+	// The disk is bounded too: /1 is dropped for /2 and /3
+	c.Store(tunnelID, request("/3"), newTestEntry(t, body+"3"))
+	c.Store(tunnelID, request("/4"), newTestEntry(t, body+"4"))
+	assert.Equal(t, 3, c.Len())
+	assert.Nil(t, c.Lookup(tunnelID, request("/1")))
+	assert.Equal(t, body+"2", string(c.Lookup(tunnelID, request("/2")).Body))
+
+	assert.Equal(t, 3, c.Purge(tunnelID, ""))
+	files, _ = filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	assert.Empty(t, files)
+}
+
+// TestCache_Purge tests purges by tunnel and path prefix.
+func TestCache_Purge(t *testing.T) {
+	c, err := New(Config{MaxMemory: 1 << 20})
+	require.NoError(t, err)
+	tunnelID, otherID := uuid.New(), uuid.New()
+	for _, path := range []string{"/assets/app.js", "/assets/app.css", "/index.html"} {
+		c.Store(tunnelID, httptest.NewRequest("GET", "http://app.grok.io"+path, nil), newTestEntry(t, path))
+	}
+	c.Store(otherID, httptest.NewRequest("GET", "http://other.grok.io/assets/app.js", nil), newTestEntry(t, "other"))
+
+	assert.Equal(t, 2, c.Purge(tunnelID, "/assets/"))
+	assert.Equal(t, 2, c.Len())
+	assert.NotNil(t, c.Lookup(tunnelID, httptest.NewRequest("GET", "http://app.grok.io/index.html", nil)))
+	assert.Equal(t, 1, c.Purge(tunnelID, ""))
+	assert.Equal(t, 1, c.Len(), "other tunnels keep their entries")
+}
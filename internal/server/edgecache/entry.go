@@ -0,0 +1,117 @@
+package edgecache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	StatusCode int
+	Header     http.Header // As received from the tunnel, without Age
+	Body       []byte      // Shared between readers: never modified
+	Date       time.Time   // When the response was generated, for its Age
+	Expires    time.Time   // Served without revalidation until then
+}
+
+// NewEntry returns the entry for a response received from a tunnel at now,
+// or false when the response may not be stored. The caller sets Body once
+// the response is complete.
+func NewEntry(statusCode int, header http.Header, now time.Time) (*Entry, bool) {
+	ttl, ok := Freshness(statusCode, header, now)
+	if !ok {
+		return nil, false
+	}
+	return newEntry(statusCode, header, nil, now, ttl), true
+}
+
+func newEntry(statusCode int, header http.Header, body []byte, now time.Time, ttl time.Duration) *Entry {
+	header = header.Clone()
+	date := now.Add(-age(header))
+	header.Del("Age")
+	return &Entry{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       body,
+		Date:       date,
+		Expires:    now.Add(ttl),
+	}
+}
+
+// Fresh reports whether e may be served without asking the tunnel.
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// Validate adds the validators of e to r, so that the tunnel answers with a
+// 304 if e is still current. It returns false and leaves r unchanged when e
+// has no validators or r has conditions of its own.
+func (e *Entry) Validate(r *http.Request) bool {
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+		return false
+	}
+	etag, modified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && modified == "" {
+		return false
+	}
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if modified != "" {
+		r.Header.Set("If-Modified-Since", modified)
+	}
+	return true
+}
+
+// Revalidated returns e updated with the headers of the 304 response that
+// confirmed it at now, and whether the updated entry may be stored.
+func (e *Entry) Revalidated(header http.Header, now time.Time) (*Entry, bool) {
+	merged := e.Header.Clone()
+	for name, values := range header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Type", "Transfer-Encoding":
+			// Describe the 304 itself, not the stored body
+		default:
+			merged[name] = values
+		}
+	}
+	ttl, ok := Freshness(e.StatusCode, merged, now)
+	return newEntry(e.StatusCode, merged, e.Body, now, ttl), ok
+}
+
+// NotModified reports whether the conditions of r are satisfied by e, so that
+// r is answered with a 304.
+func (e *Entry) NotModified(r *http.Request) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagsMatch(ifNoneMatch, e.Header.Get("ETag"))
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// ResponseHeader returns the headers of e served at now, with its Age.
+func (e *Entry) ResponseHeader(now time.Time) http.Header {
+	header := e.Header.Clone()
+	elapsed := max(0, now.Sub(e.Date))
+	header.Set("Age", strconv.FormatInt(int64(elapsed/time.Second), 10))
+	return header
+}
+
+// size is the memory an entry is accounted for.
+func (e *Entry) size() int64 {
+	size := int64(len(e.Body))
+	for name, values := range e.Header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
@@ -0,0 +1,169 @@
+package edgecache
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheableStatus are the status codes stored when the response allows it.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// cacheControl holds the directives of Cache-Control headers, by lowercase name.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds argument of a directive.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, true // Invalid: treat as stale
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// RequestCacheable reports whether r may be answered from the cache and its
+// response stored. Only plain GET requests are; requests with credentials or
+// ranges always go to the tunnel.
+func RequestCacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	return !parseCacheControl(r.Header).has("no-store")
+}
+
+// RequestRevalidates reports whether r asks for a response confirmed by the
+// tunnel (no-cache, max-age=0 or Pragma: no-cache) rather than a cached one.
+func RequestRevalidates(r *http.Request) bool {
+	cc := parseCacheControl(r.Header)
+	if cc.has("no-cache") {
+		return true
+	}
+	if maxAge, ok := cc.seconds("max-age"); ok && maxAge == 0 {
+		return true
+	}
+	return len(cc) == 0 && strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache")
+}
+
+// Freshness returns how long a response may be served from the cache, and
+// whether it may be stored at all. Responses need explicit freshness
+// (s-maxage, max-age or Expires); no-cache and expired responses are stored
+// only with a validator (ETag or Last-Modified) and revalidated before use.
+func Freshness(statusCode int, header http.Header, now time.Time) (time.Duration, bool) {
+	if !cacheableStatus[statusCode] {
+		return 0, false
+	}
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") {
+		return 0, false
+	}
+	if header.Get("Set-Cookie") != "" || slices.Contains(varyNames(header), "*") {
+		return 0, false
+	}
+	validated := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	if cc.has("no-cache") {
+		return 0, validated
+	}
+
+	var ttl time.Duration
+	if sMaxAge, ok := cc.seconds("s-maxage"); ok {
+		ttl = sMaxAge
+	} else if maxAge, ok := cc.seconds("max-age"); ok {
+		ttl = maxAge
+	} else if expires := header.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, validated // Invalid dates mean already expired
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		ttl = at.Sub(date)
+	} else {
+		return 0, false
+	}
+
+	ttl -= age(header)
+	if ttl <= 0 {
+		return 0, validated
+	}
+	return ttl, true
+}
+
+// age returns the Age of a response received from the tunnel.
+func age(header http.Header) time.Duration {
+	seconds, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// varyNames returns the canonical request header names listed in Vary, sorted.
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// etagsMatch reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires for GET.
+func etagsMatch(ifNoneMatch, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
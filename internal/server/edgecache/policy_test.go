@@ -0,0 +1,114 @@
+package edgecache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func header(pairs ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+// TestFreshness tests which responses are stored and for how long.
+func TestFreshness(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		status   int
+		header   http.Header
+		ttl      time.Duration
+		storable bool
+	}{
+		{"max-age", 200, header("Cache-Control", "public, max-age=60"), time.Minute, true},
+		{"s-maxage wins", 200, header("Cache-Control", "max-age=60, s-maxage=600"), 10 * time.Minute, true},
+		{"age counts", 200, header("Cache-Control", "max-age=60", "Age", "20"), 40 * time.Second, true},
+		{"expires", 200, header("Expires", now.Add(time.Hour).Format(http.TimeFormat), "Date", now.Format(http.TimeFormat)), time.Hour, true},
+		{"invalid expires", 200, header("Expires", "0"), 0, false},
+		{"no freshness", 200, header("Content-Type", "text/html"), 0, false},
+		{"no-store", 200, header("Cache-Control", "no-store, max-age=60"), 0, false},
+		{"private", 200, header("Cache-Control", "private, max-age=60"), 0, false},
+		{"set-cookie", 200, header("Cache-Control", "max-age=60", "Set-Cookie", "a=1"), 0, false},
+		{"vary star", 200, header("Cache-Control", "max-age=60", "Vary", "*"), 0, false},
+		{"uncacheable status", 500, header("Cache-Control", "max-age=60"), 0, false},
+		{"not found", 404, header("Cache-Control", "max-age=60"), time.Minute, true},
+		{"no-cache with etag", 200, header("Cache-Control", "no-cache", "ETag", `"v1"`), 0, true},
+		{"no-cache without validator", 200, header("Cache-Control", "no-cache"), 0, false},
+		{"expired with validator", 200, header("Cache-Control", "max-age=10", "Age", "30", "Last-Modified", now.Format(http.TimeFormat)), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, storable := Freshness(tt.status, tt.header, now)
+			assert.Equal(t, tt.storable, storable)
+			assert.Equal(t, tt.ttl, ttl)
+		})
+	}
+}
+
+// TestRequestCacheable tests which requests the cache answers.
+func TestRequestCacheable(t *testing.T) {
+	get := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+	assert.True(t, RequestCacheable(get))
+	assert.False(t, RequestRevalidates(get))
+
+	post := httptest.NewRequest("POST", "http://app.grok.io/", nil)
+	assert.False(t, RequestCacheable(post))
+
+	for name, value := range map[string]string{"Authorization": "Bearer x", "Range": "bytes=0-10", "Cache-Control": "no-store"} {
+		r := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+		r.Header.Set(name, value)
+		assert.False(t, RequestCacheable(r), name)
+	}
+
+	for _, pair := range [][2]string{{"Cache-Control", "no-cache"}, {"Cache-Control", "max-age=0"}, {"Pragma", "no-cache"}} {
+		r := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+		r.Header.Set(pair[0], pair[1])
+		assert.True(t, RequestRevalidates(r), pair[1])
+	}
+}
+
+// TestEntry tests conditional requests and revalidation of entries.
+func TestEntry(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	modified := now.Add(-time.Hour).Format(http.TimeFormat)
+	entry, ok := NewEntry(200, header("Cache-Control", "max-age=60", "ETag", `"v1"`, "Last-Modified", modified, "Age", "10"), now)
+	require.True(t, ok)
+	assert.True(t, entry.Fresh(now.Add(49*time.Second)))
+	assert.False(t, entry.Fresh(now.Add(50*time.Second)))
+	assert.Equal(t, "15", entry.ResponseHeader(now.Add(5*time.Second)).Get("Age"))
+	assert.Empty(t, entry.Header.Get("Age"))
+
+	// Visitors' conditions, compared weakly
+	r := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+	r.Header.Set("If-None-Match", `W/"v1"`)
+	assert.True(t, entry.NotModified(r))
+	r.Header.Set("If-None-Match", `"v0", "v2"`)
+	assert.False(t, entry.NotModified(r))
+	r.Header.Del("If-None-Match")
+	r.Header.Set("If-Modified-Since", modified)
+	assert.True(t, entry.NotModified(r))
+
+	// Validators are only added when the visitor has no conditions of its own
+	assert.False(t, entry.Validate(r))
+	r = httptest.NewRequest("GET", "http://app.grok.io/", nil)
+	require.True(t, entry.Validate(r))
+	assert.Equal(t, `"v1"`, r.Header.Get("If-None-Match"))
+	assert.Equal(t, modified, r.Header.Get("If-Modified-Since"))
+
+	entry.Body = []byte("hello")
+	later := now.Add(time.Minute)
+	updated, storable := entry.Revalidated(header("Cache-Control", "max-age=120", "Content-Length", "0"), later)
+	assert.True(t, storable)
+	assert.True(t, updated.Fresh(later.Add(119*time.Second)))
+	assert.Equal(t, []byte("hello"), updated.Body)
+	assert.Equal(t, `"v1"`, updated.Header.Get("ETag"))
+	assert.Empty(t, updated.Header.Get("Content-Length"))
+}
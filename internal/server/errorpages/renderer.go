@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"html/template"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 )
@@ -20,8 +22,9 @@ var (
 
 // ErrorPageData holds dynamic data for error templates.
 type ErrorPageData struct {
-	Subdomain string // For 404 errors
+	Subdomain string // For 404 and 503 errors
 	URL       string // For 400 errors
+	Message   string // Replaces the generic message of other status codes
 }
 
 // initTemplates compiles all templates on first use.
@@ -105,6 +108,12 @@ func RenderErrorPage(w http.ResponseWriter, r *http.Request, statusCode int, dat
 			}
 		default:
 			message = http.StatusText(statusCode)
+			if data.Message != "" {
+				message = data.Message
+			}
+			if data.Subdomain != "" {
+				details = "Subdomain: " + data.Subdomain
+			}
 		}
 		renderJSON(w, statusCode, message, details)
 		return
@@ -122,7 +131,11 @@ func RenderErrorPage(w http.ResponseWriter, r *http.Request, statusCode int, dat
 		templateName = "400.html"
 	default:
 		// Fallback to plain text for unmapped errors
-		http.Error(w, http.StatusText(statusCode), statusCode)
+		message := http.StatusText(statusCode)
+		if data.Message != "" {
+			message = data.Message
+		}
+		http.Error(w, message, statusCode)
 		return
 	}
 
@@ -168,6 +181,20 @@ func TunnelNotFound(w http.ResponseWriter, r *http.Request, subdomain string) {
 	})
 }
 
+// TunnelReconnecting renders 503 for a persistent tunnel whose client did not
+// reconnect in time, asking the visitor to retry after retryAfter.
+func TunnelReconnecting(w http.ResponseWriter, r *http.Request, subdomain string, retryAfter time.Duration) {
+	seconds := int(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	RenderErrorPage(w, r, http.StatusServiceUnavailable, &ErrorPageData{
+		Subdomain: subdomain,
+		Message:   "Tunnel is reconnecting",
+	})
+}
+
 // InvalidWebhookURL renders 400 error for invalid webhook URLs.
 // Always returns JSON since webhooks are API-to-API communication.
 func InvalidWebhookURL(w http.ResponseWriter, url string) {
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestRenderErrorPage_404_HTML(t *testing.T) {
@@ -195,6 +196,53 @@ func TestTunnelNotFound_JSON(t *testing.T) {
 	}
 }
 
+func TestRenderErrorPage_503_Generic(t *testing.T) {
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	RenderErrorPage(w, req, http.StatusServiceUnavailable, nil)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	// Only the reconnect path says the tunnel is reconnecting
+	if response["error"] != "Service Unavailable" {
+		t.Errorf("Expected generic message, got %v", response["error"])
+	}
+}
+
+func TestTunnelReconnecting_RetryAfter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	TunnelReconnecting(w, req, "my-app", 10*time.Second)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+
+	if got := w.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("Expected Retry-After 10, got %q", got)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse JSON response: %v", err)
+	}
+
+	if response["error"] != "Tunnel is reconnecting" {
+		t.Errorf("Expected reconnecting message, got %v", response["error"])
+	}
+
+	if !strings.Contains(response["details"].(string), "my-app") {
+		t.Error("Expected details to contain subdomain")
+	}
+}
+
 func TestInvalidWebhookURL_AlwaysJSON(t *testing.T) {
 	w := httptest.NewRecorder()
 
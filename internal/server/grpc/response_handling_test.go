@@ -1,6 +1,7 @@
 package grpc
 
 import (
+	"context"
 	"sync"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 )
 
@@ -345,3 +347,62 @@ func TestHandleProxyResponse_ConcurrentWrites(t *testing.T) {
 		t.Fatalf("Timeout waiting for consumer (received %d/%d)", receivedCount, totalChunks)
 	}
 }
+
+// TestHandleProxyResponse_OverflowAbortsRequest tests that a body frame sent without
+// credit ends its request instead of blocking the stream or being dropped.
+func TestHandleProxyResponse_OverflowAbortsRequest(t *testing.T) {
+	tun := &tunnel.Tunnel{
+		ID:       uuid.New(),
+		Features: protocol.Features{tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL: {}},
+	}
+
+	requestID := "test-request-overflow"
+	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
+	responseCh <- &tunnelv1.ProxyResponse{RequestId: requestID}
+	tun.ResponseMap.Store(requestID, responseCh)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tun.Aborts.Store(requestID, cancel)
+
+	service := &TunnelService{}
+	done := make(chan struct{})
+	go func() {
+		service.handleProxyResponse(tun, &tunnelv1.ProxyResponse{
+			RequestId: requestID,
+			Payload:   &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte("late")}},
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleProxyResponse blocked on a full channel")
+	}
+
+	assert.Error(t, ctx.Err(), "request should be aborted")
+	_, ok := tun.ResponseMap.Load(requestID)
+	assert.False(t, ok, "response channel should be unregistered")
+	assert.Len(t, responseCh, 1)
+}
+
+// TestHandleProxyResponse_BodyWindowUpdate tests that window updates from the
+// client release credit to the upload of a request body.
+func TestHandleProxyResponse_BodyWindowUpdate(t *testing.T) {
+	tun := &tunnel.Tunnel{ID: uuid.New()}
+	window := protocol.NewWindow(0)
+	tun.SendWindows.Store("test-request-upload", window)
+
+	service := &TunnelService{}
+	service.handleProxyResponse(tun, &tunnelv1.ProxyResponse{
+		RequestId: "test-request-upload",
+		Payload:   &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{WindowUpdate: 3}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := window.Acquire(ctx, protocol.BodyWindow)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
@@ -0,0 +1,84 @@
+package grpc
+
+import (
+	"sync"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// lockedStream serializes sends on a ProxyStream shared by several tunnels.
+// Each tunnel has its own StreamMu, so tunnels on the same stream need a common lock.
+type lockedStream struct {
+	tunnelv1.TunnelService_ProxyStreamServer
+	mu sync.Mutex
+}
+
+// Send sends a typed message under the stream lock.
+func (s *lockedStream) Send(msg *tunnelv1.ProxyMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TunnelService_ProxyStreamServer.Send(msg)
+}
+
+// SendMsg sends a message under the stream lock.
+func (s *lockedStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TunnelService_ProxyStreamServer.SendMsg(m)
+}
+
+// streamSession tracks the tunnels registered on one ProxyStream.
+// It is only accessed from the ProxyStream receive loop.
+type streamSession struct {
+	tunnels map[string]*tunnel.Tunnel // tunnel ID → tunnel
+	primary *tunnel.Tunnel            // First registered tunnel
+}
+
+// newStreamSession creates an empty session.
+func newStreamSession() *streamSession {
+	return &streamSession{tunnels: make(map[string]*tunnel.Tunnel)}
+}
+
+// add registers a tunnel on the session.
+func (ss *streamSession) add(tun *tunnel.Tunnel) {
+	ss.tunnels[tun.ID.String()] = tun
+	if ss.primary == nil {
+		ss.primary = tun
+	}
+}
+
+// remove drops a tunnel from the session and returns it, or nil if unknown.
+func (ss *streamSession) remove(tunnelID string) *tunnel.Tunnel {
+	tun, ok := ss.tunnels[tunnelID]
+	if !ok {
+		return nil
+	}
+
+	delete(ss.tunnels, tunnelID)
+	if ss.primary == tun {
+		ss.primary = nil
+		for _, other := range ss.tunnels {
+			ss.primary = other
+			break
+		}
+	}
+	return tun
+}
+
+// route returns the tunnel a client message belongs to. Clients that predate
+// multiplexing leave tunnel_id empty, so unknown IDs fall back to the primary tunnel.
+func (ss *streamSession) route(tunnelID string) *tunnel.Tunnel {
+	if tun, ok := ss.tunnels[tunnelID]; ok {
+		return tun
+	}
+	if len(ss.tunnels) == 1 {
+		return ss.primary
+	}
+	return nil
+}
+
+// empty reports whether no tunnel is registered.
+func (ss *streamSession) empty() bool {
+	return len(ss.tunnels) == 0
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// TestStreamSession_Route tests routing of client messages to tunnels on a stream.
+func TestStreamSession_Route(t *testing.T) {
+	session := newStreamSession()
+	assert.True(t, session.empty())
+
+	web := &tunnel.Tunnel{ID: uuid.New()}
+	session.add(web)
+
+	// Legacy clients leave tunnel_id empty on a single-tunnel stream
+	assert.Same(t, web, session.route(""))
+	assert.Same(t, web, session.route(web.ID.String()))
+
+	api := &tunnel.Tunnel{ID: uuid.New()}
+	session.add(api)
+
+	assert.Same(t, api, session.route(api.ID.String()))
+	assert.Nil(t, session.route(""))
+	assert.Nil(t, session.route(uuid.New().String()))
+
+	assert.Same(t, web, session.remove(web.ID.String()))
+	assert.Nil(t, session.remove(web.ID.String()))
+	assert.Same(t, api, session.primary)
+	assert.Same(t, api, session.route(""))
+
+	session.remove(api.ID.String())
+	assert.True(t, session.empty())
+	assert.Nil(t, session.primary)
+}
@@ -2,8 +2,10 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,13 +13,21 @@ import (
 	"google.golang.org/grpc/status"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
 )
 
+// responseSendTimeout is how long the receive loop waits for the consumer of a
+// frame sent without credit before giving up on its request.
+const responseSendTimeout = 30 * time.Second
+
 // TunnelService implements the gRPC TunnelService.
 type TunnelService struct {
 	tunnelv1.UnimplementedTunnelServiceServer
@@ -37,7 +47,17 @@ func NewTunnelService(
 }
 
 // allocateSubdomainForTunnel allocates subdomain for new tunnel, checking for offline tunnel reuse.
-func (s *TunnelService) allocateSubdomainForTunnel(ctx context.Context, userID uuid.UUID, orgID *uuid.UUID, requestedSubdomain string) (fullSubdomain, customPart string, err error) {
+func (s *TunnelService) allocateSubdomainForTunnel(ctx context.Context, userID uuid.UUID, orgID *uuid.UUID, requestedSubdomain string, balancing tunnelv1.LoadBalancing) (fullSubdomain, customPart string, err error) {
+	if requestedSubdomain != "" && balancing != tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED {
+		if group, ok := s.tunnelManager.FindGroup(userID, requestedSubdomain); ok {
+			logger.InfoEvent().
+				Str("saved_name", requestedSubdomain).
+				Str("subdomain", group.Subdomain).
+				Msg("Found tunnel group, will join its subdomain")
+			return group.Subdomain, requestedSubdomain, nil
+		}
+	}
+
 	if requestedSubdomain != "" {
 		offlineTunnel, err := s.tunnelManager.FindOfflineTunnelBySavedName(ctx, userID, requestedSubdomain)
 		if err == nil && offlineTunnel != nil {
@@ -62,6 +82,10 @@ func (s *TunnelService) determineProtocol(reqProtocol tunnelv1.TunnelProtocol) s
 		return "https"
 	case tunnelv1.TunnelProtocol_TCP:
 		return "tcp"
+	case tunnelv1.TunnelProtocol_TLS:
+		return "tls"
+	case tunnelv1.TunnelProtocol_UDP:
+		return "udp"
 	default:
 		if s.tunnelManager.IsTLSEnabled() {
 			return "https"
@@ -70,11 +94,25 @@ func (s *TunnelService) determineProtocol(reqProtocol tunnelv1.TunnelProtocol) s
 	}
 }
 
+// checkProtocolSupport rejects tunnel types this server cannot serve.
+func (s *TunnelService) checkProtocolSupport(reqProtocol tunnelv1.TunnelProtocol) error {
+	// TLS tunnels are routed by SNI on the HTTPS port, which only listens with TLS enabled
+	if reqProtocol == tunnelv1.TunnelProtocol_TLS && !s.tunnelManager.IsTLSEnabled() {
+		return status.Error(codes.InvalidArgument, "TLS tunnels are not available: the server has no HTTPS listener")
+	}
+	return nil
+}
+
 // CreateTunnel handles tunnel creation requests.
 func (s *TunnelService) CreateTunnel(
 	ctx context.Context,
 	req *tunnelv1.CreateTunnelRequest,
 ) (*tunnelv1.CreateTunnelResponse, error) {
+	if _, err := protocol.Negotiate(req.Capabilities); err != nil {
+		logger.WarnEvent().Err(err).Msg("Incompatible client in CreateTunnel")
+		return nil, status.Error(codes.FailedPrecondition, err.Error()+"; please upgrade grok")
+	}
+
 	authToken, err := s.tokenService.ValidateToken(ctx, req.AuthToken)
 	if err != nil {
 		logger.WarnEvent().Err(err).Msg("Invalid token in CreateTunnel")
@@ -95,7 +133,23 @@ func (s *TunnelService) CreateTunnel(
 		return nil, status.Error(codes.InvalidArgument, "local_address is required")
 	}
 
-	fullSubdomain, customPart, err := s.allocateSubdomainForTunnel(ctx, authToken.UserID, user.OrganizationID, req.Subdomain)
+	if req.GetOptions().GetLoadBalancing() != tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED && !tunnel.ServesHTTP(req.Protocol) {
+		return nil, status.Error(codes.InvalidArgument, "tunnel groups are only supported for HTTP tunnels")
+	}
+
+	if err := s.checkProtocolSupport(req.Protocol); err != nil {
+		return nil, err
+	}
+
+	edge, err := edgePolicy(req.GetOptions(), req.Protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkEdgeSupport(edge); err != nil {
+		return nil, err
+	}
+
+	fullSubdomain, customPart, err := s.allocateSubdomainForTunnel(ctx, authToken.UserID, user.OrganizationID, req.Subdomain, req.GetOptions().GetLoadBalancing())
 	if err != nil {
 		logger.ErrorEvent().
 			Err(err).
@@ -112,8 +166,8 @@ func (s *TunnelService) CreateTunnel(
 		return nil, status.Error(codes.Internal, "failed to allocate subdomain")
 	}
 
-	protocol := s.determineProtocol(req.Protocol)
-	publicURL := s.tunnelManager.BuildPublicURL(fullSubdomain, protocol)
+	protocolName := s.determineProtocol(req.Protocol)
+	publicURL := s.tunnelManager.BuildPublicURL(fullSubdomain, protocolName)
 
 	orgID := "none"
 	if user.OrganizationID != nil {
@@ -129,24 +183,185 @@ func (s *TunnelService) CreateTunnel(
 		Msg("Tunnel created successfully")
 
 	return &tunnelv1.CreateTunnelResponse{
-		TunnelId:  "",
-		PublicUrl: publicURL,
-		Subdomain: fullSubdomain,
-		Status:    tunnelv1.TunnelStatus_ACTIVE,
+		TunnelId:     "",
+		PublicUrl:    publicURL,
+		Subdomain:    fullSubdomain,
+		Status:       tunnelv1.TunnelStatus_ACTIVE,
+		Capabilities: protocol.Local(),
 	}, nil
 }
 
-// ProxyStream handles bidirectional streaming for tunnel proxying.
 // registrationData holds parsed tunnel registration information.
 type registrationData struct {
-	subdomain string
-	authToken string
-	localAddr string
-	publicURL string
-	savedName string
+	subdomain    string
+	authToken    string
+	localAddr    string
+	publicURL    string
+	savedName    string
+	protocol     tunnelv1.TunnelProtocol
+	webhookAppID *uuid.UUID
+	labels       map[string]string
+	balancing    tunnelv1.LoadBalancing // Tunnel group strategy (unspecified: not grouped)
+	edge         tunnel.EdgePolicy      // Access policy, IP rules and header rules
+	ref          string                 // Client reference echoed in the Registered reply
+	features     protocol.Features      // Features negotiated with the client
+	legacy       bool                   // Registered with the deprecated pipe-delimited control message
+}
+
+// registrationFromMessage converts a typed RegisterTunnel message into registration data.
+func registrationFromMessage(msg *tunnelv1.RegisterTunnel) (*registrationData, error) {
+	if msg.AuthToken == "" {
+		return nil, status.Error(codes.Unauthenticated, "auth_token is required")
+	}
+	if msg.LocalAddress == "" {
+		return nil, status.Error(codes.InvalidArgument, "local_address is required")
+	}
+
+	features, err := protocol.Negotiate(msg.Capabilities)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error()+"; please upgrade grok")
+	}
+
+	reg := &registrationData{
+		subdomain: msg.Subdomain,
+		authToken: msg.AuthToken,
+		localAddr: msg.LocalAddress,
+		publicURL: msg.PublicUrl,
+		savedName: msg.SavedName,
+		protocol:  msg.Protocol,
+		labels:    msg.Labels,
+		balancing: msg.GetOptions().GetLoadBalancing(),
+		ref:       msg.Ref,
+		features:  features,
+	}
+
+	if reg.protocol == tunnelv1.TunnelProtocol_TUNNEL_PROTOCOL_UNSPECIFIED {
+		reg.protocol = determineProtocolFromURL(msg.PublicUrl)
+	}
+
+	if reg.balancing != tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED && !tunnel.ServesHTTP(reg.protocol) {
+		return nil, status.Error(codes.InvalidArgument, "tunnel groups are only supported for HTTP tunnels")
+	}
+
+	reg.edge, err = edgePolicy(msg.GetOptions(), reg.protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.WebhookAppId != "" {
+		appID, err := uuid.Parse(msg.WebhookAppId)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid webhook_app_id")
+		}
+		reg.webhookAppID = &appID
+	}
+
+	return reg, nil
+}
+
+// edgePolicy parses the access policy, IP rules and header rules of the tunnel options.
+func edgePolicy(options *tunnelv1.TunnelOptions, reqProtocol tunnelv1.TunnelProtocol) (tunnel.EdgePolicy, error) {
+	policy, err := accessPolicy(options, reqProtocol)
+	if err != nil {
+		return tunnel.EdgePolicy{}, err
+	}
+	rules, err := ipfilter.RulesFromProto(options.GetIpRules())
+	if err != nil {
+		return tunnel.EdgePolicy{}, status.Error(codes.InvalidArgument, "invalid IP rules: "+err.Error())
+	}
+	if rules != nil && !tunnel.ServesHTTP(reqProtocol) && reqProtocol != tunnelv1.TunnelProtocol_TCP {
+		return tunnel.EdgePolicy{}, status.Error(codes.InvalidArgument, "IP rules are only supported for HTTP and TCP tunnels")
+	}
+	headers, err := headerrules.RulesFromProto(options.GetHeaderRules())
+	if err != nil {
+		return tunnel.EdgePolicy{}, status.Error(codes.InvalidArgument, "invalid header rules: "+err.Error())
+	}
+	if headers != nil && !tunnel.ServesHTTP(reqProtocol) {
+		return tunnel.EdgePolicy{}, status.Error(codes.InvalidArgument, "header rules are only supported for HTTP tunnels")
+	}
+	edge := tunnel.EdgeOptions{Compress: options.GetEdge().GetCompress(), Cache: options.GetEdge().GetCache()}
+	if edge != (tunnel.EdgeOptions{}) && !tunnel.ServesHTTP(reqProtocol) {
+		return tunnel.EdgePolicy{}, status.Error(codes.InvalidArgument, "edge compression and caching are only supported for HTTP tunnels")
+	}
+	return tunnel.EdgePolicy{Access: policy, IPRules: rules, Headers: headers, Edge: edge}, nil
+}
+
+// accessPolicy parses the access policy of the tunnel options.
+func accessPolicy(options *tunnelv1.TunnelOptions, reqProtocol tunnelv1.TunnelProtocol) (*access.Policy, error) {
+	policy, err := access.PolicyFromProto(options.GetAccess())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid access policy: "+err.Error())
+	}
+	if policy != nil && !tunnel.ServesHTTP(reqProtocol) {
+		return nil, status.Error(codes.InvalidArgument, "access policies are only supported for HTTP tunnels")
+	}
+	return policy, nil
+}
+
+// checkAccessSupport rejects access policies this server cannot enforce.
+func (s *TunnelService) checkAccessSupport(policy *access.Policy) error {
+	if policy == nil {
+		return nil
+	}
+	if s.tunnelManager.AccessGuard() == nil {
+		return status.Error(codes.InvalidArgument, "access policies are not enabled on this server")
+	}
+	if policy.OIDC != nil && !s.tunnelManager.AccessGuard().OIDCEnabled() {
+		return status.Error(codes.InvalidArgument, "OIDC login is not configured on this server")
+	}
+	return nil
+}
+
+// checkEdgeSupport rejects edge policies this server cannot enforce.
+func (s *TunnelService) checkEdgeSupport(edge tunnel.EdgePolicy) error {
+	if err := s.checkAccessSupport(edge.Access); err != nil {
+		return err
+	}
+	if edge.IPRules.UsesCountries() && !s.tunnelManager.IPFilter().GeoEnabled() {
+		return status.Error(codes.InvalidArgument, "country rules need a GeoIP database on this server")
+	}
+	if edge.Edge.Cache && s.tunnelManager.EdgeCache() == nil {
+		return status.Error(codes.InvalidArgument, "edge caching is not enabled on this server")
+	}
+	return nil
+}
+
+// rejectionCode maps a registration error to the ErrorCode sent in a Rejected reply.
+func rejectionCode(err error) tunnelv1.ErrorCode {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return tunnelv1.ErrorCode_UNAUTHORIZED
+	case codes.AlreadyExists:
+		return tunnelv1.ErrorCode_SUBDOMAIN_TAKEN
+	case codes.InvalidArgument:
+		return tunnelv1.ErrorCode_INVALID_ARGUMENT
+	case codes.ResourceExhausted:
+		return tunnelv1.ErrorCode_RATE_LIMITED
+	case codes.FailedPrecondition:
+		return tunnelv1.ErrorCode_INCOMPATIBLE_CLIENT
+	default:
+		return tunnelv1.ErrorCode_INTERNAL
+	}
+}
+
+// rejectRegistration tells the client why its RegisterTunnel message was refused.
+func rejectRegistration(stream tunnelv1.TunnelService_ProxyStreamServer, ref string, err error) {
+	msg := &tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Rejected{
+			Rejected: &tunnelv1.Rejected{
+				Code:    rejectionCode(err),
+				Message: status.Convert(err).Message(),
+				Ref:     ref,
+			},
+		},
+	}
+	if sendErr := stream.Send(msg); sendErr != nil {
+		logger.WarnEvent().Err(sendErr).Msg("Failed to send registration rejection")
+	}
 }
 
 // parseRegistrationData parses pipe-delimited registration data.
+// Deprecated: kept for clients that predate the RegisterTunnel message.
 func parseRegistrationData(data string) (*registrationData, error) {
 	parts := []string{}
 	lastIdx := 0
@@ -167,6 +382,9 @@ func parseRegistrationData(data string) (*registrationData, error) {
 		authToken: parts[1],
 		localAddr: parts[2],
 		publicURL: parts[3],
+		protocol:  determineProtocolFromURL(parts[3]),
+		features:  protocol.Features{},
+		legacy:    true,
 	}
 
 	if len(parts) == 5 && parts[4] != "" {
@@ -185,6 +403,12 @@ func determineProtocolFromURL(publicURL string) tunnelv1.TunnelProtocol {
 		if len(publicURL) >= 6 && publicURL[:6] == "tcp://" {
 			return tunnelv1.TunnelProtocol_TCP
 		}
+		if len(publicURL) >= 6 && publicURL[:6] == "tls://" {
+			return tunnelv1.TunnelProtocol_TLS
+		}
+		if len(publicURL) >= 6 && publicURL[:6] == "udp://" {
+			return tunnelv1.TunnelProtocol_UDP
+		}
 	}
 	return tunnelv1.TunnelProtocol_HTTP
 }
@@ -195,6 +419,13 @@ func (s *TunnelService) handleTunnelRegistration(
 	stream tunnelv1.TunnelService_ProxyStreamServer,
 	reg *registrationData,
 ) (*tunnel.Tunnel, error) {
+	if err := s.checkProtocolSupport(reg.protocol); err != nil {
+		return nil, err
+	}
+	if err := s.checkEdgeSupport(reg.edge); err != nil {
+		return nil, err
+	}
+
 	// Validate token
 	token, err := s.tokenService.ValidateToken(ctx, reg.authToken)
 	if err != nil {
@@ -209,8 +440,21 @@ func (s *TunnelService) handleTunnelRegistration(
 		return nil, status.Error(codes.Internal, "failed to load user")
 	}
 
-	// Determine protocol from public URL
-	protocol := determineProtocolFromURL(reg.publicURL)
+	// Webhook apps are served only by tunnels of the organization they belong to
+	if reg.webhookAppID != nil {
+		owned, err := s.tunnelManager.WebhookAppInOrganization(ctx, *reg.webhookAppID, user.OrganizationID)
+		if err != nil {
+			logger.ErrorEvent().Err(err).Msg("Failed to check webhook app in ProxyStream")
+			return nil, status.Error(codes.Internal, "failed to check webhook app")
+		}
+		if !owned {
+			logger.WarnEvent().
+				Str("user_id", token.UserID.String()).
+				Str("webhook_app_id", reg.webhookAppID.String()).
+				Msg("Webhook app of another organization requested in ProxyStream")
+			return nil, status.Error(codes.PermissionDenied, "webhook app not found in your organization")
+		}
+	}
 
 	// Auto-generate tunnel name if not provided
 	savedName := reg.savedName
@@ -240,11 +484,32 @@ func (s *TunnelService) handleTunnelRegistration(
 			Str("new_local_addr", reg.localAddr).
 			Msg("Reactivating existing tunnel")
 
-		tun, err = s.tunnelManager.ReactivateTunnel(ctx, offlineTunnel, stream, reg.localAddr)
+		tun, err = s.tunnelManager.ReactivateTunnel(ctx, offlineTunnel, stream, reg.localAddr, reg.edge)
 		if err != nil {
 			logger.ErrorEvent().Err(err).Msg("Failed to reactivate tunnel")
 			return nil, status.Error(codes.Internal, "failed to reactivate tunnel")
 		}
+	} else if group, ok := s.tunnelManager.FindGroup(token.UserID, savedName); ok && reg.balancing != tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED {
+		// Join an active tunnel group; the saved name stays with the group's persistent tunnel
+		tun = tunnel.NewTunnel(
+			token.UserID,
+			token.ID,
+			user.OrganizationID,
+			group.Subdomain,
+			reg.protocol,
+			reg.localAddr,
+			s.tunnelManager.BuildPublicURL(group.Subdomain, s.determineProtocol(reg.protocol)),
+			stream,
+		)
+		tun.EdgePolicy = reg.edge
+
+		if err := s.tunnelManager.RegisterTunnel(ctx, tun); err != nil {
+			logger.ErrorEvent().
+				Err(err).
+				Str("subdomain", group.Subdomain).
+				Msg("Failed to register group member tunnel")
+			return nil, status.Error(codes.Internal, "failed to register tunnel")
+		}
 	} else {
 		// Create new persistent tunnel
 		tun = tunnel.NewTunnel(
@@ -252,12 +517,13 @@ func (s *TunnelService) handleTunnelRegistration(
 			token.ID,
 			user.OrganizationID,
 			reg.subdomain,
-			protocol,
+			reg.protocol,
 			reg.localAddr,
 			reg.publicURL,
 			stream,
 		)
 		tun.SavedName = &savedName
+		tun.EdgePolicy = reg.edge
 
 		if err := s.tunnelManager.RegisterTunnel(ctx, tun); err != nil {
 			logger.ErrorEvent().
@@ -274,31 +540,90 @@ func (s *TunnelService) handleTunnelRegistration(
 			Msg("New persistent tunnel created")
 	}
 
+	if reg.balancing != tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED {
+		s.tunnelManager.JoinGroup(tun, savedName, reg.balancing)
+	}
+
+	// Apply client-declared metadata
+	tun.Features = reg.features
+	tun.Labels = reg.labels
+	if reg.webhookAppID != nil {
+		tun.WebhookAppID = reg.webhookAppID
+		tun.IsWebhook = true
+	}
+
+	if reg.edge.Access != nil {
+		logger.InfoEvent().
+			Str("tunnel_id", tun.ID.String()).
+			Strs("methods", reg.edge.Access.Methods()).
+			Msg("Tunnel access policy applied")
+	}
+	if rules := reg.edge.IPRules; rules != nil {
+		logger.InfoEvent().
+			Str("tunnel_id", tun.ID.String()).
+			Int("allow", len(rules.Allow)+len(rules.AllowCountries)).
+			Int("deny", len(rules.Deny)+len(rules.DenyCountries)).
+			Msg("Tunnel IP rules applied")
+	}
+	if headers := reg.edge.Headers; headers != nil {
+		logger.InfoEvent().
+			Str("tunnel_id", tun.ID.String()).
+			Int("request", len(headers.Request)).
+			Int("response", len(headers.Response)).
+			Msg("Tunnel header rules applied")
+	}
+	if opts := reg.edge.Edge; opts != (tunnel.EdgeOptions{}) {
+		logger.InfoEvent().
+			Str("tunnel_id", tun.ID.String()).
+			Bool("compress", opts.Compress).
+			Bool("cache", opts.Cache).
+			Msg("Tunnel edge options applied")
+	}
+
 	logger.InfoEvent().
 		Str("tunnel_id", tun.ID.String()).
 		Str("subdomain", reg.subdomain).
 		Str("public_url", tun.PublicURL).
 		Msg("Tunnel registered successfully")
 
-	// Send updated public URL to client
-	updateMsg := &tunnelv1.ProxyMessage{
-		Message: &tunnelv1.ProxyMessage_Control{
-			Control: &tunnelv1.ControlMessage{
-				Type:     tunnelv1.ControlMessage_UNKNOWN,
-				TunnelId: tun.ID.String(),
-				Metadata: map[string]string{
-					"public_url": tun.PublicURL,
+	// Send registration result to client
+	var replyMsg *tunnelv1.ProxyMessage
+	if reg.legacy {
+		replyMsg = &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Control{
+				Control: &tunnelv1.ControlMessage{
+					Type:     tunnelv1.ControlMessage_UNKNOWN,
+					TunnelId: tun.ID.String(),
+					Metadata: map[string]string{
+						"public_url": tun.PublicURL,
+					},
 				},
 			},
-		},
+		}
+	} else {
+		replyMsg = &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Registered{
+				Registered: &tunnelv1.Registered{
+					TunnelId:     tun.ID.String(),
+					PublicUrl:    tun.PublicURL,
+					Subdomain:    tun.Subdomain,
+					SavedName:    savedName,
+					Capabilities: protocol.Local(),
+					Ref:          reg.ref,
+				},
+			},
+		}
 	}
-	if err := stream.Send(updateMsg); err != nil {
+	if err := stream.Send(replyMsg); err != nil {
 		logger.ErrorEvent().
 			Err(err).
 			Str("tunnel_id", tun.ID.String()).
-			Msg("Failed to send public URL update to client")
+			Msg("Failed to send registration result to client")
 	}
 
+	// Replay requests held while the client was reconnecting, now that it knows the tunnel
+	s.tunnelManager.ResumeTunnel(tun)
+
 	return tun, nil
 }
 
@@ -320,6 +645,18 @@ func (s *TunnelService) handleProxyResponse(currentTunnel *tunnel.Tunnel, respon
 
 	// Check if this is WebSocket TCP data (client -> server)
 	if tcpData := response.GetTcp(); tcpData != nil {
+		// Window updates return send credit to the reader of the public connection
+		if tcpData.WindowUpdate > 0 {
+			if w, ok := currentTunnel.SendWindows.Load(response.RequestId); ok {
+				if window, ok := w.(*protocol.Window); ok {
+					window.Release(int(tcpData.WindowUpdate))
+				}
+			}
+			if len(tcpData.Data) == 0 {
+				return
+			}
+		}
+
 		// This is WebSocket data, route to WebSocket channel
 		wsKey := response.RequestId + ":ws"
 		ch, ok := currentTunnel.ResponseMap.Load(wsKey)
@@ -341,6 +678,32 @@ func (s *TunnelService) handleProxyResponse(currentTunnel *tunnel.Tunnel, respon
 		}
 	}
 
+	// Window updates return send credit to the upload of a streamed request body
+	if body := response.GetBody(); body.GetWindowUpdate() > 0 {
+		if w, ok := currentTunnel.SendWindows.Load(response.RequestId); ok {
+			if window, ok := w.(*protocol.Window); ok {
+				window.Release(int(body.WindowUpdate))
+			}
+		}
+		return
+	}
+
+	// UDP replies are dropped rather than stalling the stream when a flow is backed up
+	if response.GetUdp() != nil {
+		if ch, ok := currentTunnel.ResponseMap.Load(response.RequestId); ok {
+			if respChan, ok := ch.(chan *tunnelv1.ProxyResponse); ok {
+				select {
+				case respChan <- response:
+				default:
+					logger.DebugEvent().
+						Str("request_id", response.RequestId).
+						Msg("UDP flow backed up, dropping reply datagram")
+				}
+			}
+		}
+		return
+	}
+
 	// Regular HTTP response handling
 	ch, ok := currentTunnel.ResponseMap.Load(response.RequestId)
 	if !ok {
@@ -358,34 +721,64 @@ func (s *TunnelService) handleProxyResponse(currentTunnel *tunnel.Tunnel, respon
 		return
 	}
 
-	// Blocking send with timeout (safe because handleProxyResponse runs in goroutine)
-	// This ensures chunks are NEVER dropped, supporting unlimited file sizes
-	sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// A frame that cannot be delivered ends its request instead of leaving a hole in the body
+	if !deliverResponse(currentTunnel, respChan, response) {
+		logger.ErrorEvent().
+			Str("request_id", response.RequestId).
+			Msg("Response consumer stuck, aborting request")
+		currentTunnel.Abort(response.RequestId)
+	}
+}
 
+// deliverResponse hands a frame to the goroutine serving its request. Body frames sent
+// with credit (FEATURE_BODY_FLOW_CONTROL) always fit in its channel, so they never wait;
+// other frames wait up to responseSendTimeout for a slow consumer, holding up the stream.
+func deliverResponse(tun *tunnel.Tunnel, respChan chan<- *tunnelv1.ProxyResponse, response *tunnelv1.ProxyResponse) bool {
 	select {
 	case respChan <- response:
-		// Response delivered successfully
-	case <-sendCtx.Done():
-		// Timeout - HTTP proxy consumer stuck or too slow
-		logger.ErrorEvent().
-			Str("request_id", response.RequestId).
-			Msg("Timeout sending response - HTTP proxy consumer stuck")
+		return true
+	default:
+	}
+
+	if response.GetBody() != nil && tun.Features.Has(tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL) {
+		return false // The client sent more frames than it had credit for
+	}
+
+	timer := time.NewTimer(responseSendTimeout)
+	defer timer.Stop()
+
+	select {
+	case respChan <- response:
+		return true
+	case <-timer.C:
+		return false
 	}
 }
 
-func (s *TunnelService) ProxyStream(stream tunnelv1.TunnelService_ProxyStreamServer) error {
-	ctx := stream.Context()
+// ProxyStream handles bidirectional streaming for tunnel proxying.
+func (s *TunnelService) ProxyStream(rawStream tunnelv1.TunnelService_ProxyStreamServer) error {
+	// Request processors of the stream's tunnels stop before the stream ends
+	var processors sync.WaitGroup
+	defer processors.Wait()
+	ctx, cancel := context.WithCancel(rawStream.Context())
+	defer cancel()
+	stream := &lockedStream{TunnelService_ProxyStreamServer: rawStream}
 
 	logger.InfoEvent().Msg("ProxyStream connection established")
 
-	var currentTunnel *tunnel.Tunnel
+	session := newStreamSession()
+	cleanupAll := func(reason string) {
+		for id, tun := range session.tunnels {
+			s.cleanupTunnel(tun, reason)
+			session.remove(id)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			logger.InfoEvent().Msg("ProxyStream context done")
-			s.cleanupTunnel(currentTunnel, "context done")
+			cleanupAll("context done")
 			return nil
 		default:
 		}
@@ -393,31 +786,86 @@ func (s *TunnelService) ProxyStream(stream tunnelv1.TunnelService_ProxyStreamSer
 		msg, err := stream.Recv()
 		if err == io.EOF {
 			logger.InfoEvent().Msg("Client closed stream")
-			s.cleanupTunnel(currentTunnel, "stream close")
+			cleanupAll("stream close")
 			return nil
 		}
 		if err != nil {
 			logger.ErrorEvent().Err(err).Msg("Error receiving message from client")
-			s.cleanupTunnel(currentTunnel, "stream error")
+			cleanupAll("stream error")
 			return status.Error(codes.Internal, "stream error")
 		}
 
 		switch payload := msg.Message.(type) {
+		case *tunnelv1.ProxyMessage_Register:
+			// Several tunnels may register on one stream (multiplexing)
+			reg, err := registrationFromMessage(payload.Register)
+			var tun *tunnel.Tunnel
+			if err == nil {
+				tun, err = s.handleTunnelRegistration(ctx, stream, reg)
+			}
+			if err != nil {
+				logger.WarnEvent().Err(err).Msg("Tunnel registration rejected")
+				rejectRegistration(stream, payload.Register.Ref, err)
+				// Other registrations may be in flight; only a stream that never authenticated is closed
+				if session.empty() && status.Code(err) == codes.Unauthenticated {
+					return err
+				}
+				continue
+			}
+
+			session.add(tun)
+			processors.Add(1)
+			go func() {
+				defer processors.Done()
+				s.processRequests(ctx, tun)
+			}()
+
+		case *tunnelv1.ProxyMessage_Unregister:
+			tun := session.remove(payload.Unregister.TunnelId)
+			if tun == nil {
+				logger.WarnEvent().
+					Str("tunnel_id", payload.Unregister.TunnelId).
+					Msg("Unregister for unknown tunnel")
+				continue
+			}
+
+			// Closed on purpose: visitors are not held for a reconnect
+			if err := s.tunnelManager.CloseTunnel(context.Background(), tun.ID); err != nil {
+				logger.WarnEvent().Err(err).Str("reason", "unregistered").Msg("Failed to unregister tunnel")
+			}
+			closedMsg := &tunnelv1.ProxyMessage{
+				Message: &tunnelv1.ProxyMessage_Control{
+					Control: &tunnelv1.ControlMessage{
+						Type:     tunnelv1.ControlMessage_TUNNEL_CLOSED,
+						TunnelId: tun.ID.String(),
+					},
+				},
+			}
+			if err := stream.Send(closedMsg); err != nil {
+				logger.WarnEvent().Err(err).Msg("Failed to acknowledge tunnel unregister")
+			}
+
 		case *tunnelv1.ProxyMessage_Control:
-			if currentTunnel == nil && payload.Control.Type == tunnelv1.ControlMessage_UNKNOWN {
+			if session.empty() && payload.Control.Type == tunnelv1.ControlMessage_UNKNOWN {
 				reg, err := parseRegistrationData(payload.Control.TunnelId)
 				if err != nil {
 					logger.WarnEvent().Err(err).Msg("Invalid registration message format")
 					return status.Error(codes.InvalidArgument, err.Error())
 				}
 
+				logger.WarnEvent().Msg("Client uses deprecated pipe-delimited registration; please upgrade grok")
+
 				tun, err := s.handleTunnelRegistration(ctx, stream, reg)
 				if err != nil {
 					return err
 				}
 
-				currentTunnel = tun
-				go s.processRequests(ctx, currentTunnel)
+				session.add(tun)
+				processors.Add(1)
+				go func() {
+					defer processors.Done()
+					s.processRequests(ctx, tun)
+				}()
 				continue
 			}
 
@@ -426,14 +874,16 @@ func (s *TunnelService) ProxyStream(stream tunnelv1.TunnelService_ProxyStreamSer
 				Msg("Received control message")
 
 		case *tunnelv1.ProxyMessage_Response:
-			if currentTunnel == nil {
-				logger.WarnEvent().Msg("Received response before tunnel registration")
+			tun := session.route(payload.Response.TunnelId)
+			if tun == nil {
+				logger.WarnEvent().
+					Str("tunnel_id", payload.Response.TunnelId).
+					Msg("Received response for unknown tunnel")
 				continue
 			}
 
-			// Handle response asynchronously to avoid blocking ProxyStream receive loop
-			// This allows concurrent response processing while continuing to receive more responses
-			go s.handleProxyResponse(currentTunnel, payload.Response)
+			// Handle response in the receive loop so streamed body frames keep their order
+			s.handleProxyResponse(tun, payload.Response)
 
 		case *tunnelv1.ProxyMessage_Error:
 			logger.ErrorEvent().
@@ -493,6 +943,56 @@ func (s *TunnelService) Heartbeat(stream tunnelv1.TunnelService_HeartbeatServer)
 	}
 }
 
+// CreateShareLink signs a share link for one of the caller's online tunnels.
+func (s *TunnelService) CreateShareLink(
+	ctx context.Context,
+	req *tunnelv1.CreateShareLinkRequest,
+) (*tunnelv1.CreateShareLinkResponse, error) {
+	authToken, err := s.tokenService.ValidateToken(ctx, req.AuthToken)
+	if err != nil {
+		logger.WarnEvent().Err(err).Msg("Invalid token in CreateShareLink")
+		return nil, status.Error(codes.Unauthenticated, "invalid authentication token")
+	}
+
+	guard := s.tunnelManager.AccessGuard()
+	if guard == nil {
+		return nil, status.Error(codes.FailedPrecondition, "access policies are not enabled on this server")
+	}
+
+	var tun *tunnel.Tunnel
+	for _, candidate := range s.tunnelManager.GetUserTunnels(authToken.UserID) {
+		if candidate.Subdomain == req.Tunnel || (candidate.SavedName != nil && *candidate.SavedName == req.Tunnel) {
+			tun = candidate
+			break
+		}
+	}
+	if tun == nil {
+		return nil, status.Errorf(codes.NotFound, "no online tunnel named %q", req.Tunnel)
+	}
+	if tun.Access == nil || !tun.Access.ShareLinks {
+		return nil, status.Error(codes.FailedPrecondition, "tunnel does not accept share links (start it with --share-links)")
+	}
+
+	link, expires, err := guard.CreateShareLink(access.Scope(tun.Subdomain, tun.UserID), tun.PublicURL, time.Duration(req.TtlSeconds)*time.Second)
+	if errors.Is(err, access.ErrShareLinkTTL) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to create share link")
+		return nil, status.Error(codes.Internal, "failed to create share link")
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Time("expires_at", expires).
+		Msg("Share link created")
+
+	return &tunnelv1.CreateShareLinkResponse{
+		Url:       link,
+		ExpiresAt: expires.Unix(),
+	}, nil
+}
+
 // processRequests processes pending requests from the tunnel queue.
 func (s *TunnelService) processRequests(ctx context.Context, tun *tunnel.Tunnel) {
 	logger.DebugEvent().
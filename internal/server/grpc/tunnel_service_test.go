@@ -2,7 +2,9 @@ package grpc
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -14,7 +16,10 @@ import (
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 )
@@ -160,6 +165,233 @@ func TestParseRegistrationData(t *testing.T) {
 	}
 }
 
+// TestRegistrationFromMessage tests conversion of typed registration messages.
+func TestRegistrationFromMessage(t *testing.T) {
+	appID := uuid.New()
+
+	reg, err := registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		Subdomain:    "myapp",
+		PublicUrl:    "https://myapp.grok.io",
+		SavedName:    "a|b",
+		WebhookAppId: appID.String(),
+		Labels:       map[string]string{"env": "dev"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "a|b", reg.savedName)
+	assert.Equal(t, tunnelv1.TunnelProtocol_HTTPS, reg.protocol, "protocol falls back to public URL")
+	assert.Equal(t, appID, *reg.webhookAppID)
+	assert.Equal(t, "dev", reg.labels["env"])
+	assert.False(t, reg.legacy)
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{LocalAddress: "localhost:3000"})
+	assert.Equal(t, tunnelv1.ErrorCode_UNAUTHORIZED, rejectionCode(err))
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{AuthToken: "grok_abc123"})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err))
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		WebhookAppId: "not-a-uuid",
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err))
+
+	reg, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		Options:      &tunnelv1.TunnelOptions{Access: &tunnelv1.AccessPolicy{ShareLinks: true}},
+	})
+	require.NoError(t, err)
+	assert.True(t, reg.edge.Access.ShareLinks)
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:22",
+		Protocol:     tunnelv1.TunnelProtocol_TCP,
+		Options:      &tunnelv1.TunnelOptions{Access: &tunnelv1.AccessPolicy{ShareLinks: true}},
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "access policies are for HTTP tunnels")
+
+	reg, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:22",
+		Protocol:     tunnelv1.TunnelProtocol_TCP,
+		Options:      &tunnelv1.TunnelOptions{IpRules: &tunnelv1.IPRules{AllowCidrs: []string{"203.0.113.0/24"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.0/24"}, reg.edge.IPRules.Config().AllowCIDRs)
+
+	for _, options := range []*tunnelv1.TunnelOptions{
+		{IpRules: &tunnelv1.IPRules{DenyCidrs: []string{"not-a-network"}}},
+		{IpRules: &tunnelv1.IPRules{AllowCountries: []string{"Germany"}}},
+	} {
+		_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+			AuthToken:    "grok_abc123",
+			LocalAddress: "localhost:3000",
+			Options:      options,
+		})
+		assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "%v", options)
+	}
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:53",
+		Protocol:     tunnelv1.TunnelProtocol_UDP,
+		Options:      &tunnelv1.TunnelOptions{IpRules: &tunnelv1.IPRules{DenyCidrs: []string{"10.0.0.0/8"}}},
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "IP rules are for HTTP and TCP tunnels")
+
+	noindex := &tunnelv1.HeaderRules{Response: []*tunnelv1.HeaderRule{
+		{Action: tunnelv1.HeaderAction_HEADER_ACTION_SET, Name: "x-robots-tag", Value: "noindex"},
+	}}
+	reg, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		Options:      &tunnelv1.TunnelOptions{HeaderRules: noindex},
+	})
+	require.NoError(t, err)
+	require.Len(t, reg.edge.Headers.Response, 1)
+	assert.Equal(t, "X-Robots-Tag", reg.edge.Headers.Response[0].Name)
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:22",
+		Protocol:     tunnelv1.TunnelProtocol_TCP,
+		Options:      &tunnelv1.TunnelOptions{HeaderRules: noindex},
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "header rules are for HTTP tunnels")
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		Options: &tunnelv1.TunnelOptions{HeaderRules: &tunnelv1.HeaderRules{Request: []*tunnelv1.HeaderRule{
+			{Action: tunnelv1.HeaderAction_HEADER_ACTION_SET, Name: "X-Who", Value: "{user}"},
+		}}},
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "unknown template variable")
+
+	cached := &tunnelv1.TunnelOptions{Edge: &tunnelv1.EdgeOptions{Compress: true, Cache: true}}
+	reg, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:3000",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		Options:      cached,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, tunnel.EdgeOptions{Compress: true, Cache: true}, reg.edge.Edge)
+
+	_, err = registrationFromMessage(&tunnelv1.RegisterTunnel{
+		AuthToken:    "grok_abc123",
+		LocalAddress: "localhost:22",
+		Protocol:     tunnelv1.TunnelProtocol_TCP,
+		Options:      cached,
+	})
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, rejectionCode(err), "edge options are for HTTP tunnels")
+}
+
+// TestCheckAccessSupport tests that policies the server cannot enforce are refused.
+func TestCheckAccessSupport(t *testing.T) {
+	service, _, _, tm := setupTestTunnelService(t)
+	shareLinks := &access.Policy{ShareLinks: true}
+	oidc := &access.Policy{OIDC: &access.OIDCRule{Domains: []string{"example.com"}}}
+
+	assert.NoError(t, service.checkAccessSupport(nil))
+	assert.Equal(t, codes.InvalidArgument, status.Code(service.checkAccessSupport(shareLinks)))
+
+	guard, err := access.NewGuard(access.Config{Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	tm.SetAccessGuard(guard)
+	assert.NoError(t, service.checkAccessSupport(shareLinks))
+	assert.Equal(t, codes.InvalidArgument, status.Code(service.checkAccessSupport(oidc)), "no OIDC provider configured")
+}
+
+// TestCheckEdgeSupport tests that country rules and caching are refused when
+// the server is not set up for them.
+func TestCheckEdgeSupport(t *testing.T) {
+	service, _, _, tm := setupTestTunnelService(t)
+	networks, err := ipfilter.Parse(ipfilter.Config{AllowCIDRs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	countries, err := ipfilter.Parse(ipfilter.Config{DenyCountries: []string{"RU"}})
+	require.NoError(t, err)
+
+	assert.NoError(t, service.checkEdgeSupport(tunnel.EdgePolicy{IPRules: networks}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(service.checkEdgeSupport(tunnel.EdgePolicy{IPRules: countries})))
+
+	tm.SetIPFilter(ipfilter.NewFilter(nil))
+	assert.Equal(t, codes.InvalidArgument, status.Code(service.checkEdgeSupport(tunnel.EdgePolicy{IPRules: countries})))
+
+	// Caching needs the server's edge cache; compression needs nothing
+	compressed := tunnel.EdgePolicy{Edge: tunnel.EdgeOptions{Compress: true}}
+	cached := tunnel.EdgePolicy{Edge: tunnel.EdgeOptions{Cache: true}}
+	assert.NoError(t, service.checkEdgeSupport(compressed))
+	assert.Equal(t, codes.InvalidArgument, status.Code(service.checkEdgeSupport(cached)))
+
+	cache, err := edgecache.New(edgecache.Config{MaxMemory: 1 << 20})
+	require.NoError(t, err)
+	tm.SetEdgeCache(cache)
+	assert.NoError(t, service.checkEdgeSupport(cached))
+}
+
+// TestCreateShareLink tests share links for the caller's online tunnels.
+func TestCreateShareLink(t *testing.T) {
+	service, db, tokenService, tm := setupTestTunnelService(t)
+	ctx := context.Background()
+
+	guard, err := access.NewGuard(access.Config{
+		Secret:          []byte("0123456789abcdef0123456789abcdef"),
+		MaxShareLinkTTL: 7 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	tm.SetAccessGuard(guard)
+
+	user := createTestUser(t, db, "owner", nil)
+	tokenString, token := createTestToken(t, db, tokenService, user.ID, "test-token")
+	other := createTestUser(t, db, "other", nil)
+	otherToken, _ := createTestToken(t, db, tokenService, other.ID, "other-token")
+
+	register := func(subdomain string, policy *access.Policy) {
+		savedName := subdomain
+		tun := tunnel.NewTunnel(user.ID, token.ID, nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+			"localhost:3000", "http://"+subdomain+".grok.io", nil)
+		tun.SavedName = &savedName
+		tun.Access = policy
+		require.NoError(t, tm.RegisterTunnel(ctx, tun))
+	}
+	register("shared", &access.Policy{ShareLinks: true})
+	register("public", nil)
+
+	resp, err := service.CreateShareLink(ctx, &tunnelv1.CreateShareLinkRequest{
+		AuthToken:  tokenString,
+		Tunnel:     "shared",
+		TtlSeconds: 3600,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resp.Url, "http://shared.grok.io/?grok_share="))
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), resp.ExpiresAt, 2)
+
+	tests := []struct {
+		name string
+		req  *tunnelv1.CreateShareLinkRequest
+		code codes.Code
+	}{
+		{name: "invalid token", req: &tunnelv1.CreateShareLinkRequest{AuthToken: "grok_invalid", Tunnel: "shared"}, code: codes.Unauthenticated},
+		{name: "other user's tunnel", req: &tunnelv1.CreateShareLinkRequest{AuthToken: otherToken, Tunnel: "shared"}, code: codes.NotFound},
+		{name: "share links disabled", req: &tunnelv1.CreateShareLinkRequest{AuthToken: tokenString, Tunnel: "public"}, code: codes.FailedPrecondition},
+		{name: "ttl above maximum", req: &tunnelv1.CreateShareLinkRequest{AuthToken: tokenString, Tunnel: "shared", TtlSeconds: 30 * 24 * 3600}, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateShareLink(ctx, tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
 // TestDetermineProtocolFromURL tests URL protocol detection.
 func TestDetermineProtocolFromURL(t *testing.T) {
 	tests := []struct {
@@ -182,6 +414,16 @@ func TestDetermineProtocolFromURL(t *testing.T) {
 			publicURL: "tcp://myapp.grok.io:10000",
 			expected:  tunnelv1.TunnelProtocol_TCP,
 		},
+		{
+			name:      "tls URL",
+			publicURL: "tls://myapp.grok.io",
+			expected:  tunnelv1.TunnelProtocol_TLS,
+		},
+		{
+			name:      "udp URL",
+			publicURL: "udp://myapp.grok.io:10000",
+			expected:  tunnelv1.TunnelProtocol_UDP,
+		},
 		{
 			name:      "empty URL defaults to HTTP",
 			publicURL: "",
@@ -226,6 +468,16 @@ func TestDetermineProtocol(t *testing.T) {
 			reqProtocol: tunnelv1.TunnelProtocol_TCP,
 			expected:    "tcp",
 		},
+		{
+			name:        "TLS protocol",
+			reqProtocol: tunnelv1.TunnelProtocol_TLS,
+			expected:    "tls",
+		},
+		{
+			name:        "UDP protocol",
+			reqProtocol: tunnelv1.TunnelProtocol_UDP,
+			expected:    "udp",
+		},
 		{
 			name:        "unspecified defaults to HTTP (TLS disabled)",
 			reqProtocol: tunnelv1.TunnelProtocol_TUNNEL_PROTOCOL_UNSPECIFIED,
@@ -295,6 +547,15 @@ func TestCreateTunnel(t *testing.T) {
 				assert.Contains(t, resp.PublicUrl, "tcp://")
 			},
 		},
+		{
+			name: "TLS tunnel without HTTPS listener",
+			req: &tunnelv1.CreateTunnelRequest{
+				AuthToken:    tokenString,
+				Protocol:     tunnelv1.TunnelProtocol_TLS,
+				LocalAddress: "localhost:8443",
+			},
+			expectedError: codes.InvalidArgument,
+		},
 		{
 			name: "custom subdomain",
 			req: &tunnelv1.CreateTunnelRequest{
@@ -457,7 +718,7 @@ func TestAllocateSubdomainForTunnel(t *testing.T) {
 				tt.setup()
 			}
 
-			fullSubdomain, customPart, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, tt.requestedSubdomain)
+			fullSubdomain, customPart, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, tt.requestedSubdomain, tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED)
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -498,7 +759,7 @@ func TestAllocateSubdomainForTunnel_OfflineTunnelReuse(t *testing.T) {
 	require.NoError(t, err)
 
 	// Request subdomain with saved name "myapp" should reuse offline tunnel's subdomain
-	fullSubdomain, customPart, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, "myapp")
+	fullSubdomain, customPart, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, "myapp", tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED)
 	require.NoError(t, err)
 	assert.Equal(t, "abc123def", fullSubdomain)
 	assert.Equal(t, "myapp", customPart)
@@ -614,7 +875,7 @@ func TestAllocateSubdomainForTunnel_ReservedSubdomains(t *testing.T) {
 
 	for _, subdomain := range reservedSubdomains {
 		t.Run("reserved_"+subdomain, func(t *testing.T) {
-			_, _, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, subdomain)
+			_, _, err := service.allocateSubdomainForTunnel(ctx, user.ID, nil, subdomain, tunnelv1.LoadBalancing_LOAD_BALANCING_UNSPECIFIED)
 			assert.Error(t, err)
 			assert.ErrorIs(t, err, pkgerrors.ErrInvalidSubdomain)
 		})
@@ -0,0 +1,207 @@
+// Package headerrules adds, sets and removes the headers of requests to and
+// responses from HTTP tunnels at the edge.
+package headerrules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+)
+
+// Actions of a rule.
+const (
+	ActionAdd    = "add"    // Add a value, keeping existing ones
+	ActionSet    = "set"    // Replace all values
+	ActionRemove = "remove" // Remove the header
+)
+
+// protected are headers that frame or route the HTTP exchange; rules cannot
+// change them.
+var protected = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"Upgrade":           true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Trailer":           true,
+}
+
+// Config is the stored and API form of a rule set.
+type Config struct {
+	Request  []RuleConfig `json:"request,omitempty"`  // Before requests are forwarded to the client
+	Response []RuleConfig `json:"response,omitempty"` // Before responses are written to visitors
+}
+
+// RuleConfig is the stored and API form of a rule.
+type RuleConfig struct {
+	Action string `json:"action"`          // add, set or remove
+	Name   string `json:"name"`            // Header name
+	Value  string `json:"value,omitempty"` // Template for add and set
+}
+
+// Rules is a parsed rule set, applied in order.
+type Rules struct {
+	Request  []Rule
+	Response []Rule
+}
+
+// Rule changes one header.
+type Rule struct {
+	Action string
+	Name   string // Canonical header name
+	Value  *Template
+}
+
+// Parse validates cfg and returns its rules, or nil when cfg has none.
+func Parse(cfg Config) (*Rules, error) {
+	if len(cfg.Request)+len(cfg.Response) == 0 {
+		return nil, nil
+	}
+
+	var rules Rules
+	var err error
+	if rules.Request, err = parseRules(cfg.Request); err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	if rules.Response, err = parseRules(cfg.Response); err != nil {
+		return nil, fmt.Errorf("response: %w", err)
+	}
+	return &rules, nil
+}
+
+// RulesFromProto validates the rules declared by a client, or returns nil when it declared none.
+func RulesFromProto(msg *tunnelv1.HeaderRules) (*Rules, error) {
+	return Parse(Config{
+		Request:  rulesFromProto(msg.GetRequest()),
+		Response: rulesFromProto(msg.GetResponse()),
+	})
+}
+
+// Config returns the rules in their stored form.
+func (r *Rules) Config() Config {
+	if r == nil {
+		return Config{}
+	}
+	return Config{
+		Request:  formatRules(r.Request),
+		Response: formatRules(r.Response),
+	}
+}
+
+// ApplyRequest applies the request rules to the headers of a request.
+func (r *Rules) ApplyRequest(headers map[string]*tunnelv1.HeaderValues, vars Vars) {
+	if r != nil {
+		apply(headers, r.Request, vars)
+	}
+}
+
+// ApplyResponse applies the response rules to the headers of a response.
+func (r *Rules) ApplyResponse(headers map[string]*tunnelv1.HeaderValues, vars Vars) {
+	if r != nil {
+		apply(headers, r.Response, vars)
+	}
+}
+
+// apply changes headers, whose keys may differ from the canonical names in case.
+func apply(headers map[string]*tunnelv1.HeaderValues, rules []Rule, vars Vars) {
+	for _, rule := range rules {
+		var existing []string
+		for key, values := range headers {
+			if strings.EqualFold(key, rule.Name) {
+				existing = append(existing, values.GetValues()...)
+				delete(headers, key)
+			}
+		}
+
+		switch rule.Action {
+		case ActionAdd:
+			values := append(existing, rule.Value.Expand(vars))
+			headers[rule.Name] = &tunnelv1.HeaderValues{Values: values}
+		case ActionSet:
+			headers[rule.Name] = &tunnelv1.HeaderValues{Values: []string{rule.Value.Expand(vars)}}
+		}
+	}
+}
+
+func parseRules(configs []RuleConfig) ([]Rule, error) {
+	rules := make([]Rule, 0, len(configs))
+	for _, cfg := range configs {
+		name := strings.TrimSpace(cfg.Name)
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid header name %q", cfg.Name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if protected[name] {
+			return nil, fmt.Errorf("header %s cannot be changed", name)
+		}
+
+		rule := Rule{Action: strings.ToLower(strings.TrimSpace(cfg.Action)), Name: name}
+		switch rule.Action {
+		case ActionAdd, ActionSet:
+			value, err := ParseTemplate(cfg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %w", name, err)
+			}
+			rule.Value = value
+		case ActionRemove:
+			if cfg.Value != "" {
+				return nil, fmt.Errorf("header %s: remove rules take no value", name)
+			}
+		default:
+			return nil, fmt.Errorf("header %s: invalid action %q (use add, set or remove)", name, cfg.Action)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func rulesFromProto(msgs []*tunnelv1.HeaderRule) []RuleConfig {
+	configs := make([]RuleConfig, 0, len(msgs))
+	for _, msg := range msgs {
+		var action string
+		switch msg.GetAction() {
+		case tunnelv1.HeaderAction_HEADER_ACTION_ADD:
+			action = ActionAdd
+		case tunnelv1.HeaderAction_HEADER_ACTION_SET:
+			action = ActionSet
+		case tunnelv1.HeaderAction_HEADER_ACTION_REMOVE:
+			action = ActionRemove
+		default:
+			action = msg.GetAction().String()
+		}
+		configs = append(configs, RuleConfig{Action: action, Name: msg.GetName(), Value: msg.GetValue()})
+	}
+	return configs
+}
+
+func formatRules(rules []Rule) []RuleConfig {
+	configs := make([]RuleConfig, len(rules))
+	for i, rule := range rules {
+		configs[i] = RuleConfig{Action: rule.Action, Name: rule.Name}
+		if rule.Value != nil {
+			configs[i].Value = rule.Value.String()
+		}
+	}
+	return configs
+}
+
+// TunnelRulesFromModel parses the rules stored for tun by admins.
+func TunnelRulesFromModel(tun *models.Tunnel) (*Rules, error) {
+	if len(tun.HeaderRules) == 0 {
+		return nil, nil
+	}
+	var cfg Config
+	if err := json.Unmarshal(tun.HeaderRules, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid header rules: %w", err)
+	}
+	return Parse(cfg)
+}
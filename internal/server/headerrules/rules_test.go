@@ -0,0 +1,113 @@
+package headerrules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+)
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	cfg := Config{
+		Request: []RuleConfig{
+			{Action: "SET", Name: "x-internal-auth", Value: "s3cret"},
+			{Action: "remove", Name: "Cookie"},
+		},
+		Response: []RuleConfig{{Action: "add", Name: "X-Served-By", Value: "{tunnel}"}},
+	}
+	rules, err = Parse(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		Request: []RuleConfig{
+			{Action: "set", Name: "X-Internal-Auth", Value: "s3cret"},
+			{Action: "remove", Name: "Cookie"},
+		},
+		Response: []RuleConfig{{Action: "add", Name: "X-Served-By", Value: "{tunnel}"}},
+	}, rules.Config())
+
+	for _, cfg := range []Config{
+		{Request: []RuleConfig{{Action: "append", Name: "X-A", Value: "b"}}},
+		{Request: []RuleConfig{{Action: "set", Name: "Bad Name", Value: "b"}}},
+		{Request: []RuleConfig{{Action: "set", Name: "Host", Value: "example.com"}}},
+		{Response: []RuleConfig{{Action: "remove", Name: "transfer-encoding"}}},
+		{Response: []RuleConfig{{Action: "remove", Name: "Server", Value: "x"}}},
+		{Response: []RuleConfig{{Action: "set", Name: "X-A", Value: "a\r\nX-B: b"}}},
+		{Response: []RuleConfig{{Action: "set", Name: "X-A", Value: "{user}"}}},
+	} {
+		_, err := Parse(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
+
+func TestRulesFromProto(t *testing.T) {
+	rules, err := RulesFromProto(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	rules, err = RulesFromProto(&tunnelv1.HeaderRules{Response: []*tunnelv1.HeaderRule{
+		{Action: tunnelv1.HeaderAction_HEADER_ACTION_REMOVE, Name: "server"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Action: ActionRemove, Name: "Server"}}, rules.Response)
+
+	_, err = RulesFromProto(&tunnelv1.HeaderRules{Request: []*tunnelv1.HeaderRule{{Name: "X-A"}}})
+	assert.Error(t, err, "unspecified action")
+}
+
+func TestRules_Apply(t *testing.T) {
+	rules, err := Parse(Config{
+		Request: []RuleConfig{
+			{Action: "remove", Name: "Cookie"},
+			{Action: "set", Name: "X-Forwarded-For", Value: "{client_ip}"},
+			{Action: "add", Name: "Via", Value: "grok {request_id}"},
+		},
+		Response: []RuleConfig{
+			{Action: "set", Name: "X-Robots-Tag", Value: "noindex"},
+		},
+	})
+	require.NoError(t, err)
+
+	headers := map[string]*tunnelv1.HeaderValues{
+		"Cookie":          {Values: []string{"session=1"}},
+		"x-forwarded-for": {Values: []string{"10.0.0.1", "10.0.0.2"}},
+		"Via":             {Values: []string{"1.1 cdn"}},
+		"Accept":          {Values: []string{"*/*"}},
+	}
+	rules.ApplyRequest(headers, Vars{ClientIP: "203.0.113.9", RequestID: "req-1"})
+	assert.Equal(t, map[string]*tunnelv1.HeaderValues{
+		"X-Forwarded-For": {Values: []string{"203.0.113.9"}},
+		"Via":             {Values: []string{"1.1 cdn", "grok req-1"}},
+		"Accept":          {Values: []string{"*/*"}},
+	}, headers)
+
+	headers = map[string]*tunnelv1.HeaderValues{}
+	rules.ApplyResponse(headers, Vars{})
+	assert.Equal(t, []string{"noindex"}, headers["X-Robots-Tag"].Values)
+
+	var none *Rules
+	none.ApplyRequest(headers, Vars{})
+	none.ApplyResponse(headers, Vars{})
+	assert.Len(t, headers, 1)
+}
+
+func TestTunnelRulesFromModel(t *testing.T) {
+	rules, err := TunnelRulesFromModel(&models.Tunnel{})
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	rules, err = TunnelRulesFromModel(&models.Tunnel{
+		HeaderRules: []byte(`{"response":[{"action":"remove","name":"Server"}]}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Action: ActionRemove, Name: "Server"}}, rules.Response)
+
+	_, err = TunnelRulesFromModel(&models.Tunnel{HeaderRules: []byte(`[`)})
+	assert.Error(t, err)
+}
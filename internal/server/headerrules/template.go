@@ -0,0 +1,95 @@
+package headerrules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// variablePattern matches the placeholders of a template, e.g. {client_ip}.
+var variablePattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Vars are the values of the placeholders of a request.
+type Vars struct {
+	ClientIP  string // {client_ip}: address of the visitor
+	Tunnel    string // {tunnel}: saved name of the tunnel, or its subdomain
+	Subdomain string // {subdomain}
+	Host      string // {host}: public host requested by the visitor
+	RequestID string // {request_id}
+}
+
+// lookup returns the value of a placeholder.
+func (v Vars) lookup(name string) (string, bool) {
+	switch name {
+	case "client_ip":
+		return v.ClientIP, true
+	case "tunnel":
+		return v.Tunnel, true
+	case "subdomain":
+		return v.Subdomain, true
+	case "host":
+		return v.Host, true
+	case "request_id":
+		return v.RequestID, true
+	default:
+		return "", false
+	}
+}
+
+// Template is a header value with placeholders replaced per request.
+type Template struct {
+	source string
+	parts  []templatePart
+}
+
+type templatePart struct {
+	literal  string
+	variable string // Placeholder name; empty for literals
+}
+
+// ParseTemplate validates a header value and its placeholders.
+func ParseTemplate(source string) (*Template, error) {
+	if !httpguts.ValidHeaderFieldValue(source) {
+		return nil, errors.New("invalid header value")
+	}
+
+	t := &Template{source: source}
+	last := 0
+	for _, m := range variablePattern.FindAllStringSubmatchIndex(source, -1) {
+		name := source[m[2]:m[3]]
+		if _, ok := (Vars{}).lookup(name); !ok {
+			return nil, fmt.Errorf("unknown variable {%s} (use {client_ip}, {tunnel}, {subdomain}, {host} or {request_id})", name)
+		}
+		if m[0] > last {
+			t.parts = append(t.parts, templatePart{literal: source[last:m[0]]})
+		}
+		t.parts = append(t.parts, templatePart{variable: name})
+		last = m[1]
+	}
+	if last < len(source) {
+		t.parts = append(t.parts, templatePart{literal: source[last:]})
+	}
+	return t, nil
+}
+
+// Expand returns the value with the placeholders replaced by vars.
+func (t *Template) Expand(vars Vars) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.variable == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value, _ := vars.lookup(part.variable)
+		b.WriteString(value)
+	}
+	return b.String()
+}
+
+// String returns the template as written.
+func (t *Template) String() string {
+	return t.source
+}
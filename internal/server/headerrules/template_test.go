@@ -0,0 +1,46 @@
+package headerrules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate_Expand(t *testing.T) {
+	vars := Vars{
+		ClientIP:  "203.0.113.9",
+		Tunnel:    "api",
+		Subdomain: "api-acme",
+		Host:      "api-acme.grok.io",
+		RequestID: "req-1",
+	}
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "", want: ""},
+		{source: "noindex", want: "noindex"},
+		{source: "{client_ip}", want: "203.0.113.9"},
+		{source: "{tunnel} ({subdomain}) on {host}: {request_id}", want: "api (api-acme) on api-acme.grok.io: req-1"},
+		{source: "default-src 'self'; {not a variable}", want: "default-src 'self'; {not a variable}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			tmpl, err := ParseTemplate(tt.source)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tmpl.Expand(vars))
+			assert.Equal(t, tt.source, tmpl.String())
+		})
+	}
+}
+
+func TestParseTemplate_Invalid(t *testing.T) {
+	_, err := ParseTemplate("{client_ip} {client}")
+	assert.ErrorContains(t, err, "unknown variable {client}")
+
+	_, err = ParseTemplate("a\nb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package ipfilter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+)
+
+// Filter checks visitors against the rules of their tunnel's organization
+// and of the tunnel itself; both must let a visitor through.
+type Filter struct {
+	geo *GeoDB // Optional: country rules are not available when nil
+
+	mu   sync.RWMutex
+	orgs map[uuid.UUID]*Rules
+}
+
+// NewFilter creates a filter locating visitors with geo, which may be nil.
+func NewFilter(geo *GeoDB) *Filter {
+	return &Filter{
+		geo:  geo,
+		orgs: make(map[uuid.UUID]*Rules),
+	}
+}
+
+// GeoEnabled reports whether country rules can be evaluated.
+func (f *Filter) GeoEnabled() bool {
+	return f != nil && f.geo != nil
+}
+
+// Load reads the rules of all organizations from the database.
+func (f *Filter) Load(ctx context.Context, db *gorm.DB) error {
+	var orgs []models.Organization
+	if err := db.WithContext(ctx).Where("ip_rules IS NOT NULL").Find(&orgs).Error; err != nil {
+		return fmt.Errorf("failed to load organization IP rules: %w", err)
+	}
+
+	for _, org := range orgs {
+		rules, err := OrgRulesFromModel(&org)
+		if err != nil {
+			return fmt.Errorf("organization %s: %w", org.Subdomain, err)
+		}
+		f.SetOrgRules(org.ID, rules)
+	}
+	return nil
+}
+
+// OrgRulesFromModel parses the stored rules of org.
+func OrgRulesFromModel(org *models.Organization) (*Rules, error) {
+	if len(org.IPRules) == 0 {
+		return nil, nil
+	}
+	var cfg Config
+	if err := json.Unmarshal(org.IPRules, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid IP rules: %w", err)
+	}
+	return Parse(cfg)
+}
+
+// SetOrgRules replaces the rules of an organization; nil removes them.
+func (f *Filter) SetOrgRules(orgID uuid.UUID, rules *Rules) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if rules == nil {
+		delete(f.orgs, orgID)
+		return
+	}
+	f.orgs[orgID] = rules
+}
+
+// OrgRules returns the rules of an organization, or nil.
+func (f *Filter) OrgRules(orgID uuid.UUID) *Rules {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.orgs[orgID]
+}
+
+// Check returns why a visitor at addr (an address, or address:port) may not
+// reach a tunnel of org with rules, or an empty string when it may.
+func (f *Filter) Check(addr string, org *uuid.UUID, rules *Rules) string {
+	var orgRules *Rules
+	if org != nil {
+		orgRules = f.OrgRules(*org)
+	}
+	if orgRules == nil && rules == nil {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return "unknown address"
+	}
+	ip = ip.Unmap().WithZone("")
+
+	var country string
+	if (orgRules.UsesCountries() || rules.UsesCountries()) && f.GeoEnabled() {
+		country = f.geo.Country(ip)
+	}
+
+	if orgRules != nil {
+		if reason := orgRules.check(ip, country); reason != "" {
+			return "organization " + reason
+		}
+	}
+	if rules != nil {
+		if reason := rules.check(ip, country); reason != "" {
+			return "tunnel " + reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package ipfilter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+)
+
+func TestFilter_Check(t *testing.T) {
+	geo, err := OpenGeoDB(writeTestGeoDB(t))
+	require.NoError(t, err)
+	filter := NewFilter(geo)
+	assert.True(t, filter.GeoEnabled())
+
+	orgID := uuid.New()
+	orgRules, err := Parse(Config{DenyCountries: []string{"NL"}})
+	require.NoError(t, err)
+	filter.SetOrgRules(orgID, orgRules)
+
+	tunnelRules, err := Parse(Config{AllowCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}})
+	require.NoError(t, err)
+
+	assert.Empty(t, filter.Check("10.1.2.3:4567", &orgID, tunnelRules))
+	assert.Equal(t, "organization denied country NL", filter.Check("[2001:db8::1]:443", &orgID, tunnelRules))
+	assert.Equal(t, "tunnel not in allowlist", filter.Check("192.0.2.1:80", &orgID, tunnelRules))
+	assert.Equal(t, "tunnel not in allowlist", filter.Check("192.0.2.1", nil, tunnelRules))
+	assert.Equal(t, "unknown address", filter.Check("pipe", nil, tunnelRules))
+	assert.Empty(t, filter.Check("pipe", nil, nil))
+
+	filter.SetOrgRules(orgID, nil)
+	assert.Empty(t, filter.Check("[2001:db8::1]:443", &orgID, tunnelRules))
+
+	// Without a filter only tunnel rules apply
+	var none *Filter
+	assert.Equal(t, "tunnel not in allowlist", none.Check("192.0.2.1:80", &orgID, tunnelRules))
+	assert.False(t, none.GeoEnabled())
+}
+
+func TestFilter_Load(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}))
+
+	filtered := models.Organization{Name: "Acme", Subdomain: "acme", IPRules: []byte(`{"deny_cidrs":["192.0.2.0/24"]}`)}
+	open := models.Organization{Name: "Open", Subdomain: "open"}
+	require.NoError(t, db.Create(&filtered).Error)
+	require.NoError(t, db.Create(&open).Error)
+
+	filter := NewFilter(nil)
+	require.NoError(t, filter.Load(context.Background(), db))
+	assert.Equal(t, []string{"192.0.2.0/24"}, filter.OrgRules(filtered.ID).Config().DenyCIDRs)
+	assert.Nil(t, filter.OrgRules(open.ID))
+
+	require.NoError(t, db.Model(&open).Update("ip_rules", `{"deny_cidrs":["nope"]}`).Error)
+	assert.ErrorContains(t, NewFilter(nil).Load(context.Background(), db), "organization open")
+}
@@ -0,0 +1,315 @@
+package ipfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of a MaxMind DB file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data section field types of the MaxMind DB format.
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth bounds the nesting of decoded values, so a corrupt file cannot
+// recurse forever through pointers.
+const maxDepth = 32
+
+// GeoDB looks up the countries of addresses in a MaxMind DB file, such as
+// GeoLite2-Country or GeoIP2-Country. The file is read into memory once.
+type GeoDB struct {
+	buf        []byte
+	data       []byte // Data section
+	nodeCount  uint
+	recordSize uint
+	ipv4Start  uint // Node of ::/96, where IPv4 lookups start in an IPv6 tree
+	ipVersion  uint
+}
+
+// OpenGeoDB reads the MaxMind DB file at path.
+func OpenGeoDB(path string) (*GeoDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+	db, err := newGeoDB(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GeoIP database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+func newGeoDB(buf []byte) (*GeoDB, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("metadata not found")
+	}
+	meta := buf[start+len(metadataMarker):]
+	value, _, err := decode(meta, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+
+	db := &GeoDB{buf: buf}
+	db.nodeCount, _ = asUint(fields["node_count"])
+	db.recordSize, _ = asUint(fields["record_size"])
+	db.ipVersion, _ = asUint(fields["ip_version"])
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(start) {
+		return nil, errors.New("search tree exceeds file")
+	}
+	db.data = buf[treeSize+16 : start]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Country returns the ISO code of the country of ip, or an empty string when
+// the database does not know it.
+func (db *GeoDB) Country(ip netip.Addr) string {
+	record, err := db.lookup(ip)
+	if err != nil || record == nil {
+		return ""
+	}
+	fields, _ := record.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := fields[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// lookup returns the data record of the network containing ip, or nil.
+func (db *GeoDB) lookup(ip netip.Addr) (interface{}, error) {
+	ip = ip.Unmap()
+	node := uint(0)
+	if ip.Is4() && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if ip.Is6() && db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	addr := ip.AsSlice()
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+
+	switch {
+	case node == db.nodeCount:
+		return nil, nil // Not in the database
+	case node < db.nodeCount:
+		return nil, errors.New("search tree too shallow")
+	}
+	offset := node - db.nodeCount - 16
+	value, _, err := decode(db.data, offset, 0)
+	return value, err
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *GeoDB) record(node, bit uint) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// decode decodes the value at offset of section and returns it with the
+// offset of the next value. Pointers are relative to the start of section.
+func decode(section []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	if offset >= uint(len(section)) {
+		return nil, 0, errors.New("data offset out of range")
+	}
+
+	ctrl := section[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == typePointer {
+		size := uint(ctrl>>3) & 0x3
+		base := uint(ctrl & 0x7)
+		n := size + 1
+		if offset+n > uint(len(section)) {
+			return nil, 0, errors.New("pointer out of range")
+		}
+		b := section[offset : offset+n]
+		var target uint
+		switch size {
+		case 0:
+			target = base<<8 | uint(b[0])
+		case 1:
+			target = (base<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			target = (base<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			target = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := decode(section, target, depth+1)
+		return value, offset + n, err
+	}
+
+	if kind == typeExtended {
+		if offset >= uint(len(section)) {
+			return nil, 0, errors.New("extended type out of range")
+		}
+		kind = 7 + uint(section[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(section)) {
+			return nil, 0, errors.New("size out of range")
+		}
+		var extra uint
+		for _, c := range section[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch kind {
+	case typeMap:
+		fields := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := decode(section, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			fields[name] = value
+			offset = next
+		}
+		return fields, offset, nil
+	case typeArray:
+		values := make([]interface{}, 0, min(size, 1024))
+		for i := uint(0); i < size; i++ {
+			value, next, err := decode(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(section)) {
+		return nil, 0, errors.New("value out of range")
+	}
+	b := section[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes:
+		return append([]byte(nil), b...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	case typeUint16, typeUint32, typeUint64, typeInt32:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if kind == typeInt32 {
+			return int64(int32(uint32(v))), offset, nil
+		}
+		return v, offset, nil
+	case typeUint128:
+		return append([]byte(nil), b...), offset, nil // Not used for lookups
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", kind)
+	}
+}
+
+// asUint converts a decoded unsigned integer.
+func asUint(v interface{}) (uint, bool) {
+	n, ok := v.(uint64)
+	return uint(n), ok
+}
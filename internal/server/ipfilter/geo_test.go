@@ -0,0 +1,130 @@
+package ipfilter
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNode is a node of the search tree written by writeTestGeoDB.
+type testNode struct {
+	next [2]*testNode
+	data [2]int // Data section offset + 1 of a network ending here, or 0
+}
+
+// writeTestGeoDB writes a MaxMind DB (IPv6 tree, 24-bit records) mapping
+// 10.0.0.0/8 to DE through country, and 2001:db8::/32 to NL through a
+// pointer in registered_country.
+func writeTestGeoDB(t *testing.T) string {
+	t.Helper()
+
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	mapOf := func(n int) []byte { return []byte{0xe0 | byte(n)} }
+	uint16Of := func(v int) []byte { return []byte{0xa2, byte(v >> 8), byte(v)} }
+	uint32Of := func(v int) []byte { return []byte{0xc4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)} }
+	concat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	nl := concat(mapOf(1), str("iso_code"), str("NL"))
+	de := concat(mapOf(1), str("country"), mapOf(1), str("iso_code"), str("DE"))
+	registered := concat(mapOf(1), str("registered_country"), []byte{0x20, 0x00}) // Pointer to nl
+	data := concat(nl, de, registered)
+
+	root := &testNode{}
+	insert := func(cidr string, offset int) {
+		prefix := netip.MustParsePrefix(cidr)
+		bits := prefix.Bits()
+		addr := prefix.Addr()
+		if addr.Is4() {
+			bits += 96 // IPv4 lives at ::/96
+			addr = netip.AddrFrom16([16]byte(append(make([]byte, 12), addr.AsSlice()...)))
+		}
+		b := addr.As16()
+		node := root
+		for i := 0; i < bits; i++ {
+			bit := b[i/8] >> (7 - i%8) & 1
+			if i == bits-1 {
+				node.data[bit] = offset + 1
+				break
+			}
+			if node.next[bit] == nil {
+				node.next[bit] = &testNode{}
+			}
+			node = node.next[bit]
+		}
+	}
+	insert("10.0.0.0/8", len(nl))
+	insert("2001:db8::/32", len(nl)+len(de))
+
+	var nodes []*testNode
+	index := map[*testNode]int{}
+	for queue := []*testNode{root}; len(queue) > 0; queue = queue[1:] {
+		index[queue[0]] = len(nodes)
+		nodes = append(nodes, queue[0])
+		for _, next := range queue[0].next {
+			if next != nil {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	var buf []byte
+	for _, node := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := len(nodes)
+			switch {
+			case node.next[bit] != nil:
+				record = index[node.next[bit]]
+			case node.data[bit] != 0:
+				record = len(nodes) + 16 + node.data[bit] - 1
+			}
+			buf = append(buf, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	buf = append(buf, concat(mapOf(3),
+		str("node_count"), uint32Of(len(nodes)),
+		str("record_size"), uint16Of(24),
+		str("ip_version"), uint16Of(6))...)
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	require.NoError(t, os.WriteFile(path, buf, 0o600))
+	return path
+}
+
+func TestGeoDB_Country(t *testing.T) {
+	db, err := OpenGeoDB(writeTestGeoDB(t))
+	require.NoError(t, err)
+
+	tests := map[string]string{
+		"10.1.2.3":        "DE",
+		"::ffff:10.0.0.1": "DE",
+		"11.0.0.1":        "",
+		"2001:db8::1":     "NL",
+		"2001:db9::1":     "",
+	}
+	for ip, want := range tests {
+		assert.Equal(t, want, db.Country(netip.MustParseAddr(ip)), ip)
+	}
+}
+
+func TestOpenGeoDB_Invalid(t *testing.T) {
+	_, err := OpenGeoDB(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "garbage.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o600))
+	_, err = OpenGeoDB(path)
+	assert.ErrorContains(t, err, "metadata not found")
+}
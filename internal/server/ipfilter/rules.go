@@ -0,0 +1,154 @@
+// Package ipfilter checks the addresses of visitors against the CIDR and
+// country rules of tunnels and organizations before connections are forwarded.
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// Config is the stored and API form of a rule set.
+type Config struct {
+	AllowCIDRs     []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs      []string `json:"deny_cidrs,omitempty"`
+	AllowCountries []string `json:"allow_countries,omitempty"` // ISO 3166-1 alpha-2 codes
+	DenyCountries  []string `json:"deny_countries,omitempty"`
+}
+
+// Rules is a parsed rule set. A visitor matching a deny rule is rejected;
+// when allow rules are set, a visitor must match one of them.
+type Rules struct {
+	Allow          []netip.Prefix
+	Deny           []netip.Prefix
+	AllowCountries []string
+	DenyCountries  []string
+}
+
+// Parse validates cfg and returns its rules, or nil when cfg has none.
+func Parse(cfg Config) (*Rules, error) {
+	if len(cfg.AllowCIDRs)+len(cfg.DenyCIDRs)+len(cfg.AllowCountries)+len(cfg.DenyCountries) == 0 {
+		return nil, nil
+	}
+
+	var rules Rules
+	var err error
+	if rules.Allow, err = parsePrefixes(cfg.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	if rules.Deny, err = parsePrefixes(cfg.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	if rules.AllowCountries, err = parseCountries(cfg.AllowCountries); err != nil {
+		return nil, err
+	}
+	if rules.DenyCountries, err = parseCountries(cfg.DenyCountries); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// RulesFromProto validates the rules declared by a client, or returns nil when it declared none.
+func RulesFromProto(msg *tunnelv1.IPRules) (*Rules, error) {
+	return Parse(Config{
+		AllowCIDRs:     msg.GetAllowCidrs(),
+		DenyCIDRs:      msg.GetDenyCidrs(),
+		AllowCountries: msg.GetAllowCountries(),
+		DenyCountries:  msg.GetDenyCountries(),
+	})
+}
+
+// Config returns the rules in their stored form.
+func (r *Rules) Config() Config {
+	if r == nil {
+		return Config{}
+	}
+	return Config{
+		AllowCIDRs:     formatPrefixes(r.Allow),
+		DenyCIDRs:      formatPrefixes(r.Deny),
+		AllowCountries: r.AllowCountries,
+		DenyCountries:  r.DenyCountries,
+	}
+}
+
+// UsesCountries reports whether the rules need a GeoIP database.
+func (r *Rules) UsesCountries() bool {
+	return r != nil && len(r.AllowCountries)+len(r.DenyCountries) > 0
+}
+
+// check returns why ip, located in country (empty when unknown), is
+// rejected, or an empty string when it is let through.
+func (r *Rules) check(ip netip.Addr, country string) string {
+	for _, prefix := range r.Deny {
+		if prefix.Contains(ip) {
+			return "denied network " + prefix.String()
+		}
+	}
+	if country != "" && slices.Contains(r.DenyCountries, country) {
+		return "denied country " + country
+	}
+
+	if len(r.Allow) == 0 && len(r.AllowCountries) == 0 {
+		return ""
+	}
+	for _, prefix := range r.Allow {
+		if prefix.Contains(ip) {
+			return ""
+		}
+	}
+	if country != "" && slices.Contains(r.AllowCountries, country) {
+		return ""
+	}
+	return "not in allowlist"
+}
+
+// parsePrefixes parses CIDRs and bare addresses.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		var prefix netip.Prefix
+		if strings.Contains(value, "/") {
+			p, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", value)
+			}
+			prefix = p
+		} else {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseCountries validates and uppercases ISO 3166-1 alpha-2 country codes.
+func parseCountries(values []string) ([]string, error) {
+	countries := make([]string, 0, len(values))
+	for _, value := range values {
+		code := strings.ToUpper(strings.TrimSpace(value))
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %q (use ISO 3166-1 alpha-2, e.g. DE)", value)
+		}
+		countries = append(countries, code)
+	}
+	return countries, nil
+}
+
+func formatPrefixes(prefixes []netip.Prefix) []string {
+	values := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		values[i] = prefix.String()
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package ipfilter
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	rules, err = Parse(Config{
+		AllowCIDRs:     []string{"10.1.2.3/8", " 192.0.2.7 ", "::ffff:198.51.100.0/120", "2001:db8::/32"},
+		DenyCountries:  []string{"ru"},
+		AllowCountries: []string{"DE"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		AllowCIDRs:     []string{"10.0.0.0/8", "192.0.2.7/32", "198.51.100.0/24", "2001:db8::/32"},
+		AllowCountries: []string{"DE"},
+		DenyCIDRs:      []string{},
+		DenyCountries:  []string{"RU"},
+	}, rules.Config())
+	assert.True(t, rules.UsesCountries())
+
+	for _, cfg := range []Config{
+		{AllowCIDRs: []string{"10.0.0.0/33"}},
+		{DenyCIDRs: []string{"example.com"}},
+		{DenyCountries: []string{"DEU"}},
+		{AllowCountries: []string{"d1"}},
+	} {
+		_, err := Parse(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
+
+func TestRulesFromProto(t *testing.T) {
+	rules, err := RulesFromProto(nil)
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+
+	rules, err = RulesFromProto(&tunnelv1.IPRules{DenyCidrs: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, rules.Deny)
+	assert.False(t, rules.UsesCountries())
+}
+
+func TestRules_Check(t *testing.T) {
+	rules, err := Parse(Config{
+		AllowCIDRs:     []string{"10.0.0.0/8"},
+		DenyCIDRs:      []string{"10.9.0.0/16"},
+		AllowCountries: []string{"DE"},
+		DenyCountries:  []string{"RU"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip      string
+		country string
+		want    string
+	}{
+		{ip: "10.1.2.3", want: ""},
+		{ip: "10.9.1.1", want: "denied network 10.9.0.0/16"},
+		{ip: "10.1.2.3", country: "RU", want: "denied country RU"},
+		{ip: "192.0.2.1", country: "DE", want: ""},
+		{ip: "192.0.2.1", country: "FR", want: "not in allowlist"},
+		{ip: "192.0.2.1", want: "not in allowlist"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rules.check(netip.MustParseAddr(tt.ip), tt.country), "%s %s", tt.ip, tt.country)
+	}
+
+	denyOnly, err := Parse(Config{DenyCIDRs: []string{"2001:db8::/32"}})
+	require.NoError(t, err)
+	assert.Empty(t, denyOnly.check(netip.MustParseAddr("192.0.2.1"), ""))
+	assert.Equal(t, "denied network 2001:db8::/32", denyOnly.check(netip.MustParseAddr("2001:db8::1"), ""))
+}
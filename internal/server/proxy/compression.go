@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// CompressMinSize is the smallest response body compressed when its length is known.
+const CompressMinSize = 1024
+
+// brotliLevel trades compression ratio for CPU on responses compressed per request.
+const brotliLevel = 4
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+var brotliWriterPool = sync.Pool{
+	New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotliLevel)
+	},
+}
+
+// encoder is the part of gzip.Writer and brotli.Writer used by compressWriter.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter compresses response bodies with gzip or brotli for visitors
+// that accept it. The decision is made when the header is written, from the
+// response's Content-Type, Content-Encoding, Content-Length and Cache-Control.
+type compressWriter struct {
+	http.ResponseWriter
+	method      string
+	accepted    string // Encoding negotiated with the visitor: br, gzip or empty
+	encoding    string // Encoding of the body being written, or empty
+	enc         encoder
+	wroteHeader bool
+}
+
+// newCompressWriter wraps w for the response to r.
+func newCompressWriter(w http.ResponseWriter, r *http.Request) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		method:         r.Method,
+		accepted:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+	}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") == "" && compressibleType(header.Get("Content-Type")) {
+		addVary(header, "Accept-Encoding")
+		if cw.accepted != "" && cw.method != http.MethodHead && shouldCompress(statusCode, header) {
+			cw.encoding = cw.accepted
+			header.Set("Content-Encoding", cw.encoding)
+			header.Del("Content-Length")
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", "W/"+etag) // The encoded body is not byte-identical
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter.
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoding == "" {
+		return cw.ResponseWriter.Write(data)
+	}
+	if cw.enc == nil {
+		if cw.encoding == "br" {
+			cw.enc = brotliWriterPool.Get().(*brotli.Writer)
+		} else {
+			cw.enc = gzipWriterPool.Get().(*gzip.Writer)
+		}
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	return cw.enc.Write(data)
+}
+
+// Flush implements http.Flusher, sending the data compressed so far.
+func (cw *compressWriter) Flush() {
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the visitor's writer, so that http.ResponseController can
+// set its deadlines while the response is streamed.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish ends the compressed body. Incomplete responses are left without the
+// encoder's trailer, so that visitors see them truncated.
+func (cw *compressWriter) finish(complete bool) {
+	if cw.enc == nil {
+		return
+	}
+	if complete {
+		_ = cw.enc.Close()
+	}
+	cw.enc.Reset(io.Discard)
+	if cw.encoding == "br" {
+		brotliWriterPool.Put(cw.enc)
+	} else {
+		gzipWriterPool.Put(cw.enc)
+	}
+	cw.enc = nil
+}
+
+// shouldCompress reports whether a response with a compressible type is worth compressing.
+func shouldCompress(statusCode int, header http.Header) bool {
+	if statusCode < 200 || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified ||
+		statusCode == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && length < CompressMinSize {
+		return false
+	}
+	return true
+}
+
+// compressibleType reports whether a Content-Type is worth compressing. Event
+// streams are left alone, since some visitors do not decode them incrementally.
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/x-javascript", "application/ecmascript",
+		"application/xml", "application/wasm", "application/x-www-form-urlencoded", "application/vnd.ms-fontobject",
+		"font/ttf", "font/otf", "image/x-icon", "image/vnd.microsoft.icon", "image/bmp":
+		return true
+	}
+	return false
+}
+
+// negotiateEncoding picks br or gzip from an Accept-Encoding header, preferring
+// br at equal quality. Returns "" when the visitor accepts neither.
+func negotiateEncoding(acceptEncoding string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding != "" {
+			quality[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		q, ok := quality[coding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// addVary adds a header name to the Vary header unless it is already listed.
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if listed = strings.TrimSpace(listed); listed == "*" || strings.EqualFold(listed, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
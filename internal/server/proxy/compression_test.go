@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNegotiateEncoding tests the choice between brotli and gzip.
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"gzip, deflate, br, zstd": "br",
+		"gzip":                    "gzip",
+		"br;q=0.5, gzip":          "gzip",
+		"br;q=0, gzip;q=0":        "",
+		"*":                       "br",
+		"identity":                "",
+		"GZIP;q=0.8, *;q=0.1":     "gzip",
+	}
+	for acceptEncoding, expected := range tests {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), acceptEncoding)
+	}
+}
+
+// TestCompressibleType tests which content types are compressed.
+func TestCompressibleType(t *testing.T) {
+	for _, contentType := range []string{"text/html; charset=utf-8", "application/json", "application/ld+json", "image/svg+xml", "application/javascript"} {
+		assert.True(t, compressibleType(contentType), contentType)
+	}
+	for _, contentType := range []string{"", "image/png", "application/octet-stream", "text/event-stream", "video/mp4"} {
+		assert.False(t, compressibleType(contentType), contentType)
+	}
+}
+
+// TestCompressWriter tests when responses are compressed and the headers of
+// compressed responses.
+func TestCompressWriter(t *testing.T) {
+	body := strings.Repeat("hello compressed world ", 200)
+	decode := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		method         string
+		header         http.Header
+		status         int
+		encoding       string
+	}{
+		{"gzip", "gzip", "GET", http.Header{"Content-Type": {"text/html"}, "Etag": {`"v1"`}}, 200, "gzip"},
+		{"brotli", "gzip, br", "GET", http.Header{"Content-Type": {"application/json"}}, 200, "br"},
+		{"not accepted", "", "GET", http.Header{"Content-Type": {"text/html"}}, 200, ""},
+		{"already encoded", "gzip", "GET", http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {"gzip"}}, 200, "gzip"},
+		{"binary", "gzip", "GET", http.Header{"Content-Type": {"image/png"}}, 200, ""},
+		{"small", "gzip", "GET", http.Header{"Content-Type": {"text/html"}, "Content-Length": {"100"}}, 200, ""},
+		{"no-transform", "gzip", "GET", http.Header{"Content-Type": {"text/html"}, "Cache-Control": {"no-transform"}}, 200, ""},
+		{"head", "gzip", "HEAD", http.Header{"Content-Type": {"text/html"}}, 200, ""},
+		{"partial", "gzip", "GET", http.Header{"Content-Type": {"text/html"}, "Content-Range": {"bytes 0-9/100"}}, 206, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://app.grok.io/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			cw := newCompressWriter(rec, r)
+			for name, values := range tt.header {
+				cw.Header()[name] = values
+			}
+			cw.WriteHeader(tt.status)
+			_, err := cw.Write([]byte(body))
+			require.NoError(t, err)
+			cw.Flush()
+			cw.finish(true)
+
+			assert.Equal(t, tt.encoding, rec.Header().Get("Content-Encoding"))
+			if tt.header.Get("Content-Encoding") != "" || tt.encoding == "" {
+				if tt.method != "HEAD" {
+					assert.Equal(t, body, rec.Body.String())
+				}
+				return
+			}
+
+			assert.Empty(t, rec.Header().Get("Content-Length"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			if etag := tt.header.Get("ETag"); etag != "" {
+				assert.Equal(t, "W/"+etag, rec.Header().Get("ETag"))
+			}
+			assert.Less(t, rec.Body.Len(), len(body))
+			reader, err := decode[tt.encoding](bytes.NewReader(rec.Body.Bytes()))
+			require.NoError(t, err)
+			decoded, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(decoded))
+		})
+	}
+}
+
+// TestCompressWriter_Incomplete tests that incomplete responses do not end
+// like complete ones.
+func TestCompressWriter_Incomplete(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://app.grok.io/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	cw := newCompressWriter(rec, r)
+	cw.Header().Set("Content-Type", "text/plain")
+	_, err := cw.Write([]byte(strings.Repeat("partial ", 500)))
+	require.NoError(t, err)
+	cw.Flush()
+	cw.finish(false)
+
+	reader, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
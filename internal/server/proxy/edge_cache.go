@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// cacheExchange is the edge cache state of a request to a tunnel that caches
+// its responses. A nil *cacheExchange leaves requests and responses alone.
+type cacheExchange struct {
+	cache      *edgecache.Cache
+	tunnelID   uuid.UUID
+	r          *http.Request
+	status     string           // Cache status for the request log
+	cached     *edgecache.Entry // Entry answering the request, or being revalidated
+	validating bool             // The request carries the validators of cached
+	storing    *edgecache.Entry // Response from the tunnel to store once complete
+	capture    *captureWriter
+}
+
+// beginCache looks r up in the edge cache when tun caches its responses. A
+// stale entry gets its validators added to r, for the tunnel to confirm it.
+func (p *HTTPProxy) beginCache(tun *tunnel.Tunnel, r *http.Request, now time.Time) *cacheExchange {
+	cache := p.tunnelManager.EdgeCache()
+	if cache == nil || !tun.Edge.Cache {
+		return nil
+	}
+
+	x := &cacheExchange{cache: cache, tunnelID: tun.ID, r: r, status: edgecache.StatusBypass}
+	if !edgecache.RequestCacheable(r) {
+		return x
+	}
+	x.status = edgecache.StatusMiss
+	x.cached = cache.Lookup(tun.ID, r)
+	if x.cached != nil && (!x.cached.Fresh(now) || edgecache.RequestRevalidates(r)) {
+		x.validating = x.cached.Validate(r)
+		if !x.validating {
+			x.cached = nil // Fetched again: no validators, or the visitor's own conditions
+		}
+	}
+	return x
+}
+
+// hit returns the response to the request from the cache, or nil when the
+// request goes to the tunnel.
+func (x *cacheExchange) hit(requestID string, now time.Time) *tunnelv1.ProxyResponse {
+	if x == nil || x.cached == nil || x.validating {
+		return nil
+	}
+	x.status = edgecache.StatusHit
+	return x.cachedResponse(requestID, now)
+}
+
+// response takes the response head from the tunnel. A 304 confirming the
+// entry being revalidated is replaced by the entry; responses that may be
+// stored are captured while they are written.
+func (x *cacheExchange) response(head *tunnelv1.ProxyResponse, requestID string, now time.Time) *tunnelv1.ProxyResponse {
+	if x == nil || x.status == edgecache.StatusBypass {
+		return head
+	}
+	resp := head.GetHttp()
+	header := httpHeader(resp.GetHeaders())
+
+	if x.validating && resp.GetStatusCode() == http.StatusNotModified {
+		entry, storable := x.cached.Revalidated(header, now)
+		if storable {
+			x.cache.Store(x.tunnelID, x.r, entry)
+		}
+		x.cached = entry
+		x.status = edgecache.StatusRevalidated
+		return x.cachedResponse(requestID, now)
+	}
+
+	if entry, ok := edgecache.NewEntry(int(resp.GetStatusCode()), header, now); ok {
+		x.storing = entry
+	}
+	return head
+}
+
+// cachedResponse builds the response head carrying the cached entry, or a
+// 304 when the entry satisfies the visitor's conditions.
+func (x *cacheExchange) cachedResponse(requestID string, now time.Time) *tunnelv1.ProxyResponse {
+	header := x.cached.ResponseHeader(now)
+	resp := &tunnelv1.HTTPResponse{StatusCode: int32(x.cached.StatusCode), Body: x.cached.Body}
+	if !x.validating && x.cached.NotModified(x.r) {
+		header.Del("Content-Length")
+		resp.StatusCode = http.StatusNotModified
+		resp.Body = nil
+	}
+	resp.Headers = protoHeaders(header)
+
+	return &tunnelv1.ProxyResponse{
+		RequestId:   requestID,
+		Payload:     &tunnelv1.ProxyResponse_Http{Http: resp},
+		EndOfStream: true,
+	}
+}
+
+// writer returns w, capturing the body of a response to be stored.
+func (x *cacheExchange) writer(w http.ResponseWriter) http.ResponseWriter {
+	if x == nil || x.storing == nil {
+		return w
+	}
+	x.capture = &captureWriter{ResponseWriter: w, limit: x.cache.MaxObjectSize()}
+	return x.capture
+}
+
+// finish stores the captured response if it was written completely.
+func (x *cacheExchange) finish(complete bool) {
+	if x == nil || x.capture == nil || !complete || x.capture.overflow {
+		return
+	}
+	x.storing.Body = x.capture.body.Bytes()
+	x.cache.Store(x.tunnelID, x.r, x.storing)
+}
+
+// cacheStatus returns the cache status of the request, or "" when its tunnel
+// does not cache.
+func (x *cacheExchange) cacheStatus() string {
+	if x == nil {
+		return ""
+	}
+	return x.status
+}
+
+// captureWriter keeps a copy of the body written, up to limit bytes.
+type captureWriter struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool // The body exceeded limit and is not kept
+}
+
+// Write implements http.ResponseWriter.
+func (cw *captureWriter) Write(data []byte) (int, error) {
+	if !cw.overflow {
+		if int64(cw.body.Len()+len(data)) > cw.limit {
+			cw.overflow = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(data)
+		}
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher.
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the visitor's writer, for http.ResponseController.
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// httpHeader converts the headers of a tunnel response.
+func httpHeader(headers map[string]*tunnelv1.HeaderValues) http.Header {
+	header := make(http.Header, len(headers))
+	for name, values := range headers {
+		for _, value := range values.GetValues() {
+			header.Add(name, value)
+		}
+	}
+	return header
+}
+
+// protoHeaders converts headers for a tunnel response.
+func protoHeaders(header http.Header) map[string]*tunnelv1.HeaderValues {
+	headers := make(map[string]*tunnelv1.HeaderValues, len(header))
+	for name, values := range header {
+		headers[name] = &tunnelv1.HeaderValues{Values: append([]string(nil), values...)}
+	}
+	return headers
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// headerVars returns the values of the placeholders in the header rules of
+// tun for request r.
+func headerVars(r *http.Request, tun *tunnel.Tunnel, requestID string) headerrules.Vars {
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	name := tun.Subdomain
+	if tun.SavedName != nil {
+		name = *tun.SavedName
+	}
+	return headerrules.Vars{
+		ClientIP:  clientIP,
+		Tunnel:    name,
+		Subdomain: tun.Subdomain,
+		Host:      r.Host,
+		RequestID: requestID,
+	}
+}
+
+// applyRequestHeaderRules changes the headers of a request forwarded to tun.
+func applyRequestHeaderRules(headers map[string]*tunnelv1.HeaderValues, tun *tunnel.Tunnel, vars headerrules.Vars) {
+	for _, rules := range tun.HeaderRules() {
+		rules.ApplyRequest(headers, vars)
+	}
+}
+
+// applyResponseHeaderRules changes the headers of a response from tun before
+// it is written to the visitor.
+func applyResponseHeaderRules(resp *tunnelv1.HTTPResponse, tun *tunnel.Tunnel, vars headerrules.Vars) {
+	rules := tun.HeaderRules()
+	if resp == nil || len(rules) == 0 {
+		return
+	}
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]*tunnelv1.HeaderValues)
+	}
+	for _, r := range rules {
+		r.ApplyResponse(resp.Headers, vars)
+	}
+}
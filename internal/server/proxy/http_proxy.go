@@ -1,14 +1,17 @@
 package proxy
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +20,8 @@ import (
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/errorpages"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
@@ -35,9 +40,16 @@ var wsServerBufferPool = sync.Pool{
 const (
 	// DefaultRequestTimeout is the default timeout for proxied requests.
 	DefaultRequestTimeout = 30 * time.Second
-	// MaxRequestBodySize is the maximum allowed request body size (10MB).
-	// This prevents memory exhaustion from large request bodies.
-	MaxRequestBodySize = 10 * 1024 * 1024 // 10MB
+	// StreamIdleTimeout is the maximum time to wait between body frames of a streamed response.
+	// Long-poll and SSE endpoints must emit data (or keepalives) at least this often.
+	StreamIdleTimeout = 5 * time.Minute
+	// RequestBodyChunkSize is the size of each body frame sent to the client (64KB).
+	// Request bodies up to this size are sent inline; larger or unknown-length bodies are streamed.
+	RequestBodyChunkSize = 64 * 1024
+	// ResponseChannelBuffer is the number of response frames buffered per request: the head
+	// and a full window of body frames, so frames sent with credit never wait for the writer.
+	// Together with the client chunk size this bounds memory used by a single streamed response.
+	ResponseChannelBuffer = protocol.BodyWindow + 1
 	// CleanupDebounceInterval is the minimum time between cleanup operations per tunnel.
 	// This prevents excessive database queries on high-traffic tunnels.
 	CleanupDebounceInterval = 1 * time.Minute
@@ -78,8 +90,25 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// OIDC login of protected tunnels, on the base domain
+	if guard := p.tunnelManager.AccessGuard(); guard.IsLoginRequest(r) {
+		guard.ServeLogin(w, r)
+		return
+	}
+
 	// Regular tunnel routing
-	tun, err := p.router.RouteToTunnel(r.Host)
+	tun, err := p.router.RouteToTunnel(r)
+	if err == pkgerrors.ErrTunnelNotFound {
+		// A persistent tunnel may be reconnecting: hold the request until it is back
+		tun, err = p.router.WaitForTunnel(r.Context(), r.Host)
+	}
+	if errors.Is(err, context.Canceled) {
+		logger.DebugEvent().
+			Str("host", r.Host).
+			Str("path", r.URL.Path).
+			Msg("Visitor disconnected while tunnel was reconnecting")
+		return
+	}
 	if err != nil {
 		logger.WarnEvent().
 			Err(err).
@@ -90,14 +119,47 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if err == pkgerrors.ErrTunnelNotFound {
 			subdomain := strings.Split(r.Host, ".")[0]
 			errorpages.TunnelNotFound(w, r, subdomain)
+		} else if err == pkgerrors.ErrTunnelReconnecting {
+			subdomain := strings.Split(r.Host, ".")[0]
+			errorpages.TunnelReconnecting(w, r, subdomain, p.tunnelManager.ReconnectGracePeriod())
 		} else {
 			http.Error(w, "Bad gateway", http.StatusBadGateway)
 		}
 		return
 	}
 
+	// Visitors rejected by IP rules get no further, not even to a login
+	if reason := p.tunnelManager.IPFilter().Check(r.RemoteAddr, tun.OrganizationID, tun.IPRules); reason != "" {
+		logger.InfoEvent().
+			Str("tunnel_id", tun.ID.String()).
+			Str("remote_addr", r.RemoteAddr).
+			Str("reason", reason).
+			Msg("Request blocked by IP rules")
+		http.Error(w, "Access denied", http.StatusForbidden)
+		entry := newRequestLog(tun.ID, r, http.StatusForbidden, time.Since(start), 0, 0)
+		entry.Blocked = reason
+		go p.saveRequestLog(entry)
+		return
+	}
+
+	// Nothing reaches the client before the visitor passes the tunnel's access policy
+	if !p.tunnelManager.AccessGuard().Authorize(w, r, access.Scope(tun.Subdomain, tun.UserID), tun.Access) {
+		return
+	}
+
+	// Requests over the limits of the tunnel, its user or organization are turned away
+	if exceeded := p.tunnelManager.RateLimiter().AllowRequest(tun.RateSubject()); exceeded != nil {
+		rejectRateLimited(w, tun, exceeded)
+		return
+	}
+
 	// Update tunnel activity
 	tun.UpdateActivity()
+	defer tun.BeginRequest()()
+
+	if group, ok := p.tunnelManager.GetGroup(tun.Subdomain); ok {
+		setAffinityCookie(w, r, group, tun)
+	}
 
 	// Check if this is a WebSocket upgrade request
 	if isWebSocketUpgrade(r) {
@@ -105,26 +167,67 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Proxy regular HTTP request through tunnel (simple, full response)
-	resp, reqBytes, err := p.proxyRequest(r, tun)
-	if err != nil {
-		logger.ErrorEvent().
-			Err(err).
-			Str("tunnel_id", tun.ID.String()).
-			Str("subdomain", tun.Subdomain).
-			Str("path", r.URL.Path).
-			Msg("Failed to proxy request")
+	// Compress responses for visitors that accept it
+	complete := true
+	if tun.Edge.Compress {
+		cw := newCompressWriter(w, r)
+		defer func() { cw.finish(complete) }()
+		w = cw
+	}
 
-		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
-		return
+	// Proxy regular HTTP request through tunnel, streaming bodies in both directions
+	// (or answer it from the edge cache)
+	requestID := utils.GenerateRequestID()
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
+	cache := p.beginCache(tun, r, start)
+	head := cache.hit(requestID, start)
+	var upload *bodyUpload
+	var reqBytes int64
+	ctx, abort := context.WithCancel(r.Context())
+	defer abort()
+	if head == nil {
+		tun.ResponseMap.Store(requestID, responseCh)
+		defer releaseResponseChannel(tun, requestID, responseCh)
+		tun.Aborts.Store(requestID, abort) // Frames the stream cannot deliver end the request
+		defer tun.Aborts.Delete(requestID)
+
+		head, upload, reqBytes, err = p.proxyRequest(w, r, tun, requestID, responseCh)
+		if errors.Is(err, context.Canceled) {
+			logger.DebugEvent().
+				Str("tunnel_id", tun.ID.String()).
+				Str("path", r.URL.Path).
+				Msg("Visitor disconnected before response")
+			return
+		}
+		if err != nil {
+			logger.ErrorEvent().
+				Err(err).
+				Str("tunnel_id", tun.ID.String()).
+				Str("subdomain", tun.Subdomain).
+				Str("path", r.URL.Path).
+				Msg("Failed to proxy request")
+
+			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		head = cache.response(head, requestID, time.Now())
 	}
 
-	// Write response
-	respBytes := p.writeResponse(w, resp)
-	statusCode := int(resp.StatusCode)
+	applyResponseHeaderRules(head.GetHttp(), tun, headerVars(r, tun, requestID))
+
+	// Write response, streaming remaining body frames as they arrive
+	var respBytes int64
+	respBytes, complete = p.writeResponse(ctx, cache.writer(w), head, responseCh, newBodyCredit(tun, requestID))
+	cache.finish(complete)
+	statusCode := int(head.GetHttp().GetStatusCode())
+	reqBytes += upload.stop()
+	if !complete {
+		cancelRequest(tun, requestID, cancelReason(r.Context()))
+	}
 
 	// Update tunnel statistics
 	tun.UpdateStats(reqBytes, respBytes)
+	p.tunnelManager.RateLimiter().CountBytes(tun.RateSubject(), reqBytes+respBytes)
 
 	// Save stats to database (async to avoid blocking)
 	go func() {
@@ -160,11 +263,14 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Dur("duration", duration).
 			Int64("bytes_in", reqBytes).
 			Int64("bytes_out", respBytes).
+			Str("cache", cache.cacheStatus()).
 			Msg("Request proxied (chunked)")
 	}
 
 	// Save request log to database (async)
-	go p.saveRequestLog(tun.ID, r, statusCode, duration, reqBytes, respBytes)
+	entry := newRequestLog(tun.ID, r, statusCode, duration, reqBytes, respBytes)
+	entry.Cache = cache.cacheStatus()
+	go p.saveRequestLog(entry)
 }
 
 // isWebSocketUpgrade checks if the request is a WebSocket upgrade request.
@@ -173,11 +279,15 @@ func isWebSocketUpgrade(r *http.Request) bool {
 		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
 }
 
-// writeResponse writes complete HTTP response (headers + body) to ResponseWriter.
-// Returns total bytes written.
-func (p *HTTPProxy) writeResponse(w http.ResponseWriter, resp *tunnelv1.HTTPResponse) int64 {
-	// Calculate response size (headers + body)
-	responseBytes := int64(len(resp.Body))
+// writeResponse writes the response head to ResponseWriter and then streams the
+// remaining body frames until end of stream or until ctx (the visitor's request) is done.
+// Body frames written out are returned to the client through credit (nil: no flow control).
+// Returns total bytes written and whether the whole response was delivered.
+func (p *HTTPProxy) writeResponse(ctx context.Context, w http.ResponseWriter, head *tunnelv1.ProxyResponse, responseCh <-chan *tunnelv1.ProxyResponse, credit *bodyCredit) (int64, bool) {
+	resp := head.GetHttp()
+
+	// Calculate header size
+	var responseBytes int64
 	for key, headerVals := range resp.Headers {
 		for _, val := range headerVals.Values {
 			responseBytes += int64(len(key) + len(val) + 4) // key: value\r\n
@@ -195,34 +305,321 @@ func (p *HTTPProxy) writeResponse(w http.ResponseWriter, resp *tunnelv1.HTTPResp
 	// Write status code
 	w.WriteHeader(int(resp.StatusCode))
 
-	// Write body
-	if len(resp.Body) > 0 {
-		if _, err := io.Copy(w, bytes.NewReader(resp.Body)); err != nil {
-			logger.ErrorEvent().
+	flusher, _ := w.(http.Flusher)
+	writeChunk := func(data []byte) bool {
+		if len(data) == 0 {
+			return true
+		}
+		n, err := w.Write(data)
+		responseBytes += int64(n)
+		if err != nil {
+			logger.DebugEvent().
 				Err(err).
+				Str("request_id", head.RequestId).
 				Msg("Failed to write response body")
+			return false
 		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
 	}
 
-	return responseBytes
+	// Write body carried by the head frame (complete body for buffered responses)
+	if !writeChunk(resp.Body) {
+		return responseBytes, false
+	}
+	if head.EndOfStream {
+		setTrailers(w, resp.Trailers)
+		return responseBytes, true
+	}
+	if flusher != nil {
+		flusher.Flush() // Send headers immediately for streamed responses (SSE, long-poll)
+	}
+
+	// Stream remaining body frames
+	idle := time.NewTimer(StreamIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case frame, ok := <-responseCh:
+			if !ok {
+				logger.WarnEvent().
+					Str("request_id", head.RequestId).
+					Msg("Tunnel closed while streaming response")
+				return responseBytes, false
+			}
+
+			if !writeChunk(frame.GetBody().GetData()) {
+				return responseBytes, false
+			}
+			if frame.EndOfStream {
+				setTrailers(w, frame.GetBody().GetTrailers())
+				return responseBytes, true
+			}
+			credit.consumed()
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(StreamIdleTimeout)
+
+		case <-idle.C:
+			logger.WarnEvent().
+				Str("request_id", head.RequestId).
+				Dur("idle_timeout", StreamIdleTimeout).
+				Msg("Response stream idle, closing")
+			return responseBytes, false
+
+		case <-ctx.Done():
+			logger.DebugEvent().
+				Str("request_id", head.RequestId).
+				Msg("Visitor disconnected or request aborted while streaming response")
+			return responseBytes, false
+		}
+	}
 }
 
-// proxyRequestChunked proxies request and streams response chunks directly to client.
-// Returns: (requestBytes, responseBytes, statusCode, error).
-// proxyRequest proxies a single HTTP request through the tunnel (simple, non-chunked).
-// Returns: (httpResponse, requestBytes, error).
-func (p *HTTPProxy) proxyRequest(r *http.Request, tun *tunnel.Tunnel) (*tunnelv1.HTTPResponse, int64, error) {
-	// Generate request ID
-	requestID := utils.GenerateRequestID()
+// setTrailers sets the trailers of a response whose body has been written.
+// They are sent when the handler returns (HTTP/2, or chunked HTTP/1.1 responses).
+func setTrailers(w http.ResponseWriter, trailers map[string]*tunnelv1.HeaderValues) {
+	for key, values := range trailers {
+		for _, val := range values.GetValues() {
+			w.Header().Add(http.TrailerPrefix+key, val)
+		}
+	}
+}
 
-	// Read request body
-	body, err := io.ReadAll(r.Body)
+// releaseResponseChannel unregisters a response channel and drains frames still in flight
+// so the gRPC receive loop never blocks on a request that is no longer being served.
+func releaseResponseChannel(tun *tunnel.Tunnel, requestID string, responseCh chan *tunnelv1.ProxyResponse) {
+	tun.ResponseMap.Delete(requestID)
+
+	go func() {
+		for {
+			select {
+			case _, ok := <-responseCh:
+				if !ok {
+					return
+				}
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+}
+
+// cancelRequest tells the client to stop working on a request nobody is waiting for anymore.
+// Clients without FEATURE_CANCEL keep the old behaviour and finish the request.
+func cancelRequest(tun *tunnel.Tunnel, requestID string, reason tunnelv1.CancelRequest_Reason) {
+	if !tun.Features.Has(tunnelv1.Feature_FEATURE_CANCEL) {
+		return
+	}
+
+	msg := &tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Request{
+			Request: &tunnelv1.ProxyRequest{
+				RequestId: requestID,
+				TunnelId:  tun.ID.String(),
+				Payload:   &tunnelv1.ProxyRequest_Cancel{Cancel: &tunnelv1.CancelRequest{Reason: reason}},
+			},
+		},
+	}
+
+	tun.StreamMu.Lock()
+	err := tun.Stream.SendMsg(msg)
+	tun.StreamMu.Unlock()
 	if err != nil {
-		return nil, 0, pkgerrors.Wrap(err, "failed to read request body")
+		logger.DebugEvent().
+			Err(err).
+			Str("request_id", requestID).
+			Msg("Failed to send cancel to tunnel")
+		return
 	}
-	defer r.Body.Close()
 
-	// Calculate request size (headers + body)
+	logger.DebugEvent().
+		Str("request_id", requestID).
+		Str("reason", reason.String()).
+		Msg("Canceled request on tunnel client")
+}
+
+// bodyCredit returns the response body frames of a request written out to the
+// visitor as send credit to a client with FEATURE_BODY_FLOW_CONTROL.
+// A nil bodyCredit does nothing.
+type bodyCredit struct {
+	tun       *tunnel.Tunnel
+	requestID string
+	credit    *protocol.Credit
+}
+
+// newBodyCredit returns the credit of a response, or nil when the client sends without credit.
+func newBodyCredit(tun *tunnel.Tunnel, requestID string) *bodyCredit {
+	if !tun.Features.Has(tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL) {
+		return nil
+	}
+	return &bodyCredit{tun: tun, requestID: requestID, credit: protocol.NewCredit(protocol.BodyWindow)}
+}
+
+// consumed records a body frame written out and sends a window update once one is due.
+func (c *bodyCredit) consumed() {
+	if c == nil {
+		return
+	}
+	grant := c.credit.Consumed(1)
+	if grant == 0 {
+		return
+	}
+
+	msg := &tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Request{
+			Request: &tunnelv1.ProxyRequest{
+				RequestId: c.requestID,
+				TunnelId:  c.tun.ID.String(),
+				Payload:   &tunnelv1.ProxyRequest_Body{Body: &tunnelv1.BodyChunk{WindowUpdate: grant}},
+			},
+		},
+	}
+
+	c.tun.StreamMu.Lock()
+	err := c.tun.Stream.SendMsg(msg)
+	c.tun.StreamMu.Unlock()
+	if err != nil {
+		logger.DebugEvent().
+			Err(err).
+			Str("request_id", c.requestID).
+			Msg("Failed to send body window update")
+	}
+}
+
+// cancelReason tells a visitor that went away apart from a server-side timeout.
+func cancelReason(ctx context.Context) tunnelv1.CancelRequest_Reason {
+	if ctx.Err() != nil {
+		return tunnelv1.CancelRequest_CLIENT_DISCONNECTED
+	}
+	return tunnelv1.CancelRequest_TIMEOUT
+}
+
+// bodyUpload streams a public request body to the tunnel client as BodyChunk frames.
+type bodyUpload struct {
+	rc      *http.ResponseController
+	body    io.ReadCloser
+	trailer *http.Header     // Filled in by the server once the body is read
+	window  *protocol.Window // Frames the client has room for (nil: no flow control)
+	done    chan struct{}
+	bytes   atomic.Int64
+	err     error
+}
+
+// run reads the request body and sends it in frames of up to RequestBodyChunkSize,
+// as soon as data arrives so that streamed messages (gRPC) are not held back.
+// The last frame carries EndOfStream and the request trailers. A body that
+// cannot be read to the end (e.g. the visitor went away) is never marked
+// complete: the request is aborted instead.
+func (u *bodyUpload) run(tun *tunnel.Tunnel, requestID string) {
+	defer close(u.done)
+	if u.window != nil {
+		defer tun.SendWindows.Delete(requestID)
+	}
+
+	buf := make([]byte, RequestBodyChunkSize)
+	for {
+		n, readErr := u.body.Read(buf)
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			u.err = pkgerrors.Wrap(readErr, "failed to read request body")
+			tun.Abort(requestID)
+			return
+		}
+		eof := readErr != nil
+		if n == 0 && !eof {
+			continue
+		}
+		chunk := &tunnelv1.BodyChunk{Data: make([]byte, n)}
+		copy(chunk.Data, buf[:n])
+		if eof && len(*u.trailer) > 0 {
+			chunk.Trailers = protoHeaders(*u.trailer)
+		}
+
+		// Never send more frames than the client has room for
+		if _, err := u.window.Acquire(context.Background(), 1); err != nil {
+			return // Stopped while waiting for credit
+		}
+
+		msg := &tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Request{
+				Request: &tunnelv1.ProxyRequest{
+					RequestId:   requestID,
+					TunnelId:    tun.ID.String(),
+					Payload:     &tunnelv1.ProxyRequest_Body{Body: chunk},
+					EndOfStream: eof,
+				},
+			},
+		}
+
+		tun.StreamMu.Lock()
+		err := tun.Stream.SendMsg(msg)
+		tun.StreamMu.Unlock()
+		if err != nil {
+			u.err = pkgerrors.Wrap(err, "failed to send request body to tunnel")
+			return
+		}
+		u.bytes.Add(int64(n))
+
+		if eof {
+			return
+		}
+	}
+}
+
+// stop interrupts an upload that is still reading (the response already finished),
+// waits for it to exit and returns the number of body bytes sent. Safe on nil.
+func (u *bodyUpload) stop() int64 {
+	if u == nil {
+		return 0
+	}
+
+	select {
+	case <-u.done:
+	default:
+		u.window.Close()
+		_ = u.rc.SetReadDeadline(time.Now())
+		<-u.done
+	}
+	return u.bytes.Load()
+}
+
+// doneCh returns a channel closed when the upload finishes, or nil when there is no upload.
+func (u *bodyUpload) doneCh() <-chan struct{} {
+	if u == nil {
+		return nil
+	}
+	return u.done
+}
+
+// proxyRequest sends an HTTP request through the tunnel and waits for the response head.
+// Small bodies with a known length (or any body, for clients without streaming support)
+// are sent inline; other bodies are streamed in BodyChunk frames by a background upload that keeps running while the response is written.
+// Returns: (response head, body upload or nil, requestBytes, error).
+func (p *HTTPProxy) proxyRequest(w http.ResponseWriter, r *http.Request, tun *tunnel.Tunnel, requestID string, responseCh <-chan *tunnelv1.ProxyResponse) (*tunnelv1.ProxyResponse, *bodyUpload, int64, error) {
+	// Clients without streaming support get the whole body inline
+	streaming := (r.ContentLength < 0 || r.ContentLength > RequestBodyChunkSize) &&
+		tun.Features.Has(tunnelv1.Feature_FEATURE_STREAMING_BODY)
+
+	// Read small request bodies inline, with their trailers
+	var body []byte
+	var trailers map[string]*tunnelv1.HeaderValues
+	if !streaming {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, nil, 0, pkgerrors.Wrap(err, "failed to read request body")
+		}
+		if len(r.Trailer) > 0 {
+			trailers = protoHeaders(r.Trailer)
+		}
+	}
+
+	// Calculate request size (headers + inline body); streamed bytes are counted by the upload
 	requestBytes := int64(len(body))
 	for key, values := range r.Header {
 		for _, val := range values {
@@ -239,6 +636,13 @@ func (p *HTTPProxy) proxyRequest(r *http.Request, tun *tunnel.Tunnel) (*tunnelv1
 		}
 	}
 
+	// Trailers announced by the visitor, which the server takes out of the headers
+	if len(r.Trailer) > 0 {
+		headers["Trailer"] = &tunnelv1.HeaderValues{
+			Values: []string{strings.Join(slices.Sorted(maps.Keys(r.Trailer)), ", ")},
+		}
+	}
+
 	// Add X-Forwarded-* headers for proper proxy behavior
 	// X-Forwarded-For: Client IP address
 	clientIP := r.RemoteAddr
@@ -270,27 +674,27 @@ func (p *HTTPProxy) proxyRequest(r *http.Request, tun *tunnel.Tunnel) (*tunnelv1
 		Values: []string{proto},
 	}
 
+	// Header rules of the tunnel apply last, so they can change the headers above
+	applyRequestHeaderRules(headers, tun, headerVars(r, tun, requestID))
+
 	// Create proxy request
 	proxyReq := &tunnelv1.ProxyRequest{
 		RequestId: requestID,
 		TunnelId:  tun.ID.String(),
 		Payload: &tunnelv1.ProxyRequest_Http{
 			Http: &tunnelv1.HTTPRequest{
-				Method:      r.Method,
-				Path:        r.URL.Path,
-				Headers:     headers,
-				Body:        body,
-				QueryString: r.URL.RawQuery,
-				RemoteAddr:  r.RemoteAddr,
+				Method:        r.Method,
+				Path:          r.URL.Path,
+				Headers:       headers,
+				Body:          body,
+				QueryString:   r.URL.RawQuery,
+				RemoteAddr:    r.RemoteAddr,
+				StreamingBody: streaming,
+				Trailers:      trailers,
 			},
 		},
 	}
 
-	// Create response channel
-	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
-	tun.ResponseMap.Store(requestID, responseCh)
-	defer tun.ResponseMap.Delete(requestID)
-
 	// Send request to tunnel via gRPC stream (mutex protects concurrent sends)
 	proxyMsg := &tunnelv1.ProxyMessage{
 		Message: &tunnelv1.ProxyMessage_Request{
@@ -299,26 +703,74 @@ func (p *HTTPProxy) proxyRequest(r *http.Request, tun *tunnel.Tunnel) (*tunnelv1
 	}
 
 	tun.StreamMu.Lock()
-	err = tun.Stream.SendMsg(proxyMsg)
+	err := tun.Stream.SendMsg(proxyMsg)
 	tun.StreamMu.Unlock()
 	if err != nil {
-		return nil, 0, pkgerrors.Wrap(err, "failed to send request to tunnel")
+		return nil, nil, 0, pkgerrors.Wrap(err, "failed to send request to tunnel")
 	}
 
-	// Wait for response with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
-	defer cancel()
+	// Stream large bodies in the background. Server read/write deadlines are lifted so
+	// long uploads and long-lived responses (SSE, long-poll) are not cut off.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
 
-	select {
-	case proxyResp := <-responseCh:
-		// Got response
-		if httpResp := proxyResp.GetHttp(); httpResp != nil {
-			return httpResp, requestBytes, nil
+	var upload *bodyUpload
+	if streaming {
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.EnableFullDuplex()
+
+		upload = &bodyUpload{rc: rc, body: r.Body, trailer: &r.Trailer, done: make(chan struct{})}
+		if tun.Features.Has(tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL) {
+			upload.window = protocol.NewWindow(protocol.BodyWindow)
+			tun.SendWindows.Store(requestID, upload.window)
 		}
-		return nil, 0, pkgerrors.NewAppError("INVALID_RESPONSE", "invalid response type", nil)
+		go upload.run(tun, requestID)
+	}
 
-	case <-ctx.Done():
-		return nil, 0, pkgerrors.ErrRequestTimeout
+	// Wait for response head. The timeout only starts once the request body is fully sent.
+	timeout := time.NewTimer(DefaultRequestTimeout)
+	defer timeout.Stop()
+	uploadDone := upload.doneCh()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// The visitor went away; stop the local handler as well
+			upload.stop()
+			cancelRequest(tun, requestID, tunnelv1.CancelRequest_CLIENT_DISCONNECTED)
+			return nil, nil, 0, r.Context().Err()
+
+		case proxyResp, ok := <-responseCh:
+			if !ok {
+				upload.stop()
+				return nil, nil, 0, pkgerrors.ErrTunnelNotFound
+			}
+			if proxyResp.GetHttp() != nil {
+				return proxyResp, upload, requestBytes, nil
+			}
+			upload.stop()
+			return nil, nil, 0, pkgerrors.NewAppError("INVALID_RESPONSE", "invalid response type", nil)
+
+		case <-uploadDone:
+			uploadDone = nil
+			if upload.err != nil {
+				cancelRequest(tun, requestID, cancelReason(r.Context()))
+				return nil, nil, 0, upload.err
+			}
+			if !timeout.Stop() {
+				<-timeout.C
+			}
+			timeout.Reset(DefaultRequestTimeout)
+
+		case <-timeout.C:
+			if uploadDone != nil {
+				// Still uploading; keep waiting
+				timeout.Reset(DefaultRequestTimeout)
+				continue
+			}
+			cancelRequest(tun, requestID, tunnelv1.CancelRequest_TIMEOUT)
+			return nil, nil, 0, pkgerrors.ErrRequestTimeout
+		}
 	}
 }
 
@@ -472,19 +924,15 @@ func (p *HTTPProxy) logWebhookEvent(appID uuid.UUID, r *http.Request, result *Br
 		Msg("Webhook event logged")
 }
 
-// saveRequestLog saves HTTP request log to database.
-func (p *HTTPProxy) saveRequestLog(tunnelID uuid.UUID, r *http.Request, statusCode int, duration time.Duration, bytesIn, bytesOut int64) {
-	if p.db == nil {
-		return
-	}
-
+// newRequestLog builds the request log entry of an HTTP request.
+func newRequestLog(tunnelID uuid.UUID, r *http.Request, statusCode int, duration time.Duration, bytesIn, bytesOut int64) *models.RequestLog {
 	// Build full path with query parameters
 	fullPath := r.URL.Path
 	if r.URL.RawQuery != "" {
 		fullPath = r.URL.Path + "?" + r.URL.RawQuery
 	}
 
-	requestLog := &models.RequestLog{
+	return &models.RequestLog{
 		TunnelID:   tunnelID,
 		Method:     r.Method,
 		Path:       fullPath,
@@ -494,15 +942,33 @@ func (p *HTTPProxy) saveRequestLog(tunnelID uuid.UUID, r *http.Request, statusCo
 		BytesOut:   int(bytesOut),
 		ClientIP:   r.RemoteAddr,
 	}
+}
+
+// LogBlockedConnection records a TCP, TLS or UDP connection rejected by IP
+// rules in the request log of its tunnel, with method naming its kind.
+func (p *HTTPProxy) LogBlockedConnection(tunnelID uuid.UUID, method, remoteAddr, reason string) {
+	p.saveRequestLog(&models.RequestLog{
+		TunnelID: tunnelID,
+		Method:   method,
+		ClientIP: remoteAddr,
+		Blocked:  reason,
+	})
+}
+
+// saveRequestLog saves a request log entry to the database.
+func (p *HTTPProxy) saveRequestLog(requestLog *models.RequestLog) {
+	if p.db == nil {
+		return
+	}
 
 	if err := p.db.Create(requestLog).Error; err != nil {
 		logger.WarnEvent().
 			Err(err).
-			Str("tunnel_id", tunnelID.String()).
+			Str("tunnel_id", requestLog.TunnelID.String()).
 			Msg("Failed to save request log")
 	} else {
 		// Cleanup old request logs if limit exceeded
-		p.cleanupOldRequestLogs(tunnelID)
+		p.cleanupOldRequestLogs(requestLog.TunnelID)
 	}
 }
 
@@ -604,10 +1070,13 @@ func (p *HTTPProxy) handleWebSocketProxy(w http.ResponseWriter, r *http.Request,
 
 	upgradeResp, err := p.waitForWebSocketUpgradeResponse(requestID, responseCh)
 	if err != nil {
+		cancelRequest(tun, requestID, tunnelv1.CancelRequest_TIMEOUT)
 		return
 	}
+	applyResponseHeaderRules(upgradeResp, tun, headerVars(r, tun, requestID))
 
 	if err := p.writeWebSocketUpgradeResponse(conn, upgradeResp); err != nil {
+		cancelRequest(tun, requestID, tunnelv1.CancelRequest_CLIENT_DISCONNECTED)
 		return
 	}
 
@@ -652,6 +1121,7 @@ func (p *HTTPProxy) sendWebSocketUpgradeRequest(r *http.Request, tun *tunnel.Tun
 	for key, values := range r.Header {
 		headers[key] = &tunnelv1.HeaderValues{Values: values}
 	}
+	applyRequestHeaderRules(headers, tun, headerVars(r, tun, requestID))
 
 	upgradeReq := &tunnelv1.ProxyRequest{
 		RequestId: requestID,
@@ -747,13 +1217,30 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 		close(wsCh)
 	}()
 
+	// With flow control, reads are limited by the credit the client has returned,
+	// and written data is acknowledged so the client can send more
+	var window *protocol.Window
+	var credit *protocol.Credit
+	if tun.Features.Has(tunnelv1.Feature_FEATURE_TCP_FLOW_CONTROL) {
+		window = protocol.NewWindow(protocol.DefaultWindow)
+		credit = protocol.NewCredit(protocol.DefaultWindow)
+		tun.SendWindows.Store(requestID, window)
+		defer tun.SendWindows.Delete(requestID)
+	}
+	ctx, cancel := context.WithCancel(context.Background()) // Ends bandwidth waits with the connection
+	stop := func() {
+		close(done)
+		cancel()
+		window.Close()
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	// Client -> Tunnel (read from HTTP connection, send to gRPC stream)
 	go func() {
 		defer wg.Done()
-		defer closeOnce.Do(func() { close(done) })
+		defer closeOnce.Do(stop)
 
 		// Get buffer from pool
 		bufPtr := wsServerBufferPool.Get().(*[]byte) //nolint:errcheck // sync.Pool.Get() doesn't return error
@@ -763,7 +1250,14 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 		sequence := int64(0)
 
 		for {
-			n, err := conn.Read(buffer)
+			// Never read more than the client has room for
+			size, err := window.Acquire(context.Background(), len(buffer))
+			if err != nil {
+				return
+			}
+
+			n, err := conn.Read(buffer[:size])
+			window.Release(size - n)
 			if err != nil {
 				if err != io.EOF {
 					logger.DebugEvent().Err(err).Msg("Client connection read error")
@@ -772,6 +1266,10 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 			}
 
 			if n > 0 {
+				if !waitBandwidth(ctx, p.tunnelManager.RateLimiter(), tun, n) {
+					return
+				}
+
 				// CRITICAL: Must copy buffer data before sending to avoid data corruption
 				// when buffer is reused in next Read() iteration
 				tcpData := &tunnelv1.TCPData{
@@ -810,7 +1308,7 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 	// Tunnel -> Client (receive from gRPC stream, write to HTTP connection)
 	go func() {
 		defer wg.Done()
-		defer closeOnce.Do(func() { close(done) })
+		defer closeOnce.Do(stop)
 
 		idleTimeout := 5 * time.Minute
 		timer := time.NewTimer(idleTimeout)
@@ -823,6 +1321,9 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 					return
 				}
 				if len(data) > 0 {
+					if !waitBandwidth(ctx, p.tunnelManager.RateLimiter(), tun, len(data)) {
+						return
+					}
 					if _, err := conn.Write(data); err != nil {
 						logger.ErrorEvent().Err(err).Msg("Failed to write WebSocket data to client")
 						return
@@ -830,6 +1331,14 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 
 					// Update stats
 					tun.UpdateStats(0, int64(len(data)))
+
+					// Let the client send more once enough has been written out
+					if grant := credit.Consumed(len(data)); grant > 0 {
+						if err := sendWindowUpdate(tun, requestID, grant); err != nil {
+							logger.ErrorEvent().Err(err).Msg("Failed to send WebSocket window update")
+							return
+						}
+					}
 				}
 				// Reset idle timer on data received
 				if !timer.Stop() {
@@ -850,6 +1359,9 @@ func (p *HTTPProxy) proxyWebSocketData(conn net.Conn, tun *tunnel.Tunnel, reques
 
 	wg.Wait()
 
+	// Close the local WebSocket too, in case the visitor side ended first
+	cancelRequest(tun, requestID, tunnelv1.CancelRequest_CLIENT_DISCONNECTED)
+
 	logger.InfoEvent().
 		Str("request_id", requestID).
 		Str("tunnel_id", tun.ID.String()).
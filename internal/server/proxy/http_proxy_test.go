@@ -0,0 +1,935 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// recordingStream is a grpc.ServerStream that records sent proxy requests
+// and control messages.
+type recordingStream struct {
+	grpc.ServerStream
+	mu       sync.Mutex
+	sent     []*tunnelv1.ProxyRequest
+	controls []*tunnelv1.ControlMessage
+	onSend   func(req *tunnelv1.ProxyRequest)
+}
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	if ctrl := m.(*tunnelv1.ProxyMessage).GetControl(); ctrl != nil {
+		s.mu.Lock()
+		s.controls = append(s.controls, ctrl)
+		s.mu.Unlock()
+		return nil
+	}
+	req := m.(*tunnelv1.ProxyMessage).GetRequest()
+	s.mu.Lock()
+	s.sent = append(s.sent, req)
+	s.mu.Unlock()
+	if s.onSend != nil {
+		s.onSend(req)
+	}
+	return nil
+}
+
+// TestHTTPProxy_WriteResponse_StreamsBodyFrames tests that body frames following the head are written in order.
+func TestHTTPProxy_WriteResponse_StreamsBodyFrames(t *testing.T) {
+	p := &HTTPProxy{}
+
+	head := &tunnelv1.ProxyResponse{
+		RequestId: "req-1",
+		Payload: &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{
+			StatusCode: 200,
+			Headers:    map[string]*tunnelv1.HeaderValues{"Content-Type": {Values: []string{"text/event-stream"}}},
+		}},
+	}
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, 3)
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte("data: one\n\n")}}}
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte("data: two\n\n")}}}
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{}}, EndOfStream: true}
+
+	w := httptest.NewRecorder()
+	written, complete := p.writeResponse(context.Background(), w, head, responseCh, nil)
+	assert.True(t, complete)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: one\n\ndata: two\n\n", w.Body.String())
+	assert.True(t, w.Flushed)
+	assert.Greater(t, written, int64(w.Body.Len()))
+	assert.Empty(t, responseCh)
+}
+
+// TestHTTPProxy_WriteResponse_Trailers tests that trailers of the last frame
+// are set on the response, whether it is the head or a body frame.
+func TestHTTPProxy_WriteResponse_Trailers(t *testing.T) {
+	p := &HTTPProxy{}
+	trailers := map[string]*tunnelv1.HeaderValues{"Grpc-Status": {Values: []string{"0"}}}
+
+	head := &tunnelv1.ProxyResponse{
+		Payload: &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{
+			StatusCode: 200,
+			Headers:    map[string]*tunnelv1.HeaderValues{"Content-Type": {Values: []string{"application/grpc"}}},
+		}},
+	}
+	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
+	responseCh <- &tunnelv1.ProxyResponse{
+		Payload:     &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte("message"), Trailers: trailers}},
+		EndOfStream: true,
+	}
+
+	w := httptest.NewRecorder()
+	_, complete := p.writeResponse(context.Background(), w, head, responseCh, nil)
+	assert.True(t, complete)
+	assert.Equal(t, "message", w.Body.String())
+	assert.Equal(t, "0", w.Result().Trailer.Get("Grpc-Status"))
+
+	head = &tunnelv1.ProxyResponse{
+		Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200, Body: []byte("message"), Trailers: trailers}},
+		EndOfStream: true,
+	}
+	w = httptest.NewRecorder()
+	_, complete = p.writeResponse(context.Background(), w, head, nil, nil)
+	assert.True(t, complete)
+	assert.Equal(t, "0", w.Result().Trailer.Get("Grpc-Status"))
+}
+
+// TestHTTPProxy_WriteResponse_ReturnsCredit tests that body frames written out are
+// returned to a client with body flow control in batched window updates.
+func TestHTTPProxy_WriteResponse_ReturnsCredit(t *testing.T) {
+	p := &HTTPProxy{}
+	stream := &recordingStream{}
+	tun := &tunnel.Tunnel{
+		ID:       uuid.New(),
+		Stream:   stream,
+		Features: protocol.Features{tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL: {}},
+	}
+
+	head := &tunnelv1.ProxyResponse{
+		RequestId: "req-1",
+		Payload:   &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+	}
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
+	for i := 0; i < protocol.BodyWindow; i++ {
+		responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte("x")}}}
+	}
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{}}, EndOfStream: true}
+
+	_, complete := p.writeResponse(context.Background(), httptest.NewRecorder(), head, responseCh, newBodyCredit(tun, "req-1"))
+	assert.True(t, complete)
+
+	var returned uint32
+	for _, req := range stream.sent {
+		assert.Equal(t, "req-1", req.RequestId)
+		assert.Empty(t, req.GetBody().GetData())
+		returned += req.GetBody().GetWindowUpdate()
+	}
+	assert.Greater(t, len(stream.sent), 1, "credit should be returned in several updates")
+	assert.Equal(t, uint32(protocol.BodyWindow), returned)
+
+	assert.Nil(t, newBodyCredit(&tunnel.Tunnel{}, "req-2"), "clients without flow control get no credit")
+}
+
+// TestHTTPProxy_ProxyRequest_StreamsLargeBody tests that large request bodies are sent as ordered body frames.
+func TestHTTPProxy_ProxyRequest_StreamsLargeBody(t *testing.T) {
+	p := &HTTPProxy{}
+	body := strings.Repeat("x", 3*RequestBodyChunkSize+100)
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
+	stream := &recordingStream{
+		onSend: func(req *tunnelv1.ProxyRequest) {
+			// Reply once the whole body has been received
+			if req.EndOfStream {
+				responseCh <- &tunnelv1.ProxyResponse{
+					RequestId:   req.RequestId,
+					Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 201}},
+					EndOfStream: true,
+				}
+			}
+		},
+	}
+	tun := &tunnel.Tunnel{
+		ID:       uuid.New(),
+		Stream:   stream,
+		Features: protocol.Features{tunnelv1.Feature_FEATURE_STREAMING_BODY: {}},
+	}
+
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/upload", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	head, upload, _, err := p.proxyRequest(w, r, tun, "req-1", responseCh)
+	require.NoError(t, err)
+	require.NotNil(t, upload)
+	assert.Equal(t, int32(201), head.GetHttp().GetStatusCode())
+	assert.Equal(t, int64(len(body)), upload.stop())
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	require.Greater(t, len(stream.sent), 2)
+	assert.True(t, stream.sent[0].GetHttp().GetStreamingBody())
+	assert.Empty(t, stream.sent[0].GetHttp().GetBody())
+
+	var received bytes.Buffer
+	for i, req := range stream.sent[1:] {
+		require.NotNil(t, req.GetBody(), "frame %d should be a body frame", i)
+		received.Write(req.GetBody().GetData())
+		assert.Equal(t, i == len(stream.sent)-2, req.EndOfStream)
+	}
+	assert.Equal(t, body, received.String())
+}
+
+// TestHTTPProxy_ProxyRequest_UploadWaitsForCredit tests that a client with body flow
+// control is never sent more request body frames than it returned credit for.
+func TestHTTPProxy_ProxyRequest_UploadWaitsForCredit(t *testing.T) {
+	p := &HTTPProxy{}
+	body := strings.Repeat("x", (protocol.BodyWindow+4)*RequestBodyChunkSize)
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
+	var bodyFrames atomic.Int64
+	stream := &recordingStream{
+		onSend: func(req *tunnelv1.ProxyRequest) {
+			if req.GetBody() != nil {
+				bodyFrames.Add(1)
+			}
+			if req.EndOfStream {
+				responseCh <- &tunnelv1.ProxyResponse{
+					Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 201}},
+					EndOfStream: true,
+				}
+			}
+		},
+	}
+	tun := &tunnel.Tunnel{
+		ID:     uuid.New(),
+		Stream: stream,
+		Features: protocol.Features{
+			tunnelv1.Feature_FEATURE_STREAMING_BODY:    {},
+			tunnelv1.Feature_FEATURE_BODY_FLOW_CONTROL: {},
+		},
+	}
+	sentFrames := func() int { return int(bodyFrames.Load()) }
+
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/upload", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	type result struct {
+		upload *bodyUpload
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, upload, _, err := p.proxyRequest(w, r, tun, "req-1", responseCh)
+		done <- result{upload, err}
+	}()
+
+	require.Eventually(t, func() bool { return sentFrames() == protocol.BodyWindow }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, protocol.BodyWindow, sentFrames(), "upload should wait for credit")
+
+	window, ok := tun.SendWindows.Load("req-1")
+	require.True(t, ok)
+	window.(*protocol.Window).Release(protocol.BodyWindow)
+
+	select {
+	case res := <-done:
+		require.NoError(t, res.err)
+		assert.Equal(t, int64(len(body)), res.upload.stop())
+	case <-time.After(time.Second):
+		t.Fatal("upload did not resume after a window update")
+	}
+	_, ok = tun.SendWindows.Load("req-1")
+	assert.False(t, ok, "window should be removed once the upload ends")
+}
+
+// TestHTTPProxy_ProxyRequest_StreamsTrailers tests that request trailers are
+// announced with the headers and sent on the last body frame.
+func TestHTTPProxy_ProxyRequest_StreamsTrailers(t *testing.T) {
+	p := &HTTPProxy{}
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
+	stream := &recordingStream{
+		onSend: func(req *tunnelv1.ProxyRequest) {
+			if req.EndOfStream {
+				responseCh <- &tunnelv1.ProxyResponse{
+					Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+					EndOfStream: true,
+				}
+			}
+		},
+	}
+	tun := &tunnel.Tunnel{
+		ID:       uuid.New(),
+		Stream:   stream,
+		Features: protocol.Features{tunnelv1.Feature_FEATURE_STREAMING_BODY: {}},
+	}
+
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/upload", strings.NewReader("streamed"))
+	r.ContentLength = -1
+	r.Trailer = map[string][]string{"Checksum": {"abc"}} // As read by the server with the body
+	w := httptest.NewRecorder()
+
+	_, upload, _, err := p.proxyRequest(w, r, tun, "req-1", responseCh)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("streamed")), upload.stop())
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	assert.Equal(t, []string{"Checksum"}, stream.sent[0].GetHttp().GetHeaders()["Trailer"].GetValues())
+	last := stream.sent[len(stream.sent)-1]
+	require.True(t, last.EndOfStream)
+	assert.Equal(t, []string{"abc"}, last.GetBody().GetTrailers()["Checksum"].GetValues())
+	for _, req := range stream.sent[1 : len(stream.sent)-1] {
+		assert.Empty(t, req.GetBody().GetTrailers())
+	}
+}
+
+// TestHTTPProxy_ProxyRequest_SmallBodyInline tests that small bodies with known length stay inline.
+func TestHTTPProxy_ProxyRequest_SmallBodyInline(t *testing.T) {
+	p := &HTTPProxy{}
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
+	responseCh <- &tunnelv1.ProxyResponse{
+		Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+		EndOfStream: true,
+	}
+	stream := &recordingStream{}
+	tun := &tunnel.Tunnel{ID: uuid.New(), Stream: stream}
+
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/form", strings.NewReader("a=1"))
+	w := httptest.NewRecorder()
+
+	_, upload, _, err := p.proxyRequest(w, r, tun, "req-1", responseCh)
+	require.NoError(t, err)
+	assert.Nil(t, upload)
+
+	require.Len(t, stream.sent, 1)
+	assert.False(t, stream.sent[0].GetHttp().GetStreamingBody())
+	assert.Equal(t, "a=1", string(stream.sent[0].GetHttp().GetBody()))
+}
+
+// TestHTTPProxy_ProxyRequest_LegacyClientInline tests that clients without streaming support get large bodies inline.
+func TestHTTPProxy_ProxyRequest_LegacyClientInline(t *testing.T) {
+	p := &HTTPProxy{}
+	body := strings.Repeat("x", 2*RequestBodyChunkSize)
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
+	responseCh <- &tunnelv1.ProxyResponse{
+		Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+		EndOfStream: true,
+	}
+	stream := &recordingStream{}
+	tun := &tunnel.Tunnel{ID: uuid.New(), Stream: stream}
+
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/upload", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	_, upload, _, err := p.proxyRequest(w, r, tun, "req-1", responseCh)
+	require.NoError(t, err)
+	assert.Nil(t, upload)
+
+	require.Len(t, stream.sent, 1)
+	assert.False(t, stream.sent[0].GetHttp().GetStreamingBody())
+	assert.Len(t, stream.sent[0].GetHttp().GetBody(), len(body))
+}
+
+// TestHTTPProxy_ProxyRequest_CancelOnDisconnect tests that the client is told to cancel when the visitor goes away.
+func TestHTTPProxy_ProxyRequest_CancelOnDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		features   protocol.Features
+		wantCancel bool
+	}{
+		{
+			name:       "client with cancel support",
+			features:   protocol.Features{tunnelv1.Feature_FEATURE_CANCEL: {}},
+			wantCancel: true,
+		},
+		{
+			name:       "legacy client",
+			features:   protocol.Features{},
+			wantCancel: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HTTPProxy{}
+			stream := &recordingStream{}
+			tun := &tunnel.Tunnel{ID: uuid.New(), Stream: stream, Features: tt.features}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			r := httptest.NewRequest("GET", "http://test.grok.example.com/slow", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			// The local service never answers; the visitor gives up
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			_, _, _, err := p.proxyRequest(w, r, tun, "req-1", make(chan *tunnelv1.ProxyResponse))
+			assert.ErrorIs(t, err, context.Canceled)
+
+			stream.mu.Lock()
+			defer stream.mu.Unlock()
+
+			if !tt.wantCancel {
+				require.Len(t, stream.sent, 1)
+				return
+			}
+			require.Len(t, stream.sent, 2)
+			assert.Equal(t, "req-1", stream.sent[1].RequestId)
+			assert.Equal(t, tunnelv1.CancelRequest_CLIENT_DISCONNECTED, stream.sent[1].GetCancel().GetReason())
+		})
+	}
+}
+
+// TestHTTPProxy_ProxyRequest_UploadReadError tests that a request body cut
+// short is never marked complete: the request is aborted and canceled instead.
+func TestHTTPProxy_ProxyRequest_UploadReadError(t *testing.T) {
+	p := &HTTPProxy{}
+	stream := &recordingStream{}
+	tun := &tunnel.Tunnel{
+		ID:     uuid.New(),
+		Stream: stream,
+		Features: protocol.Features{
+			tunnelv1.Feature_FEATURE_STREAMING_BODY: {},
+			tunnelv1.Feature_FEATURE_CANCEL:         {},
+		},
+	}
+	var aborted atomic.Bool
+	tun.Aborts.Store("req-1", context.CancelFunc(func() { aborted.Store(true) }))
+
+	body := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	r := httptest.NewRequest("POST", "http://test.grok.example.com/upload", body)
+	r.ContentLength = -1
+
+	_, _, _, err := p.proxyRequest(httptest.NewRecorder(), r, tun, "req-1", make(chan *tunnelv1.ProxyResponse))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.True(t, aborted.Load())
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	require.NotEmpty(t, stream.sent)
+	for _, req := range stream.sent {
+		assert.False(t, req.EndOfStream, "no frame ends the body")
+	}
+	assert.NotNil(t, stream.sent[len(stream.sent)-1].GetCancel())
+}
+
+// TestHTTPProxy_WriteResponse_VisitorGone tests that a streamed response stops when the visitor disconnects.
+func TestHTTPProxy_WriteResponse_VisitorGone(t *testing.T) {
+	p := &HTTPProxy{}
+	head := &tunnelv1.ProxyResponse{
+		RequestId: "req-1",
+		Payload:   &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, complete := p.writeResponse(ctx, httptest.NewRecorder(), head, make(chan *tunnelv1.ProxyResponse), nil)
+	assert.False(t, complete)
+}
+
+// TestHTTPProxy_ServeHTTP_HoldsDuringReconnect tests that requests for a reconnecting persistent tunnel are replayed or rejected.
+func TestHTTPProxy_ServeHTTP_HoldsDuringReconnect(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, true, 80, 443, 10000, 20000)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	savedName := "myapp"
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, savedName)
+	require.NoError(t, err)
+
+	connect := func() *tunnel.Tunnel {
+		tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+			"localhost:3000", "https://myapp.grok.example.com", &recordingStream{})
+		tun.SavedName = &savedName
+		require.NoError(t, manager.RegisterTunnel(ctx, tun))
+		return tun
+	}
+
+	t.Run("replayed after reconnect", func(t *testing.T) {
+		manager.SetReconnectGrace(5*time.Second, 10)
+		tun := connect()
+		require.NoError(t, manager.UnregisterTunnel(ctx, tun.ID))
+
+		w := httptest.NewRecorder()
+		served := make(chan struct{})
+		go func() {
+			p.ServeHTTP(w, httptest.NewRequest("GET", "http://myapp.grok.example.com/hello", nil))
+			close(served)
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		// The client comes back; the held request reaches it and gets answered
+		offline, err := manager.FindOfflineTunnelBySavedName(ctx, userID, savedName)
+		require.NoError(t, err)
+		stream := &recordingStream{}
+		reactivated, err := manager.ReactivateTunnel(ctx, offline, stream, "localhost:3000", tunnel.EdgePolicy{})
+		require.NoError(t, err)
+		stream.onSend = func(req *tunnelv1.ProxyRequest) {
+			if ch, ok := reactivated.ResponseMap.Load(req.RequestId); ok {
+				ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+					RequestId:   req.RequestId,
+					Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200, Body: []byte("hello")}},
+					EndOfStream: true,
+				}
+			}
+		}
+		manager.ResumeTunnel(reactivated)
+
+		select {
+		case <-served:
+		case <-time.After(5 * time.Second):
+			t.Fatal("held request was not replayed")
+		}
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+		require.NoError(t, manager.UnregisterTunnel(ctx, reactivated.ID))
+		manager.CancelReconnect(subdomain)
+	})
+
+	t.Run("503 after grace period", func(t *testing.T) {
+		manager.SetReconnectGrace(50*time.Millisecond, 10)
+		offline, err := manager.FindOfflineTunnelBySavedName(ctx, userID, savedName)
+		require.NoError(t, err)
+		tun, err := manager.ReactivateTunnel(ctx, offline, &recordingStream{}, "localhost:3000", tunnel.EdgePolicy{})
+		require.NoError(t, err)
+		require.NoError(t, manager.UnregisterTunnel(ctx, tun.ID))
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "http://myapp.grok.example.com/hello", nil))
+
+		assert.Equal(t, 503, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	})
+}
+
+// TestHTTPProxy_ServeHTTP_AccessPolicy tests that visitors of a protected
+// tunnel are checked before anything is sent to the client.
+func TestHTTPProxy_ServeHTTP_AccessPolicy(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	guard, err := access.NewGuard(access.Config{Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	manager.SetAccessGuard(guard)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "private")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://private.grok.example.com", stream)
+	tun.Access, err = access.PolicyFromProto(&tunnelv1.AccessPolicy{
+		BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "alice", Password: "s3cret"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		if ch, ok := tun.ResponseMap.Load(req.RequestId); ok {
+			ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+				RequestId:   req.RequestId,
+				Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200, Body: []byte("hello")}},
+				EndOfStream: true,
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "http://private.grok.example.com/", nil))
+	assert.Equal(t, 401, w.Code)
+	assert.Empty(t, stream.sent)
+
+	req := httptest.NewRequest("GET", "http://private.grok.example.com/", nil)
+	req.SetBasicAuth("alice", "s3cret")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	require.Len(t, stream.sent, 1)
+	assert.NotContains(t, stream.sent[0].GetHttp().GetHeaders(), "Authorization", "credentials are not forwarded")
+}
+
+// TestHTTPProxy_ServeHTTP_IPRules tests that visitors rejected by IP rules
+// get 403, are never forwarded and are recorded in the request log.
+func TestHTTPProxy_ServeHTTP_IPRules(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "office")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://office.grok.example.com", stream)
+	tun.IPRules, err = ipfilter.Parse(ipfilter.Config{AllowCIDRs: []string{"203.0.113.0/24"}})
+	require.NoError(t, err)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		if ch, ok := tun.ResponseMap.Load(req.RequestId); ok {
+			ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+				RequestId:   req.RequestId,
+				Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+				EndOfStream: true,
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "http://office.grok.example.com/admin", nil)) // From 192.0.2.1
+	assert.Equal(t, 403, w.Code)
+	assert.Empty(t, stream.sent)
+
+	var entry models.RequestLog
+	require.Eventually(t, func() bool {
+		return database.Where("tunnel_id = ?", tun.ID).First(&entry).Error == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "tunnel not in allowlist", entry.Blocked)
+	assert.Equal(t, 403, entry.StatusCode)
+	assert.Equal(t, "/admin", entry.Path)
+
+	req := httptest.NewRequest("GET", "http://office.grok.example.com/admin", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Len(t, stream.sent, 1)
+}
+
+func TestHTTPProxy_ServeHTTP_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Config{
+		Tunnel: ratelimit.Rate{Requests: 1, RequestBurst: 2},
+	}, nil))
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "busy")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://busy.grok.example.com", stream)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		if ch, ok := tun.ResponseMap.Load(req.RequestId); ok {
+			ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+				RequestId:   req.RequestId,
+				Payload:     &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{StatusCode: 200}},
+				EndOfStream: true,
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "http://busy.grok.example.com/", nil))
+		assert.Equal(t, 200, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "http://busy.grok.example.com/", nil))
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	assert.Len(t, stream.sent, 2)
+	require.Len(t, stream.controls, 1)
+	assert.Equal(t, tunnelv1.ControlMessage_RATE_LIMIT, stream.controls[0].Type)
+	assert.Equal(t, "tunnel", stream.controls[0].Metadata["scope"])
+	assert.Equal(t, "requests", stream.controls[0].Metadata["limit"])
+	assert.Equal(t, "1", stream.controls[0].Metadata["retry_after"])
+}
+
+func TestHTTPProxy_ServeHTTP_HeaderRules(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "docs")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://docs.grok.example.com", stream)
+	tun.Headers, err = headerrules.Parse(headerrules.Config{
+		Request: []headerrules.RuleConfig{
+			{Action: headerrules.ActionRemove, Name: "Cookie"},
+			{Action: headerrules.ActionSet, Name: "X-Visitor", Value: "{client_ip} via {subdomain}"},
+		},
+		Response: []headerrules.RuleConfig{{Action: headerrules.ActionRemove, Name: "Server"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	// Admin rules apply after the client's
+	admin, err := headerrules.Parse(headerrules.Config{
+		Response: []headerrules.RuleConfig{{Action: headerrules.ActionSet, Name: "X-Robots-Tag", Value: "noindex"}},
+		Request:  []headerrules.RuleConfig{{Action: headerrules.ActionSet, Name: "X-Visitor", Value: "hidden"}},
+	})
+	require.NoError(t, err)
+	tun.SetAdminHeaderRules(admin)
+
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		if ch, ok := tun.ResponseMap.Load(req.RequestId); ok {
+			ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+				RequestId: req.RequestId,
+				Payload: &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{
+					StatusCode: 200,
+					Headers:    map[string]*tunnelv1.HeaderValues{"Server": {Values: []string{"nginx"}}},
+				}},
+				EndOfStream: true,
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "http://docs.grok.example.com/", nil)
+	req.Header.Set("Cookie", "session=1")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.Equal(t, "noindex", w.Header().Get("X-Robots-Tag"))
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	require.Len(t, stream.sent, 1)
+	headers := stream.sent[0].GetHttp().GetHeaders()
+	assert.NotContains(t, headers, "Cookie")
+	assert.Equal(t, []string{"hidden"}, headers["X-Visitor"].GetValues())
+	assert.Equal(t, []string{"192.0.2.1"}, headers["X-Forwarded-For"].GetValues())
+
+	tun.SetAdminHeaderRules(nil)
+	require.Len(t, tun.HeaderRules(), 1)
+	tun.HeaderRules()[0].ApplyRequest(headers, headerVars(req, tun, "req-1"))
+	assert.Equal(t, []string{"192.0.2.1 via docs"}, headers["X-Visitor"].GetValues())
+}
+
+// TestHTTPProxy_ServeHTTP_EdgeCache tests that responses of tunnels that opt
+// in are served from the edge cache, revalidated with the tunnel when stale,
+// compressed for visitors, and that the cache status is logged.
+func TestHTTPProxy_ServeHTTP_EdgeCache(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	cache, err := edgecache.New(edgecache.Config{MaxMemory: 1 << 20})
+	require.NoError(t, err)
+	manager.SetEdgeCache(cache)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "static")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://static.grok.example.com", stream)
+	tun.Edge = tunnel.EdgeOptions{Compress: true, Cache: true}
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	page := strings.Repeat("<p>cached page</p>", 100)
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		ch, ok := tun.ResponseMap.Load(req.RequestId)
+		if !ok {
+			return
+		}
+		resp := &tunnelv1.HTTPResponse{
+			StatusCode: 200,
+			Headers: map[string]*tunnelv1.HeaderValues{
+				"Content-Type":  {Values: []string{"text/html"}},
+				"Cache-Control": {Values: []string{"max-age=60"}},
+				"Etag":          {Values: []string{`"v1"`}},
+			},
+			Body: []byte(page),
+		}
+		if req.GetHttp().GetHeaders()["If-None-Match"].GetValues() != nil {
+			resp.StatusCode = 304
+			resp.Body = nil
+		}
+		ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+			RequestId:   req.RequestId,
+			Payload:     &tunnelv1.ProxyResponse_Http{Http: resp},
+			EndOfStream: true,
+		}
+	}
+	get := func(headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://static.grok.example.com/index.html", nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		return w
+	}
+	sent := func() int {
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		return len(stream.sent)
+	}
+
+	w := get()
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, page, w.Body.String())
+	assert.Equal(t, 1, sent())
+
+	// Served from the cache, compressed for visitors that accept it
+	w = get("Accept-Encoding", "gzip")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+	assert.NotEmpty(t, w.Header().Get("Age"))
+	reader, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(decoded))
+	assert.Equal(t, 1, sent())
+
+	w = get("If-None-Match", `W/"v1"`)
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, 1, sent())
+
+	// Revalidated with the tunnel on request
+	w = get("Cache-Control", "no-cache")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, page, w.Body.String())
+	require.Equal(t, 2, sent())
+	stream.mu.Lock()
+	assert.Equal(t, []string{`"v1"`}, stream.sent[1].GetHttp().GetHeaders()["If-None-Match"].GetValues())
+	stream.mu.Unlock()
+
+	// Other methods are not cached
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "http://static.grok.example.com/index.html", nil))
+	assert.Equal(t, 3, sent())
+
+	assert.Equal(t, 1, cache.Purge(tun.ID, "/"))
+	get()
+	assert.Equal(t, 4, sent())
+
+	var logs []models.RequestLog
+	require.Eventually(t, func() bool {
+		return database.Where("tunnel_id = ?", tun.ID).Find(&logs).Error == nil && len(logs) == 6
+	}, 2*time.Second, 10*time.Millisecond)
+	statuses := map[string]int{}
+	for _, entry := range logs {
+		statuses[entry.Cache]++
+	}
+	assert.Equal(t, map[string]int{
+		edgecache.StatusMiss:        2,
+		edgecache.StatusHit:         2,
+		edgecache.StatusRevalidated: 1,
+		edgecache.StatusBypass:      1,
+	}, statuses)
+}
+
+// TestHTTPProxy_ServeHTTP_CompressedStream tests that a response streamed
+// through a tunnel with compression lifts the server's write deadline, like
+// any other streamed response.
+func TestHTTPProxy_ServeHTTP_CompressedStream(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, false, 80, 443, 10000, 20000)
+	p := NewHTTPProxy(NewRouter(manager, "grok.example.com"), nil, manager, database, "silent", 100)
+
+	userID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, "stream")
+	require.NoError(t, err)
+
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "http://stream.grok.example.com", stream)
+	tun.Edge = tunnel.EdgeOptions{Compress: true}
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	// The body is streamed for longer than the write timeout
+	stream.onSend = func(req *tunnelv1.ProxyRequest) {
+		value, ok := tun.ResponseMap.Load(req.RequestId)
+		if !ok {
+			return
+		}
+		ch := value.(chan *tunnelv1.ProxyResponse)
+		go func() {
+			ch <- &tunnelv1.ProxyResponse{
+				RequestId: req.RequestId,
+				Payload: &tunnelv1.ProxyResponse_Http{Http: &tunnelv1.HTTPResponse{
+					StatusCode: 200,
+					Headers:    map[string]*tunnelv1.HeaderValues{"Content-Type": {Values: []string{"text/plain"}}},
+				}},
+			}
+			for i := 0; i < 4; i++ {
+				time.Sleep(100 * time.Millisecond)
+				ch <- &tunnelv1.ProxyResponse{
+					RequestId: req.RequestId,
+					Payload:   &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{Data: []byte(strings.Repeat("chunk ", 50))}},
+				}
+			}
+			ch <- &tunnelv1.ProxyResponse{
+				RequestId:   req.RequestId,
+				Payload:     &tunnelv1.ProxyResponse_Body{Body: &tunnelv1.BodyChunk{}},
+				EndOfStream: true,
+			}
+		}()
+	}
+
+	server := httptest.NewUnstartedServer(p)
+	server.Config.WriteTimeout = 150 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/events", nil)
+	require.NoError(t, err)
+	req.Host = "stream.grok.example.com"
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	reader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("chunk ", 200), string(body))
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// rejectRateLimited answers a request over a rate limit or transfer quota
+// of its tunnel, user or organization, and tells the client.
+func rejectRateLimited(w http.ResponseWriter, tun *tunnel.Tunnel, exceeded *ratelimit.Exceeded) {
+	logger.DebugEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("scope", string(exceeded.Scope)).
+		Str("limit", string(exceeded.Kind)).
+		Msg("Request rate limited")
+
+	w.Header().Set("Retry-After", strconv.Itoa(exceeded.RetryAfterSeconds()))
+	message := "Rate limit exceeded"
+	if exceeded.Kind == ratelimit.KindTransfer {
+		message = "Monthly transfer quota exceeded"
+	}
+	http.Error(w, message, http.StatusTooManyRequests)
+	tun.NotifyRateLimited(exceeded)
+}
+
+// waitBandwidth waits until the rate limits of tun let n bytes of a
+// connection through. It returns false when the connection must be closed
+// because a transfer quota is exhausted or ctx is done.
+func waitBandwidth(ctx context.Context, limiter *ratelimit.Limiter, tun *tunnel.Tunnel, n int) bool {
+	delayed, err := limiter.WaitBytes(ctx, tun.RateSubject(), n)
+	var exceeded *ratelimit.Exceeded
+	switch {
+	case errors.As(err, &exceeded):
+		tun.NotifyRateLimited(exceeded)
+	case delayed != nil:
+		tun.NotifyRateLimited(delayed)
+	}
+	return err == nil
+}
@@ -1,16 +1,30 @@
 package proxy
 
 import (
+	"context"
+	"net"
+	"net/http"
 	"strings"
 
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 )
 
+// AffinityCookie names the member of a sticky tunnel group that serves a visitor.
+const AffinityCookie = "grok_affinity"
+
+// CustomDomains resolves verified custom domains.
+type CustomDomains interface {
+	Resolve(host string) (models.CustomDomain, bool)
+}
+
 // Router routes incoming requests to appropriate tunnels.
 type Router struct {
 	tunnelManager *tunnel.Manager
 	baseDomain    string
+	customDomains CustomDomains // Optional
 }
 
 // NewRouter creates a new proxy router.
@@ -21,6 +35,12 @@ func NewRouter(tunnelManager *tunnel.Manager, baseDomain string) *Router {
 	}
 }
 
+// SetCustomDomains routes verified custom domains to the tunnel subdomains
+// they point at.
+func (r *Router) SetCustomDomains(customDomains CustomDomains) {
+	r.customDomains = customDomains
+}
+
 // Example: "myapp.grok.io" -> "myapp".
 func (r *Router) ExtractSubdomain(host string) (string, error) {
 	// Remove port if present
@@ -44,19 +64,120 @@ func (r *Router) ExtractSubdomain(host string) (string, error) {
 	return subdomain, nil
 }
 
-// RouteToTunnel finds the tunnel for a given host.
-func (r *Router) RouteToTunnel(host string) (*tunnel.Tunnel, error) {
-	// Extract subdomain
-	subdomain, err := r.ExtractSubdomain(host)
+// RouteToTunnel finds the tunnel for a request. Requests for a tunnel group
+// go to one of its healthy members, picked by the group's load balancing strategy.
+func (r *Router) RouteToTunnel(req *http.Request) (*tunnel.Tunnel, error) {
+	subdomain, domain, err := r.resolveHost(req.Host)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find tunnel
+	if group, ok := r.tunnelManager.GetGroup(subdomain); ok {
+		if tun, ok := pickGroupMember(group, req); ok && ownsTunnel(domain, tun) {
+			return tun, nil
+		}
+	}
+
+	// Find tunnel; TCP and TLS tunnels only take raw connections
 	tun, ok := r.tunnelManager.GetTunnelBySubdomain(subdomain)
-	if !ok {
+	if !ok || !tun.ServesHTTP() || !ownsTunnel(domain, tun) {
 		return nil, pkgerrors.ErrTunnelNotFound
 	}
 
 	return tun, nil
 }
+
+// RouteTLS finds the TLS passthrough tunnel for a ClientHello server name.
+func (r *Router) RouteTLS(serverName string) (*tunnel.Tunnel, bool) {
+	subdomain, domain, err := r.resolveHost(strings.ToLower(serverName))
+	if err != nil {
+		return nil, false
+	}
+
+	tun, ok := r.tunnelManager.GetTunnelBySubdomain(subdomain)
+	if !ok || tun.Protocol != tunnelv1.TunnelProtocol_TLS || !ownsTunnel(domain, tun) {
+		return nil, false
+	}
+	return tun, true
+}
+
+// resolveHost returns the tunnel subdomain for a request host: a subdomain of
+// the base domain, or the target of a verified custom domain, which is
+// returned too.
+func (r *Router) resolveHost(host string) (string, *models.CustomDomain, error) {
+	subdomain, err := r.ExtractSubdomain(host)
+	if err == nil || r.customDomains == nil {
+		return subdomain, nil, err
+	}
+
+	domain, ok := r.customDomains.Resolve(host)
+	if !ok {
+		return "", nil, err
+	}
+	return domain.Subdomain, &domain, nil
+}
+
+// ownsTunnel reports whether a custom domain may route to tun: the tunnel
+// must belong to the domain's user or organization. Requests without a
+// custom domain may route to any tunnel.
+func ownsTunnel(domain *models.CustomDomain, tun *tunnel.Tunnel) bool {
+	if domain == nil || tun.UserID == domain.UserID {
+		return true
+	}
+	return domain.OrganizationID != nil && tun.OrganizationID != nil && *domain.OrganizationID == *tun.OrganizationID
+}
+
+// pickGroupMember selects the group member for a request. Sticky groups keep a
+// visitor on the member named by its affinity cookie while that member is up,
+// and otherwise pick by client IP.
+func pickGroupMember(group *tunnel.Group, req *http.Request) (*tunnel.Tunnel, bool) {
+	if group.Balancing != tunnelv1.LoadBalancing_STICKY {
+		return group.Pick("")
+	}
+
+	if cookie, err := req.Cookie(AffinityCookie); err == nil {
+		if tun, ok := group.Member(cookie.Value); ok {
+			return tun, true
+		}
+	}
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	return group.Pick(clientIP)
+}
+
+// setAffinityCookie pins the visitor of a sticky tunnel group to tun.
+func setAffinityCookie(w http.ResponseWriter, r *http.Request, group *tunnel.Group, tun *tunnel.Tunnel) {
+	if group.Balancing != tunnelv1.LoadBalancing_STICKY {
+		return
+	}
+	if cookie, err := r.Cookie(AffinityCookie); err == nil && cookie.Value == tun.ID.String() {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     AffinityCookie,
+		Value:    tun.ID.String(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// WaitForTunnel holds a request for a host whose persistent tunnel is reconnecting.
+// It returns ErrTunnelNotFound right away when no reconnect is pending.
+func (r *Router) WaitForTunnel(ctx context.Context, host string) (*tunnel.Tunnel, error) {
+	subdomain, domain, err := r.resolveHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	tun, err := r.tunnelManager.WaitForTunnel(ctx, subdomain)
+	if err == nil && !ownsTunnel(domain, tun) {
+		return nil, pkgerrors.ErrTunnelNotFound
+	}
+	return tun, err
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// TestRouter_RouteToTunnel_Group tests routing requests over the members of a tunnel group.
+func TestRouter_RouteToTunnel_Group(t *testing.T) {
+	manager := tunnel.NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	router := NewRouter(manager, "grok.example.com")
+
+	userID := uuid.New()
+	members := make([]*tunnel.Tunnel, 2)
+	for i := range members {
+		members[i] = tunnel.NewTunnel(userID, uuid.New(), nil, "myapp", tunnelv1.TunnelProtocol_HTTP,
+			"localhost:3000", "https://myapp.grok.example.com", &recordingStream{})
+		manager.JoinGroup(members[i], "myapp", tunnelv1.LoadBalancing_STICKY)
+	}
+	group, ok := manager.GetGroup("myapp")
+	require.True(t, ok)
+
+	newRequest := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "http://myapp.grok.example.com/", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	t.Run("same client IP, same member", func(t *testing.T) {
+		first, err := router.RouteToTunnel(newRequest("198.51.100.1:1000"))
+		require.NoError(t, err)
+		again, err := router.RouteToTunnel(newRequest("198.51.100.1:2000"))
+		require.NoError(t, err)
+		assert.Same(t, first, again)
+	})
+
+	t.Run("affinity cookie wins", func(t *testing.T) {
+		for _, member := range members {
+			r := newRequest("198.51.100.1:1000")
+			r.AddCookie(&http.Cookie{Name: AffinityCookie, Value: member.ID.String()})
+			tun, err := router.RouteToTunnel(r)
+			require.NoError(t, err)
+			assert.Same(t, member, tun)
+		}
+	})
+
+	t.Run("cookie set for new visitors only", func(t *testing.T) {
+		r := newRequest("198.51.100.1:1000")
+		w := httptest.NewRecorder()
+		setAffinityCookie(w, r, group, members[0])
+		assert.Contains(t, w.Header().Get("Set-Cookie"), AffinityCookie+"="+members[0].ID.String())
+
+		r.AddCookie(&http.Cookie{Name: AffinityCookie, Value: members[0].ID.String()})
+		w = httptest.NewRecorder()
+		setAffinityCookie(w, r, group, members[0])
+		assert.Empty(t, w.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("fails over when the pinned member is down", func(t *testing.T) {
+		members[0].SetStatus("closed")
+		r := newRequest("198.51.100.1:1000")
+		r.AddCookie(&http.Cookie{Name: AffinityCookie, Value: members[0].ID.String()})
+		tun, err := router.RouteToTunnel(r)
+		require.NoError(t, err)
+		assert.Same(t, members[1], tun)
+
+		members[1].SetStatus("closed")
+		_, err = router.RouteToTunnel(r)
+		assert.ErrorIs(t, err, pkgerrors.ErrTunnelNotFound)
+	})
+}
+
+// staticDomains resolves a fixed set of verified custom domains.
+type staticDomains map[string]models.CustomDomain
+
+func (d staticDomains) Resolve(host string) (models.CustomDomain, bool) {
+	domain, ok := d[host]
+	return domain, ok
+}
+
+// TestRouter_CustomDomains tests routing verified custom domains to the
+// tunnels of their owners.
+func TestRouter_CustomDomains(t *testing.T) {
+	manager := tunnel.NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	router := NewRouter(manager, "grok.example.com")
+
+	owner, orgID := uuid.New(), uuid.New()
+	tun := tunnel.NewTunnel(owner, uuid.New(), &orgID, "myapp", tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "https://myapp.grok.example.com", &recordingStream{})
+	require.NoError(t, manager.RegisterTunnel(context.Background(), tun))
+
+	route := func(host string) (*tunnel.Tunnel, error) {
+		return router.RouteToTunnel(httptest.NewRequest("GET", "http://"+host+"/", nil))
+	}
+
+	// Without custom domains only the base domain routes
+	_, err := route("demo.example.com")
+	assert.Error(t, err)
+
+	router.SetCustomDomains(staticDomains{
+		"demo.example.com":  {UserID: owner, Subdomain: "myapp"},
+		"org.example.com":   {UserID: uuid.New(), OrganizationID: &orgID, Subdomain: "myapp"},
+		"other.example.com": {UserID: uuid.New(), Subdomain: "myapp"},
+	})
+
+	got, err := route("demo.example.com")
+	require.NoError(t, err)
+	assert.Same(t, tun, got)
+
+	got, err = route("org.example.com")
+	require.NoError(t, err)
+	assert.Same(t, tun, got)
+
+	// Domains can't front the tunnels of other users
+	_, err = route("other.example.com")
+	assert.ErrorIs(t, err, pkgerrors.ErrTunnelNotFound)
+
+	_, err = route("unknown.example.com")
+	assert.Error(t, err)
+
+	got, err = route("myapp.grok.example.com")
+	require.NoError(t, err)
+	assert.Same(t, tun, got)
+}
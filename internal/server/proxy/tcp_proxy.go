@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"github.com/pandeptwidyaop/grok/pkg/proxyproto"
 )
 
 // TCPProxy manages TCP listeners for allocated ports.
@@ -21,6 +25,12 @@ type TCPProxy struct {
 	listeners     map[int]net.Listener // port → listener
 	mu            sync.RWMutex
 	done          chan struct{}
+
+	// Records connections rejected by IP rules (optional)
+	logBlocked func(tunnelID uuid.UUID, method, remoteAddr, reason string)
+
+	// Networks of proxies whose connections start with a PROXY header (optional)
+	proxyTrusted []netip.Prefix
 }
 
 // NewTCPProxy creates a new TCP proxy manager.
@@ -32,6 +42,17 @@ func NewTCPProxy(tunnelManager *tunnel.Manager) *TCPProxy {
 	}
 }
 
+// SetBlockedLogger sets the function recording connections rejected by IP rules.
+func (tp *TCPProxy) SetBlockedLogger(fn func(tunnelID uuid.UUID, method, remoteAddr, reason string)) {
+	tp.logBlocked = fn
+}
+
+// SetProxyProtocol makes listeners started from now on read the PROXY header
+// of connections from trusted networks. Nil disables it.
+func (tp *TCPProxy) SetProxyProtocol(trusted []netip.Prefix) {
+	tp.proxyTrusted = trusted
+}
+
 // StartListener starts a TCP listener on the specified port for a tunnel.
 func (tp *TCPProxy) StartListener(port int, tunnelID uuid.UUID) error {
 	tp.mu.Lock()
@@ -48,6 +69,9 @@ func (tp *TCPProxy) StartListener(port int, tunnelID uuid.UUID) error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %d: %w", port, err)
 	}
+	if tp.proxyTrusted != nil {
+		listener = proxyproto.NewListener(listener, tp.proxyTrusted)
+	}
 
 	tp.listeners[port] = listener
 
@@ -122,11 +146,75 @@ func (tp *TCPProxy) acceptConnections(listener net.Listener, port int, tunnelID
 			continue
 		}
 
+		if tp.rejected(conn, tunnelID) {
+			conn.Close()
+			continue
+		}
+
 		// Handle connection in background
 		go tp.handleConnection(conn, port, tunnelID)
 	}
 }
 
+// rejected reports whether the IP rules of the tunnel or its organization
+// reject conn, and records the attempt, or whether conn is over their rate
+// limits, and tells the client.
+func (tp *TCPProxy) rejected(conn net.Conn, tunnelID uuid.UUID) bool {
+	tun, exists := tp.tunnelManager.GetTunnelByID(tunnelID)
+	if !exists {
+		return false // Left to handleConnection
+	}
+
+	return tp.blocked(tun, "TCP", conn.RemoteAddr().String()) || tp.rateLimited(tun, "TCP")
+}
+
+// blocked reports whether the IP rules of tun or its organization reject a
+// connection from remoteAddr, and records the attempt. method names the
+// kind of connection (TCP or TLS) in the request log.
+func (tp *TCPProxy) blocked(tun *tunnel.Tunnel, method, remoteAddr string) bool {
+	reason := tp.tunnelManager.IPFilter().Check(remoteAddr, tun.OrganizationID, tun.IPRules)
+	if reason == "" {
+		return false
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("protocol", method).
+		Str("remote_addr", remoteAddr).
+		Str("reason", reason).
+		Msg("Connection blocked by IP rules")
+	if tp.logBlocked != nil {
+		go tp.logBlocked(tun.ID, method, remoteAddr, reason)
+	}
+	return true
+}
+
+// rateLimited reports whether a new connection is over the rate limits or
+// transfer quotas of tun, its user or organization, and tells the client.
+func (tp *TCPProxy) rateLimited(tun *tunnel.Tunnel, method string) bool {
+	exceeded := tp.limiter().AllowRequest(tun.RateSubject())
+	if exceeded == nil {
+		return false
+	}
+
+	logger.DebugEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("protocol", method).
+		Str("scope", string(exceeded.Scope)).
+		Str("limit", string(exceeded.Kind)).
+		Msg("Connection rate limited")
+	tun.NotifyRateLimited(exceeded)
+	return true
+}
+
+// limiter returns the limiter of the tunnels' rate limits, or nil.
+func (tp *TCPProxy) limiter() *ratelimit.Limiter {
+	if tp.tunnelManager == nil {
+		return nil
+	}
+	return tp.tunnelManager.RateLimiter()
+}
+
 // handleConnection handles a single TCP connection by forwarding it through the tunnel.
 func (tp *TCPProxy) handleConnection(conn net.Conn, port int, tunnelID uuid.UUID) {
 	defer conn.Close()
@@ -156,6 +244,17 @@ func (tp *TCPProxy) handleConnection(conn net.Conn, port int, tunnelID uuid.UUID
 	// Create a unique connection ID
 	connID := uuid.New().String()
 
+	tp.forward(conn, tun, connID)
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnelID.String()).
+		Int("port", port).
+		Str("connection_id", connID).
+		Msg("TCP connection closed")
+}
+
+// forward relays a public connection through the tunnel until either side closes it.
+func (tp *TCPProxy) forward(conn net.Conn, tun *tunnel.Tunnel, connID string) {
 	// Create response channel for this connection
 	responseCh := make(chan *tunnelv1.ProxyResponse, 100)
 	defer close(responseCh)
@@ -164,21 +263,38 @@ func (tp *TCPProxy) handleConnection(conn net.Conn, port int, tunnelID uuid.UUID
 	tun.ResponseMap.Store(connID, responseCh)
 	defer tun.ResponseMap.Delete(connID)
 
+	// With flow control, reads are limited by the credit the client has returned,
+	// and written data is acknowledged so the client can send more
+	var window *protocol.Window
+	var credit *protocol.Credit
+	if tun.Features.Has(tunnelv1.Feature_FEATURE_TCP_FLOW_CONTROL) {
+		window = protocol.NewWindow(protocol.DefaultWindow)
+		credit = protocol.NewCredit(protocol.DefaultWindow)
+		tun.SendWindows.Store(connID, window)
+		defer tun.SendWindows.Delete(connID)
+	}
+
+	// The client connects to the local service right away, so that services
+	// speaking first (SMTP, SSH) work, and learns who the visitor is
+	if tun.Features.Has(tunnelv1.Feature_FEATURE_TCP_OPEN) && !tp.sendOpen(tun, connID, conn.RemoteAddr().String()) {
+		return
+	}
+
 	// Create context with cancel for this connection
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	tun.Aborts.Store(connID, cancel) // Data the stream cannot deliver closes the connection
+	defer tun.Aborts.Delete(connID)
 
-	// Start goroutine to read from tunnel stream and write to TCP connection
-	go tp.streamToConnection(ctx, conn, responseCh, connID)
+	// Start goroutine to read from tunnel stream and write to TCP connection.
+	// Once the tunnel side closes, stop reading so a sender waiting for credit is released.
+	go func() {
+		tp.streamToConnection(ctx, conn, tun, responseCh, connID, credit)
+		cancel()
+	}()
 
 	// Read from TCP connection and send to tunnel stream
-	tp.connectionToStream(ctx, conn, tun, connID, remoteAddr)
-
-	logger.InfoEvent().
-		Str("tunnel_id", tunnelID.String()).
-		Int("port", port).
-		Str("connection_id", connID).
-		Msg("TCP connection closed")
+	tp.connectionToStream(ctx, conn, tun, connID, window)
 }
 
 // connectionToStream reads from TCP connection and sends data to tunnel stream.
@@ -187,21 +303,31 @@ func (tp *TCPProxy) connectionToStream(
 	conn net.Conn,
 	tun *tunnel.Tunnel,
 	connID string,
-	_ string,
+	window *protocol.Window,
 ) {
 	buffer := make([]byte, 32*1024) // 32KB buffer
 
 	for {
 		select {
 		case <-ctx.Done():
+			// The tunnel side is gone; make sure the client closes its end too
+			tp.sendCloseSignal(tun, connID)
 			return
 		default:
 		}
 
+		// Never read more than the client has room for
+		size, err := window.Acquire(ctx, len(buffer))
+		if err != nil {
+			tp.sendCloseSignal(tun, connID)
+			return
+		}
+
 		// Set read deadline to allow periodic context checks
 		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // Best effort
 
-		n, err := conn.Read(buffer)
+		n, err := conn.Read(buffer[:size])
+		window.Release(size - n)
 		if err != nil {
 			if err == io.EOF {
 				// Normal connection close
@@ -218,42 +344,24 @@ func (tp *TCPProxy) connectionToStream(
 					Msg("Error reading from TCP connection")
 			}
 
-			// Send close signal to tunnel (empty TCP data)
-			closeReq := &tunnelv1.ProxyRequest{
-				RequestId: connID,
-				TunnelId:  tun.ID.String(),
-				Payload: &tunnelv1.ProxyRequest_Tcp{
-					Tcp: &tunnelv1.TCPData{
-						Data:     []byte{},
-						Sequence: 0,
-					},
-				},
-			}
-
-			select {
-			case tun.RequestQueue <- &tunnel.PendingRequest{
-				RequestID:  connID,
-				Request:    closeReq,
-				ResponseCh: make(chan *tunnelv1.ProxyResponse, 1),
-				Timeout:    5 * time.Second,
-				CreatedAt:  time.Now(),
-			}:
-			case <-time.After(1 * time.Second):
-				logger.WarnEvent().Msg("Timeout sending TCP close signal")
-			}
-
+			tp.sendCloseSignal(tun, connID)
 			return
 		}
 
 		if n > 0 {
+			if !waitBandwidth(ctx, tp.limiter(), tun, n) {
+				tp.sendCloseSignal(tun, connID)
+				return
+			}
+
 			// Create proxy request with TCP data
 			proxyReq := &tunnelv1.ProxyRequest{
 				RequestId: connID,
 				TunnelId:  tun.ID.String(),
 				Payload: &tunnelv1.ProxyRequest_Tcp{
 					Tcp: &tunnelv1.TCPData{
-						Data:     buffer[:n],
-						Sequence: 0, // TODO: implement proper sequencing
+						Data:     append([]byte(nil), buffer[:n]...), // Copy: the buffer is reused before the request is sent
+						Sequence: 0,                                  // TODO: implement proper sequencing
 					},
 				},
 			}
@@ -284,12 +392,67 @@ func (tp *TCPProxy) connectionToStream(
 	}
 }
 
+// sendOpen tells the client about a new connection from remoteAddr.
+func (tp *TCPProxy) sendOpen(tun *tunnel.Tunnel, connID, remoteAddr string) bool {
+	openReq := &tunnelv1.ProxyRequest{
+		RequestId: connID,
+		TunnelId:  tun.ID.String(),
+		Payload: &tunnelv1.ProxyRequest_Tcp{
+			Tcp: &tunnelv1.TCPData{RemoteAddr: remoteAddr},
+		},
+	}
+
+	select {
+	case tun.RequestQueue <- &tunnel.PendingRequest{
+		RequestID:  connID,
+		Request:    openReq,
+		ResponseCh: make(chan *tunnelv1.ProxyResponse, 1),
+		Timeout:    30 * time.Second,
+		CreatedAt:  time.Now(),
+	}:
+		return true
+	case <-time.After(5 * time.Second):
+		logger.WarnEvent().
+			Str("connection_id", connID).
+			Msg("Timeout sending TCP connection to tunnel")
+		return false
+	}
+}
+
+// sendCloseSignal tells the client to close its end of the connection (empty TCP data).
+func (tp *TCPProxy) sendCloseSignal(tun *tunnel.Tunnel, connID string) {
+	closeReq := &tunnelv1.ProxyRequest{
+		RequestId: connID,
+		TunnelId:  tun.ID.String(),
+		Payload: &tunnelv1.ProxyRequest_Tcp{
+			Tcp: &tunnelv1.TCPData{
+				Data:     []byte{},
+				Sequence: 0,
+			},
+		},
+	}
+
+	select {
+	case tun.RequestQueue <- &tunnel.PendingRequest{
+		RequestID:  connID,
+		Request:    closeReq,
+		ResponseCh: make(chan *tunnelv1.ProxyResponse, 1),
+		Timeout:    5 * time.Second,
+		CreatedAt:  time.Now(),
+	}:
+	case <-time.After(1 * time.Second):
+		logger.WarnEvent().Msg("Timeout sending TCP close signal")
+	}
+}
+
 // streamToConnection reads from tunnel stream and writes to TCP connection.
 func (tp *TCPProxy) streamToConnection(
 	ctx context.Context,
 	conn net.Conn,
+	tun *tunnel.Tunnel,
 	responseCh chan *tunnelv1.ProxyResponse,
 	connID string,
+	credit *protocol.Credit,
 ) {
 	for {
 		select {
@@ -310,7 +473,7 @@ func (tp *TCPProxy) streamToConnection(
 			}
 
 			// Handle TCP close (empty data)
-			if len(tcpData.Data) == 0 || response.EndOfStream {
+			if protocol.IsTCPClose(tcpData) || response.EndOfStream {
 				logger.DebugEvent().
 					Str("connection_id", connID).
 					Msg("Received TCP close signal from tunnel")
@@ -320,6 +483,9 @@ func (tp *TCPProxy) streamToConnection(
 
 			// Write response data to TCP connection
 			if len(tcpData.Data) > 0 {
+				if !waitBandwidth(ctx, tp.limiter(), tun, len(tcpData.Data)) {
+					return
+				}
 				_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second)) // Best effort
 				n, err := conn.Write(tcpData.Data)
 				if err != nil {
@@ -331,11 +497,16 @@ func (tp *TCPProxy) streamToConnection(
 				}
 
 				// Update stats (bytes out)
-				if response.TunnelId != "" {
-					if tunnelID, err := uuid.Parse(response.TunnelId); err == nil {
-						if tun, exists := tp.tunnelManager.GetTunnelByID(tunnelID); exists {
-							tun.UpdateStats(0, int64(n))
-						}
+				tun.UpdateStats(0, int64(n))
+
+				// Let the client send more once enough has been written out
+				if grant := credit.Consumed(n); grant > 0 {
+					if err := sendWindowUpdate(tun, connID, grant); err != nil {
+						logger.WarnEvent().
+							Err(err).
+							Str("connection_id", connID).
+							Msg("Failed to send TCP window update")
+						return
 					}
 				}
 
@@ -348,6 +519,25 @@ func (tp *TCPProxy) streamToConnection(
 	}
 }
 
+// sendWindowUpdate returns n bytes of send credit to the client for a connection.
+func sendWindowUpdate(tun *tunnel.Tunnel, connID string, n uint32) error {
+	msg := &tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Request{
+			Request: &tunnelv1.ProxyRequest{
+				RequestId: connID,
+				TunnelId:  tun.ID.String(),
+				Payload: &tunnelv1.ProxyRequest_Tcp{
+					Tcp: &tunnelv1.TCPData{WindowUpdate: n},
+				},
+			},
+		},
+	}
+
+	tun.StreamMu.Lock()
+	defer tun.StreamMu.Unlock()
+	return tun.Stream.SendMsg(msg)
+}
+
 // Shutdown stops all TCP listeners.
 func (tp *TCPProxy) Shutdown() {
 	close(tp.done)
@@ -2,6 +2,10 @@ package proxy
 
 import (
 	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
 	"testing"
 	"time"
 
@@ -13,6 +17,9 @@ import (
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 )
 
@@ -152,3 +159,220 @@ func TestTCPProxy_Integration(t *testing.T) {
 	activePorts = proxy.GetActiveListeners()
 	assert.NotContains(t, activePorts, allocatedPort)
 }
+
+// TestTCPProxy_FlowControl tests that reads stop at the client's window and resume on window updates.
+func TestTCPProxy_FlowControl(t *testing.T) {
+	tp := &TCPProxy{}
+	tun := &tunnel.Tunnel{
+		ID:           uuid.New(),
+		RequestQueue: make(chan *tunnel.PendingRequest, 100),
+		Stream:       &recordingStream{},
+	}
+
+	public, local := net.Pipe()
+	defer public.Close()
+	defer local.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	window := protocol.NewWindow(protocol.DefaultWindow)
+	go tp.connectionToStream(ctx, local, tun, "conn-1", window)
+
+	// A fast public client sends far more than the window
+	go func() {
+		_, _ = public.Write(make([]byte, 4*protocol.DefaultWindow))
+	}()
+
+	// drain counts the bytes queued for the client until the reader stalls
+	queued := 0
+	drain := func() int {
+		for {
+			select {
+			case req := <-tun.RequestQueue:
+				queued += len(req.Request.GetTcp().GetData())
+			case <-time.After(200 * time.Millisecond):
+				return queued
+			}
+		}
+	}
+
+	assert.Equal(t, protocol.DefaultWindow, drain())
+
+	window.Release(protocol.DefaultWindow / 2)
+	assert.Equal(t, protocol.DefaultWindow+protocol.DefaultWindow/2, drain())
+}
+
+// TestTCPProxy_StreamToConnection_GrantsCredit tests that data written to the public connection is acknowledged.
+func TestTCPProxy_StreamToConnection_GrantsCredit(t *testing.T) {
+	tp := &TCPProxy{}
+	stream := &recordingStream{}
+	tun := &tunnel.Tunnel{ID: uuid.New(), Stream: stream}
+
+	public, local := net.Pipe()
+	defer public.Close()
+	defer local.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, public)
+	}()
+
+	responseCh := make(chan *tunnelv1.ProxyResponse, 10)
+	chunk := make([]byte, protocol.DefaultWindow/8)
+	for i := 0; i < 3; i++ {
+		responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Tcp{Tcp: &tunnelv1.TCPData{Data: chunk}}}
+	}
+	// A window update is not a close signal
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Tcp{Tcp: &tunnelv1.TCPData{WindowUpdate: 1}}}
+	responseCh <- &tunnelv1.ProxyResponse{Payload: &tunnelv1.ProxyResponse_Tcp{Tcp: &tunnelv1.TCPData{}}}
+
+	tp.streamToConnection(context.Background(), local, tun, responseCh, "conn-1", protocol.NewCredit(protocol.DefaultWindow))
+
+	// Credit is returned in batches, not per frame
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	require.Len(t, stream.sent, 1)
+	assert.Equal(t, "conn-1", stream.sent[0].RequestId)
+	assert.Equal(t, uint32(protocol.DefaultWindow/4), stream.sent[0].GetTcp().GetWindowUpdate())
+}
+
+// TestTCPProxy_IPRules tests that connections rejected by IP rules are closed
+// before anything is sent to the client, and reported.
+func TestTCPProxy_IPRules(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, true, 80, 443, 10000, 20000)
+
+	proxy := NewTCPProxy(manager)
+	defer proxy.Shutdown()
+	manager.SetTCPProxy(proxy)
+
+	blocked := make(chan string, 1)
+	proxy.SetBlockedLogger(func(_ uuid.UUID, method, _, reason string) { blocked <- method + " " + reason })
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_TCP,
+		"localhost:22", "tcp://localhost:12000", stream)
+	tun.IPRules, err = ipfilter.Parse(ipfilter.Config{DenyCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	defer func() { _ = manager.UnregisterTunnel(ctx, tun.ID) }()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(*tun.RemotePort)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	select {
+	case reason := <-blocked:
+		assert.Equal(t, "TCP tunnel denied network 127.0.0.0/8", reason)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked connection was not reported")
+	}
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	assert.Empty(t, stream.sent)
+}
+
+func TestTCPProxy_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, true, 80, 443, 10000, 20000)
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Config{
+		Tunnel: ratelimit.Rate{Requests: 0.01, RequestBurst: 1},
+	}, nil))
+
+	proxy := NewTCPProxy(manager)
+	defer proxy.Shutdown()
+	manager.SetTCPProxy(proxy)
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_TCP,
+		"localhost:22", "tcp://localhost:12000", stream)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	defer func() { _ = manager.UnregisterTunnel(ctx, tun.ID) }()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(*tun.RemotePort))
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("ping"))
+	require.NoError(t, err)
+	select {
+	case <-tun.RequestQueue:
+	case <-time.After(2 * time.Second):
+		t.Fatal("first connection was not forwarded")
+	}
+
+	// The second connection is closed without reaching the client
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	_ = second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	stream.mu.Lock()
+	require.Len(t, stream.controls, 1)
+	assert.Equal(t, tunnelv1.ControlMessage_RATE_LIMIT, stream.controls[0].Type)
+	assert.Equal(t, "tunnel request rate limit exceeded", stream.controls[0].Metadata["message"])
+	stream.mu.Unlock()
+
+	// Let the first connection close before the tunnel is unregistered
+	require.NoError(t, first.Close())
+	select {
+	case <-tun.RequestQueue:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close of first connection was not forwarded")
+	}
+}
+
+// TestTCPProxy_ProxyProtocol tests that connections through a trusted proxy
+// are opened on the client with the visitor's address from the PROXY header.
+func TestTCPProxy_ProxyProtocol(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	manager := tunnel.NewManager(database, "localhost", 10, true, 80, 443, 10000, 20000)
+
+	proxy := NewTCPProxy(manager)
+	defer proxy.Shutdown()
+	manager.SetTCPProxy(proxy)
+	proxy.SetProxyProtocol([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_TCP,
+		"localhost:5432", "tcp://localhost:12000", &recordingStream{})
+	tun.Features = protocol.Features{tunnelv1.Feature_FEATURE_TCP_OPEN: {}}
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+	defer func() { _ = manager.UnregisterTunnel(ctx, tun.ID) }()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(*tun.RemotePort)))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 5432\r\nping"))
+	require.NoError(t, err)
+
+	next := func() *tunnelv1.TCPData {
+		select {
+		case req := <-tun.RequestQueue:
+			return req.Request.GetTcp()
+		case <-time.After(2 * time.Second):
+			t.Fatal("nothing forwarded to the client")
+			return nil
+		}
+	}
+	open := next()
+	assert.Equal(t, "203.0.113.7:51234", open.GetRemoteAddr())
+	assert.Empty(t, open.GetData())
+	assert.Equal(t, "ping", string(next().GetData()))
+
+	require.NoError(t, conn.Close())
+	assert.True(t, protocol.IsTCPClose(next()))
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// clientHelloTimeout bounds how long a new connection may take to send its ClientHello.
+const clientHelloTimeout = 10 * time.Second
+
+// errClientHelloRead stops the handshake once the ClientHello has been read.
+var errClientHelloRead = errors.New("client hello read")
+
+// SNIListener wraps the public HTTPS listener. It reads the server name of
+// each new connection and relays connections for TLS tunnels to their client
+// as raw encrypted bytes; the server never terminates them. All other
+// connections are handed to the HTTPS server through Accept.
+type SNIListener struct {
+	net.Listener
+	router   *Router
+	tcpProxy *TCPProxy
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // Returned by Accept once done is closed
+}
+
+// NewSNIListener starts routing the connections accepted on inner.
+func NewSNIListener(inner net.Listener, router *Router, tcpProxy *TCPProxy) *SNIListener {
+	l := &SNIListener{
+		Listener: inner,
+		router:   router,
+		tcpProxy: tcpProxy,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.acceptConnections()
+	return l
+}
+
+// Accept returns the next connection for the HTTPS server.
+func (l *SNIListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Close stops accepting connections. Relayed connections are not affected.
+func (l *SNIListener) Close() error {
+	l.close(net.ErrClosed)
+	return nil
+}
+
+// close shuts the listener down; Accept returns err from then on.
+func (l *SNIListener) close(err error) {
+	l.closeOnce.Do(func() {
+		l.err = err
+		close(l.done)
+		_ = l.Listener.Close() // Best effort
+	})
+}
+
+// acceptConnections routes every accepted connection in the background, so a
+// slow ClientHello does not hold up the others.
+func (l *SNIListener) acceptConnections() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				l.close(net.ErrClosed)
+				return
+			}
+
+			logger.ErrorEvent().Err(err).Msg("Error accepting HTTPS connection")
+			select {
+			case <-l.done:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+
+		go l.route(conn)
+	}
+}
+
+// route sends a connection to its TLS tunnel, or else to the HTTPS server.
+func (l *SNIListener) route(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout)) // Best effort
+	serverName, hello := peekServerName(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// The peeked bytes are replayed to whoever handles the connection
+	conn = &replayConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(hello), conn)}
+
+	if tun, ok := l.router.RouteTLS(serverName); ok {
+		l.passthrough(conn, tun, serverName)
+		return
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// passthrough relays a TLS connection to the client of a TLS tunnel, unless
+// the IP rules of the tunnel's organization reject it or it is over the
+// tunnel's rate limits. Its bytes count against the bandwidth limits like
+// those of a TCP connection.
+func (l *SNIListener) passthrough(conn net.Conn, tun *tunnel.Tunnel, serverName string) {
+	defer conn.Close()
+
+	if l.tcpProxy.blocked(tun, "TLS", conn.RemoteAddr().String()) || l.tcpProxy.rateLimited(tun, "TLS") {
+		return
+	}
+
+	tun.UpdateActivity()
+	connID := uuid.New().String()
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("server_name", serverName).
+		Str("remote_addr", conn.RemoteAddr().String()).
+		Str("connection_id", connID).
+		Msg("Accepted TLS passthrough connection")
+
+	l.tcpProxy.forward(conn, tun, connID)
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("connection_id", connID).
+		Msg("TLS passthrough connection closed")
+}
+
+// peekServerName reads the ClientHello of a TLS connection and returns its
+// server name (empty when there is none, or the connection is not TLS)
+// together with every byte read from conn.
+func peekServerName(conn net.Conn) (string, []byte) {
+	var hello bytes.Buffer
+	var serverName string
+
+	config := &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = info.ServerName
+			return nil, errClientHelloRead
+		},
+	}
+	// The handshake stops after the ClientHello; nothing is written back to the peer
+	_ = tls.Server(&replayConn{Conn: conn, reader: io.TeeReader(conn, &hello), readOnly: true}, config).Handshake()
+
+	return strings.ToLower(serverName), hello.Bytes()
+}
+
+// replayConn reads from reader instead of the connection itself, e.g. to
+// replay the bytes read while peeking at the ClientHello.
+type replayConn struct {
+	net.Conn
+	reader   io.Reader
+	readOnly bool
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *replayConn) Write(p []byte) (int, error) {
+	if c.readOnly {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Write(p)
+}
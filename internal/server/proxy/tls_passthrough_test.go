@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// selfSignedCert returns a certificate for host and a pool trusting it.
+func selfSignedCert(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{host},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// TestPeekServerName tests reading the server name without consuming the ClientHello.
+func TestPeekServerName(t *testing.T) {
+	public, server := net.Pipe()
+	defer public.Close()
+	defer server.Close()
+
+	go func() {
+		_ = tls.Client(public, &tls.Config{ServerName: "Demo.Grok.Example.com"}).Handshake()
+	}()
+
+	serverName, hello := peekServerName(server)
+	assert.Equal(t, "demo.grok.example.com", serverName)
+	require.NotEmpty(t, hello)
+	assert.Equal(t, byte(0x16), hello[0], "starts with the handshake record")
+}
+
+// TestSNIListener_Passthrough tests that a TLS tunnel gets the raw TLS stream and
+// terminates it on the client side, while other connections reach the HTTPS server.
+func TestSNIListener_Passthrough(t *testing.T) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "grok.example.com", 10, true, 80, 443, 10000, 20000)
+	tcpProxy := NewTCPProxy(manager)
+	defer tcpProxy.Shutdown()
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "demo")
+	require.NoError(t, err)
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_TLS,
+		"localhost:8443", manager.BuildPublicURL(subdomain, tunnel.ProtocolTLS), &recordingStream{})
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewSNIListener(inner, NewRouter(manager, "grok.example.com"), tcpProxy)
+	defer listener.Close()
+
+	// The client side: the tunnel's local service terminates TLS with its own certificate
+	host := "demo.grok.example.com"
+	cert, pool := selfSignedCert(t, host)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		conn := tls.Server(local, &tls.Config{Certificates: []tls.Certificate{cert}})
+		defer conn.Close()
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil {
+			_, _ = conn.Write([]byte("echo: " + line))
+		}
+	}()
+	go relayTunnel(tun, remote)
+
+	conn, err := tls.Dial("tcp", inner.Addr().String(), &tls.Config{ServerName: host, RootCAs: pool})
+	require.NoError(t, err, "handshake with the client's certificate")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello\n", reply)
+
+	t.Run("other server names go to the HTTPS server", func(t *testing.T) {
+		go func() {
+			raw, err := net.Dial("tcp", inner.Addr().String())
+			if err == nil {
+				_ = tls.Client(raw, &tls.Config{ServerName: "web.grok.example.com"}).Handshake()
+			}
+		}()
+
+		accepted, err := listener.Accept()
+		require.NoError(t, err)
+		defer accepted.Close()
+
+		serverName, _ := peekServerName(accepted)
+		assert.Equal(t, "web.grok.example.com", serverName, "ClientHello is replayed")
+	})
+}
+
+// TestSNIListener_IPRules tests that TLS connections rejected by the IP rules
+// of the tunnel's organization are closed before reaching the client, and reported.
+func TestSNIListener_IPRules(t *testing.T) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "grok.example.com", 10, true, 80, 443, 10000, 20000)
+	filter := ipfilter.NewFilter(nil)
+	manager.SetIPFilter(filter)
+	tcpProxy := NewTCPProxy(manager)
+	defer tcpProxy.Shutdown()
+
+	blocked := make(chan string, 1)
+	tcpProxy.SetBlockedLogger(func(_ uuid.UUID, method, _, reason string) { blocked <- method + " " + reason })
+
+	orgID := uuid.New()
+	rules, err := ipfilter.Parse(ipfilter.Config{DenyCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	filter.SetOrgRules(orgID, rules)
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "demo")
+	require.NoError(t, err)
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), &orgID, subdomain, tunnelv1.TunnelProtocol_TLS,
+		"localhost:8443", manager.BuildPublicURL(subdomain, tunnel.ProtocolTLS), &recordingStream{})
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewSNIListener(inner, NewRouter(manager, "grok.example.com"), tcpProxy)
+	defer listener.Close()
+
+	_, err = tls.Dial("tcp", inner.Addr().String(), &tls.Config{ServerName: "demo.grok.example.com"})
+	assert.Error(t, err, "connection closed during the handshake")
+
+	select {
+	case reason := <-blocked:
+		assert.Equal(t, "TLS organization denied network 127.0.0.0/8", reason)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked connection was not reported")
+	}
+	assert.Empty(t, tun.RequestQueue, "nothing sent to the client")
+}
+
+// TestSNIListener_RateLimit tests that TLS connections over the tunnel's
+// request rate are closed without reaching the client.
+func TestSNIListener_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "grok.example.com", 10, true, 80, 443, 10000, 20000)
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Config{
+		Tunnel: ratelimit.Rate{Requests: 0.01, RequestBurst: 1},
+	}, nil))
+	tcpProxy := NewTCPProxy(manager)
+	defer tcpProxy.Shutdown()
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "demo")
+	require.NoError(t, err)
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_TLS,
+		"localhost:8443", manager.BuildPublicURL(subdomain, tunnel.ProtocolTLS), stream)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := NewSNIListener(inner, NewRouter(manager, "grok.example.com"), tcpProxy)
+	defer listener.Close()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	go func() {
+		_ = tls.Client(first, &tls.Config{ServerName: "demo.grok.example.com"}).Handshake()
+	}()
+	select {
+	case <-tun.RequestQueue:
+	case <-time.After(2 * time.Second):
+		t.Fatal("first connection was not forwarded")
+	}
+
+	_, err = tls.Dial("tcp", inner.Addr().String(), &tls.Config{ServerName: "demo.grok.example.com"})
+	assert.Error(t, err, "connection closed during the handshake")
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	require.Len(t, stream.controls, 1)
+	assert.Equal(t, tunnelv1.ControlMessage_RATE_LIMIT, stream.controls[0].Type)
+	assert.Equal(t, "tunnel request rate limit exceeded", stream.controls[0].Metadata["message"])
+}
+
+// relayTunnel plays the tunnel client: it copies the data of the first
+// connection on tun to local and sends local's replies back.
+func relayTunnel(tun *tunnel.Tunnel, local net.Conn) {
+	var connID string
+	for req := range tun.RequestQueue {
+		data := req.Request.GetTcp().GetData()
+		if len(data) == 0 {
+			_ = local.Close()
+			return
+		}
+
+		if connID == "" {
+			connID = req.RequestID
+			go func() {
+				value, _ := tun.ResponseMap.Load(connID)
+				responseCh := value.(chan *tunnelv1.ProxyResponse)
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := local.Read(buf)
+					if err != nil {
+						return
+					}
+					responseCh <- &tunnelv1.ProxyResponse{
+						RequestId: connID,
+						Payload:   &tunnelv1.ProxyResponse_Tcp{Tcp: &tunnelv1.TCPData{Data: append([]byte(nil), buf[:n]...)}},
+					}
+				}
+			}()
+		}
+
+		if _, err := local.Write(data); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+const (
+	// maxDatagramSize is the largest UDP payload that can be received.
+	maxDatagramSize = 64 * 1024
+	// defaultUDPFlowTimeout is used when no idle timeout is configured.
+	defaultUDPFlowTimeout = 60 * time.Second
+	// defaultUDPMaxFlows is used when no flow limit is configured.
+	defaultUDPMaxFlows = 1024
+)
+
+// UDPProxy manages UDP listeners for allocated ports. Datagrams are grouped
+// into flows by public remote address, so replies from the client's local
+// socket go back to the right peer. Flows without traffic expire, and the
+// least recently active flow makes room once a listener has maxFlows.
+type UDPProxy struct {
+	tunnelManager *tunnel.Manager
+	idleTimeout   time.Duration
+	maxFlows      int
+	listeners     map[int]*udpListener // port → listener
+	mu            sync.RWMutex
+
+	// Records peers rejected by IP rules (optional)
+	logBlocked func(tunnelID uuid.UUID, method, remoteAddr, reason string)
+}
+
+// udpListener is the public socket of one UDP tunnel.
+type udpListener struct {
+	conn     *net.UDPConn
+	port     int
+	tunnelID uuid.UUID
+	flows    sync.Map     // remote address → *udpFlow
+	count    atomic.Int64 // Number of flows
+	blocked  sync.Map     // remote address → Unix nanoseconds it was last reported blocked
+	done     chan struct{}
+}
+
+// udpFlow is the traffic exchanged with one public remote address.
+type udpFlow struct {
+	id         string
+	remote     *net.UDPAddr
+	responseCh chan *tunnelv1.ProxyResponse
+	lastActive atomic.Int64 // Unix nanoseconds of the last datagram either way
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewUDPProxy creates a new UDP proxy manager. Flows idle for idleTimeout are closed,
+// and each listener keeps at most maxFlows.
+func NewUDPProxy(tunnelManager *tunnel.Manager, idleTimeout time.Duration, maxFlows int) *UDPProxy {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultUDPFlowTimeout
+	}
+	if maxFlows <= 0 {
+		maxFlows = defaultUDPMaxFlows
+	}
+
+	return &UDPProxy{
+		tunnelManager: tunnelManager,
+		idleTimeout:   idleTimeout,
+		maxFlows:      maxFlows,
+		listeners:     make(map[int]*udpListener),
+	}
+}
+
+// SetBlockedLogger sets the function recording peers rejected by IP rules.
+func (up *UDPProxy) SetBlockedLogger(fn func(tunnelID uuid.UUID, method, remoteAddr, reason string)) {
+	up.logBlocked = fn
+}
+
+// StartListener starts a UDP listener on the specified port for a tunnel.
+func (up *UDPProxy) StartListener(port int, tunnelID uuid.UUID) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	if _, exists := up.listeners[port]; exists {
+		return fmt.Errorf("listener already exists on port %d", port)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
+	listener := &udpListener{
+		conn:     conn,
+		port:     port,
+		tunnelID: tunnelID,
+		done:     make(chan struct{}),
+	}
+	up.listeners[port] = listener
+
+	logger.InfoEvent().
+		Int("port", port).
+		Str("tunnel_id", tunnelID.String()).
+		Msg("UDP listener started")
+
+	go up.readDatagrams(listener)
+	go up.expireFlows(listener)
+
+	return nil
+}
+
+// StopListener stops the UDP listener on the specified port and closes its flows.
+func (up *UDPProxy) StopListener(port int) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	listener, exists := up.listeners[port]
+	if !exists {
+		return fmt.Errorf("no listener found on port %d", port)
+	}
+
+	up.closeListener(listener)
+	delete(up.listeners, port)
+
+	logger.InfoEvent().
+		Int("port", port).
+		Msg("UDP listener stopped")
+
+	return nil
+}
+
+// GetActiveListeners returns the ports with an active UDP listener.
+func (up *UDPProxy) GetActiveListeners() []int {
+	up.mu.RLock()
+	defer up.mu.RUnlock()
+
+	ports := make([]int, 0, len(up.listeners))
+	for port := range up.listeners {
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// Shutdown stops all UDP listeners.
+func (up *UDPProxy) Shutdown() {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+
+	for port, listener := range up.listeners {
+		up.closeListener(listener)
+		logger.InfoEvent().
+			Int("port", port).
+			Msg("UDP listener closed during shutdown")
+	}
+
+	up.listeners = make(map[int]*udpListener)
+}
+
+// closeListener closes the public socket and forgets its flows. The tunnel is
+// going away, so the client is not told about each flow.
+func (up *UDPProxy) closeListener(listener *udpListener) {
+	close(listener.done)
+	if err := listener.conn.Close(); err != nil {
+		logger.WarnEvent().
+			Err(err).
+			Int("port", listener.port).
+			Msg("Error closing UDP listener")
+	}
+
+	tun, _ := up.tunnelManager.GetTunnelByID(listener.tunnelID)
+	listener.flows.Range(func(key, value interface{}) bool {
+		up.removeFlow(listener, tun, value.(*udpFlow), false)
+		return true
+	})
+}
+
+// readDatagrams forwards the datagrams received on the public socket to the
+// tunnel. A new peer takes a request from the tunnel's rate limits, like a
+// TCP connection, and every datagram waits for bandwidth; the datagrams that
+// arrive meanwhile are dropped by the socket.
+func (up *UDPProxy) readDatagrams(listener *udpListener) {
+	ctx, cancel := doneContext(listener.done)
+	defer cancel()
+	buffer := make([]byte, maxDatagramSize)
+
+	for {
+		n, remote, err := listener.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.ErrorEvent().
+				Err(err).
+				Int("port", listener.port).
+				Msg("Error reading UDP datagram")
+			continue
+		}
+
+		tun, exists := up.tunnelManager.GetTunnelByID(listener.tunnelID)
+		if !exists || tun.GetStatus() != "active" {
+			logger.DebugEvent().
+				Str("tunnel_id", listener.tunnelID.String()).
+				Int("port", listener.port).
+				Msg("Tunnel not found for incoming UDP datagram")
+			continue
+		}
+		if up.blocked(listener, tun, remote) {
+			continue
+		}
+		if _, ok := listener.flows.Load(remote.String()); !ok && up.rateLimited(listener, tun) {
+			continue
+		}
+		// Over a transfer quota, or the listener closed
+		if !waitBandwidth(ctx, up.tunnelManager.RateLimiter(), tun, n) {
+			continue
+		}
+
+		flow := up.getOrCreateFlow(listener, tun, remote)
+		flow.touch()
+		tun.UpdateActivity()
+
+		proxyReq := &tunnelv1.ProxyRequest{
+			RequestId: flow.id,
+			TunnelId:  tun.ID.String(),
+			Payload: &tunnelv1.ProxyRequest_Udp{
+				Udp: &tunnelv1.UDPDatagram{
+					Data:       append([]byte(nil), buffer[:n]...), // Copy: the buffer is reused
+					RemoteAddr: remote.String(),
+				},
+			},
+		}
+
+		// UDP is lossy: drop the datagram rather than stall every flow of the tunnel
+		select {
+		case tun.RequestQueue <- &tunnel.PendingRequest{
+			RequestID:  flow.id,
+			Request:    proxyReq,
+			ResponseCh: make(chan *tunnelv1.ProxyResponse, 1), // Replies arrive on the flow's channel
+			Timeout:    up.idleTimeout,
+			CreatedAt:  time.Now(),
+		}:
+			tun.UpdateStats(int64(n), 0)
+		default:
+			logger.WarnEvent().
+				Str("flow_id", flow.id).
+				Int("bytes", n).
+				Msg("Tunnel queue full, dropping UDP datagram")
+		}
+	}
+}
+
+// blocked reports whether the IP rules of the tunnel's organization reject
+// datagrams from remote, and closes the peer's flow if it has one. A blocked
+// peer is recorded once per idle timeout rather than for every datagram.
+func (up *UDPProxy) blocked(listener *udpListener, tun *tunnel.Tunnel, remote *net.UDPAddr) bool {
+	key := remote.String()
+	reason := up.tunnelManager.IPFilter().Check(key, tun.OrganizationID, tun.IPRules)
+	if reason == "" {
+		return false
+	}
+
+	// The rules changed since the flow started
+	if value, ok := listener.flows.Load(key); ok {
+		up.removeFlow(listener, tun, value.(*udpFlow), true)
+	}
+
+	now := time.Now().UnixNano()
+	if last, ok := listener.blocked.Load(key); ok && now-last.(int64) < int64(up.idleTimeout) {
+		return true
+	}
+	listener.blocked.Store(key, now)
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Int("port", listener.port).
+		Str("remote_addr", key).
+		Str("reason", reason).
+		Msg("UDP datagram blocked by IP rules")
+	if up.logBlocked != nil {
+		go up.logBlocked(tun.ID, "UDP", key, reason)
+	}
+	return true
+}
+
+// rateLimited reports whether a new peer is over the rate limits or transfer
+// quotas of the tunnel, its user or organization, and tells the client.
+func (up *UDPProxy) rateLimited(listener *udpListener, tun *tunnel.Tunnel) bool {
+	exceeded := up.tunnelManager.RateLimiter().AllowRequest(tun.RateSubject())
+	if exceeded == nil {
+		return false
+	}
+
+	logger.DebugEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Int("port", listener.port).
+		Str("scope", string(exceeded.Scope)).
+		Str("limit", string(exceeded.Kind)).
+		Msg("UDP flow rate limited")
+	tun.NotifyRateLimited(exceeded)
+	return true
+}
+
+// getOrCreateFlow returns the flow of a remote address, starting it on its first datagram.
+func (up *UDPProxy) getOrCreateFlow(listener *udpListener, tun *tunnel.Tunnel, remote *net.UDPAddr) *udpFlow {
+	key := remote.String()
+	if value, ok := listener.flows.Load(key); ok {
+		return value.(*udpFlow)
+	}
+
+	// At the limit, the least recently active flow makes room for the new peer
+	if int(listener.count.Load()) >= up.maxFlows {
+		if oldest := listener.oldestFlow(); oldest != nil {
+			logger.DebugEvent().
+				Str("tunnel_id", tun.ID.String()).
+				Int("port", listener.port).
+				Int("max_flows", up.maxFlows).
+				Str("flow_id", oldest.id).
+				Msg("UDP flow limit reached, closing least recently active flow")
+			up.removeFlow(listener, tun, oldest, true)
+		}
+	}
+
+	flow := &udpFlow{
+		id:         uuid.New().String(),
+		remote:     remote,
+		responseCh: make(chan *tunnelv1.ProxyResponse, 100),
+		done:       make(chan struct{}),
+	}
+	flow.touch()
+
+	// Register the reply channel before the first datagram reaches the client
+	tun.ResponseMap.Store(flow.id, flow.responseCh)
+	listener.flows.Store(key, flow)
+	listener.count.Add(1)
+	go up.writeReplies(listener, tun, flow)
+
+	logger.DebugEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Int("port", listener.port).
+		Str("remote_addr", key).
+		Str("flow_id", flow.id).
+		Msg("UDP flow started")
+
+	return flow
+}
+
+// writeReplies sends the client's replies for a flow to its public peer,
+// within the tunnel's bandwidth limits. Replies arriving while it waits are
+// dropped once the flow's channel is full.
+func (up *UDPProxy) writeReplies(listener *udpListener, tun *tunnel.Tunnel, flow *udpFlow) {
+	ctx, cancel := doneContext(flow.done)
+	defer cancel()
+
+	for {
+		select {
+		case <-flow.done:
+			return
+		case response, ok := <-flow.responseCh:
+			// The tunnel closed its response channels
+			if !ok {
+				return
+			}
+
+			datagram := response.GetUdp()
+			if datagram == nil {
+				continue
+			}
+
+			// The client's local socket failed; the next datagram starts a new flow
+			if datagram.Close {
+				up.removeFlow(listener, tun, flow, false)
+				return
+			}
+
+			// Over a transfer quota, or the flow closed
+			if !waitBandwidth(ctx, up.tunnelManager.RateLimiter(), tun, len(datagram.Data)) {
+				continue
+			}
+
+			n, err := listener.conn.WriteToUDP(datagram.Data, flow.remote)
+			if err != nil {
+				logger.WarnEvent().
+					Err(err).
+					Str("flow_id", flow.id).
+					Msg("Error writing UDP datagram")
+				continue
+			}
+
+			flow.touch()
+			tun.UpdateStats(0, int64(n))
+		}
+	}
+}
+
+// expireFlows closes the flows that have been idle for longer than the idle
+// timeout, and forgets the blocked peers reported before it.
+func (up *UDPProxy) expireFlows(listener *udpListener) {
+	ticker := time.NewTicker(up.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-listener.done:
+			return
+		case <-ticker.C:
+		}
+
+		tun, _ := up.tunnelManager.GetTunnelByID(listener.tunnelID)
+		deadline := time.Now().Add(-up.idleTimeout).UnixNano()
+		listener.flows.Range(func(_, value interface{}) bool {
+			if flow := value.(*udpFlow); flow.lastActive.Load() < deadline {
+				up.removeFlow(listener, tun, flow, true)
+			}
+			return true
+		})
+		listener.blocked.Range(func(key, value interface{}) bool {
+			if value.(int64) < deadline {
+				listener.blocked.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// removeFlow forgets a flow. notify tells the client to close its local socket.
+func (up *UDPProxy) removeFlow(listener *udpListener, tun *tunnel.Tunnel, flow *udpFlow, notify bool) {
+	flow.closeOnce.Do(func() {
+		listener.flows.CompareAndDelete(flow.remote.String(), flow)
+		listener.count.Add(-1)
+		close(flow.done)
+
+		if tun == nil {
+			return
+		}
+		tun.ResponseMap.Delete(flow.id)
+
+		if notify && tun.GetStatus() == "active" {
+			closeReq := &tunnelv1.ProxyRequest{
+				RequestId: flow.id,
+				TunnelId:  tun.ID.String(),
+				Payload: &tunnelv1.ProxyRequest_Udp{
+					Udp: &tunnelv1.UDPDatagram{Close: true},
+				},
+			}
+			select {
+			case tun.RequestQueue <- &tunnel.PendingRequest{
+				RequestID:  flow.id,
+				Request:    closeReq,
+				ResponseCh: make(chan *tunnelv1.ProxyResponse, 1),
+				Timeout:    5 * time.Second,
+				CreatedAt:  time.Now(),
+			}:
+			default:
+				logger.WarnEvent().Str("flow_id", flow.id).Msg("Tunnel queue full, dropping UDP flow close")
+			}
+		}
+
+		logger.DebugEvent().
+			Str("flow_id", flow.id).
+			Str("remote_addr", flow.remote.String()).
+			Msg("UDP flow closed")
+	})
+}
+
+// oldestFlow returns the flow that has been idle the longest, or nil when there are none.
+func (l *udpListener) oldestFlow() *udpFlow {
+	var oldest *udpFlow
+	l.flows.Range(func(_, value interface{}) bool {
+		if flow := value.(*udpFlow); oldest == nil || flow.lastActive.Load() < oldest.lastActive.Load() {
+			oldest = flow
+		}
+		return true
+	})
+	return oldest
+}
+
+// touch records traffic on the flow.
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+// doneContext returns a context canceled once done is closed.
+func doneContext(done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
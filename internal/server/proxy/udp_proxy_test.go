@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
+	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
+)
+
+// registerUDPTunnel registers a UDP tunnel served by a new UDP proxy.
+func registerUDPTunnel(t *testing.T, idleTimeout time.Duration, maxFlows int) (*tunnel.Tunnel, *UDPProxy) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	udpProxy := NewUDPProxy(manager, idleTimeout, maxFlows)
+	manager.SetUDPProxy(udpProxy)
+	t.Cleanup(udpProxy.Shutdown)
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_UDP,
+		"localhost:53", "udp://pending-allocation", &recordingStream{})
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	return tun, udpProxy
+}
+
+// nextDatagram returns the next UDP request queued for the client.
+func nextDatagram(t *testing.T, tun *tunnel.Tunnel) *tunnelv1.ProxyRequest {
+	select {
+	case pending := <-tun.RequestQueue:
+		require.NotNil(t, pending.Request.GetUdp())
+		return pending.Request
+	case <-time.After(time.Second):
+		t.Fatal("no datagram queued for the client")
+		return nil
+	}
+}
+
+// TestUDPProxy_Flows tests that datagrams are tracked per remote address and
+// replies reach the right peer.
+func TestUDPProxy_Flows(t *testing.T) {
+	tun, udpProxy := registerUDPTunnel(t, time.Minute, 0)
+	require.NotNil(t, tun.RemotePort)
+	assert.True(t, strings.HasPrefix(tun.PublicURL, "udp://"))
+	assert.Contains(t, udpProxy.GetActiveListeners(), *tun.RemotePort)
+
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	peerA, peerB := dial(), dial()
+
+	_, err := peerA.Write([]byte("a1"))
+	require.NoError(t, err)
+	first := nextDatagram(t, tun)
+	assert.Equal(t, []byte("a1"), first.GetUdp().Data)
+	assert.Equal(t, peerA.LocalAddr().String(), first.GetUdp().RemoteAddr)
+
+	_, err = peerA.Write([]byte("a2"))
+	require.NoError(t, err)
+	assert.Equal(t, first.RequestId, nextDatagram(t, tun).RequestId, "same peer, same flow")
+
+	_, err = peerB.Write([]byte("b1"))
+	require.NoError(t, err)
+	other := nextDatagram(t, tun)
+	assert.NotEqual(t, first.RequestId, other.RequestId, "new peer, new flow")
+
+	// The client replies on each flow
+	for _, flow := range []struct {
+		id   string
+		peer *net.UDPConn
+	}{{first.RequestId, peerA}, {other.RequestId, peerB}} {
+		ch, ok := tun.ResponseMap.Load(flow.id)
+		require.True(t, ok)
+		ch.(chan *tunnelv1.ProxyResponse) <- &tunnelv1.ProxyResponse{
+			RequestId: flow.id,
+			Payload:   &tunnelv1.ProxyResponse_Udp{Udp: &tunnelv1.UDPDatagram{Data: []byte("reply " + flow.id)}},
+		}
+
+		buf := make([]byte, 128)
+		_ = flow.peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := flow.peer.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("reply %s", flow.id), string(buf[:n]))
+	}
+}
+
+// TestUDPProxy_FlowExpiry tests that idle flows are closed and the client is told.
+func TestUDPProxy_FlowExpiry(t *testing.T) {
+	tun, _ := registerUDPTunnel(t, 100*time.Millisecond, 0)
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	flowID := nextDatagram(t, tun).RequestId
+
+	closeReq := nextDatagram(t, tun)
+	assert.Equal(t, flowID, closeReq.RequestId)
+	assert.True(t, closeReq.GetUdp().Close)
+
+	_, ok := tun.ResponseMap.Load(flowID)
+	assert.False(t, ok)
+
+	// The peer's next datagram starts a new flow
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	assert.NotEqual(t, flowID, nextDatagram(t, tun).RequestId)
+}
+
+// TestUDPProxy_FlowLimit tests that the least recently active flow is closed
+// to make room for a new peer once the listener has its maximum of flows.
+func TestUDPProxy_FlowLimit(t *testing.T) {
+	tun, _ := registerUDPTunnel(t, time.Minute, 2)
+
+	var peers []*net.UDPConn
+	var flowIDs []string
+	for i := 0; i < 2; i++ {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+		require.NoError(t, err)
+		defer conn.Close()
+		peers = append(peers, conn)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		flowIDs = append(flowIDs, nextDatagram(t, tun).RequestId)
+	}
+
+	// The first peer is active again, so the second one is the oldest
+	time.Sleep(10 * time.Millisecond)
+	_, err := peers[0].Write([]byte("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, flowIDs[0], nextDatagram(t, tun).RequestId)
+
+	third, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+	require.NoError(t, err)
+	defer third.Close()
+	_, err = third.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	closeReq := nextDatagram(t, tun)
+	assert.Equal(t, flowIDs[1], closeReq.RequestId)
+	assert.True(t, closeReq.GetUdp().Close)
+	_, ok := tun.ResponseMap.Load(flowIDs[1])
+	assert.False(t, ok)
+
+	newFlow := nextDatagram(t, tun)
+	assert.NotContains(t, flowIDs, newFlow.RequestId)
+	assert.Equal(t, third.LocalAddr().String(), newFlow.GetUdp().RemoteAddr)
+}
+
+// TestUDPProxy_IPRules tests that datagrams from peers rejected by the IP
+// rules of the tunnel's organization are dropped, their flow closed, and
+// the peer reported once.
+func TestUDPProxy_IPRules(t *testing.T) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	filter := ipfilter.NewFilter(nil)
+	manager.SetIPFilter(filter)
+	udpProxy := NewUDPProxy(manager, time.Minute, 0)
+	manager.SetUDPProxy(udpProxy)
+	defer udpProxy.Shutdown()
+
+	blocked := make(chan string, 10)
+	udpProxy.SetBlockedLogger(func(_ uuid.UUID, method, _, reason string) { blocked <- method + " " + reason })
+
+	orgID := uuid.New()
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), &orgID, subdomain, tunnelv1.TunnelProtocol_UDP,
+		"localhost:53", "udp://pending-allocation", &recordingStream{})
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	peer, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	_, err = peer.Write([]byte("ping"))
+	require.NoError(t, err)
+	flowID := nextDatagram(t, tun).RequestId
+
+	// The organization denies the peer after its flow started
+	rules, err := ipfilter.Parse(ipfilter.Config{DenyCIDRs: []string{"127.0.0.0/8"}})
+	require.NoError(t, err)
+	filter.SetOrgRules(orgID, rules)
+
+	for i := 0; i < 3; i++ {
+		_, err = peer.Write([]byte("ping"))
+		require.NoError(t, err)
+	}
+
+	closeReq := nextDatagram(t, tun)
+	assert.Equal(t, flowID, closeReq.RequestId)
+	assert.True(t, closeReq.GetUdp().Close)
+
+	select {
+	case reason := <-blocked:
+		assert.Equal(t, "UDP organization denied network 127.0.0.0/8", reason)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked peer was not reported")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, tun.RequestQueue, "blocked datagrams are not forwarded")
+	assert.Empty(t, blocked, "the peer is reported once")
+}
+
+// TestUDPProxy_RateLimit tests that new peers over the tunnel's request rate
+// are dropped, while peers with a flow go on.
+func TestUDPProxy_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	manager := tunnel.NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	manager.SetRateLimiter(ratelimit.NewLimiter(ratelimit.Config{
+		Tunnel: ratelimit.Rate{Requests: 0.01, RequestBurst: 1},
+	}, nil))
+	udpProxy := NewUDPProxy(manager, time.Minute, 0)
+	manager.SetUDPProxy(udpProxy)
+	defer udpProxy.Shutdown()
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, uuid.New(), nil, "")
+	require.NoError(t, err)
+	stream := &recordingStream{}
+	tun := tunnel.NewTunnel(uuid.New(), uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_UDP,
+		"localhost:53", "udp://pending-allocation", stream)
+	require.NoError(t, manager.RegisterTunnel(ctx, tun))
+
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: *tun.RemotePort})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	first, second := dial(), dial()
+
+	_, err = first.Write([]byte("a1"))
+	require.NoError(t, err)
+	flowID := nextDatagram(t, tun).RequestId
+
+	// The second peer would start a flow over the limit
+	_, err = second.Write([]byte("b1"))
+	require.NoError(t, err)
+	_, err = first.Write([]byte("a2"))
+	require.NoError(t, err)
+
+	datagram := nextDatagram(t, tun)
+	assert.Equal(t, flowID, datagram.RequestId)
+	assert.Equal(t, []byte("a2"), datagram.GetUdp().Data)
+	assert.Empty(t, tun.RequestQueue)
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	require.Len(t, stream.controls, 1)
+	assert.Equal(t, tunnelv1.ControlMessage_RATE_LIMIT, stream.controls[0].Type)
+	assert.Equal(t, "tunnel request rate limit exceeded", stream.controls[0].Metadata["message"])
+}
@@ -735,9 +735,9 @@ func (wr *WebhookRouter) sendToTunnel(ctx context.Context, tun *tunnel.Tunnel, u
 	}
 
 	// Create response channel
-	responseCh := make(chan *tunnelv1.ProxyResponse, 1)
+	responseCh := make(chan *tunnelv1.ProxyResponse, ResponseChannelBuffer)
 	tun.ResponseMap.Store(requestID, responseCh)
-	defer tun.ResponseMap.Delete(requestID)
+	defer releaseResponseChannel(tun, requestID, responseCh)
 
 	// Send request to tunnel via RequestQueue to prevent race condition
 	pendingReq := &tunnel.PendingRequest{
@@ -764,27 +764,52 @@ func (wr *WebhookRouter) sendToTunnel(ctx context.Context, tun *tunnel.Tunnel, u
 
 	select {
 	case proxyResp := <-responseCh:
-		// Got response from tunnel
-		if httpResp := proxyResp.GetHttp(); httpResp != nil {
-			// Convert proto headers back to map
-			respHeaders := make(map[string][]string)
-			for key, headerVals := range httpResp.Headers {
-				respHeaders[key] = headerVals.Values
+		// Got response head from tunnel
+		httpResp := proxyResp.GetHttp()
+		if httpResp == nil {
+			return &TunnelResponse{
+				Success:      false,
+				ErrorMessage: "invalid response type from tunnel",
 			}
+		}
 
-			return &TunnelResponse{
-				StatusCode: int(httpResp.StatusCode),
-				Body:       httpResp.Body,
-				Headers:    respHeaders,
-				Success:    true,
+		// Collect streamed body frames (webhook responses are returned in full)
+		body := httpResp.Body
+		for !proxyResp.EndOfStream {
+			select {
+			case frame, ok := <-responseCh:
+				if !ok {
+					return &TunnelResponse{
+						Success:      false,
+						ErrorMessage: "tunnel closed while streaming response",
+					}
+				}
+				body = append(body, frame.GetBody().GetData()...)
+				proxyResp = frame
+			case <-timeoutCtx.Done():
+				cancelRequest(tun, requestID, tunnelv1.CancelRequest_TIMEOUT)
+				return &TunnelResponse{
+					Success:      false,
+					ErrorMessage: "tunnel response timeout (30s)",
+				}
 			}
 		}
+
+		// Convert proto headers back to map
+		respHeaders := make(map[string][]string)
+		for key, headerVals := range httpResp.Headers {
+			respHeaders[key] = headerVals.Values
+		}
+
 		return &TunnelResponse{
-			Success:      false,
-			ErrorMessage: "invalid response type from tunnel",
+			StatusCode: int(httpResp.StatusCode),
+			Body:       body,
+			Headers:    respHeaders,
+			Success:    true,
 		}
 
 	case <-timeoutCtx.Done():
+		cancelRequest(tun, requestID, tunnelv1.CancelRequest_TIMEOUT)
 		return &TunnelResponse{
 			Success:      false,
 			ErrorMessage: "tunnel response timeout (30s)",
@@ -0,0 +1,310 @@
+// Package ratelimit limits the requests and bytes of tunnels, users and
+// organizations at the edge with token buckets, and enforces their monthly
+// transfer quotas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+	"gorm.io/gorm"
+)
+
+// Scope is what a limit applies to.
+type Scope string
+
+const (
+	ScopeTunnel       Scope = "tunnel"
+	ScopeUser         Scope = "user"
+	ScopeOrganization Scope = "organization"
+)
+
+// Kind is the limit that was exceeded.
+type Kind string
+
+const (
+	KindRequests Kind = "requests"
+	KindBytes    Kind = "bytes"
+	KindTransfer Kind = "monthly_transfer"
+)
+
+// Rate is a token bucket limit. Zero values are unlimited.
+type Rate struct {
+	Requests     float64 // Requests (TCP: connections) per second
+	RequestBurst int     // Requests let through at once (default: Requests, rounded up)
+	Bytes        int64   // Bytes per second, in and out together
+	ByteBurst    int64   // Bytes let through at once (default: Bytes)
+}
+
+// Config holds the limits of every tunnel, user and organization.
+type Config struct {
+	Tunnel       Rate
+	User         Rate
+	Organization Rate
+
+	// Bytes in and out per calendar month (UTC); 0 is unlimited
+	UserTransfer         int64
+	OrganizationTransfer int64
+}
+
+// Subject identifies the tunnel, user and organization traffic is counted for.
+type Subject struct {
+	Tunnel       uuid.UUID
+	User         uuid.UUID
+	Organization *uuid.UUID
+}
+
+// Exceeded reports a limit that stopped a request or connection.
+type Exceeded struct {
+	Scope      Scope
+	Kind       Kind
+	RetryAfter time.Duration // When the limit lets traffic through again
+}
+
+// Error describes the exceeded limit.
+func (e *Exceeded) Error() string {
+	switch e.Kind {
+	case KindTransfer:
+		return fmt.Sprintf("%s monthly transfer quota exceeded", e.Scope)
+	case KindBytes:
+		return fmt.Sprintf("%s bandwidth limit exceeded", e.Scope)
+	default:
+		return fmt.Sprintf("%s request rate limit exceeded", e.Scope)
+	}
+}
+
+// RetryAfterSeconds returns RetryAfter in whole seconds, at least 1.
+func (e *Exceeded) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Limiter enforces the limits of a Config. A nil *Limiter lets everything through.
+type Limiter struct {
+	cfg Config
+	db  *gorm.DB // Optional: monthly transfer is not persisted when nil
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+
+	usageMu sync.Mutex
+	usage   map[usageKey]*usage
+}
+
+type bucketKey struct {
+	scope Scope
+	id    uuid.UUID
+}
+
+// bucket holds the token buckets of one tunnel, user or organization.
+type bucket struct {
+	requests *rate.Limiter // nil: unlimited
+	bytes    *rate.Limiter // nil: unlimited
+	lastSeen time.Time
+}
+
+// scopeLimit is the rate of one scope of a subject.
+type scopeLimit struct {
+	key  bucketKey
+	rate Rate
+}
+
+// NewLimiter creates a limiter persisting monthly transfer in db, which may be nil.
+func NewLimiter(cfg Config, db *gorm.DB) *Limiter {
+	l := &Limiter{
+		cfg:     cfg,
+		db:      db,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*bucket),
+		usage:   make(map[usageKey]*usage),
+	}
+
+	// Cleanup idle buckets every 5 minutes
+	go l.cleanupLoop()
+
+	return l
+}
+
+// AllowRequest takes a request (or new connection or UDP peer) of sub from its
+// buckets, or returns the limit that rejects it. Bandwidth in debt and
+// exhausted monthly transfer reject requests too.
+func (l *Limiter) AllowRequest(sub Subject) *Exceeded {
+	if l == nil {
+		return nil
+	}
+	now := l.now()
+	if exceeded := l.checkTransfer(sub, now); exceeded != nil {
+		return exceeded
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var taken []*rate.Reservation
+	cancel := func() {
+		for _, r := range taken {
+			r.CancelAt(now)
+		}
+	}
+	for _, limit := range l.scopes(sub) {
+		b := l.bucket(limit, now)
+		if b.bytes != nil {
+			if tokens := b.bytes.TokensAt(now); tokens < 1 {
+				cancel()
+				wait := time.Duration((1 - tokens) / float64(b.bytes.Limit()) * float64(time.Second))
+				return &Exceeded{Scope: limit.key.scope, Kind: KindBytes, RetryAfter: wait}
+			}
+		}
+		if b.requests != nil {
+			r := b.requests.ReserveN(now, 1)
+			if wait := r.DelayFrom(now); wait > 0 {
+				r.CancelAt(now)
+				cancel()
+				return &Exceeded{Scope: limit.key.scope, Kind: KindRequests, RetryAfter: wait}
+			}
+			taken = append(taken, r)
+		}
+	}
+	return nil
+}
+
+// CountBytes takes n bytes of sub from its bandwidth buckets without
+// waiting. Buckets go into debt, so that later requests are rejected until
+// they refill.
+func (l *Limiter) CountBytes(sub Subject, n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, limit := range l.scopes(sub) {
+		b := l.bucket(limit, now)
+		if b.bytes == nil {
+			continue
+		}
+		for left := n; left > 0; {
+			chunk := min(left, int64(b.bytes.Burst()))
+			b.bytes.ReserveN(now, int(chunk))
+			left -= chunk
+		}
+	}
+}
+
+// WaitBytes waits until the bandwidth buckets of sub let n bytes through and
+// takes them. delayed describes the limit that slowed the bytes down, or is
+// nil. err is an *Exceeded when the monthly transfer of sub is exhausted, or
+// the error of ctx.
+func (l *Limiter) WaitBytes(ctx context.Context, sub Subject, n int) (delayed *Exceeded, err error) {
+	if l == nil || n <= 0 {
+		return nil, nil
+	}
+	now := l.now()
+	if exceeded := l.checkTransfer(sub, now); exceeded != nil {
+		return nil, exceeded
+	}
+
+	type scopeBucket struct {
+		scope Scope
+		bytes *rate.Limiter
+	}
+	l.mu.Lock()
+	var buckets []scopeBucket
+	for _, limit := range l.scopes(sub) {
+		if b := l.bucket(limit, now); b.bytes != nil {
+			buckets = append(buckets, scopeBucket{limit.key.scope, b.bytes})
+		}
+	}
+	l.mu.Unlock()
+
+	for _, b := range buckets {
+		for left := n; left > 0; {
+			chunk := min(left, b.bytes.Burst())
+			at := l.now()
+			wait := b.bytes.ReserveN(at, chunk).DelayFrom(at)
+			if wait > 0 {
+				if delayed == nil || wait > delayed.RetryAfter {
+					delayed = &Exceeded{Scope: b.scope, Kind: KindBytes, RetryAfter: wait}
+				}
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return delayed, ctx.Err()
+				}
+			}
+			left -= chunk
+		}
+	}
+	return delayed, nil
+}
+
+// scopes returns the limited scopes of sub.
+func (l *Limiter) scopes(sub Subject) []scopeLimit {
+	var limits []scopeLimit
+	if l.cfg.Tunnel.limited() {
+		limits = append(limits, scopeLimit{bucketKey{ScopeTunnel, sub.Tunnel}, l.cfg.Tunnel})
+	}
+	if l.cfg.User.limited() {
+		limits = append(limits, scopeLimit{bucketKey{ScopeUser, sub.User}, l.cfg.User})
+	}
+	if sub.Organization != nil && l.cfg.Organization.limited() {
+		limits = append(limits, scopeLimit{bucketKey{ScopeOrganization, *sub.Organization}, l.cfg.Organization})
+	}
+	return limits
+}
+
+// bucket returns the buckets of a scope, creating them on first use. The
+// caller holds l.mu.
+func (l *Limiter) bucket(limit scopeLimit, now time.Time) *bucket {
+	b, ok := l.buckets[limit.key]
+	if !ok {
+		b = &bucket{}
+		if r := limit.rate; r.Requests > 0 {
+			burst := r.RequestBurst
+			if burst <= 0 {
+				burst = int(math.Ceil(r.Requests))
+			}
+			b.requests = rate.NewLimiter(rate.Limit(r.Requests), burst)
+		}
+		if r := limit.rate; r.Bytes > 0 {
+			burst := r.ByteBurst
+			if burst <= 0 {
+				burst = r.Bytes
+			}
+			b.bytes = rate.NewLimiter(rate.Limit(r.Bytes), int(min(burst, math.MaxInt32)))
+		}
+		l.buckets[limit.key] = b
+	}
+	b.lastSeen = now
+	return b
+}
+
+// limited reports whether the rate limits anything.
+func (r Rate) limited() bool {
+	return r.Requests > 0 || r.Bytes > 0
+}
+
+// cleanupLoop periodically removes buckets of idle tunnels, users and organizations.
+func (l *Limiter) cleanupLoop() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			// Remove buckets not used in the last 10 minutes
+			if time.Since(b.lastSeen) > 10*time.Minute {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a settable clock for limiters under test.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(cfg Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)}
+	l := NewLimiter(cfg, nil)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiter_Nil(t *testing.T) {
+	var l *Limiter
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New()}
+
+	assert.Nil(t, l.AllowRequest(sub))
+	l.CountBytes(sub, 1<<20)
+	delayed, err := l.WaitBytes(context.Background(), sub, 1<<20)
+	assert.Nil(t, delayed)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Flush(context.Background()))
+}
+
+func TestLimiter_AllowRequest(t *testing.T) {
+	l, clock := newTestLimiter(Config{Tunnel: Rate{Requests: 2, RequestBurst: 3}})
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New()}
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, l.AllowRequest(sub), "request %d", i)
+	}
+	exceeded := l.AllowRequest(sub)
+	require.NotNil(t, exceeded)
+	assert.Equal(t, ScopeTunnel, exceeded.Scope)
+	assert.Equal(t, KindRequests, exceeded.Kind)
+	assert.Equal(t, 500*time.Millisecond, exceeded.RetryAfter)
+	assert.Equal(t, 1, exceeded.RetryAfterSeconds())
+	assert.Equal(t, "tunnel request rate limit exceeded", exceeded.Error())
+
+	// Other tunnels have their own bucket
+	assert.Nil(t, l.AllowRequest(Subject{Tunnel: uuid.New(), User: sub.User}))
+
+	clock.advance(500 * time.Millisecond)
+	assert.Nil(t, l.AllowRequest(sub))
+	assert.NotNil(t, l.AllowRequest(sub))
+}
+
+func TestLimiter_AllowRequest_Scopes(t *testing.T) {
+	orgID := uuid.New()
+	l, clock := newTestLimiter(Config{
+		Tunnel:       Rate{Requests: 10},
+		Organization: Rate{Requests: 1},
+	})
+	first := Subject{Tunnel: uuid.New(), User: uuid.New(), Organization: &orgID}
+	second := Subject{Tunnel: uuid.New(), User: uuid.New(), Organization: &orgID}
+
+	assert.Nil(t, l.AllowRequest(first))
+	exceeded := l.AllowRequest(second)
+	require.NotNil(t, exceeded)
+	assert.Equal(t, ScopeOrganization, exceeded.Scope)
+
+	// The rejected request did not take a token of its tunnel
+	tunnelBucket := l.buckets[bucketKey{ScopeTunnel, second.Tunnel}]
+	assert.Equal(t, 10.0, tunnelBucket.requests.TokensAt(clock.now()))
+
+	// Tunnels without an organization are not limited by it
+	assert.Nil(t, l.AllowRequest(Subject{Tunnel: uuid.New(), User: uuid.New()}))
+}
+
+func TestLimiter_CountBytes(t *testing.T) {
+	l, clock := newTestLimiter(Config{User: Rate{Bytes: 1000}})
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New()}
+
+	assert.Nil(t, l.AllowRequest(sub))
+	l.CountBytes(sub, 3000) // 2000 bytes in debt
+
+	exceeded := l.AllowRequest(sub)
+	require.NotNil(t, exceeded)
+	assert.Equal(t, ScopeUser, exceeded.Scope)
+	assert.Equal(t, KindBytes, exceeded.Kind)
+	assert.Equal(t, "user bandwidth limit exceeded", exceeded.Error())
+	assert.InDelta(t, 2.001, exceeded.RetryAfter.Seconds(), 0.001)
+
+	clock.advance(2 * time.Second)
+	assert.NotNil(t, l.AllowRequest(sub))
+	clock.advance(10 * time.Millisecond)
+	assert.Nil(t, l.AllowRequest(sub))
+}
+
+func TestLimiter_WaitBytes(t *testing.T) {
+	l := NewLimiter(Config{Tunnel: Rate{Bytes: 10000, ByteBurst: 1000}}, nil)
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New()}
+	ctx := context.Background()
+
+	delayed, err := l.WaitBytes(ctx, sub, 1000)
+	require.NoError(t, err)
+	assert.Nil(t, delayed)
+
+	start := time.Now()
+	delayed, err = l.WaitBytes(ctx, sub, 500)
+	require.NoError(t, err)
+	require.NotNil(t, delayed)
+	assert.Equal(t, ScopeTunnel, delayed.Scope)
+	assert.Equal(t, KindBytes, delayed.Kind)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// Waiting ends with the context
+	l.CountBytes(sub, 100000)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = l.WaitBytes(ctx, sub, 1000)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
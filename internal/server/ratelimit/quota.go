@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+type usageKey struct {
+	scope Scope
+	owner uuid.UUID
+	month string // YYYY-MM
+}
+
+// usage is the traffic of a user or organization in a month.
+type usage struct {
+	bytes   int64 // Total, including pending
+	pending int64 // Not yet written to the database
+}
+
+// quotaLimit is the monthly transfer quota of one scope of a subject.
+type quotaLimit struct {
+	scope Scope
+	owner uuid.UUID
+	quota int64
+}
+
+// AddTransfer adds n bytes of traffic of sub to the current month of its
+// user and organization. Only scopes with a quota are tracked.
+func (l *Limiter) AddTransfer(sub Subject, n int64) {
+	if l == nil || n <= 0 {
+		return
+	}
+	month := monthOf(l.now())
+	for _, q := range l.quotas(sub) {
+		l.usageMu.Lock()
+		u := l.usageOf(usageKey{q.scope, q.owner, month})
+		u.bytes += n
+		u.pending += n
+		l.usageMu.Unlock()
+	}
+}
+
+// Transfer returns the traffic of a user or organization this month.
+func (l *Limiter) Transfer(scope Scope, owner uuid.UUID) int64 {
+	if l == nil {
+		return 0
+	}
+	l.usageMu.Lock()
+	defer l.usageMu.Unlock()
+	return l.usageOf(usageKey{scope, owner, monthOf(l.now())}).bytes
+}
+
+// Flush writes the traffic added since the last flush to the database.
+func (l *Limiter) Flush(ctx context.Context) error {
+	if l == nil || l.db == nil {
+		return nil
+	}
+	current := monthOf(l.now())
+
+	l.usageMu.Lock()
+	pending := make(map[usageKey]int64)
+	for key, u := range l.usage {
+		if u.pending > 0 {
+			pending[key] = u.pending
+			u.pending = 0
+		}
+		if key.month != current {
+			delete(l.usage, key)
+		}
+	}
+	l.usageMu.Unlock()
+
+	var errs []error
+	for key, n := range pending {
+		err := l.db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "scope"}, {Name: "owner_id"}, {Name: "month"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"bytes":      gorm.Expr("transfer_usages.bytes + ?", n),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&models.TransferUsage{
+			Scope:   string(key.scope),
+			OwnerID: key.owner,
+			Month:   key.month,
+			Bytes:   n,
+		}).Error
+		if err != nil {
+			// Keep the traffic for the next flush
+			l.usageMu.Lock()
+			if u, ok := l.usage[key]; ok {
+				u.pending += n
+			}
+			l.usageMu.Unlock()
+			errs = append(errs, fmt.Errorf("%s %s: %w", key.scope, key.owner, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to save transfer usage: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// checkTransfer returns the quota sub has exhausted this month, or nil.
+func (l *Limiter) checkTransfer(sub Subject, now time.Time) *Exceeded {
+	month := monthOf(now)
+	for _, q := range l.quotas(sub) {
+		l.usageMu.Lock()
+		used := l.usageOf(usageKey{q.scope, q.owner, month}).bytes
+		l.usageMu.Unlock()
+		if used >= q.quota {
+			return &Exceeded{Scope: q.scope, Kind: KindTransfer, RetryAfter: nextMonth(now).Sub(now)}
+		}
+	}
+	return nil
+}
+
+// quotas returns the monthly transfer quotas of sub.
+func (l *Limiter) quotas(sub Subject) []quotaLimit {
+	var quotas []quotaLimit
+	if l.cfg.UserTransfer > 0 {
+		quotas = append(quotas, quotaLimit{ScopeUser, sub.User, l.cfg.UserTransfer})
+	}
+	if sub.Organization != nil && l.cfg.OrganizationTransfer > 0 {
+		quotas = append(quotas, quotaLimit{ScopeOrganization, *sub.Organization, l.cfg.OrganizationTransfer})
+	}
+	return quotas
+}
+
+// usageOf returns the usage of key, loading it from the database on first
+// use. The caller holds l.usageMu.
+func (l *Limiter) usageOf(key usageKey) *usage {
+	if u, ok := l.usage[key]; ok {
+		return u
+	}
+
+	u := &usage{}
+	if l.db != nil {
+		var row models.TransferUsage
+		err := l.db.Where("scope = ? AND owner_id = ? AND month = ?", string(key.scope), key.owner, key.month).First(&row).Error
+		switch {
+		case err == nil:
+			u.bytes = row.Bytes
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			logger.WarnEvent().
+				Err(err).
+				Str("scope", string(key.scope)).
+				Str("owner_id", key.owner.String()).
+				Msg("Failed to load transfer usage")
+		}
+	}
+	l.usage[key] = u
+	return u
+}
+
+// monthOf returns the calendar month (UTC) of t as YYYY-MM.
+func monthOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// nextMonth returns the start of the calendar month (UTC) after t.
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.TransferUsage{}))
+	return db
+}
+
+func TestLimiter_Transfer(t *testing.T) {
+	database := setupTestDB(t)
+	ctx := context.Background()
+	orgID := uuid.New()
+	cfg := Config{UserTransfer: 1000, OrganizationTransfer: 5000}
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New(), Organization: &orgID}
+
+	l, clock := newTestLimiter(cfg)
+	l.db = database
+
+	l.AddTransfer(sub, 600)
+	assert.Nil(t, l.AllowRequest(sub))
+	require.NoError(t, l.Flush(ctx))
+
+	l.AddTransfer(sub, 400)
+	exceeded := l.AllowRequest(sub)
+	require.NotNil(t, exceeded)
+	assert.Equal(t, ScopeUser, exceeded.Scope)
+	assert.Equal(t, KindTransfer, exceeded.Kind)
+	assert.Equal(t, "user monthly transfer quota exceeded", exceeded.Error())
+	assert.Equal(t, 17*24*time.Hour-12*time.Hour, exceeded.RetryAfter) // Until April 1st
+
+	_, err := l.WaitBytes(ctx, sub, 1)
+	assert.Equal(t, exceeded, err)
+
+	// Flushes add to the stored usage
+	require.NoError(t, l.Flush(ctx))
+	var rows []models.TransferUsage
+	require.NoError(t, database.Order("scope").Find(&rows).Error)
+	require.Len(t, rows, 2)
+	assert.Equal(t, models.UsageScopeOrganization, rows[0].Scope)
+	assert.Equal(t, orgID, rows[0].OwnerID)
+	assert.Equal(t, int64(1000), rows[0].Bytes)
+	assert.Equal(t, models.UsageScopeUser, rows[1].Scope)
+	assert.Equal(t, "2026-03", rows[1].Month)
+	assert.Equal(t, int64(1000), rows[1].Bytes)
+
+	// A restarted server loads the usage of the month
+	restarted, restartedClock := newTestLimiter(cfg)
+	restarted.db = database
+	restartedClock.t = clock.t
+	assert.Equal(t, int64(1000), restarted.Transfer(ScopeUser, sub.User))
+	assert.NotNil(t, restarted.AllowRequest(sub))
+
+	// The quota resets with the month
+	restartedClock.t = time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, restarted.AllowRequest(sub))
+	assert.Zero(t, restarted.Transfer(ScopeUser, sub.User))
+}
+
+func TestLimiter_Transfer_Untracked(t *testing.T) {
+	l, _ := newTestLimiter(Config{UserTransfer: 1000})
+	sub := Subject{Tunnel: uuid.New(), User: uuid.New()}
+
+	l.AddTransfer(sub, 500)
+	assert.Equal(t, int64(500), l.Transfer(ScopeUser, sub.User))
+	assert.Len(t, l.usage, 1) // No organization, and no tunnel quota
+	assert.NoError(t, l.Flush(context.Background()))
+}
@@ -0,0 +1,84 @@
+package tls
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestManager_ACME issues an on-demand certificate from a local ACME test
+// server. It runs when GROK_TEST_ACME_DIRECTORY is set, e.g. for Pebble
+// started with PEBBLE_VA_ALWAYS_VALID=1 (see make test-acme); set
+// GROK_TEST_ACME_CA to the CA certificate of its directory endpoint.
+func TestManager_ACME(t *testing.T) {
+	directory := os.Getenv("GROK_TEST_ACME_DIRECTORY")
+	if directory == "" {
+		t.Skip("GROK_TEST_ACME_DIRECTORY not set")
+	}
+
+	certDir := t.TempDir()
+	newManager := func() *Manager {
+		m, err := NewManager(Config{
+			AutoCert:      true,
+			CertDir:       certDir,
+			Domain:        "grok.test",
+			Email:         "admin@grok.test",
+			ACMEDirectory: directory,
+			ACMECAFile:    os.Getenv("GROK_TEST_ACME_CA"),
+		})
+		require.NoError(t, err)
+		m.SetSubdomainChecker(liveSubdomains{"myapp": true})
+		return m
+	}
+
+	m := newManager()
+	cert, err := m.GetTLSConfig().GetCertificate(renewalHello("myapp.grok.test"))
+	require.NoError(t, err)
+	require.NotNil(t, cert.Leaf)
+	assert.Contains(t, cert.Leaf.DNSNames, "myapp.grok.test")
+	assert.FileExists(t, filepath.Join(certDir, "myapp.grok.test"))
+
+	// Subdomains without a tunnel get nothing
+	_, err = m.GetTLSConfig().GetCertificate(renewalHello("gone.grok.test"))
+	assert.Error(t, err)
+
+	// A restarted server picks the certificate up from the cache for renewal
+	restarted := newManager()
+	restarted.issuance = newIssuanceLimiter(1)
+	restarted.renewCached(context.Background())
+	assert.Empty(t, restarted.issuance.issued, "cached certificate must not be issued again")
+}
+
+// TestManager_ACMEWildcard obtains a wildcard certificate with DNS-01 from a
+// local ACME test server; see TestManager_ACME.
+func TestManager_ACMEWildcard(t *testing.T) {
+	directory := os.Getenv("GROK_TEST_ACME_DIRECTORY")
+	if directory == "" {
+		t.Skip("GROK_TEST_ACME_DIRECTORY not set")
+	}
+
+	certDir := t.TempDir()
+	provider := &recordingProvider{}
+	m, err := NewManager(Config{
+		AutoCert:      true,
+		CertDir:       certDir,
+		Domain:        "grok.test",
+		ACMEDirectory: directory,
+		ACMECAFile:    os.Getenv("GROK_TEST_ACME_CA"),
+		DNSProvider:   provider,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, m.renewWildcard(context.Background()))
+	assert.NotEmpty(t, provider.present)
+	assert.ElementsMatch(t, provider.present, provider.cleanUp)
+
+	cert, err := m.GetTLSConfig().GetCertificate(renewalHello("myapp.grok.test"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"*.grok.test", "grok.test"}, cert.Leaf.DNSNames)
+	assert.FileExists(t, filepath.Join(certDir, "grok.test+wildcard"))
+}
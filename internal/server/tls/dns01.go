@@ -0,0 +1,345 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+const (
+	// accountKeyName is where autocert caches the ACME account key; the
+	// wildcard certificate is obtained with the same account.
+	accountKeyName = "acme_account+key"
+
+	// wildcardRenewBefore is how long before expiry the wildcard certificate is renewed.
+	wildcardRenewBefore = 30 * 24 * time.Hour
+
+	// wildcardRetryInterval is how long to wait after a failed attempt to obtain it.
+	wildcardRetryInterval = time.Hour
+)
+
+// DNSProvider publishes the TXT records that answer ACME DNS-01 challenges.
+type DNSProvider interface {
+	// Present adds a TXT record with value at fqdn, keeping other values.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the TXT record with value at fqdn.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// dnsChallenge is a DNS-01 challenge whose record has been published.
+type dnsChallenge struct {
+	authzURL  string
+	challenge *acme.Challenge
+	fqdn      string
+	value     string
+}
+
+// wildcardCacheKey returns the cache key of the wildcard certificate.
+func (m *Manager) wildcardCacheKey() string {
+	return m.config.Domain + "+wildcard"
+}
+
+// coveredByWildcard reports whether the wildcard certificate is valid for host:
+// the domain itself or a single-label subdomain of it.
+func (m *Manager) coveredByWildcard(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == m.config.Domain {
+		return true
+	}
+	label, ok := strings.CutSuffix(host, "."+m.config.Domain)
+	return ok && label != "" && !strings.Contains(label, ".")
+}
+
+// getCertificate serves the wildcard certificate for the hosts it covers and
+// leaves the rest (custom domains, ACME TLS-ALPN challenges) to autocert.
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := m.wildcard.Load(); cert != nil && m.coveredByWildcard(hello.ServerName) && !isALPNChallenge(hello) {
+		return cert, nil
+	}
+	return m.autocertMgr.GetCertificate(hello)
+}
+
+// isALPNChallenge reports whether hello is an ACME TLS-ALPN-01 validation.
+func isALPNChallenge(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acme.ALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWildcard loads the cached wildcard certificate, if any, for serving.
+func (m *Manager) loadWildcard(ctx context.Context) error {
+	data, err := m.autocertMgr.Cache.Get(ctx, m.wildcardCacheKey())
+	if err != nil {
+		if errors.Is(err, autocert.ErrCacheMiss) {
+			return nil
+		}
+		return err
+	}
+
+	// The key and the chain are stored in one PEM file, like autocert's
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return fmt.Errorf("invalid cached wildcard certificate: %w", err)
+	}
+	m.wildcard.Store(&cert)
+	return nil
+}
+
+// renewWildcardInBackground obtains the wildcard certificate when it is
+// missing or about to expire, until ctx is done. Failed attempts are retried
+// after wildcardRetryInterval.
+func (m *Manager) renewWildcardInBackground(ctx context.Context) {
+	for {
+		next := renewCheckInterval
+		if err := m.renewWildcard(ctx); err != nil {
+			logger.ErrorEvent().Err(err).Str("domain", m.config.Domain).Msg("Failed to obtain wildcard certificate")
+			next = wildcardRetryInterval
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// renewWildcard obtains a new wildcard certificate unless the current one is
+// valid for more than wildcardRenewBefore, and swaps it in.
+func (m *Manager) renewWildcard(ctx context.Context) error {
+	if cert := m.wildcard.Load(); cert != nil && time.Until(cert.Leaf.NotAfter) > wildcardRenewBefore {
+		return nil
+	}
+
+	cert, err := m.obtainWildcard(ctx)
+	if err != nil {
+		return err
+	}
+	m.wildcard.Store(cert)
+
+	logger.InfoEvent().
+		Str("domain", m.config.Domain).
+		Time("not_after", cert.Leaf.NotAfter).
+		Msg("Wildcard certificate obtained")
+	return nil
+}
+
+// obtainWildcard orders a certificate for the domain and *.domain, answering
+// the DNS-01 challenges through the DNS provider, and caches it.
+func (m *Manager) obtainWildcard(ctx context.Context) (*tls.Certificate, error) {
+	client, err := m.registeredClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{"*." + m.config.Domain, m.config.Domain}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+
+	// Both names are validated at _acme-challenge.<domain>, so every record
+	// is published before any challenge is accepted
+	challenges, err := m.presentChallenges(ctx, client, order.AuthzURLs)
+	defer m.cleanUpChallenges(challenges)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(challenges) > 0 && m.config.DNSPropagationDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(m.config.DNSPropagationDelay):
+		}
+	}
+
+	for _, c := range challenges {
+		if _, err := client.Accept(ctx, c.challenge); err != nil {
+			return nil, fmt.Errorf("failed to accept challenge for %s: %w", c.fqdn, err)
+		}
+	}
+	for _, c := range challenges {
+		if _, err := client.WaitAuthorization(ctx, c.authzURL); err != nil {
+			return nil, fmt.Errorf("authorization for %s failed: %w", c.fqdn, err)
+		}
+	}
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order failed: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	data, err := encodeCertificate(key, chain)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.autocertMgr.Cache.Put(ctx, m.wildcardCacheKey(), data); err != nil {
+		logger.WarnEvent().Err(err).Msg("Failed to cache wildcard certificate")
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate issued: %w", err)
+	}
+	return &cert, nil
+}
+
+// presentChallenges publishes the DNS-01 records of the pending
+// authorizations. It returns the challenges published so far, also on error.
+func (m *Manager) presentChallenges(ctx context.Context, client *acme.Client, authzURLs []string) ([]dnsChallenge, error) {
+	var challenges []dnsChallenge
+	for _, authzURL := range authzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return challenges, fmt.Errorf("failed to get authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return challenges, fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+		}
+
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return challenges, err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+		if err := m.config.DNSProvider.Present(ctx, fqdn, value); err != nil {
+			return challenges, fmt.Errorf("failed to publish %s: %w", fqdn, err)
+		}
+
+		challenges = append(challenges, dnsChallenge{authzURL: authzURL, challenge: challenge, fqdn: fqdn, value: value})
+	}
+	return challenges, nil
+}
+
+// cleanUpChallenges removes the published challenge records.
+func (m *Manager) cleanUpChallenges(challenges []dnsChallenge) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, c := range challenges {
+		if err := m.config.DNSProvider.CleanUp(ctx, c.fqdn, c.value); err != nil {
+			logger.WarnEvent().Err(err).Str("fqdn", c.fqdn).Msg("Failed to remove DNS challenge record")
+		}
+	}
+}
+
+// registeredClient returns an ACME client for the configured directory with
+// the account autocert uses, registering it when new.
+func (m *Manager) registeredClient(ctx context.Context) (*acme.Client, error) {
+	client, err := newACMEClient(m.config.ACMEDirectory, m.config.ACMECAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client.Key = key
+
+	account := &acme.Account{}
+	if m.config.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	return client, nil
+}
+
+// accountKey loads the cached ACME account key, creating it when missing.
+func (m *Manager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := m.autocertMgr.Cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, errors.New("invalid cached ACME account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.autocertMgr.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("failed to cache ACME account key: %w", err)
+	}
+	return key, nil
+}
+
+// encodeCertificate encodes key and chain in one PEM file.
+func encodeCertificate(key *ecdsa.PrivateKey, chain [][]byte) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	for _, cert := range chain {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,154 @@
+package tls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingProvider records the challenge records it is asked to publish.
+type recordingProvider struct {
+	mu      sync.Mutex
+	present []string
+	cleanUp []string
+}
+
+func (p *recordingProvider) Present(_ context.Context, fqdn, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.present = append(p.present, fqdn+" "+value)
+	return nil
+}
+
+func (p *recordingProvider) CleanUp(_ context.Context, fqdn, value string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanUp = append(p.cleanUp, fqdn+" "+value)
+	return nil
+}
+
+// cacheWildcard writes a self-signed wildcard certificate for domain, valid
+// for validFor, to the cache in certDir.
+func cacheWildcard(t *testing.T, certDir, domain string, validFor time.Duration) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*." + domain},
+		DNSNames:     []string{"*." + domain, domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "*." + domain}}, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	data, err := encodeCertificate(key, [][]byte{der})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(certDir, domain+"+wildcard"), data, 0o600))
+}
+
+// newWildcardManager creates an autocert manager for grok.example.com with a
+// DNS provider and the certificates cached in certDir.
+func newWildcardManager(t *testing.T, certDir string, provider DNSProvider) *Manager {
+	t.Helper()
+
+	m, err := NewManager(Config{
+		AutoCert:    true,
+		CertDir:     certDir,
+		Domain:      "grok.example.com",
+		DNSProvider: provider,
+		CustomDomains: func(_ context.Context, host string) error {
+			if host == "demo.ourcompany.com" {
+				return nil
+			}
+			return errors.New("not verified")
+		},
+	})
+	require.NoError(t, err)
+	return m
+}
+
+// TestManager_Wildcard tests that the cached wildcard certificate is served
+// for the domain and its subdomains only.
+func TestManager_Wildcard(t *testing.T) {
+	certDir := t.TempDir()
+	cacheWildcard(t, certDir, "grok.example.com", 60*24*time.Hour)
+	provider := &recordingProvider{}
+	m := newWildcardManager(t, certDir, provider)
+	getCertificate := m.GetTLSConfig().GetCertificate
+
+	for _, host := range []string{"grok.example.com", "myapp.grok.example.com", "MyApp.Grok.Example.com"} {
+		cert, err := getCertificate(renewalHello(host))
+		require.NoError(t, err, host)
+		assert.Equal(t, "*.grok.example.com", cert.Leaf.Subject.CommonName, host)
+	}
+
+	// Deeper names are not covered and not issued on demand either
+	_, err := getCertificate(renewalHello("a.b.grok.example.com"))
+	assert.Error(t, err)
+
+	// Subdomains are never issued on demand; custom domains still are
+	m.SetSubdomainChecker(liveSubdomains{"myapp": true})
+	assert.Error(t, m.hostPolicy(context.Background(), "myapp.grok.example.com"))
+	assert.Error(t, m.hostPolicy(context.Background(), "grok.example.com"))
+	assert.NoError(t, m.hostPolicy(context.Background(), "demo.ourcompany.com"))
+
+	// A certificate far from expiry is not renewed
+	require.NoError(t, m.renewWildcard(context.Background()))
+	assert.Empty(t, provider.present)
+}
+
+// TestManager_WildcardSwap tests that a renewed certificate is served
+// without rebuilding the TLS config.
+func TestManager_WildcardSwap(t *testing.T) {
+	certDir := t.TempDir()
+	m := newWildcardManager(t, certDir, &recordingProvider{})
+	getCertificate := m.GetTLSConfig().GetCertificate
+	assert.Nil(t, m.wildcard.Load())
+
+	cacheWildcard(t, certDir, "grok.example.com", 60*24*time.Hour)
+	require.NoError(t, m.loadWildcard(context.Background()))
+
+	cert, err := getCertificate(renewalHello("myapp.grok.example.com"))
+	require.NoError(t, err)
+	assert.Same(t, m.wildcard.Load(), cert)
+}
+
+// TestCoveredByWildcard tests which hosts the wildcard certificate is valid for.
+func TestCoveredByWildcard(t *testing.T) {
+	m := &Manager{config: Config{Domain: "grok.example.com"}}
+
+	tests := []struct {
+		host    string
+		covered bool
+	}{
+		{host: "grok.example.com", covered: true},
+		{host: "grok.example.com.", covered: true},
+		{host: "myapp.grok.example.com", covered: true},
+		{host: "a.b.grok.example.com", covered: false},
+		{host: ".grok.example.com", covered: false},
+		{host: "evilgrok.example.com", covered: false},
+		{host: "demo.ourcompany.com", covered: false},
+		{host: "", covered: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			assert.Equal(t, tt.covered, m.coveredByWildcard(tt.host))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package tls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ExecProvider publishes challenge records by running a hook command, for
+// DNS services without a built-in provider. The command is run as
+//
+//	<command> present <fqdn> <value>
+//	<command> cleanup <fqdn> <value>
+//
+// and must exit with status 0 once the record is added or removed.
+type ExecProvider struct {
+	command string
+}
+
+// NewExecProvider creates a DNS provider that runs command.
+func NewExecProvider(command string) (*ExecProvider, error) {
+	if command == "" {
+		return nil, errors.New("exec: command is required")
+	}
+	return &ExecProvider{command: command}, nil
+}
+
+// Present runs the command with "present".
+func (p *ExecProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp runs the command with "cleanup".
+func (p *ExecProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// run runs the command, including its output in the error when it fails.
+func (p *ExecProvider) run(ctx context.Context, action, fqdn, value string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command, action, fqdn, value) //nolint:gosec // The command is set by the server admin
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("exec: %s %s failed: %w: %s", p.command, action, err, out)
+		}
+		return fmt.Errorf("exec: %s %s failed: %w", p.command, action, err)
+	}
+	return nil
+}
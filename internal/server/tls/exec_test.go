@@ -0,0 +1,48 @@
+package tls
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExecProvider tests that the hook is run with the action, name and value.
+func TestExecProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook script needs a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "calls")
+	hook := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(hook, []byte(`#!/bin/sh
+if [ "$3" = "fail" ]; then
+  echo "zone is read-only" >&2
+  exit 1
+fi
+echo "$1 $2 $3" >> "`+logFile+`"
+`), 0o700))
+
+	p, err := NewExecProvider(hook)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, p.Present(ctx, "_acme-challenge.grok.example.com.", "abc"))
+	require.NoError(t, p.CleanUp(ctx, "_acme-challenge.grok.example.com.", "abc"))
+
+	calls, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Equal(t, "present _acme-challenge.grok.example.com. abc\ncleanup _acme-challenge.grok.example.com. abc\n", string(calls))
+
+	err = p.Present(ctx, "_acme-challenge.grok.example.com.", "fail")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "zone is read-only")
+
+	_, err = NewExecProvider("")
+	assert.Error(t, err)
+}
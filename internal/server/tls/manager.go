@@ -1,29 +1,59 @@
 package tls
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
 )
 
 // Config holds TLS configuration.
 type Config struct {
-	AutoCert    bool
-	CertDir     string
-	Domain      string
-	CertFile    string
-	KeyFile     string
-	Email       string // Email for Let's Encrypt registration
-	DNSProvider string // DNS provider for DNS-01 challenge (cloudflare, route53, etc)
+	AutoCert bool
+	CertDir  string
+	Domain   string
+	CertFile string
+	KeyFile  string
+	Email    string // Email for Let's Encrypt registration
+
+	// DNSProvider switches the domain and its subdomains to one wildcard
+	// certificate obtained with DNS-01 challenges. Optional.
+	DNSProvider         DNSProvider
+	DNSPropagationDelay time.Duration // Wait between publishing challenge records and validation
+
+	MaxCertsPerHour int    // Cap on newly issued certificates per hour; 0 for no cap
+	ACMEDirectory   string // ACME directory URL (default: Let's Encrypt)
+	ACMECAFile      string // Optional: CA trusted for the ACME directory, e.g. a local test server
+
+	// CustomDomains allows certificates for hosts outside Domain, e.g. verified
+	// custom domains. Optional.
+	CustomDomains autocert.HostPolicy
 }
 
-// Manager handles TLS certificate management.
+// Manager handles TLS certificate management. With AutoCert, certificates
+// for subdomains of Domain are obtained on demand, on the first handshake
+// for a subdomain that is in use, or covered by a wildcard certificate when
+// a DNS provider is configured.
 type Manager struct {
 	config      Config
 	autocertMgr *autocert.Manager
 	tlsConfig   *tls.Config
+
+	subdomains SubdomainChecker // Set with SetSubdomainChecker
+	issuance   *issuanceLimiter
+
+	wildcard atomic.Pointer[tls.Certificate] // Swapped in when renewed
 }
 
 // NewManager creates a new TLS manager.
@@ -33,17 +63,38 @@ func NewManager(cfg Config) (*Manager, error) {
 	}
 
 	if cfg.AutoCert {
-		// Setup autocert for Let's Encrypt
+		// Setup autocert for Let's Encrypt; issued certificates are cached in
+		// CertDir and renewed by autocert before they expire
+		m.config.Domain = strings.ToLower(cfg.Domain)
+		m.issuance = newIssuanceLimiter(cfg.MaxCertsPerHour)
 		m.autocertMgr = &autocert.Manager{
 			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(cfg.Domain),
+			HostPolicy: m.hostPolicy,
 			Cache:      autocert.DirCache(cfg.CertDir),
+			Email:      cfg.Email,
+		}
+
+		if cfg.ACMEDirectory != "" || cfg.ACMECAFile != "" {
+			client, err := newACMEClient(cfg.ACMEDirectory, cfg.ACMECAFile)
+			if err != nil {
+				return nil, err
+			}
+			m.autocertMgr.Client = client
 		}
 
 		m.tlsConfig = &tls.Config{
 			GetCertificate: m.autocertMgr.GetCertificate,
 			MinVersion:     tls.VersionTLS12,
 		}
+
+		if cfg.DNSProvider != nil {
+			// Serve the cached wildcard certificate right away; it is
+			// obtained or renewed by RenewInBackground
+			if err := m.loadWildcard(context.Background()); err != nil {
+				logger.WarnEvent().Err(err).Msg("Failed to load cached wildcard certificate")
+			}
+			m.tlsConfig.GetCertificate = m.getCertificate
+		}
 	} else if cfg.CertFile != "" && cfg.KeyFile != "" {
 		// Load manual certificates
 		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
@@ -60,6 +111,38 @@ func NewManager(cfg Config) (*Manager, error) {
 	return m, nil
 }
 
+// newACMEClient creates an ACME client for directoryURL, trusting the CA in
+// caFile for it when set.
+func newACMEClient(directoryURL, caFile string) (*acme.Client, error) {
+	if directoryURL == "" {
+		directoryURL = autocert.DefaultACMEDirectory
+	}
+	client := &acme.Client{DirectoryURL: directoryURL}
+
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ACME CA file: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse ACME CA file %s", caFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+
+	return client, nil
+}
+
+// SetSubdomainChecker enables on-demand certificates for the subdomains
+// checker reports in use.
+func (m *Manager) SetSubdomainChecker(checker SubdomainChecker) {
+	m.subdomains = checker
+}
+
 // GetTLSConfig returns the TLS configuration.
 func (m *Manager) GetTLSConfig() *tls.Config {
 	return m.tlsConfig
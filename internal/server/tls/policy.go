@@ -0,0 +1,125 @@
+package tls
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// issuanceWindow is the period MaxCertsPerHour applies to.
+const issuanceWindow = time.Hour
+
+// SubdomainChecker reports whether a subdomain of the server domain is in
+// use by a live tunnel or a reservation.
+type SubdomainChecker interface {
+	SubdomainInUse(ctx context.Context, subdomain string) bool
+}
+
+// hostPolicy allows certificates for the server domain, its subdomains that
+// are in use and, when configured, custom domains. Certificates not in the
+// cache yet count against the hourly issuance cap. With a DNS provider, the
+// domain and its subdomains are left to the wildcard certificate.
+//
+// autocert calls it on every handshake, before looking in its cache, so hosts
+// allowed recently are let through without checking again.
+func (m *Manager) hostPolicy(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+	if m.config.DNSProvider != nil && m.coveredByWildcard(host) {
+		return fmt.Errorf("host %q is served by the wildcard certificate", host)
+	}
+	if host == m.config.Domain || m.issuance.recentlyAllowed(host) {
+		return nil
+	}
+
+	if !m.knownHost(ctx, host) {
+		return fmt.Errorf("no tunnel or verified domain for host %q", host)
+	}
+	return m.issuance.allow(host, m.cached(ctx, host))
+}
+
+// knownHost reports whether host is a subdomain in use or an allowed custom domain.
+func (m *Manager) knownHost(ctx context.Context, host string) bool {
+	if subdomain, ok := strings.CutSuffix(host, "."+m.config.Domain); ok {
+		// Only one level: the wildcard of the base domain
+		return m.subdomains != nil && !strings.Contains(subdomain, ".") && m.subdomains.SubdomainInUse(ctx, subdomain)
+	}
+	return m.config.CustomDomains != nil && m.config.CustomDomains(ctx, host) == nil
+}
+
+// cached reports whether a certificate for host is in the cache.
+func (m *Manager) cached(ctx context.Context, host string) bool {
+	for _, name := range []string{host, host + "+rsa"} {
+		if _, err := m.autocertMgr.Cache.Get(ctx, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// issuanceLimiter caps the number of new certificates per hour, protecting
+// the CA account from rate limits when many subdomains appear at once.
+type issuanceLimiter struct {
+	max int // New certificates per issuanceWindow; 0 for no cap
+	now func() time.Time
+
+	mu      sync.Mutex
+	allowed map[string]time.Time // host → when it was last allowed
+	issued  []time.Time          // New certificates within the window, oldest first
+}
+
+// newIssuanceLimiter creates a limiter allowing max new certificates per hour.
+func newIssuanceLimiter(maxPerHour int) *issuanceLimiter {
+	return &issuanceLimiter{
+		max:     maxPerHour,
+		now:     time.Now,
+		allowed: make(map[string]time.Time),
+	}
+}
+
+// recentlyAllowed reports whether host was allowed within the window.
+func (l *issuanceLimiter) recentlyAllowed(host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at, ok := l.allowed[host]
+	return ok && l.now().Sub(at) < issuanceWindow
+}
+
+// allow records host as allowed. Hosts without a cached certificate need a
+// new one and are refused once the cap is reached.
+func (l *issuanceLimiter) allow(host string, cached bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	if !cached {
+		if l.max > 0 && len(l.issued) >= l.max {
+			return fmt.Errorf("%w: %d new certificates in the last hour, not issuing for %q", pkgerrors.ErrRateLimited, len(l.issued), host)
+		}
+		l.issued = append(l.issued, now)
+	}
+
+	l.allowed[host] = now
+	return nil
+}
+
+// prune forgets issuances and allowed hosts older than the window.
+func (l *issuanceLimiter) prune(now time.Time) {
+	i := 0
+	for i < len(l.issued) && now.Sub(l.issued[i]) >= issuanceWindow {
+		i++
+	}
+	l.issued = l.issued[i:]
+
+	for host, at := range l.allowed {
+		if now.Sub(at) >= issuanceWindow {
+			delete(l.allowed, host)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tls
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// liveSubdomains reports the subdomains in the map as in use.
+type liveSubdomains map[string]bool
+
+func (s liveSubdomains) SubdomainInUse(_ context.Context, subdomain string) bool {
+	return s[subdomain]
+}
+
+// newAutoCertManager creates an autocert manager for grok.example.com with a
+// temporary cert directory.
+func newAutoCertManager(t *testing.T, maxPerHour int) *Manager {
+	t.Helper()
+
+	m, err := NewManager(Config{
+		AutoCert:        true,
+		CertDir:         t.TempDir(),
+		Domain:          "Grok.Example.com",
+		MaxCertsPerHour: maxPerHour,
+		CustomDomains: func(_ context.Context, host string) error {
+			if host == "demo.ourcompany.com" {
+				return nil
+			}
+			return errors.New("not verified")
+		},
+	})
+	require.NoError(t, err)
+	return m
+}
+
+// TestHostPolicy tests which hosts get certificates.
+func TestHostPolicy(t *testing.T) {
+	m := newAutoCertManager(t, 0)
+	ctx := context.Background()
+
+	// Without a checker only the base domain and custom domains are allowed
+	assert.NoError(t, m.hostPolicy(ctx, "grok.example.com"))
+	assert.Error(t, m.hostPolicy(ctx, "myapp.grok.example.com"))
+
+	m.SetSubdomainChecker(liveSubdomains{"myapp": true, "reserved": true})
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{host: "grok.example.com", allowed: true},
+		{host: "myapp.grok.example.com", allowed: true},
+		{host: "MyApp.grok.example.com", allowed: true},
+		{host: "reserved.grok.example.com", allowed: true},
+		{host: "demo.ourcompany.com", allowed: true},
+		{host: "gone.grok.example.com", allowed: false},
+		{host: "a.myapp.grok.example.com", allowed: false},
+		{host: "myapp.grok.example.com.evil.com", allowed: false},
+		{host: "other.ourcompany.com", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := m.hostPolicy(ctx, tt.host)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// TestHostPolicy_IssuanceCap tests the hourly cap on new certificates.
+func TestHostPolicy_IssuanceCap(t *testing.T) {
+	m := newAutoCertManager(t, 2)
+	m.SetSubdomainChecker(liveSubdomains{"one": true, "two": true, "three": true, "cached": true})
+	ctx := context.Background()
+
+	now := time.Now()
+	m.issuance.now = func() time.Time { return now }
+
+	require.NoError(t, m.hostPolicy(ctx, "one.grok.example.com"))
+	require.NoError(t, m.hostPolicy(ctx, "two.grok.example.com"))
+
+	// Cap reached: new hosts wait, hosts already allowed keep working
+	assert.ErrorIs(t, m.hostPolicy(ctx, "three.grok.example.com"), pkgerrors.ErrRateLimited)
+	assert.NoError(t, m.hostPolicy(ctx, "one.grok.example.com"))
+
+	// Certificates in the cache don't need issuing
+	require.NoError(t, os.WriteFile(filepath.Join(m.config.CertDir, "cached.grok.example.com"), []byte("cert"), 0o600))
+	assert.NoError(t, m.hostPolicy(ctx, "cached.grok.example.com"))
+
+	now = now.Add(time.Hour)
+	assert.NoError(t, m.hostPolicy(ctx, "three.grok.example.com"))
+}
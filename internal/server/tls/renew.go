@@ -0,0 +1,82 @@
+package tls
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// renewCheckInterval is how often cached certificates are checked for renewal.
+const renewCheckInterval = 12 * time.Hour
+
+// RenewInBackground keeps the cached certificates of hosts still in use
+// renewed until ctx is done. autocert renews the certificates it has loaded;
+// this loads those that saw no handshake since the server started, so they
+// don't expire unnoticed. Certificates of subdomains no longer in use are
+// not loaded. With a DNS provider, it also obtains and renews the wildcard
+// certificate.
+func (m *Manager) RenewInBackground(ctx context.Context) {
+	if m.autocertMgr == nil {
+		return
+	}
+	if m.config.DNSProvider != nil {
+		go m.renewWildcardInBackground(ctx)
+	}
+
+	ticker := time.NewTicker(renewCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		m.renewCached(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewCached loads every cached certificate whose host is still allowed,
+// which starts its renewal timer and replaces it right away when expired.
+func (m *Manager) renewCached(ctx context.Context) {
+	entries, err := os.ReadDir(m.config.CertDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.WarnEvent().Err(err).Str("cert_dir", m.config.CertDir).Msg("Failed to list cached certificates")
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		// ECDSA certificates are cached under the bare host name; other
+		// entries (account key, RSA and challenge certificates) have a suffix
+		host := entry.Name()
+		if entry.IsDir() || strings.Contains(host, "+") {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if _, err := m.autocertMgr.GetCertificate(renewalHello(host)); err != nil {
+			logger.DebugEvent().Err(err).Str("host", host).Msg("Not renewing cached certificate")
+			continue
+		}
+		logger.DebugEvent().Str("host", host).Msg("Cached certificate loaded for renewal")
+	}
+}
+
+// renewalHello returns a ClientHello for host that selects its ECDSA certificate.
+func renewalHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	}
+}
@@ -0,0 +1,317 @@
+package tls
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // hmac-sha1 is a TSIG algorithm some servers still use
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// challengeTTL is the TTL of published challenge records.
+	challengeTTL = 60
+
+	// rfc2136Timeout bounds a single DNS update exchange.
+	rfc2136Timeout = 10 * time.Second
+
+	// tsigFudge is the clock skew, in seconds, the server may allow for a signature.
+	tsigFudge = 300
+
+	opCodeUpdate = dnsmessage.OpCode(5)
+	classNone    = dnsmessage.Class(254) // Deletes a single record in an update
+	typeTSIG     = dnsmessage.Type(250)
+)
+
+// tsigAlgorithms maps the supported TSIG algorithm names to their hash.
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// RFC2136Config configures the RFC 2136 dynamic update DNS provider.
+type RFC2136Config struct {
+	Nameserver    string // Primary server of the zone, host[:port] (port 53 by default)
+	Zone          string // Zone the challenge records are in
+	TSIGKey       string // Optional: TSIG key name
+	TSIGSecret    string // TSIG secret (base64)
+	TSIGAlgorithm string // hmac-sha256 (default), hmac-sha512 or hmac-sha1
+}
+
+// RFC2136Provider publishes challenge records with RFC 2136 dynamic updates,
+// signed with TSIG (RFC 8945) when a key is set. Updates are sent over TCP;
+// only the status of the response is checked.
+type RFC2136Provider struct {
+	nameserver string
+	zone       string
+	keyName    string
+	algorithm  string
+	secret     []byte
+	newHash    func() hash.Hash
+	now        func() time.Time
+}
+
+// NewRFC2136Provider creates an RFC 2136 DNS provider.
+func NewRFC2136Provider(cfg RFC2136Config) (*RFC2136Provider, error) {
+	if cfg.Nameserver == "" {
+		return nil, errors.New("rfc2136: nameserver is required")
+	}
+	if cfg.Zone == "" {
+		return nil, errors.New("rfc2136: zone is required")
+	}
+
+	nameserver := cfg.Nameserver
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(strings.Trim(nameserver, "[]"), "53")
+	}
+
+	p := &RFC2136Provider{
+		nameserver: nameserver,
+		zone:       fqdn(cfg.Zone),
+		now:        time.Now,
+	}
+
+	if cfg.TSIGKey != "" {
+		algorithm := strings.TrimSuffix(strings.ToLower(cfg.TSIGAlgorithm), ".")
+		if algorithm == "" {
+			algorithm = "hmac-sha256"
+		}
+		newHash, ok := tsigAlgorithms[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm %q (use hmac-sha256, hmac-sha512 or hmac-sha1)", cfg.TSIGAlgorithm)
+		}
+		secret, err := base64.StdEncoding.DecodeString(cfg.TSIGSecret)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("rfc2136: tsig_secret must be a base64 encoded key")
+		}
+
+		p.keyName = fqdn(cfg.TSIGKey)
+		p.algorithm = algorithm + "."
+		p.secret = secret
+		p.newHash = newHash
+	}
+
+	return p, nil
+}
+
+// Present adds the TXT record.
+func (p *RFC2136Provider) Present(ctx context.Context, name, value string) error {
+	return p.update(ctx, name, value, dnsmessage.ClassINET, challengeTTL)
+}
+
+// CleanUp deletes the TXT record.
+func (p *RFC2136Provider) CleanUp(ctx context.Context, name, value string) error {
+	return p.update(ctx, name, value, classNone, 0)
+}
+
+// update sends an update of the TXT record name with value to the zone:
+// class IN adds it, class NONE deletes it.
+func (p *RFC2136Provider) update(ctx context.Context, name, value string, class dnsmessage.Class, ttl uint32) error {
+	msg, id, err := p.buildUpdate(name, value, class, ttl)
+	if err != nil {
+		return fmt.Errorf("rfc2136: %w", err)
+	}
+	if p.secret != nil {
+		msg = p.sign(msg, id)
+	}
+
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update via %s failed: %w", p.nameserver, err)
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(resp)
+	if err != nil {
+		return fmt.Errorf("rfc2136: invalid response: %w", err)
+	}
+	if header.ID != id || !header.Response {
+		return errors.New("rfc2136: response does not match the update")
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("rfc2136: update of %s rejected: %s", name, rcodeName(header.RCode))
+	}
+	return nil
+}
+
+// buildUpdate builds an update message with a single record in its update section.
+func (p *RFC2136Provider) buildUpdate(name, value string, class dnsmessage.Class, ttl uint32) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	zone, err := dnsmessage.NewName(p.zone)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid zone %q: %w", p.zone, err)
+	}
+	recordName, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid record name %q: %w", name, err)
+	}
+
+	// An update reuses the sections of a query: the zone is the question,
+	// the (empty) prerequisites the answers and the updates the authorities
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: opCodeUpdate})
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return nil, 0, err
+	}
+	if err := b.TXTResource(
+		dnsmessage.ResourceHeader{Name: recordName, Class: class, TTL: ttl},
+		dnsmessage.TXTResource{TXT: []string{value}},
+	); err != nil {
+		return nil, 0, err
+	}
+
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, 0, err
+	}
+	return msg, id, nil
+}
+
+// sign appends a TSIG record to msg.
+func (p *RFC2136Provider) sign(msg []byte, id uint16) []byte {
+	timeSigned := uint64(p.now().Unix())
+
+	mac := hmac.New(p.newHash, p.secret)
+	mac.Write(msg)
+	mac.Write(tsigVariables(p.keyName, p.algorithm, timeSigned))
+	sum := mac.Sum(nil)
+
+	var rdata []byte
+	rdata = appendWireName(rdata, p.algorithm)
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum))) //nolint:gosec // HMAC sums are at most 64 bytes
+	rdata = append(rdata, sum...)
+	rdata = binary.BigEndian.AppendUint16(rdata, id) // Original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)  // Error
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)  // Other length
+
+	signed := appendWireName(msg, p.keyName)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(typeTSIG))
+	signed = binary.BigEndian.AppendUint16(signed, uint16(dnsmessage.ClassANY))
+	signed = binary.BigEndian.AppendUint32(signed, 0)                  // TTL
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata))) //nolint:gosec // Bounded by the names above
+	signed = append(signed, rdata...)
+
+	// One more additional record
+	arcount := binary.BigEndian.Uint16(signed[10:12])
+	binary.BigEndian.PutUint16(signed[10:12], arcount+1)
+	return signed
+}
+
+// exchange sends msg to the nameserver over TCP and reads the response.
+func (p *RFC2136Provider) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, rfc2136Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// TCP messages are prefixed with their length
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(msg))) //nolint:gosec // Updates are far below 64 KiB
+	if _, err := conn.Write(append(out, msg...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// tsigVariables returns the TSIG fields covered by the MAC of a request
+// (RFC 8945, section 4.3.3).
+func tsigVariables(keyName, algorithm string, timeSigned uint64) []byte {
+	var b []byte
+	b = appendWireName(b, keyName)
+	b = binary.BigEndian.AppendUint16(b, uint16(dnsmessage.ClassANY))
+	b = binary.BigEndian.AppendUint32(b, 0) // TTL
+	b = appendWireName(b, algorithm)
+	b = appendUint48(b, timeSigned)
+	b = binary.BigEndian.AppendUint16(b, tsigFudge)
+	b = binary.BigEndian.AppendUint16(b, 0) // Error
+	b = binary.BigEndian.AppendUint16(b, 0) // Other length
+	return b
+}
+
+// appendWireName appends name in canonical wire format: lowercase, uncompressed.
+func appendWireName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// appendUint48 appends the low 48 bits of v.
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// rcodeName names the response codes an update may return.
+func rcodeName(rcode dnsmessage.RCode) string {
+	switch rcode {
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case 9:
+		return "NOTAUTH (check the TSIG key)"
+	case 10:
+		return "NOTZONE"
+	default:
+		return fmt.Sprintf("rcode %d", rcode)
+	}
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
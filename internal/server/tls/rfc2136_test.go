@@ -0,0 +1,263 @@
+package tls
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// updateServer is a DNS server accepting RFC 2136 updates of TXT records
+// over TCP, signed with an hmac-sha256 TSIG key.
+type updateServer struct {
+	addr    string
+	keyName string
+	secret  []byte
+
+	mu      sync.Mutex
+	records map[string][]string // name → TXT values
+}
+
+func newUpdateServer(t *testing.T, keyName string, secret []byte) *updateServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &updateServer{
+		addr:    listener.Addr().String(),
+		keyName: keyName,
+		secret:  secret,
+		records: make(map[string][]string),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *updateServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return
+	}
+
+	id := binary.BigEndian.Uint16(msg[:2])
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:       id,
+		Response: true,
+		OpCode:   opCodeUpdate,
+		RCode:    s.handle(msg),
+	})
+	resp, _ := b.Finish()
+
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+	_, _ = conn.Write(append(out, resp...))
+}
+
+// handle verifies and applies an update.
+func (s *updateServer) handle(msg []byte) dnsmessage.RCode {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil || header.OpCode != opCodeUpdate {
+		return dnsmessage.RCodeFormatError
+	}
+	if _, err := p.AllQuestions(); err != nil {
+		return dnsmessage.RCodeFormatError
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return dnsmessage.RCodeFormatError
+	}
+
+	type change struct {
+		name   string
+		class  dnsmessage.Class
+		values []string
+	}
+	var changes []change
+	for {
+		h, err := p.AuthorityHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil || h.Type != dnsmessage.TypeTXT {
+			return dnsmessage.RCodeFormatError
+		}
+		txt, err := p.TXTResource()
+		if err != nil {
+			return dnsmessage.RCodeFormatError
+		}
+		changes = append(changes, change{name: h.Name.String(), class: h.Class, values: txt.TXT})
+	}
+
+	if s.secret != nil && !s.verify(msg, &p) {
+		return dnsmessage.RCode(9) // NOTAUTH
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range changes {
+		switch c.class {
+		case dnsmessage.ClassINET:
+			s.records[c.name] = append(s.records[c.name], c.values...)
+		case classNone:
+			var kept []string
+			for _, v := range s.records[c.name] {
+				if v != c.values[0] {
+					kept = append(kept, v)
+				}
+			}
+			s.records[c.name] = kept
+		}
+	}
+	return dnsmessage.RCodeSuccess
+}
+
+// verify checks the TSIG record that ends msg, p being at its additional section.
+func (s *updateServer) verify(msg []byte, p *dnsmessage.Parser) bool {
+	h, err := p.AdditionalHeader()
+	if err != nil || h.Type != typeTSIG || h.Name.String() != s.keyName {
+		return false
+	}
+	rr, err := p.UnknownResource()
+	if err != nil {
+		return false
+	}
+	rdata := rr.Data
+
+	// Algorithm name, time signed (6) and fudge (2), then the MAC
+	algLen := 0
+	for rdata[algLen] != 0 {
+		algLen += int(rdata[algLen]) + 1
+	}
+	algLen++
+	fixed := rdata[:algLen+8]
+	macSize := int(binary.BigEndian.Uint16(rdata[algLen+8:]))
+	mac := rdata[algLen+10 : algLen+10+macSize]
+	rest := rdata[algLen+10+macSize:] // Original ID, error, other length
+
+	// The MAC covers the message without its TSIG record
+	tsigLen := len(appendWireName(nil, s.keyName)) + 10 + len(rdata)
+	unsigned := append([]byte(nil), msg[:len(msg)-tsigLen]...)
+	binary.BigEndian.PutUint16(unsigned[10:12], binary.BigEndian.Uint16(unsigned[10:12])-1)
+
+	variables := appendWireName(nil, s.keyName)
+	variables = append(variables, 0, 255, 0, 0, 0, 0) // Class ANY, TTL 0
+	variables = append(variables, fixed...)
+	variables = append(variables, rest[2:]...)
+
+	expected := hmac.New(sha256.New, s.secret)
+	expected.Write(unsigned)
+	expected.Write(variables)
+	return hmac.Equal(mac, expected.Sum(nil))
+}
+
+func (s *updateServer) txt(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[name]
+}
+
+// TestRFC2136Provider tests publishing and removing challenge records with TSIG.
+func TestRFC2136Provider(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	server := newUpdateServer(t, "grok-acme.", secret)
+	ctx := context.Background()
+	name := "_acme-challenge.grok.example.com."
+
+	p, err := NewRFC2136Provider(RFC2136Config{
+		Nameserver: server.addr,
+		Zone:       "grok.example.com",
+		TSIGKey:    "grok-acme",
+		TSIGSecret: base64.StdEncoding.EncodeToString(secret),
+	})
+	require.NoError(t, err)
+
+	// Both challenge values of a wildcard order are kept side by side
+	require.NoError(t, p.Present(ctx, name, "token-wildcard"))
+	require.NoError(t, p.Present(ctx, name, "token-base"))
+	assert.Equal(t, []string{"token-wildcard", "token-base"}, server.txt(name))
+
+	require.NoError(t, p.CleanUp(ctx, name, "token-wildcard"))
+	assert.Equal(t, []string{"token-base"}, server.txt(name))
+
+	t.Run("wrong secret", func(t *testing.T) {
+		wrong, err := NewRFC2136Provider(RFC2136Config{
+			Nameserver: server.addr,
+			Zone:       "grok.example.com",
+			TSIGKey:    "grok-acme",
+			TSIGSecret: base64.StdEncoding.EncodeToString([]byte("not-the-secret")),
+		})
+		require.NoError(t, err)
+
+		err = wrong.Present(ctx, name, "forged")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "NOTAUTH")
+		assert.NotContains(t, server.txt(name), "forged")
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		open := newUpdateServer(t, "", nil)
+		unsigned, err := NewRFC2136Provider(RFC2136Config{Nameserver: open.addr, Zone: "grok.example.com"})
+		require.NoError(t, err)
+
+		require.NoError(t, unsigned.Present(ctx, name, "token"))
+		assert.Equal(t, []string{"token"}, open.txt(name))
+	})
+}
+
+// TestNewRFC2136Provider tests the provider settings.
+func TestNewRFC2136Provider(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+
+	tests := []struct {
+		name    string
+		cfg     RFC2136Config
+		wantErr bool
+	}{
+		{name: "default port", cfg: RFC2136Config{Nameserver: "ns1.example.com", Zone: "example.com"}},
+		{name: "sha512", cfg: RFC2136Config{Nameserver: "ns1.example.com:5353", Zone: "example.com", TSIGKey: "k", TSIGSecret: secret, TSIGAlgorithm: "HMAC-SHA512."}},
+		{name: "no nameserver", cfg: RFC2136Config{Zone: "example.com"}, wantErr: true},
+		{name: "no zone", cfg: RFC2136Config{Nameserver: "ns1.example.com"}, wantErr: true},
+		{name: "unknown algorithm", cfg: RFC2136Config{Nameserver: "ns1", Zone: "example.com", TSIGKey: "k", TSIGSecret: secret, TSIGAlgorithm: "hmac-md5"}, wantErr: true},
+		{name: "secret not base64", cfg: RFC2136Config{Nameserver: "ns1", Zone: "example.com", TSIGKey: "k", TSIGSecret: "%%%"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewRFC2136Provider(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "example.com.", p.zone)
+		})
+	}
+
+	p, err := NewRFC2136Provider(RFC2136Config{Nameserver: "ns1.example.com", Zone: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "ns1.example.com:53", p.nameserver)
+}
@@ -0,0 +1,191 @@
+package tunnel
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// Group is a set of tunnels serving the same subdomain. Clients opt in by
+// registering the same saved name with a load balancing strategy.
+type Group struct {
+	Name      string // Saved name shared by the members
+	Subdomain string
+	UserID    uuid.UUID
+	Balancing tunnelv1.LoadBalancing
+
+	mu      sync.RWMutex
+	members []*Tunnel
+	next    atomic.Uint64 // Round-robin position
+}
+
+// Members returns the tunnels currently in the group.
+func (g *Group) Members() []*Tunnel {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Tunnel(nil), g.members...)
+}
+
+// Member returns the healthy member with the given tunnel ID.
+func (g *Group) Member(tunnelID string) (*Tunnel, bool) {
+	for _, member := range g.healthy() {
+		if member.ID.String() == tunnelID {
+			return member, true
+		}
+	}
+	return nil, false
+}
+
+// Pick selects a healthy member according to the group's strategy.
+// affinity keys sticky groups (e.g. the client IP) and is ignored otherwise.
+func (g *Group) Pick(affinity string) (*Tunnel, bool) {
+	members := g.healthy()
+	if len(members) == 0 {
+		return nil, false
+	}
+
+	switch g.Balancing {
+	case tunnelv1.LoadBalancing_LEAST_INFLIGHT:
+		best := members[0]
+		for _, member := range members[1:] {
+			if member.Inflight() < best.Inflight() {
+				best = member
+			}
+		}
+		return best, true
+
+	case tunnelv1.LoadBalancing_STICKY:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(affinity))
+		return members[h.Sum32()%uint32(len(members))], true
+
+	default:
+		n := g.next.Add(1) - 1
+		return members[n%uint64(len(members))], true
+	}
+}
+
+// healthy returns the members whose stream is still up.
+func (g *Group) healthy() []*Tunnel {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	members := make([]*Tunnel, 0, len(g.members))
+	for _, member := range g.members {
+		if member.GetStatus() == "active" {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// add appends a member to the group.
+func (g *Group) add(tunnel *Tunnel) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members = append(g.members, tunnel)
+}
+
+// remove drops a member, reporting whether it was in the group.
+func (g *Group) remove(tunnel *Tunnel) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i, member := range g.members {
+		if member == tunnel {
+			g.members = append(g.members[:i:i], g.members[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// GetGroup returns the tunnel group serving subdomain, if any.
+func (m *Manager) GetGroup(subdomain string) (*Group, bool) {
+	value, ok := m.groups.Load(subdomain)
+	if !ok {
+		return nil, false
+	}
+	group, ok := value.(*Group)
+	return group, ok
+}
+
+// FindGroup returns the user's active tunnel group with the given saved name.
+func (m *Manager) FindGroup(userID uuid.UUID, name string) (*Group, bool) {
+	var found *Group
+	m.groups.Range(func(_, value interface{}) bool {
+		group, ok := value.(*Group)
+		if ok && group.UserID == userID && group.Name == name {
+			found = group
+			return false
+		}
+		return true
+	})
+	return found, found != nil
+}
+
+// JoinGroup adds a registered tunnel to the group serving its subdomain,
+// creating the group for its first member. The group keeps the strategy of
+// its first member.
+func (m *Manager) JoinGroup(tunnel *Tunnel, name string, balancing tunnelv1.LoadBalancing) *Group {
+	m.groupMu.Lock()
+	defer m.groupMu.Unlock()
+
+	value, loaded := m.groups.LoadOrStore(tunnel.Subdomain, &Group{
+		Name:      name,
+		Subdomain: tunnel.Subdomain,
+		UserID:    tunnel.UserID,
+		Balancing: balancing,
+	})
+	group := value.(*Group)
+	group.add(tunnel)
+
+	if loaded && balancing != group.Balancing {
+		logger.WarnEvent().
+			Str("subdomain", tunnel.Subdomain).
+			Str("requested", balancing.String()).
+			Str("balancing", group.Balancing.String()).
+			Msg("Tunnel group already uses another load balancing strategy")
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnel.ID.String()).
+		Str("subdomain", tunnel.Subdomain).
+		Str("balancing", group.Balancing.String()).
+		Int("members", len(group.Members())).
+		Msg("Tunnel joined group")
+
+	return group
+}
+
+// leaveGroup removes a tunnel from its group. It returns a remaining member to
+// route the subdomain to (nil when none is left) and whether the tunnel was a member.
+func (m *Manager) leaveGroup(tunnel *Tunnel) (next *Tunnel, member bool) {
+	m.groupMu.Lock()
+	defer m.groupMu.Unlock()
+
+	group, ok := m.GetGroup(tunnel.Subdomain)
+	if !ok {
+		return nil, false
+	}
+
+	member = group.remove(tunnel)
+	remaining := group.Members()
+	if len(remaining) == 0 {
+		m.groups.Delete(tunnel.Subdomain)
+		return nil, member
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnel.ID.String()).
+		Str("subdomain", tunnel.Subdomain).
+		Int("members", len(remaining)).
+		Msg("Tunnel left group, failing over to remaining members")
+
+	return remaining[0], member
+}
@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+)
+
+// newGroupMembers returns n active tunnels serving the same subdomain.
+func newGroupMembers(n int) []*Tunnel {
+	userID := uuid.New()
+	members := make([]*Tunnel, n)
+	for i := range members {
+		members[i] = NewTunnel(userID, uuid.New(), nil, "myapp", tunnelv1.TunnelProtocol_HTTP,
+			"localhost:3000", "https://myapp.localhost", nil)
+	}
+	return members
+}
+
+// TestGroup_Pick tests member selection for each load balancing strategy.
+func TestGroup_Pick(t *testing.T) {
+	members := newGroupMembers(3)
+
+	t.Run("round robin", func(t *testing.T) {
+		group := &Group{Balancing: tunnelv1.LoadBalancing_ROUND_ROBIN, members: members}
+		for i := 0; i < 6; i++ {
+			tun, ok := group.Pick("")
+			require.True(t, ok)
+			assert.Same(t, members[i%3], tun)
+		}
+	})
+
+	t.Run("least in-flight", func(t *testing.T) {
+		group := &Group{Balancing: tunnelv1.LoadBalancing_LEAST_INFLIGHT, members: members}
+		end0 := members[0].BeginRequest()
+		end2 := members[2].BeginRequest()
+
+		tun, ok := group.Pick("")
+		require.True(t, ok)
+		assert.Same(t, members[1], tun)
+
+		end0()
+		end2()
+	})
+
+	t.Run("sticky", func(t *testing.T) {
+		group := &Group{Balancing: tunnelv1.LoadBalancing_STICKY, members: members}
+		first, ok := group.Pick("203.0.113.7")
+		require.True(t, ok)
+		for i := 0; i < 5; i++ {
+			tun, _ := group.Pick("203.0.113.7")
+			assert.Same(t, first, tun)
+		}
+	})
+
+	t.Run("skips closed members", func(t *testing.T) {
+		members := newGroupMembers(2)
+		group := &Group{Balancing: tunnelv1.LoadBalancing_ROUND_ROBIN, members: members}
+		members[0].SetStatus("closed")
+
+		for i := 0; i < 3; i++ {
+			tun, ok := group.Pick("")
+			require.True(t, ok)
+			assert.Same(t, members[1], tun)
+		}
+
+		_, ok := group.Member(members[0].ID.String())
+		assert.False(t, ok)
+
+		members[1].SetStatus("closed")
+		_, ok = group.Pick("")
+		assert.False(t, ok)
+	})
+}
+
+// TestManager_GroupFailover tests that a group keeps serving its subdomain while members come and go.
+func TestManager_GroupFailover(t *testing.T) {
+	manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	manager.SetReconnectGrace(0, 0)
+	ctx := context.Background()
+
+	primary := registerPersistentTunnel(t, manager, "myapp")
+	manager.JoinGroup(primary, "myapp", tunnelv1.LoadBalancing_ROUND_ROBIN)
+
+	secondary := NewTunnel(primary.UserID, uuid.New(), nil, primary.Subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3001", primary.PublicURL, nil)
+	require.NoError(t, manager.RegisterTunnel(ctx, secondary))
+	group := manager.JoinGroup(secondary, "myapp", tunnelv1.LoadBalancing_STICKY)
+
+	assert.Equal(t, tunnelv1.LoadBalancing_ROUND_ROBIN, group.Balancing, "first member sets the strategy")
+	assert.Len(t, group.Members(), 2)
+
+	found, ok := manager.FindGroup(primary.UserID, "myapp")
+	require.True(t, ok)
+	assert.Same(t, group, found)
+
+	// The primary goes away; the secondary takes over the subdomain
+	require.NoError(t, manager.UnregisterTunnel(ctx, primary.ID))
+	tun, ok := manager.GetTunnelBySubdomain(primary.Subdomain)
+	require.True(t, ok)
+	assert.Same(t, secondary, tun)
+	assert.Len(t, group.Members(), 1)
+
+	// The last member leaves: the group is gone and its unnamed row removed
+	require.NoError(t, manager.UnregisterTunnel(ctx, secondary.ID))
+	_, ok = manager.GetGroup(primary.Subdomain)
+	assert.False(t, ok)
+	_, ok = manager.GetTunnelBySubdomain(primary.Subdomain)
+	assert.False(t, ok)
+
+	var count int64
+	require.NoError(t, manager.db.Table("tunnels").Where("subdomain = ?", primary.Subdomain).Count(&count).Error)
+	assert.Equal(t, int64(1), count, "only the persistent tunnel is kept")
+}
@@ -12,6 +12,11 @@ import (
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
 	"github.com/pandeptwidyaop/grok/internal/server/tcp"
 	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
@@ -31,6 +36,10 @@ const (
 const (
 	// ProtocolTCP represents the TCP protocol.
 	ProtocolTCP = "tcp"
+	// ProtocolTLS represents TLS passthrough, routed by SNI on the HTTPS port.
+	ProtocolTLS = "tls"
+	// ProtocolUDP represents the UDP protocol.
+	ProtocolUDP = "udp"
 )
 
 // Event represents a tunnel state change event.
@@ -49,6 +58,18 @@ type TCPProxy interface {
 	StopListener(port int) error
 }
 
+// UDPProxy interface for UDP proxy operations.
+type UDPProxy interface {
+	StartListener(port int, tunnelID uuid.UUID) error
+	StopListener(port int) error
+}
+
+// portListener is implemented by both TCPProxy and UDPProxy.
+type portListener interface {
+	StartListener(port int, tunnelID uuid.UUID) error
+	StopListener(port int) error
+}
+
 // Manager manages active tunnels.
 type Manager struct {
 	db                *gorm.DB
@@ -61,8 +82,18 @@ type Manager struct {
 	httpsPort         int           // HTTPS port (default 443)
 	portPool          *tcp.PortPool // TCP port pool for TCP tunnels
 	tcpProxy          TCPProxy      // TCP proxy for starting/stopping listeners
+	udpProxy          UDPProxy      // UDP proxy for starting/stopping listeners
 	eventHandlers     []EventHandler
 	eventMu           sync.RWMutex
+	reconnectGrace    time.Duration      // How long requests are held for a reconnecting persistent tunnel
+	maxHeldRequests   int                // Maximum requests held per reconnecting tunnel (0 = unlimited)
+	reconnecting      sync.Map           // subdomain → *reconnectWait
+	groups            sync.Map           // subdomain → *Group
+	groupMu           sync.Mutex         // Serializes group membership changes
+	accessGuard       *access.Guard      // Enforces the access policies of HTTP tunnels
+	ipFilter          *ipfilter.Filter   // Enforces organization and tunnel IP rules
+	rateLimiter       *ratelimit.Limiter // Enforces rate limits and monthly transfer quotas
+	edgeCache         *edgecache.Cache   // Caches responses of HTTP tunnels that opt in
 }
 
 // NewManager creates a new tunnel manager.
@@ -107,6 +138,83 @@ func (m *Manager) SetTCPProxy(proxy TCPProxy) {
 	m.tcpProxy = proxy
 }
 
+// SetUDPProxy sets the UDP proxy for starting/stopping UDP listeners.
+func (m *Manager) SetUDPProxy(proxy UDPProxy) {
+	m.udpProxy = proxy
+}
+
+// SetAccessGuard sets the guard enforcing tunnel access policies.
+func (m *Manager) SetAccessGuard(guard *access.Guard) {
+	m.accessGuard = guard
+}
+
+// AccessGuard returns the guard enforcing tunnel access policies, nil when
+// access policies are not enabled.
+func (m *Manager) AccessGuard() *access.Guard {
+	return m.accessGuard
+}
+
+// SetIPFilter sets the filter enforcing IP rules.
+func (m *Manager) SetIPFilter(filter *ipfilter.Filter) {
+	m.ipFilter = filter
+}
+
+// IPFilter returns the filter enforcing IP rules. When nil, only the rules
+// of tunnels are enforced, without country matching.
+func (m *Manager) IPFilter() *ipfilter.Filter {
+	return m.ipFilter
+}
+
+// SetRateLimiter sets the limiter enforcing rate limits and monthly transfer quotas.
+func (m *Manager) SetRateLimiter(limiter *ratelimit.Limiter) {
+	m.rateLimiter = limiter
+}
+
+// RateLimiter returns the limiter enforcing rate limits and monthly transfer
+// quotas. When nil, traffic is not limited.
+func (m *Manager) RateLimiter() *ratelimit.Limiter {
+	return m.rateLimiter
+}
+
+// SetEdgeCache sets the cache for responses of HTTP tunnels.
+func (m *Manager) SetEdgeCache(cache *edgecache.Cache) {
+	m.edgeCache = cache
+}
+
+// EdgeCache returns the cache for responses of HTTP tunnels that opt in, nil
+// when caching is not enabled.
+func (m *Manager) EdgeCache() *edgecache.Cache {
+	return m.edgeCache
+}
+
+// countTransfer adds the traffic of a tunnel since the last call to the
+// monthly transfer of its user and organization.
+func (m *Manager) countTransfer(tunnel *Tunnel) {
+	if m.rateLimiter == nil {
+		return
+	}
+	m.rateLimiter.AddTransfer(tunnel.RateSubject(), tunnel.uncountedTransfer())
+}
+
+// usesPort reports whether tunnels of the protocol get a port from the port pool.
+func usesPort(protocol tunnelv1.TunnelProtocol) bool {
+	return protocol == tunnelv1.TunnelProtocol_TCP || protocol == tunnelv1.TunnelProtocol_UDP
+}
+
+// listenerFor returns the proxy serving the allocated ports of protocol, or nil.
+func (m *Manager) listenerFor(protocol tunnelv1.TunnelProtocol) portListener {
+	if protocol == tunnelv1.TunnelProtocol_UDP {
+		if m.udpProxy != nil {
+			return m.udpProxy
+		}
+		return nil
+	}
+	if m.tcpProxy != nil {
+		return m.tcpProxy
+	}
+	return nil
+}
+
 // All tunnels are persistent - they are marked offline but domain reservations are kept.
 func (m *Manager) CleanupStaleTunnels(ctx context.Context) error {
 	logger.InfoEvent().Msg("Cleaning up stale tunnels from previous sessions...")
@@ -240,11 +348,11 @@ func (m *Manager) RegisterTunnel(ctx context.Context, tunnel *Tunnel) error {
 		return err
 	}
 
-	// For TCP tunnels, allocate a port
+	// For TCP and UDP tunnels, allocate a port
 	var allocatedPort *int
-	if tunnel.Protocol == tunnelv1.TunnelProtocol_TCP {
+	if usesPort(tunnel.Protocol) {
 		if m.portPool == nil {
-			return pkgerrors.NewAppError("TCP_NOT_SUPPORTED", "TCP tunnels not supported (port pool not initialized)", nil)
+			return pkgerrors.NewAppError(tunnel.Protocol.String()+"_NOT_SUPPORTED", tunnel.Protocol.String()+" tunnels not supported (port pool not initialized)", nil)
 		}
 
 		port, err := m.portPool.AllocatePort(tunnel.ID)
@@ -260,17 +368,17 @@ func (m *Manager) RegisterTunnel(ctx context.Context, tunnel *Tunnel) error {
 		tunnel.RemotePort = &port
 
 		// Update public URL with allocated port
-		tunnel.PublicURL = m.BuildTCPPublicURL(port)
+		tunnel.PublicURL = m.buildPortPublicURL(tunnel.Protocol, port)
 
 		logger.InfoEvent().
 			Int("port", port).
 			Str("tunnel_id", tunnel.ID.String()).
 			Str("public_url", tunnel.PublicURL).
-			Msg("Allocated TCP port for tunnel")
+			Msg("Allocated port for tunnel")
 
-		// Start TCP listener if TCP proxy is available
-		if m.tcpProxy != nil {
-			if err := m.tcpProxy.StartListener(port, tunnel.ID); err != nil {
+		// Start the listener if the proxy for the protocol is available
+		if listener := m.listenerFor(tunnel.Protocol); listener != nil {
+			if err := listener.StartListener(port, tunnel.ID); err != nil {
 				// Release the port if listener fails to start
 				if releaseErr := m.portPool.ReleasePort(port, false); releaseErr != nil {
 					logger.WarnEvent().Err(releaseErr).Int("port", port).Msg("Failed to release port")
@@ -279,8 +387,9 @@ func (m *Manager) RegisterTunnel(ctx context.Context, tunnel *Tunnel) error {
 					Err(err).
 					Int("port", port).
 					Str("tunnel_id", tunnel.ID.String()).
-					Msg("Failed to start TCP listener")
-				return pkgerrors.Wrap(err, "failed to start TCP listener")
+					Str("protocol", tunnel.Protocol.String()).
+					Msg("Failed to start listener")
+				return pkgerrors.Wrap(err, "failed to start listener")
 			}
 		}
 	}
@@ -338,8 +447,21 @@ func (m *Manager) RegisterTunnel(ctx context.Context, tunnel *Tunnel) error {
 	return nil
 }
 
+// UnregisterTunnel takes the tunnel of a disconnected client offline.
 // All tunnels are now persistent - they are marked offline but not deleted.
+// Requests for a persistent HTTP tunnel are held while its client reconnects.
 func (m *Manager) UnregisterTunnel(ctx context.Context, tunnelID uuid.UUID) error {
+	return m.unregisterTunnel(ctx, tunnelID, true)
+}
+
+// CloseTunnel takes a tunnel offline that was closed on purpose, by its client
+// or an admin. Unlike UnregisterTunnel, no requests are held for a reconnect.
+func (m *Manager) CloseTunnel(ctx context.Context, tunnelID uuid.UUID) error {
+	return m.unregisterTunnel(ctx, tunnelID, false)
+}
+
+// unregisterTunnel takes a tunnel offline; hold starts its reconnect grace period.
+func (m *Manager) unregisterTunnel(ctx context.Context, tunnelID uuid.UUID, hold bool) error {
 	// Load tunnel
 	value, ok := m.tunnelsByID.Load(tunnelID)
 	if !ok {
@@ -352,10 +474,20 @@ func (m *Manager) UnregisterTunnel(ctx context.Context, tunnelID uuid.UUID) erro
 	}
 
 	// Close tunnel
+	m.countTransfer(tunnel)
 	tunnel.Close()
 
-	// Remove from memory
-	m.tunnels.Delete(tunnel.Subdomain)
+	// Remove from memory. A group keeps serving the subdomain with its remaining
+	// members; otherwise new requests for a persistent tunnel are held until it reconnects.
+	next, grouped := m.leaveGroup(tunnel)
+	if next != nil {
+		m.tunnels.CompareAndSwap(tunnel.Subdomain, tunnel, next)
+	} else {
+		if hold {
+			m.holdForReconnect(tunnel)
+		}
+		m.tunnels.CompareAndDelete(tunnel.Subdomain, tunnel)
+	}
 	m.tunnelsByID.Delete(tunnelID)
 
 	// Fetch tunnel from database
@@ -364,18 +496,18 @@ func (m *Manager) UnregisterTunnel(ctx context.Context, tunnelID uuid.UUID) erro
 		return pkgerrors.Wrap(err, "failed to fetch tunnel from database")
 	}
 
-	// Handle TCP port release/reservation
+	// Handle TCP/UDP port release/reservation
 	if dbTunnel.RemotePort != nil && m.portPool != nil {
 		isPersistent := dbTunnel.IsPersistent
 
-		// Stop TCP listener
-		if m.tcpProxy != nil {
-			if err := m.tcpProxy.StopListener(*dbTunnel.RemotePort); err != nil {
+		// Stop the TCP or UDP listener
+		if listener := m.listenerFor(tunnel.Protocol); listener != nil {
+			if err := listener.StopListener(*dbTunnel.RemotePort); err != nil {
 				logger.WarnEvent().
 					Err(err).
 					Int("port", *dbTunnel.RemotePort).
 					Str("tunnel_id", tunnelID.String()).
-					Msg("Failed to stop TCP listener")
+					Msg("Failed to stop listener")
 			}
 		}
 
@@ -402,6 +534,26 @@ func (m *Manager) UnregisterTunnel(ctx context.Context, tunnelID uuid.UUID) erro
 		}
 	}
 
+	// Extra group members have no saved name of their own; nothing to keep for them
+	if grouped && tunnel.SavedName == nil {
+		if err := m.db.WithContext(ctx).Delete(&models.Tunnel{}, "id = ?", tunnelID).Error; err != nil {
+			return pkgerrors.Wrap(err, "failed to delete group member tunnel")
+		}
+
+		logger.InfoEvent().
+			Str("tunnel_id", tunnelID.String()).
+			Str("subdomain", tunnel.Subdomain).
+			Msg("Group member tunnel removed")
+
+		dbTunnel.Status = "offline"
+		m.emitEvent(Event{
+			Type:     EventTunnelDisconnected,
+			TunnelID: tunnelID,
+			Tunnel:   &dbTunnel,
+		})
+		return nil
+	}
+
 	// Mark tunnel as offline, KEEP domain reservation
 	now := time.Now()
 	err := m.db.WithContext(ctx).
@@ -450,6 +602,42 @@ func (m *Manager) GetTunnelBySubdomain(subdomain string) (*Tunnel, bool) {
 	return tunnel, true
 }
 
+// SubdomainInUse reports whether subdomain has a live tunnel or group, or
+// is reserved, e.g. by a persistent tunnel that is offline.
+func (m *Manager) SubdomainInUse(ctx context.Context, subdomain string) bool {
+	if _, ok := m.tunnels.Load(subdomain); ok {
+		return true
+	}
+	if _, ok := m.GetGroup(subdomain); ok {
+		return true
+	}
+
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&models.Domain{}).Where("subdomain = ?", subdomain).Count(&count).Error; err != nil {
+		logger.WarnEvent().Err(err).Str("subdomain", subdomain).Msg("Failed to check subdomain reservation")
+		return false
+	}
+	return count > 0
+}
+
+// WebhookAppInOrganization reports whether the webhook app belongs to the
+// organization. Users without an organization own no webhook apps.
+func (m *Manager) WebhookAppInOrganization(ctx context.Context, appID uuid.UUID, orgID *uuid.UUID) (bool, error) {
+	if orgID == nil {
+		return false, nil
+	}
+
+	var count int64
+	err := m.db.WithContext(ctx).
+		Model(&models.WebhookApp{}).
+		Where("id = ? AND organization_id = ?", appID, *orgID).
+		Count(&count).Error
+	if err != nil {
+		return false, pkgerrors.Wrap(err, "failed to check webhook app")
+	}
+	return count > 0, nil
+}
+
 // GetTunnelByID retrieves a tunnel by ID.
 func (m *Manager) GetTunnelByID(tunnelID uuid.UUID) (*Tunnel, bool) {
 	value, ok := m.tunnelsByID.Load(tunnelID)
@@ -472,6 +660,7 @@ func (m *Manager) SaveTunnelStats(ctx context.Context, tunnelID uuid.UUID) error
 
 	// Get current stats
 	bytesIn, bytesOut, requestsCount := tunnel.GetStats()
+	m.countTransfer(tunnel)
 
 	// Update database
 	err := m.db.WithContext(ctx).
@@ -545,10 +734,19 @@ func (m *Manager) checkUserTunnelLimit(ctx context.Context, userID uuid.UUID) er
 
 // BuildPublicURL builds the public URL for a tunnel.
 func (m *Manager) BuildPublicURL(subdomain string, protocol string) string {
-	if protocol == ProtocolTCP {
-		// TCP tunnels use port-based routing, not subdomain
+	if protocol == ProtocolTCP || protocol == ProtocolUDP {
+		// TCP and UDP tunnels use port-based routing, not subdomain
 		// Return placeholder - actual URL set after port allocation
-		return ProtocolTCP + "://pending-allocation"
+		return protocol + "://pending-allocation"
+	}
+
+	if protocol == ProtocolTLS {
+		// TLS tunnels share the HTTPS port, whatever the TLS configuration of HTTP tunnels
+		host := fmt.Sprintf("%s.%s", subdomain, m.baseDomain)
+		if m.httpsPort != 443 && m.httpsPort != 0 {
+			return fmt.Sprintf("%s://%s:%d", ProtocolTLS, host, m.httpsPort)
+		}
+		return fmt.Sprintf("%s://%s", ProtocolTLS, host)
 	}
 
 	// Determine scheme and port based on TLS configuration
@@ -580,6 +778,19 @@ func (m *Manager) BuildTCPPublicURL(port int) string {
 	return fmt.Sprintf("%s://%s:%d", ProtocolTCP, m.baseDomain, port)
 }
 
+// BuildUDPPublicURL builds the public URL for a UDP tunnel with allocated port.
+func (m *Manager) BuildUDPPublicURL(port int) string {
+	return fmt.Sprintf("%s://%s:%d", ProtocolUDP, m.baseDomain, port)
+}
+
+// buildPortPublicURL builds the public URL for a TCP or UDP tunnel with allocated port.
+func (m *Manager) buildPortPublicURL(protocol tunnelv1.TunnelProtocol, port int) string {
+	if protocol == tunnelv1.TunnelProtocol_UDP {
+		return m.BuildUDPPublicURL(port)
+	}
+	return m.BuildTCPPublicURL(port)
+}
+
 // IsTLSEnabled returns whether TLS is enabled on the server.
 func (m *Manager) IsTLSEnabled() bool {
 	return m.tlsEnabled
@@ -653,23 +864,29 @@ func (m *Manager) FindOfflineTunnelBySavedName(ctx context.Context, userID uuid.
 	return &tunnel, nil
 }
 
-// ReactivateTunnel reactivates an offline persistent tunnel.
-func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tunnel, stream grpc.ServerStream, newLocalAddr string) (*Tunnel, error) {
+// ReactivateTunnel reactivates an offline persistent tunnel. Its edge
+// policy is in place before it can be routed to.
+func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tunnel, stream grpc.ServerStream, newLocalAddr string, edge EdgePolicy) (*Tunnel, error) {
 	// Determine protocol from tunnel type
 	protocol := "http"
 	if offlineTunnel.TunnelType == "HTTPS" {
 		protocol = "https"
 	} else if offlineTunnel.TunnelType == "TCP" {
 		protocol = ProtocolTCP
+	} else if offlineTunnel.TunnelType == "TLS" {
+		protocol = ProtocolTLS
+	} else if offlineTunnel.TunnelType == "UDP" {
+		protocol = ProtocolUDP
 	}
+	tunnelProtocol := tunnelv1.TunnelProtocol(tunnelv1.TunnelProtocol_value[offlineTunnel.TunnelType])
 
-	// Handle TCP port reallocation for persistent tunnels
+	// Handle TCP/UDP port reallocation for persistent tunnels
 	var publicURL string
 	var remotePort *int
-	if protocol == ProtocolTCP && offlineTunnel.RemotePort != nil {
-		// Reallocate the same port for persistent TCP tunnel
+	if usesPort(tunnelProtocol) && offlineTunnel.RemotePort != nil {
+		// Reallocate the same port for persistent TCP/UDP tunnel
 		if m.portPool == nil {
-			return nil, pkgerrors.NewAppError("TCP_NOT_SUPPORTED", "TCP tunnels not supported (port pool not initialized)", nil)
+			return nil, pkgerrors.NewAppError(offlineTunnel.TunnelType+"_NOT_SUPPORTED", offlineTunnel.TunnelType+" tunnels not supported (port pool not initialized)", nil)
 		}
 
 		port, err := m.portPool.ReallocatePortForTunnel(offlineTunnel.ID, *offlineTunnel.RemotePort)
@@ -678,22 +895,22 @@ func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tu
 				Err(err).
 				Int("previous_port", *offlineTunnel.RemotePort).
 				Str("tunnel_id", offlineTunnel.ID.String()).
-				Msg("Failed to reallocate TCP port")
-			return nil, pkgerrors.Wrap(err, "failed to reallocate TCP port")
+				Msg("Failed to reallocate port")
+			return nil, pkgerrors.Wrap(err, "failed to reallocate port")
 		}
 
 		remotePort = &port
-		publicURL = m.BuildTCPPublicURL(port)
+		publicURL = m.buildPortPublicURL(tunnelProtocol, port)
 
 		logger.InfoEvent().
 			Int("port", port).
 			Str("tunnel_id", offlineTunnel.ID.String()).
 			Str("public_url", publicURL).
-			Msg("Reallocated TCP port for persistent tunnel")
+			Msg("Reallocated port for persistent tunnel")
 
-		// Start TCP listener if TCP proxy is available
-		if m.tcpProxy != nil {
-			if err := m.tcpProxy.StartListener(port, offlineTunnel.ID); err != nil {
+		// Start the listener if the proxy for the protocol is available
+		if listener := m.listenerFor(tunnelProtocol); listener != nil {
+			if err := listener.StartListener(port, offlineTunnel.ID); err != nil {
 				// Release the port if listener fails to start
 				if releaseErr := m.portPool.ReleasePort(port, false); releaseErr != nil {
 					logger.WarnEvent().Err(releaseErr).Int("port", port).Msg("Failed to release port")
@@ -702,12 +919,12 @@ func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tu
 					Err(err).
 					Int("port", port).
 					Str("tunnel_id", offlineTunnel.ID.String()).
-					Msg("Failed to start TCP listener for reactivated tunnel")
-				return nil, pkgerrors.Wrap(err, "failed to start TCP listener")
+					Msg("Failed to start listener for reactivated tunnel")
+				return nil, pkgerrors.Wrap(err, "failed to start listener")
 			}
 		}
 	} else {
-		// Regenerate public URL with current TLS and port configuration for HTTP/HTTPS
+		// Regenerate public URL with current TLS and port configuration for HTTP/HTTPS/TLS
 		publicURL = m.BuildPublicURL(offlineTunnel.Subdomain, protocol)
 	}
 
@@ -735,11 +952,12 @@ func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tu
 		TokenID:        offlineTunnel.TokenID,
 		OrganizationID: offlineTunnel.OrganizationID,
 		Subdomain:      offlineTunnel.Subdomain,
-		Protocol:       tunnelv1.TunnelProtocol(tunnelv1.TunnelProtocol_value[offlineTunnel.TunnelType]),
+		Protocol:       tunnelProtocol,
 		RemotePort:     remotePort,   // Store remote port for TCP tunnels
 		LocalAddr:      newLocalAddr, // Use new local address
 		PublicURL:      publicURL,    // Use regenerated URL
 		SavedName:      offlineTunnel.SavedName,
+		EdgePolicy:     edge,
 		Stream:         stream,
 		RequestQueue:   make(chan *PendingRequest, 100),
 		ResponseMap:    sync.Map{},
@@ -747,10 +965,20 @@ func (m *Manager) ReactivateTunnel(ctx context.Context, offlineTunnel *models.Tu
 		ConnectedAt:    time.Now(),
 		LastActivity:   time.Now(),
 		// Preserve cumulative stats from database
-		BytesIn:       offlineTunnel.BytesIn,
-		BytesOut:      offlineTunnel.BytesOut,
-		RequestsCount: offlineTunnel.RequestsCount,
+		BytesIn:         offlineTunnel.BytesIn,
+		BytesOut:        offlineTunnel.BytesOut,
+		RequestsCount:   offlineTunnel.RequestsCount,
+		transferCounted: offlineTunnel.BytesIn + offlineTunnel.BytesOut,
+	}
+
+	adminHeaders, err := headerrules.TunnelRulesFromModel(offlineTunnel)
+	if err != nil {
+		logger.WarnEvent().
+			Err(err).
+			Str("tunnel_id", offlineTunnel.ID.String()).
+			Msg("Ignoring invalid stored header rules")
 	}
+	tunnel.adminHeaders = adminHeaders
 
 	// Store in memory maps
 	m.tunnels.Store(tunnel.Subdomain, tunnel)
@@ -792,6 +1020,7 @@ func (m *Manager) startPeriodicStatsUpdater() {
 
 			// Get current stats from in-memory tunnel
 			bytesIn, bytesOut, requestsCount := tunnel.GetStats()
+			m.countTransfer(tunnel)
 
 			// Load tunnel from database to get full data
 			var dbTunnel models.Tunnel
@@ -831,5 +1060,10 @@ func (m *Manager) startPeriodicStatsUpdater() {
 
 			return true // Continue to next tunnel
 		})
+
+		// Persist the monthly transfer counted above
+		if err := m.rateLimiter.Flush(context.Background()); err != nil {
+			logger.WarnEvent().Err(err).Msg("Failed to save transfer usage")
+		}
 	}
 }
@@ -12,6 +12,7 @@ import (
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
 )
 
 func setupTestDB(t *testing.T) *gorm.DB {
@@ -198,6 +199,8 @@ func TestBuildPublicURL(t *testing.T) {
 		{"HTTPS custom port", true, 80, 8443, "myapp", "https", "https://myapp.example.com:8443"},
 		{"HTTP default port", false, 80, 443, "myapp", "http", "http://myapp.example.com"},
 		{"HTTP custom port", false, 8080, 443, "myapp", "http", "http://myapp.example.com:8080"},
+		{"TLS default port", true, 80, 443, "myapp", "tls", "tls://myapp.example.com"},
+		{"TLS custom port", true, 80, 8443, "myapp", "tls", "tls://myapp.example.com:8443"},
 	}
 
 	for _, tt := range tests {
@@ -255,3 +258,30 @@ func TestGetPortStats(t *testing.T) {
 		assert.Nil(t, noTCPManager.GetPortStats())
 	})
 }
+
+func TestWebhookAppInOrganization(t *testing.T) {
+	database := setupTestDB(t)
+	manager := NewManager(database, "example.com", 10, true, 80, 443, 10000, 20000)
+	ctx := context.Background()
+
+	orgID := uuid.New()
+	otherOrgID := uuid.New()
+	app := &models.WebhookApp{OrganizationID: orgID, UserID: uuid.New(), Name: "payment-app"}
+	require.NoError(t, database.Create(app).Error)
+
+	owned, err := manager.WebhookAppInOrganization(ctx, app.ID, &orgID)
+	require.NoError(t, err)
+	assert.True(t, owned)
+
+	owned, err = manager.WebhookAppInOrganization(ctx, app.ID, &otherOrgID)
+	require.NoError(t, err)
+	assert.False(t, owned, "apps of other organizations are not owned")
+
+	owned, err = manager.WebhookAppInOrganization(ctx, uuid.New(), &orgID)
+	require.NoError(t, err)
+	assert.False(t, owned, "unknown apps are not owned")
+
+	owned, err = manager.WebhookAppInOrganization(ctx, app.ID, nil)
+	require.NoError(t, err)
+	assert.False(t, owned, "users without an organization own no apps")
+}
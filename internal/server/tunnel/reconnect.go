@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// reconnectWait holds the requests for a persistent tunnel whose client is
+// expected to reconnect. done is closed once the client is back (tunnel set)
+// or the tunnel will not come back in time (err set).
+type reconnectWait struct {
+	done   chan struct{}
+	tunnel *Tunnel
+	err    error
+	held   atomic.Int32
+}
+
+// SetReconnectGrace configures how long requests for a disconnected persistent
+// tunnel are held, and how many of them, before visitors get an error.
+func (m *Manager) SetReconnectGrace(grace time.Duration, maxHeld int) {
+	m.reconnectGrace = grace
+	m.maxHeldRequests = maxHeld
+}
+
+// ReconnectGracePeriod returns how long requests are held for a reconnecting tunnel.
+func (m *Manager) ReconnectGracePeriod() time.Duration {
+	return m.reconnectGrace
+}
+
+// holdForReconnect starts the grace period of a disconnected tunnel.
+// Only persistent HTTP(S) tunnels are held; a client reconnecting under the same
+// saved name gets the same subdomain back.
+func (m *Manager) holdForReconnect(tunnel *Tunnel) {
+	if m.reconnectGrace <= 0 || tunnel.SavedName == nil || !tunnel.ServesHTTP() {
+		return
+	}
+
+	wait := &reconnectWait{done: make(chan struct{})}
+	if previous, loaded := m.reconnecting.Swap(tunnel.Subdomain, wait); loaded {
+		previous.(*reconnectWait).finish(nil, pkgerrors.ErrTunnelReconnecting)
+	}
+
+	// A wait resumed or canceled earlier is no longer in the map; the timer is then a no-op
+	time.AfterFunc(m.reconnectGrace, func() {
+		if m.reconnecting.CompareAndDelete(tunnel.Subdomain, wait) {
+			logger.InfoEvent().
+				Str("subdomain", tunnel.Subdomain).
+				Int32("held_requests", wait.held.Load()).
+				Msg("Reconnect grace period expired")
+			wait.finish(nil, pkgerrors.ErrTunnelReconnecting)
+		}
+	})
+
+	logger.InfoEvent().
+		Str("subdomain", tunnel.Subdomain).
+		Dur("grace_period", m.reconnectGrace).
+		Msg("Holding requests while tunnel reconnects")
+}
+
+// ResumeTunnel releases the requests held for a reconnected tunnel. It is called
+// once the tunnel is fully set up, so held requests see its negotiated features.
+func (m *Manager) ResumeTunnel(tunnel *Tunnel) {
+	value, ok := m.reconnecting.LoadAndDelete(tunnel.Subdomain)
+	if !ok {
+		return
+	}
+
+	wait := value.(*reconnectWait)
+	wait.finish(tunnel, nil)
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnel.ID.String()).
+		Str("subdomain", tunnel.Subdomain).
+		Int32("held_requests", wait.held.Load()).
+		Msg("Releasing requests held during reconnect")
+}
+
+// CancelReconnect ends the grace period of a tunnel that will not come back,
+// e.g. because it was deleted. Held requests fail with ErrTunnelNotFound.
+func (m *Manager) CancelReconnect(subdomain string) {
+	if value, ok := m.reconnecting.LoadAndDelete(subdomain); ok {
+		value.(*reconnectWait).finish(nil, pkgerrors.ErrTunnelNotFound)
+	}
+}
+
+// WaitForTunnel waits for a disconnected persistent tunnel to reconnect.
+// It returns ErrTunnelNotFound when no reconnect is pending for subdomain and
+// ErrTunnelReconnecting when the grace period ran out or too many requests are held.
+func (m *Manager) WaitForTunnel(ctx context.Context, subdomain string) (*Tunnel, error) {
+	value, ok := m.reconnecting.Load(subdomain)
+	if !ok {
+		return nil, pkgerrors.ErrTunnelNotFound
+	}
+	wait := value.(*reconnectWait)
+
+	if m.maxHeldRequests > 0 && int(wait.held.Add(1)) > m.maxHeldRequests {
+		wait.held.Add(-1)
+		return nil, pkgerrors.ErrTunnelReconnecting
+	}
+
+	select {
+	case <-wait.done:
+	case <-ctx.Done():
+		wait.held.Add(-1)
+		return nil, ctx.Err()
+	}
+
+	return wait.tunnel, wait.err
+}
+
+// finish wakes up the held requests. It is called exactly once, by whoever
+// removed the wait from the reconnecting map.
+func (w *reconnectWait) finish(tunnel *Tunnel, err error) {
+	w.tunnel = tunnel
+	w.err = err
+	close(w.done)
+}
@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+)
+
+// registerPersistentTunnel registers an HTTP tunnel under a saved name.
+func registerPersistentTunnel(t *testing.T, manager *Manager, savedName string) *Tunnel {
+	ctx := context.Background()
+	userID := uuid.New()
+
+	subdomain, _, err := manager.AllocateSubdomain(ctx, userID, nil, savedName)
+	require.NoError(t, err)
+
+	tunnel := NewTunnel(userID, uuid.New(), nil, subdomain, tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "https://"+subdomain+".localhost", nil)
+	tunnel.SavedName = &savedName
+	require.NoError(t, manager.RegisterTunnel(ctx, tunnel))
+
+	return tunnel
+}
+
+// TestWaitForTunnel_Resumed tests that held requests get the reconnected tunnel.
+func TestWaitForTunnel_Resumed(t *testing.T) {
+	manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	manager.SetReconnectGrace(5*time.Second, 10)
+	ctx := context.Background()
+
+	tunnel := registerPersistentTunnel(t, manager, "myapp")
+	require.NoError(t, manager.UnregisterTunnel(ctx, tunnel.ID))
+
+	type result struct {
+		tunnel *Tunnel
+		err    error
+	}
+	held := make(chan result, 1)
+	go func() {
+		tun, err := manager.WaitForTunnel(ctx, "myapp")
+		held <- result{tun, err}
+	}()
+
+	select {
+	case <-held:
+		t.Fatal("request released before the tunnel reconnected")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	offline, err := manager.FindOfflineTunnelBySavedName(ctx, tunnel.UserID, "myapp")
+	require.NoError(t, err)
+	reactivated, err := manager.ReactivateTunnel(ctx, offline, nil, "localhost:4000", EdgePolicy{})
+	require.NoError(t, err)
+	manager.ResumeTunnel(reactivated)
+
+	select {
+	case res := <-held:
+		require.NoError(t, res.err)
+		assert.Same(t, reactivated, res.tunnel)
+	case <-time.After(time.Second):
+		t.Fatal("held request not released")
+	}
+
+	// The grace period is over; later misses are not held
+	_, err = manager.WaitForTunnel(ctx, "myapp")
+	assert.True(t, errors.Is(err, pkgerrors.ErrTunnelNotFound))
+}
+
+// TestWaitForTunnel_GraceExpired tests that held requests fail once the grace period runs out.
+func TestWaitForTunnel_GraceExpired(t *testing.T) {
+	manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+	manager.SetReconnectGrace(50*time.Millisecond, 10)
+	ctx := context.Background()
+
+	tunnel := registerPersistentTunnel(t, manager, "myapp")
+	require.NoError(t, manager.UnregisterTunnel(ctx, tunnel.ID))
+
+	_, err := manager.WaitForTunnel(ctx, "myapp")
+	assert.True(t, errors.Is(err, pkgerrors.ErrTunnelReconnecting))
+
+	_, err = manager.WaitForTunnel(ctx, "myapp")
+	assert.True(t, errors.Is(err, pkgerrors.ErrTunnelNotFound))
+}
+
+// TestWaitForTunnel_Limits tests the cases in which requests are not held.
+func TestWaitForTunnel_Limits(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("too many held requests", func(t *testing.T) {
+		manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+		manager.SetReconnectGrace(5*time.Second, 1)
+
+		tunnel := registerPersistentTunnel(t, manager, "myapp")
+		require.NoError(t, manager.UnregisterTunnel(ctx, tunnel.ID))
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := manager.WaitForTunnel(waitCtx, "myapp")
+			errCh <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		_, err := manager.WaitForTunnel(ctx, "myapp")
+		assert.True(t, errors.Is(err, pkgerrors.ErrTunnelReconnecting))
+
+		// The visitor leaving frees its slot
+		cancel()
+		assert.True(t, errors.Is(<-errCh, context.Canceled))
+		manager.CancelReconnect("myapp")
+	})
+
+	t.Run("deleted tunnel", func(t *testing.T) {
+		manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+		manager.SetReconnectGrace(5*time.Second, 10)
+
+		tunnel := registerPersistentTunnel(t, manager, "myapp")
+		require.NoError(t, manager.UnregisterTunnel(ctx, tunnel.ID))
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := manager.WaitForTunnel(ctx, "myapp")
+			errCh <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		manager.CancelReconnect("myapp")
+		assert.True(t, errors.Is(<-errCh, pkgerrors.ErrTunnelNotFound))
+	})
+
+	t.Run("closed on purpose", func(t *testing.T) {
+		manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+		manager.SetReconnectGrace(5*time.Second, 10)
+
+		tunnel := registerPersistentTunnel(t, manager, "myapp")
+		require.NoError(t, manager.CloseTunnel(ctx, tunnel.ID))
+
+		_, err := manager.WaitForTunnel(ctx, "myapp")
+		assert.True(t, errors.Is(err, pkgerrors.ErrTunnelNotFound))
+	})
+
+	t.Run("grace period disabled", func(t *testing.T) {
+		manager := NewManager(setupTestDB(t), "localhost", 10, true, 80, 443, 10000, 20000)
+
+		tunnel := registerPersistentTunnel(t, manager, "myapp")
+		require.NoError(t, manager.UnregisterTunnel(ctx, tunnel.ID))
+
+		_, err := manager.WaitForTunnel(ctx, "myapp")
+		assert.True(t, errors.Is(err, pkgerrors.ErrTunnelNotFound))
+	})
+}
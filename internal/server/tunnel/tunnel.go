@@ -1,15 +1,27 @@
 package tunnel
 
 import (
+	"context"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
 
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
+	"github.com/pandeptwidyaop/grok/internal/server/ratelimit"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
 )
 
+// rateNoticeInterval is the minimum time between rate limit notices to a client.
+const rateNoticeInterval = 10 * time.Second
+
 // Tunnel represents an active tunnel connection.
 type Tunnel struct {
 	ID             uuid.UUID
@@ -18,12 +30,14 @@ type Tunnel struct {
 	OrganizationID *uuid.UUID // Organization ID (nullable)
 	Subdomain      string
 	Protocol       tunnelv1.TunnelProtocol
-	RemotePort     *int // Allocated port for TCP tunnels
+	RemotePort     *int // Allocated port for TCP and UDP tunnels
 	LocalAddr      string
 	PublicURL      string
 	Stream         grpc.ServerStream
 	RequestQueue   chan *PendingRequest
 	ResponseMap    sync.Map // request_id → response channel
+	SendWindows    sync.Map // connection ID → *protocol.Window (TCP/WebSocket flow control)
+	Aborts         sync.Map // request_id → context.CancelFunc ending the request (see Abort)
 	Status         string
 	ConnectedAt    time.Time
 	LastActivity   time.Time
@@ -35,15 +49,41 @@ type Tunnel struct {
 	// Persistent tunnel fields
 	SavedName *string // Optional saved name for persistent tunnels
 
+	// Client-declared metadata
+	Labels   map[string]string
+	Features protocol.Features // Protocol features negotiated with the client
+	EdgePolicy
+
 	// Statistics (in-memory counters)
 	BytesIn       int64
 	BytesOut      int64
 	RequestsCount int64
 
+	inflight atomic.Int64 // Requests currently being proxied (least in-flight balancing)
+
+	transferCounted int64              // BytesIn+BytesOut already added to monthly transfer
+	rateNoticeAt    time.Time          // When the client was last told about a rate limit
+	adminHeaders    *headerrules.Rules // Header rules set by admins (nil: none)
+
 	mu       sync.RWMutex // Protects tunnel state (status, activity, stats)
 	StreamMu sync.Mutex   // Protects gRPC stream Send operations (for WebSocket data streaming)
 }
 
+// EdgePolicy holds the client-declared rules the server applies to visitors
+// of a tunnel before their traffic reaches the client.
+type EdgePolicy struct {
+	Access  *access.Policy     // Who may reach an HTTP tunnel (nil: public)
+	IPRules *ipfilter.Rules    // Addresses that may connect to an HTTP or TCP tunnel (nil: any)
+	Headers *headerrules.Rules // Headers changed on requests and responses of an HTTP tunnel (nil: none)
+	Edge    EdgeOptions        // Compression and caching of the responses of an HTTP tunnel
+}
+
+// EdgeOptions are the edge features an HTTP tunnel opts into.
+type EdgeOptions struct {
+	Compress bool // Compress responses for visitors that accept gzip or brotli
+	Cache    bool // Serve GET responses from the edge cache when Cache-Control allows
+}
+
 // PendingRequest represents a request waiting for response.
 type PendingRequest struct {
 	RequestID  string
@@ -118,6 +158,117 @@ func (t *Tunnel) GetStats() (bytesIn, bytesOut, requestsCount int64) {
 	return t.BytesIn, t.BytesOut, t.RequestsCount
 }
 
+// RateSubject returns what the traffic of the tunnel is limited as.
+func (t *Tunnel) RateSubject() ratelimit.Subject {
+	return ratelimit.Subject{Tunnel: t.ID, User: t.UserID, Organization: t.OrganizationID}
+}
+
+// HeaderRules returns the header rules of the tunnel in the order they apply:
+// the client's, then the admins'.
+func (t *Tunnel) HeaderRules() []*headerrules.Rules {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var rules []*headerrules.Rules
+	for _, r := range []*headerrules.Rules{t.Headers, t.adminHeaders} {
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// SetAdminHeaderRules replaces the header rules set by admins; nil removes them.
+func (t *Tunnel) SetAdminHeaderRules(rules *headerrules.Rules) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.adminHeaders = rules
+}
+
+// uncountedTransfer returns the bytes in and out since the last call.
+func (t *Tunnel) uncountedTransfer() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.BytesIn + t.BytesOut - t.transferCounted
+	t.transferCounted = t.BytesIn + t.BytesOut
+	return n
+}
+
+// NotifyRateLimited tells the client that a limit rejected or slowed down
+// its traffic, at most once per rateNoticeInterval.
+func (t *Tunnel) NotifyRateLimited(exceeded *ratelimit.Exceeded) {
+	t.mu.Lock()
+	if t.Stream == nil || time.Since(t.rateNoticeAt) < rateNoticeInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.rateNoticeAt = time.Now()
+	t.mu.Unlock()
+
+	msg := &tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Control{
+			Control: &tunnelv1.ControlMessage{
+				Type:     tunnelv1.ControlMessage_RATE_LIMIT,
+				TunnelId: t.ID.String(),
+				Metadata: map[string]string{
+					"scope":       string(exceeded.Scope),
+					"limit":       string(exceeded.Kind),
+					"retry_after": strconv.Itoa(exceeded.RetryAfterSeconds()),
+					"message":     exceeded.Error(),
+				},
+			},
+		},
+	}
+
+	t.StreamMu.Lock()
+	err := t.Stream.SendMsg(msg)
+	t.StreamMu.Unlock()
+	if err != nil {
+		logger.DebugEvent().
+			Err(err).
+			Str("tunnel_id", t.ID.String()).
+			Msg("Failed to send rate limit notice")
+	}
+}
+
+// BeginRequest counts a request in flight until the returned func is called.
+func (t *Tunnel) BeginRequest() (end func()) {
+	t.inflight.Add(1)
+	return func() { t.inflight.Add(-1) }
+}
+
+// Inflight returns the number of requests currently being proxied.
+func (t *Tunnel) Inflight() int64 {
+	return t.inflight.Load()
+}
+
+// Abort gives up on a request or connection whose frames cannot be delivered:
+// its response channel is unregistered, so later frames are discarded, and the
+// cancel func stored in Aborts ends it, so the body is never silently cut short.
+func (t *Tunnel) Abort(requestID string) {
+	t.ResponseMap.Delete(requestID)
+	if v, ok := t.Aborts.LoadAndDelete(requestID); ok {
+		if cancel, ok := v.(context.CancelFunc); ok {
+			cancel()
+		}
+	}
+}
+
+// ServesHTTP reports whether the tunnel carries HTTP requests.
+func (t *Tunnel) ServesHTTP() bool {
+	return ServesHTTP(t.Protocol)
+}
+
+// ServesHTTP reports whether tunnels of the protocol carry HTTP requests.
+// TCP and TLS tunnels relay raw connections and UDP tunnels datagrams instead.
+func ServesHTTP(protocol tunnelv1.TunnelProtocol) bool {
+	switch protocol {
+	case tunnelv1.TunnelProtocol_TCP, tunnelv1.TunnelProtocol_TLS, tunnelv1.TunnelProtocol_UDP:
+		return false
+	default:
+		return true
+	}
+}
+
 // Close closes the tunnel and cleans up resources.
 func (t *Tunnel) Close() {
 	t.mu.Lock()
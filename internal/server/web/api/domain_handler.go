@@ -0,0 +1,308 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/domains"
+	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
+	pkgerrors "github.com/pandeptwidyaop/grok/pkg/errors"
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+	"gorm.io/gorm"
+)
+
+// DomainHandler handles custom domain API requests
+type DomainHandler struct {
+	db                 *gorm.DB
+	domains            *domains.Service
+	baseDomain         string
+	delegateChallenges bool // The built-in DNS server answers delegated challenge records
+}
+
+// NewDomainHandler creates a new custom domain handler. With
+// delegateChallenges, owners may CNAME the challenge record to grok's DNS
+// server instead of adding the token.
+func NewDomainHandler(db *gorm.DB, domainService *domains.Service, baseDomain string, delegateChallenges bool) *DomainHandler {
+	return &DomainHandler{
+		db:                 db,
+		domains:            domainService,
+		baseDomain:         baseDomain,
+		delegateChallenges: delegateChallenges,
+	}
+}
+
+// CreateDomainRequest registers a custom domain
+type CreateDomainRequest struct {
+	Hostname           string `json:"hostname"`
+	Subdomain          string `json:"subdomain"`                     // Tunnel subdomain to route to
+	VerificationMethod string `json:"verification_method,omitempty"` // dns (default) or http
+	Organization       bool   `json:"organization,omitempty"`        // Register for the user's organization (org admins)
+}
+
+// UpdateDomainRequest changes the target or verification method of a custom domain
+type UpdateDomainRequest struct {
+	Subdomain          *string `json:"subdomain,omitempty"`
+	VerificationMethod *string `json:"verification_method,omitempty"`
+}
+
+// DomainVerification tells the owner how to prove ownership of a domain
+type DomainVerification struct {
+	Method      string `json:"method"`
+	RecordName  string `json:"record_name,omitempty"`  // dns: TXT record to create
+	RecordValue string `json:"record_value,omitempty"` // dns: its value
+	DelegateTo  string `json:"delegate_to,omitempty"`  // dns: or a CNAME of the record to this name, served by grok
+	URL         string `json:"url,omitempty"`          // http: URL the server fetches the token from
+}
+
+// DomainResponse is a custom domain with its setup instructions
+type DomainResponse struct {
+	models.CustomDomain
+	Verified     bool               `json:"verified"`
+	CNAMETarget  string             `json:"cname_target"` // Point the hostname here
+	Verification DomainVerification `json:"verification"`
+}
+
+// CreateDomain registers a pending custom domain for the user or their organization
+func (dh *DomainHandler) CreateDomain(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req CreateDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+
+	domain := models.CustomDomain{
+		UserID:             userID,
+		Hostname:           req.Hostname,
+		Subdomain:          req.Subdomain,
+		VerificationMethod: req.VerificationMethod,
+	}
+
+	if req.Organization {
+		if claims.OrganizationID == nil || claims.Role != string(models.RoleOrgAdmin) {
+			respondError(w, http.StatusForbidden, "only organization admins can register organization domains")
+			return
+		}
+		orgID, err := uuid.Parse(*claims.OrganizationID)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid organization ID")
+			return
+		}
+		domain.OrganizationID = &orgID
+	}
+
+	if err := dh.domains.Register(r.Context(), &domain); err != nil {
+		dh.respondDomainError(w, err, "failed to register domain")
+		return
+	}
+
+	logger.InfoEvent().
+		Str("domain_id", domain.ID.String()).
+		Str("hostname", domain.Hostname).
+		Str("subdomain", domain.Subdomain).
+		Str("user_id", claims.UserID).
+		Msg("Custom domain registered")
+
+	respondJSON(w, http.StatusCreated, dh.toResponse(domain))
+}
+
+// ListDomains lists the custom domains of the user and their organization (all for super_admin)
+func (dh *DomainHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	query := dh.db.Model(&models.CustomDomain{})
+	if claims.Role != string(models.RoleSuperAdmin) {
+		if claims.OrganizationID != nil {
+			query = query.Where("user_id = ? OR organization_id = ?", claims.UserID, *claims.OrganizationID)
+		} else {
+			query = query.Where("user_id = ?", claims.UserID)
+		}
+	}
+
+	var list []models.CustomDomain
+	if err := query.Order("created_at DESC").Find(&list).Error; err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to list custom domains")
+		respondError(w, http.StatusInternalServerError, "failed to list domains")
+		return
+	}
+
+	response := make([]DomainResponse, len(list))
+	for i, domain := range list {
+		response[i] = dh.toResponse(domain)
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// GetDomain retrieves a single custom domain by ID
+func (dh *DomainHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
+	domain, ok := dh.loadDomain(w, r, false)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, dh.toResponse(*domain))
+}
+
+// UpdateDomain changes the target subdomain or verification method of a custom domain
+func (dh *DomainHandler) UpdateDomain(w http.ResponseWriter, r *http.Request) {
+	domain, ok := dh.loadDomain(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req UpdateDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Subdomain != nil {
+		domain.Subdomain = *req.Subdomain
+	}
+	if req.VerificationMethod != nil {
+		domain.VerificationMethod = *req.VerificationMethod
+	}
+
+	if err := dh.domains.Update(r.Context(), domain); err != nil {
+		dh.respondDomainError(w, err, "failed to update domain")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, dh.toResponse(*domain))
+}
+
+// VerifyDomain checks ownership of a custom domain. Once verified, the
+// hostname routes to its tunnel and may get a certificate.
+func (dh *DomainHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	domain, ok := dh.loadDomain(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := dh.domains.Verify(r.Context(), domain); err != nil {
+		dh.respondDomainError(w, err, "failed to verify domain")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, dh.toResponse(*domain))
+}
+
+// DeleteDomain removes a custom domain
+func (dh *DomainHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	domain, ok := dh.loadDomain(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := dh.domains.Delete(r.Context(), domain); err != nil {
+		logger.ErrorEvent().Err(err).Str("domain_id", domain.ID.String()).Msg("Failed to delete custom domain")
+		respondError(w, http.StatusInternalServerError, "failed to delete domain")
+		return
+	}
+
+	logger.InfoEvent().
+		Str("domain_id", domain.ID.String()).
+		Str("hostname", domain.Hostname).
+		Msg("Custom domain deleted")
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "domain deleted successfully"})
+}
+
+// loadDomain loads the domain in the path and checks that the caller may
+// see it, or manage it when manage is set. It writes the error response
+// and returns false otherwise.
+func (dh *DomainHandler) loadDomain(w http.ResponseWriter, r *http.Request, manage bool) (*models.CustomDomain, bool) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	domainID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid domain ID")
+		return nil, false
+	}
+
+	domain, err := dh.domains.Get(r.Context(), domainID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondError(w, http.StatusNotFound, "domain not found")
+			return nil, false
+		}
+		logger.ErrorEvent().Err(err).Msg("Failed to get custom domain")
+		respondError(w, http.StatusInternalServerError, "failed to get domain")
+		return nil, false
+	}
+
+	if !canAccessDomain(claims, domain, manage) {
+		respondError(w, http.StatusForbidden, "access denied")
+		return nil, false
+	}
+	return domain, true
+}
+
+// canAccessDomain reports whether the caller may see (or manage) a domain:
+// its owner and super admins always; members of its organization may see it
+// and org admins manage it.
+func canAccessDomain(claims *middleware.Claims, domain *models.CustomDomain, manage bool) bool {
+	if claims.Role == string(models.RoleSuperAdmin) || domain.UserID.String() == claims.UserID {
+		return true
+	}
+	if domain.OrganizationID == nil || claims.OrganizationID == nil || domain.OrganizationID.String() != *claims.OrganizationID {
+		return false
+	}
+	return !manage || claims.Role == string(models.RoleOrgAdmin)
+}
+
+// respondDomainError maps custom domain errors to HTTP responses.
+func (dh *DomainHandler) respondDomainError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, pkgerrors.ErrInvalidDomain), errors.Is(err, pkgerrors.ErrInvalidSubdomain):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, pkgerrors.ErrDomainTaken):
+		respondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, pkgerrors.ErrDomainNotVerified):
+		respondError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		logger.ErrorEvent().Err(err).Msg(message)
+		respondError(w, http.StatusInternalServerError, message)
+	}
+}
+
+// toResponse adds the setup instructions to a domain.
+func (dh *DomainHandler) toResponse(domain models.CustomDomain) DomainResponse {
+	verification := DomainVerification{Method: domain.VerificationMethod}
+	if domain.VerificationMethod == models.VerificationHTTP {
+		verification.URL = "http://" + domain.Hostname + domain.ChallengePath()
+	} else {
+		verification.RecordName = domain.ChallengeRecord()
+		verification.RecordValue = domain.VerificationToken
+		if dh.delegateChallenges {
+			verification.DelegateTo = domain.DelegatedChallengeRecord(dh.baseDomain)
+		}
+	}
+
+	return DomainResponse{
+		CustomDomain: domain,
+		Verified:     domain.IsVerified(),
+		CNAMETarget:  domain.Subdomain + "." + dh.baseDomain,
+		Verification: verification,
+	}
+}
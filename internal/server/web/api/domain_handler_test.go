@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/domains"
+	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupDomainHandler creates a custom domain handler for grok.io
+func setupDomainHandler(t *testing.T) (*DomainHandler, *gorm.DB) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.CustomDomain{}))
+	return NewDomainHandler(db, domains.NewService(db, "grok.io", 80), "grok.io", false), db
+}
+
+// domainRequest runs a domain handler as the user of claims
+func domainRequest(handler http.HandlerFunc, claims *middleware.Claims, method, id string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, "/api/domains", bytes.NewReader(payload))
+	if id != "" {
+		req.SetPathValue("id", id)
+	}
+	req = req.WithContext(middleware.SetClaimsInContext(req.Context(), claims))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// TestCreateDomain tests registering custom domains
+func TestCreateDomain(t *testing.T) {
+	handler, db := setupDomainHandler(t)
+
+	org := createTestOrg(t, db, "testorg")
+	admin := createTestOrgUser(t, db, org.ID, models.RoleOrgAdmin)
+	user := createTestOrgUser(t, db, org.ID, models.RoleOrgUser)
+	adminClaims := &middleware.Claims{UserID: admin.ID.String(), Role: string(models.RoleOrgAdmin), OrganizationID: strPtr(org.ID.String())}
+	userClaims := &middleware.Claims{UserID: user.ID.String(), Role: string(models.RoleOrgUser), OrganizationID: strPtr(org.ID.String())}
+
+	tests := []struct {
+		name           string
+		claims         *middleware.Claims
+		body           CreateDomainRequest
+		expectedStatus int
+		checkResponse  func(t *testing.T, resp DomainResponse)
+	}{
+		{
+			name:           "dns verification",
+			claims:         userClaims,
+			body:           CreateDomainRequest{Hostname: "demo.example.com", Subdomain: "myapp-testorg"},
+			expectedStatus: http.StatusCreated,
+			checkResponse: func(t *testing.T, resp DomainResponse) {
+				assert.False(t, resp.Verified)
+				assert.Nil(t, resp.OrganizationID)
+				assert.Equal(t, "myapp-testorg.grok.io", resp.CNAMETarget)
+				assert.Equal(t, "dns", resp.Verification.Method)
+				assert.Equal(t, "_grok-challenge.demo.example.com", resp.Verification.RecordName)
+				assert.Equal(t, resp.VerificationToken, resp.Verification.RecordValue)
+				assert.Empty(t, resp.Verification.DelegateTo, "no DNS server")
+			},
+		},
+		{
+			name:           "http verification for the organization",
+			claims:         adminClaims,
+			body:           CreateDomainRequest{Hostname: "api.example.com", Subdomain: "api-testorg", VerificationMethod: "http", Organization: true},
+			expectedStatus: http.StatusCreated,
+			checkResponse: func(t *testing.T, resp DomainResponse) {
+				require.NotNil(t, resp.OrganizationID)
+				assert.Equal(t, org.ID, *resp.OrganizationID)
+				assert.Equal(t, "http://api.example.com/.well-known/grok-challenge/"+resp.VerificationToken, resp.Verification.URL)
+			},
+		},
+		{
+			name:           "organization domain needs org admin",
+			claims:         userClaims,
+			body:           CreateDomainRequest{Hostname: "www.example.com", Subdomain: "myapp", Organization: true},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "duplicate hostname",
+			claims:         adminClaims,
+			body:           CreateDomainRequest{Hostname: "demo.example.com", Subdomain: "myapp"},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "server domain",
+			claims:         userClaims,
+			body:           CreateDomainRequest{Hostname: "myapp.grok.io", Subdomain: "myapp"},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := domainRequest(handler.CreateDomain, tt.claims, "POST", "", tt.body)
+			assert.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+
+			if tt.checkResponse != nil {
+				var resp DomainResponse
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+				tt.checkResponse(t, resp)
+			}
+		})
+	}
+}
+
+// TestCreateDomain_DelegatedChallenge tests the challenge delegation
+// instructions when the built-in DNS server is enabled
+func TestCreateDomain_DelegatedChallenge(t *testing.T) {
+	handler, db := setupDomainHandler(t)
+	handler.delegateChallenges = true
+	user := createTestUser(t, db, models.RoleOrgUser, nil)
+	claims := &middleware.Claims{UserID: user.ID.String(), Role: string(models.RoleOrgUser)}
+
+	rec := domainRequest(handler.CreateDomain, claims, http.MethodPost, "", CreateDomainRequest{Hostname: "demo.example.com", Subdomain: "myapp"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	var resp DomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "_grok-challenge.demo.example.com", resp.Verification.RecordName)
+	assert.Equal(t, "_grok-challenge.demo.example.com.grok.io", resp.Verification.DelegateTo)
+}
+
+// TestDomainAccess tests who may see and manage a custom domain
+func TestDomainAccess(t *testing.T) {
+	handler, db := setupDomainHandler(t)
+
+	org := createTestOrg(t, db, "testorg")
+	admin := createTestOrgUser(t, db, org.ID, models.RoleOrgAdmin)
+	owner := createTestOrgUser(t, db, org.ID, models.RoleOrgUser)
+	member := createTestOrgUser(t, db, org.ID, models.RoleOrgUser)
+	outsider := createTestUser(t, db, models.RoleOrgUser, nil)
+
+	claimsOf := func(u *models.User) *middleware.Claims {
+		claims := &middleware.Claims{UserID: u.ID.String(), Role: string(u.Role)}
+		if u.OrganizationID != nil {
+			claims.OrganizationID = strPtr(u.OrganizationID.String())
+		}
+		return claims
+	}
+
+	// A personal and an organization domain
+	rec := domainRequest(handler.CreateDomain, claimsOf(owner), "POST", "", CreateDomainRequest{Hostname: "demo.example.com", Subdomain: "myapp"})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var personal DomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &personal))
+
+	rec = domainRequest(handler.CreateDomain, claimsOf(admin), "POST", "", CreateDomainRequest{Hostname: "org.example.com", Subdomain: "myapp", Organization: true})
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var orgDomain DomainResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &orgDomain))
+
+	t.Run("list", func(t *testing.T) {
+		count := func(u *models.User) int {
+			var list []DomainResponse
+			rec := domainRequest(handler.ListDomains, claimsOf(u), "GET", "", nil)
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+			return len(list)
+		}
+		assert.Equal(t, 2, count(owner))
+		assert.Equal(t, 1, count(member))
+		assert.Equal(t, 0, count(outsider))
+	})
+
+	t.Run("get", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, domainRequest(handler.GetDomain, claimsOf(member), "GET", orgDomain.ID.String(), nil).Code)
+		assert.Equal(t, http.StatusForbidden, domainRequest(handler.GetDomain, claimsOf(member), "GET", personal.ID.String(), nil).Code)
+		assert.Equal(t, http.StatusForbidden, domainRequest(handler.GetDomain, claimsOf(outsider), "GET", orgDomain.ID.String(), nil).Code)
+		assert.Equal(t, http.StatusNotFound, domainRequest(handler.GetDomain, claimsOf(owner), "GET", "00000000-0000-0000-0000-000000000000", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, domainRequest(handler.GetDomain, claimsOf(owner), "GET", "invalid", nil).Code)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		body := UpdateDomainRequest{Subdomain: strPtr("other")}
+		assert.Equal(t, http.StatusForbidden, domainRequest(handler.UpdateDomain, claimsOf(member), "PATCH", orgDomain.ID.String(), body).Code)
+
+		rec := domainRequest(handler.UpdateDomain, claimsOf(admin), "PATCH", orgDomain.ID.String(), body)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var resp DomainResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "other", resp.Subdomain)
+		assert.Equal(t, "other.grok.io", resp.CNAMETarget)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, domainRequest(handler.DeleteDomain, claimsOf(member), "DELETE", personal.ID.String(), nil).Code)
+		assert.Equal(t, http.StatusOK, domainRequest(handler.DeleteDomain, claimsOf(owner), "DELETE", personal.ID.String(), nil).Code)
+		assert.Equal(t, http.StatusNotFound, domainRequest(handler.GetDomain, claimsOf(owner), "GET", personal.ID.String(), nil).Code)
+	})
+}
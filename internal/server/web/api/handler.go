@@ -5,17 +5,23 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
 	"github.com/pandeptwidyaop/grok/internal/server/config"
+	"github.com/pandeptwidyaop/grok/internal/server/domains"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
 	"github.com/pandeptwidyaop/grok/internal/server/proxy"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -25,6 +31,7 @@ type Handler struct {
 	tokenService  *auth.TokenService
 	tunnelManager *tunnel.Manager
 	webhookRouter *proxy.WebhookRouter
+	domains       *domains.Service
 	config        *config.Config
 	authMW        *middleware.AuthMiddleware
 	rateLimiter   *middleware.RateLimiter
@@ -33,12 +40,13 @@ type Handler struct {
 }
 
 // NewHandler creates a new dashboard API handler
-func NewHandler(db *gorm.DB, tokenService *auth.TokenService, tunnelManager *tunnel.Manager, webhookRouter *proxy.WebhookRouter, cfg *config.Config) *Handler {
+func NewHandler(db *gorm.DB, tokenService *auth.TokenService, tunnelManager *tunnel.Manager, webhookRouter *proxy.WebhookRouter, domainService *domains.Service, cfg *config.Config) *Handler {
 	h := &Handler{
 		db:            db,
 		tokenService:  tokenService,
 		tunnelManager: tunnelManager,
 		webhookRouter: webhookRouter,
+		domains:       domainService,
 		config:        cfg,
 		authMW:        middleware.NewAuthMiddleware(cfg.Auth.JWTSecret),
 		rateLimiter:   middleware.NewRateLimiter(0.5, 3), // 1 request per 2 seconds, burst of 3
@@ -301,6 +309,9 @@ func (h *Handler) isAllowedOrigin(origin string) bool {
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	// Create organization handler, webhook handler, version handler, 2FA handler, and RBAC middleware
 	orgHandler := NewOrganizationHandler(h.db, h.config.Server.Domain)
+	if h.tunnelManager != nil {
+		orgHandler.SetIPFilter(h.tunnelManager.IPFilter())
+	}
 	webhookHandler := NewWebhookHandler(h.db, h.tunnelManager)
 	versionHandler := NewVersionHandler()
 	twoFAHandler := NewTwoFAHandler(h.db, h.config.Server.Domain)
@@ -328,6 +339,10 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.Handle("GET /api/tunnels/{id}", h.authMW.Protect(http.HandlerFunc(h.getTunnel)))
 	mux.Handle("GET /api/tunnels/{id}/logs", h.authMW.Protect(http.HandlerFunc(h.getTunnelLogs)))
 	mux.Handle("DELETE /api/tunnels/{id}", h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(h.deleteTunnel))))
+	mux.Handle("POST /api/tunnels/{id}/share-links", h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(h.createShareLink))))
+	mux.Handle("GET /api/tunnels/{id}/header-rules", h.authMW.Protect(http.HandlerFunc(h.getTunnelHeaderRules)))
+	mux.Handle("PUT /api/tunnels/{id}/header-rules", h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(h.updateTunnelHeaderRules))))
+	mux.Handle("DELETE /api/tunnels/{id}/cache", h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(h.purgeTunnelCache))))
 
 	mux.Handle("GET /api/stats", h.authMW.Protect(http.HandlerFunc(h.getStats)))
 	mux.Handle("GET /api/config", h.authMW.Protect(http.HandlerFunc(h.getConfig)))
@@ -370,6 +385,12 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.Handle("GET /api/organizations/{org_id}/tunnels",
 		h.authMW.Protect(rbac.RequireOrgMembership(rbac.RequireOrgAdmin(http.HandlerFunc(orgHandler.ListOrgTunnels)))))
 
+	// Organization IP rules - Org Admin + Super Admin
+	mux.Handle("GET /api/organizations/{org_id}/ip-rules",
+		h.authMW.Protect(rbac.RequireOrgMembership(rbac.RequireOrgAdmin(http.HandlerFunc(orgHandler.GetOrgIPRules)))))
+	mux.Handle("PUT /api/organizations/{org_id}/ip-rules",
+		h.authMW.Protect(rbac.RequireOrgMembership(rbac.RequireOrgAdmin(http.HandlerFunc(orgHandler.UpdateOrgIPRules)))))
+
 	// Webhook routes - Org membership required
 	// Webhook App Management
 	mux.Handle("POST /api/webhooks/apps",
@@ -405,6 +426,23 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.Handle("GET /api/webhooks/apps/{app_id}/stats",
 		h.authMW.Protect(rbac.RequireOrganization(http.HandlerFunc(webhookHandler.GetStats))))
 
+	// Custom domain routes - owners, their org admins and super admins
+	if h.domains != nil {
+		domainHandler := NewDomainHandler(h.db, h.domains, h.config.Server.Domain, h.config.DNS.Enabled)
+		mux.Handle("POST /api/domains",
+			h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(domainHandler.CreateDomain))))
+		mux.Handle("GET /api/domains",
+			h.authMW.Protect(http.HandlerFunc(domainHandler.ListDomains)))
+		mux.Handle("GET /api/domains/{id}",
+			h.authMW.Protect(http.HandlerFunc(domainHandler.GetDomain)))
+		mux.Handle("PATCH /api/domains/{id}",
+			h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(domainHandler.UpdateDomain))))
+		mux.Handle("POST /api/domains/{id}/verify",
+			h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(domainHandler.VerifyDomain))))
+		mux.Handle("DELETE /api/domains/{id}",
+			h.csrf.Protect(h.authMW.Protect(http.HandlerFunc(domainHandler.DeleteDomain))))
+	}
+
 	// Server-Sent Events (SSE) for real-time updates
 	mux.Handle("GET /api/sse", h.authMW.Protect(http.HandlerFunc(h.HandleSSE)))
 }
@@ -807,6 +845,254 @@ func (h *Handler) getTunnelLogs(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// createShareLink signs a share link for an online tunnel whose access policy accepts them.
+func (h *Handler) createShareLink(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tunnelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid tunnel ID")
+		return
+	}
+
+	var req struct {
+		TTLSeconds int64 `json:"ttl_seconds"` // Validity of the link (server default when 0)
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	tun, ok := h.tunnelManager.GetTunnelByID(tunnelID)
+	if !ok {
+		respondError(w, http.StatusNotFound, "Tunnel is not online")
+		return
+	}
+
+	// Same rules as viewing the tunnel: owner, admin of its organization or super admin
+	isOwner := tun.UserID.String() == claims.UserID
+	isSuperAdmin := claims.Role == string(models.RoleSuperAdmin)
+	isOrgAdmin := claims.Role == string(models.RoleOrgAdmin)
+	if !isSuperAdmin {
+		if isOrgAdmin {
+			if tun.OrganizationID == nil || claims.OrganizationID == nil || tun.OrganizationID.String() != *claims.OrganizationID {
+				respondError(w, http.StatusForbidden, "Access denied")
+				return
+			}
+		} else if !isOwner {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	guard := h.tunnelManager.AccessGuard()
+	if guard == nil || tun.Access == nil || !tun.Access.ShareLinks {
+		respondError(w, http.StatusConflict, "Tunnel does not accept share links")
+		return
+	}
+
+	link, expires, err := guard.CreateShareLink(access.Scope(tun.Subdomain, tun.UserID), tun.PublicURL, time.Duration(req.TTLSeconds)*time.Second)
+	if errors.Is(err, access.ErrShareLinkTTL) {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		logger.ErrorEvent().Err(err).Str("tunnel_id", tunnelID.String()).Msg("Failed to create share link")
+		respondError(w, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tunnelID.String()).
+		Str("user_id", claims.UserID).
+		Time("expires_at", expires).
+		Msg("Share link created")
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"url":        link,
+		"expires_at": expires,
+	})
+}
+
+// TunnelHeaderRulesResponse holds the header rules of a tunnel.
+type TunnelHeaderRulesResponse struct {
+	headerrules.Config                     // Set by admins, applied after the client's
+	Client             *headerrules.Config `json:"client,omitempty"` // Declared by the client, while online
+}
+
+// getTunnelHeaderRules returns the header rules of a tunnel
+func (h *Handler) getTunnelHeaderRules(w http.ResponseWriter, r *http.Request) {
+	tun, ok := h.loadAdminTunnel(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := headerrules.TunnelRulesFromModel(tun)
+	if err != nil {
+		logger.ErrorEvent().Err(err).Str("tunnel_id", tun.ID.String()).Msg("Invalid stored header rules")
+		respondError(w, http.StatusInternalServerError, "Failed to read header rules")
+		return
+	}
+
+	response := TunnelHeaderRulesResponse{Config: rules.Config()}
+	if online, ok := h.tunnelManager.GetTunnelByID(tun.ID); ok && online.Headers != nil {
+		client := online.Headers.Config()
+		response.Client = &client
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// updateTunnelHeaderRules replaces the header rules admins set on a tunnel; empty lists remove them
+func (h *Handler) updateTunnelHeaderRules(w http.ResponseWriter, r *http.Request) {
+	var req headerrules.Config
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rules, err := headerrules.Parse(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tun, ok := h.loadAdminTunnel(w, r)
+	if !ok {
+		return
+	}
+	if !tunnel.ServesHTTP(tunnelv1.TunnelProtocol(tunnelv1.TunnelProtocol_value[strings.ToUpper(tun.TunnelType)])) {
+		respondError(w, http.StatusBadRequest, "Header rules are only supported for HTTP tunnels")
+		return
+	}
+
+	cfg := rules.Config()
+	var stored datatypes.JSON
+	if rules != nil {
+		if stored, err = json.Marshal(cfg); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to update header rules")
+			return
+		}
+	}
+	if err := h.db.Model(tun).Update("header_rules", stored).Error; err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to update tunnel header rules")
+		respondError(w, http.StatusInternalServerError, "Failed to update header rules")
+		return
+	}
+	if online, ok := h.tunnelManager.GetTunnelByID(tun.ID); ok {
+		online.SetAdminHeaderRules(rules)
+	}
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Int("request", len(cfg.Request)).
+		Int("response", len(cfg.Response)).
+		Msg("Tunnel header rules updated")
+
+	respondJSON(w, http.StatusOK, TunnelHeaderRulesResponse{Config: cfg})
+}
+
+// purgeTunnelCache removes the responses of a tunnel from the edge cache,
+// those under the path given by the path query parameter or all of them.
+func (h *Handler) purgeTunnelCache(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	tunnelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid tunnel ID")
+		return
+	}
+
+	var tun models.Tunnel
+	if err := h.db.First(&tun, "id = ?", tunnelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondError(w, http.StatusNotFound, "Tunnel not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to get tunnel")
+		return
+	}
+
+	// Same rules as viewing the tunnel: owner, admin of its organization or super admin
+	isOwner := tun.UserID.String() == claims.UserID
+	isSuperAdmin := claims.Role == string(models.RoleSuperAdmin)
+	isOrgAdmin := claims.Role == string(models.RoleOrgAdmin)
+	if !isSuperAdmin {
+		if isOrgAdmin {
+			if tun.OrganizationID == nil || claims.OrganizationID == nil || tun.OrganizationID.String() != *claims.OrganizationID {
+				respondError(w, http.StatusForbidden, "Access denied")
+				return
+			}
+		} else if !isOwner {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	path := r.URL.Query().Get("path")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		respondError(w, http.StatusBadRequest, "Path must start with /")
+		return
+	}
+	purged := h.tunnelManager.EdgeCache().Purge(tun.ID, path)
+
+	logger.InfoEvent().
+		Str("tunnel_id", tun.ID.String()).
+		Str("path", path).
+		Int("purged", purged).
+		Msg("Tunnel cache purged")
+
+	respondJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
+
+// loadAdminTunnel loads the tunnel of the request for a super admin or an
+// admin of its organization, or writes the error response.
+func (h *Handler) loadAdminTunnel(w http.ResponseWriter, r *http.Request) (*models.Tunnel, bool) {
+	claims := middleware.GetClaimsFromContext(r.Context())
+	if claims == nil {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	tunnelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid tunnel ID")
+		return nil, false
+	}
+
+	var tun models.Tunnel
+	if err := h.db.First(&tun, "id = ?", tunnelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondError(w, http.StatusNotFound, "Tunnel not found")
+			return nil, false
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to get tunnel")
+		return nil, false
+	}
+
+	switch claims.Role {
+	case string(models.RoleSuperAdmin):
+	case string(models.RoleOrgAdmin):
+		if tun.OrganizationID == nil || claims.OrganizationID == nil || tun.OrganizationID.String() != *claims.OrganizationID {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return nil, false
+		}
+	default:
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return nil, false
+	}
+	return &tun, true
+}
+
 // deleteTunnel forcefully disconnects and deletes a tunnel
 func (h *Handler) deleteTunnel(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetClaimsFromContext(r.Context())
@@ -879,12 +1165,13 @@ func (h *Handler) deleteTunnel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Disconnect tunnel from tunnel manager if active
-	if err := h.tunnelManager.UnregisterTunnel(r.Context(), tunnelID); err != nil {
+	if err := h.tunnelManager.CloseTunnel(r.Context(), tunnelID); err != nil {
 		logger.WarnEvent().
 			Err(err).
 			Str("tunnel_id", tunnelID.String()).
 			Msg("Failed to unregister tunnel (may already be offline)")
 	}
+	h.tunnelManager.CancelReconnect(tun.Subdomain)
 
 	// Delete domain reservation for this subdomain
 	if err := h.db.Where("subdomain = ?", tun.Subdomain).Delete(&models.Domain{}).Error; err != nil {
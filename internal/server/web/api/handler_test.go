@@ -2,16 +2,23 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/access"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
 	"github.com/pandeptwidyaop/grok/internal/server/config"
+	"github.com/pandeptwidyaop/grok/internal/server/edgecache"
+	"github.com/pandeptwidyaop/grok/internal/server/headerrules"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
 	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
@@ -391,7 +398,7 @@ func setupHandlerWithAuth(db *gorm.DB) *Handler {
 		11000, // TCP end port
 	)
 
-	return NewHandler(db, tokenService, tunnelManager, nil, cfg)
+	return NewHandler(db, tokenService, tunnelManager, nil, nil, cfg)
 }
 
 // TestHealth tests the health check endpoint
@@ -680,6 +687,263 @@ func TestGetTunnel(t *testing.T) {
 	}
 }
 
+// TestCreateShareLink tests creating share links for online tunnels
+func TestCreateShareLink(t *testing.T) {
+	db := setupTestDB(t)
+	handler := setupHandlerWithAuth(db)
+
+	guard, err := access.NewGuard(access.Config{Secret: []byte("0123456789abcdef0123456789abcdef")})
+	require.NoError(t, err)
+	handler.tunnelManager.SetAccessGuard(guard)
+
+	org := &models.Organization{
+		Name:      "Test Org",
+		Subdomain: "testorg",
+		IsActive:  true,
+	}
+	db.Create(org)
+
+	orgAdmin := createTestUser(t, db, models.RoleOrgAdmin, &org.ID)
+	owner := createTestUser(t, db, models.RoleOrgUser, &org.ID)
+	otherUser := createTestUser(t, db, models.RoleOrgUser, nil)
+	ownerOrg := &[]string{org.ID.String()}[0]
+
+	register := func(subdomain string, policy *tunnelv1.AccessPolicy) *tunnel.Tunnel {
+		token := createTestAuthToken(t, db, owner.ID, "Token "+subdomain)
+		tun := tunnel.NewTunnel(owner.ID, token.ID, &org.ID, subdomain, tunnelv1.TunnelProtocol_HTTP,
+			"localhost:3000", fmt.Sprintf("https://%s.grok.io", subdomain), nil)
+		tun.Access, err = access.PolicyFromProto(policy)
+		require.NoError(t, err)
+		require.NoError(t, handler.tunnelManager.RegisterTunnel(context.Background(), tun))
+		return tun
+	}
+	shared := register("shared", &tunnelv1.AccessPolicy{ShareLinks: true})
+	private := register("private", &tunnelv1.AccessPolicy{
+		BasicAuth: []*tunnelv1.BasicAuthCredential{{Username: "alice", Password: "s3cret"}},
+	})
+
+	tests := []struct {
+		name           string
+		tunnelID       string
+		userID         string
+		role           string
+		orgID          *string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "owner creates link",
+			tunnelID:       shared.ID.String(),
+			userID:         owner.ID.String(),
+			role:           string(models.RoleOrgUser),
+			orgID:          ownerOrg,
+			body:           `{"ttl_seconds": 3600}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "org admin creates link with default ttl",
+			tunnelID:       shared.ID.String(),
+			userID:         orgAdmin.ID.String(),
+			role:           string(models.RoleOrgAdmin),
+			orgID:          ownerOrg,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "other user cannot create link",
+			tunnelID:       shared.ID.String(),
+			userID:         otherUser.ID.String(),
+			role:           string(models.RoleOrgUser),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "share links not enabled",
+			tunnelID:       private.ID.String(),
+			userID:         owner.ID.String(),
+			role:           string(models.RoleOrgUser),
+			orgID:          ownerOrg,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "offline tunnel",
+			tunnelID:       uuid.New().String(),
+			userID:         owner.ID.String(),
+			role:           string(models.RoleOrgUser),
+			orgID:          ownerOrg,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/tunnels/"+tt.tunnelID+"/share-links", strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.tunnelID)
+
+			ctx := middleware.SetClaimsInContext(req.Context(), &middleware.Claims{
+				UserID:         tt.userID,
+				Username:       "testuser",
+				Role:           tt.role,
+				OrganizationID: tt.orgID,
+			})
+			req = req.WithContext(ctx)
+
+			rec := httptest.NewRecorder()
+			handler.createShareLink(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if rec.Code == http.StatusCreated {
+				var response map[string]interface{}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+				assert.Contains(t, response["url"], "https://shared.grok.io/?"+access.ShareParam+"=")
+				assert.NotEmpty(t, response["expires_at"])
+			}
+		})
+	}
+}
+
+// TestTunnelHeaderRules tests that admins read and replace the header rules of tunnels
+func TestTunnelHeaderRules(t *testing.T) {
+	db := setupTestDB(t)
+	handler := setupHandlerWithAuth(db)
+
+	org := &models.Organization{
+		Name:      "Test Org",
+		Subdomain: "testorg",
+		IsActive:  true,
+	}
+	db.Create(org)
+
+	superAdmin := createTestUser(t, db, models.RoleSuperAdmin, nil)
+	orgAdmin := createTestUser(t, db, models.RoleOrgAdmin, &org.ID)
+	owner := createTestUser(t, db, models.RoleOrgUser, &org.ID)
+	otherAdmin := createTestUser(t, db, models.RoleOrgAdmin, nil)
+	ownerOrg := &[]string{org.ID.String()}[0]
+
+	token := createTestAuthToken(t, db, owner.ID, "Token docs")
+	online := tunnel.NewTunnel(owner.ID, token.ID, &org.ID, "docs", tunnelv1.TunnelProtocol_HTTP,
+		"localhost:3000", "https://docs.grok.io", nil)
+	online.Headers, _ = headerrules.Parse(headerrules.Config{
+		Response: []headerrules.RuleConfig{{Action: headerrules.ActionRemove, Name: "Server"}},
+	})
+	require.NoError(t, handler.tunnelManager.RegisterTunnel(context.Background(), online))
+	offline := createTestTunnel(t, db, owner.ID, &org.ID, "offline")
+	tcp := createTestTunnel(t, db, owner.ID, &org.ID, "tcp")
+	db.Model(tcp).Update("tunnel_type", "tcp")
+
+	call := func(method, tunnelID, body, userID, role string, orgID *string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/tunnels/"+tunnelID+"/header-rules", strings.NewReader(body))
+		req.SetPathValue("id", tunnelID)
+		req = req.WithContext(middleware.SetClaimsInContext(req.Context(), &middleware.Claims{
+			UserID:         userID,
+			Username:       "testuser",
+			Role:           role,
+			OrganizationID: orgID,
+		}))
+		rec := httptest.NewRecorder()
+		if method == "PUT" {
+			handler.updateTunnelHeaderRules(rec, req)
+		} else {
+			handler.getTunnelHeaderRules(rec, req)
+		}
+		return rec
+	}
+
+	noindex := `{"response":[{"action":"set","name":"x-robots-tag","value":"noindex"}]}`
+	rec := call("PUT", online.ID.String(), noindex, orgAdmin.ID.String(), string(models.RoleOrgAdmin), ownerOrg)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, online.HeaderRules(), 2, "applied to the online tunnel")
+	assert.Equal(t, "X-Robots-Tag", online.HeaderRules()[1].Response[0].Name)
+
+	rec = call("GET", online.ID.String(), "", superAdmin.ID.String(), string(models.RoleSuperAdmin), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response TunnelHeaderRulesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []headerrules.RuleConfig{{Action: "set", Name: "X-Robots-Tag", Value: "noindex"}}, response.Response)
+	require.NotNil(t, response.Client)
+	assert.Equal(t, []headerrules.RuleConfig{{Action: "remove", Name: "Server"}}, response.Client.Response)
+
+	// Stored rules are loaded when the tunnel reconnects
+	var stored models.Tunnel
+	require.NoError(t, db.First(&stored, "id = ?", online.ID).Error)
+	rules, err := headerrules.TunnelRulesFromModel(&stored)
+	require.NoError(t, err)
+	assert.Len(t, rules.Response, 1)
+
+	rec = call("PUT", offline.ID.String(), `{}`, superAdmin.ID.String(), string(models.RoleSuperAdmin), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var cleared models.Tunnel
+	require.NoError(t, db.First(&cleared, "id = ?", offline.ID).Error)
+	assert.Empty(t, cleared.HeaderRules)
+
+	rec = call("PUT", tcp.ID.String(), noindex, superAdmin.ID.String(), string(models.RoleSuperAdmin), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	tests := []struct {
+		name           string
+		body           string
+		userID         string
+		role           string
+		orgID          *string
+		expectedStatus int
+	}{
+		{"owner is not an admin", noindex, owner.ID.String(), string(models.RoleOrgUser), ownerOrg, http.StatusForbidden},
+		{"admin of another organization", noindex, otherAdmin.ID.String(), string(models.RoleOrgAdmin), nil, http.StatusForbidden},
+		{"protected header", `{"request":[{"action":"set","name":"Host","value":"x"}]}`, superAdmin.ID.String(), string(models.RoleSuperAdmin), nil, http.StatusBadRequest},
+		{"unknown variable", `{"request":[{"action":"set","name":"X-A","value":"{user}"}]}`, superAdmin.ID.String(), string(models.RoleSuperAdmin), nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := call("PUT", online.ID.String(), tt.body, tt.userID, tt.role, tt.orgID)
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+// TestPurgeTunnelCache tests that owners and admins purge the edge cache of tunnels
+func TestPurgeTunnelCache(t *testing.T) {
+	db := setupTestDB(t)
+	handler := setupHandlerWithAuth(db)
+	cache, err := edgecache.New(edgecache.Config{MaxMemory: 1 << 20})
+	require.NoError(t, err)
+	handler.tunnelManager.SetEdgeCache(cache)
+
+	owner := createTestUser(t, db, models.RoleOrgUser, nil)
+	other := createTestUser(t, db, models.RoleOrgUser, nil)
+	tun := createTestTunnel(t, db, owner.ID, nil, "static")
+
+	store := func(paths ...string) {
+		for _, path := range paths {
+			entry, ok := edgecache.NewEntry(http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, time.Now())
+			require.True(t, ok)
+			cache.Store(tun.ID, httptest.NewRequest("GET", "http://static.grok.io"+path, nil), entry)
+		}
+	}
+	purge := func(query, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/api/tunnels/"+tun.ID.String()+"/cache"+query, nil)
+		req.SetPathValue("id", tun.ID.String())
+		req = req.WithContext(middleware.SetClaimsInContext(req.Context(), &middleware.Claims{
+			UserID:   userID,
+			Username: "testuser",
+			Role:     string(models.RoleOrgUser),
+		}))
+		rec := httptest.NewRecorder()
+		handler.purgeTunnelCache(rec, req)
+		return rec
+	}
+
+	store("/assets/app.js", "/assets/app.css", "/index.html")
+	assert.Equal(t, http.StatusForbidden, purge("", other.ID.String()).Code)
+	assert.Equal(t, http.StatusBadRequest, purge("?path=assets", owner.ID.String()).Code)
+
+	rec := purge("?path=/assets/", owner.ID.String())
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":2}`, rec.Body.String())
+	assert.Equal(t, 1, cache.Len())
+
+	rec = purge("", owner.ID.String())
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+}
+
 // TestCreateToken tests token creation
 func TestCreateToken(t *testing.T) {
 	db := setupTestDB(t)
@@ -7,16 +7,19 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
 	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
 	"github.com/pandeptwidyaop/grok/pkg/logger"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
 // OrganizationHandler handles organization-related API requests
 type OrganizationHandler struct {
-	db     *gorm.DB
-	domain string
+	db       *gorm.DB
+	domain   string
+	ipFilter *ipfilter.Filter // Enforces organization IP rules (optional)
 }
 
 // NewOrganizationHandler creates a new organization handler
@@ -27,6 +30,11 @@ func NewOrganizationHandler(db *gorm.DB, domain string) *OrganizationHandler {
 	}
 }
 
+// SetIPFilter sets the filter that enforces the IP rules of organizations.
+func (h *OrganizationHandler) SetIPFilter(filter *ipfilter.Filter) {
+	h.ipFilter = filter
+}
+
 // DTOs for organization management
 
 type CreateOrganizationRequest struct {
@@ -52,6 +60,11 @@ type UpdateUserRoleRequest struct {
 	Role string `json:"role"`
 }
 
+type OrgIPRulesResponse struct {
+	ipfilter.Config
+	GeoIPEnabled bool `json:"geoip_enabled"` // Whether country rules can be used
+}
+
 type OrganizationResponse struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
@@ -585,3 +598,87 @@ func (h *OrganizationHandler) ResetUserPassword(w http.ResponseWriter, r *http.R
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Password reset successfully"})
 }
+
+// IP Rules Handlers (Org Admin + Super Admin)
+
+// GetOrgIPRules gets the IP rules applied to all tunnels of an organization
+func (h *OrganizationHandler) GetOrgIPRules(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("org_id")
+
+	var org models.Organization
+	if err := h.db.Where("id = ?", orgID).First(&org).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respondError(w, http.StatusNotFound, "Organization not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to get organization")
+		return
+	}
+
+	rules, err := ipfilter.OrgRulesFromModel(&org)
+	if err != nil {
+		logger.ErrorEvent().Err(err).Str("org_id", orgID).Msg("Invalid stored IP rules")
+		respondError(w, http.StatusInternalServerError, "Failed to read IP rules")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, OrgIPRulesResponse{Config: rules.Config(), GeoIPEnabled: h.ipFilter.GeoEnabled()})
+}
+
+// UpdateOrgIPRules replaces the IP rules of an organization; empty lists remove them
+func (h *OrganizationHandler) UpdateOrgIPRules(w http.ResponseWriter, r *http.Request) {
+	orgID := r.PathValue("org_id")
+
+	var req ipfilter.Config
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rules, err := ipfilter.Parse(req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rules.UsesCountries() && !h.ipFilter.GeoEnabled() {
+		respondError(w, http.StatusBadRequest, "Country rules need a GeoIP database on the server")
+		return
+	}
+
+	var org models.Organization
+	if err := h.db.Where("id = ?", orgID).First(&org).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			respondError(w, http.StatusNotFound, "Organization not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to get organization")
+		return
+	}
+
+	cfg := rules.Config()
+	var stored datatypes.JSON
+	if rules != nil {
+		if stored, err = json.Marshal(cfg); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to update IP rules")
+			return
+		}
+	}
+	if err := h.db.Model(&org).Update("ip_rules", stored).Error; err != nil {
+		logger.ErrorEvent().Err(err).Msg("Failed to update organization IP rules")
+		respondError(w, http.StatusInternalServerError, "Failed to update IP rules")
+		return
+	}
+	if h.ipFilter != nil {
+		h.ipFilter.SetOrgRules(org.ID, rules)
+	}
+
+	logger.InfoEvent().
+		Str("org_id", orgID).
+		Strs("allow_cidrs", cfg.AllowCIDRs).
+		Strs("deny_cidrs", cfg.DenyCIDRs).
+		Strs("allow_countries", cfg.AllowCountries).
+		Strs("deny_countries", cfg.DenyCountries).
+		Msg("Organization IP rules updated")
+
+	respondJSON(w, http.StatusOK, OrgIPRulesResponse{Config: cfg, GeoIPEnabled: h.ipFilter.GeoEnabled()})
+}
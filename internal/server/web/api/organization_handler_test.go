@@ -11,6 +11,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/server/ipfilter"
 	"github.com/pandeptwidyaop/grok/internal/server/web/middleware"
 	"github.com/pandeptwidyaop/grok/pkg/utils"
 	"github.com/stretchr/testify/assert"
@@ -743,6 +744,64 @@ func TestListOrgTunnels(t *testing.T) {
 	assert.Equal(t, 2, len(tunnels)) // Only active and offline
 }
 
+// TestOrgIPRules tests reading and replacing the IP rules of an organization
+func TestOrgIPRules(t *testing.T) {
+	db := setupTestDB(t)
+	handler := NewOrganizationHandler(db, "grok.io")
+	filter := ipfilter.NewFilter(nil)
+	handler.SetIPFilter(filter)
+
+	org := createTestOrg(t, db, "iprulestest")
+
+	put := func(orgID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/organizations/"+orgID+"/ip-rules", bytes.NewBufferString(body))
+		req.SetPathValue("org_id", orgID)
+		rec := httptest.NewRecorder()
+		handler.UpdateOrgIPRules(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		orgID          string
+		body           string
+		expectedStatus int
+	}{
+		{name: "invalid cidr", orgID: org.ID.String(), body: `{"allow_cidrs":["10.0.0.0/33"]}`, expectedStatus: http.StatusBadRequest},
+		{name: "countries without geoip", orgID: org.ID.String(), body: `{"deny_countries":["RU"]}`, expectedStatus: http.StatusBadRequest},
+		{name: "invalid body", orgID: org.ID.String(), body: `[`, expectedStatus: http.StatusBadRequest},
+		{name: "non-existent org", orgID: uuid.New().String(), body: `{"deny_cidrs":["10.0.0.0/8"]}`, expectedStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedStatus, put(tt.orgID, tt.body).Code)
+		})
+	}
+
+	rec := put(org.ID.String(), `{"allow_cidrs":["10.1.0.0/8"],"deny_cidrs":["192.0.2.7"]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, []string{"10.0.0.0/8"}, filter.OrgRules(org.ID).Config().AllowCIDRs, "applied to online tunnels")
+
+	req := httptest.NewRequest("GET", "/api/organizations/"+org.ID.String()+"/ip-rules", nil)
+	req.SetPathValue("org_id", org.ID.String())
+	rec = httptest.NewRecorder()
+	handler.GetOrgIPRules(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp OrgIPRulesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, []string{"10.0.0.0/8"}, resp.AllowCIDRs)
+	assert.Equal(t, []string{"192.0.2.7/32"}, resp.DenyCIDRs)
+	assert.False(t, resp.GeoIPEnabled)
+
+	// Empty rules remove them
+	require.Equal(t, http.StatusOK, put(org.ID.String(), `{}`).Code)
+	assert.Nil(t, filter.OrgRules(org.ID))
+	var stored models.Organization
+	require.NoError(t, db.First(&stored, "id = ?", org.ID).Error)
+	assert.Empty(t, stored.IPRules)
+}
+
 // TestResetUserPassword tests resetting a user's password
 func TestResetUserPassword(t *testing.T) {
 	db := setupTestDB(t)
@@ -21,6 +21,12 @@ var (
 	ErrRateLimited               = errors.New("rate limited")
 	ErrInvalidProtocol           = errors.New("invalid protocol")
 	ErrNoAvailablePorts          = errors.New("no available ports in pool")
+	ErrIncompatibleProtocol      = errors.New("incompatible protocol version")
+	ErrTunnelReconnecting        = errors.New("tunnel is reconnecting")
+	ErrTunnelRejected            = errors.New("tunnel registration rejected")
+	ErrInvalidDomain             = errors.New("invalid domain")
+	ErrDomainTaken               = errors.New("domain already registered")
+	ErrDomainNotVerified         = errors.New("domain ownership not verified")
 )
 
 // AppError represents an application error with context.
@@ -0,0 +1,106 @@
+package pool
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upstream is a local service connections are made to: a TCP address,
+// optionally speaking TLS, or a Unix domain socket.
+type Upstream struct {
+	Network string      // "tcp" or "unix"
+	Address string      // host:port, or the socket path
+	TLS     *tls.Config // Set for https:// upstreams; the handshake is part of dialing
+}
+
+// ParseUpstream parses the address of a local service:
+//
+//	host:port, http://host:port    plain TCP
+//	https://host[:port]            TLS (port 443 by default)
+//	unix:///path/to.sock           Unix domain socket
+//
+// The TLS config of an https:// upstream verifies the certificate against
+// the system roots for host; callers may adjust it before dialing.
+func ParseUpstream(addr string) (Upstream, error) {
+	scheme, rest, found := strings.Cut(addr, "://")
+	if !found {
+		scheme, rest = "http", addr
+	}
+
+	switch strings.ToLower(scheme) {
+	case "unix":
+		// unix:///run/app.sock, or unix://run/app.sock for a relative path
+		if rest == "" {
+			return Upstream{}, fmt.Errorf("invalid upstream %q: missing socket path", addr)
+		}
+		return Upstream{Network: "unix", Address: rest}, nil
+
+	case "http", "https":
+		u, err := url.Parse(strings.ToLower(scheme) + "://" + rest)
+		if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return Upstream{}, fmt.Errorf("invalid upstream %q (use host:port, https://host:port or unix:///path)", addr)
+		}
+
+		host, port := u.Hostname(), u.Port()
+		if port == "" {
+			if u.Scheme == "http" {
+				return Upstream{}, fmt.Errorf("invalid upstream %q: missing port", addr)
+			}
+			port = "443"
+		}
+		if _, err := strconv.Atoi(port); err != nil {
+			return Upstream{}, fmt.Errorf("invalid upstream %q: bad port %q", addr, port)
+		}
+
+		upstream := Upstream{Network: "tcp", Address: net.JoinHostPort(host, port)}
+		if u.Scheme == "https" {
+			upstream.TLS = &tls.Config{
+				ServerName: host,
+				MinVersion: tls.VersionTLS12,
+				NextProtos: []string{"http/1.1"},
+			}
+		}
+		return upstream, nil
+
+	default:
+		return Upstream{}, fmt.Errorf("invalid upstream %q: unsupported scheme %q", addr, scheme)
+	}
+}
+
+// DialContext connects to the upstream, completing the TLS handshake for TLS upstreams.
+func (u Upstream) DialContext(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	if u.TLS == nil {
+		return dialer.DialContext(ctx, u.Network, u.Address)
+	}
+
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: u.TLS}
+	return tlsDialer.DialContext(ctx, u.Network, u.Address)
+}
+
+// Factory returns a ConnectionFactory dialing the upstream with timeout.
+func (u Upstream) Factory(timeout time.Duration) ConnectionFactory {
+	return func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return u.DialContext(ctx)
+	}
+}
+
+// String returns the upstream as a URL, e.g. for logs.
+func (u Upstream) String() string {
+	switch {
+	case u.Network == "unix":
+		return "unix://" + u.Address
+	case u.TLS != nil:
+		return "https://" + u.Address
+	default:
+		return "http://" + u.Address
+	}
+}
@@ -0,0 +1,123 @@
+package pool
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseUpstream tests parsing local service addresses.
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		tls     bool
+		wantErr bool
+	}{
+		{addr: "localhost:3000", network: "tcp", address: "localhost:3000"},
+		{addr: "http://127.0.0.1:3000", network: "tcp", address: "127.0.0.1:3000"},
+		{addr: "https://localhost:8443", network: "tcp", address: "localhost:8443", tls: true},
+		{addr: "HTTPS://app.local", network: "tcp", address: "app.local:443", tls: true},
+		{addr: "https://[::1]:8443", network: "tcp", address: "[::1]:8443", tls: true},
+		{addr: "unix:///run/php/app.sock", network: "unix", address: "/run/php/app.sock"},
+		{addr: "unix://app.sock", network: "unix", address: "app.sock"},
+		{addr: "localhost", wantErr: true},
+		{addr: "http://localhost", wantErr: true},
+		{addr: "https://localhost:8443/api", wantErr: true},
+		{addr: "ftp://localhost:21", wantErr: true},
+		{addr: "unix://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			upstream, err := ParseUpstream(tt.addr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.network, upstream.Network)
+			assert.Equal(t, tt.address, upstream.Address)
+			assert.Equal(t, tt.tls, upstream.TLS != nil)
+		})
+	}
+
+	upstream, err := ParseUpstream("https://app.local:8443")
+	require.NoError(t, err)
+	assert.Equal(t, "app.local", upstream.TLS.ServerName)
+	assert.Equal(t, "https://app.local:8443", upstream.String())
+}
+
+// TestUpstream_PoolTLS tests pooling connections to a TLS upstream.
+func TestUpstream_PoolTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("secure"))
+	}))
+	defer server.Close()
+
+	upstream, err := ParseUpstream(server.URL)
+	require.NoError(t, err)
+	upstream.TLS.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	upstream.TLS.ServerName = "example.com" // Name in the test server's certificate
+
+	pool, err := NewConnectionPool(DefaultConfig(upstream.Factory(time.Second)))
+	require.NoError(t, err)
+	defer pool.Close()
+
+	conn, err := pool.Get(context.Background())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The connection has completed its handshake
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Verification is on by default
+	upstream.TLS = upstream.TLS.Clone()
+	upstream.TLS.RootCAs = nil
+	_, err = upstream.Factory(time.Second)()
+	var certErr *tls.CertificateVerificationError
+	assert.ErrorAs(t, err, &certErr)
+}
+
+// TestUpstream_Unix tests dialing a Unix domain socket.
+func TestUpstream_Unix(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_, _ = conn.Write([]byte("hello\n"))
+			conn.Close()
+		}
+	}()
+
+	upstream, err := ParseUpstream("unix://" + socket)
+	require.NoError(t, err)
+
+	conn, err := upstream.Factory(time.Second)()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello", strings.TrimSpace(line))
+}
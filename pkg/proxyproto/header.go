@@ -0,0 +1,206 @@
+// Package proxyproto reads and writes PROXY protocol headers (versions 1 and
+// 2), which carry the original client address of a connection relayed by a
+// proxy or load balancer.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Versions of the PROXY protocol.
+const (
+	V1 = 1 // Human-readable header
+	V2 = 2 // Binary header
+)
+
+// v1MaxLength is the longest version 1 header, CRLF included.
+const v1MaxLength = 107
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoHeader is returned by Read when a connection does not start with a PROXY header.
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Header is a PROXY protocol header. Source and Destination are invalid for
+// connections the proxy made itself (LOCAL, or UNKNOWN in version 1), e.g.
+// health checks; such connections keep their own addresses.
+type Header struct {
+	Version     int
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+}
+
+// ParseVersion parses a version given as v1, v2, 1 or 2.
+func ParseVersion(s string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "v1", "1":
+		return V1, nil
+	case "v2", "2":
+		return V2, nil
+	}
+	return 0, fmt.Errorf("invalid PROXY protocol version %q (use v1 or v2)", s)
+}
+
+// Local reports whether the header carries no client address.
+func (h *Header) Local() bool {
+	return !h.Source.IsValid() || !h.Destination.IsValid()
+}
+
+// Format encodes the header. IPv4 addresses are mapped to IPv6 when the
+// other address is IPv6, since both must be of the same family.
+func (h *Header) Format() []byte {
+	src, dst := h.Source.Addr().Unmap(), h.Destination.Addr().Unmap()
+	ipv4 := src.Is4() && dst.Is4()
+
+	if h.Version == V2 {
+		return h.formatV2(src, dst, ipv4)
+	}
+	if h.Local() {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	if !ipv4 {
+		family = "TCP6"
+		src, dst = netip.AddrFrom16(src.As16()), netip.AddrFrom16(dst.As16())
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src, dst, h.Source.Port(), h.Destination.Port())
+}
+
+func (h *Header) formatV2(src, dst netip.Addr, ipv4 bool) []byte {
+	buf := append([]byte(nil), v2Signature...)
+	if h.Local() {
+		return append(buf, 0x20, 0x00, 0x00, 0x00) // LOCAL, UNSPEC, no addresses
+	}
+
+	if ipv4 {
+		buf = append(buf, 0x21, 0x11) // PROXY, TCP over IPv4
+		buf = binary.BigEndian.AppendUint16(buf, 12)
+		buf = append(buf, src.AsSlice()...)
+		buf = append(buf, dst.AsSlice()...)
+	} else {
+		buf = append(buf, 0x21, 0x21) // PROXY, TCP over IPv6
+		buf = binary.BigEndian.AppendUint16(buf, 36)
+		src16, dst16 := src.As16(), dst.As16()
+		buf = append(buf, src16[:]...)
+		buf = append(buf, dst16[:]...)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, h.Source.Port())
+	return binary.BigEndian.AppendUint16(buf, h.Destination.Port())
+}
+
+// Read reads a PROXY header of either version from the start of a
+// connection. It returns ErrNoHeader, without consuming anything, when the
+// connection starts with something else.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+			return nil, ErrNoHeader
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		if prefix, err := r.Peek(len(v2Signature)); err != nil || !bytes.Equal(prefix, v2Signature) {
+			return nil, ErrNoHeader
+		}
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLength {
+			return nil, errors.New("PROXY header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{Version: V1}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY header %q", line)
+	}
+
+	src, err := parseV1Address(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Address(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Version: V1, Source: src, Destination: dst}, nil
+}
+
+func parseV1Address(ip, port string, ipv4 bool) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != ipv4 || addr.Zone() != "" {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q in PROXY header", ip)
+	}
+	// Ports are decimal without leading zeros
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q in PROXY header", port)
+	}
+	return netip.AddrPortFrom(addr, uint16(n)), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", fixed[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: V2}
+	switch fixed[12] & 0x0f {
+	case 0x0: // LOCAL
+		return header, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol command %d", fixed[12]&0x0f)
+	}
+
+	// Other families (UNSPEC, unix sockets) and TLVs following the addresses are ignored
+	switch fixed[13] >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errors.New("PROXY header too short for IPv4 addresses")
+		}
+		header.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:]))
+		header.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:]))
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errors.New("PROXY header too short for IPv6 addresses")
+		}
+		header.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:]))
+		header.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:]))
+	}
+	return header, nil
+}
@@ -0,0 +1,121 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHeader_RoundTrip tests that formatted headers of both versions read back.
+func TestHeader_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		destination string
+		wantSource  string
+	}{
+		{"ipv4", "203.0.113.7:51234", "10.0.0.1:5432", "203.0.113.7:51234"},
+		{"ipv6", "[2001:db8::7]:51234", "[::1]:5432", "[2001:db8::7]:51234"},
+		{"mixed families", "203.0.113.7:51234", "[::1]:5432", "[::ffff:203.0.113.7]:51234"},
+	}
+	for _, tt := range tests {
+		for _, version := range []int{V1, V2} {
+			header := &Header{
+				Version:     version,
+				Source:      netip.MustParseAddrPort(tt.source),
+				Destination: netip.MustParseAddrPort(tt.destination),
+			}
+			reader := bufio.NewReader(io.MultiReader(bytes.NewReader(header.Format()), strings.NewReader("payload")))
+			read, err := Read(reader)
+			require.NoError(t, err, tt.name)
+			assert.Equal(t, version, read.Version, tt.name)
+			assert.Equal(t, tt.wantSource, read.Source.String(), tt.name)
+			assert.False(t, read.Local(), tt.name)
+
+			rest, _ := io.ReadAll(reader)
+			assert.Equal(t, "payload", string(rest), "data after the header is kept")
+		}
+	}
+}
+
+// TestHeader_Format tests the encoding of both versions.
+func TestHeader_Format(t *testing.T) {
+	header := &Header{
+		Version:     V1,
+		Source:      netip.MustParseAddrPort("203.0.113.7:51234"),
+		Destination: netip.MustParseAddrPort("10.0.0.1:5432"),
+	}
+	assert.Equal(t, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 5432\r\n", string(header.Format()))
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string((&Header{Version: V1}).Format()))
+
+	header.Version = V2
+	v2 := header.Format()
+	assert.Equal(t, v2Signature, v2[:12])
+	assert.Equal(t, []byte{0x21, 0x11, 0x00, 0x0c}, v2[12:16])
+	assert.Len(t, v2, 28)
+	assert.Equal(t, append(append([]byte(nil), v2Signature...), 0x20, 0x00, 0x00, 0x00), (&Header{Version: V2}).Format())
+}
+
+// TestRead tests headers without a client address, connections without a
+// header and invalid headers.
+func TestRead(t *testing.T) {
+	read := func(s string) (*Header, error) {
+		return Read(bufio.NewReader(strings.NewReader(s)))
+	}
+
+	header, err := read("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.True(t, header.Local())
+
+	// LOCAL command with a TLV that is skipped
+	header, err = read(string(v2Signature) + "\x20\x00\x00\x03abc")
+	require.NoError(t, err)
+	assert.True(t, header.Local())
+
+	// TLVs after the addresses are skipped
+	v2 := (&Header{Version: V2, Source: netip.MustParseAddrPort("203.0.113.7:1"), Destination: netip.MustParseAddrPort("10.0.0.1:2")}).Format()
+	v2[15] += 3
+	reader := bufio.NewReader(strings.NewReader(string(v2) + "tlvdata"))
+	header, err = Read(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:1", header.Source.String())
+	rest, _ := io.ReadAll(reader)
+	assert.Equal(t, "data", string(rest))
+
+	for _, s := range []string{"GET / HTTP/1.1\r\n", "\x16\x03\x01\x00", "POST / HTTP/1.1\r\n", "\r\n\r\nnot a signature"} {
+		_, err := read(s)
+		assert.ErrorIs(t, err, ErrNoHeader, s)
+	}
+
+	for _, s := range []string{
+		"PROXY TCP4 203.0.113.7 10.0.0.1 51234\r\n",
+		"PROXY TCP4 2001:db8::7 10.0.0.1 51234 5432\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 051234 5432\r\n",
+		"PROXY TCP4 203.0.113.7 10.0.0.1 70000 5432\r\n",
+		"PROXY UDP4 203.0.113.7 10.0.0.1 51234 5432\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		string(v2Signature) + "\x11\x11\x00\x0c",
+		string(v2Signature) + "\x21\x11\x00\x04abcd",
+	} {
+		_, err := read(s)
+		assert.Error(t, err, s)
+		assert.NotErrorIs(t, err, ErrNoHeader, s)
+	}
+}
+
+// TestParseVersion tests the accepted spellings of versions.
+func TestParseVersion(t *testing.T) {
+	for s, want := range map[string]int{"v1": V1, "V2": V2, "1": V1, "2": V2} {
+		version, err := ParseVersion(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, version)
+	}
+	_, err := ParseVersion("v3")
+	assert.Error(t, err)
+}
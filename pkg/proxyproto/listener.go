@@ -0,0 +1,177 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pandeptwidyaop/grok/pkg/logger"
+)
+
+// HeaderTimeout bounds how long a trusted peer may take to send its header.
+const HeaderTimeout = 10 * time.Second
+
+// ParseTrusted parses the networks of trusted proxies, given as CIDRs or bare addresses.
+func ParseTrusted(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Listener wraps a listener whose connections may come through proxies
+// sending PROXY headers. Connections from trusted networks must start with a
+// header, and report the client address it carries as their RemoteAddr;
+// those without a valid header are closed. Connections from anywhere else are
+// accepted as they are.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewListener starts reading the headers of the connections accepted on inner.
+func NewListener(inner net.Listener, trusted []netip.Prefix) *Listener {
+	l := &Listener{
+		Listener: inner,
+		trusted:  trusted,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.acceptConnections()
+	return l
+}
+
+// Accept returns the next connection, once its header has been read.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close stops accepting connections.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		_ = l.Listener.Close() // Best effort
+	})
+	return nil
+}
+
+// acceptConnections reads headers in the background, so a slow peer does not
+// hold up the others.
+func (l *Listener) acceptConnections() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				_ = l.Close()
+				return
+			}
+
+			logger.ErrorEvent().Err(err).Msg("Error accepting connection")
+			select {
+			case <-l.done:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+
+		go l.readHeader(conn)
+	}
+}
+
+// readHeader hands conn to Accept, with the header of a trusted peer read.
+func (l *Listener) readHeader(conn net.Conn) {
+	if l.trusts(conn.RemoteAddr()) {
+		reader := bufio.NewReader(conn)
+		_ = conn.SetReadDeadline(time.Now().Add(HeaderTimeout)) // Best effort
+		header, err := Read(reader)
+		_ = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			logger.WarnEvent().
+				Err(err).
+				Str("remote_addr", conn.RemoteAddr().String()).
+				Msg("Closed connection from trusted proxy without a valid PROXY header")
+			conn.Close()
+			return
+		}
+		conn = &Conn{Conn: conn, reader: reader, header: header}
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// trusts reports whether addr is in a trusted network.
+func (l *Listener) trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted proxy.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader // Holds what the proxy sent after the header
+	header *Header
+}
+
+// Header returns the PROXY header the connection started with.
+func (c *Conn) Header() *Header {
+	return c.header
+}
+
+// Read reads what the proxy buffered after the header first.
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// RemoteAddr returns the client address from the header, or the proxy's
+// address for connections the proxy made itself.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.header.Local() {
+		return c.Conn.RemoteAddr()
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(c.header.Source.Addr().Unmap(), c.header.Source.Port()))
+}
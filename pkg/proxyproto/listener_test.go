@@ -0,0 +1,128 @@
+package proxyproto
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestListener(t *testing.T, trusted ...string) *Listener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	prefixes, err := ParseTrusted(trusted)
+	require.NoError(t, err)
+	l := NewListener(inner, prefixes)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func dialAndSend(t *testing.T, l net.Listener, data string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.Write([]byte(data))
+	require.NoError(t, err)
+	return conn
+}
+
+func accept(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		accepted <- result{conn, err}
+	}()
+	select {
+	case r := <-accepted:
+		require.NoError(t, r.err)
+		t.Cleanup(func() { r.conn.Close() })
+		return r.conn
+	case <-time.After(2 * time.Second):
+		t.Fatal("no connection accepted")
+		return nil
+	}
+}
+
+// TestListener_Trusted tests that connections from trusted proxies report the
+// client address of their header and keep the data that follows it.
+func TestListener_Trusted(t *testing.T) {
+	l := newTestListener(t, "127.0.0.0/8")
+	dialAndSend(t, l, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\nhello")
+
+	conn := accept(t, l)
+	assert.Equal(t, "203.0.113.7:51234", conn.RemoteAddr().String())
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// Health checks of the proxy keep its address
+	dialAndSend(t, l, string((&Header{Version: V2}).Format()))
+	conn = accept(t, l)
+	assert.Equal(t, "127.0.0.1", conn.RemoteAddr().(*net.TCPAddr).IP.String())
+}
+
+// TestListener_Untrusted tests that headers from untrusted peers are not
+// interpreted.
+func TestListener_Untrusted(t *testing.T) {
+	l := newTestListener(t, "10.0.0.0/8")
+	client := dialAndSend(t, l, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 80\r\n")
+
+	conn := accept(t, l)
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	buf := make([]byte, 6)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY ", string(buf))
+}
+
+// TestListener_MissingHeader tests that trusted peers without a header are
+// closed, without holding up other connections.
+func TestListener_MissingHeader(t *testing.T) {
+	l := newTestListener(t, "127.0.0.1")
+	invalid := dialAndSend(t, l, "GET / HTTP/1.1\r\n\r\n")
+	dialAndSend(t, l, "PROXY TCP6 2001:db8::7 2001:db8::1 51234 80\r\n")
+
+	conn := accept(t, l)
+	assert.Equal(t, "[2001:db8::7]:51234", conn.RemoteAddr().String())
+
+	_ = invalid.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err := invalid.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF, "closed by the listener")
+}
+
+// TestListener_Close tests that Accept reports a closed listener.
+func TestListener_Close(t *testing.T) {
+	l := newTestListener(t, "127.0.0.1")
+	require.NoError(t, l.Close())
+	_, err := l.Accept()
+	assert.True(t, errors.Is(err, net.ErrClosed))
+}
+
+// TestParseTrusted tests CIDRs and bare addresses of trusted proxies.
+func TestParseTrusted(t *testing.T) {
+	prefixes, err := ParseTrusted([]string{"10.1.2.3/8", " 192.0.2.10 ", "2001:db8::/32"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.10/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, prefixes)
+
+	_, err = ParseTrusted([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseTrusted([]string{"lb.internal"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
+	clienttunnel "github.com/pandeptwidyaop/grok/internal/client/tunnel"
+	grpcserver "github.com/pandeptwidyaop/grok/internal/server/grpc"
+)
+
+// TestClientSessionMultiplex tests a client session carrying several tunnels over one stream.
+func TestClientSessionMultiplex(t *testing.T) {
+	bufServer, _, tunnelManager, tokenService, _ := setupTestServer(t)
+	defer bufServer.Stop()
+
+	// The client dials TCP, so serve the same services on a real listener
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	grpcServer := grpc.NewServer(grpc.WaitForHandlers(true))
+	tunnelv1.RegisterTunnelServiceServer(grpcServer, grpcserver.NewTunnelService(tunnelManager, tokenService))
+	go func() {
+		_ = grpcServer.Serve(lis)
+	}()
+	defer grpcServer.Stop()
+
+	session := clienttunnel.NewSession(clienttunnel.ClientConfig{
+		ServerAddr: lis.Addr().String(),
+		AuthToken:  "test-token-12345",
+	})
+
+	web, err := session.AddTunnel(clienttunnel.ClientConfig{Name: "web", Protocol: "http", LocalAddr: "localhost:3000", Subdomain: "session-web"})
+	require.NoError(t, err)
+	api, err := session.AddTunnel(clienttunnel.ClientConfig{Name: "api", Protocol: "http", LocalAddr: "localhost:3001", Subdomain: "session-api"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Start(ctx)
+	}()
+
+	registered := func(subdomain string) bool {
+		_, ok := tunnelManager.GetTunnelBySubdomain(subdomain)
+		return ok
+	}
+
+	require.Eventually(t, func() bool {
+		return web.IsConnected() && api.IsConnected() && registered("session-web") && registered("session-api")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Both tunnels are bound to the same server stream
+	webTun, _ := tunnelManager.GetTunnelBySubdomain("session-web")
+	apiTun, _ := tunnelManager.GetTunnelBySubdomain("session-api")
+	assert.Same(t, webTun.Stream, apiTun.Stream)
+
+	// Add a tunnel while connected
+	admin, err := session.AddTunnel(clienttunnel.ClientConfig{Name: "admin", Protocol: "http", LocalAddr: "localhost:3002", Subdomain: "session-admin"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return registered("session-admin") }, 5*time.Second, 20*time.Millisecond)
+	assert.True(t, admin.IsConnected())
+
+	// Remove a tunnel without affecting the others
+	require.NoError(t, session.RemoveTunnel(web))
+	require.Eventually(t, func() bool { return !registered("session-web") }, 5*time.Second, 20*time.Millisecond)
+	assert.True(t, registered("session-api"))
+	assert.True(t, registered("session-admin"))
+	assert.Len(t, session.Tunnels(), 2)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("session did not stop")
+	}
+}
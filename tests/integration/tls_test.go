@@ -142,7 +142,7 @@ func TestAPIServerWithTLS(t *testing.T) {
 
 	// Create API server with TLS
 	apiMux := http.NewServeMux()
-	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, cfg)
+	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, nil, cfg)
 	apiHandler.RegisterRoutes(apiMux)
 
 	apiServer := &http.Server{
@@ -236,7 +236,7 @@ func TestAPIServerWithoutTLS(t *testing.T) {
 
 	// Create API server WITHOUT TLS
 	apiMux := http.NewServeMux()
-	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, cfg)
+	apiHandler := api.NewHandler(database, tokenService, tunnelManager, webhookRouter, nil, cfg)
 	apiHandler.RegisterRoutes(apiMux)
 
 	apiServer := &http.Server{
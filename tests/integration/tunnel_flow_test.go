@@ -2,6 +2,7 @@ package integration
 
 import (
 	"context"
+	"io"
 	"net"
 	"testing"
 	"time"
@@ -11,7 +12,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
@@ -19,6 +22,7 @@ import (
 	tunnelv1 "github.com/pandeptwidyaop/grok/gen/proto/tunnel/v1"
 	"github.com/pandeptwidyaop/grok/internal/db"
 	"github.com/pandeptwidyaop/grok/internal/db/models"
+	"github.com/pandeptwidyaop/grok/internal/protocol"
 	"github.com/pandeptwidyaop/grok/internal/server/auth"
 	grpcserver "github.com/pandeptwidyaop/grok/internal/server/grpc"
 	"github.com/pandeptwidyaop/grok/internal/server/tunnel"
@@ -65,8 +69,8 @@ func setupTestServer(t *testing.T) (*grpc.Server, *gorm.DB, *tunnel.Manager, *au
 	tokenService := auth.NewTokenService(database)
 	tunnelManager := tunnel.NewManager(database, "localhost", 10, true, 80, 443, 10000, 20000) // TLS enabled, standard ports
 
-	// Create gRPC server
-	grpcServer := grpc.NewServer()
+	// Create gRPC server; Stop waits for stream handlers so none outlive the test
+	grpcServer := grpc.NewServer(grpc.WaitForHandlers(true))
 	tunnelService := grpcserver.NewTunnelService(tunnelManager, tokenService)
 	tunnelv1.RegisterTunnelServiceServer(grpcServer, tunnelService)
 
@@ -83,6 +87,19 @@ func setupTestServer(t *testing.T) (*grpc.Server, *gorm.DB, *tunnel.Manager, *au
 	return grpcServer, database, tunnelManager, tokenService, lis
 }
 
+// closeStream closes a ProxyStream and waits until the server is done with it,
+// so none of its goroutines outlive the test.
+func closeStream(t *testing.T, stream tunnelv1.TunnelService_ProxyStreamClient) {
+	t.Helper()
+	require.NoError(t, stream.CloseSend())
+	for {
+		if _, err := stream.Recv(); err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			return
+		}
+	}
+}
+
 // TestCompleteTunnelFlow tests the complete tunnel creation and data persistence.
 func TestCompleteTunnelFlow(t *testing.T) {
 	grpcServer, database, tunnelManager, _, lis := setupTestServer(t)
@@ -177,6 +194,373 @@ func TestCompleteTunnelFlow(t *testing.T) {
 	assert.Equal(t, "offline", dbTunnel.Status, "Status should be updated to offline")
 }
 
+// TestTypedRegistrationFlow tests registration with the RegisterTunnel message.
+func TestTypedRegistrationFlow(t *testing.T) {
+	grpcServer, _, tunnelManager, _, lis := setupTestServer(t)
+	defer grpcServer.Stop()
+
+	ctx := context.Background()
+	//nolint:staticcheck // grpc.DialContext is deprecated but required for bufconn testing
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := tunnelv1.NewTunnelServiceClient(conn)
+
+	createResp, err := client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+		AuthToken:    "test-token-12345",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		LocalAddress: "localhost:8080",
+		Subdomain:    "typed",
+	})
+	require.NoError(t, err)
+
+	t.Run("registered", func(t *testing.T) {
+		stream, err := client.ProxyStream(ctx)
+		require.NoError(t, err)
+
+		err = stream.Send(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    "test-token-12345",
+					Protocol:     tunnelv1.TunnelProtocol_HTTP,
+					LocalAddress: "localhost:8080",
+					Subdomain:    createResp.Subdomain,
+					PublicUrl:    createResp.PublicUrl,
+					SavedName:    "name|with|pipes",
+					Labels:       map[string]string{"team": "web"},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		reply, err := stream.Recv()
+		require.NoError(t, err)
+		registered := reply.GetRegistered()
+		require.NotNil(t, registered, "expected Registered reply")
+		assert.NotEmpty(t, registered.TunnelId)
+		assert.Equal(t, createResp.PublicUrl, registered.PublicUrl)
+		assert.Equal(t, "name|with|pipes", registered.SavedName)
+
+		tun, exists := tunnelManager.GetTunnelBySubdomain(createResp.Subdomain)
+		require.True(t, exists)
+		assert.Equal(t, "web", tun.Labels["team"])
+
+		stream.CloseSend()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		stream, err := client.ProxyStream(ctx)
+		require.NoError(t, err)
+
+		err = stream.Send(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    "wrong-token",
+					LocalAddress: "localhost:8080",
+					Subdomain:    createResp.Subdomain,
+					PublicUrl:    createResp.PublicUrl,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		reply, err := stream.Recv()
+		require.NoError(t, err)
+		rejected := reply.GetRejected()
+		require.NotNil(t, rejected, "expected Rejected reply")
+		assert.Equal(t, tunnelv1.ErrorCode_UNAUTHORIZED, rejected.Code)
+
+		_, err = stream.Recv()
+		assert.Error(t, err, "server should close the stream after rejecting")
+	})
+
+	t.Run("webhook app of another organization", func(t *testing.T) {
+		stream, err := client.ProxyStream(ctx)
+		require.NoError(t, err)
+		defer closeStream(t, stream)
+
+		err = stream.Send(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    "test-token-12345",
+					Protocol:     tunnelv1.TunnelProtocol_HTTP,
+					LocalAddress: "localhost:8080",
+					Subdomain:    createResp.Subdomain,
+					PublicUrl:    createResp.PublicUrl,
+					WebhookAppId: uuid.New().String(),
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		reply, err := stream.Recv()
+		require.NoError(t, err)
+		rejected := reply.GetRejected()
+		require.NotNil(t, rejected, "expected Rejected reply")
+		assert.Equal(t, tunnelv1.ErrorCode_UNAUTHORIZED, rejected.Code)
+	})
+}
+
+// TestMultiplexedTunnels tests registering and removing several tunnels on one ProxyStream.
+func TestMultiplexedTunnels(t *testing.T) {
+	grpcServer, _, tunnelManager, _, lis := setupTestServer(t)
+	defer grpcServer.Stop()
+
+	ctx := context.Background()
+	//nolint:staticcheck // grpc.DialContext is deprecated but required for bufconn testing
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := tunnelv1.NewTunnelServiceClient(conn)
+
+	stream, err := client.ProxyStream(ctx)
+	require.NoError(t, err)
+	defer closeStream(t, stream)
+
+	register := func(ref, subdomain, token string) *tunnelv1.ProxyMessage {
+		createResp, err := client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+			AuthToken:    "test-token-12345",
+			Protocol:     tunnelv1.TunnelProtocol_HTTP,
+			LocalAddress: "localhost:8080",
+			Subdomain:    subdomain,
+		})
+		require.NoError(t, err)
+
+		err = stream.Send(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    token,
+					Protocol:     tunnelv1.TunnelProtocol_HTTP,
+					LocalAddress: "localhost:8080",
+					Subdomain:    createResp.Subdomain,
+					PublicUrl:    createResp.PublicUrl,
+					Ref:          ref,
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		reply, err := stream.Recv()
+		require.NoError(t, err)
+		return reply
+	}
+
+	// A rejected first registration leaves the stream open for the others
+	require.NoError(t, stream.Send(&tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Register{
+			Register: &tunnelv1.RegisterTunnel{AuthToken: "test-token-12345", Ref: "0"},
+		},
+	}))
+	invalid, err := stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, invalid.GetRejected(), "expected Rejected reply")
+	assert.Equal(t, tunnelv1.ErrorCode_INVALID_ARGUMENT, invalid.GetRejected().Code)
+
+	web := register("1", "mux-web", "test-token-12345").GetRegistered()
+	require.NotNil(t, web, "expected Registered reply")
+	assert.Equal(t, "1", web.Ref)
+
+	api := register("2", "mux-api", "test-token-12345").GetRegistered()
+	require.NotNil(t, api, "expected Registered reply")
+	assert.Equal(t, "2", api.Ref)
+	assert.NotEqual(t, web.TunnelId, api.TunnelId)
+
+	// A rejected registration must not close a stream that carries other tunnels
+	rejected := register("3", "mux-bad", "wrong-token").GetRejected()
+	require.NotNil(t, rejected, "expected Rejected reply")
+	assert.Equal(t, "3", rejected.Ref)
+
+	_, exists := tunnelManager.GetTunnelBySubdomain(web.Subdomain)
+	assert.True(t, exists)
+	_, exists = tunnelManager.GetTunnelBySubdomain(api.Subdomain)
+	assert.True(t, exists)
+
+	// Remove one tunnel without affecting the other
+	err = stream.Send(&tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Unregister{
+			Unregister: &tunnelv1.UnregisterTunnel{TunnelId: web.TunnelId},
+		},
+	})
+	require.NoError(t, err)
+
+	reply, err := stream.Recv()
+	require.NoError(t, err)
+	closed := reply.GetControl()
+	require.NotNil(t, closed, "expected TUNNEL_CLOSED acknowledgement")
+	assert.Equal(t, tunnelv1.ControlMessage_TUNNEL_CLOSED, closed.Type)
+	assert.Equal(t, web.TunnelId, closed.TunnelId)
+
+	_, exists = tunnelManager.GetTunnelBySubdomain(web.Subdomain)
+	assert.False(t, exists)
+	_, exists = tunnelManager.GetTunnelBySubdomain(api.Subdomain)
+	assert.True(t, exists)
+}
+
+// TestTunnelGroup tests two clients registering into one load-balanced subdomain.
+func TestTunnelGroup(t *testing.T) {
+	grpcServer, database, tunnelManager, _, lis := setupTestServer(t)
+	defer grpcServer.Stop()
+
+	ctx := context.Background()
+	//nolint:staticcheck // grpc.DialContext is deprecated but required for bufconn testing
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := tunnelv1.NewTunnelServiceClient(conn)
+	options := &tunnelv1.TunnelOptions{LoadBalancing: tunnelv1.LoadBalancing_ROUND_ROBIN}
+
+	// join creates and registers one group member on its own stream
+	join := func(localAddr string) (tunnelv1.TunnelService_ProxyStreamClient, *tunnelv1.Registered) {
+		createResp, err := client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+			AuthToken:    "test-token-12345",
+			Protocol:     tunnelv1.TunnelProtocol_HTTP,
+			LocalAddress: localAddr,
+			Subdomain:    "shop",
+			Options:      options,
+		})
+		require.NoError(t, err)
+
+		stream, err := client.ProxyStream(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&tunnelv1.ProxyMessage{
+			Message: &tunnelv1.ProxyMessage_Register{
+				Register: &tunnelv1.RegisterTunnel{
+					AuthToken:    "test-token-12345",
+					Protocol:     tunnelv1.TunnelProtocol_HTTP,
+					LocalAddress: localAddr,
+					Subdomain:    createResp.Subdomain,
+					PublicUrl:    createResp.PublicUrl,
+					SavedName:    "shop",
+					Options:      options,
+				},
+			},
+		}))
+
+		reply, err := stream.Recv()
+		require.NoError(t, err)
+		require.NotNil(t, reply.GetRegistered(), "expected Registered reply")
+		return stream, reply.GetRegistered()
+	}
+
+	first, firstReg := join("10.0.0.1:3000")
+	second, secondReg := join("10.0.0.2:3000")
+	defer closeStream(t, second)
+
+	assert.Equal(t, firstReg.Subdomain, secondReg.Subdomain)
+	assert.NotEqual(t, firstReg.TunnelId, secondReg.TunnelId)
+
+	group, ok := tunnelManager.GetGroup(firstReg.Subdomain)
+	require.True(t, ok)
+	assert.Len(t, group.Members(), 2)
+
+	// A client that did not opt in cannot take the subdomain
+	_, err = client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+		AuthToken:    "test-token-12345",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		LocalAddress: "10.0.0.3:3000",
+		Subdomain:    "shop",
+	})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// The first member disconnects; the second keeps serving the subdomain
+	closeStream(t, first)
+	assert.Eventually(t, func() bool {
+		return len(group.Members()) == 1
+	}, 2*time.Second, 20*time.Millisecond)
+
+	tun, ok := tunnelManager.GetTunnelBySubdomain(firstReg.Subdomain)
+	require.True(t, ok)
+	assert.Equal(t, secondReg.TunnelId, tun.ID.String())
+
+	var dbTunnel models.Tunnel
+	require.NoError(t, database.Where("id = ?", firstReg.TunnelId).First(&dbTunnel).Error)
+	assert.Equal(t, "offline", dbTunnel.Status, "the named tunnel is kept for reconnects")
+}
+
+// TestCapabilityNegotiation tests that incompatible clients are refused with a typed error.
+func TestCapabilityNegotiation(t *testing.T) {
+	grpcServer, _, _, _, lis := setupTestServer(t)
+	defer grpcServer.Stop()
+
+	ctx := context.Background()
+	//nolint:staticcheck // grpc.DialContext is deprecated but required for bufconn testing
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := tunnelv1.NewTunnelServiceClient(conn)
+
+	// Compatible client receives server capabilities
+	createResp, err := client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+		AuthToken:    "test-token-12345",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		LocalAddress: "localhost:8080",
+		Capabilities: protocol.Local(),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, createResp.Capabilities)
+	assert.Equal(t, uint32(protocol.Version), createResp.Capabilities.ProtocolVersion)
+	assert.Contains(t, createResp.Capabilities.Features, tunnelv1.Feature_FEATURE_STREAMING_BODY)
+
+	// Client requiring an unknown feature is refused
+	_, err = client.CreateTunnel(ctx, &tunnelv1.CreateTunnelRequest{
+		AuthToken:    "test-token-12345",
+		Protocol:     tunnelv1.TunnelProtocol_HTTP,
+		LocalAddress: "localhost:8080",
+		Capabilities: &tunnelv1.Capabilities{
+			ProtocolVersion: protocol.Version,
+			Required:        []tunnelv1.Feature{tunnelv1.Feature(999)},
+		},
+	})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Same check on ProxyStream registration yields a Rejected reply
+	stream, err := client.ProxyStream(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&tunnelv1.ProxyMessage{
+		Message: &tunnelv1.ProxyMessage_Register{
+			Register: &tunnelv1.RegisterTunnel{
+				AuthToken:    "test-token-12345",
+				LocalAddress: "localhost:8080",
+				Subdomain:    createResp.Subdomain,
+				PublicUrl:    createResp.PublicUrl,
+				Capabilities: &tunnelv1.Capabilities{ProtocolVersion: 0},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	reply, err := stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, reply.GetRejected())
+	assert.Equal(t, tunnelv1.ErrorCode_INCOMPATIBLE_CLIENT, reply.GetRejected().Code)
+}
+
 // TestSubdomainAllocation tests subdomain allocation and validation.
 func TestSubdomainAllocation(t *testing.T) {
 	grpcServer, database, tunnelManager, _, _ := setupTestServer(t)